@@ -0,0 +1,156 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/epik-protocol/epik-gateway-backend/clog"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+)
+
+var errReadOnlyRestore = errors.New("cannot restore a backup into a read-only database")
+
+func backupStore(h *graph.Handle) (graph.BackupStore, error) {
+	bs, ok := h.QuadStore.(graph.BackupStore)
+	if !ok {
+		return nil, fmt.Errorf("database backend %q does not support backups", viper.GetString(KeyBackend))
+	}
+	return bs, nil
+}
+
+func printBackupInfo(info *graph.BackupInfo) {
+	if info.Incremental() {
+		fmt.Printf("incremental backup of epochs %d to %d\n", info.From+1, info.Epoch)
+	} else {
+		fmt.Printf("full backup at epoch %d\n", info.Epoch)
+	}
+}
+
+func NewBackupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Write a full or incremental backup of the database.",
+		Long: "Write a consistent backup of the database, including cid mappings and epoch metadata. " +
+			"If --from is set, the backup only contains deltas of the following epochs.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			printBackendInfo()
+			out, _ := cmd.Flags().GetString("out")
+			if out == "" && len(args) == 1 {
+				out = args[0]
+			}
+			if out == "" {
+				return errors.New("backup file must be specified")
+			}
+			from, _ := cmd.Flags().GetInt64("from")
+			to, _ := cmd.Flags().GetInt64("to")
+
+			h, err := openQuadStore()
+			if err != nil {
+				return err
+			}
+			defer h.Close()
+			bs, err := backupStore(h)
+			if err != nil {
+				return err
+			}
+
+			var w io.Writer = os.Stdout
+			if out != "-" {
+				f, err := os.Create(out)
+				if err != nil {
+					return fmt.Errorf("could not create file %q: %v", out, err)
+				}
+				defer f.Close()
+				w = f
+			}
+			info, err := bs.Backup(context.Background(), w, graph.BackupOptions{From: from, To: to})
+			if err != nil {
+				if out != "-" {
+					os.Remove(out)
+				}
+				return err
+			}
+			if out != "-" {
+				printBackupInfo(info)
+				fmt.Printf("backup was written to %q\n", out)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringP("out", "o", "", `backup file to write ("-" for stdout)`)
+	cmd.Flags().Int64("from", 0, "last epoch of the previous backup; only later epochs are included into an incremental backup")
+	cmd.Flags().Int64("to", 0, "last epoch to include into an incremental backup (defaults to the last synced epoch)")
+	return cmd
+}
+
+func NewRestoreCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore a backup written by the backup command.",
+		Long: "Restore a backup written by the backup command. A full backup is restored into an empty database, " +
+			"and incremental backups are applied in order on top of it. The checksum is verified before any data is written.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			printBackendInfo()
+			in, _ := cmd.Flags().GetString("in")
+			if in == "" && len(args) == 1 {
+				in = args[0]
+			}
+			if in == "" {
+				return errors.New("backup file must be specified")
+			} else if viper.GetBool(KeyReadOnly) {
+				return errReadOnlyRestore
+			}
+			f, err := os.Open(in)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			info, err := graph.VerifyBackup(f)
+			if err != nil {
+				return fmt.Errorf("cannot verify backup %q: %v", in, err)
+			}
+			printBackupInfo(info)
+			if check, _ := cmd.Flags().GetBool("verify"); check {
+				fmt.Println("checksum is valid")
+				return nil
+			}
+			if _, err = f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+
+			if init, err := cmd.Flags().GetBool("init"); err != nil {
+				return err
+			} else if init {
+				if err = initDatabase(); err == graph.ErrDatabaseExists {
+					clog.Infof("database already initialized, skipping init")
+				} else if err != nil {
+					return err
+				}
+			}
+			h, err := openQuadStore()
+			if err != nil {
+				return err
+			}
+			defer h.Close()
+			bs, err := backupStore(h)
+			if err != nil {
+				return err
+			}
+			if _, err = bs.Restore(context.Background(), f); err != nil {
+				return err
+			}
+			fmt.Printf("restored to epoch %d\n", info.Epoch)
+			return nil
+		},
+	}
+	cmd.Flags().StringP("in", "i", "", "backup file to restore")
+	cmd.Flags().Bool("init", false, "initialize the database before restoring a full backup")
+	cmd.Flags().Bool("verify", false, "only verify the checksum of the backup")
+	return cmd
+}
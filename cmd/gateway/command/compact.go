@@ -0,0 +1,56 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+)
+
+func NewCompactCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "compact",
+		Short: "Remove deleted quads and nodes from the database.",
+		Long: "Remove quads and nodes deleted before a given epoch, together with index entries, node reference counters " +
+			"and cid references pointing to them, and report how much space was reclaimed. " +
+			"By default, the history is kept for all epochs that can still be reverted.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			printBackendInfo()
+			before, _ := cmd.Flags().GetInt64("before")
+			dry, _ := cmd.Flags().GetBool("dry-run")
+			if !dry && viper.GetBool(KeyReadOnly) {
+				return errors.New("cannot compact a read-only database")
+			}
+			h, err := openQuadStore()
+			if err != nil {
+				return err
+			}
+			defer h.Close()
+			c, ok := h.QuadStore.(graph.Compactor)
+			if !ok {
+				return fmt.Errorf("database backend %q does not support compaction", viper.GetString(KeyBackend))
+			}
+			start := time.Now()
+			st, err := c.Compact(context.Background(), graph.CompactOptions{Before: before, DryRun: dry})
+			if err != nil {
+				return err
+			}
+			verb, reclaim := "removed", "reclaimed"
+			if dry {
+				verb, reclaim = "would remove", "would reclaim"
+			}
+			fmt.Printf("%s %d quads and %d nodes deleted before epoch %d\n", verb, st.Quads, st.Nodes, st.Before)
+			fmt.Printf("%s %d index entries, %d node reference counters and %d cid references\n", verb, st.Indexes, st.Refs, st.Cids)
+			fmt.Printf("%s %d bytes in %v\n", reclaim, st.Bytes, time.Since(start))
+			return nil
+		},
+	}
+	cmd.Flags().Int64("before", 0, "remove quads and nodes deleted before this epoch (defaults to the oldest epoch that can be reverted)")
+	cmd.Flags().Bool("dry-run", false, "only report what would be removed")
+	return cmd
+}
@@ -92,6 +92,7 @@ func NewConvertCmd() *cobra.Command {
 				return errors.New("both input and output files must be specified")
 			}
 			loadf, _ := cmd.Flags().GetString(flagLoadFormat)
+			ropts := loadReadOptions(cmd)
 			var multi multiReader
 			for _, path := range files {
 				path := path
@@ -101,7 +102,7 @@ func NewConvertCmd() *cobra.Command {
 					} else {
 						fmt.Printf("reading %q\n", path)
 					}
-					return internal.QuadReaderFor(path, loadf)
+					return internal.QuadReaderFor(path, loadf, ropts)
 				}))
 			}
 			// TODO: print additional stats
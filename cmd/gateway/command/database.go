@@ -1,6 +1,7 @@
 package command
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -17,6 +18,7 @@ import (
 	"github.com/epik-protocol/epik-gateway-backend/clog"
 	"github.com/epik-protocol/epik-gateway-backend/graph"
 	"github.com/epik-protocol/epik-gateway-backend/internal"
+	"github.com/epik-protocol/epik-gateway-backend/internal/epikquad"
 )
 
 const (
@@ -28,18 +30,30 @@ const (
 
 	KeyLoadBatch = "load.batch"
 
-	KeyDataSource = "datasource.name"
+	KeyDataSource        = "datasource.name"
+	KeyDataSourceOptions = "datasource.options"
+	KeyDataSourceSync    = "datasource.sync"
+
+	KeyFeedSize = "feed.size"
 )
 
+// defaultFeedSize is the number of the latest applied deltas kept for subscribers by default.
+const defaultFeedSize = 10000
+
 const (
-	flagLoad       = "load"
-	flagLoadFormat = "load_format"
-	flagDump       = "dump"
-	flagDumpFormat = "dump_format"
+	flagLoad        = "load"
+	flagLoadFormat  = "load_format"
+	flagLoadStrict  = "load_strict"
+	flagLoadBulk    = "bulk"
+	flagLoadWorkers = "workers"
+	flagDump        = "dump"
+	flagDumpFormat  = "dump_format"
 )
 
 var ErrNotPersistent = errors.New("database type is not persistent")
 
+var errReadOnlySync = errors.New("cannot sync a read-only database, either read_only or sync must be disabled")
+
 func registerLoadFlags(cmd *cobra.Command) {
 	// TODO: allow to load multiple files
 	cmd.Flags().StringP(flagLoad, "i", "", `quad file to load after initialization (".gz" supported, "-" for stdin)`)
@@ -51,6 +65,13 @@ func registerLoadFlags(cmd *cobra.Command) {
 	}
 	sort.Strings(names)
 	cmd.Flags().String(flagLoadFormat, "", `quad file format to use for loading instead of auto-detection ("`+strings.Join(names, `", "`)+`")`)
+	cmd.Flags().Bool(flagLoadStrict, false, `fail on malformed lines instead of skipping them ("`+epikquad.Name+`" format only)`)
+}
+
+// loadReadOptions returns options of quad readers set by load flags.
+func loadReadOptions(cmd *cobra.Command) internal.ReadOptions {
+	strict, _ := cmd.Flags().GetBool(flagLoadStrict)
+	return internal.ReadOptions{Strict: strict}
 }
 
 func registerDumpFlags(cmd *cobra.Command) {
@@ -107,22 +128,29 @@ func NewLoadDatabaseCmd() *cobra.Command {
 					return err
 				}
 			}
-			h, err := openDatabase()
+			h, err := openQuadStore()
 			if err != nil {
 				return err
 			}
 			defer h.Close()
 
-			qw, err := h.NewQuadWriter()
-			if err != nil {
-				return err
-			}
-			defer qw.Close()
-
 			// TODO: check read-only flag in config before that?
 			typ, _ := cmd.Flags().GetString(flagLoadFormat)
-			if err = internal.Load(qw, quad.DefaultBatch, load, typ); err != nil {
-				return err
+			ropts := loadReadOptions(cmd)
+			if bulk, _ := cmd.Flags().GetBool(flagLoadBulk); bulk {
+				workers, _ := cmd.Flags().GetInt(flagLoadWorkers)
+				if err = loadBulk(h, workers, load, typ, ropts); err != nil {
+					return err
+				}
+			} else {
+				qw, err := h.NewQuadWriter()
+				if err != nil {
+					return err
+				}
+				defer qw.Close()
+				if err = internal.Load(qw, quad.DefaultBatch, load, typ, ropts); err != nil {
+					return err
+				}
 			}
 
 			if dump, _ := cmd.Flags().GetString(flagDump); dump != "" {
@@ -135,11 +163,35 @@ func NewLoadDatabaseCmd() *cobra.Command {
 		},
 	}
 	cmd.Flags().Bool("init", false, "initialize the database before using it")
+	cmd.Flags().Bool(flagLoadBulk, false, "hash values, resolve nodes and generate index keys in parallel stages, and write sorted runs")
+	cmd.Flags().Int(flagLoadWorkers, 0, "number of workers for parallel stages of a bulk load (defaults to the number of CPUs)")
 	registerLoadFlags(cmd)
 	registerDumpFlags(cmd)
 	return cmd
 }
 
+// bulkReportInterval is the minimal interval between progress reports of a bulk load.
+const bulkReportInterval = 10 * time.Second
+
+// loadBulk loads a quad file with a bulk writer and reports the throughput.
+func loadBulk(h *graph.Handle, workers int, path, typ string, ropts internal.ReadOptions) error {
+	if _, ok := h.QuadStore.(graph.BulkLoader); !ok {
+		clog.Warningf("database backend %q does not support bulk loading, quads are written in batches", viper.GetString(KeyBackend))
+	}
+	var last time.Duration
+	st, err := internal.LoadBulk(h.QuadStore, graph.BulkOptions{Workers: workers}, viper.GetInt(KeyLoadBatch), path, typ, ropts, func(st graph.BulkStats) {
+		if st.Duration-last >= bulkReportInterval {
+			last = st.Duration
+			clog.Infof("loaded %d quads (%.0f quads/s)", st.Quads, st.Rate())
+		}
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("loaded %d quads in %v (%.0f quads/s)\n", st.Quads, st.Duration.Round(time.Millisecond), st.Rate())
+	return nil
+}
+
 func NewDumpDatabaseCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "dump",
@@ -153,7 +205,7 @@ func NewDumpDatabaseCmd() *cobra.Command {
 			if dump == "" {
 				dump = "-"
 			}
-			h, err := openDatabase()
+			h, err := openQuadStore()
 			if err != nil {
 				return err
 			}
@@ -202,11 +254,67 @@ func initDatabase() error {
 	return graph.InitQuadStore(name, path, graph.Options(opts))
 }
 
-func openDatabase() (*graph.Handle, error) {
+// openDatabase opens the database and starts the listener if the sync is enabled.
+// If lead is set, the sync role is taken before returning, and the database is not opened
+// if another process syncs it already. Otherwise the listener stays on standby in this case.
+func openDatabase(lead bool) (*graph.Handle, error) {
+	h, err := openQuadStore()
+	if err != nil {
+		return nil, err
+	}
+	if src := viper.GetString(KeyDataSource); len(src) > 0 && viper.GetBool(KeyDataSourceSync) {
+		if viper.GetBool(KeyReadOnly) {
+			h.Close()
+			return nil, errReadOnlySync
+		}
+		listener, err := newListener(h.QuadStore)
+		if err != nil {
+			h.Close()
+			return nil, fmt.Errorf("failed to start listener %s: %v", src, err)
+		}
+		if l, ok := listener.(graph.Leader); ok && lead {
+			if err = l.Lead(context.Background()); err != nil {
+				listener.Stop()
+				h.Close()
+				return nil, fmt.Errorf("failed to take the sync role: %v", err)
+			}
+		}
+		h.Listener = listener
+	}
+	size := defaultFeedSize
+	if viper.IsSet(KeyFeedSize) {
+		size = viper.GetInt(KeyFeedSize)
+	}
+	if size > 0 {
+		// the feed is kept in the store if possible, so subscribers can resume after a restart
+		feed := graph.NewFeed(size)
+		if ms, ok := h.QuadStore.(graph.MetaStore); ok && !viper.GetBool(KeyReadOnly) {
+			if feed, err = graph.OpenFeed(context.Background(), ms, size); err != nil {
+				h.Close()
+				return nil, fmt.Errorf("failed to open the feed: %v", err)
+			}
+		}
+		h.SetFeed(feed)
+	}
+	if h.Listener != nil {
+		h.Start()
+	}
+	return h, nil
+}
+
+// openQuadStore opens the database without starting the listener, for commands that run once.
+func openQuadStore() (*graph.Handle, error) {
 	name := viper.GetString(KeyBackend)
 	path := viper.GetString(KeyAddress)
-	src := viper.GetString(KeyDataSource)
 	opts := graph.Options(viper.GetStringMap(KeyOptions))
+	if viper.GetBool(KeyReadOnly) {
+		ropts := make(graph.Options, len(opts)+1)
+		for k, v := range opts {
+			ropts[k] = v
+		}
+		ropts[graph.OptionReadOnly] = true
+		opts = ropts
+	}
 	qs, err := graph.NewQuadStore(name, path, opts)
 	if err != nil {
 		return nil, err
@@ -215,18 +323,23 @@ func openDatabase() (*graph.Handle, error) {
 	if err != nil {
 		return nil, err
 	}
-	h := &graph.Handle{QuadStore: qs, QuadWriter: qw}
-
-	if len(src) > 0 {
-		listener, err := graph.NewListener(src, qs)
-		if err == nil {
-			h.Listener = listener
-			h.Start()
-		} else {
-			clog.Warningf("failed to start listener: %s, error: %v", src, err)
+	return &graph.Handle{QuadStore: qs, QuadWriter: qw}, nil
+}
+
+// newListener creates a listener of the configured data source for the store.
+func newListener(qs graph.QuadStore) (graph.Listener, error) {
+	src := viper.GetString(KeyDataSource)
+	lopts := make(graph.Options)
+	for k, v := range viper.GetStringMap(KeyDataSourceOptions) {
+		lopts[k] = v
+	}
+	// listeners keep their files next to a local database
+	if addr := viper.GetString(KeyAddress); addr != "" {
+		if _, err := os.Stat(addr); err == nil {
+			lopts[graph.OptListenerDatabase] = addr
 		}
 	}
-	return h, nil
+	return graph.NewListener(src, qs, lopts)
 }
 
 func openForQueries(cmd *cobra.Command) (*graph.Handle, error) {
@@ -239,12 +352,14 @@ func openForQueries(cmd *cobra.Command) (*graph.Handle, error) {
 			return nil, err
 		}
 	}
+	// the sync role is required if the sync was requested with the flag, rather than a shared config
+	lead := cmd.Flags().Changed("sync")
 	var load string
-	h, err := openDatabase()
+	h, err := openDatabase(lead)
 	if err == graph.ErrQuadStoreNotPersistent {
 		load = viper.GetString(KeyAddress)
 		viper.Set(KeyAddress, "")
-		h, err = openDatabase()
+		h, err = openDatabase(lead)
 	}
 	if err == graph.ErrQuadStoreNotPersistent {
 		return nil, fmt.Errorf("%v; did you mean -i flag?", err)
@@ -270,7 +385,7 @@ func openForQueries(cmd *cobra.Command) (*graph.Handle, error) {
 		typ, _ := cmd.Flags().GetString(flagLoadFormat)
 		// TODO: check read-only flag in config before that?
 		start := time.Now()
-		if err = internal.Load(qw, quad.DefaultBatch, load, typ); err != nil {
+		if err = internal.Load(qw, quad.DefaultBatch, load, typ, loadReadOptions(cmd)); err != nil {
 			h.Close()
 			return nil, err
 		}
@@ -0,0 +1,58 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+)
+
+func NewFsckCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fsck",
+		Short: "Check the consistency of the database.",
+		Long: "Cross-check indexes, node reference counters, cid references and bloom filters with quads and nodes " +
+			"stored in the database, and print a report as JSON. With --repair, derived indexes are rebuilt. " +
+			"The command fails if any problem was not repaired.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			printBackendInfo()
+			repair, _ := cmd.Flags().GetBool("repair")
+			if repair && viper.GetBool(KeyReadOnly) {
+				return errors.New("cannot repair a read-only database")
+			}
+			h, err := openQuadStore()
+			if err != nil {
+				return err
+			}
+			defer h.Close()
+			c, ok := h.QuadStore.(graph.Checker)
+			if !ok {
+				return fmt.Errorf("database backend %q does not support consistency checks", viper.GetString(KeyBackend))
+			}
+			rep, err := c.Check(context.Background(), graph.CheckOptions{Repair: repair})
+			if err != nil {
+				return err
+			}
+			if rep.Problems == nil {
+				rep.Problems = []graph.Inconsistency{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err = enc.Encode(rep); err != nil {
+				return err
+			}
+			if n := rep.Unrepaired(); n != 0 {
+				return fmt.Errorf("found %d problems", n)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().Bool("repair", false, "rebuild derived indexes that do not match the stored quads")
+	return cmd
+}
@@ -0,0 +1,188 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+)
+
+// parseIndexDirs parses directions of an index written as prefixes of directions, e.g. "po" or "ops".
+func parseIndexDirs(s string) ([]quad.Direction, error) {
+	dirs := make([]quad.Direction, 0, len(s))
+	for _, c := range strings.ToLower(s) {
+		var d quad.Direction
+		switch c {
+		case 's':
+			d = quad.Subject
+		case 'p':
+			d = quad.Predicate
+		case 'o':
+			d = quad.Object
+		case 'l':
+			d = quad.Label
+		default:
+			return nil, fmt.Errorf("invalid index direction %q, expected one of s, p, o or l", c)
+		}
+		dirs = append(dirs, d)
+	}
+	if len(dirs) == 0 {
+		return nil, errors.New("index directions must be set")
+	}
+	return dirs, nil
+}
+
+func formatIndexDirs(dirs []quad.Direction) string {
+	buf := make([]byte, len(dirs))
+	for i, d := range dirs {
+		buf[i] = d.Prefix()
+	}
+	return string(buf)
+}
+
+// openIndexManager opens the database and checks that it supports index management.
+func openIndexManager() (*graph.Handle, graph.IndexManager, error) {
+	h, err := openQuadStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	m, ok := h.QuadStore.(graph.IndexManager)
+	if !ok {
+		h.Close()
+		return nil, nil, fmt.Errorf("database backend %q does not support index management", viper.GetString(KeyBackend))
+	}
+	return h, m, nil
+}
+
+func NewIndexCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "index",
+		Short: "Manage quad indexes of the database.",
+		Long: "Manage quad indexes of the database. Indexes are written as prefixes of quad directions they are keyed by, " +
+			"e.g. \"po\" for an index of quads by predicate and object.",
+	}
+	root.AddCommand(
+		NewIndexListCmd(),
+		NewIndexAddCmd(),
+		NewIndexDropCmd(),
+	)
+	return root
+}
+
+func NewIndexListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List quad indexes and the progress of building them.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			printBackendInfo()
+			h, m, err := openIndexManager()
+			if err != nil {
+				return err
+			}
+			defer h.Close()
+			list, err := m.Indexes(context.Background())
+			if err != nil {
+				return err
+			}
+			for _, ind := range list {
+				state := "complete"
+				if !ind.Complete {
+					state = fmt.Sprintf("building %.1f%%", ind.Progress*100)
+				}
+				if ind.Unique {
+					state += ", unique"
+				}
+				fmt.Printf("%s\t%s\n", formatIndexDirs(ind.Dirs), state)
+			}
+			return nil
+		},
+	}
+}
+
+func NewIndexAddCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "add <dirs>",
+		Short: "Add a quad index and build it over existing quads.",
+		Long: "Add a quad index and build it over existing quads. The database can still be written while the index is built, " +
+			"and queries start using the index once it is complete. An interrupted build is resumed by adding the same index again.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dirs, err := parseIndexDirs(args[0])
+			if err != nil {
+				return err
+			}
+			if viper.GetBool(KeyReadOnly) {
+				return errors.New("cannot add an index to a read-only database")
+			}
+			printBackendInfo()
+			h, m, err := openIndexManager()
+			if err != nil {
+				return err
+			}
+			defer h.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt)
+			defer signal.Stop(sig)
+			go func() {
+				select {
+				case <-sig:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			err = m.AddIndex(ctx, dirs, func(ind graph.Index) {
+				if !ind.Complete {
+					fmt.Printf("built %.1f%% of index %s\n", ind.Progress*100, args[0])
+				}
+			})
+			if err == context.Canceled {
+				return fmt.Errorf("interrupted, run the command again to resume building index %s", args[0])
+			} else if err != nil {
+				return err
+			}
+			fmt.Printf("index %s is complete\n", args[0])
+			return nil
+		},
+	}
+}
+
+func NewIndexDropCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "drop <dirs>",
+		Short: "Drop a quad index and remove its entries.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dirs, err := parseIndexDirs(args[0])
+			if err != nil {
+				return err
+			}
+			if viper.GetBool(KeyReadOnly) {
+				return errors.New("cannot drop an index of a read-only database")
+			}
+			printBackendInfo()
+			h, m, err := openIndexManager()
+			if err != nil {
+				return err
+			}
+			defer h.Close()
+			if err = m.DropIndex(context.Background(), dirs); err != nil {
+				return err
+			}
+			fmt.Printf("index %s was dropped\n", args[0])
+			return nil
+		},
+	}
+}
@@ -0,0 +1,172 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/clog"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+)
+
+// migrateState is stored to the checkpoint file to resume an interrupted migration.
+type migrateState struct {
+	FromDB     string                  `json:"from_db"`
+	FromDBPath string                  `json:"from_dbpath"`
+	ToDB       string                  `json:"to_db"`
+	ToDBPath   string                  `json:"to_dbpath"`
+	Checkpoint graph.MigrateCheckpoint `json:"checkpoint"`
+}
+
+func (s *migrateState) sameMigration(s2 *migrateState) bool {
+	return s.FromDB == s2.FromDB && s.FromDBPath == s2.FromDBPath && s.ToDB == s2.ToDB && s.ToDBPath == s2.ToDBPath
+}
+
+// readMigrateState reads the checkpoint file, or returns nil if it does not exist.
+func readMigrateState(path string) (*migrateState, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var s migrateState
+	if err = json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("cannot read checkpoint %q: %v", path, err)
+	}
+	return &s, nil
+}
+
+func writeMigrateState(path string, s *migrateState) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first, so an interruption does not leave a partial checkpoint
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func NewMigrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Copy the database into another backend.",
+		Long: "Copy all quads, cid references, chain keys and the synced epoch of the database into another backend. " +
+			"An interrupted migration is resumed from the checkpoint file, and node and quad counts are verified at the end.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			printBackendInfo()
+			toDB, _ := cmd.Flags().GetString("to-db")
+			toPath, _ := cmd.Flags().GetString("to-dbpath")
+			if toDB == "" {
+				return errors.New("destination database backend must be set with --to-db")
+			} else if !graph.IsPersistent(toDB) {
+				return fmt.Errorf("cannot migrate into %q: %v", toDB, ErrNotPersistent)
+			}
+			toOpts := graph.Options(viper.GetStringMap(KeyOptions))
+			if s, _ := cmd.Flags().GetString("to-options"); s != "" {
+				toOpts = make(graph.Options)
+				if err := json.Unmarshal([]byte(s), &toOpts); err != nil {
+					return fmt.Errorf("cannot parse destination options: %v", err)
+				}
+			}
+			batch, _ := cmd.Flags().GetInt("batch")
+			cpath, _ := cmd.Flags().GetString("checkpoint")
+
+			state := &migrateState{
+				FromDB: viper.GetString(KeyBackend), FromDBPath: viper.GetString(KeyAddress),
+				ToDB: toDB, ToDBPath: toPath,
+			}
+			if state.FromDB == toDB && state.FromDBPath == toPath {
+				return errors.New("source and destination databases must be different")
+			}
+			prev, err := readMigrateState(cpath)
+			if err != nil {
+				return err
+			} else if prev != nil && !prev.sameMigration(state) {
+				return fmt.Errorf("checkpoint %q belongs to a migration from %s (%s) to %s (%s)",
+					cpath, prev.FromDB, prev.FromDBPath, prev.ToDB, prev.ToDBPath)
+			}
+
+			h, err := openQuadStore()
+			if err != nil {
+				return err
+			}
+			defer h.Close()
+			if prev == nil {
+				if err = graph.InitQuadStore(toDB, toPath, toOpts); err == graph.ErrDatabaseExists {
+					clog.Infof("destination database already initialized, skipping init")
+				} else if err != nil {
+					return err
+				}
+			}
+			dst, err := graph.NewQuadStore(toDB, toPath, toOpts)
+			if err != nil {
+				return err
+			}
+			defer dst.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt)
+			defer signal.Stop(sig)
+			go func() {
+				select {
+				case <-sig:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			opts := graph.MigrateOptions{
+				Batch: batch,
+				Checkpoint: func(cp graph.MigrateCheckpoint) error {
+					state.Checkpoint = cp
+					if err := writeMigrateState(cpath, state); err != nil {
+						return err
+					}
+					fmt.Printf("copied %d quads and %d cid references\n", cp.Quads, cp.Cids)
+					// stop after the checkpoint is written, so the migration can be resumed
+					return ctx.Err()
+				},
+			}
+			if prev != nil {
+				fmt.Printf("resuming migration after %d quads and %d cid references\n", prev.Checkpoint.Quads, prev.Checkpoint.Cids)
+				opts.Resume = &prev.Checkpoint
+			}
+			start := time.Now()
+			if err = graph.Migrate(ctx, h.QuadStore, dst, opts); err == context.Canceled {
+				return fmt.Errorf("migration interrupted, run the command again to resume it")
+			} else if err != nil {
+				return err
+			}
+			if err = os.Remove(cpath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			st, err := dst.Stats(ctx, false)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("migrated %d quads at epoch %d to %s in %v\n", st.Quads.Value, st.Epoch, toDB, time.Since(start))
+			return nil
+		},
+	}
+	cmd.Flags().String("to-db", "", "database backend to migrate to")
+	cmd.Flags().String("to-dbpath", "", "path or address string of the database to migrate to")
+	cmd.Flags().String("to-options", "", "options of the database to migrate to as a JSON object (defaults to the store options)")
+	cmd.Flags().Int("batch", quad.DefaultBatch, "number of quads written at once")
+	cmd.Flags().String("checkpoint", "migrate.checkpoint", "file to record the progress of the migration to")
+	return cmd
+}
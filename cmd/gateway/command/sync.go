@@ -0,0 +1,103 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+)
+
+const KeyDataSourceFetchers = "datasource.fetchers"
+
+func NewSyncCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Sync a range of chain epochs in the foreground.",
+		Long: "Sync a range of chain epochs in the foreground. " +
+			"Applied epochs in the range are reverted and applied again.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			printBackendInfo()
+			if viper.GetString(KeyDataSource) == "" {
+				return errors.New("data source is not configured")
+			} else if viper.GetBool(KeyReadOnly) {
+				return errReadOnlySync
+			}
+			from, _ := cmd.Flags().GetInt64("from")
+			to, _ := cmd.Flags().GetInt64("to")
+			dryRun, _ := cmd.Flags().GetBool("dry_run")
+			if n, _ := cmd.Flags().GetInt("fetchers"); n > 0 {
+				viper.Set(KeyDataSourceFetchers, n)
+			}
+
+			h, err := openQuadStore()
+			if err != nil {
+				return err
+			}
+			defer h.Close()
+			l, err := newListener(h.QuadStore)
+			if err != nil {
+				return err
+			}
+			defer l.Stop()
+			rs, ok := l.(graph.RangeSyncer)
+			if !ok {
+				return fmt.Errorf("data source %q does not support syncing a range of epochs", viper.GetString(KeyDataSource))
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt)
+			defer signal.Stop(sig)
+			go func() {
+				select {
+				case <-sig:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			start := time.Now()
+			files := 0
+			err = rs.SyncRange(ctx, from, to, graph.SyncRangeOptions{
+				DryRun: dryRun,
+				Progress: func(p graph.SyncProgress) {
+					files += len(p.Roots)
+					if dryRun {
+						for _, root := range p.Roots {
+							fmt.Printf("%d\t%s\n", p.Epoch, root)
+						}
+						return
+					}
+					msg := ""
+					if len(p.Roots) != 0 {
+						msg = ": " + strings.Join(p.Roots, ", ")
+					}
+					fmt.Printf("epoch %d (%d/%d)%s\n", p.Epoch, p.Done, p.Total, msg)
+				},
+			})
+			if err != nil {
+				return err
+			}
+			if dryRun {
+				fmt.Printf("%d files would be imported\n", files)
+			} else {
+				fmt.Printf("imported %d files in %v\n", files, time.Since(start))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().Int64("from", 0, "first epoch to sync (defaults to the epoch after the last synced one)")
+	cmd.Flags().Int64("to", 0, "last epoch to sync (defaults to the chain head)")
+	cmd.Flags().Bool("dry_run", false, "only list root cids of files that would be imported")
+	cmd.Flags().Int("fetchers", 0, "number of tipsets fetched in parallel")
+	return cmd
+}
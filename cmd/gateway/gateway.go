@@ -47,6 +47,7 @@ import (
 	_ "github.com/cayleygraph/quad/jsonld"
 	_ "github.com/cayleygraph/quad/nquads"
 	_ "github.com/cayleygraph/quad/pquads"
+	_ "github.com/epik-protocol/epik-gateway-backend/internal/epikquad"
 
 	// Load writer registry
 	_ "github.com/epik-protocol/epik-gateway-backend/writer"
@@ -56,6 +57,7 @@ import (
 	_ "github.com/epik-protocol/epik-gateway-backend/query/graphql"
 	_ "github.com/epik-protocol/epik-gateway-backend/query/mql"
 	_ "github.com/epik-protocol/epik-gateway-backend/query/sexp"
+	_ "github.com/epik-protocol/epik-gateway-backend/query/sparql"
 
 	// Register listener
 	_ "github.com/epik-protocol/epik-gateway-backend/graph/listener/epik"
@@ -147,6 +149,13 @@ func init() {
 		command.NewDedupCommand(),
 		command.NewHealthCmd(),
 		command.NewSchemaCommand(),
+		command.NewSyncCmd(),
+		command.NewBackupCmd(),
+		command.NewRestoreCmd(),
+		command.NewMigrateCmd(),
+		command.NewCompactCmd(),
+		command.NewFsckCmd(),
+		command.NewIndexCmd(),
 	)
 	rootCmd.PersistentFlags().StringP("config", "c", "", "path to an explicit configuration file")
 
@@ -154,6 +163,7 @@ func init() {
 	rootCmd.PersistentFlags().StringP("db", "d", "memstore", "database backend to use: "+strings.Join(qnames, ", "))
 	rootCmd.PersistentFlags().StringP("dbpath", "a", "", "path or address string for database")
 	rootCmd.PersistentFlags().Bool("read_only", false, "open database in read-only mode")
+	rootCmd.PersistentFlags().Bool("sync", false, "start the listener to sync the database with the data source")
 
 	rootCmd.PersistentFlags().Bool("dup", true, "don't stop loading on duplicated on add")
 	rootCmd.PersistentFlags().Bool("missing", false, "don't stop loading on missing key on delete")
@@ -169,6 +179,7 @@ func init() {
 	viper.BindPFlag(command.KeyBackend, rootCmd.PersistentFlags().Lookup("db"))
 	viper.BindPFlag(command.KeyAddress, rootCmd.PersistentFlags().Lookup("dbpath"))
 	viper.BindPFlag(command.KeyReadOnly, rootCmd.PersistentFlags().Lookup("read_only"))
+	viper.BindPFlag(command.KeyDataSourceSync, rootCmd.PersistentFlags().Lookup("sync"))
 	viper.BindPFlag("load.ignore_duplicates", rootCmd.PersistentFlags().Lookup("dup"))
 	viper.BindPFlag("load.ignore_missing", rootCmd.PersistentFlags().Lookup("missing"))
 	viper.BindPFlag(command.KeyLoadBatch, rootCmd.PersistentFlags().Lookup("batch"))
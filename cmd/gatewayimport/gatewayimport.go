@@ -16,6 +16,7 @@ import (
 	"github.com/cayleygraph/quad"
 	_ "github.com/cayleygraph/quad/jsonld"
 	_ "github.com/cayleygraph/quad/nquads"
+	_ "github.com/epik-protocol/epik-gateway-backend/internal/epikquad"
 
 	"github.com/spf13/cobra"
 )
@@ -24,7 +25,7 @@ const defaultFormat = "jsonld"
 
 // NewCmd creates the command
 func NewCmd() *cobra.Command {
-	var quiet bool
+	var quiet, bulk bool
 	var uri, formatName string
 
 	var cmd = &cobra.Command{
@@ -64,7 +65,11 @@ func NewCmd() *cobra.Command {
 			if format == nil {
 				format = quad.FormatByName(defaultFormat)
 			}
-			r, err := http.Post(uri+"/api/v2/write", format.Mime[0], reader)
+			path := "/api/v2/write"
+			if bulk {
+				path += "?bulk=true"
+			}
+			r, err := http.Post(uri+path, format.Mime[0], reader)
 			if err != nil {
 				return err
 			}
@@ -75,9 +80,10 @@ func NewCmd() *cobra.Command {
 			}
 			if r.StatusCode == http.StatusOK {
 				var response struct {
-					Result string `json:"result"`
-					Count  string `json:"count"`
-					Error  string `json:"error"`
+					Result string  `json:"result"`
+					Count  string  `json:"count"`
+					Error  string  `json:"error"`
+					Rate   float64 `json:"rate"`
 				}
 				json.Unmarshal(body, &response)
 				if response.Error != "" {
@@ -85,6 +91,9 @@ func NewCmd() *cobra.Command {
 				}
 				if !quiet {
 					fmt.Println(response.Result)
+					if response.Rate > 0 {
+						fmt.Printf("Throughput: %.0f quads/s\n", response.Rate)
+					}
 				}
 			} else if r.StatusCode == http.StatusNotFound {
 				return errors.New("Database instance does not support write")
@@ -96,6 +105,7 @@ func NewCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&uri, "uri", "", "http://127.0.0.1:64210", "Gateway URI connection string")
 	cmd.Flags().StringVarP(&formatName, "format", "", "", "format of the provided data (if can not be detected defaults to JSON-LD)")
 	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "hide all log output")
+	cmd.Flags().BoolVarP(&bulk, "bulk", "", false, "use the bulk loader of the database (writes are not published to the feed)")
 	return cmd
 }
 
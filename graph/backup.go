@@ -0,0 +1,263 @@
+package graph
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+var (
+	// ErrBackupChecksum is returned when the checksum of a backup does not match its content.
+	ErrBackupChecksum = errors.New("backup: checksum mismatch")
+	// ErrBackupFormat is returned when the data is not a backup, or was written by an unsupported version.
+	ErrBackupFormat = errors.New("backup: unsupported format")
+	// ErrRestoreNotEmpty is returned when restoring a full backup into a database that already has data.
+	ErrRestoreNotEmpty = errors.New("backup: full backup can only be restored into an empty database")
+)
+
+const (
+	backupMagic   = "EPIKBKP\n"
+	backupVersion = 1
+
+	// backupChecksum is the type of the last record, which contains a checksum of all preceding data.
+	backupChecksum = 0
+
+	// maxBackupRecord limits the size of a single record, so corrupted data does not cause huge allocations.
+	maxBackupRecord = 1 << 30
+)
+
+// BackupInfo is a header of a backup.
+type BackupInfo struct {
+	Version int `json:"version"`
+	// Format identifies the kind of quad store that wrote the backup; the backup can only be restored to the same kind.
+	Format string `json:"format"`
+	// From is set for incremental backups. They contain deltas of epochs after From, up to and including Epoch.
+	From int64 `json:"from,omitempty"`
+	// Epoch is the last epoch included in the backup.
+	Epoch   int64     `json:"epoch"`
+	Created time.Time `json:"created"`
+}
+
+// Incremental reports whether the backup only contains deltas between two epochs.
+func (b *BackupInfo) Incremental() bool {
+	return b.From > 0
+}
+
+// BackupOptions are options of BackupStore.Backup.
+type BackupOptions struct {
+	// From is the last epoch of a previous backup. If set, only deltas of the following epochs are written.
+	// Otherwise, a full snapshot of the database is written.
+	From int64
+	// To is the last epoch to include into an incremental backup. It defaults to the latest applied epoch.
+	To int64
+}
+
+// BackupStore is an optional interface for quad stores that can write and restore consistent backups,
+// including cid mappings and epoch metadata.
+type BackupStore interface {
+	// Backup writes a backup of the store to w and returns its header.
+	Backup(ctx context.Context, w io.Writer, opts BackupOptions) (*BackupInfo, error)
+
+	// Restore reads a backup from r and applies it to the store.
+	//
+	// A full backup can only be restored into an empty store, and an incremental backup must start right
+	// after the latest epoch applied to the store. The checksum is verified after the data is applied,
+	// thus VerifyBackup should be called first to prevent restoring corrupted data.
+	Restore(ctx context.Context, r io.Reader) (*BackupInfo, error)
+}
+
+// BackupWriter writes records of a backup followed by a checksum.
+type BackupWriter struct {
+	w   *bufio.Writer
+	h   hash.Hash
+	buf [binary.MaxVarintLen64 + 1]byte
+	err error
+}
+
+// NewBackupWriter writes a backup header to w and returns a writer for backup records.
+// Close must be called to write the checksum.
+func NewBackupWriter(w io.Writer, info *BackupInfo) (*BackupWriter, error) {
+	info.Version = backupVersion
+	hdr, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	bw := &BackupWriter{h: sha256.New()}
+	bw.w = bufio.NewWriter(io.MultiWriter(w, bw.h))
+	bw.w.WriteString(backupMagic)
+	n := binary.PutUvarint(bw.buf[:], uint64(len(hdr)))
+	bw.w.Write(bw.buf[:n])
+	if _, err = bw.w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return bw, nil
+}
+
+// WriteRecord writes a record of a given type. Types are specific to the quad store, except for zero, which is reserved.
+func (w *BackupWriter) WriteRecord(typ byte, data []byte) error {
+	if w.err != nil {
+		return w.err
+	} else if typ == backupChecksum {
+		return fmt.Errorf("backup: invalid record type: %d", typ)
+	}
+	w.buf[0] = typ
+	n := binary.PutUvarint(w.buf[1:], uint64(len(data)))
+	w.w.Write(w.buf[:n+1])
+	_, w.err = w.w.Write(data)
+	return w.err
+}
+
+// Close writes the checksum of the backup and flushes the data. It does not close the underlying writer.
+func (w *BackupWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.err = w.w.Flush(); w.err != nil {
+		return w.err
+	}
+	sum := w.h.Sum(nil)
+	w.buf[0] = backupChecksum
+	n := binary.PutUvarint(w.buf[1:], uint64(len(sum)))
+	w.w.Write(w.buf[:n+1])
+	w.w.Write(sum)
+	w.err = w.w.Flush()
+	return w.err
+}
+
+// BackupReader reads records of a backup and verifies its checksum.
+type BackupReader struct {
+	r    *bufio.Reader
+	h    hash.Hash
+	info BackupInfo
+	typ  byte
+	data []byte
+	err  error
+}
+
+// NewBackupReader reads a backup header from r and returns a reader for backup records.
+func NewBackupReader(r io.Reader) (*BackupReader, error) {
+	br := &BackupReader{r: bufio.NewReader(r), h: sha256.New()}
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != backupMagic {
+		return nil, ErrBackupFormat
+	}
+	hdr, err := br.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(hdr, &br.info); err != nil {
+		return nil, fmt.Errorf("backup: cannot decode header: %v", err)
+	} else if br.info.Version != backupVersion {
+		return nil, ErrBackupFormat
+	}
+	return br, nil
+}
+
+// Read reads the data while computing its checksum.
+func (r *BackupReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	return n, err
+}
+
+// ReadByte implements io.ByteReader.
+func (r *BackupReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.h.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (r *BackupReader) readBytes() ([]byte, error) {
+	sz, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	if sz > maxBackupRecord {
+		return nil, ErrBackupFormat
+	}
+	buf := make([]byte, sz)
+	if _, err = io.ReadFull(r, buf); err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf, err
+}
+
+// Info returns the header of the backup.
+func (r *BackupReader) Info() *BackupInfo {
+	return &r.info
+}
+
+// Next reads the next record. It returns false when all records were read and the checksum was verified,
+// or if an error occurs. Err should be consulted to distinguish between the two cases.
+func (r *BackupReader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	// the checksum does not cover its own record, thus the type is hashed separately
+	typ, err := r.r.ReadByte()
+	if err == io.EOF {
+		r.err = io.ErrUnexpectedEOF
+		return false
+	} else if err != nil {
+		r.err = err
+		return false
+	}
+	if typ == backupChecksum {
+		sum := r.h.Sum(nil)
+		r.data, r.err = r.readBytes()
+		if r.err != nil {
+			return false
+		}
+		if !bytes.Equal(r.data, sum) {
+			r.err = ErrBackupChecksum
+		} else if _, err = r.r.ReadByte(); err != io.EOF {
+			r.err = fmt.Errorf("backup: unexpected data after the checksum")
+		} else {
+			r.err = io.EOF
+		}
+		return false
+	}
+	r.h.Write([]byte{typ})
+	r.typ = typ
+	r.data, r.err = r.readBytes()
+	return r.err == nil
+}
+
+// Record returns the type and the data of the current record.
+func (r *BackupReader) Record() (byte, []byte) {
+	return r.typ, r.data
+}
+
+// Err returns an error that stopped reading the backup, or nil if the backup was read completely.
+func (r *BackupReader) Err() error {
+	if r.err == io.EOF {
+		return nil
+	}
+	return r.err
+}
+
+// VerifyBackup reads the whole backup and checks its checksum.
+func VerifyBackup(r io.Reader) (*BackupInfo, error) {
+	br, err := NewBackupReader(r)
+	if err != nil {
+		return nil, err
+	}
+	for br.Next() {
+	}
+	if err = br.Err(); err != nil {
+		return nil, err
+	}
+	return br.Info(), nil
+}
@@ -0,0 +1,124 @@
+package graph
+
+import (
+	"io"
+	"time"
+
+	"github.com/cayleygraph/quad"
+)
+
+// BulkOptions configures a bulk load, see BulkLoader.
+type BulkOptions struct {
+	// Workers is the number of goroutines used by parallel stages of the load.
+	// The number of CPUs is used if it is not set.
+	Workers int
+}
+
+// BulkLoader is an optional interface for quad stores that can load large amounts of quads faster
+// than a quad writer by processing batches in parallel stages.
+//
+// Bulk writes bypass the QuadWriter of a Handle, thus they are not published to the feed.
+type BulkLoader interface {
+	// NewBulkWriter starts a bulk load. Quads are ignored if they already exist.
+	// Quads written to the writer may not be visible before it is closed.
+	NewBulkWriter(opts BulkOptions) (quad.WriteCloser, error)
+}
+
+// NewBulkWriter starts a bulk load into the quad store, if it supports it. Otherwise, a regular quad writer is returned.
+func NewBulkWriter(qs QuadStore, opts BulkOptions) (quad.WriteCloser, error) {
+	if l, ok := Unwrap(qs).(BulkLoader); ok {
+		return l.NewBulkWriter(opts)
+	}
+	return qs.NewQuadWriter()
+}
+
+// BulkStats is the progress of a bulk copy, see CopyBulk.
+type BulkStats struct {
+	// Quads is the number of quads written so far.
+	Quads int `json:"quads"`
+	// Duration is the time passed since the start of the copy.
+	Duration time.Duration `json:"duration"`
+}
+
+// Rate returns the throughput of the copy in quads per second.
+func (s BulkStats) Rate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Quads) / s.Duration.Seconds()
+}
+
+// bulkBatches is the number of parsed batches buffered between the reader and the writer of CopyBulk.
+const bulkBatches = 4
+
+// CopyBulk copies quads from the reader to the writer in batches, like quad.CopyBatch, but the reader is
+// parsed on a separate goroutine, so parsing of the next batches overlaps with writing of the current one.
+// If progress is set, it is called after each written batch.
+func CopyBulk(w quad.Writer, r quad.Reader, batch int, progress func(BulkStats)) (BulkStats, error) {
+	if batch <= 0 {
+		batch = quad.DefaultBatch
+	}
+	type parsed struct {
+		quads []quad.Quad
+		err   error
+	}
+	var (
+		out   = make(chan parsed, bulkBatches)
+		stop  = make(chan struct{})
+		start = time.Now()
+		st    BulkStats
+	)
+	defer func() {
+		// wait for the reader to stop, since the caller may close it
+		close(stop)
+		for range out {
+		}
+	}()
+	go func() {
+		defer close(out)
+		for {
+			// writers may retain batches, so a new buffer is allocated for each one
+			buf := make([]quad.Quad, 0, batch)
+			var err error
+			for len(buf) < batch {
+				var q quad.Quad
+				if q, err = r.ReadQuad(); err != nil {
+					break
+				}
+				buf = append(buf, q)
+			}
+			if len(buf) != 0 {
+				select {
+				case out <- parsed{quads: buf}:
+				case <-stop:
+					return
+				}
+			}
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				select {
+				case out <- parsed{err: err}:
+				case <-stop:
+				}
+				return
+			}
+		}
+	}()
+	for p := range out {
+		if p.err != nil {
+			st.Duration = time.Since(start)
+			return st, p.err
+		}
+		n, err := w.WriteQuads(p.quads)
+		st.Quads += n
+		st.Duration = time.Since(start)
+		if err != nil {
+			return st, err
+		}
+		if progress != nil {
+			progress(st)
+		}
+	}
+	return st, nil
+}
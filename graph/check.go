@@ -0,0 +1,69 @@
+package graph
+
+import "context"
+
+// Kinds of inconsistencies reported by Checker.
+const (
+	// CheckLog is a problem of primitives themselves. It cannot be repaired, since all other structures are derived from them.
+	CheckLog = "log"
+	// CheckIndex is an entry of a quad index that does not match quads.
+	CheckIndex = "index"
+	// CheckValue is an entry of a value index that does not match nodes.
+	CheckValue = "value"
+	// CheckRefs is a reference counter of a node that does not match the number of quads using it.
+	CheckRefs = "refs"
+	// CheckCid is a reference from a cid to a primitive that is not a quad.
+	CheckCid = "cid"
+	// CheckBloom is a bloom filter that does not contain an existing quad, node or index entry.
+	CheckBloom = "bloom"
+	// CheckMeta is a counter in the store metadata that does not match the data.
+	CheckMeta = "meta"
+)
+
+// Inconsistency is a single problem found by Checker.
+type Inconsistency struct {
+	Kind string `json:"kind"`
+	// Key is the key of the inconsistent entry, formatted by the quad store.
+	Key string `json:"key,omitempty"`
+	// ID is the primitive the entry belongs to, if it is known.
+	ID uint64 `json:"id,omitempty"`
+	// Msg describes the problem.
+	Msg string `json:"msg"`
+	// Repaired is set if the problem was fixed.
+	Repaired bool `json:"repaired"`
+}
+
+// CheckOptions are options of Checker.Check.
+type CheckOptions struct {
+	// Repair rebuilds derived structures that do not match primitives.
+	Repair bool
+}
+
+// CheckReport is the result of a consistency check.
+type CheckReport struct {
+	// Quads is the number of existing quads.
+	Quads int64 `json:"quads"`
+	// Nodes is the number of existing nodes.
+	Nodes int64 `json:"nodes"`
+	// Problems are all inconsistencies that were found.
+	Problems []Inconsistency `json:"problems"`
+}
+
+// Unrepaired returns the number of problems that were not repaired.
+func (r *CheckReport) Unrepaired() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// Checker is an optional interface for quad stores that can verify the consistency of indexes, reference counters
+// and other structures derived from the primitives they store.
+type Checker interface {
+	// Check cross-checks all derived structures with primitives and reports inconsistencies.
+	// Writes are blocked during the check.
+	Check(ctx context.Context, opts CheckOptions) (*CheckReport, error)
+}
@@ -0,0 +1,41 @@
+package graph
+
+import "context"
+
+// CompactOptions are options of Compactor.Compact.
+type CompactOptions struct {
+	// Before is the first epoch to keep the history of. Quads and nodes deleted at earlier epochs are removed,
+	// thus HistoryStore no longer returns views of epochs before Before-1.
+	//
+	// It defaults to the oldest epoch that can still be reverted, or to the epoch after the latest one
+	// if no undo logs are left.
+	Before int64
+	// DryRun only reports what would be removed, without modifying the store.
+	DryRun bool
+}
+
+// CompactStats reports the data removed by a compaction.
+type CompactStats struct {
+	// Before is the first epoch the history was kept for.
+	Before int64 `json:"before"`
+	// Quads is the number of deleted quads removed from the store.
+	Quads int64 `json:"quads"`
+	// Nodes is the number of deleted nodes removed from the store.
+	Nodes int64 `json:"nodes"`
+	// Refs is the number of orphaned node reference counters removed from the store.
+	Refs int64 `json:"refs"`
+	// Indexes is the number of index entries that were rewritten or removed.
+	Indexes int64 `json:"indexes"`
+	// Cids is the number of references from cids to deleted quads removed from the store.
+	Cids int64 `json:"cids"`
+	// Bytes is an estimated size of keys and values reclaimed by the compaction.
+	Bytes int64 `json:"bytes"`
+}
+
+// Compactor is an optional interface for quad stores that keep deleted quads and nodes
+// and can reclaim space used by them.
+type Compactor interface {
+	// Compact removes deleted quads and nodes that are no longer needed for the history,
+	// together with index entries, node reference counters and cid references pointing to them.
+	Compact(ctx context.Context, opts CompactOptions) (*CompactStats, error)
+}
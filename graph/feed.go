@@ -0,0 +1,473 @@
+package graph
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/pquads"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph/iterator"
+)
+
+var (
+	// ErrNoFeed is returned by Handle when the feed is not enabled.
+	ErrNoFeed = errors.New("feed: not enabled")
+	// ErrFeedTruncated is returned when events requested from the Feed are no longer retained.
+	ErrFeedTruncated = errors.New("feed: events were dropped from the feed")
+	// ErrFeedSequence is returned when the requested sequence number was not issued by the Feed yet,
+	// for example because the process was restarted.
+	ErrFeedSequence = errors.New("feed: sequence number is ahead of the feed")
+	// ErrFeedInstance is returned when the requested sequence number was issued by another Feed,
+	// for example before the process was restarted.
+	ErrFeedInstance = errors.New("feed: sequence number was issued by another feed")
+)
+
+// FeedEvent is a delta of a committed batch published to the Feed.
+//
+// Events describe requested changes: stores do not report which deltas of a batch were skipped
+// with IgnoreOpts, thus an event may add a quad that already existed or delete a missing one.
+// Subscribers should apply events idempotently. Deletions by cid are published as deletions
+// of the quads they removed, one event per quad.
+type FeedEvent struct {
+	// Feed is the ID of the feed that issued the sequence number.
+	Feed string
+	// Seq is the sequence number of the event, it increases by one with each event.
+	Seq   uint64
+	Epoch int64
+	Cid   string
+	// Action and Quad describe the delta.
+	Action Procedure
+	Quad   quad.Quad
+	// Revert is set if all events of Epoch and later epochs were reverted.
+	// Such events carry no delta.
+	Revert bool
+}
+
+type feedEventJSON struct {
+	Feed   string     `json:"feed,omitempty"`
+	Seq    uint64     `json:"seq"`
+	Epoch  int64      `json:"epoch"`
+	Cid    string     `json:"cid,omitempty"`
+	Action string     `json:"action"`
+	Quad   *quad.Quad `json:"quad,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e FeedEvent) MarshalJSON() ([]byte, error) {
+	out := feedEventJSON{Feed: e.Feed, Seq: e.Seq, Epoch: e.Epoch, Cid: e.Cid, Action: e.Action.String()}
+	if e.Revert {
+		out.Action = "revert"
+	} else if e.Quad.IsValid() {
+		out.Quad = &e.Quad
+	}
+	return json.Marshal(out)
+}
+
+// Feed is an ordered stream of deltas committed to a QuadStore.
+//
+// The feed keeps a given number of the latest events, so subscribers can resume from a sequence number
+// they have seen. Feeds created with OpenFeed keep their ID, sequence number and events in the metadata
+// of the store, thus subscribers can resume after a restart. Other feeds are kept in memory: their sequence
+// numbers start from one, thus each feed has a random ID and subscribers can only resume from sequence
+// numbers issued by the same feed.
+type Feed struct {
+	id string
+	// store keeps the state of the feed, it is nil if the feed is kept in memory.
+	store MetaStore
+	// commit serializes batches applied with the feed, so events are published in the order of commits.
+	commit sync.Mutex
+
+	mu   sync.Mutex
+	buf  []FeedEvent
+	seq  uint64
+	size uint64
+	// start is the sequence number of the oldest event kept by the feed.
+	start uint64
+	// notify is closed and replaced when new events are published.
+	notify chan struct{}
+}
+
+// NewFeed creates a feed that retains up to size latest events in memory.
+func NewFeed(size int) *Feed {
+	if size <= 0 {
+		size = 1
+	}
+	return &Feed{
+		id:     newFeedID(),
+		buf:    make([]FeedEvent, size),
+		size:   uint64(size),
+		start:  1,
+		notify: make(chan struct{}),
+	}
+}
+
+const (
+	metaFeedID    = "feed/id"
+	metaFeedSeq   = "feed/seq"
+	metaFeedEvent = "feed/event/"
+)
+
+func feedEventKey(seq uint64) string {
+	return fmt.Sprintf("%s%016x", metaFeedEvent, seq)
+}
+
+// OpenFeed creates a feed that retains up to size latest events in the metadata of the store.
+// The feed continues the sequence numbers and events kept by the store, if any.
+func OpenFeed(ctx context.Context, ms MetaStore, size int) (*Feed, error) {
+	f := NewFeed(size)
+	f.store = ms
+	id, err := ms.Meta(ctx, metaFeedID)
+	if err != nil {
+		return nil, err
+	} else if len(id) == 0 {
+		// the ID is written first, thus stored events always belong to it
+		if err = ms.ApplyDeltasMeta(NoCidEpoch, nil, IgnoreOpts{}, map[string][]byte{metaFeedID: []byte(f.id)}); err != nil {
+			return nil, fmt.Errorf("feed: cannot write the id: %v", err)
+		}
+		return f, nil
+	}
+	f.id = string(id)
+	if b, err := ms.Meta(ctx, metaFeedSeq); err != nil {
+		return nil, err
+	} else if len(b) == 8 {
+		f.seq = binary.BigEndian.Uint64(b)
+	}
+	f.start = f.seq + 1
+	err = ms.ForEachMeta(ctx, func(key string, val []byte) error {
+		if !strings.HasPrefix(key, metaFeedEvent) {
+			return nil
+		}
+		seq, err := strconv.ParseUint(key[len(metaFeedEvent):], 16, 64)
+		if err != nil {
+			return fmt.Errorf("feed: invalid event key %q", key)
+		}
+		if seq > f.seq || f.seq-seq >= f.size {
+			return nil
+		}
+		e, err := decodeFeedEvent(val)
+		if err != nil {
+			return fmt.Errorf("feed: cannot decode event %d: %v", seq, err)
+		}
+		e.Feed, e.Seq = f.id, seq
+		f.buf[seq%f.size] = e
+		if seq < f.start {
+			f.start = seq
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// feedRecord is an event kept in the metadata of the store.
+type feedRecord struct {
+	Epoch  int64     `json:"epoch"`
+	Cid    string    `json:"cid,omitempty"`
+	Action Procedure `json:"action"`
+	// Quad is encoded with pquads, thus values keep their types.
+	Quad   []byte `json:"quad,omitempty"`
+	Revert bool   `json:"revert,omitempty"`
+}
+
+func encodeFeedEvent(e FeedEvent) ([]byte, error) {
+	r := feedRecord{Epoch: e.Epoch, Cid: e.Cid, Action: e.Action, Revert: e.Revert}
+	if e.Quad.IsValid() {
+		var err error
+		if r.Quad, err = pquads.MakeQuad(e.Quad).Marshal(); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(r)
+}
+
+func decodeFeedEvent(data []byte) (FeedEvent, error) {
+	var r feedRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return FeedEvent{}, err
+	}
+	e := FeedEvent{Epoch: r.Epoch, Cid: r.Cid, Action: r.Action, Revert: r.Revert}
+	if len(r.Quad) != 0 {
+		var q pquads.Quad
+		if err := q.Unmarshal(r.Quad); err != nil {
+			return FeedEvent{}, err
+		}
+		e.Quad = q.ToNative()
+	}
+	return e, nil
+}
+
+// newFeedID returns a random ID of the feed.
+func newFeedID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// ID returns the ID of the feed that is set to all events.
+func (f *Feed) ID() string {
+	return f.id
+}
+
+// FeedPublisher is an optional interface for quad writers and listeners that can publish applied deltas to a Feed.
+type FeedPublisher interface {
+	// SetFeed sets the feed to publish deltas to. It must be called before any deltas are applied.
+	SetFeed(f *Feed)
+}
+
+// Seq returns the sequence number of the latest event, or zero if nothing was published yet.
+func (f *Feed) Seq() uint64 {
+	if f == nil {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.seq
+}
+
+// first returns the sequence number of the oldest retained event. It must be called with the lock held.
+func (f *Feed) first() uint64 {
+	if f.seq < f.size {
+		return f.start
+	} else if first := f.seq - f.size + 1; first > f.start {
+		return first
+	}
+	return f.start
+}
+
+// add keeps events in the store of the feed, if any, and publishes them.
+// It must be called with the commit lock held, thus sequence numbers cannot change concurrently.
+func (f *Feed) add(events ...FeedEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if f.store != nil {
+		f.mu.Lock()
+		seq, first := f.seq, f.first()
+		f.mu.Unlock()
+		meta := make(map[string][]byte, len(events)+1)
+		last := seq + uint64(len(events))
+		var keep uint64 = 1
+		if last >= f.size {
+			keep = last - f.size + 1
+		}
+		// events that are no longer retained are removed
+		for i := first; i < keep && i <= seq; i++ {
+			meta[feedEventKey(i)] = nil
+		}
+		for i, e := range events {
+			if seq+uint64(i)+1 < keep {
+				continue
+			}
+			data, err := encodeFeedEvent(e)
+			if err != nil {
+				return err
+			}
+			meta[feedEventKey(seq+uint64(i)+1)] = data
+		}
+		seq = last
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], seq)
+		meta[metaFeedSeq] = buf[:]
+		if err := f.store.ApplyDeltasMeta(NoCidEpoch, nil, IgnoreOpts{}, meta); err != nil {
+			return fmt.Errorf("feed: cannot write events: %v", err)
+		}
+	}
+	f.publish(events...)
+	return nil
+}
+
+func (f *Feed) publish(events ...FeedEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range events {
+		f.seq++
+		e.Feed, e.Seq = f.id, f.seq
+		f.buf[f.seq%f.size] = e
+	}
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// Apply applies deltas to the store and publishes them to the feed, including deltas skipped by the store
+// with IgnoreOpts. Batches are published in the order they are committed, and deletions by cid are published
+// as deletions of the quads they removed. If the feed is nil, deltas are only applied to the store.
+//
+// Events of a feed created with OpenFeed are written right after the batch is committed.
+func (f *Feed) Apply(qs QuadStore, epoch int64, deltas []Delta, opts IgnoreOpts) error {
+	if f == nil {
+		return qs.ApplyDeltas(epoch, deltas, opts)
+	}
+	ctx := context.TODO()
+	f.commit.Lock()
+	defer f.commit.Unlock()
+
+	// quads of deleted cids are resolved before they are removed
+	byCid := make(map[string][]quad.Quad)
+	for _, d := range deltas {
+		if d.Action != Delete || d.Cid == "" || d.Quad.IsValid() {
+			continue
+		} else if _, ok := byCid[d.Cid]; ok {
+			continue
+		}
+		refs, err := qs.QuadsByCid(ctx, d.Cid)
+		if err != nil {
+			return err
+		}
+		quads := make([]quad.Quad, 0, len(refs))
+		for _, r := range refs {
+			quads = append(quads, qs.Quad(r))
+		}
+		byCid[d.Cid] = quads
+	}
+	if err := qs.ApplyDeltas(epoch, deltas, opts); err != nil {
+		return err
+	}
+
+	events := make([]FeedEvent, 0, len(deltas))
+	for _, d := range deltas {
+		quads, ok := byCid[d.Cid]
+		if !ok || d.Action != Delete || d.Quad.IsValid() {
+			events = append(events, FeedEvent{Epoch: epoch, Cid: d.Cid, Action: d.Action, Quad: d.Quad})
+			continue
+		}
+		// quads shared with other cids may be kept by the store
+		delete(byCid, d.Cid)
+		for _, q := range quads {
+			if ok, err := hasQuad(ctx, qs, q); err != nil {
+				return fmt.Errorf("feed: batch was applied, but not published: %v", err)
+			} else if !ok {
+				events = append(events, FeedEvent{Epoch: epoch, Cid: d.Cid, Action: Delete, Quad: q})
+			}
+		}
+	}
+	return f.add(events...)
+}
+
+// Revert calls a function that reverts a given epoch and later epochs, and notifies subscribers
+// that all their deltas were reverted. If the feed is nil, it only calls the function.
+func (f *Feed) Revert(from int64, revert func() error) error {
+	if f == nil {
+		return revert()
+	}
+	f.commit.Lock()
+	defer f.commit.Unlock()
+	if err := revert(); err != nil {
+		return err
+	}
+	return f.add(FeedEvent{Epoch: from, Revert: true})
+}
+
+// hasQuad checks if the store has a quad.
+func hasQuad(ctx context.Context, qs QuadStore, q quad.Quad) (bool, error) {
+	var its []iterator.Shape
+	for _, d := range quad.Directions {
+		v := q.Get(d)
+		if v == nil {
+			continue
+		}
+		ref := qs.ValueOf(v)
+		if ref == nil {
+			return false, nil
+		}
+		its = append(its, qs.QuadIterator(d, ref))
+	}
+	it := iterator.NewAnd(its...).Iterate()
+	defer it.Close()
+	for it.Next(ctx) {
+		// a quad without a label only matches quads without a label
+		if q.Label != nil || qs.Quad(it.Result()).Label == nil {
+			return true, nil
+		}
+	}
+	return false, it.Err()
+}
+
+// Subscribe starts reading the feed from a given sequence number issued by the feed with a given ID,
+// or from the next published event if the sequence number is zero.
+//
+// It returns ErrFeedInstance if the ID does not match the feed, ErrFeedTruncated if the event is no longer retained,
+// and ErrFeedSequence if it was not published yet.
+func (f *Feed) Subscribe(id string, from uint64) (*FeedSubscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if from == 0 {
+		from = f.seq + 1
+	} else if id != f.id {
+		return nil, ErrFeedInstance
+	} else if from > f.seq+1 {
+		return nil, ErrFeedSequence
+	} else if from < f.first() {
+		return nil, ErrFeedTruncated
+	}
+	return &FeedSubscription{f: f, next: from}, nil
+}
+
+// FeedSubscription reads events of the Feed in order.
+type FeedSubscription struct {
+	f    *Feed
+	next uint64
+	cur  FeedEvent
+	err  error
+}
+
+// Next waits for the next event. It returns false if the context is cancelled, or if the subscriber
+// falls behind the feed and events are dropped before they are read. Err should be consulted
+// to distinguish between the two cases.
+func (s *FeedSubscription) Next(ctx context.Context) bool {
+	if s.err != nil {
+		return false
+	}
+	for {
+		s.f.mu.Lock()
+		if s.next <= s.f.seq {
+			if s.next < s.f.first() {
+				s.f.mu.Unlock()
+				s.err = ErrFeedTruncated
+				return false
+			}
+			s.cur = s.f.buf[s.next%s.f.size]
+			s.next++
+			s.f.mu.Unlock()
+			return true
+		}
+		notify := s.f.notify
+		s.f.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			s.err = ctx.Err()
+			return false
+		case <-notify:
+		}
+	}
+}
+
+// Buffered returns the number of published events that were not read yet.
+func (s *FeedSubscription) Buffered() int {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	return int(s.f.seq + 1 - s.next)
+}
+
+// Result returns the current event.
+func (s *FeedSubscription) Result() FeedEvent {
+	return s.cur
+}
+
+// Err returns an error that stopped the subscription.
+func (s *FeedSubscription) Err() error {
+	return s.err
+}
@@ -0,0 +1,113 @@
+package graph_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cayleygraph/quad"
+	"github.com/stretchr/testify/require"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+)
+
+func readFeed(t *testing.T, f *graph.Feed, from uint64, n int) []graph.FeedEvent {
+	ctx := context.TODO()
+	sub, err := f.Subscribe(f.ID(), from)
+	require.NoError(t, err)
+	var out []graph.FeedEvent
+	for i := 0; i < n; i++ {
+		require.True(t, sub.Next(ctx))
+		out = append(out, sub.Result())
+	}
+	return out
+}
+
+func TestFeedStore(t *testing.T) {
+	ctx := context.TODO()
+	qs := newKVStore(t)
+	defer qs.Close()
+	f, err := graph.OpenFeed(ctx, qs, 3)
+	require.NoError(t, err)
+	id := f.ID()
+
+	q1 := quad.MakeIRI("a", "b", "c", "")
+	q2 := quad.MakeIRI("a", "b", "d", "")
+	ignore := graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true}
+	require.NoError(t, f.Apply(qs, 1, []graph.Delta{
+		{Cid: "c1", Quad: q1, Action: graph.Add},
+		{Cid: "c1", Quad: q2, Action: graph.Add},
+	}, ignore))
+	require.NoError(t, f.Apply(qs, 2, []graph.Delta{{Cid: "c2", Quad: q2, Action: graph.Add}}, ignore))
+
+	// deletions by cid are published as the quads they removed, shared quads are kept
+	require.NoError(t, f.Apply(qs, 3, []graph.Delta{{Cid: "c1", Action: graph.Delete}}, ignore))
+	require.Equal(t, uint64(4), f.Seq())
+	require.Equal(t, []graph.FeedEvent{
+		{Feed: id, Seq: 3, Epoch: 2, Cid: "c2", Action: graph.Add, Quad: q2},
+		{Feed: id, Seq: 4, Epoch: 3, Cid: "c1", Action: graph.Delete, Quad: q1},
+	}, readFeed(t, f, 3, 2))
+
+	require.NoError(t, f.Revert(3, func() error { return qs.RevertEpochs(ctx, 3) }))
+
+	// the feed is resumed from the store
+	f, err = graph.OpenFeed(ctx, qs, 3)
+	require.NoError(t, err)
+	require.Equal(t, id, f.ID())
+	require.Equal(t, uint64(5), f.Seq())
+	require.Equal(t, []graph.FeedEvent{
+		{Feed: id, Seq: 3, Epoch: 2, Cid: "c2", Action: graph.Add, Quad: q2},
+		{Feed: id, Seq: 4, Epoch: 3, Cid: "c1", Action: graph.Delete, Quad: q1},
+		{Feed: id, Seq: 5, Epoch: 3, Revert: true},
+	}, readFeed(t, f, 3, 3))
+	_, err = f.Subscribe(id, 2)
+	require.Equal(t, graph.ErrFeedTruncated, err)
+
+	// only retained events are kept
+	var keys []string
+	err = qs.ForEachMeta(ctx, func(key string, val []byte) error {
+		if strings.HasPrefix(key, "feed/event/") {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, keys, 3)
+
+	// a larger feed does not resume events that were removed
+	f, err = graph.OpenFeed(ctx, qs, 10)
+	require.NoError(t, err)
+	_, err = f.Subscribe(id, 2)
+	require.Equal(t, graph.ErrFeedTruncated, err)
+	_, err = f.Subscribe(id, 3)
+	require.NoError(t, err)
+}
+
+func TestFeedOrder(t *testing.T) {
+	qs := newKVStore(t)
+	defer qs.Close()
+	f := graph.NewFeed(100)
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := quad.IRI(string(rune('a' + i)))
+			err := f.Apply(qs, graph.NoCidEpoch, []graph.Delta{
+				{Quad: quad.Make(s, quad.IRI("p"), quad.IRI("o1"), nil), Action: graph.Add},
+				{Quad: quad.Make(s, quad.IRI("p"), quad.IRI("o2"), nil), Action: graph.Add},
+			}, graph.IgnoreOpts{})
+			require.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	// batches are published as a whole, in the order of commits
+	events := readFeed(t, f, 1, 2*n)
+	for i := 0; i < n; i++ {
+		require.Equal(t, events[2*i].Quad.Subject, events[2*i+1].Quad.Subject)
+	}
+}
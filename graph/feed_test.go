@@ -0,0 +1,95 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cayleygraph/quad"
+	"github.com/stretchr/testify/require"
+)
+
+// publish adds events of deltas to the feed, as if they were applied.
+func publish(f *Feed, epoch int64, deltas ...Delta) {
+	events := make([]FeedEvent, 0, len(deltas))
+	for _, d := range deltas {
+		events = append(events, FeedEvent{Epoch: epoch, Cid: d.Cid, Action: d.Action, Quad: d.Quad})
+	}
+	f.publish(events...)
+}
+
+func TestFeed(t *testing.T) {
+	ctx := context.TODO()
+	f := NewFeed(3)
+	id := f.ID()
+	require.NotEmpty(t, id)
+	require.NotEqual(t, id, NewFeed(3).ID())
+
+	q1 := quad.MakeIRI("a", "b", "c", "")
+	q2 := quad.MakeIRI("a", "b", "d", "")
+
+	live, err := f.Subscribe("", 0)
+	require.NoError(t, err)
+
+	publish(f, 5, Delta{Cid: "c1", Quad: q1, Action: Add}, Delta{Cid: "c2", Quad: q2, Action: Add})
+	require.NoError(t, f.Revert(5, func() error { return nil }))
+	require.Equal(t, uint64(3), f.Seq())
+
+	var got []FeedEvent
+	for i := 0; i < 3; i++ {
+		require.True(t, live.Next(ctx))
+		got = append(got, live.Result())
+	}
+	require.Equal(t, []FeedEvent{
+		{Feed: id, Seq: 1, Epoch: 5, Cid: "c1", Action: Add, Quad: q1},
+		{Feed: id, Seq: 2, Epoch: 5, Cid: "c2", Action: Add, Quad: q2},
+		{Feed: id, Seq: 3, Epoch: 5, Revert: true},
+	}, got)
+
+	data, err := json.Marshal(got[2])
+	require.NoError(t, err)
+	require.JSONEq(t, fmt.Sprintf(`{"feed":%q,"seq":3,"epoch":5,"action":"revert"}`, id), string(data))
+	data, err = json.Marshal(FeedEvent{Seq: 4, Cid: "c1", Action: Delete})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"seq":4,"epoch":0,"cid":"c1","action":"delete"}`, string(data))
+
+	// resume from a retained event
+	sub, err := f.Subscribe(id, 2)
+	require.NoError(t, err)
+	require.True(t, sub.Next(ctx))
+	require.Equal(t, uint64(2), sub.Result().Seq)
+
+	// wait for the next event
+	go publish(f, 6, Delta{Cid: "c1", Quad: q1, Action: Delete})
+	tctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	require.True(t, live.Next(tctx))
+	require.Equal(t, FeedEvent{Feed: id, Seq: 4, Epoch: 6, Cid: "c1", Action: Delete, Quad: q1}, live.Result())
+
+	// events 1 and 2 are dropped
+	_, err = f.Subscribe(id, 1)
+	require.Equal(t, ErrFeedTruncated, err)
+	_, err = f.Subscribe(id, 6)
+	require.Equal(t, ErrFeedSequence, err)
+
+	// sequence numbers of another feed, for example before a restart, are rejected
+	_, err = f.Subscribe("other", 4)
+	require.Equal(t, ErrFeedInstance, err)
+	_, err = f.Subscribe("", 4)
+	require.Equal(t, ErrFeedInstance, err)
+
+	// the subscription falls behind
+	require.True(t, sub.Next(ctx))
+	publish(f, 7, Delta{Cid: "c3", Quad: q1, Action: Add}, Delta{Cid: "c4", Quad: q2, Action: Add})
+	require.NoError(t, f.Revert(7, func() error { return nil }))
+	require.False(t, sub.Next(ctx))
+	require.Equal(t, ErrFeedTruncated, sub.Err())
+
+	// cancelled context stops the subscription
+	cancel()
+	for live.Next(tctx) {
+	}
+	require.Equal(t, context.Canceled, live.Err())
+}
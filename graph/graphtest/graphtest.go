@@ -59,6 +59,11 @@ var graphTests = []struct {
 	{"schema", TestSchema},
 	{"delete reinserted", TestDeleteReinserted},
 	{"delete reinserted dup", TestDeleteReinsertedDup},
+	{"epoch", TestEpoch},
+	{"revert epochs", TestRevertEpochs},
+	{"as of", TestAsOf},
+	{"cid index", TestCidIndex},
+	{"lock", TestLock},
 }
 
 func TestAll(t *testing.T, gen testutil.DatabaseFunc, conf *Config) {
@@ -1260,6 +1265,292 @@ func TestDeleteReinsertedDup(t testing.TB, gen testutil.DatabaseFunc, _ *Config)
 	}
 }
 
+func TestEpoch(t testing.TB, gen testutil.DatabaseFunc, _ *Config) {
+	qs, _, closer := gen(t)
+	defer closer()
+
+	ctx := context.TODO()
+	quads := MakeQuadSet()
+	ignore := graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true}
+
+	expectEpoch := func(exp int64) {
+		st, err := qs.Stats(ctx, true)
+		require.NoError(t, err)
+		require.Equal(t, exp, st.Epoch)
+	}
+	expectEpoch(0)
+
+	err := qs.ApplyDeltas(3, []graph.Delta{
+		{Cid: "c1", Quad: quads[0], Action: graph.Add},
+	}, ignore)
+	require.NoError(t, err)
+	expectEpoch(3)
+
+	// writes without cid do not change the epoch
+	err = qs.ApplyDeltas(graph.NoCidEpoch, []graph.Delta{
+		{Quad: quads[1], Action: graph.Add},
+	}, ignore)
+	require.NoError(t, err)
+	expectEpoch(3)
+
+	// empty batch only moves the epoch
+	err = qs.ApplyDeltas(5, nil, ignore)
+	require.NoError(t, err)
+	expectEpoch(5)
+
+	st, err := qs.Stats(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), st.Quads.Value)
+}
+
+func TestRevertEpochs(t testing.TB, gen testutil.DatabaseFunc, _ *Config) {
+	qs, _, closer := gen(t)
+	defer closer()
+
+	es, ok := qs.(graph.EpochStore)
+	if !ok {
+		t.Skip("quadstore does not support epoch rollback")
+	}
+	ctx := context.TODO()
+
+	quads := MakeQuadSet()
+	ignore := graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true}
+
+	// epoch 1 and 2 are final, epoch 3 will be reverted
+	require.NoError(t, es.SetEpochKey(ctx, 1, []byte("ts1")))
+	err := qs.ApplyDeltas(1, []graph.Delta{
+		{Cid: "c1", Quad: quads[0], Action: graph.Add},
+		{Cid: "c2", Quad: quads[1], Action: graph.Add},
+	}, ignore)
+	require.NoError(t, err)
+
+	require.NoError(t, es.SetEpochKey(ctx, 2, []byte("ts2")))
+	err = qs.ApplyDeltas(2, []graph.Delta{
+		{Cid: "c3", Quad: quads[2], Action: graph.Add},
+	}, ignore)
+	require.NoError(t, err)
+
+	require.NoError(t, es.SetEpochKey(ctx, 3, []byte("ts3")))
+	err = qs.ApplyDeltas(3, []graph.Delta{
+		{Cid: "c4", Quad: quads[3], Action: graph.Add},
+		{Cid: "c2", Action: graph.Delete},
+	}, ignore)
+	require.NoError(t, err)
+	err = qs.ApplyDeltas(3, []graph.Delta{
+		{Cid: "c3", Action: graph.Delete},
+	}, ignore)
+	require.NoError(t, err)
+	ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), []quad.Quad{quads[0], quads[3]}, true)
+
+	err = es.RevertEpochs(ctx, 3)
+	require.NoError(t, err)
+
+	st, err := qs.Stats(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), st.Epoch)
+	require.Equal(t, refs.Size{Value: 3, Exact: true}, st.Quads)
+	ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), quads[:3], true)
+
+	key, err := es.EpochKey(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ts2"), key)
+	_, err = es.EpochKey(ctx, 3)
+	require.Equal(t, graph.ErrEpochNotExist, err)
+
+	// cids must point to restored quads again
+	err = qs.ApplyDeltas(3, []graph.Delta{
+		{Cid: "c2", Action: graph.Delete},
+	}, graph.IgnoreOpts{})
+	require.NoError(t, err)
+	ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), []quad.Quad{quads[0], quads[2]}, true)
+
+	// epochs before 2 cannot be reverted after pruning
+	err = es.PruneEpochs(ctx, 2)
+	require.NoError(t, err)
+	_, err = es.EpochKey(ctx, 1)
+	require.Equal(t, graph.ErrEpochNotExist, err)
+	key, err = es.EpochKey(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ts2"), key)
+}
+
+func quadsByCid(t testing.TB, qs graph.QuadStore, cid string) []quad.Quad {
+	refs, err := qs.(graph.CidIndex).QuadsByCid(context.TODO(), cid)
+	require.NoError(t, err)
+	var out quad.ByQuadString
+	for _, r := range refs {
+		out = append(out, qs.Quad(r))
+	}
+	sort.Sort(out)
+	return []quad.Quad(out)
+}
+
+func TestAsOf(t testing.TB, gen testutil.DatabaseFunc, _ *Config) {
+	qs, _, closer := gen(t)
+	defer closer()
+
+	if _, ok := qs.(graph.HistoryStore); !ok {
+		t.Skip("quadstore does not support queries at past epochs")
+	}
+	es, ok := qs.(graph.EpochStore)
+	if !ok {
+		t.Skip("quadstore does not support epoch rollback")
+	}
+	ctx := context.TODO()
+
+	quads := MakeQuadSet()
+	ignore := graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true}
+
+	err := qs.ApplyDeltas(1, []graph.Delta{
+		{Cid: "c1", Quad: quads[0], Action: graph.Add},
+		{Cid: "c2", Quad: quads[1], Action: graph.Add},
+	}, ignore)
+	require.NoError(t, err)
+	err = qs.ApplyDeltas(2, []graph.Delta{
+		{Cid: "c3", Quad: quads[2], Action: graph.Add},
+		{Cid: "c1", Action: graph.Delete},
+	}, ignore)
+	require.NoError(t, err)
+	// nodes of deleted quads are kept for past epochs, but live lookups do not resolve them
+	require.Nil(t, qs.ValueOf(quads[0].Subject))
+	err = qs.ApplyDeltas(3, []graph.Delta{
+		{Cid: "c2", Action: graph.Delete},
+		{Cid: "c4", Quad: quads[0], Action: graph.Add},
+	}, ignore)
+	require.NoError(t, err)
+
+	expectAsOf := func(epoch int64, exp []quad.Quad) {
+		view, err := graph.AsOf(ctx, qs, epoch)
+		require.NoError(t, err)
+		ExpectIteratedQuads(t, view, view.QuadsAllIterator(), exp, true)
+		for _, q := range exp {
+			ref := view.ValueOf(q.Subject)
+			require.NotNil(t, ref, "epoch %d: %v", epoch, q.Subject)
+			it := view.QuadIterator(quad.Subject, ref).Iterate()
+			var got []quad.Quad
+			for it.Next(ctx) {
+				got = append(got, view.Quad(it.Result()))
+			}
+			require.NoError(t, it.Err())
+			it.Close()
+			require.Contains(t, got, q, "epoch %d", epoch)
+		}
+	}
+	expectAsOf(1, quads[0:2])
+	expectAsOf(2, quads[1:3])
+	expectAsOf(3, []quad.Quad{quads[0], quads[2]})
+	ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), []quad.Quad{quads[0], quads[2]}, true)
+
+	_, err = graph.AsOf(ctx, qs, 4)
+	require.Equal(t, graph.ErrEpochNotExist, err)
+	_, err = graph.AsOf(ctx, qs, 0)
+	require.Error(t, err)
+
+	view, err := graph.AsOf(ctx, qs, 2)
+	require.NoError(t, err)
+	err = view.ApplyDeltas(4, []graph.Delta{{Quad: quads[3], Action: graph.Add}}, ignore)
+	require.Equal(t, graph.ErrReadOnlyHistory, err)
+
+	// reverted epochs are removed from the history
+	require.NoError(t, es.RevertEpochs(ctx, 3))
+	expectAsOf(1, quads[0:2])
+	expectAsOf(2, quads[1:3])
+	_, err = graph.AsOf(ctx, qs, 3)
+	require.Equal(t, graph.ErrEpochNotExist, err)
+	ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), quads[1:3], true)
+}
+
+func TestCidIndex(t testing.TB, gen testutil.DatabaseFunc, conf *Config) {
+	qs, _, closer := gen(t)
+	defer closer()
+
+	if _, ok := qs.(graph.CidIndex); !ok {
+		t.Skip("quadstore does not support cid index")
+	}
+	ctx := context.TODO()
+
+	quads := MakeQuadSet()
+	ignore := graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true}
+
+	// all quads of the file are provided by the same cid, the last one is shared with the second file
+	err := qs.ApplyDeltas(1, []graph.Delta{
+		{Cid: "c1", Quad: quads[0], Action: graph.Add},
+		{Cid: "c1", Quad: quads[1], Action: graph.Add},
+		{Cid: "c1", Quad: quads[2], Action: graph.Add},
+		{Cid: "c2", Quad: quads[2], Action: graph.Add},
+		{Cid: "c2", Quad: quads[3], Action: graph.Add},
+	}, ignore)
+	require.NoError(t, err)
+	// existing quad provided by another cid
+	err = qs.ApplyDeltas(2, []graph.Delta{
+		{Cid: "c3", Quad: quads[3], Action: graph.Add},
+	}, ignore)
+	require.NoError(t, err)
+
+	require.Equal(t, quads[:3], quadsByCid(t, qs, "c1"))
+	require.Equal(t, quads[2:4], quadsByCid(t, qs, "c2"))
+	require.Equal(t, quads[3:4], quadsByCid(t, qs, "c3"))
+	require.Empty(t, quadsByCid(t, qs, "c4"))
+
+	// delete by cid removes quads of the file, unless they are still provided by another cid
+	err = qs.ApplyDeltas(3, []graph.Delta{
+		{Cid: "c1", Action: graph.Delete},
+	}, ignore)
+	require.NoError(t, err)
+	ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), quads[2:4], true)
+	require.Empty(t, quadsByCid(t, qs, "c1"))
+	require.Equal(t, quads[2:4], quadsByCid(t, qs, "c2"))
+
+	if !conf.SkipSizeCheckAfterDelete {
+		st, err := qs.Stats(ctx, true)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), st.Quads.Value)
+	}
+
+	es, ok := qs.(graph.EpochStore)
+	if !ok {
+		return
+	}
+	err = es.RevertEpochs(ctx, 2)
+	require.NoError(t, err)
+	ExpectIteratedQuads(t, qs, qs.QuadsAllIterator(), quads[:4], true)
+	require.Equal(t, quads[:3], quadsByCid(t, qs, "c1"))
+	require.Equal(t, quads[2:4], quadsByCid(t, qs, "c2"))
+	require.Empty(t, quadsByCid(t, qs, "c3"))
+}
+
+func TestLock(t testing.TB, gen testutil.DatabaseFunc, _ *Config) {
+	qs, _, closer := gen(t)
+	defer closer()
+
+	ls, ok := qs.(graph.LockStore)
+	if !ok {
+		t.Skip("quadstore does not support locks")
+	}
+	ctx := context.TODO()
+
+	require.NoError(t, ls.Lock(ctx, "sync", "a", time.Minute))
+	// renew by the same owner
+	require.NoError(t, ls.Lock(ctx, "sync", "a", time.Minute))
+	require.Equal(t, graph.ErrLocked, ls.Lock(ctx, "sync", "b", time.Minute))
+	// locks are independent
+	require.NoError(t, ls.Lock(ctx, "other", "b", time.Minute))
+
+	// unlock by another owner is ignored
+	require.NoError(t, ls.Unlock(ctx, "sync", "b"))
+	require.Equal(t, graph.ErrLocked, ls.Lock(ctx, "sync", "b", time.Minute))
+
+	require.NoError(t, ls.Unlock(ctx, "sync", "a"))
+	require.NoError(t, ls.Lock(ctx, "sync", "b", time.Minute))
+	require.Equal(t, graph.ErrLocked, ls.Lock(ctx, "sync", "a", time.Minute))
+
+	// expired lock can be taken over
+	require.NoError(t, ls.Lock(ctx, "expired", "a", time.Nanosecond))
+	time.Sleep(time.Millisecond)
+	require.NoError(t, ls.Lock(ctx, "expired", "b", time.Minute))
+	require.Equal(t, graph.ErrLocked, ls.Lock(ctx, "expired", "a", time.Minute))
+}
+
 func irif(format string, args ...interface{}) quad.IRI {
 	return quad.IRI(fmt.Sprintf(format, args...))
 }
@@ -473,7 +473,7 @@ func prepare(t testing.TB, gen testutil.DatabaseFunc) (graph.QuadStore, func())
 
 		start := time.Now()
 		for _, p := range []string{"./", "../"} {
-			err = internal.Load(qw, 0, filepath.Join(p, "../../data/30kmoviedata.nq.gz"), format)
+			err = internal.Load(qw, 0, filepath.Join(p, "../../data/30kmoviedata.nq.gz"), format, internal.ReadOptions{})
 			if err == nil || !os.IsNotExist(err) {
 				break
 			}
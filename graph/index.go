@@ -0,0 +1,42 @@
+package graph
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cayleygraph/quad"
+)
+
+var (
+	// ErrIndexExists is returned when a complete index with the same directions already exists.
+	ErrIndexExists = errors.New("index: already exists")
+	// ErrIndexNotExist is returned when an index with given directions does not exist.
+	ErrIndexNotExist = errors.New("index: does not exist")
+	// ErrLastIndex is returned when dropping the only complete index of a quad store.
+	ErrLastIndex = errors.New("index: cannot drop the last complete index")
+)
+
+// Index describes a quad index of a quad store.
+type Index struct {
+	// Dirs are directions of quads the index is keyed by, in order.
+	Dirs []quad.Direction `json:"dirs"`
+	// Unique is set if each entry of the index references a single quad.
+	Unique bool `json:"unique,omitempty"`
+	// Complete is set once the index was built over all existing quads.
+	// Incomplete indexes are maintained by writes, but are not used by queries.
+	Complete bool `json:"complete"`
+	// Progress is the fraction of existing quads that were indexed by the build.
+	Progress float64 `json:"progress"`
+}
+
+// IndexManager is an optional interface for quad stores that allow to add and drop quad indexes at runtime.
+type IndexManager interface {
+	// Indexes lists all quad indexes, including incomplete ones.
+	Indexes(ctx context.Context) ([]Index, error)
+	// AddIndex adds a quad index with given directions and builds it over existing quads.
+	// If an incomplete index with the same directions exists, its build is resumed.
+	// Writes are not blocked while the index is built. Progress, if set, is called after each batch.
+	AddIndex(ctx context.Context, dirs []quad.Direction, progress func(Index)) error
+	// DropIndex removes a quad index with given directions and all its entries.
+	DropIndex(ctx context.Context, dirs []quad.Direction) error
+}
@@ -0,0 +1,496 @@
+package iterator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph/refs"
+)
+
+// AggregateFunc is a function used by Aggregate iterator to compute a value for each group of results.
+type AggregateFunc int
+
+const (
+	// AggregateCount counts values in the group.
+	AggregateCount AggregateFunc = iota
+	// AggregateCountDistinct counts unique values in the group.
+	AggregateCountDistinct
+	// AggregateSum sums numeric values in the group. Other values are ignored.
+	AggregateSum
+	// AggregateMin selects the smallest value in the group.
+	AggregateMin
+	// AggregateMax selects the largest value in the group.
+	AggregateMax
+	// AggregateAvg computes an average of numeric values in the group. Other values are ignored.
+	AggregateAvg
+)
+
+var aggregateNames = map[AggregateFunc]string{
+	AggregateCount:         "count",
+	AggregateCountDistinct: "countDistinct",
+	AggregateSum:           "sum",
+	AggregateMin:           "min",
+	AggregateMax:           "max",
+	AggregateAvg:           "avg",
+}
+
+func (fn AggregateFunc) String() string {
+	if name, ok := aggregateNames[fn]; ok {
+		return name
+	}
+	return fmt.Sprintf("aggregate(%d)", int(fn))
+}
+
+// ParseAggregateFunc returns an aggregate function by its name. Names are case-insensitive.
+func ParseAggregateFunc(name string) (AggregateFunc, error) {
+	switch strings.ToLower(name) {
+	case "count":
+		return AggregateCount, nil
+	case "countdistinct", "count_distinct", "distinct":
+		return AggregateCountDistinct, nil
+	case "sum":
+		return AggregateSum, nil
+	case "min":
+		return AggregateMin, nil
+	case "max":
+		return AggregateMax, nil
+	case "avg", "average":
+		return AggregateAvg, nil
+	}
+	return 0, fmt.Errorf("unknown aggregate function: %q", name)
+}
+
+// needsValues reports if the function works on values instead of references.
+func (fn AggregateFunc) needsValues() bool {
+	switch fn {
+	case AggregateCount, AggregateCountDistinct:
+		return false
+	}
+	return true
+}
+
+// Aggregate iterator groups results of the subiterator by a tag and computes an aggregate value for each group.
+//
+// If groupBy is set, the iterator returns one result for each unique value of the groupBy tag,
+// in order of their first appearance. Otherwise, it returns a single aggregate value as a result.
+// The function is applied to values of the "of" tag, or to results of the subiterator if the tag is not set.
+// The aggregate value is saved to the "as" tag, if it is set.
+type Aggregate struct {
+	it      Shape
+	qs      refs.Namer
+	fn      AggregateFunc
+	groupBy string
+	of      string
+	as      string
+}
+
+// NewAggregate creates a new iterator that aggregates results of a provided subiterator.
+// qs may be nil if all values are pre-fetched and fn only counts values.
+func NewAggregate(it Shape, qs refs.Namer, fn AggregateFunc, groupBy, of, as string) *Aggregate {
+	return &Aggregate{
+		it: it, qs: qs,
+		fn: fn, groupBy: groupBy, of: of, as: as,
+	}
+}
+
+func (it *Aggregate) Iterate() Scanner {
+	return newAggregateNext(it)
+}
+
+func (it *Aggregate) Lookup() Index {
+	return newAggregateContains(it)
+}
+
+// SubIterators returns a slice of the sub iterators.
+func (it *Aggregate) SubIterators() []Shape {
+	return []Shape{it.it}
+}
+
+func (it *Aggregate) Optimize(ctx context.Context) (Shape, bool) {
+	sub, optimized := it.it.Optimize(ctx)
+	it.it = sub
+	return it, optimized
+}
+
+func (it *Aggregate) Stats(ctx context.Context) (Costs, error) {
+	sub, err := it.it.Stats(ctx)
+	stats := Costs{
+		NextCost: sub.NextCost * sub.Size.Value,
+		Size:     sub.Size,
+	}
+	stats.Size.Exact = false
+	if it.groupBy == "" {
+		stats.Size = refs.Size{Value: 1, Exact: !it.fn.needsValues() || it.fn == AggregateSum}
+	}
+	stats.ContainsCost = stats.NextCost
+	return stats, err
+}
+
+func (it *Aggregate) String() string {
+	return fmt.Sprintf("Aggregate(%v)", it.fn)
+}
+
+type aggregateGroup struct {
+	key refs.Ref
+	val quad.Value
+}
+
+// result returns a result for the group, or nil if the group has no value.
+func (it *Aggregate) result(g aggregateGroup) refs.Ref {
+	if it.groupBy != "" {
+		return g.key
+	}
+	if g.val == nil {
+		return nil
+	}
+	return refs.PreFetched(g.val)
+}
+
+func (it *Aggregate) tagResults(g aggregateGroup, dst map[string]refs.Ref) {
+	if it.groupBy != "" {
+		dst[it.groupBy] = g.key
+	}
+	if it.as != "" && g.val != nil {
+		dst[it.as] = refs.PreFetched(g.val)
+	}
+}
+
+// groups reads all results of the subiterator and computes an aggregate value for each group.
+func (it *Aggregate) groups(ctx context.Context) ([]aggregateGroup, error) {
+	var (
+		groups []aggregateGroup
+		accs   []*aggregator
+		byKey  = make(map[interface{}]int)
+		// refs to resolve to values; only used by functions that need values
+		vals  []refs.Ref
+		valTo []int
+	)
+	if it.groupBy == "" {
+		groups = append(groups, aggregateGroup{})
+		accs = append(accs, newAggregator(it.fn))
+	}
+	sit := it.it.Iterate()
+	defer sit.Close()
+	var tags map[string]refs.Ref
+	add := func() {
+		if it.groupBy != "" || it.of != "" {
+			tags = make(map[string]refs.Ref)
+			sit.TagResults(tags)
+		}
+		gi := 0
+		if it.groupBy != "" {
+			key := tags[it.groupBy]
+			if key == nil {
+				return
+			}
+			i, ok := byKey[refs.ToKey(key)]
+			if !ok {
+				i = len(groups)
+				byKey[refs.ToKey(key)] = i
+				groups = append(groups, aggregateGroup{key: key})
+				accs = append(accs, newAggregator(it.fn))
+			}
+			gi = i
+		}
+		v := sit.Result()
+		if it.of != "" {
+			v = tags[it.of]
+		}
+		if v == nil {
+			return
+		}
+		if it.fn.needsValues() {
+			vals = append(vals, v)
+			valTo = append(valTo, gi)
+		} else {
+			accs[gi].addRef(v)
+		}
+	}
+	for sit.Next(ctx) {
+		add()
+		for sit.NextPath(ctx) {
+			add()
+		}
+	}
+	if err := sit.Err(); err != nil {
+		return nil, err
+	}
+	if len(vals) != 0 {
+		names, err := it.valuesOf(ctx, vals)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range names {
+			if v != nil {
+				accs[valTo[i]].addValue(v)
+			}
+		}
+	}
+	for i := range groups {
+		groups[i].val = accs[i].value()
+	}
+	return groups, nil
+}
+
+func (it *Aggregate) valuesOf(ctx context.Context, vals []refs.Ref) ([]quad.Value, error) {
+	if it.qs != nil {
+		return refs.ValuesOf(ctx, it.qs, vals)
+	}
+	out := make([]quad.Value, len(vals))
+	for i, v := range vals {
+		if pv, ok := v.(refs.PreFetchedValue); ok {
+			out[i] = pv.NameOf()
+		}
+	}
+	return out, nil
+}
+
+// aggregator accumulates values of a single group.
+type aggregator struct {
+	fn       AggregateFunc
+	n        int64
+	distinct map[interface{}]struct{}
+	sumInt   int64
+	sumFloat float64
+	isFloat  bool
+	best     quad.Value
+}
+
+func newAggregator(fn AggregateFunc) *aggregator {
+	a := &aggregator{fn: fn}
+	if fn == AggregateCountDistinct {
+		a.distinct = make(map[interface{}]struct{})
+	}
+	return a
+}
+
+func (a *aggregator) addRef(r refs.Ref) {
+	switch a.fn {
+	case AggregateCount:
+		a.n++
+	case AggregateCountDistinct:
+		a.distinct[refs.ToKey(r)] = struct{}{}
+	}
+}
+
+func (a *aggregator) addValue(v quad.Value) {
+	switch a.fn {
+	case AggregateSum, AggregateAvg:
+		switch v := v.(type) {
+		case quad.Int:
+			a.sumInt += int64(v)
+		case quad.Float:
+			a.sumFloat += float64(v)
+			a.isFloat = true
+		default:
+			return
+		}
+		a.n++
+	case AggregateMin:
+		if a.best == nil || compareValues(v, a.best) < 0 {
+			a.best = v
+		}
+	case AggregateMax:
+		if a.best == nil || compareValues(v, a.best) > 0 {
+			a.best = v
+		}
+	}
+}
+
+func (a *aggregator) value() quad.Value {
+	switch a.fn {
+	case AggregateCount:
+		return quad.Int(a.n)
+	case AggregateCountDistinct:
+		return quad.Int(len(a.distinct))
+	case AggregateSum:
+		if a.isFloat {
+			return quad.Float(a.sumFloat + float64(a.sumInt))
+		}
+		return quad.Int(a.sumInt)
+	case AggregateAvg:
+		if a.n == 0 {
+			return nil
+		}
+		return quad.Float((a.sumFloat + float64(a.sumInt)) / float64(a.n))
+	}
+	return a.best
+}
+
+// compareValues orders numbers numerically and times chronologically. Other values are compared as strings.
+func compareValues(a, b quad.Value) int {
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return +1
+			}
+			return 0
+		}
+	}
+	if ta, ok := a.(quad.Time); ok {
+		if tb, ok := b.(quad.Time); ok {
+			switch {
+			case time.Time(ta).Before(time.Time(tb)):
+				return -1
+			case time.Time(ta).After(time.Time(tb)):
+				return +1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(a.String(), b.String())
+}
+
+func toFloat(v quad.Value) (float64, bool) {
+	switch v := v.(type) {
+	case quad.Int:
+		return float64(v), true
+	case quad.Float:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// aggregateNext returns aggregated groups in order of their first appearance.
+type aggregateNext struct {
+	agg    *Aggregate
+	groups []aggregateGroup
+	done   bool
+	index  int
+	cur    aggregateGroup
+	err    error
+}
+
+func newAggregateNext(agg *Aggregate) *aggregateNext {
+	return &aggregateNext{agg: agg}
+}
+
+func (it *aggregateNext) TagResults(dst map[string]refs.Ref) {
+	it.agg.tagResults(it.cur, dst)
+}
+
+func (it *aggregateNext) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	if !it.done {
+		it.groups, it.err = it.agg.groups(ctx)
+		it.done = true
+		if it.err != nil {
+			return false
+		}
+	}
+	for it.index < len(it.groups) {
+		g := it.groups[it.index]
+		it.index++
+		if it.agg.result(g) != nil {
+			it.cur = g
+			return true
+		}
+	}
+	it.cur = aggregateGroup{}
+	return false
+}
+
+func (it *aggregateNext) Err() error {
+	return it.err
+}
+
+func (it *aggregateNext) Result() refs.Ref {
+	return it.agg.result(it.cur)
+}
+
+func (it *aggregateNext) NextPath(ctx context.Context) bool {
+	return false
+}
+
+func (it *aggregateNext) Close() error {
+	it.groups = nil
+	return nil
+}
+
+func (it *aggregateNext) String() string { return "AggregateNext" }
+
+// aggregateContains checks if a value is one of the groups, or if it equals to the aggregate value
+// in case results are not grouped.
+type aggregateContains struct {
+	agg   *Aggregate
+	byKey map[interface{}]aggregateGroup
+	done  bool
+	cur   aggregateGroup
+	err   error
+}
+
+func newAggregateContains(agg *Aggregate) *aggregateContains {
+	return &aggregateContains{agg: agg}
+}
+
+func (it *aggregateContains) TagResults(dst map[string]refs.Ref) {
+	it.agg.tagResults(it.cur, dst)
+}
+
+func (it *aggregateContains) Err() error {
+	return it.err
+}
+
+func (it *aggregateContains) Result() refs.Ref {
+	return it.agg.result(it.cur)
+}
+
+func (it *aggregateContains) Contains(ctx context.Context, val refs.Ref) bool {
+	it.cur = aggregateGroup{}
+	if it.err != nil {
+		return false
+	}
+	if !it.done {
+		groups, err := it.agg.groups(ctx)
+		it.done = true
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.byKey = make(map[interface{}]aggregateGroup, len(groups))
+		for _, g := range groups {
+			if it.agg.groupBy == "" {
+				if g.val != nil {
+					it.byKey[g.val] = g
+				}
+			} else {
+				it.byKey[refs.ToKey(g.key)] = g
+			}
+		}
+	}
+	var key interface{}
+	if it.agg.groupBy != "" {
+		key = refs.ToKey(val)
+	} else if v, ok := val.(refs.PreFetchedValue); ok {
+		key = v.NameOf()
+	} else if it.agg.qs != nil {
+		key = it.agg.qs.NameOf(val)
+	}
+	if key == nil {
+		return false
+	}
+	g, ok := it.byKey[key]
+	if ok {
+		it.cur = g
+	}
+	return ok
+}
+
+func (it *aggregateContains) NextPath(ctx context.Context) bool {
+	return false
+}
+
+func (it *aggregateContains) Close() error {
+	it.byKey = nil
+	return nil
+}
+
+func (it *aggregateContains) String() string { return "AggregateContains" }
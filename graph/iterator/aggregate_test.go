@@ -0,0 +1,141 @@
+package iterator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph/refs"
+	"github.com/stretchr/testify/require"
+)
+
+func aggregateRows() Shape {
+	row := func(group string, val quad.Value) Shape {
+		it := NewSave(NewFixed(refs.PreFetched(quad.String("x"))))
+		it.AddFixedTag("group", refs.PreFetched(quad.String(group)))
+		it.AddFixedTag("value", refs.PreFetched(val))
+		return it
+	}
+	return NewOr(
+		row("a", quad.Int(1)),
+		row("b", quad.Int(5)),
+		row("a", quad.Int(3)),
+		row("a", quad.Int(3)),
+		row("b", quad.Float(1.5)),
+		row("c", quad.String("s")),
+	)
+}
+
+func aggregateResults(t testing.TB, it Shape) map[string]quad.Value {
+	ctx := context.TODO()
+	sc := it.Iterate()
+	defer sc.Close()
+	out := make(map[string]quad.Value)
+	for sc.Next(ctx) {
+		tags := make(map[string]refs.Ref)
+		sc.TagResults(tags)
+		key := sc.Result().(refs.PreFetchedValue).NameOf().String()
+		if v, ok := tags["agg"]; ok {
+			out[key] = v.(refs.PreFetchedValue).NameOf()
+		} else {
+			out[key] = nil
+		}
+	}
+	require.NoError(t, sc.Err())
+	return out
+}
+
+func TestAggregateGroupBy(t *testing.T) {
+	for _, c := range []struct {
+		fn     AggregateFunc
+		expect map[string]quad.Value
+	}{
+		{
+			fn: AggregateCount,
+			expect: map[string]quad.Value{
+				`"a"`: quad.Int(3), `"b"`: quad.Int(2), `"c"`: quad.Int(1),
+			},
+		},
+		{
+			fn: AggregateCountDistinct,
+			expect: map[string]quad.Value{
+				`"a"`: quad.Int(2), `"b"`: quad.Int(2), `"c"`: quad.Int(1),
+			},
+		},
+		{
+			fn: AggregateSum,
+			expect: map[string]quad.Value{
+				`"a"`: quad.Int(7), `"b"`: quad.Float(6.5), `"c"`: quad.Int(0),
+			},
+		},
+		{
+			fn: AggregateAvg,
+			expect: map[string]quad.Value{
+				`"a"`: quad.Float(7.0 / 3), `"b"`: quad.Float(3.25), `"c"`: nil,
+			},
+		},
+		{
+			fn: AggregateMin,
+			expect: map[string]quad.Value{
+				`"a"`: quad.Int(1), `"b"`: quad.Float(1.5), `"c"`: quad.String("s"),
+			},
+		},
+		{
+			fn: AggregateMax,
+			expect: map[string]quad.Value{
+				`"a"`: quad.Int(3), `"b"`: quad.Int(5), `"c"`: quad.String("s"),
+			},
+		},
+	} {
+		t.Run(c.fn.String(), func(t *testing.T) {
+			it := NewAggregate(aggregateRows(), nil, c.fn, "group", "value", "agg")
+			require.Equal(t, c.expect, aggregateResults(t, it))
+		})
+	}
+}
+
+func TestAggregateNoGroup(t *testing.T) {
+	ctx := context.TODO()
+	it := NewAggregate(aggregateRows(), nil, AggregateSum, "", "value", "")
+
+	itn := it.Iterate()
+	require.True(t, itn.Next(ctx))
+	require.Equal(t, refs.PreFetched(quad.Float(13.5)), itn.Result())
+	require.False(t, itn.Next(ctx))
+
+	itc := it.Lookup()
+	require.True(t, itc.Contains(ctx, refs.PreFetched(quad.Float(13.5))))
+	require.False(t, itc.Contains(ctx, refs.PreFetched(quad.Int(3))))
+
+	// aggregates of an empty set
+	it = NewAggregate(NewNull(), nil, AggregateCount, "", "", "")
+	itn = it.Iterate()
+	require.True(t, itn.Next(ctx))
+	require.Equal(t, refs.PreFetched(quad.Int(0)), itn.Result())
+	require.False(t, itn.Next(ctx))
+
+	it = NewAggregate(NewNull(), nil, AggregateMax, "", "", "")
+	itn = it.Iterate()
+	require.False(t, itn.Next(ctx))
+	require.NoError(t, itn.Err())
+}
+
+func TestAggregateContains(t *testing.T) {
+	ctx := context.TODO()
+	it := NewAggregate(aggregateRows(), nil, AggregateCount, "group", "", "agg").Lookup()
+	require.True(t, it.Contains(ctx, refs.PreFetched(quad.String("b"))))
+	tags := make(map[string]refs.Ref)
+	it.TagResults(tags)
+	require.Equal(t, refs.PreFetched(quad.Int(2)), tags["agg"])
+	require.False(t, it.Contains(ctx, refs.PreFetched(quad.String("d"))))
+}
+
+func TestParseAggregateFunc(t *testing.T) {
+	for fn := AggregateCount; fn <= AggregateAvg; fn++ {
+		got, err := ParseAggregateFunc(fn.String())
+		require.NoError(t, err)
+		require.Equal(t, fn, got)
+	}
+	_, err := ParseAggregateFunc("median")
+	require.Error(t, err)
+}
@@ -150,14 +150,16 @@ type Shape interface {
 type Morphism func(Shape) Shape
 
 func IsNull(it Shape) bool {
-	if _, ok := it.(*Null); ok {
+	if _, ok := Unprofile(it).(*Null); ok {
 		return true
 	}
 	return false
 }
 
 // Height is a convienence function to measure the height of an iterator tree.
+// Profile iterators are not counted.
 func Height(it Shape, filter func(Shape) bool) int {
+	it = Unprofile(it)
 	if filter != nil && !filter(it) {
 		return 1
 	}
@@ -0,0 +1,191 @@
+package iterator
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph/refs"
+)
+
+// ProfileStats are execution statistics collected by a Profile iterator.
+type ProfileStats struct {
+	// Next is the number of calls to Next.
+	Next int64 `json:"next"`
+	// Results is the number of calls to Next that returned a result.
+	Results int64 `json:"results"`
+	// NextPath is the number of calls to NextPath that returned a result.
+	NextPath int64 `json:"next_path"`
+	// Contains is the number of calls to Contains.
+	Contains int64 `json:"contains"`
+	// Hits is the number of calls to Contains that returned true.
+	Hits int64 `json:"hits"`
+	// Time is the total time spent in the iterator, including its sub-iterators.
+	Time time.Duration `json:"time"`
+}
+
+// Profile iterator wraps an iterator and collects execution statistics of all scanners and indexes
+// created from it. It is transparent otherwise.
+type Profile struct {
+	it Shape
+	st ProfileStats
+}
+
+// NewProfile wraps an iterator to collect its execution statistics.
+func NewProfile(it Shape) *Profile {
+	return &Profile{it: it}
+}
+
+// Unwrap returns the wrapped iterator.
+func (it *Profile) Unwrap() Shape {
+	return it.it
+}
+
+// Unprofile returns the iterator wrapped by Profile iterators, or the iterator itself if it is not profiled.
+// Optimizers that check types of sub-iterators should use it, so profiled iterator trees are optimized
+// in the same way as the original ones.
+func Unprofile(it Shape) Shape {
+	for {
+		p, ok := it.(*Profile)
+		if !ok {
+			return it
+		}
+		it = p.it
+	}
+}
+
+// ProfileStats returns statistics collected so far.
+func (it *Profile) ProfileStats() ProfileStats {
+	return ProfileStats{
+		Next:     atomic.LoadInt64(&it.st.Next),
+		Results:  atomic.LoadInt64(&it.st.Results),
+		NextPath: atomic.LoadInt64(&it.st.NextPath),
+		Contains: atomic.LoadInt64(&it.st.Contains),
+		Hits:     atomic.LoadInt64(&it.st.Hits),
+		Time:     time.Duration(atomic.LoadInt64((*int64)(&it.st.Time))),
+	}
+}
+
+func (it *Profile) Iterate() Scanner {
+	return &profileNext{it: it.it.Iterate(), st: &it.st}
+}
+
+func (it *Profile) Lookup() Index {
+	return &profileContains{it: it.it.Lookup(), st: &it.st}
+}
+
+// SubIterators returns a slice of the sub iterators.
+func (it *Profile) SubIterators() []Shape {
+	return []Shape{it.it}
+}
+
+// Optimize optimizes the wrapped iterator. The Profile iterator itself is only replaced
+// by a Null iterator if the wrapped one is empty, so statistics are collected for the optimized iterator.
+func (it *Profile) Optimize(ctx context.Context) (Shape, bool) {
+	nit, optimized := it.it.Optimize(ctx)
+	if IsNull(nit) {
+		return nit, true
+	}
+	it.it = nit
+	return it, optimized
+}
+
+func (it *Profile) Stats(ctx context.Context) (Costs, error) {
+	return it.it.Stats(ctx)
+}
+
+func (it *Profile) String() string {
+	return "Profile"
+}
+
+func addTime(st *ProfileStats, start time.Time) {
+	atomic.AddInt64((*int64)(&st.Time), int64(time.Since(start)))
+}
+
+type profileNext struct {
+	it Scanner
+	st *ProfileStats
+}
+
+func (it *profileNext) TagResults(dst map[string]refs.Ref) {
+	it.it.TagResults(dst)
+}
+
+func (it *profileNext) Next(ctx context.Context) bool {
+	defer addTime(it.st, time.Now())
+	atomic.AddInt64(&it.st.Next, 1)
+	if it.it.Next(ctx) {
+		atomic.AddInt64(&it.st.Results, 1)
+		return true
+	}
+	return false
+}
+
+func (it *profileNext) NextPath(ctx context.Context) bool {
+	defer addTime(it.st, time.Now())
+	if it.it.NextPath(ctx) {
+		atomic.AddInt64(&it.st.NextPath, 1)
+		return true
+	}
+	return false
+}
+
+func (it *profileNext) Err() error {
+	return it.it.Err()
+}
+
+func (it *profileNext) Result() refs.Ref {
+	return it.it.Result()
+}
+
+func (it *profileNext) Close() error {
+	return it.it.Close()
+}
+
+func (it *profileNext) String() string {
+	return "ProfileNext"
+}
+
+type profileContains struct {
+	it Index
+	st *ProfileStats
+}
+
+func (it *profileContains) TagResults(dst map[string]refs.Ref) {
+	it.it.TagResults(dst)
+}
+
+func (it *profileContains) Contains(ctx context.Context, val refs.Ref) bool {
+	defer addTime(it.st, time.Now())
+	atomic.AddInt64(&it.st.Contains, 1)
+	if it.it.Contains(ctx, val) {
+		atomic.AddInt64(&it.st.Hits, 1)
+		return true
+	}
+	return false
+}
+
+func (it *profileContains) NextPath(ctx context.Context) bool {
+	defer addTime(it.st, time.Now())
+	if it.it.NextPath(ctx) {
+		atomic.AddInt64(&it.st.NextPath, 1)
+		return true
+	}
+	return false
+}
+
+func (it *profileContains) Err() error {
+	return it.it.Err()
+}
+
+func (it *profileContains) Result() refs.Ref {
+	return it.it.Result()
+}
+
+func (it *profileContains) Close() error {
+	return it.it.Close()
+}
+
+func (it *profileContains) String() string {
+	return "ProfileContains"
+}
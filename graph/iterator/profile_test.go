@@ -0,0 +1,40 @@
+package iterator_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/epik-protocol/epik-gateway-backend/graph/iterator"
+)
+
+func TestProfileIterator(t *testing.T) {
+	ctx := context.TODO()
+	p := NewProfile(NewFixed(
+		Int64Node(1),
+		Int64Node(2),
+		Int64Node(3),
+	))
+	require.Equal(t, []int{1, 2, 3}, iterated(p))
+
+	pc := p.Lookup()
+	require.True(t, pc.Contains(ctx, Int64Node(2)))
+	require.False(t, pc.Contains(ctx, Int64Node(4)))
+
+	st := p.ProfileStats()
+	require.Equal(t, int64(4), st.Next)
+	require.Equal(t, int64(3), st.Results)
+	require.Equal(t, int64(2), st.Contains)
+	require.Equal(t, int64(1), st.Hits)
+
+	o, _ := p.Optimize(ctx)
+	require.True(t, o == Shape(p), "profile iterator must not be replaced")
+
+	// profile iterators are transparent to optimizers of parent iterators
+	require.True(t, IsNull(NewProfile(NewNull())))
+	o, ok := NewProfile(NewNull()).Optimize(ctx)
+	require.True(t, ok)
+	require.Equal(t, NewNull(), o)
+	require.Equal(t, 2, Height(NewProfile(NewAnd(NewProfile(NewFixed()))), nil))
+}
@@ -0,0 +1,806 @@
+package iterator
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph/refs"
+)
+
+// RouteKind selects an algorithm used by Routes iterator to find routes between nodes.
+type RouteKind int
+
+const (
+	// RouteShortest finds the shortest route from each source node to each reachable target node.
+	RouteShortest RouteKind = iota
+	// RouteAll finds all simple routes (routes without repeated nodes) from each source node to target nodes.
+	RouteAll
+	// RouteReachable finds a single shortest route from each source node to the nearest target node.
+	RouteReachable
+)
+
+var routeNames = map[RouteKind]string{
+	RouteShortest:  "shortest",
+	RouteAll:       "all",
+	RouteReachable: "reachable",
+}
+
+func (k RouteKind) String() string {
+	if name, ok := routeNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("route(%d)", int(k))
+}
+
+// ParseRouteKind returns a route kind by its name. Names are case-insensitive.
+func ParseRouteKind(name string) (RouteKind, error) {
+	switch strings.ToLower(name) {
+	case "shortest", "shortestpath", "shortest_path":
+		return RouteShortest, nil
+	case "all", "allpaths", "all_paths":
+		return RouteAll, nil
+	case "reachable", "reachability":
+		return RouteReachable, nil
+	}
+	return 0, fmt.Errorf("unknown route kind: %q", name)
+}
+
+// QuadIndex is a subset of the quad store interface used by Routes iterator to follow edges of the graph.
+type QuadIndex interface {
+	refs.Namer
+	// QuadIterator returns an iterator for all quads that have a node in a given direction.
+	QuadIterator(quad.Direction, refs.Ref) Shape
+	// QuadDirection returns a node of the quad in a given direction.
+	QuadDirection(id refs.Ref, d quad.Direction) refs.Ref
+}
+
+// Route is an ordered list of nodes and edges between them.
+// Edges are quad references; Edges[i] connects Nodes[i] and Nodes[i+1].
+type Route struct {
+	Nodes  []refs.Ref
+	Edges  []refs.Ref
+	Weight float64 // sum of weights of all steps; equals to the number of edges for unweighted routes
+}
+
+// Source returns the first node of the route.
+func (r Route) Source() refs.Ref {
+	if len(r.Nodes) == 0 {
+		return nil
+	}
+	return r.Nodes[0]
+}
+
+// Target returns the last node of the route.
+func (r Route) Target() refs.Ref {
+	if len(r.Nodes) == 0 {
+		return nil
+	}
+	return r.Nodes[len(r.Nodes)-1]
+}
+
+// RouteOptions controls which edges are followed by Routes iterator.
+type RouteOptions struct {
+	// Via is a list of predicates to follow. Edges with any predicate are followed if the list is empty.
+	Via []refs.Ref
+	// Reverse follows edges from objects to subjects.
+	Reverse bool
+	// MaxDepth limits the number of edges in a route. If it is not set, DefaultMaxRouteDepth is used for RouteAll,
+	// and DefaultMaxRecursiveSteps is used for other kinds.
+	MaxDepth int
+	// Weight is a predicate that sets a numeric cost of a step to the node it reaches. Nodes without a numeric
+	// value of this predicate cost 1, as well as all nodes if it is not set. Only used by RouteShortest.
+	Weight refs.Ref
+}
+
+// RouteScanner is a Scanner that also returns a route to each result.
+type RouteScanner interface {
+	Scanner
+	// Route returns a route from a source node to the current result.
+	Route() Route
+}
+
+// RouteIndex is an Index that also returns a route to each result.
+type RouteIndex interface {
+	Index
+	// Route returns a route from a source node to the current result.
+	Route() Route
+}
+
+var _ Shape = (*Routes)(nil)
+
+// Routes iterator finds routes from results of one iterator to results of the other iterator,
+// and returns the last node of each route. Tags of the source node are preserved.
+//
+// Routes are found independently for each source node, in order of results of the source iterator.
+// If target iterator is nil, any node is a target, and routes of zero length are not returned.
+type Routes struct {
+	qs   QuadIndex
+	from Shape
+	to   Shape
+	kind RouteKind
+	opt  RouteOptions
+}
+
+// DefaultMaxRouteDepth is the default maximal number of edges in routes found by RouteAll.
+// The number of such routes grows exponentially with the depth, thus the default is kept small.
+var DefaultMaxRouteDepth = 4
+
+// MaxRouteIndexSize is the maximal number of routes Routes iterator loads to check if it contains a node.
+var MaxRouteIndexSize = 100000
+
+// ErrTooManyRoutes is returned by Routes iterator if a node cannot be checked without loading
+// more than MaxRouteIndexSize routes.
+var ErrTooManyRoutes = errors.New("too many routes to check if they lead to a node")
+
+// DefaultRouteDepth returns the maximal number of edges in routes of a given kind if RouteOptions.MaxDepth is not set.
+func DefaultRouteDepth(kind RouteKind) int {
+	if kind == RouteAll {
+		return DefaultMaxRouteDepth
+	}
+	return DefaultMaxRecursiveSteps
+}
+
+// NewRoutes creates a new iterator that finds routes from nodes of "from" to nodes of "to" iterator.
+func NewRoutes(qs QuadIndex, from, to Shape, kind RouteKind, opt RouteOptions) *Routes {
+	if opt.MaxDepth <= 0 {
+		opt.MaxDepth = DefaultRouteDepth(kind)
+	}
+	return &Routes{
+		qs: qs, from: from, to: to,
+		kind: kind, opt: opt,
+	}
+}
+
+func (it *Routes) Iterate() Scanner {
+	return newRoutesNext(it)
+}
+
+func (it *Routes) Lookup() Index {
+	return newRoutesContains(it)
+}
+
+// SubIterators returns a slice of the sub iterators.
+func (it *Routes) SubIterators() []Shape {
+	if it.to == nil {
+		return []Shape{it.from}
+	}
+	return []Shape{it.from, it.to}
+}
+
+func (it *Routes) Optimize(ctx context.Context) (Shape, bool) {
+	var opt bool
+	it.from, opt = it.from.Optimize(ctx)
+	if it.to != nil {
+		var opt2 bool
+		it.to, opt2 = it.to.Optimize(ctx)
+		opt = opt || opt2
+	}
+	return it, opt
+}
+
+func (it *Routes) Stats(ctx context.Context) (Costs, error) {
+	sub, err := it.from.Stats(ctx)
+	depth := int64(it.opt.MaxDepth)
+	return Costs{
+		NextCost:     sub.NextCost * depth,
+		ContainsCost: sub.NextCost * depth * sub.Size.Value,
+		Size: refs.Size{
+			Value: sub.Size.Value * depth,
+			Exact: false,
+		},
+	}, err
+}
+
+func (it *Routes) String() string {
+	return fmt.Sprintf("Routes(%v)", it.kind)
+}
+
+// routeFinder finds routes from source nodes one at a time. It caches edge weights and target checks.
+type routeFinder struct {
+	r       *Routes
+	to      Index
+	via     map[interface{}]struct{}
+	targets map[interface{}]bool
+	weights map[interface{}]float64
+}
+
+func newRouteFinder(r *Routes) *routeFinder {
+	f := &routeFinder{
+		r:       r,
+		targets: make(map[interface{}]bool),
+	}
+	if r.to != nil {
+		f.to = r.to.Lookup()
+	}
+	if len(r.opt.Via) != 0 {
+		f.via = make(map[interface{}]struct{}, len(r.opt.Via))
+		for _, p := range r.opt.Via {
+			f.via[refs.ToKey(p)] = struct{}{}
+		}
+	}
+	if r.opt.Weight != nil && r.kind == RouteShortest {
+		f.weights = make(map[interface{}]float64)
+	}
+	return f
+}
+
+func (f *routeFinder) isTarget(ctx context.Context, n refs.Ref) (bool, error) {
+	if f.to == nil {
+		return true, nil
+	}
+	key := refs.ToKey(n)
+	if ok, cached := f.targets[key]; cached {
+		return ok, nil
+	}
+	ok := f.to.Contains(ctx, n)
+	if err := f.to.Err(); err != nil {
+		return false, err
+	}
+	f.targets[key] = ok
+	return ok, nil
+}
+
+// routeEdge is a quad that connects a node to the next node of the route.
+type routeEdge struct {
+	quad refs.Ref
+	node refs.Ref
+}
+
+// edges returns all edges that can be followed from a given node.
+func (f *routeFinder) edges(ctx context.Context, n refs.Ref) ([]routeEdge, error) {
+	from, to := quad.Subject, quad.Object
+	if f.r.opt.Reverse {
+		from, to = to, from
+	}
+	it := f.r.qs.QuadIterator(from, n).Iterate()
+	defer it.Close()
+	var out []routeEdge
+	for it.Next(ctx) {
+		q := it.Result()
+		if f.via != nil {
+			if _, ok := f.via[refs.ToKey(f.r.qs.QuadDirection(q, quad.Predicate))]; !ok {
+				continue
+			}
+		}
+		out = append(out, routeEdge{quad: q, node: f.r.qs.QuadDirection(q, to)})
+	}
+	return out, it.Err()
+}
+
+// weight returns a cost of a step to a given node.
+func (f *routeFinder) weight(ctx context.Context, n refs.Ref) (float64, error) {
+	if f.weights == nil {
+		return 1, nil
+	}
+	key := refs.ToKey(n)
+	if w, ok := f.weights[key]; ok {
+		return w, nil
+	}
+	it := f.r.qs.QuadIterator(quad.Subject, n).Iterate()
+	defer it.Close()
+	wkey := refs.ToKey(f.r.opt.Weight)
+	w := 1.0
+	for it.Next(ctx) {
+		q := it.Result()
+		if refs.ToKey(f.r.qs.QuadDirection(q, quad.Predicate)) != wkey {
+			continue
+		}
+		vals, err := refs.ValuesOf(ctx, f.r.qs, []refs.Ref{f.r.qs.QuadDirection(q, quad.Object)})
+		if err != nil {
+			return 0, err
+		}
+		if v, ok := toFloat(vals[0]); ok {
+			if v < 0 {
+				return 0, fmt.Errorf("negative weight of %v: %v", f.r.qs.NameOf(n), v)
+			}
+			w = v
+			break
+		}
+	}
+	if err := it.Err(); err != nil {
+		return 0, err
+	}
+	f.weights[key] = w
+	return w, nil
+}
+
+// routeSearch returns routes from a single source node one at a time.
+type routeSearch interface {
+	// next returns the next route, or false if there are no more routes.
+	next(ctx context.Context) (Route, bool, error)
+}
+
+// routeList is a routeSearch for routes that were already found.
+type routeList []Route
+
+func (l *routeList) next(ctx context.Context) (Route, bool, error) {
+	if len(*l) == 0 {
+		return Route{}, false, nil
+	}
+	r := (*l)[0]
+	*l = (*l)[1:]
+	return r, true, nil
+}
+
+// find starts a search of routes from a given node.
+func (f *routeFinder) find(ctx context.Context, src refs.Ref) (routeSearch, error) {
+	var (
+		routes routeList
+		err    error
+	)
+	switch f.r.kind {
+	case RouteShortest:
+		if f.weights != nil {
+			routes, err = f.weighted(ctx, src)
+		} else {
+			routes, err = f.bfs(ctx, src)
+		}
+	case RouteReachable:
+		routes, err = f.bfs(ctx, src)
+	case RouteAll:
+		// the number of routes can be huge, thus they are returned as they are found
+		return f.all(src), nil
+	default:
+		err = fmt.Errorf("unsupported route kind: %v", f.r.kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &routes, nil
+}
+
+// routeStep is a step of a search tree. Routes are reconstructed by following steps to the source.
+type routeStep struct {
+	prev   int // index of the previous step; -1 for the source
+	edge   refs.Ref
+	node   refs.Ref
+	depth  int
+	weight float64
+}
+
+func (f *routeFinder) route(steps []routeStep, i int) Route {
+	last := steps[i]
+	r := Route{
+		Nodes:  make([]refs.Ref, last.depth+1),
+		Edges:  make([]refs.Ref, last.depth),
+		Weight: last.weight,
+	}
+	for ; i >= 0; i = steps[i].prev {
+		s := steps[i]
+		r.Nodes[s.depth] = s.node
+		if s.depth > 0 {
+			r.Edges[s.depth-1] = s.edge
+		}
+	}
+	return r
+}
+
+// accept reports if a route to a given step should be returned.
+func (f *routeFinder) accept(ctx context.Context, s routeStep) (bool, error) {
+	if s.depth == 0 && f.to == nil {
+		return false, nil
+	}
+	return f.isTarget(ctx, s.node)
+}
+
+// bfs finds the shortest route to each target node with a breadth-first search.
+// It stops at the first target if only reachability is checked.
+func (f *routeFinder) bfs(ctx context.Context, src refs.Ref) ([]Route, error) {
+	steps := []routeStep{{prev: -1, node: src}}
+	seen := map[interface{}]struct{}{refs.ToKey(src): {}}
+	var out []Route
+	for i := 0; i < len(steps); i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		s := steps[i]
+		if ok, err := f.accept(ctx, s); err != nil {
+			return nil, err
+		} else if ok {
+			out = append(out, f.route(steps, i))
+			if f.r.kind == RouteReachable {
+				return out, nil
+			}
+		}
+		if s.depth >= f.r.opt.MaxDepth {
+			continue
+		}
+		edges, err := f.edges(ctx, s.node)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range edges {
+			key := refs.ToKey(e.node)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			steps = append(steps, routeStep{
+				prev: i, edge: e.quad, node: e.node,
+				depth: s.depth + 1, weight: s.weight + 1,
+			})
+		}
+	}
+	return out, nil
+}
+
+// routeState is a node reached with a given number of edges.
+type routeState struct {
+	node  interface{}
+	depth int
+}
+
+// weighted finds the cheapest route with at most MaxDepth edges to each target node with Dijkstra's algorithm.
+//
+// The cheapest route to a node may be too long to be continued to other nodes, thus a node is visited again
+// if it is reached with fewer edges. A step is skipped if its node was already visited with the same or
+// a smaller number of edges, since steps are visited in order of their weights.
+func (f *routeFinder) weighted(ctx context.Context, src refs.Ref) ([]Route, error) {
+	steps := []routeStep{{prev: -1, node: src}}
+	best := map[routeState]float64{{node: refs.ToKey(src)}: 0}
+	// the smallest depth each node was visited with
+	done := make(map[interface{}]int)
+	queue := &routeQueue{steps: &steps, items: []int{0}}
+	var out []Route
+	for queue.Len() != 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		i := heap.Pop(queue).(int)
+		s := steps[i]
+		key := refs.ToKey(s.node)
+		depth, visited := done[key]
+		if visited && depth <= s.depth {
+			continue
+		}
+		done[key] = s.depth
+		if !visited {
+			// the first visit of a node is the cheapest route to it
+			if ok, err := f.accept(ctx, s); err != nil {
+				return nil, err
+			} else if ok {
+				out = append(out, f.route(steps, i))
+			}
+		}
+		if s.depth >= f.r.opt.MaxDepth {
+			continue
+		}
+		edges, err := f.edges(ctx, s.node)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range edges {
+			next := routeState{node: refs.ToKey(e.node), depth: s.depth + 1}
+			if depth, ok := done[next.node]; ok && depth <= next.depth {
+				continue
+			}
+			w, err := f.weight(ctx, e.node)
+			if err != nil {
+				return nil, err
+			}
+			w += s.weight
+			if prev, ok := best[next]; ok && prev <= w {
+				continue
+			}
+			best[next] = w
+			steps = append(steps, routeStep{
+				prev: i, edge: e.quad, node: e.node,
+				depth: s.depth + 1, weight: w,
+			})
+			heap.Push(queue, len(steps)-1)
+		}
+	}
+	return out, nil
+}
+
+// routeQueue is a priority queue of search steps ordered by weight.
+// Steps with equal weights are ordered by their discovery.
+type routeQueue struct {
+	steps *[]routeStep
+	items []int
+}
+
+func (q *routeQueue) Len() int { return len(q.items) }
+func (q *routeQueue) Less(i, j int) bool {
+	a, b := (*q.steps)[q.items[i]], (*q.steps)[q.items[j]]
+	if a.weight != b.weight {
+		return a.weight < b.weight
+	}
+	return q.items[i] < q.items[j]
+}
+func (q *routeQueue) Swap(i, j int)      { q.items[i], q.items[j] = q.items[j], q.items[i] }
+func (q *routeQueue) Push(x interface{}) { q.items = append(q.items, x.(int)) }
+func (q *routeQueue) Pop() interface{} {
+	n := len(q.items)
+	x := q.items[n-1]
+	q.items = q.items[:n-1]
+	return x
+}
+
+// all starts a depth-first search of all simple routes to target nodes.
+func (f *routeFinder) all(src refs.Ref) *routeDFS {
+	return &routeDFS{
+		f:      f,
+		cur:    Route{Nodes: []refs.Ref{src}},
+		onPath: map[interface{}]struct{}{refs.ToKey(src): {}},
+	}
+}
+
+// routeDFS finds all simple routes from a source node with a depth-first search,
+// and returns each route as soon as it is found.
+type routeDFS struct {
+	f       *routeFinder
+	started bool
+	cur     Route
+	onPath  map[interface{}]struct{}
+	// edges that are not followed yet from each node of the current route
+	edges [][]routeEdge
+}
+
+// enter loads edges of the last node of the current route and reports if the route should be returned.
+func (d *routeDFS) enter(ctx context.Context) (bool, error) {
+	var (
+		n     = d.cur.Target()
+		depth = len(d.cur.Edges)
+		edges []routeEdge
+	)
+	if depth < d.f.r.opt.MaxDepth {
+		var err error
+		edges, err = d.f.edges(ctx, n)
+		if err != nil {
+			return false, err
+		}
+	}
+	d.edges = append(d.edges, edges)
+	return d.f.accept(ctx, routeStep{node: n, depth: depth})
+}
+
+// leave removes the last node from the current route.
+func (d *routeDFS) leave() {
+	last := len(d.cur.Nodes) - 1
+	delete(d.onPath, refs.ToKey(d.cur.Nodes[last]))
+	d.cur.Nodes = d.cur.Nodes[:last]
+	if last > 0 {
+		d.cur.Edges = d.cur.Edges[:last-1]
+	}
+	d.edges = d.edges[:last]
+}
+
+func (d *routeDFS) next(ctx context.Context) (Route, bool, error) {
+	if !d.started {
+		d.started = true
+		if ok, err := d.enter(ctx); err != nil {
+			return Route{}, false, err
+		} else if ok {
+			return d.route(), true, nil
+		}
+	}
+	for len(d.edges) != 0 {
+		if err := ctx.Err(); err != nil {
+			return Route{}, false, err
+		}
+		top := len(d.edges) - 1
+		if len(d.edges[top]) == 0 {
+			// all routes through the last node were returned
+			d.leave()
+			continue
+		}
+		e := d.edges[top][0]
+		d.edges[top] = d.edges[top][1:]
+		key := refs.ToKey(e.node)
+		if _, ok := d.onPath[key]; ok {
+			continue
+		}
+		d.onPath[key] = struct{}{}
+		d.cur.Nodes = append(d.cur.Nodes, e.node)
+		d.cur.Edges = append(d.cur.Edges, e.quad)
+		if ok, err := d.enter(ctx); err != nil {
+			return Route{}, false, err
+		} else if ok {
+			return d.route(), true, nil
+		}
+	}
+	return Route{}, false, nil
+}
+
+// route returns a copy of the current route.
+func (d *routeDFS) route() Route {
+	return Route{
+		Nodes:  append([]refs.Ref{}, d.cur.Nodes...),
+		Edges:  append([]refs.Ref{}, d.cur.Edges...),
+		Weight: float64(len(d.cur.Edges)),
+	}
+}
+
+func (f *routeFinder) Close() error {
+	if f.to != nil {
+		return f.to.Close()
+	}
+	return nil
+}
+
+var _ RouteScanner = (*routesNext)(nil)
+
+// routesNext finds routes for each result of the source iterator in turn.
+type routesNext struct {
+	f      *routeFinder
+	src    Scanner
+	tags   map[string]refs.Ref
+	search routeSearch
+	cur    Route
+	err    error
+}
+
+func newRoutesNext(r *Routes) *routesNext {
+	return &routesNext{
+		f:   newRouteFinder(r),
+		src: r.from.Iterate(),
+	}
+}
+
+func (it *routesNext) TagResults(dst map[string]refs.Ref) {
+	for k, v := range it.tags {
+		dst[k] = v
+	}
+}
+
+func (it *routesNext) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	for {
+		if it.search == nil {
+			if !it.src.Next(ctx) {
+				it.err = it.src.Err()
+				it.cur, it.tags = Route{}, nil
+				return false
+			}
+			it.tags = make(map[string]refs.Ref)
+			it.src.TagResults(it.tags)
+			it.search, it.err = it.f.find(ctx, it.src.Result())
+			if it.err != nil {
+				return false
+			}
+		}
+		r, ok, err := it.search.next(ctx)
+		if err != nil {
+			it.err = err
+			return false
+		} else if ok {
+			it.cur = r
+			return true
+		}
+		it.search = nil
+	}
+}
+
+func (it *routesNext) Err() error {
+	return it.err
+}
+
+func (it *routesNext) Result() refs.Ref {
+	return it.cur.Target()
+}
+
+func (it *routesNext) Route() Route {
+	return it.cur
+}
+
+func (it *routesNext) NextPath(ctx context.Context) bool {
+	return false
+}
+
+func (it *routesNext) Close() error {
+	err := it.src.Close()
+	if err2 := it.f.Close(); err == nil {
+		err = err2
+	}
+	it.search = nil
+	return err
+}
+
+func (it *routesNext) String() string {
+	return fmt.Sprintf("RoutesNext(%v)", it.f.r.kind)
+}
+
+var _ RouteIndex = (*routesContains)(nil)
+
+// routesContains finds all routes and checks if a value is the last node of any of them.
+// Other routes to the same node are returned by NextPath. At most MaxRouteIndexSize routes are loaded.
+type routesContains struct {
+	r      *Routes
+	loaded bool
+	routes map[interface{}][]taggedRoute
+	cur    []taggedRoute
+	index  int
+	err    error
+}
+
+type taggedRoute struct {
+	Route
+	tags map[string]refs.Ref
+}
+
+func newRoutesContains(r *Routes) *routesContains {
+	return &routesContains{r: r}
+}
+
+func (it *routesContains) load(ctx context.Context) error {
+	it.routes = make(map[interface{}][]taggedRoute)
+	sc := newRoutesNext(it.r)
+	defer sc.Close()
+	n := 0
+	for sc.Next(ctx) {
+		if n++; MaxRouteIndexSize > 0 && n > MaxRouteIndexSize {
+			it.routes = nil
+			return ErrTooManyRoutes
+		}
+		tags := make(map[string]refs.Ref)
+		sc.TagResults(tags)
+		key := refs.ToKey(sc.Result())
+		it.routes[key] = append(it.routes[key], taggedRoute{Route: sc.Route(), tags: tags})
+	}
+	return sc.Err()
+}
+
+func (it *routesContains) Contains(ctx context.Context, v refs.Ref) bool {
+	it.cur, it.index = nil, 0
+	if it.err != nil {
+		return false
+	}
+	if !it.loaded {
+		it.loaded = true
+		if it.err = it.load(ctx); it.err != nil {
+			return false
+		}
+	}
+	it.cur = it.routes[refs.ToKey(v)]
+	return len(it.cur) != 0
+}
+
+func (it *routesContains) TagResults(dst map[string]refs.Ref) {
+	if it.index < len(it.cur) {
+		for k, v := range it.cur[it.index].tags {
+			dst[k] = v
+		}
+	}
+}
+
+func (it *routesContains) Err() error {
+	return it.err
+}
+
+func (it *routesContains) Result() refs.Ref {
+	if it.index < len(it.cur) {
+		return it.cur[it.index].Target()
+	}
+	return nil
+}
+
+func (it *routesContains) Route() Route {
+	if it.index < len(it.cur) {
+		return it.cur[it.index].Route
+	}
+	return Route{}
+}
+
+func (it *routesContains) NextPath(ctx context.Context) bool {
+	if it.index+1 >= len(it.cur) {
+		return false
+	}
+	it.index++
+	return true
+}
+
+func (it *routesContains) Close() error {
+	it.routes, it.cur = nil, nil
+	return nil
+}
+
+func (it *routesContains) String() string {
+	return fmt.Sprintf("RoutesContains(%v)", it.r.kind)
+}
@@ -0,0 +1,266 @@
+package iterator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph/refs"
+	"github.com/stretchr/testify/require"
+)
+
+// routesGraph is a minimal quad index for Routes iterator. Quads are referenced by their index.
+type routesGraph []quad.Quad
+
+func (g routesGraph) ValueOf(v quad.Value) refs.Ref {
+	return refs.PreFetched(v)
+}
+
+func (g routesGraph) NameOf(r refs.Ref) quad.Value {
+	if v, ok := r.(refs.PreFetchedValue); ok {
+		return v.NameOf()
+	}
+	return nil
+}
+
+func (g routesGraph) QuadIterator(d quad.Direction, r refs.Ref) Shape {
+	v := g.NameOf(r)
+	it := NewFixed()
+	for i, q := range g {
+		if q.Get(d) == v {
+			it.Add(Int64Quad(i))
+		}
+	}
+	return it
+}
+
+func (g routesGraph) QuadDirection(id refs.Ref, d quad.Direction) refs.Ref {
+	return refs.PreFetched(g[id.(Int64Quad)].Get(d))
+}
+
+var routesQuads = routesGraph{
+	quad.MakeIRI("a", "next", "b", ""),
+	quad.MakeIRI("a", "next", "d", ""),
+	quad.MakeIRI("b", "next", "c", ""),
+	quad.MakeIRI("d", "next", "c", ""),
+	quad.MakeIRI("c", "next", "e", ""),
+	quad.MakeIRI("e", "other", "a", ""),
+	quad.Make(quad.IRI("b"), quad.IRI("cost"), quad.Int(5), nil),
+	quad.Make(quad.IRI("d"), quad.IRI("cost"), quad.Float(0.5), nil),
+}
+
+func routeNodes(ids ...string) Shape {
+	it := NewFixed()
+	for _, id := range ids {
+		it.Add(refs.PreFetched(quad.IRI(id)))
+	}
+	return it
+}
+
+// routeStrings formats routes as "a -next-> b" strings.
+func routeStrings(t testing.TB, it Shape) []string {
+	ctx := context.TODO()
+	sc := it.Iterate().(RouteScanner)
+	defer sc.Close()
+	var out []string
+	for sc.Next(ctx) {
+		r := sc.Route()
+		require.Equal(t, sc.Result(), r.Target())
+		require.Len(t, r.Edges, len(r.Nodes)-1)
+		s := routesQuads.NameOf(r.Nodes[0]).String()
+		for i, e := range r.Edges {
+			s += " -" + routesQuads.NameOf(routesQuads.QuadDirection(e, quad.Predicate)).String() + "-> "
+			s += routesQuads.NameOf(r.Nodes[i+1]).String()
+		}
+		out = append(out, s)
+	}
+	require.NoError(t, sc.Err())
+	return out
+}
+
+func TestRoutes(t *testing.T) {
+	next := []refs.Ref{refs.PreFetched(quad.IRI("next"))}
+	for _, c := range []struct {
+		name   string
+		from   Shape
+		to     Shape
+		kind   RouteKind
+		opt    RouteOptions
+		expect []string
+	}{
+		{
+			name:   "shortest",
+			from:   routeNodes("a"),
+			to:     routeNodes("c"),
+			kind:   RouteShortest,
+			expect: []string{"<a> -<next>-> <b> -<next>-> <c>"},
+		},
+		{
+			name: "shortest weighted",
+			from: routeNodes("a"),
+			to:   routeNodes("c", "b"),
+			kind: RouteShortest,
+			opt:  RouteOptions{Weight: refs.PreFetched(quad.IRI("cost"))},
+			expect: []string{
+				"<a> -<next>-> <d> -<next>-> <c>",
+				"<a> -<next>-> <b>",
+			},
+		},
+		{
+			name: "shortest to itself",
+			from: routeNodes("a"),
+			to:   routeNodes("a"),
+			kind: RouteShortest,
+			expect: []string{
+				"<a>",
+			},
+		},
+		{
+			name: "all paths",
+			from: routeNodes("a"),
+			to:   routeNodes("c"),
+			kind: RouteAll,
+			expect: []string{
+				"<a> -<next>-> <b> -<next>-> <c>",
+				"<a> -<next>-> <d> -<next>-> <c>",
+			},
+		},
+		{
+			name: "k hops",
+			from: routeNodes("a"),
+			kind: RouteAll,
+			opt:  RouteOptions{Via: next, MaxDepth: 2},
+			expect: []string{
+				"<a> -<next>-> <b>",
+				"<a> -<next>-> <b> -<next>-> <c>",
+				"<a> -<next>-> <d>",
+				"<a> -<next>-> <d> -<next>-> <c>",
+			},
+		},
+		{
+			name: "simple paths only",
+			from: routeNodes("c"),
+			to:   routeNodes("a"),
+			kind: RouteAll,
+			expect: []string{
+				"<c> -<next>-> <e> -<other>-> <a>",
+			},
+		},
+		{
+			name: "reverse",
+			from: routeNodes("c"),
+			to:   routeNodes("a"),
+			kind: RouteShortest,
+			opt:  RouteOptions{Via: next, Reverse: true},
+			expect: []string{
+				"<c> -<next>-> <b> -<next>-> <a>",
+			},
+		},
+		{
+			name: "reachable",
+			from: routeNodes("a", "e", "c"),
+			to:   routeNodes("c", "b"),
+			kind: RouteReachable,
+			opt:  RouteOptions{Via: next},
+			expect: []string{
+				"<a> -<next>-> <b>",
+				"<c>",
+			},
+		},
+		{
+			name: "max depth",
+			from: routeNodes("a"),
+			to:   routeNodes("e"),
+			kind: RouteShortest,
+			opt:  RouteOptions{MaxDepth: 2},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			it := NewRoutes(routesQuads, c.from, c.to, c.kind, c.opt)
+			require.Equal(t, c.expect, routeStrings(t, it))
+		})
+	}
+}
+
+func TestRoutesWeightedMaxDepth(t *testing.T) {
+	ctx := context.TODO()
+	g := routesGraph{
+		quad.MakeIRI("s", "next", "a", ""),
+		quad.MakeIRI("a", "next", "b", ""),
+		quad.MakeIRI("b", "next", "m", ""),
+		quad.MakeIRI("s", "next", "h", ""),
+		quad.MakeIRI("h", "next", "m", ""),
+		quad.MakeIRI("m", "next", "t", ""),
+		quad.Make(quad.IRI("h"), quad.IRI("cost"), quad.Int(5), nil),
+	}
+	for _, n := range []string{"a", "b", "m", "t"} {
+		g = append(g, quad.Make(quad.IRI(n), quad.IRI("cost"), quad.Int(0), nil))
+	}
+	// the cheapest route to m is too long to be continued to t
+	it := NewRoutes(g, routeNodes("s"), routeNodes("t"), RouteShortest, RouteOptions{
+		Via:      []refs.Ref{refs.PreFetched(quad.IRI("next"))},
+		MaxDepth: 3,
+		Weight:   refs.PreFetched(quad.IRI("cost")),
+	})
+	sc := it.Iterate().(RouteScanner)
+	defer sc.Close()
+	require.True(t, sc.Next(ctx))
+	var nodes []string
+	for _, n := range sc.Route().Nodes {
+		nodes = append(nodes, g.NameOf(n).String())
+	}
+	require.Equal(t, []string{"<s>", "<h>", "<m>", "<t>"}, nodes)
+	require.Equal(t, 5.0, sc.Route().Weight)
+	require.False(t, sc.Next(ctx))
+	require.NoError(t, sc.Err())
+}
+
+func TestRoutesContains(t *testing.T) {
+	ctx := context.TODO()
+	it := NewRoutes(routesQuads, routeNodes("a"), nil, RouteAll, RouteOptions{MaxDepth: 2})
+	sc := it.Lookup().(RouteIndex)
+	defer sc.Close()
+
+	require.True(t, sc.Contains(ctx, refs.PreFetched(quad.IRI("c"))))
+	n := 1
+	for sc.NextPath(ctx) {
+		n++
+	}
+	require.Equal(t, 2, n)
+	require.Len(t, sc.Route().Nodes, 3)
+
+	require.False(t, sc.Contains(ctx, refs.PreFetched(quad.IRI("e"))))
+	require.NoError(t, sc.Err())
+}
+
+func TestRoutesContainsLimit(t *testing.T) {
+	ctx := context.TODO()
+	defer func(n int) { MaxRouteIndexSize = n }(MaxRouteIndexSize)
+	MaxRouteIndexSize = 2
+
+	// there are 4 routes from a within 2 edges
+	it := NewRoutes(routesQuads, routeNodes("a"), nil, RouteAll, RouteOptions{MaxDepth: 2})
+	sc := it.Lookup()
+	defer sc.Close()
+	require.False(t, sc.Contains(ctx, refs.PreFetched(quad.IRI("c"))))
+	require.Equal(t, ErrTooManyRoutes, sc.Err())
+}
+
+func TestRoutesDefaultDepth(t *testing.T) {
+	it := NewRoutes(routesQuads, routeNodes("a"), nil, RouteAll, RouteOptions{})
+	require.Equal(t, DefaultMaxRouteDepth, it.opt.MaxDepth)
+	it = NewRoutes(routesQuads, routeNodes("a"), nil, RouteShortest, RouteOptions{})
+	require.Equal(t, DefaultMaxRecursiveSteps, it.opt.MaxDepth)
+	it = NewRoutes(routesQuads, routeNodes("a"), nil, RouteAll, RouteOptions{MaxDepth: 10})
+	require.Equal(t, 10, it.opt.MaxDepth)
+}
+
+func TestParseRouteKind(t *testing.T) {
+	for name, kind := range routeNames {
+		got, err := ParseRouteKind(name)
+		require.NoError(t, err)
+		require.Equal(t, kind, got)
+	}
+	_, err := ParseRouteKind("longest")
+	require.Error(t, err)
+}
@@ -33,9 +33,12 @@ type allIterator struct {
 	qs    *QuadStore
 	nodes bool
 	cons  *constraint
+	asOf  int64
 }
 
-func (qs *QuadStore) newAllIterator(nodes bool, cons *constraint) *allIterator {
+// newAllIterator creates an iterator over all nodes or quads.
+// If asOf is not zero, only primitives that existed at this epoch are returned.
+func (qs *QuadStore) newAllIterator(nodes bool, cons *constraint, asOf int64) *allIterator {
 	if nodes && cons != nil {
 		panic("cannot use a kv all iterator across nodes with a constraint")
 	}
@@ -43,11 +46,12 @@ func (qs *QuadStore) newAllIterator(nodes bool, cons *constraint) *allIterator {
 		qs:    qs,
 		nodes: nodes,
 		cons:  cons,
+		asOf:  asOf,
 	}
 }
 
 func (it *allIterator) Iterate() iterator.Scanner {
-	return it.qs.newAllIteratorNext(it.nodes, it.cons)
+	return it.qs.newAllIteratorNext(it.nodes, it.cons, it.asOf)
 }
 
 func (it *allIterator) Lookup() iterator.Index {
@@ -89,9 +93,10 @@ type allIteratorNext struct {
 	qs      *QuadStore
 	err     error
 	cons    *constraint
+	asOf    int64
 }
 
-func (qs *QuadStore) newAllIteratorNext(nodes bool, cons *constraint) *allIteratorNext {
+func (qs *QuadStore) newAllIteratorNext(nodes bool, cons *constraint, asOf int64) *allIteratorNext {
 	if nodes && cons != nil {
 		panic("cannot use a kv all iterator across nodes with a constraint\n")
 	}
@@ -100,6 +105,7 @@ func (qs *QuadStore) newAllIteratorNext(nodes bool, cons *constraint) *allIterat
 		nodes:   nodes,
 		horizon: qs.horizon(context.TODO()),
 		cons:    cons,
+		asOf:    asOf,
 	}
 }
 
@@ -158,7 +164,7 @@ func (it *allIteratorNext) Next(ctx context.Context) bool {
 		for ; len(it.buf) > 0; it.buf = it.buf[1:] {
 			p := it.buf[0]
 			it.prim = p
-			if p == nil || p.Deleted {
+			if p == nil || !p.ExistsAt(it.asOf) {
 				continue
 			}
 			it.id = it.prim.ID
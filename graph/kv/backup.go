@@ -0,0 +1,542 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/hidal-go/hidalgo/kv"
+
+	"github.com/cayleygraph/quad/pquads"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/proto"
+	"github.com/epik-protocol/epik-gateway-backend/internal/lru"
+)
+
+var _ graph.BackupStore = (*QuadStore)(nil)
+
+// backupFormat identifies backups written by the kv quad store.
+const backupFormat = "kv"
+
+// Types of backup records.
+const (
+	// recPair is a raw key-value pair of a full backup.
+	recPair = 'p'
+	// recEpoch starts deltas of an epoch in an incremental backup. It contains the epoch, followed by its chain key.
+	recEpoch = 'e'
+	// recDelta is a delta of the last epoch, encoded as proto.LogDelta.
+	recDelta = 'd'
+	// recMeta is a listener metadata value of an incremental backup, see graph.MetaStore.
+	// It contains the length of the key, the key and the value.
+	recMeta = 'm'
+)
+
+// restoreBatch is the number of key-value pairs written in a single transaction when restoring a full backup.
+const restoreBatch = 10000
+
+// Backup implements graph.BackupStore.
+//
+// A full backup contains all key-value pairs of the database read in a single transaction, except for locks.
+// An incremental backup contains deltas recorded to quads added or deleted in the epoch range,
+// chain keys of these epochs, and cid references to existing quads found in undo logs of the range.
+// Listener metadata is only included if the range ends at the latest epoch, since older values are not kept.
+func (qs *QuadStore) Backup(ctx context.Context, w io.Writer, opts graph.BackupOptions) (*graph.BackupInfo, error) {
+	if opts.From < 0 || opts.To < 0 {
+		return nil, errors.New("backup: epochs must not be negative")
+	} else if opts.From == 0 && opts.To != 0 {
+		return nil, errors.New("backup: last epoch can only be set for incremental backups")
+	}
+	tx, err := qs.db.Tx(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Close()
+	tx = wrapTx(tx)
+
+	cur, err := qs.getMetaIntTx(ctx, tx, "epoch")
+	if err != nil && err != kv.ErrNotFound {
+		return nil, err
+	}
+	info := &graph.BackupInfo{Format: backupFormat, Epoch: cur, Created: time.Now().UTC()}
+	if opts.From > 0 {
+		to := opts.To
+		if to == 0 {
+			to = cur
+		} else if to > cur {
+			return nil, graph.ErrEpochNotExist
+		}
+		if opts.From >= to {
+			return nil, fmt.Errorf("backup: no epochs to backup after %d, last epoch is %d", opts.From, to)
+		}
+		info.From, info.Epoch = opts.From, to
+	}
+	bw, err := graph.NewBackupWriter(w, info)
+	if err != nil {
+		return nil, err
+	}
+	if info.Incremental() {
+		err = qs.backupDeltas(ctx, tx, bw, info.From, info.Epoch)
+		if err == nil && info.Epoch == cur {
+			err = qs.backupMeta(ctx, tx, bw)
+		}
+	} else {
+		err = qs.backupPairs(ctx, tx, bw)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = bw.Close(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// isLockKey checks if the key belongs to a lock, see LockStore.
+// Locks are bound to running processes, thus they are not included into backups.
+func isLockKey(k kv.Key) bool {
+	return len(k) == 2 && bytes.Equal(k[0], metaBucket[0]) && bytes.HasPrefix(k[1], []byte("lock/"))
+}
+
+func (qs *QuadStore) backupPairs(ctx context.Context, tx kv.Tx, bw *graph.BackupWriter) error {
+	var (
+		buf []byte
+		tmp [binary.MaxVarintLen64]byte
+	)
+	it := tx.Scan(nil)
+	defer it.Close()
+	for it.Next(ctx) {
+		k := it.Key()
+		if isLockKey(k) || isFilterKey(k) {
+			continue
+		}
+		n := binary.PutUvarint(tmp[:], uint64(len(k)))
+		buf = append(buf[:0], tmp[:n]...)
+		for _, p := range k {
+			n = binary.PutUvarint(tmp[:], uint64(len(p)))
+			buf = append(buf, tmp[:n]...)
+			buf = append(buf, p...)
+		}
+		buf = append(buf, it.Val()...)
+		if err := bw.WriteRecord(recPair, buf); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+func decodePair(b []byte) (kv.Key, kv.Value, error) {
+	n, sz := binary.Uvarint(b)
+	if sz <= 0 {
+		return nil, nil, graph.ErrBackupFormat
+	}
+	b = b[sz:]
+	key := make(kv.Key, 0, n)
+	for i := uint64(0); i < n; i++ {
+		l, sz := binary.Uvarint(b)
+		if sz <= 0 || uint64(len(b)-sz) < l {
+			return nil, nil, graph.ErrBackupFormat
+		}
+		b = b[sz:]
+		key = append(key, b[:l:l])
+		b = b[l:]
+	}
+	return key, b, nil
+}
+
+// epochDeltas collects deltas of an epoch written to an incremental backup.
+type epochDeltas struct {
+	key []byte
+	// deleted quads are written first, so quads deleted and added again in the same epoch are restored correctly
+	del, add []*proto.LogDelta
+}
+
+func (qs *QuadStore) backupDeltas(ctx context.Context, tx kv.Tx, bw *graph.BackupWriter, from, to int64) error {
+	epochs := make(map[int64]*epochDeltas)
+	get := func(e int64) *epochDeltas {
+		d := epochs[e]
+		if d == nil {
+			d = &epochDeltas{}
+			epochs[e] = d
+		}
+		return d
+	}
+	inRange := func(e int64) bool {
+		return e > from && e <= to
+	}
+	keys, err := epochsFrom(ctx, tx, epochIndex, from+1)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if e := int64(quadKeyEnc.Uint64(k[1])); inRange(e) {
+			val, err := tx.Get(ctx, k)
+			if err != nil {
+				return err
+			}
+			get(e).key = append([]byte{}, val...)
+		}
+	}
+
+	// quads added in the range, by id
+	added := make(map[uint64]addedQuad)
+	it := tx.Scan(logIndex)
+	defer it.Close()
+	for it.Next(ctx) {
+		var p proto.Primitive
+		if err := p.Unmarshal(it.Val()); err != nil {
+			return err
+		}
+		if p.IsNode() || (p.Deleted && p.DeletedEpoch <= p.AddedEpoch) {
+			// quads hidden from the history are never visible
+			continue
+		}
+		isAdded, isDeleted := inRange(p.AddedEpoch), p.Deleted && inRange(p.DeletedEpoch)
+		if !isAdded && !isDeleted {
+			continue
+		}
+		q, err := qs.primitiveToQuad(ctx, tx, &p)
+		if err != nil {
+			return err
+		}
+		pq := pquads.MakeQuad(q)
+		if isAdded {
+			d := &proto.LogDelta{ID: p.ID, Quad: pq, Action: int32(graph.Add), Timestamp: p.Timestamp}
+			added[p.ID] = addedQuad{d: d, epoch: p.AddedEpoch}
+			get(p.AddedEpoch).add = append(get(p.AddedEpoch).add, d)
+		}
+		if isDeleted {
+			d := &proto.LogDelta{ID: p.ID, Quad: pq, Action: int32(graph.Delete), Timestamp: p.Timestamp}
+			get(p.DeletedEpoch).del = append(get(p.DeletedEpoch).del, d)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	if err := qs.backupCids(ctx, tx, added, get); err != nil {
+		return err
+	}
+	if err := qs.backupCidRefs(ctx, tx, from, to, get); err != nil {
+		return err
+	}
+
+	list := make([]int64, 0, len(epochs))
+	for e := range epochs {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	for _, e := range list {
+		d := epochs[e]
+		buf := make([]byte, 8, 8+len(d.key))
+		quadKeyEnc.PutUint64(buf, uint64(e))
+		if err := bw.WriteRecord(recEpoch, append(buf, d.key...)); err != nil {
+			return err
+		}
+		for _, ld := range append(d.del, d.add...) {
+			data, err := ld.Marshal()
+			if err != nil {
+				return err
+			}
+			if err = bw.WriteRecord(recDelta, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// backupMeta writes listener metadata values of an incremental backup.
+func (qs *QuadStore) backupMeta(ctx context.Context, tx kv.Tx, bw *graph.BackupWriter) error {
+	var (
+		buf []byte
+		tmp [binary.MaxVarintLen64]byte
+	)
+	it := tx.Scan(metaBucket.AppendBytes(listenerMetaPrefix))
+	defer it.Close()
+	for it.Next(ctx) {
+		k := it.Key()
+		key := k[len(k)-1][len(listenerMetaPrefix):]
+		n := binary.PutUvarint(tmp[:], uint64(len(key)))
+		buf = append(buf[:0], tmp[:n]...)
+		buf = append(buf, key...)
+		buf = append(buf, it.Val()...)
+		if err := bw.WriteRecord(recMeta, buf); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// addedQuad is a delta of a quad added in the range of an incremental backup.
+type addedQuad struct {
+	d     *proto.LogDelta
+	epoch int64
+}
+
+// backupCids sets cids of added quads. Quads referenced by multiple cids are added once per cid.
+func (qs *QuadStore) backupCids(ctx context.Context, tx kv.Tx, added map[uint64]addedQuad, get func(int64) *epochDeltas) error {
+	if len(added) == 0 {
+		return nil
+	}
+	cids := make(map[uint64][]string)
+	it := tx.Scan(cidIndex)
+	defer it.Close()
+	for it.Next(ctx) {
+		v := it.Val()
+		if len(v) != 8 {
+			continue
+		}
+		id := binary.LittleEndian.Uint64(v)
+		if _, ok := added[id]; !ok {
+			continue
+		}
+		// see cidKey and legacyCidKey
+		k := it.Key()
+		cid := k[len(k)-1]
+		if n := len(cid) - 9; n >= 0 && cid[n] == 0 && quadKeyEnc.Uint64(cid[n+1:]) == id {
+			cid = cid[:n]
+		}
+		cids[id] = append(cids[id], string(cid))
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	for id, list := range cids {
+		a := added[id]
+		a.d.Cid = list[0]
+		for _, cid := range list[1:] {
+			ed := get(a.epoch)
+			ed.add = append(ed.add, &proto.LogDelta{ID: id, Quad: a.d.Quad, Action: a.d.Action, Timestamp: a.d.Timestamp, Cid: cid})
+		}
+	}
+	return nil
+}
+
+// backupCidRefs adds cid references to existing quads recorded to undo logs of the range.
+// Undo logs of pruned epochs are no longer available, thus such references are lost.
+func (qs *QuadStore) backupCidRefs(ctx context.Context, tx kv.Tx, from, to int64, get func(int64) *epochDeltas) error {
+	keys, err := epochsFrom(ctx, tx, undoIndex, from+1)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		e := int64(quadKeyEnc.Uint64(k[1][:8]))
+		if e > to {
+			break
+		}
+		val, err := tx.Get(ctx, k)
+		if err != nil {
+			return err
+		}
+		var undo undoLog
+		if err = undo.unmarshal(val); err != nil {
+			return err
+		}
+		for _, d := range undo.deltas {
+			if d.Quad != nil || d.Cid == "" {
+				continue
+			}
+			p, err := qs.getPrimitiveFromLog(ctx, tx, d.ID)
+			if err == kv.ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			q, err := qs.primitiveToQuad(ctx, tx, p)
+			if err != nil {
+				return err
+			}
+			ed := get(e)
+			ed.add = append(ed.add, &proto.LogDelta{ID: d.ID, Quad: pquads.MakeQuad(q), Action: int32(graph.Add), Cid: d.Cid})
+		}
+	}
+	return nil
+}
+
+// Restore implements graph.BackupStore.
+func (qs *QuadStore) Restore(ctx context.Context, r io.Reader) (*graph.BackupInfo, error) {
+	br, err := graph.NewBackupReader(r)
+	if err != nil {
+		return nil, err
+	}
+	info := br.Info()
+	if info.Format != backupFormat {
+		return nil, graph.ErrBackupFormat
+	}
+	if info.Incremental() {
+		err = qs.restoreDeltas(ctx, br)
+	} else {
+		err = qs.restorePairs(ctx, br)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (qs *QuadStore) restorePairs(ctx context.Context, br *graph.BackupReader) error {
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		for _, key := range []string{"horizon", "size", "epoch"} {
+			v, err := qs.getMetaIntTx(ctx, tx, key)
+			if err == kv.ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			} else if v != 0 {
+				return graph.ErrRestoreNotEmpty
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var (
+		tx kv.Tx
+		n  int
+	)
+	defer func() {
+		if tx != nil {
+			tx.Close()
+		}
+	}()
+	for br.Next() {
+		typ, data := br.Record()
+		if typ != recPair {
+			return graph.ErrBackupFormat
+		}
+		key, val, err := decodePair(data)
+		if err != nil {
+			return err
+		}
+		if tx == nil {
+			if tx, err = qs.db.Tx(true); err != nil {
+				return err
+			}
+			tx = wrapTx(tx)
+		}
+		if err = tx.Put(key, val); err != nil {
+			return err
+		}
+		if n++; n%restoreBatch == 0 {
+			err = tx.Commit(ctx)
+			tx = nil
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err = br.Err(); err != nil {
+		return err
+	}
+	if tx != nil {
+		err = tx.Commit(ctx)
+		tx = nil
+		if err != nil {
+			return err
+		}
+	}
+	return qs.reload(ctx)
+}
+
+// reload resets in-memory state of the quad store after the database was replaced.
+func (qs *QuadStore) reload(ctx context.Context) error {
+	list, err := qs.readIndexesMeta(ctx)
+	if err != nil {
+		return err
+	}
+	qs.indexes.Lock()
+	qs.indexes.all = list
+	qs.indexes.exists = nil
+	qs.indexes.Unlock()
+	qs.valueLRU = lru.New(2000)
+	qs.mapBloom = nil
+	qs.mapNodes = nil
+	if err := kv.Update(ctx, qs.db, qs.invalidateFilters); err != nil {
+		return err
+	}
+	return qs.initBloomFilter(ctx)
+}
+
+func (qs *QuadStore) restoreDeltas(ctx context.Context, br *graph.BackupReader) error {
+	info := br.Info()
+	cur, err := qs.getMetaInt(ctx, "epoch")
+	if err != nil && err != ErrNoBucket {
+		return err
+	}
+	if cur != info.From {
+		return fmt.Errorf("backup: incremental backup starts after epoch %d, but the database is at epoch %d", info.From, cur)
+	}
+	var (
+		epoch  int64
+		key    []byte
+		deltas []graph.Delta
+		meta   map[string][]byte
+	)
+	flush := func() error {
+		if epoch == 0 {
+			return nil
+		}
+		if err := qs.ApplyDeltas(epoch, deltas, graph.IgnoreOpts{IgnoreDup: true}); err != nil {
+			return fmt.Errorf("backup: cannot apply epoch %d: %v", epoch, err)
+		}
+		if len(key) != 0 {
+			return qs.SetEpochKey(ctx, epoch, key)
+		}
+		return nil
+	}
+	for br.Next() {
+		typ, data := br.Record()
+		switch typ {
+		case recEpoch:
+			if len(data) < 8 {
+				return graph.ErrBackupFormat
+			}
+			if err = flush(); err != nil {
+				return err
+			}
+			epoch = int64(quadKeyEnc.Uint64(data[:8]))
+			key, deltas = data[8:], deltas[:0]
+		case recDelta:
+			if epoch == 0 {
+				return graph.ErrBackupFormat
+			}
+			var d proto.LogDelta
+			if err = d.Unmarshal(data); err != nil {
+				return err
+			} else if d.Quad == nil {
+				return graph.ErrBackupFormat
+			}
+			deltas = append(deltas, graph.Delta{Quad: d.Quad.ToNative(), Action: graph.Procedure(d.Action), Cid: d.Cid})
+		case recMeta:
+			n, sz := binary.Uvarint(data)
+			if sz <= 0 || uint64(len(data)-sz) < n {
+				return graph.ErrBackupFormat
+			}
+			if meta == nil {
+				meta = make(map[string][]byte)
+			}
+			data = data[sz:]
+			meta[string(data[:n])] = append([]byte{}, data[n:]...)
+		default:
+			return graph.ErrBackupFormat
+		}
+	}
+	if err = br.Err(); err != nil {
+		return err
+	}
+	if err = flush(); err != nil {
+		return err
+	}
+	if epoch != info.Epoch || len(meta) != 0 {
+		// trailing epochs have no changes, metadata is written with the last epoch
+		return qs.applyEpoch(info.Epoch, nil, graph.IgnoreOpts{}, meta)
+	}
+	return nil
+}
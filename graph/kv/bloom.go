@@ -0,0 +1,523 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/crc64"
+	"hash/fnv"
+	"math"
+
+	"github.com/hidal-go/hidalgo/kv"
+
+	"github.com/epik-protocol/epik-gateway-backend/clog"
+)
+
+const bloomVersion = 2
+
+const (
+	// filterChunkSize is the size of values a serialized filter is split into.
+	filterChunkSize = 1 << 20
+	// filterChunksPerTx is the number of chunks written in a single transaction.
+	filterChunksPerTx = 8
+)
+
+var (
+	keyMetaFilters    = metaBucket.AppendBytes([]byte("filters"))
+	filterChunkPrefix = []byte("filters/")
+)
+
+// bloomFilter is a bloom filter that can be checkpointed to the database, see saveFilters.
+//
+// If regions are set, it is a deletable bloom filter (Rothenberg et al.): bits are split into regions,
+// and a collision bit is recorded for each region, so values can be removed from regions where no collisions occurred.
+type bloomFilter struct {
+	k          uint32
+	m          uint64 // number of bits, excluding collision bits
+	bits       []uint64
+	regionSize uint64
+	collisions []uint64
+}
+
+func bloomSizes(n uint, fpRate float64) (m uint64, k uint32) {
+	ln2 := math.Log(2)
+	m = uint64(math.Ceil(float64(n) * math.Abs(math.Log(fpRate)) / (ln2 * ln2)))
+	k = uint32(math.Ceil(math.Log2(1 / fpRate)))
+	return m, k
+}
+
+// newBloomFilter creates a bloom filter for n values with a given rate of false positives.
+func newBloomFilter(n uint, fpRate float64) *bloomFilter {
+	m, k := bloomSizes(n, fpRate)
+	return &bloomFilter{k: k, m: m, bits: make([]uint64, (m+63)/64)}
+}
+
+// newDeletableBloomFilter creates a deletable bloom filter for n values with r regions and a given rate of false positives.
+func newDeletableBloomFilter(n, r uint, fpRate float64) *bloomFilter {
+	m, k := bloomSizes(n, fpRate)
+	m -= uint64(r)
+	size := (m + uint64(r) - 1) / uint64(r)
+	return &bloomFilter{
+		k: k, m: m, bits: make([]uint64, (m+63)/64),
+		regionSize: size,
+		collisions: make([]uint64, ((m+size-1)/size+63)/64),
+	}
+}
+
+func getBit(b []uint64, i uint64) bool { return b[i/64]&(1<<(i%64)) != 0 }
+func setBit(b []uint64, i uint64)      { b[i/64] |= 1 << (i % 64) }
+func clearBit(b []uint64, i uint64)    { b[i/64] &^= 1 << (i % 64) }
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// hashes returns two hashes of the value for double hashing. The second hash is independent of the first one,
+// and it is odd, thus it is never zero and probes do not repeat early in filters with a power of two bits.
+func (f *bloomFilter) hashes(data []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64(), crc64.Checksum(data, crc64Table) | 1
+}
+
+// Add adds the value to the filter.
+func (f *bloomFilter) Add(data []byte) {
+	h1, h2 := f.hashes(data)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.collisions != nil && getBit(f.bits, bit) {
+			setBit(f.collisions, bit/f.regionSize)
+		}
+		setBit(f.bits, bit)
+	}
+}
+
+// Test checks if the value may be in the filter. False positives are possible, false negatives are not.
+func (f *bloomFilter) Test(data []byte) bool {
+	h1, h2 := f.hashes(data)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		if !getBit(f.bits, (h1+i*h2)%f.m) {
+			return false
+		}
+	}
+	return true
+}
+
+// TestAndRemove checks if the value may be in the filter and removes it from regions without collisions.
+// It must only be called for deletable filters.
+func (f *bloomFilter) TestAndRemove(data []byte) bool {
+	if !f.Test(data) {
+		return false
+	}
+	h1, h2 := f.hashes(data)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % f.m
+		if !getBit(f.collisions, bit/f.regionSize) {
+			clearBit(f.bits, bit)
+		}
+	}
+	return true
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+//
+// Words of the filter are encoded sparsely, as the number of zero words before each non-zero word,
+// thus filters of small stores are small as well.
+func (f *bloomFilter) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 21, 21+2*binary.MaxVarintLen64)
+	buf[0] = bloomVersion
+	binary.LittleEndian.PutUint32(buf[1:], f.k)
+	binary.LittleEndian.PutUint64(buf[5:], f.m)
+	binary.LittleEndian.PutUint64(buf[13:], f.regionSize)
+	var tmp [binary.MaxVarintLen64]byte
+	for _, words := range [][]uint64{f.bits, f.collisions} {
+		n := 0
+		for _, w := range words {
+			if w != 0 {
+				n++
+			}
+		}
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(n))]...)
+		zeros := uint64(0)
+		for _, w := range words {
+			if w == 0 {
+				zeros++
+				continue
+			}
+			buf = append(buf, tmp[:binary.PutUvarint(tmp[:], zeros)]...)
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], w)
+			buf = append(buf, b[:]...)
+			zeros = 0
+		}
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (f *bloomFilter) UnmarshalBinary(buf []byte) error {
+	if len(buf) < 21 {
+		return errors.New("bloom: filter is truncated")
+	} else if buf[0] != bloomVersion {
+		return errors.New("bloom: unsupported filter version")
+	}
+	k := binary.LittleEndian.Uint32(buf[1:])
+	m := binary.LittleEndian.Uint64(buf[5:])
+	regionSize := binary.LittleEndian.Uint64(buf[13:])
+	if k == 0 || m == 0 {
+		return errors.New("bloom: invalid filter size")
+	}
+	*f = bloomFilter{k: k, m: m, regionSize: regionSize, bits: make([]uint64, (m+63)/64)}
+	if regionSize != 0 {
+		f.collisions = make([]uint64, ((m+regionSize-1)/regionSize+63)/64)
+	}
+	buf = buf[21:]
+	for _, words := range [][]uint64{f.bits, f.collisions} {
+		n, sz := binary.Uvarint(buf)
+		if sz <= 0 {
+			return errors.New("bloom: filter is truncated")
+		}
+		buf = buf[sz:]
+		i := uint64(0)
+		for ; n > 0; n-- {
+			zeros, sz := binary.Uvarint(buf)
+			if sz <= 0 || len(buf) < sz+8 {
+				return errors.New("bloom: filter is truncated")
+			}
+			i += zeros
+			if i >= uint64(len(words)) {
+				return errors.New("bloom: filter size mismatch")
+			}
+			words[i] = binary.LittleEndian.Uint64(buf[sz:])
+			buf = buf[sz+8:]
+			i++
+		}
+	}
+	if len(buf) != 0 {
+		return errors.New("bloom: filter size mismatch")
+	}
+	return nil
+}
+
+// filterBlob is a serialized filter stored in chunks.
+type filterBlob struct {
+	Name   string `json:"name"`
+	Chunks int    `json:"chunks"`
+	Size   int    `json:"size"`
+	CRC    uint32 `json:"crc"`
+}
+
+// filterCheckpoint is the header of filters checkpointed to the metadata. Chunks of filters are only valid
+// together with the header, and the header is only valid if counters in the metadata match the ones it was written at.
+type filterCheckpoint struct {
+	Epoch   int64 `json:"epoch"`
+	Horizon int64 `json:"horizon"`
+	Size    int64 `json:"size"`
+	// Exists is the filter of existing quads.
+	Exists *filterBlob `json:"exists"`
+	// Bulk is set if bulk load filters were enabled.
+	Bulk bool `json:"bulk,omitempty"`
+	// Nodes is the bulk load filter of nodes.
+	Nodes *filterBlob `json:"nodes,omitempty"`
+	// Buckets are bulk load filters of index buckets.
+	Buckets map[string]*filterBlob `json:"buckets,omitempty"`
+}
+
+func filterChunkKey(name string, i int) kv.Key {
+	k := make([]byte, 0, len(filterChunkPrefix)+len(name)+1+4)
+	k = append(k, filterChunkPrefix...)
+	k = append(k, name...)
+	k = append(k, '/', 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(k[len(k)-4:], uint32(i))
+	return metaBucket.AppendBytes(k)
+}
+
+// isFilterKey checks if the key belongs to the filter checkpoint. Filters are derived from quads,
+// thus they are not included into backups.
+func isFilterKey(k kv.Key) bool {
+	return len(k) == 2 && bytes.Equal(k[0], metaBucket[0]) &&
+		(bytes.Equal(k[1], keyMetaFilters[1]) || bytes.HasPrefix(k[1], filterChunkPrefix))
+}
+
+// filterState returns metadata counters the filter checkpoint is bound to.
+func (qs *QuadStore) filterState(ctx context.Context, tx kv.Tx) (epoch, horizon, size int64, err error) {
+	for _, m := range []struct {
+		key string
+		val *int64
+	}{
+		{"epoch", &epoch},
+		{"horizon", &horizon},
+		{"size", &size},
+	} {
+		*m.val, err = qs.getMetaIntTx(ctx, tx, m.key)
+		if err == kv.ErrNotFound {
+			err = nil
+		} else if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// invalidateFilters removes the filter checkpoint in a given transaction. It must be called before writes
+// that change in-memory filters, so the checkpoint is never loaded for a state of the database it does not match.
+func (qs *QuadStore) invalidateFilters(tx kv.Tx) error {
+	qs.filters.mu.Lock()
+	qs.filters.gen++
+	stored := qs.filters.stored
+	qs.filters.saved, qs.filters.stored = false, false
+	qs.filters.mu.Unlock()
+	if !stored {
+		return nil
+	}
+	return tx.Del(keyMetaFilters)
+}
+
+// loadFilters loads checkpointed filters. It returns false if there is no valid checkpoint,
+// thus filters must be rebuilt.
+func (qs *QuadStore) loadFilters(ctx context.Context) (bool, error) {
+	var (
+		cp                   filterCheckpoint
+		epoch, horizon, size int64
+	)
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		data, err := tx.Get(ctx, keyMetaFilters)
+		if err == kv.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		qs.filters.mu.Lock()
+		qs.filters.stored = true
+		qs.filters.mu.Unlock()
+		if err = json.Unmarshal(data, &cp); err != nil {
+			clog.Warningf("kv: cannot decode filter checkpoint: %v", err)
+			cp = filterCheckpoint{}
+			return nil
+		}
+		epoch, horizon, size, err = qs.filterState(ctx, tx)
+		return err
+	})
+	if err != nil || cp.Exists == nil {
+		return false, err
+	}
+	if cp.Epoch != epoch || cp.Horizon != horizon || cp.Size != size {
+		clog.Infof("kv: filter checkpoint at epoch %d does not match epoch %d, rebuilding filters", cp.Epoch, epoch)
+		return false, nil
+	}
+	exists, err := qs.loadFilter(ctx, cp.Exists)
+	if err != nil {
+		clog.Warningf("kv: cannot load filter checkpoint: %v", err)
+		return false, nil
+	}
+	var (
+		nodes *bloomFilter
+		bulk  map[string]*bloomFilter
+	)
+	if cp.Nodes != nil {
+		if nodes, err = qs.loadFilter(ctx, cp.Nodes); err != nil {
+			clog.Warningf("kv: cannot load filter checkpoint: %v", err)
+			return false, nil
+		}
+	}
+	if cp.Bulk {
+		bulk = make(map[string]*bloomFilter, len(cp.Buckets))
+		for b, blob := range cp.Buckets {
+			if bulk[b], err = qs.loadFilter(ctx, blob); err != nil {
+				clog.Warningf("kv: cannot load filter checkpoint: %v", err)
+				return false, nil
+			}
+		}
+	}
+	qs.exists.buf = make([]byte, 3*8)
+	qs.exists.bloomFilter = exists
+	qs.mapBloom, qs.mapNodes = bulk, nodes
+	qs.filters.mu.Lock()
+	qs.filters.saved = true
+	qs.filters.mu.Unlock()
+	return true, nil
+}
+
+func (qs *QuadStore) loadFilter(ctx context.Context, blob *filterBlob) (*bloomFilter, error) {
+	keys := make([]kv.Key, blob.Chunks)
+	for i := range keys {
+		keys[i] = filterChunkKey(blob.Name, i)
+	}
+	data := make([]byte, 0, blob.Size)
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		vals, err := tx.GetBatch(ctx, keys)
+		if err != nil {
+			return err
+		}
+		for i, v := range vals {
+			if v == nil {
+				return fmt.Errorf("chunk %d of filter %q is missing", i, blob.Name)
+			}
+			data = append(data, v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	} else if len(data) != blob.Size || crc32.ChecksumIEEE(data) != blob.CRC {
+		return nil, fmt.Errorf("filter %q is corrupted", blob.Name)
+	}
+	f := &bloomFilter{}
+	if err = f.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// saveFilters checkpoints in-memory filters to the metadata, unless they are already saved.
+//
+// Filters are serialized with writes blocked, and written to the database while writes proceed.
+// The previous checkpoint is removed first, and the header is written last, after all chunks.
+// If a write invalidates filters in the meantime, the header is removed again.
+func (qs *QuadStore) saveFilters(ctx context.Context) error {
+	qs.filters.save.Lock()
+	defer qs.filters.save.Unlock()
+	qs.writer.Lock()
+	cp, blobs, gen, err := qs.serializeFilters(ctx)
+	qs.writer.Unlock()
+	if err != nil || cp == nil {
+		return err
+	}
+	var ops []compactOp
+	err = kv.View(qs.db, func(tx kv.Tx) error {
+		it := tx.Scan(metaBucket.AppendBytes(filterChunkPrefix))
+		defer it.Close()
+		for it.Next(ctx) {
+			ops = append(ops, compactOp{key: it.Key().Clone()})
+		}
+		return it.Err()
+	})
+	if err != nil {
+		return err
+	} else if err = qs.writeCompactOps(ctx, ops); err != nil {
+		return err
+	}
+	for name, data := range blobs {
+		if err = qs.saveFilter(ctx, name, data); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	err = kv.Update(ctx, qs.db, func(tx kv.Tx) error {
+		// the header is marked as stored before it is written, thus a concurrent write removes it
+		qs.filters.mu.Lock()
+		valid := qs.filters.gen == gen
+		qs.filters.stored = qs.filters.stored || valid
+		qs.filters.mu.Unlock()
+		if !valid {
+			return nil
+		}
+		return tx.Put(keyMetaFilters, data)
+	})
+	if err != nil {
+		return err
+	}
+	qs.filters.mu.Lock()
+	valid := qs.filters.gen == gen
+	qs.filters.saved = valid
+	qs.filters.mu.Unlock()
+	if valid {
+		return nil
+	}
+	return kv.Update(ctx, qs.db, func(tx kv.Tx) error {
+		return tx.Del(keyMetaFilters)
+	})
+}
+
+// serializeFilters removes the previous checkpoint and serializes in-memory filters. It returns a header
+// of the new checkpoint and serialized filters by their names, or nil if filters are already saved.
+// It must be called with writes blocked, thus filters match the returned generation of the checkpoint.
+func (qs *QuadStore) serializeFilters(ctx context.Context) (*filterCheckpoint, map[string][]byte, uint64, error) {
+	qs.filters.mu.Lock()
+	saved := qs.filters.saved
+	qs.filters.mu.Unlock()
+	if qs.exists.disabled || qs.filters.disabled || saved {
+		return nil, nil, 0, nil
+	}
+	cp := &filterCheckpoint{Bulk: qs.mapBloom != nil}
+	err := kv.View(qs.db, func(tx kv.Tx) (err error) {
+		cp.Epoch, cp.Horizon, cp.Size, err = qs.filterState(ctx, tx)
+		return err
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if err = kv.Update(ctx, qs.db, qs.invalidateFilters); err != nil {
+		return nil, nil, 0, err
+	}
+	qs.filters.mu.Lock()
+	gen := qs.filters.gen
+	qs.filters.mu.Unlock()
+
+	blobs := make(map[string][]byte, 2+len(qs.mapBloom))
+	add := func(name string, f *bloomFilter) (*filterBlob, error) {
+		data, err := f.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		blobs[name] = data
+		return newFilterBlob(name, data), nil
+	}
+	qs.exists.Lock()
+	cp.Exists, err = add("exists", qs.exists.bloomFilter)
+	qs.exists.Unlock()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if qs.mapNodes != nil {
+		if cp.Nodes, err = add("nodes", qs.mapNodes); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	for b, f := range qs.mapBloom {
+		if cp.Buckets == nil {
+			cp.Buckets = make(map[string]*filterBlob, len(qs.mapBloom))
+		}
+		if cp.Buckets[b], err = add("map/"+b, f); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	return cp, blobs, gen, nil
+}
+
+func newFilterBlob(name string, data []byte) *filterBlob {
+	return &filterBlob{
+		Name:   name,
+		Size:   len(data),
+		CRC:    crc32.ChecksumIEEE(data),
+		Chunks: (len(data) + filterChunkSize - 1) / filterChunkSize,
+	}
+}
+
+// saveFilter writes chunks of a serialized filter.
+func (qs *QuadStore) saveFilter(ctx context.Context, name string, data []byte) error {
+	chunks := (len(data) + filterChunkSize - 1) / filterChunkSize
+	for i := 0; i < chunks; i += filterChunksPerTx {
+		err := kv.Update(ctx, qs.db, func(tx kv.Tx) error {
+			for j := i; j < i+filterChunksPerTx && j < chunks; j++ {
+				chunk := data[j*filterChunkSize:]
+				if len(chunk) > filterChunkSize {
+					chunk = chunk[:filterChunkSize]
+				}
+				if err := tx.Put(filterChunkKey(name, j), chunk); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package kv
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	for _, deletable := range []bool{false, true} {
+		f := newBloomFilter(1000, 0.01)
+		if deletable {
+			f = newDeletableBloomFilter(1000, 1000, 0.01)
+		}
+		for i := 0; i < 100; i++ {
+			f.Add([]byte(strconv.Itoa(i)))
+		}
+		for i := 0; i < 100; i++ {
+			if !f.Test([]byte(strconv.Itoa(i))) {
+				t.Fatalf("value %d is missing", i)
+			}
+		}
+		data, err := f.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var f2 bloomFilter
+		if err = f2.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			if !f2.Test([]byte(strconv.Itoa(i))) {
+				t.Fatalf("value %d is missing after decoding", i)
+			}
+		}
+		for i := 100; i < 1000; i++ {
+			f.Add([]byte(strconv.Itoa(i)))
+		}
+		// false positive rate of a full filter stays close to the configured one
+		const tests = 100000
+		fp := 0
+		for i := 0; i < tests; i++ {
+			if f.Test([]byte("x" + strconv.Itoa(i))) {
+				fp++
+			}
+		}
+		if rate := float64(fp) / tests; rate > 0.02 {
+			t.Fatalf("false positive rate is too high: %v", rate)
+		}
+		if err = f2.UnmarshalBinary(data[:len(data)-1]); err == nil {
+			t.Fatal("expected an error for a truncated filter")
+		}
+		if !deletable {
+			continue
+		}
+		removed := 0
+		for i := 0; i < 100; i++ {
+			if f.TestAndRemove([]byte(strconv.Itoa(i))) && !f.Test([]byte(strconv.Itoa(i))) {
+				removed++
+			}
+		}
+		if removed == 0 {
+			t.Fatal("no values were removed")
+		}
+	}
+}
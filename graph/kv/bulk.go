@@ -0,0 +1,364 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hidal-go/hidalgo/kv"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	graphlog "github.com/epik-protocol/epik-gateway-backend/graph/log"
+	"github.com/epik-protocol/epik-gateway-backend/graph/proto"
+	"github.com/epik-protocol/epik-gateway-backend/graph/refs"
+)
+
+var _ graph.BulkLoader = (*QuadStore)(nil)
+
+// bulkFlushQuads is the number of quads written by a bulk load in a single transaction.
+const bulkFlushQuads = quad.DefaultBatch * 20
+
+var errBulkClosed = errors.New("kv: bulk writer is closed")
+
+// NewBulkWriter implements graph.BulkLoader.
+//
+// The load runs in a pipeline of stages:
+//   - values of each batch are hashed by a pool of workers;
+//   - a single goroutine resolves node ids, creates new nodes, skips existing quads and allocates quad ids,
+//     in the order of batches, while holding the writer lock;
+//   - keys of all quad indexes and log entries of new quads are generated by the same pool of workers,
+//     and sorted into a run for each batch;
+//   - sorted runs are merged and written into the database every bulkFlushQuads quads.
+//
+// Only node resolution and writes run on a single goroutine, while other stages process the following batches.
+func (qs *QuadStore) NewBulkWriter(opts graph.BulkOptions) (quad.WriteCloser, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	w := &bulkWriter{
+		qs:    qs,
+		jobs:  make(chan func(), workers*2),
+		order: make(chan chan *graphlog.Deltas, workers*2),
+		stop:  make(chan struct{}),
+	}
+	w.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer w.wg.Done()
+			for job := range w.jobs {
+				job()
+			}
+		}()
+	}
+	go w.run()
+	return w, nil
+}
+
+type bulkWriter struct {
+	qs *QuadStore
+	// jobs are executed by the pool of workers
+	jobs chan func()
+	// order lists hashed batches in the order of writes
+	order chan chan *graphlog.Deltas
+	// stop is closed once all batches were written, or the load failed
+	stop chan struct{}
+	// err is the error of the load; it must only be read after stop is closed
+	err    error
+	wg     sync.WaitGroup
+	closed bool
+}
+
+// bulkRun is a sorted run of index entries and log entries for a batch of new quads.
+type bulkRun struct {
+	// buckets maps quad index buckets to entries, sorted by key
+	buckets map[string][]indexEntry
+	// log lists log entries, sorted by id
+	log []bulkLogEntry
+	err error
+}
+
+type bulkLogEntry struct {
+	key kv.Key
+	val []byte
+}
+
+func (w *bulkWriter) WriteQuad(q quad.Quad) error {
+	_, err := w.WriteQuads([]quad.Quad{q})
+	return err
+}
+
+func (w *bulkWriter) WriteQuads(buf []quad.Quad) (int, error) {
+	if w.closed {
+		return 0, errBulkClosed
+	}
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	// the buffer is reused by the caller
+	quads := append([]quad.Quad{}, buf...)
+	hashed := make(chan *graphlog.Deltas, 1)
+	select {
+	case w.order <- hashed:
+	case <-w.stop:
+		return 0, w.err
+	}
+	select {
+	case w.jobs <- func() { hashed <- graphlog.InsertQuads(quads) }:
+	case <-w.stop:
+		return 0, w.err
+	}
+	return len(buf), nil
+}
+
+func (w *bulkWriter) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	close(w.order)
+	<-w.stop
+	close(w.jobs)
+	w.wg.Wait()
+	return w.err
+}
+
+func (w *bulkWriter) run() {
+	defer close(w.stop)
+	w.err = w.write()
+}
+
+// write runs the serial stage of the load. See NewBulkWriter.
+func (w *bulkWriter) write() error {
+	ctx := context.TODO()
+	qs := w.qs
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+	// indexes are only changed while holding the writer lock
+	qs.indexes.RLock()
+	indexes := qs.indexes.all
+	qs.indexes.RUnlock()
+
+	tx, err := qs.db.Tx(true)
+	if err != nil {
+		return err
+	}
+	tx = wrapTx(tx)
+	defer func() {
+		if tx != nil {
+			_ = tx.Close()
+		}
+	}()
+	if err = qs.invalidateFilters(tx); err != nil {
+		return err
+	}
+	var (
+		runs []chan *bulkRun
+		// quads that were added since the last flush; they are not yet visible in indexes
+		pending = make(map[[4]uint64]struct{})
+		n       int
+	)
+	flush := func() error {
+		if err := qs.writeBulkRuns(ctx, tx, runs); err != nil {
+			return err
+		}
+		runs = nil
+		pending = make(map[[4]uint64]struct{})
+		n = 0
+		err := tx.Commit(ctx)
+		tx = nil
+		return err
+	}
+	for hashed := range w.order {
+		deltas := <-hashed
+		n += len(deltas.QuadAdd)
+		links, err := qs.addBulkLinks(ctx, tx, deltas, pending)
+		if err != nil {
+			return err
+		}
+		if len(links) != 0 {
+			run := make(chan *bulkRun, 1)
+			runs = append(runs, run)
+			w.jobs <- func() { run <- newBulkRun(indexes, links) }
+		}
+		if n < bulkFlushQuads {
+			continue
+		}
+		if err = flush(); err != nil {
+			return err
+		}
+		if tx, err = qs.db.Tx(true); err != nil {
+			return err
+		}
+		tx = wrapTx(tx)
+	}
+	return flush()
+}
+
+// addBulkLinks creates nodes for a hashed batch and allocates ids for quads that do not exist yet.
+// Quads listed in pending are considered existing, and new quads are added to it.
+func (qs *QuadStore) addBulkLinks(ctx context.Context, tx kv.Tx, deltas *graphlog.Deltas, pending map[[4]uint64]struct{}) ([]proto.Primitive, error) {
+	nodes, err := qs.incNodes(ctx, tx, deltas.IncNode, history{})
+	if err != nil {
+		return nil, err
+	}
+	links := make([]proto.Primitive, 0, len(deltas.QuadAdd))
+	// references of skipped quads, which were counted by incNodes
+	skipped := make(map[refs.ValueHash]int)
+	for _, q := range deltas.QuadAdd {
+		var (
+			link      proto.Primitive
+			qkey      [4]uint64
+			mustBeNew bool
+		)
+		for i, dir := range quad.Directions {
+			n, ok := nodes[q.Quad.Get(dir)]
+			if !ok {
+				continue
+			}
+			mustBeNew = mustBeNew || n.New
+			link.SetDirection(dir, n.ID)
+			qkey[i] = n.ID
+		}
+		exists := false
+		if _, ok := pending[qkey]; ok {
+			exists = true
+		} else if !mustBeNew {
+			p, err := qs.hasPrimitive(ctx, tx, &link, false)
+			if err != nil {
+				return nil, err
+			}
+			exists = p != nil
+		}
+		if exists {
+			for _, dir := range quad.Directions {
+				if h := q.Quad.Get(dir); h.Valid() {
+					skipped[h]++
+				}
+			}
+			continue
+		}
+		pending[qkey] = struct{}{}
+		links = append(links, link)
+	}
+	if len(skipped) != 0 {
+		upd := make([]nodeUpdate, 0, len(skipped))
+		for h, n := range skipped {
+			upd = append(upd, nodeUpdate{ID: nodes[h].ID, NodeUpdate: graphlog.NodeUpdate{Hash: h, RefInc: -n}})
+		}
+		sort.Slice(upd, func(i, j int) bool {
+			return bytes.Compare(upd[i].Hash[:], upd[j].Hash[:]) < 0
+		})
+		// nodes are still referenced by existing quads, thus no nodes are removed
+		if _, _, err = qs.incNodesCnt(ctx, tx, upd, nil); err != nil {
+			return nil, err
+		}
+	}
+	start, err := qs.genIDs(ctx, tx, len(links))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixNano()
+	for i := range links {
+		links[i].ID = start + uint64(i)
+		links[i].Timestamp = now
+		qs.bloomAdd(&links[i])
+	}
+	if err = qs.incSize(ctx, tx, int64(len(links))); err != nil {
+		return nil, err
+	}
+	mBulkQuads.Add(float64(len(links)))
+	return links, nil
+}
+
+// newBulkRun generates index keys and log entries for new quads. Quads must be sorted by id.
+func newBulkRun(indexes []QuadIndex, links []proto.Primitive) *bulkRun {
+	r := &bulkRun{
+		buckets: make(map[string][]indexEntry, len(indexes)),
+		log:     make([]bulkLogEntry, 0, len(links)),
+	}
+	for _, ind := range indexes {
+		m := make(map[string][]uint64, len(links))
+		for i := range links {
+			k := ind.KeyFor(&links[i])
+			m[string(k[1])] = append(m[string(k[1])], links[i].ID)
+		}
+		entries := make([]indexEntry, 0, len(m))
+		for k, ids := range m {
+			entries = append(entries, indexEntry{key: []byte(k), ids: ids})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i].key, entries[j].key) < 0
+		})
+		r.buckets[string(ind.bucket()[0])] = entries
+	}
+	for i := range links {
+		buf, err := links[i].Marshal()
+		if err != nil {
+			r.err = err
+			return r
+		}
+		r.log = append(r.log, bulkLogEntry{key: logIndex.Append(uint64KeyBytes(links[i].ID)), val: buf})
+	}
+	return r
+}
+
+// writeBulkRuns waits for sorted runs, merges and writes them. Runs must be listed in the order of quad ids.
+func (qs *QuadStore) writeBulkRuns(ctx context.Context, tx kv.Tx, runs []chan *bulkRun) error {
+	buckets := make(map[string][]indexEntry)
+	for _, ch := range runs {
+		r := <-ch
+		if r.err != nil {
+			return r.err
+		}
+		for _, e := range r.log {
+			if err := tx.Put(e.key, e.val); err != nil {
+				return err
+			}
+			mPrimitiveAppend.Inc()
+		}
+		for b, entries := range r.buckets {
+			buckets[b] = mergeIndexEntries(buckets[b], entries)
+		}
+	}
+	names := make([]string, 0, len(buckets))
+	for b := range buckets {
+		names = append(names, b)
+	}
+	sort.Strings(names)
+	for _, b := range names {
+		if err := qs.putIndexEntries(ctx, tx, b, buckets[b]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeIndexEntries merges two lists of index entries sorted by key. Ids of b must be greater than ids of a.
+func mergeIndexEntries(a, b []indexEntry) []indexEntry {
+	if len(a) == 0 {
+		return b
+	}
+	c := make([]indexEntry, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		switch bytes.Compare(a[0].key, b[0].key) {
+		case -1:
+			c = append(c, a[0])
+			a = a[1:]
+		case 1:
+			c = append(c, b[0])
+			b = b[1:]
+		default:
+			ids := append(append([]uint64{}, a[0].ids...), b[0].ids...)
+			c = append(c, indexEntry{key: a[0].key, ids: ids})
+			a, b = a[1:], b[1:]
+		}
+	}
+	c = append(c, a...)
+	return append(c, b...)
+}
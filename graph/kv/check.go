@@ -0,0 +1,485 @@
+package kv
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hidal-go/hidalgo/kv"
+
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/pquads"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/proto"
+	"github.com/epik-protocol/epik-gateway-backend/graph/refs"
+)
+
+var _ graph.Checker = (*QuadStore)(nil)
+
+type checkNode struct {
+	hash    refs.ValueHash
+	val     quad.Value
+	deleted bool
+}
+
+// hashEntry is an expected value of a value index entry or a reference counter of a node.
+type hashEntry struct {
+	id   uint64
+	val  uint64
+	dead bool
+}
+
+// bytes encodes the entry, see valueEntry.
+func (e hashEntry) bytes() []byte {
+	return valueEntry(e.val, e.dead)
+}
+
+// checker collects the state of the log and changes that repair derived indexes.
+type checker struct {
+	rep *graph.CheckReport
+
+	// quads are all quad primitives, including deleted ones, in the order of ids
+	quads []proto.Primitive
+	nodes map[uint64]*checkNode
+	// refs are the numbers of references to nodes from existing quads
+	refs  map[uint64]uint64
+	maxID uint64
+
+	// fixed are indexes of problems that are repaired by ops
+	fixed []int
+	ops   []compactOp
+	// filters is set if in-memory filters must be rebuilt
+	filters bool
+	// values are nodes with repaired value index entries
+	values []quad.Value
+}
+
+func formatKey(k kv.Key) string {
+	if len(k) == 0 {
+		return ""
+	}
+	s := strconv.Quote(string(k[0]))
+	for _, p := range k[1:] {
+		s += "/" + hex.EncodeToString(p)
+	}
+	return s
+}
+
+// report records a problem that cannot be repaired.
+func (c *checker) report(kind string, id uint64, key kv.Key, format string, args ...interface{}) {
+	c.rep.Problems = append(c.rep.Problems, graph.Inconsistency{
+		Kind: kind, ID: id, Key: formatKey(key),
+		Msg: fmt.Sprintf(format, args...),
+	})
+}
+
+// fix records a problem that is repaired by putting a value to the key, or removing the key if the value is nil.
+func (c *checker) fix(kind string, id uint64, key kv.Key, val []byte, format string, args ...interface{}) {
+	c.fixed = append(c.fixed, len(c.rep.Problems))
+	c.report(kind, id, key, format, args...)
+	c.ops = append(c.ops, compactOp{key: key, val: val})
+}
+
+// fixFilter records a problem that is repaired by rebuilding in-memory filters.
+func (c *checker) fixFilter(id uint64, key kv.Key, format string, args ...interface{}) {
+	c.fixed = append(c.fixed, len(c.rep.Problems))
+	c.report(graph.CheckBloom, id, key, format, args...)
+	c.filters = true
+}
+
+// quad returns a quad primitive with a given id, or nil if it does not exist.
+func (c *checker) quad(id uint64) *proto.Primitive {
+	i := sort.Search(len(c.quads), func(i int) bool {
+		return c.quads[i].ID >= id
+	})
+	if i < len(c.quads) && c.quads[i].ID == id {
+		return &c.quads[i]
+	}
+	return nil
+}
+
+// Check implements graph.Checker.
+//
+// Primitives in the log are the source of truth. Quad indexes, value index entries and node reference counters
+// are compared with the ones built from the log, references from cids must point to quads, and metadata counters
+// must match the log. Bloom filters must contain all existing quads, as well as nodes and index entries while
+// bulk loading. Repair rewrites mismatched entries and rebuilds in-memory filters; cid references to primitives
+// that are not quads are removed, since cids are not recorded to the log.
+func (qs *QuadStore) Check(ctx context.Context, opts graph.CheckOptions) (*graph.CheckReport, error) {
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+
+	c := &checker{
+		rep:   &graph.CheckReport{},
+		nodes: make(map[uint64]*checkNode),
+		refs:  make(map[uint64]uint64),
+	}
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		tx = wrapTx(tx)
+		if err := qs.checkLog(ctx, tx, c); err != nil {
+			return err
+		}
+		if err := qs.checkIndexes(ctx, tx, c); err != nil {
+			return err
+		}
+		if err := qs.checkNodes(ctx, tx, c); err != nil {
+			return err
+		}
+		if err := qs.checkCids(ctx, tx, c); err != nil {
+			return err
+		}
+		return qs.checkMeta(ctx, tx, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	qs.checkBloom(c)
+	if !opts.Repair || len(c.fixed) == 0 {
+		return c.rep, nil
+	}
+	if err := kv.Update(ctx, qs.db, qs.invalidateFilters); err != nil {
+		return c.rep, err
+	}
+	if err := qs.writeCompactOps(ctx, c.ops); err != nil {
+		return c.rep, err
+	}
+	for _, v := range c.values {
+		if iri, ok := v.(quad.IRI); ok {
+			qs.valueLRU.Del(string(iri))
+		}
+	}
+	if c.filters || len(c.ops) != 0 {
+		// bulk load filters are only an optimization, thus they are dropped instead of being rebuilt
+		qs.mapBloom, qs.mapNodes = nil, nil
+		if err := qs.initBloomFilter(ctx); err != nil {
+			return c.rep, err
+		}
+	}
+	for _, i := range c.fixed {
+		c.rep.Problems[i].Repaired = true
+	}
+	return c.rep, nil
+}
+
+// checkLog reads all primitives and verifies that quads reference existing nodes.
+func (qs *QuadStore) checkLog(ctx context.Context, tx kv.Tx, c *checker) error {
+	it := tx.Scan(logIndex)
+	defer it.Close()
+	for it.Next(ctx) {
+		k, v := it.Key(), it.Val()
+		if len(k) != 2 || len(k[1]) == 0 {
+			continue
+		}
+		var p proto.Primitive
+		if err := p.Unmarshal(v); err != nil {
+			c.report(graph.CheckLog, 0, k.Clone(), "cannot decode primitive: %v", err)
+			continue
+		} else if len(k[1]) != 8 || quadKeyEnc.Uint64(k[1]) != p.ID {
+			c.report(graph.CheckLog, p.ID, k.Clone(), "primitive is stored with a wrong key")
+			continue
+		}
+		if p.ID > c.maxID {
+			c.maxID = p.ID
+		}
+		if p.IsNode() {
+			val, err := pquads.UnmarshalValue(p.Value)
+			if err != nil {
+				c.report(graph.CheckLog, p.ID, k.Clone(), "cannot decode node value: %v", err)
+				continue
+			}
+			c.nodes[p.ID] = &checkNode{hash: refs.HashOf(val), val: val, deleted: p.Deleted}
+			if !p.Deleted {
+				c.rep.Nodes++
+			}
+			continue
+		}
+		c.quads = append(c.quads, p)
+		if p.Deleted {
+			continue
+		}
+		c.rep.Quads++
+		for _, d := range quad.Directions {
+			if id := p.GetDirection(d); id != 0 {
+				c.refs[id]++
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	for _, p := range c.quads {
+		if p.Deleted {
+			// nodes of deleted quads are removed unless the history is recorded
+			continue
+		}
+		for _, d := range quad.Directions {
+			id := p.GetDirection(d)
+			if id == 0 {
+				if d != quad.Label {
+					c.report(graph.CheckLog, p.ID, nil, "quad has no %v", d)
+				}
+				continue
+			}
+			n, ok := c.nodes[id]
+			if !ok {
+				c.report(graph.CheckLog, p.ID, nil, "quad references a missing node %d", id)
+			} else if n.deleted {
+				c.report(graph.CheckLog, p.ID, nil, "quad references a deleted node %d", id)
+			}
+		}
+	}
+	return nil
+}
+
+// checkIndexes compares all complete quad indexes with the ones built from the log. Deleted quads are indexed as well.
+func (qs *QuadStore) checkIndexes(ctx context.Context, tx kv.Tx, c *checker) error {
+	qs.indexes.RLock()
+	all := completeIndexes(qs.indexes.all)
+	qs.indexes.RUnlock()
+	for _, ind := range all {
+		exp := make(map[string][]uint64)
+		for i := range c.quads {
+			k := ind.KeyFor(&c.quads[i])
+			exp[string(k[1])] = append(exp[string(k[1])], c.quads[i].ID)
+		}
+		if qs.mapBloom != nil {
+			b := ind.bucket()
+			bloom := qs.mapBloom[string(b[0])]
+			for k, ids := range exp {
+				if bloom == nil || !bloom.Test([]byte(k)) {
+					c.fixFilter(ids[0], b.AppendBytes([]byte(k)), "index entry is missing in the bulk load filter")
+				}
+			}
+		}
+		if err := qs.checkIndex(ctx, tx, c, ind, exp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func equalIDs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (qs *QuadStore) checkIndex(ctx context.Context, tx kv.Tx, c *checker, ind QuadIndex, exp map[string][]uint64) error {
+	it := tx.Scan(ind.bucket())
+	for it.Next(ctx) {
+		k, v := it.Key(), it.Val()
+		if len(k) != 2 || len(k[1]) == 0 {
+			continue
+		}
+		ids, ok := exp[string(k[1])]
+		if !ok {
+			c.fix(graph.CheckIndex, 0, k.Clone(), nil, "index entry references no quads")
+			continue
+		}
+		delete(exp, string(k[1]))
+		got, err := decodeIndex(v)
+		if err != nil {
+			c.fix(graph.CheckIndex, ids[0], k.Clone(), appendIndex(nil, ids), "cannot decode index entry: %v", err)
+		} else if !equalIDs(got, ids) {
+			c.fix(graph.CheckIndex, ids[0], k.Clone(), appendIndex(nil, ids), "index entry lists quads %v instead of %v", got, ids)
+		}
+	}
+	err := it.Err()
+	it.Close()
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(exp))
+	for k := range exp {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b := ind.bucket()
+	for _, k := range keys {
+		ids := exp[k]
+		c.fix(graph.CheckIndex, ids[0], b.AppendBytes([]byte(k)), appendIndex(nil, ids), "index entry for quads %v is missing", ids)
+	}
+	return nil
+}
+
+// checkNodes compares value index entries and reference counters of nodes with the log.
+func (qs *QuadStore) checkNodes(ctx context.Context, tx kv.Tx, c *checker) error {
+	ids := make([]uint64, 0, len(c.nodes))
+	for id := range c.nodes {
+		ids = append(ids, id)
+	}
+	sort.Sort(Int64Set(ids))
+	vals := make(map[refs.ValueHash]hashEntry, len(ids))
+	cnts := make(map[refs.ValueHash]hashEntry, len(ids))
+	for _, id := range ids {
+		n := c.nodes[id]
+		if prev, ok := vals[n.hash]; ok {
+			c.report(graph.CheckLog, id, nil, "node has the same value as node %d", prev.id)
+			if !c.nodes[prev.id].deleted {
+				continue
+			}
+		}
+		vals[n.hash] = hashEntry{id: id, val: id, dead: n.deleted}
+		if cnt := c.refs[id]; cnt != 0 && !n.deleted {
+			cnts[n.hash] = hashEntry{id: id, val: cnt}
+		}
+	}
+	for i := 0; i < 256; i++ {
+		for j := 0; j < 256; j++ {
+			if err := qs.checkHashBucket(ctx, tx, c, graph.CheckValue, bucketForVal(byte(i), byte(j)), vals); err != nil {
+				return err
+			}
+			if err := qs.checkHashBucket(ctx, tx, c, graph.CheckRefs, bucketForValRefs(byte(i), byte(j)), cnts); err != nil {
+				return err
+			}
+		}
+	}
+	for _, exp := range []struct {
+		kind    string
+		entries map[refs.ValueHash]hashEntry
+		key     func(h refs.ValueHash) kv.Key
+	}{
+		{graph.CheckValue, vals, bucketKeyForHash},
+		{graph.CheckRefs, cnts, bucketKeyForHashRefs},
+	} {
+		missing := make([]refs.ValueHash, 0, len(exp.entries))
+		for h := range exp.entries {
+			missing = append(missing, h)
+		}
+		sort.Slice(missing, func(i, j int) bool {
+			return exp.entries[missing[i]].id < exp.entries[missing[j]].id
+		})
+		for _, h := range missing {
+			e := exp.entries[h]
+			c.fix(exp.kind, e.id, exp.key(h), e.bytes(), "entry is missing, expected %d", e.val)
+			if exp.kind == graph.CheckValue {
+				c.values = append(c.values, c.nodes[e.id].val)
+			}
+		}
+	}
+	if qs.mapNodes != nil {
+		for _, id := range ids {
+			if n := c.nodes[id]; !n.deleted && !qs.mapNodes.Test(n.hash[:]) {
+				c.fixFilter(id, nil, "node is missing in the bulk load filter")
+			}
+		}
+	}
+	return nil
+}
+
+// checkHashBucket compares varint values of a bucket keyed by value hashes with the expected ones,
+// and the deleted marker of value index entries, see valueEntry.
+// Matched entries are removed from the expected set.
+func (qs *QuadStore) checkHashBucket(ctx context.Context, tx kv.Tx, c *checker, kind string, b kv.Key, exp map[refs.ValueHash]hashEntry) error {
+	it := tx.Scan(b)
+	defer it.Close()
+	for it.Next(ctx) {
+		k, v := it.Key(), it.Val()
+		if len(k) != 2 || len(k[1]) == 0 {
+			continue
+		}
+		var h refs.ValueHash
+		if len(k[1]) != len(h) {
+			c.fix(kind, 0, k.Clone(), nil, "invalid value hash")
+			continue
+		}
+		copy(h[:], k[1])
+		e, ok := exp[h]
+		if !ok {
+			c.fix(kind, 0, k.Clone(), nil, "entry references no existing node")
+			continue
+		}
+		delete(exp, h)
+		got, dead := decodeValueEntry(v)
+		if got != e.val {
+			c.fix(kind, e.id, k.Clone(), e.bytes(), "entry is %d instead of %d", got, e.val)
+		} else if dead != e.dead {
+			c.fix(kind, e.id, k.Clone(), e.bytes(), "entry is marked as deleted: %v, expected %v", dead, e.dead)
+		} else {
+			continue
+		}
+		if kind == graph.CheckValue {
+			c.values = append(c.values, c.nodes[e.id].val)
+		}
+	}
+	return it.Err()
+}
+
+// checkCids verifies that cid references point to quads.
+func (qs *QuadStore) checkCids(ctx context.Context, tx kv.Tx, c *checker) error {
+	it := tx.Scan(cidIndex)
+	defer it.Close()
+	for it.Next(ctx) {
+		k, v := it.Key(), it.Val()
+		if len(k) != 2 || len(k[1]) == 0 {
+			continue
+		}
+		if len(v) != 8 {
+			c.fix(graph.CheckCid, 0, k.Clone(), nil, "invalid cid reference")
+			continue
+		}
+		id := binary.LittleEndian.Uint64(v)
+		rev := quadCidKey(id, cidOfKey(k, id))
+		if c.quad(id) != nil {
+			if _, err := tx.Get(ctx, rev); err == kv.ErrNotFound {
+				c.fix(graph.CheckCid, id, rev, append([]byte{}, k[len(k)-1]...), "reverse cid reference is missing")
+			} else if err != nil {
+				return err
+			}
+			continue
+		}
+		// the reverse entry is removed together with the reference
+		c.ops = append(c.ops, compactOp{key: rev})
+		if _, ok := c.nodes[id]; ok {
+			c.fix(graph.CheckCid, id, k.Clone(), nil, "cid references a node")
+		} else {
+			c.fix(graph.CheckCid, id, k.Clone(), nil, "cid references a missing quad")
+		}
+	}
+	return it.Err()
+}
+
+// checkMeta verifies the number of quads and the id horizon.
+func (qs *QuadStore) checkMeta(ctx context.Context, tx kv.Tx, c *checker) error {
+	size, err := qs.getMetaIntTx(ctx, tx, "size")
+	if err != nil && err != kv.ErrNotFound {
+		return err
+	}
+	if size != c.rep.Quads {
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, uint64(c.rep.Quads))
+		c.fix(graph.CheckMeta, 0, metaBucket.AppendBytes([]byte("size")), buf, "size is %d instead of %d", size, c.rep.Quads)
+	}
+	horizon, err := qs.getMetaIntTx(ctx, tx, "horizon")
+	if err != nil && err != kv.ErrNotFound {
+		return err
+	}
+	if uint64(horizon) < c.maxID {
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, c.maxID)
+		c.fix(graph.CheckMeta, c.maxID, metaBucket.AppendBytes([]byte("horizon")), buf, "horizon %d is below the last id", horizon)
+	}
+	return nil
+}
+
+// checkBloom verifies that the quad bloom filter contains all existing quads. False negatives of the filter
+// cause duplicate quads to be inserted.
+func (qs *QuadStore) checkBloom(c *checker) {
+	if qs.exists.disabled {
+		return
+	}
+	for i := range c.quads {
+		if p := &c.quads[i]; !p.Deleted && !qs.testBloom(p) {
+			c.fixFilter(p.ID, nil, "quad is missing in the bloom filter")
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package kv
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/hidal-go/hidalgo/kv"
+
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/pquads"
+	"github.com/epik-protocol/epik-gateway-backend/clog"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/proto"
+)
+
+var _ graph.Compactor = (*QuadStore)(nil)
+
+// compactBatch is the number of keys written or removed in a single transaction by the compaction.
+const compactBatch = 10000
+
+// metaHistory is the metadata key of the oldest epoch that can be queried with AsOf after a compaction.
+const metaHistory = "history"
+
+// deadNode is a deleted node that may be removed by the compaction.
+type deadNode struct {
+	val  quad.Value
+	size int
+}
+
+// compaction collects changes to the store before they are written.
+type compaction struct {
+	before int64
+	st     *graph.CompactStats
+
+	// quads are ids of deleted quads that are removed
+	quads map[uint64]struct{}
+	// dead are ids of all deleted quads, including the ones kept for the history
+	dead map[uint64]struct{}
+	// nodes are deleted nodes that are removed unless they are used by kept quads
+	nodes map[uint64]deadNode
+	// used are ids of nodes referenced by kept quads
+	used map[uint64]struct{}
+
+	// refs are changes of index entries that reference primitives, they are written first
+	refs []compactOp
+	// log are primitives removed from the log after all references to them
+	log []compactOp
+}
+
+// compactOp puts a value to the key, or removes the key if the value is nil.
+type compactOp struct {
+	key kv.Key
+	val []byte
+}
+
+func keySize(k kv.Key) int {
+	n := 0
+	for _, p := range k {
+		n += len(p)
+	}
+	return n
+}
+
+func (c *compaction) del(k kv.Key, size int) {
+	c.refs = append(c.refs, compactOp{key: k})
+	c.st.Bytes += int64(keySize(k) + size)
+}
+
+func (c *compaction) put(k kv.Key, size int, val []byte) {
+	c.refs = append(c.refs, compactOp{key: k, val: val})
+	c.st.Bytes += int64(size - len(val))
+}
+
+func (c *compaction) delLog(id uint64, size int) {
+	k := logIndex.Append(uint64KeyBytes(id))
+	c.log = append(c.log, compactOp{key: k})
+	c.st.Bytes += int64(keySize(k) + size)
+}
+
+// Compact implements graph.Compactor.
+//
+// Deleted quads are kept in the log for queries at past epochs, and deleted nodes are kept while any of such quads
+// reference them. Compaction removes them from the log, rewrites quad indexes and removes cid references to
+// deleted quads, value index entries of removed nodes and reference counters of nodes that are deleted or missing.
+// Writes are blocked during the compaction, and changes are committed in batches, references to removed
+// primitives first, thus an interrupted compaction can be safely run again.
+//
+// Views of epochs before Before-1 would miss removed quads, thus AsOf returns graph.ErrEpochNotExist for them.
+func (qs *QuadStore) Compact(ctx context.Context, opts graph.CompactOptions) (*graph.CompactStats, error) {
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+
+	c := &compaction{
+		st:    &graph.CompactStats{},
+		quads: make(map[uint64]struct{}),
+		dead:  make(map[uint64]struct{}),
+		nodes: make(map[uint64]deadNode),
+		used:  make(map[uint64]struct{}),
+	}
+	var removed []quad.Value
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		tx = wrapTx(tx)
+		c.before = opts.Before
+		if c.before <= 0 {
+			var err error
+			c.before, err = qs.compactHorizon(ctx, tx)
+			if err != nil {
+				return err
+			}
+		}
+		c.st.Before = c.before
+		if err := qs.compactLog(ctx, tx, c); err != nil {
+			return err
+		}
+		var err error
+		if removed, err = qs.compactNodes(ctx, tx, c); err != nil {
+			return err
+		}
+		if err := qs.compactRefs(ctx, tx, c); err != nil {
+			return err
+		}
+		if err := qs.compactIndexes(ctx, tx, c); err != nil {
+			return err
+		}
+		return qs.compactCids(ctx, tx, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		return c.st, nil
+	}
+	// the horizon is recorded first, thus compacted epochs cannot be queried even if the compaction is interrupted
+	if err := qs.setHistoryHorizon(ctx, c.before-1); err != nil {
+		return nil, err
+	}
+	for _, ops := range [][]compactOp{c.refs, c.log} {
+		if err := qs.writeCompactOps(ctx, ops); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range removed {
+		if iri, ok := v.(quad.IRI); ok {
+			qs.valueLRU.Del(string(iri))
+		}
+	}
+	mCompactBytes.Add(float64(c.st.Bytes))
+	return c.st, nil
+}
+
+// setHistoryHorizon records the oldest epoch that can be queried with AsOf, unless a later one is recorded already.
+// Quads deleted before the epoch do not exist at it, thus removing them does not change the view of the epoch.
+func (qs *QuadStore) setHistoryHorizon(ctx context.Context, epoch int64) error {
+	return kv.Update(ctx, qs.db, func(tx kv.Tx) error {
+		cur, err := qs.getMetaIntTx(ctx, tx, metaHistory)
+		if err != nil && err != kv.ErrNotFound {
+			return err
+		} else if epoch <= cur {
+			return nil
+		}
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, uint64(epoch))
+		return tx.Put(metaBucket.AppendBytes([]byte(metaHistory)), buf)
+	})
+}
+
+// compactHorizon returns the oldest epoch that can still be reverted, or the epoch following
+// the latest one if all undo logs were pruned.
+func (qs *QuadStore) compactHorizon(ctx context.Context, tx kv.Tx) (int64, error) {
+	it := tx.Scan(undoIndex)
+	defer it.Close()
+	for it.Next(ctx) {
+		k := it.Key()
+		if len(k) != 2 || len(k[1]) < 8 {
+			continue
+		}
+		return int64(quadKeyEnc.Uint64(k[1][:8])), nil
+	}
+	if err := it.Err(); err != nil {
+		return 0, err
+	}
+	epoch, err := qs.getMetaIntTx(ctx, tx, "epoch")
+	if err != nil && err != kv.ErrNotFound {
+		return 0, err
+	}
+	return epoch + 1, nil
+}
+
+// compactLog scans the log for quads deleted before the horizon and collects deleted nodes.
+func (qs *QuadStore) compactLog(ctx context.Context, tx kv.Tx, c *compaction) error {
+	it := tx.Scan(logIndex)
+	defer it.Close()
+	for it.Next(ctx) {
+		v := it.Val()
+		var p proto.Primitive
+		if err := p.Unmarshal(v); err != nil {
+			return err
+		} else if p.ID == 0 {
+			continue
+		}
+		if p.IsNode() {
+			if p.Deleted && p.DeletedEpoch < c.before {
+				val, err := pquads.UnmarshalValue(p.Value)
+				if err != nil {
+					return err
+				}
+				c.nodes[p.ID] = deadNode{val: val, size: len(v)}
+			}
+			continue
+		}
+		if p.Deleted {
+			c.dead[p.ID] = struct{}{}
+			if p.DeletedEpoch < c.before {
+				c.quads[p.ID] = struct{}{}
+				c.delLog(p.ID, len(v))
+				c.st.Quads++
+				continue
+			}
+		}
+		for _, d := range quad.Directions {
+			if id := p.GetDirection(d); id != 0 {
+				c.used[id] = struct{}{}
+			}
+		}
+	}
+	return it.Err()
+}
+
+// compactNodes removes deleted nodes that are not referenced by kept quads, together with their value index entries.
+// It returns values of removed nodes.
+func (qs *QuadStore) compactNodes(ctx context.Context, tx kv.Tx, c *compaction) ([]quad.Value, error) {
+	ids := make([]uint64, 0, len(c.nodes))
+	for id := range c.nodes {
+		if _, ok := c.used[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(Int64Set(ids))
+	vals := make([]quad.Value, 0, len(ids))
+	for _, id := range ids {
+		n := c.nodes[id]
+		c.delLog(id, n.size)
+		c.st.Nodes++
+		vals = append(vals, n.val)
+
+		k := bucketKeyForVal(n.val)
+		v, err := tx.Get(ctx, k)
+		if err == kv.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		// the value may already be assigned to a new node
+		if vid, _ := binary.Uvarint(v); vid == id {
+			c.del(k, len(v))
+		}
+	}
+	return vals, nil
+}
+
+// compactRefs removes reference counters of nodes that are missing, deleted or removed by the compaction.
+func (qs *QuadStore) compactRefs(ctx context.Context, tx kv.Tx, c *compaction) error {
+	removed := make(map[uint64]struct{})
+	for id := range c.nodes {
+		if _, ok := c.used[id]; !ok {
+			removed[id] = struct{}{}
+		}
+	}
+	for i := 0; i < 256; i++ {
+		for j := 0; j < 256; j++ {
+			if err := qs.compactRefsBucket(ctx, tx, c, byte(i), byte(j), removed); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (qs *QuadStore) compactRefsBucket(ctx context.Context, tx kv.Tx, c *compaction, i, j byte, removed map[uint64]struct{}) error {
+	var (
+		keys  []kv.Key
+		vkeys []kv.Key
+		sizes []int
+	)
+	it := tx.Scan(bucketForValRefs(i, j))
+	for it.Next(ctx) {
+		k := it.Key()
+		if len(k) != 2 || len(k[1]) == 0 {
+			continue
+		}
+		k = k.Clone()
+		keys = append(keys, k)
+		vkeys = append(vkeys, bucketForVal(i, j).AppendBytes(k[1]))
+		sizes = append(sizes, len(it.Val()))
+	}
+	err := it.Err()
+	it.Close()
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	vals, err := tx.GetBatch(ctx, vkeys)
+	if err != nil {
+		return err
+	}
+	var (
+		inds []int
+		ids  []uint64
+	)
+	for n, v := range vals {
+		if len(v) == 0 {
+			c.del(keys[n], sizes[n])
+			c.st.Refs++
+			continue
+		}
+		id, _ := binary.Uvarint(v)
+		if _, ok := removed[id]; ok {
+			c.del(keys[n], sizes[n])
+			c.st.Refs++
+			continue
+		}
+		inds = append(inds, n)
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	prims, err := qs.getPrimitivesFromLog(ctx, tx, ids)
+	if err != nil {
+		return err
+	}
+	for k, p := range prims {
+		if p == nil || p.Deleted {
+			n := inds[k]
+			c.del(keys[n], sizes[n])
+			c.st.Refs++
+		}
+	}
+	return nil
+}
+
+// compactIndexes removes ids of removed quads from all quad indexes.
+func (qs *QuadStore) compactIndexes(ctx context.Context, tx kv.Tx, c *compaction) error {
+	if len(c.quads) == 0 {
+		return nil
+	}
+	qs.indexes.RLock()
+	all := qs.indexes.all
+	qs.indexes.RUnlock()
+	for _, ind := range all {
+		if err := qs.compactIndex(ctx, tx, c, ind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (qs *QuadStore) compactIndex(ctx context.Context, tx kv.Tx, c *compaction, ind QuadIndex) error {
+	it := tx.Scan(ind.bucket())
+	defer it.Close()
+	for it.Next(ctx) {
+		k, v := it.Key(), it.Val()
+		if len(k) != 2 || len(k[1]) == 0 {
+			continue
+		}
+		list, err := decodeIndex(v)
+		if err != nil {
+			return err
+		}
+		n := 0
+		for _, id := range list {
+			if _, ok := c.quads[id]; !ok {
+				list[n] = id
+				n++
+			}
+		}
+		if n == len(list) {
+			continue
+		}
+		c.st.Indexes++
+		if n == 0 {
+			c.del(k.Clone(), len(v))
+		} else {
+			c.put(k.Clone(), len(v), appendIndex(nil, list[:n]))
+		}
+	}
+	return it.Err()
+}
+
+// compactCids removes references from cids to deleted quads.
+func (qs *QuadStore) compactCids(ctx context.Context, tx kv.Tx, c *compaction) error {
+	if len(c.dead) == 0 {
+		return nil
+	}
+	it := tx.Scan(cidIndex)
+	defer it.Close()
+	for it.Next(ctx) {
+		v := it.Val()
+		if len(v) != 8 {
+			continue
+		}
+		id := binary.LittleEndian.Uint64(v)
+		if _, ok := c.dead[id]; !ok {
+			continue
+		}
+		k := it.Key().Clone()
+		c.del(k, len(v))
+		c.del(quadCidKey(id, cidOfKey(k, id)), len(k[len(k)-1]))
+		c.st.Cids++
+	}
+	return it.Err()
+}
+
+func (qs *QuadStore) writeCompactOps(ctx context.Context, ops []compactOp) error {
+	for len(ops) > 0 {
+		n := compactBatch
+		if n > len(ops) {
+			n = len(ops)
+		}
+		err := kv.Update(ctx, qs.db, func(tx kv.Tx) error {
+			for _, op := range ops[:n] {
+				var err error
+				if op.val == nil {
+					err = tx.Del(op.key)
+				} else {
+					err = tx.Put(op.key, op.val)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
+
+// startCompaction runs the compaction in the background with a given interval, until the store is closed.
+func (qs *QuadStore) startCompaction(every time.Duration) {
+	stop, done := make(chan struct{}), make(chan struct{})
+	qs.compact.stop, qs.compact.done = stop, done
+	go func() {
+		defer close(done)
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+			}
+			st, err := qs.Compact(context.TODO(), graph.CompactOptions{})
+			if err != nil {
+				clog.Errorf("kv: compaction failed: %v", err)
+				continue
+			}
+			if st.Bytes != 0 {
+				clog.Infof("kv: compaction removed %d quads and %d nodes, reclaimed %d bytes", st.Quads, st.Nodes, st.Bytes)
+			}
+		}
+	}()
+}
+
+// stopCompaction stops the background compaction and waits for it to finish.
+func (qs *QuadStore) stopCompaction() {
+	if qs.compact.stop == nil {
+		return
+	}
+	close(qs.compact.stop)
+	<-qs.compact.done
+	qs.compact.stop, qs.compact.done = nil, nil
+}
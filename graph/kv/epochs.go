@@ -0,0 +1,372 @@
+package kv
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/pquads"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/proto"
+	"github.com/hidal-go/hidalgo/kv"
+)
+
+var _ graph.EpochStore = (*QuadStore)(nil)
+
+// history describes the epoch deltas are applied at, it is recorded to primitives of added and deleted quads.
+type history struct {
+	// epoch of the changes, or zero if changes are not made at a specific epoch
+	epoch int64
+	// revert is set if changes revert epochs starting from the given one: quads added in these epochs are
+	// hidden from the history, and deleted quads are restored with epochs they were originally added at
+	revert bool
+	// added are the original epochs of quads restored by the revert, by delta index
+	added map[int]int64
+}
+
+// addedAt returns the epoch to record for the quad added by the delta.
+func (h history) addedAt(ind int) int64 {
+	if h.revert {
+		return h.added[ind]
+	}
+	return h.epoch
+}
+
+// deletedAt returns the epoch to record for the deleted primitive.
+func (h history) deletedAt(p *proto.Primitive) int64 {
+	if h.revert && !p.IsNode() {
+		return p.AddedEpoch
+	}
+	if p.AddedEpoch > h.epoch {
+		return p.AddedEpoch
+	}
+	return h.epoch
+}
+
+// undoLog collects deltas applied at a specific epoch, so they can be reverted later.
+// Nil undo log ignores all deltas.
+type undoLog struct {
+	deltas []*proto.LogDelta
+}
+
+func (u *undoLog) add(p *proto.Primitive, d graph.Delta) {
+	if u == nil {
+		return
+	}
+	u.deltas = append(u.deltas, &proto.LogDelta{
+		ID:        p.ID,
+		Quad:      pquads.MakeQuad(d.Quad),
+		Action:    int32(d.Action),
+		Timestamp: p.Timestamp,
+		Cid:       d.Cid,
+	})
+}
+
+// addCid records a new reference from the cid to an existing quad.
+func (u *undoLog) addCid(cid string, id uint64) {
+	if u == nil {
+		return
+	}
+	u.deltas = append(u.deltas, &proto.LogDelta{
+		ID:     id,
+		Action: int32(graph.Add),
+		Cid:    cid,
+	})
+}
+
+// delCid records a removed reference from the cid to a quad that is still provided by other cids.
+// It has no primitive id, thus the revert adds the quad back with the cid, or only restores the reference
+// if the quad still exists.
+func (u *undoLog) delCid(cid string, q quad.Quad) {
+	if u == nil {
+		return
+	}
+	u.deltas = append(u.deltas, &proto.LogDelta{
+		Quad:   pquads.MakeQuad(q),
+		Action: int32(graph.Delete),
+		Cid:    cid,
+	})
+}
+
+func (u *undoLog) marshal() ([]byte, error) {
+	var (
+		out []byte
+		buf [binary.MaxVarintLen64]byte
+	)
+	for _, d := range u.deltas {
+		data, err := d.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		n := binary.PutUvarint(buf[:], uint64(len(data)))
+		out = append(out, buf[:n]...)
+		out = append(out, data...)
+	}
+	return out, nil
+}
+
+func (u *undoLog) unmarshal(b []byte) error {
+	for len(b) > 0 {
+		sz, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < sz {
+			return io.ErrUnexpectedEOF
+		}
+		b = b[n:]
+		d := &proto.LogDelta{}
+		if err := d.Unmarshal(b[:sz]); err != nil {
+			return err
+		}
+		u.deltas = append(u.deltas, d)
+		b = b[sz:]
+	}
+	return nil
+}
+
+// reverse returns deltas that will revert all the changes recorded in the log,
+// and ids of deleted primitives restored by these deltas, or zero for other deltas.
+func (u *undoLog) reverse() ([]graph.Delta, []uint64) {
+	out := make([]graph.Delta, 0, len(u.deltas))
+	ids := make([]uint64, 0, len(u.deltas))
+	for i := len(u.deltas) - 1; i >= 0; i-- {
+		d := u.deltas[i]
+		if d.Quad == nil {
+			// cid reference only, removed separately
+			continue
+		}
+		rd := graph.Delta{Quad: d.Quad.ToNative()}
+		var id uint64
+		switch graph.Procedure(d.Action) {
+		case graph.Add:
+			// delete the quad itself; the cid index entry is removed separately
+			rd.Action = graph.Delete
+		case graph.Delete:
+			rd.Cid = d.Cid
+			rd.Action = graph.Add
+			id = d.ID
+		default:
+			continue
+		}
+		out = append(out, rd)
+		ids = append(ids, id)
+	}
+	return out, ids
+}
+
+func epochKey(epoch int64) []byte {
+	k := make([]byte, 8)
+	quadKeyEnc.PutUint64(k, uint64(epoch))
+	return k
+}
+
+// writeUndoLog appends a new batch to the undo log of the epoch.
+// Each call to ApplyDeltas writes a separate batch, thus epochs can be applied in multiple transactions.
+func (qs *QuadStore) writeUndoLog(ctx context.Context, tx kv.Tx, epoch int64, undo *undoLog) error {
+	if undo == nil || len(undo.deltas) == 0 {
+		return nil
+	}
+	seq, err := qs.incMetaInt(ctx, tx, "undo", 1)
+	if err != nil {
+		return err
+	}
+	data, err := undo.marshal()
+	if err != nil {
+		return err
+	}
+	key := append(epochKey(epoch), epochKey(seq)...)
+	return tx.Put(undoIndex.AppendBytes(key), data)
+}
+
+func (qs *QuadStore) EpochKey(ctx context.Context, epoch int64) ([]byte, error) {
+	var key []byte
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		val, err := tx.Get(ctx, epochIndex.AppendBytes(epochKey(epoch)))
+		if err == kv.ErrNotFound {
+			return graph.ErrEpochNotExist
+		} else if err != nil {
+			return err
+		}
+		key = append([]byte{}, val...)
+		return nil
+	})
+	return key, err
+}
+
+func (qs *QuadStore) SetEpochKey(ctx context.Context, epoch int64, key []byte) error {
+	return kv.Update(ctx, qs.db, func(tx kv.Tx) error {
+		return tx.Put(epochIndex.AppendBytes(epochKey(epoch)), append([]byte{}, key...))
+	})
+}
+
+// epochsFrom returns keys of all entries in the bucket that belong to the given epoch and above.
+func epochsFrom(ctx context.Context, tx kv.Tx, bucket kv.Key, from int64) ([]kv.Key, error) {
+	var keys []kv.Key
+	it := tx.Scan(bucket)
+	defer it.Close()
+	for it.Next(ctx) {
+		k := it.Key()
+		if len(k) != 2 || len(k[1]) < 8 {
+			continue
+		}
+		if int64(quadKeyEnc.Uint64(k[1][:8])) >= from {
+			keys = append(keys, k.Clone())
+		}
+	}
+	return keys, it.Err()
+}
+
+// epochsBefore returns keys of all entries in the bucket that belong to epochs before the given one.
+func epochsBefore(ctx context.Context, tx kv.Tx, bucket kv.Key, before int64) ([]kv.Key, error) {
+	var keys []kv.Key
+	it := tx.Scan(bucket)
+	defer it.Close()
+	for it.Next(ctx) {
+		k := it.Key()
+		if len(k) != 2 || len(k[1]) < 8 {
+			continue
+		}
+		if int64(quadKeyEnc.Uint64(k[1][:8])) >= before {
+			break
+		}
+		keys = append(keys, k.Clone())
+	}
+	return keys, it.Err()
+}
+
+func (qs *QuadStore) RevertEpochs(ctx context.Context, from int64) error {
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+	tx, err := qs.db.Tx(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+	tx = wrapTx(tx)
+	if err = qs.invalidateFilters(tx); err != nil {
+		return err
+	}
+
+	keys, err := epochsFrom(ctx, tx, undoIndex, from)
+	if err != nil {
+		return err
+	}
+	vals, err := tx.GetBatch(ctx, keys)
+	if err != nil {
+		return err
+	}
+	// revert batches in the reverse order, each in a separate step,
+	// since ApplyDeltas handles all additions before deletions
+	for i := len(vals) - 1; i >= 0; i-- {
+		var undo undoLog
+		if err := undo.unmarshal(vals[i]); err != nil {
+			return fmt.Errorf("cannot decode undo log %x: %v", keys[i][1], err)
+		}
+		if err := qs.delUndoCids(ctx, tx, &undo); err != nil {
+			return err
+		}
+		deltas, ids := undo.reverse()
+		hist, err := qs.revertHistory(ctx, tx, from, ids)
+		if err != nil {
+			return err
+		}
+		err = qs.applyDeltas(ctx, tx, deltas, graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true}, nil, hist)
+		if err != nil {
+			return err
+		}
+		if err := tx.Del(keys[i]); err != nil {
+			return err
+		}
+	}
+	keys, err = epochsFrom(ctx, tx, epochIndex, from)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := tx.Del(k); err != nil {
+			return err
+		}
+	}
+	epoch, err := qs.getMetaIntTx(ctx, tx, "epoch")
+	if err != nil && err != kv.ErrNotFound {
+		return err
+	}
+	if from <= epoch {
+		if err := qs.setEpoch(tx, from-1); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// revertHistory returns the history for deltas that revert epochs starting from a given one.
+// Ids are primitives restored by deltas, quads are added back with the epochs of these primitives.
+// Restored quads get new primitives, thus old ones are hidden from the history.
+func (qs *QuadStore) revertHistory(ctx context.Context, tx kv.Tx, from int64, ids []uint64) (history, error) {
+	h := history{epoch: from, revert: true, added: make(map[int]int64)}
+	var (
+		inds []int
+		keys []uint64
+	)
+	for i, id := range ids {
+		if id != 0 {
+			inds = append(inds, i)
+			keys = append(keys, id)
+		}
+	}
+	if len(keys) == 0 {
+		return h, nil
+	}
+	prims, err := qs.getPrimitivesFromLog(ctx, tx, keys)
+	if err != nil {
+		return h, err
+	}
+	for i, p := range prims {
+		if p == nil {
+			continue
+		}
+		h.added[inds[i]] = p.AddedEpoch
+		p.DeletedEpoch = p.AddedEpoch
+		if err := qs.addToLog(tx, p); err != nil {
+			return h, err
+		}
+	}
+	return h, nil
+}
+
+// delUndoCids removes cid index entries that were added by the undo log.
+func (qs *QuadStore) delUndoCids(ctx context.Context, tx kv.Tx, undo *undoLog) error {
+	for _, d := range undo.deltas {
+		if graph.Procedure(d.Action) != graph.Add || len(d.Cid) == 0 {
+			continue
+		}
+		key := cidKey(d.Cid, d.ID)
+		_, err := tx.Get(ctx, key)
+		if err == kv.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := delCidRef(tx, key, d.Cid, d.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (qs *QuadStore) PruneEpochs(ctx context.Context, before int64) error {
+	return kv.Update(ctx, qs.db, func(tx kv.Tx) error {
+		for _, b := range []kv.Key{undoIndex, epochIndex} {
+			keys, err := epochsBefore(ctx, tx, b, before)
+			if err != nil {
+				return err
+			}
+			for _, k := range keys {
+				if err := tx.Del(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
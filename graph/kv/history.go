@@ -0,0 +1,130 @@
+package kv
+
+import (
+	"context"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/iterator"
+	"github.com/epik-protocol/epik-gateway-backend/graph/refs"
+	"github.com/epik-protocol/epik-gateway-backend/query/shape"
+)
+
+var (
+	_ graph.HistoryStore = (*QuadStore)(nil)
+	_ graph.QuadStore    = (*asOfStore)(nil)
+	_ shape.Optimizer    = (*asOfStore)(nil)
+)
+
+// AsOf implements graph.HistoryStore.
+func (qs *QuadStore) AsOf(ctx context.Context, epoch int64) (graph.QuadStore, error) {
+	cur, err := qs.getMetaInt(ctx, "epoch")
+	if err != nil {
+		return nil, err
+	}
+	if epoch > cur {
+		return nil, graph.ErrEpochNotExist
+	}
+	// the history of earlier epochs was removed by a compaction
+	from, err := qs.getMetaInt(ctx, metaHistory)
+	if err != nil && err != ErrNoBucket {
+		return nil, err
+	} else if epoch < from {
+		return nil, graph.ErrEpochNotExist
+	}
+	return &asOfStore{qs: qs, epoch: epoch}, nil
+}
+
+// asOfStore is a read-only view of the quad store at a past epoch.
+//
+// Deleted quads are kept in the log with epochs they were added and deleted at,
+// thus the view only skips quads that did not exist at the epoch.
+type asOfStore struct {
+	qs    *QuadStore
+	epoch int64
+}
+
+func (s *asOfStore) ValueOf(v quad.Value) graph.Ref {
+	return s.qs.valueAt(v, s.epoch)
+}
+
+func (s *asOfStore) NameOf(v graph.Ref) quad.Value {
+	return s.qs.NameOf(v)
+}
+
+func (s *asOfStore) ValuesOf(ctx context.Context, vals []graph.Ref) ([]quad.Value, error) {
+	return s.qs.ValuesOf(ctx, vals)
+}
+
+func (s *asOfStore) RefsOf(ctx context.Context, nodes []quad.Value) ([]graph.Ref, error) {
+	return s.qs.refsAt(ctx, nodes, s.epoch)
+}
+
+func (s *asOfStore) Quad(k graph.Ref) quad.Quad {
+	return s.qs.Quad(k)
+}
+
+func (s *asOfStore) QuadIterator(dir quad.Direction, v graph.Ref) iterator.Shape {
+	return s.qs.quadIterator(dir, v, s.epoch)
+}
+
+func (s *asOfStore) QuadIteratorSize(ctx context.Context, d quad.Direction, v graph.Ref) (refs.Size, error) {
+	sz, err := s.qs.QuadIteratorSize(ctx, d, v)
+	sz.Exact = false
+	return sz, err
+}
+
+func (s *asOfStore) QuadDirection(val graph.Ref, d quad.Direction) graph.Ref {
+	return s.qs.QuadDirection(val, d)
+}
+
+func (s *asOfStore) Stats(ctx context.Context, exact bool) (graph.Stats, error) {
+	st, err := s.qs.Stats(ctx, false)
+	if err != nil {
+		return st, err
+	}
+	st.Epoch = s.epoch
+	st.Quads.Exact = false
+	if exact {
+		st.Nodes.Value = 0
+		it := s.NodesAllIterator().Iterate()
+		defer it.Close()
+		for it.Next(ctx) {
+			st.Nodes.Value++
+		}
+		if err := it.Err(); err != nil {
+			return st, err
+		}
+		st.Nodes.Exact = true
+	}
+	return st, nil
+}
+
+func (s *asOfStore) ApplyDeltas(epoch int64, in []graph.Delta, opts graph.IgnoreOpts) error {
+	return graph.ErrReadOnlyHistory
+}
+
+func (s *asOfStore) NewQuadWriter() (quad.WriteCloser, error) {
+	return nil, graph.ErrReadOnlyHistory
+}
+
+func (s *asOfStore) NodesAllIterator() iterator.Shape {
+	return s.qs.newAllIterator(true, nil, s.epoch)
+}
+
+func (s *asOfStore) QuadsAllIterator() iterator.Shape {
+	return s.qs.newAllIterator(false, nil, s.epoch)
+}
+
+func (s *asOfStore) OptimizeShape(ctx context.Context, sh shape.Shape) (shape.Shape, bool) {
+	switch sh := sh.(type) {
+	case shape.QuadsAction:
+		return s.qs.optimizeQuadsAction(sh, s.epoch)
+	}
+	return sh, false
+}
+
+// Close does nothing; the underlying quad store must be closed separately.
+func (s *asOfStore) Close() error {
+	return nil
+}
@@ -0,0 +1,359 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hidal-go/hidalgo/kv"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/clog"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+)
+
+var _ graph.IndexManager = (*QuadStore)(nil)
+
+// indexBuildBatch is the number of log entries indexed in a single transaction while building an index.
+const indexBuildBatch = 10000
+
+// IndexBuild is the progress of building a quad index over quads that existed when the index was added.
+// Quads added later are indexed by writes.
+type IndexBuild struct {
+	// Next is the first log id that is not indexed yet.
+	Next uint64 `json:"next"`
+	// Last is the last log id that existed when the index was added.
+	Last uint64 `json:"last"`
+}
+
+func (ind QuadIndex) sameDirs(dirs []quad.Direction) bool {
+	if len(ind.Dirs) != len(dirs) {
+		return false
+	}
+	for i, d := range ind.Dirs {
+		if d != dirs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (ind QuadIndex) info() graph.Index {
+	info := graph.Index{Dirs: ind.Dirs, Unique: ind.Unique, Complete: ind.Build == nil, Progress: 1}
+	if b := ind.Build; b != nil {
+		info.Progress = float64(b.Next-1) / float64(b.Last)
+	}
+	return info
+}
+
+func findIndex(list []QuadIndex, dirs []quad.Direction) int {
+	for i, ind := range list {
+		if ind.sameDirs(dirs) {
+			return i
+		}
+	}
+	return -1
+}
+
+func validateIndexDirs(dirs []quad.Direction) error {
+	if len(dirs) == 0 {
+		return fmt.Errorf("kv: index must have at least one direction")
+	}
+	seen := make(map[quad.Direction]bool, len(dirs))
+	for _, d := range dirs {
+		if d < quad.Subject || d > quad.Label {
+			return fmt.Errorf("kv: invalid index direction: %v", d)
+		} else if seen[d] {
+			return fmt.Errorf("kv: duplicate index direction: %v", d)
+		}
+		seen[d] = true
+	}
+	return nil
+}
+
+// completeIndexes returns quad indexes that were built over all existing quads.
+// Only these indexes can be used to read quads.
+func completeIndexes(all []QuadIndex) []QuadIndex {
+	for i, ind := range all {
+		if ind.Build == nil {
+			continue
+		}
+		out := append([]QuadIndex{}, all[:i]...)
+		for _, ind := range all[i+1:] {
+			if ind.Build == nil {
+				out = append(out, ind)
+			}
+		}
+		return out
+	}
+	return all
+}
+
+// putIndexesMeta writes a list of quad indexes to the metadata. See setIndexes.
+func putIndexesMeta(tx kv.Tx, list []QuadIndex) error {
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return tx.Put(keyMetaIndexes, data)
+}
+
+// setIndexes replaces the list of quad indexes after it was written to the metadata.
+// The list must not be modified after this call, since readers use it without holding the lock.
+func (qs *QuadStore) setIndexes(list []QuadIndex) {
+	qs.indexes.Lock()
+	qs.indexes.all = list
+	qs.indexes.exists = nil
+	qs.indexes.Unlock()
+}
+
+// Indexes implements graph.IndexManager.
+func (qs *QuadStore) Indexes(ctx context.Context) ([]graph.Index, error) {
+	qs.indexes.RLock()
+	all := qs.indexes.all
+	qs.indexes.RUnlock()
+	out := make([]graph.Index, 0, len(all))
+	for _, ind := range all {
+		out = append(out, ind.info())
+	}
+	return out, nil
+}
+
+// AddIndex implements graph.IndexManager.
+//
+// The index is added to the metadata as incomplete, thus all following writes update it, and then quads
+// that existed before are indexed in batches, in the order of the log. Writes are only blocked while a batch
+// is indexed. The progress is recorded to the metadata together with each batch, so the build can be resumed
+// after an interruption. Queries and duplicate checks start using the index once the build is complete.
+func (qs *QuadStore) AddIndex(ctx context.Context, dirs []quad.Direction, progress func(graph.Index)) error {
+	if err := validateIndexDirs(dirs); err != nil {
+		return err
+	}
+	if err := qs.addIndex(ctx, dirs); err != nil {
+		return err
+	}
+	for {
+		ind, err := qs.buildIndexBatch(ctx, dirs)
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(ind.info())
+		}
+		if ind.Build == nil {
+			if clog.V(1) {
+				clog.Infof("kv: index %s is complete", ind.bucket()[0])
+			}
+			return nil
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// addIndex adds an incomplete index to the metadata, unless it already exists.
+func (qs *QuadStore) addIndex(ctx context.Context, dirs []quad.Direction) error {
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+	qs.indexes.RLock()
+	all := qs.indexes.all
+	qs.indexes.RUnlock()
+	if i := findIndex(all, dirs); i >= 0 {
+		if all[i].Build == nil {
+			return graph.ErrIndexExists
+		}
+		return nil // resume the build
+	}
+	ind := QuadIndex{Dirs: append([]quad.Direction{}, dirs...)}
+	list := append(append([]QuadIndex{}, all...), ind)
+	err := kv.Update(ctx, qs.db, func(tx kv.Tx) error {
+		horizon, err := qs.getMetaIntTx(ctx, tx, "horizon")
+		if err != nil && err != kv.ErrNotFound {
+			return err
+		}
+		if horizon > 0 {
+			list[len(list)-1].Build = &IndexBuild{Next: 1, Last: uint64(horizon)}
+		}
+		_ = kv.CreateBucket(ctx, tx, ind.bucket())
+		return putIndexesMeta(tx, list)
+	})
+	if err != nil {
+		return err
+	}
+	qs.setIndexes(list)
+	return nil
+}
+
+// buildIndexBatch indexes the next batch of log entries for an incomplete index and returns the updated index.
+func (qs *QuadStore) buildIndexBatch(ctx context.Context, dirs []quad.Direction) (QuadIndex, error) {
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+	qs.indexes.RLock()
+	all := qs.indexes.all
+	qs.indexes.RUnlock()
+	i := findIndex(all, dirs)
+	if i < 0 {
+		// dropped while building
+		return QuadIndex{}, graph.ErrIndexNotExist
+	}
+	ind := all[i]
+	if ind.Build == nil {
+		return ind, nil
+	}
+	b := *ind.Build
+	last := b.Next + indexBuildBatch - 1
+	if last > b.Last {
+		last = b.Last
+	}
+	ids := make([]uint64, 0, last-b.Next+1)
+	for id := b.Next; id <= last; id++ {
+		ids = append(ids, id)
+	}
+	if b.Next = last + 1; b.Next > b.Last {
+		ind.Build = nil
+	} else {
+		ind.Build = &b
+	}
+	list := append([]QuadIndex{}, all...)
+	list[i] = ind
+
+	var keys []kv.Key
+	err := kv.Update(ctx, qs.db, func(tx kv.Tx) error {
+		tx = wrapTx(tx)
+		if err := qs.invalidateFilters(tx); err != nil {
+			return err
+		}
+		prims, err := qs.getPrimitivesFromLog(ctx, tx, ids)
+		if err != nil {
+			return err
+		}
+		entries := make(map[string][]uint64)
+		for _, p := range prims {
+			// removed nodes and compacted quads are missing; deleted quads are indexed
+			if p == nil || p.IsNode() {
+				continue
+			}
+			k := ind.KeyFor(p)
+			entries[string(k[1])] = append(entries[string(k[1])], p.ID)
+		}
+		keys = make([]kv.Key, 0, len(entries))
+		for k := range entries {
+			keys = append(keys, ind.bucket().AppendBytes([]byte(k)))
+		}
+		sort.Sort(kv.ByKey(keys))
+		vals, err := tx.GetBatch(ctx, keys)
+		if err != nil {
+			return err
+		}
+		for j, k := range keys {
+			cur, err := decodeIndex(vals[j])
+			if err != nil {
+				return fmt.Errorf("cannot decode index entry: %v", err)
+			}
+			// quads added after the index was created are already listed
+			ids := unionSortedUint64(cur, entries[string(k[1])])
+			if err = tx.Put(k, appendIndex(nil, ids)); err != nil {
+				return err
+			}
+		}
+		return putIndexesMeta(tx, list)
+	})
+	if err != nil {
+		return QuadIndex{}, err
+	}
+	qs.setIndexes(list)
+	if qs.mapBloom != nil && len(keys) != 0 {
+		// bulk load assumes that keys missing in the filter do not exist
+		bucket := string(ind.bucket()[0])
+		bloom := qs.mapBloom[bucket]
+		if bloom == nil {
+			bloom = newBloomFilter(100*1000*1000, 0.05)
+			qs.mapBloom[bucket] = bloom
+		}
+		for _, k := range keys {
+			bloom.Add(k[1])
+		}
+	}
+	return ind, nil
+}
+
+// DropIndex implements graph.IndexManager.
+//
+// The index is removed from the metadata first, and then its entries are removed in batches.
+// Writes are blocked until all entries are removed.
+func (qs *QuadStore) DropIndex(ctx context.Context, dirs []quad.Direction) error {
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
+	qs.indexes.RLock()
+	all := qs.indexes.all
+	qs.indexes.RUnlock()
+	i := findIndex(all, dirs)
+	if i < 0 {
+		return graph.ErrIndexNotExist
+	}
+	ind := all[i]
+	if ind.Build == nil {
+		complete := 0
+		for _, in := range all {
+			if in.Build == nil {
+				complete++
+			}
+		}
+		if complete == 1 {
+			return graph.ErrLastIndex
+		}
+	}
+	list := append(append([]QuadIndex{}, all[:i]...), all[i+1:]...)
+	err := kv.Update(ctx, qs.db, func(tx kv.Tx) error {
+		if err := qs.invalidateFilters(tx); err != nil {
+			return err
+		}
+		return putIndexesMeta(tx, list)
+	})
+	if err != nil {
+		return err
+	}
+	qs.setIndexes(list)
+	delete(qs.mapBloom, string(ind.bucket()[0]))
+	for {
+		var ops []compactOp
+		err := kv.View(qs.db, func(tx kv.Tx) error {
+			it := tx.Scan(ind.bucket())
+			defer it.Close()
+			for len(ops) < indexBuildBatch && it.Next(ctx) {
+				ops = append(ops, compactOp{key: it.Key().Clone()})
+			}
+			return it.Err()
+		})
+		if err != nil {
+			return err
+		} else if len(ops) == 0 {
+			return nil
+		}
+		if err = qs.writeCompactOps(ctx, ops); err != nil {
+			return err
+		}
+	}
+}
+
+// unionSortedUint64 merges two sorted lists, skipping duplicates.
+func unionSortedUint64(a, b []uint64) []uint64 {
+	c := make([]uint64, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			c = append(c, a[0])
+			a = a[1:]
+		case a[0] > b[0]:
+			c = append(c, b[0])
+			b = b[1:]
+		default:
+			c = append(c, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	c = append(c, a...)
+	return append(c, b...)
+}
@@ -35,13 +35,16 @@ import (
 
 	"github.com/hidal-go/hidalgo/kv"
 	"github.com/prometheus/client_golang/prometheus"
-	boom "github.com/tylertreat/BoomFilters"
 )
 
 var (
 	metaBucket = kv.Key{[]byte("meta")}
 	logIndex   = kv.Key{[]byte("log")}
 	cidIndex   = kv.Key{[]byte("cid")}
+	epochIndex = kv.Key{[]byte("epoch")}
+	undoIndex  = kv.Key{[]byte("undo")}
+	// quadCidIndex is the reverse of the cid index, it lists cids of each quad, see quadCidKey.
+	quadCidIndex = kv.Key{[]byte("quadcid")}
 
 	keyMetaIndexes = metaBucket.AppendBytes([]byte("indexes"))
 
@@ -50,6 +53,9 @@ var (
 		metaBucket,
 		logIndex,
 		cidIndex,
+		epochIndex,
+		undoIndex,
+		quadCidIndex,
 	}
 
 	DefaultQuadIndexes = []QuadIndex{
@@ -69,6 +75,8 @@ var quadKeyEnc = binary.BigEndian
 type QuadIndex struct {
 	Dirs   []quad.Direction `json:"dirs"`
 	Unique bool             `json:"unique"`
+	// Build is set while the index is being built over existing quads, see QuadStore.AddIndex.
+	Build *IndexBuild `json:"build,omitempty"`
 }
 
 func (ind QuadIndex) Key(vals []uint64) kv.Key {
@@ -217,9 +225,9 @@ func (qs *QuadStore) resolveValDeltas(ctx context.Context, tx kv.Tx, deltas []gr
 			continue
 		}
 		ind := inds[i]
-		id, _ := binary.Uvarint(b)
+		id, dead := decodeValueEntry(b)
 		d := &deltas[ind]
-		if iri, ok := d.Val.(quad.IRI); ok && id != 0 {
+		if iri, ok := d.Val.(quad.IRI); ok && id != 0 && !dead {
 			qs.valueLRU.Put(string(iri), uint64(id))
 		}
 		fnc(ind, uint64(id))
@@ -275,7 +283,9 @@ type nodeUpdate struct {
 	graphlog.NodeUpdate
 }
 
-func (qs *QuadStore) incNodesCnt(ctx context.Context, tx kv.Tx, deltas, newDeltas []nodeUpdate) ([]int, error) {
+// incNodesCnt updates reference counters of nodes. It returns indexes of nodes with no references left,
+// and indexes of kept nodes that are referenced again, see decNodes.
+func (qs *QuadStore) incNodesCnt(ctx context.Context, tx kv.Tx, deltas, newDeltas []nodeUpdate) ([]int, []int, error) {
 	var buf [binary.MaxVarintLen64]byte
 	// increment nodes
 	keys := make([]kv.Key, 0, len(deltas))
@@ -284,9 +294,9 @@ func (qs *QuadStore) incNodesCnt(ctx context.Context, tx kv.Tx, deltas, newDelta
 	}
 	sizes, err := tx.GetBatch(ctx, keys)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	var del []int
+	var del, revived []int
 	for i, d := range deltas {
 		k := keys[i]
 		var sz int64
@@ -294,11 +304,13 @@ func (qs *QuadStore) incNodesCnt(ctx context.Context, tx kv.Tx, deltas, newDelta
 			szu, _ := binary.Uvarint(sizes[i])
 			sz = int64(szu)
 			sizes[i] = nil // cannot reuse buffer since it belongs to kv
+		} else if d.RefInc > 0 {
+			revived = append(revived, i)
 		}
 		sz += int64(d.RefInc)
 		if sz <= 0 {
 			if err := tx.Del(k); err != nil {
-				return del, err
+				return del, revived, err
 			}
 			mNodesDel.Inc()
 			del = append(del, i)
@@ -307,7 +319,7 @@ func (qs *QuadStore) incNodesCnt(ctx context.Context, tx kv.Tx, deltas, newDelta
 		n := binary.PutUvarint(buf[:], uint64(sz))
 		val := append([]byte{}, buf[:n]...)
 		if err := tx.Put(k, val); err != nil {
-			return del, err
+			return del, revived, err
 		}
 		mNodesUpd.Inc()
 	}
@@ -316,11 +328,11 @@ func (qs *QuadStore) incNodesCnt(ctx context.Context, tx kv.Tx, deltas, newDelta
 		n := binary.PutUvarint(buf[:], uint64(d.RefInc))
 		val := append([]byte{}, buf[:n]...)
 		if err := tx.Put(bucketKeyForHashRefs(d.Hash), val); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		mNodesNew.Inc()
 	}
-	return del, nil
+	return del, revived, nil
 }
 
 type resolvedNode struct {
@@ -328,7 +340,7 @@ type resolvedNode struct {
 	New bool
 }
 
-func (qs *QuadStore) incNodes(ctx context.Context, tx kv.Tx, deltas []graphlog.NodeUpdate) (map[refs.ValueHash]resolvedNode, error) {
+func (qs *QuadStore) incNodes(ctx context.Context, tx kv.Tx, deltas []graphlog.NodeUpdate, hist history) (map[refs.ValueHash]resolvedNode, error) {
 	var (
 		ins []nodeUpdate
 		upd = make([]nodeUpdate, 0, len(deltas))
@@ -362,6 +374,9 @@ func (qs *QuadStore) incNodes(ctx context.Context, tx kv.Tx, deltas []graphlog.N
 			}
 
 			node.ID = id
+			if !hist.revert {
+				node.AddedEpoch = hist.epoch
+			}
 			ids[iv.Hash] = resolvedNode{ID: id, New: true}
 			if err := qs.indexNode(tx, node, iv.Val); err != nil {
 				return ids, err
@@ -369,10 +384,30 @@ func (qs *QuadStore) incNodes(ctx context.Context, tx kv.Tx, deltas []graphlog.N
 			ins[i].ID = id
 		}
 	}
-	_, err = qs.incNodesCnt(ctx, tx, upd, ins)
-	return ids, err
+	_, revived, err := qs.incNodesCnt(ctx, tx, upd, ins)
+	if err != nil {
+		return ids, err
+	}
+	for _, i := range revived {
+		p, err := qs.getPrimitiveFromLog(ctx, tx, upd[i].ID)
+		if err != nil {
+			return ids, err
+		}
+		p.Deleted, p.DeletedEpoch = false, 0
+		if err := qs.addToLog(tx, p); err != nil {
+			return ids, err
+		}
+		if err := tx.Put(bucketKeyForHash(upd[i].Hash), valueEntry(p.ID, false)); err != nil {
+			return ids, err
+		}
+	}
+	return ids, nil
 }
-func (qs *QuadStore) decNodes(ctx context.Context, tx kv.Tx, deltas []graphlog.NodeUpdate, nodes map[refs.ValueHash]uint64) error {
+
+// decNodes decrements reference counters of nodes and removes nodes with no references left.
+// If keep is set, such nodes are only marked as deleted, so quads of past epochs can still be resolved.
+// Kept nodes are restored once they are referenced again.
+func (qs *QuadStore) decNodes(ctx context.Context, tx kv.Tx, deltas []graphlog.NodeUpdate, nodes map[refs.ValueHash]uint64, hist history, keep bool) error {
 	upds := make([]nodeUpdate, 0, len(deltas))
 	for i, d := range deltas {
 		id := nodes[d.Hash]
@@ -381,19 +416,33 @@ func (qs *QuadStore) decNodes(ctx context.Context, tx kv.Tx, deltas []graphlog.N
 		}
 		upds = append(upds, nodeUpdate{Ind: i, ID: id, NodeUpdate: d})
 	}
-	del, err := qs.incNodesCnt(ctx, tx, upds, nil)
+	del, _, err := qs.incNodesCnt(ctx, tx, upds, nil)
 	if err != nil {
 		return err
 	}
 	for _, i := range del {
 		d := upds[i]
-		key := bucketForVal(d.Hash[0], d.Hash[1]).AppendBytes(d.Hash[:])
-		if err = tx.Del(key); err != nil {
-			return err
-		}
 		if iri, ok := d.Val.(quad.IRI); ok {
 			qs.valueLRU.Del(string(iri))
 		}
+		key := bucketKeyForHash(d.Hash)
+		if keep {
+			p, err := qs.getPrimitiveFromLog(ctx, tx, d.ID)
+			if err != nil {
+				return err
+			}
+			if err := qs.markAsDead(tx, p, hist); err != nil {
+				return err
+			}
+			// live lookups skip marked entries without reading the log, see resolveQuadValues
+			if err := tx.Put(key, valueEntry(d.ID, true)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = tx.Del(key); err != nil {
+			return err
+		}
 		if err := qs.delLog(tx, d.ID); err != nil {
 			return err
 		}
@@ -453,9 +502,14 @@ func (w *quadWriter) WriteQuads(buf []quad.Quad) (int, error) {
 			return 0, err
 		}
 		w.tx = wrapTx(tx)
+		// the writer lock is held until Close, thus filters are invalidated once
+		if err = w.qs.invalidateFilters(w.tx); err != nil {
+			w.err = err
+			return 0, err
+		}
 	}
 	deltas := graphlog.InsertQuads(buf)
-	if _, err := w.qs.applyAddDeltas(w.tx, nil, deltas, graph.IgnoreOpts{IgnoreDup: true}); err != nil {
+	if _, err := w.qs.applyAddDeltas(w.tx, nil, deltas, graph.IgnoreOpts{IgnoreDup: true}, nil, history{}); err != nil {
 		w.err = err
 		return 0, err
 	}
@@ -493,19 +547,21 @@ func (w *quadWriter) Close() error {
 	return err
 }
 
-func (qs *QuadStore) applyAddDeltas(tx kv.Tx, in []graph.Delta, deltas *graphlog.Deltas, ignoreOpts graph.IgnoreOpts) (map[refs.ValueHash]resolvedNode, error) {
+func (qs *QuadStore) applyAddDeltas(tx kv.Tx, in []graph.Delta, deltas *graphlog.Deltas, ignoreOpts graph.IgnoreOpts, undo *undoLog, hist history) (map[refs.ValueHash]resolvedNode, error) {
 	ctx := context.TODO()
 
 	// first add all new nodes
-	nodes, err := qs.incNodes(ctx, tx, deltas.IncNode)
+	nodes, err := qs.incNodes(ctx, tx, deltas.IncNode, hist)
 	if err != nil {
 		return nil, err
 	}
 	deltas.IncNode = nil
 	// resolve and insert all new quads
 	links := make([]proto.Primitive, 0, len(deltas.QuadAdd))
-	lkCids := make([]string, 0, len(deltas.QuadAdd))
-	qadd := make(map[[4]uint64]struct{}, len(deltas.QuadAdd))
+	lkInds := make([]int, 0, len(deltas.QuadAdd))
+	// cid refs are resolved to quad ids after new links are allocated
+	var crefs []cidRef
+	qadd := make(map[[4]uint64]cidRef, len(deltas.QuadAdd))
 	for _, q := range deltas.QuadAdd {
 		var link proto.Primitive
 		mustBeNew := false
@@ -519,10 +575,17 @@ func (qs *QuadStore) applyAddDeltas(tx kv.Tx, in []graph.Delta, deltas *graphlog
 			link.SetDirection(dir, n.ID)
 			qkey[i] = n.ID
 		}
-		if _, ok := qadd[qkey]; ok {
+		var cid string
+		if len(in) != 0 {
+			cid = in[q.Ind].Cid
+		}
+		if r, ok := qadd[qkey]; ok {
+			if cid != "" {
+				r.cid = cid
+				crefs = append(crefs, r)
+			}
 			continue
 		}
-		qadd[qkey] = struct{}{}
 		if !mustBeNew {
 			p, err := qs.hasPrimitive(ctx, tx, &link, false)
 			if err != nil {
@@ -530,7 +593,12 @@ func (qs *QuadStore) applyAddDeltas(tx kv.Tx, in []graph.Delta, deltas *graphlog
 			}
 			if p != nil {
 				if ignoreOpts.IgnoreDup {
-					continue // already exists, no need to insert
+					// already exists, no need to insert, but it is still provided by this cid
+					qadd[qkey] = cidRef{link: -1, id: p.ID}
+					if cid != "" {
+						crefs = append(crefs, cidRef{cid: cid, link: -1, id: p.ID})
+					}
+					continue
 				}
 				err = graph.ErrQuadExists
 				if len(in) != 0 {
@@ -539,9 +607,13 @@ func (qs *QuadStore) applyAddDeltas(tx kv.Tx, in []graph.Delta, deltas *graphlog
 				return nil, err
 			}
 		}
+		qadd[qkey] = cidRef{link: len(links)}
+		if cid != "" {
+			crefs = append(crefs, cidRef{cid: cid, link: len(links), new: true})
+		}
 		links = append(links, link)
 		if len(in) != 0 {
-			lkCids = append(lkCids, in[q.Ind].Cid)
+			lkInds = append(lkInds, q.Ind)
 		}
 	}
 	qadd = nil
@@ -555,13 +627,30 @@ func (qs *QuadStore) applyAddDeltas(tx kv.Tx, in []graph.Delta, deltas *graphlog
 		links[i].ID = qstart + uint64(i)
 		links[i].Timestamp = time.Now().UnixNano()
 	}
-	if err := qs.indexLinks(ctx, tx, links, lkCids); err != nil {
+	for i, ind := range lkInds {
+		links[i].AddedEpoch = hist.addedAt(ind)
+		undo.add(&links[i], in[ind])
+	}
+	if err := qs.indexLinks(ctx, tx, links); err != nil {
+		return nil, err
+	}
+	for i, r := range crefs {
+		if r.link >= 0 {
+			crefs[i].id = links[r.link].ID
+		}
+	}
+	if err := qs.addCidIndex(ctx, tx, crefs, undo); err != nil {
 		return nil, err
 	}
 	return nodes, nil
 }
 
 func (qs *QuadStore) ApplyDeltas(epoch int64, in []graph.Delta, ignoreOpts graph.IgnoreOpts) error {
+	return qs.applyEpoch(epoch, in, ignoreOpts, nil)
+}
+
+// applyEpoch applies deltas at a given epoch and sets metadata values in the same transaction, see graph.MetaStore.
+func (qs *QuadStore) applyEpoch(epoch int64, in []graph.Delta, ignoreOpts graph.IgnoreOpts, meta map[string][]byte) error {
 	mApplyBatch.Observe(float64(len(in)))
 	defer prometheus.NewTimer(mApplySeconds).ObserveDuration()
 
@@ -575,16 +664,43 @@ func (qs *QuadStore) ApplyDeltas(epoch int64, in []graph.Delta, ignoreOpts graph
 	defer tx.Close()
 	tx = wrapTx(tx)
 
+	var undo *undoLog
+	if epoch > 0 {
+		undo = &undoLog{}
+	}
+	if err = qs.invalidateFilters(tx); err != nil {
+		return err
+	}
+	if err = qs.applyDeltas(ctx, tx, in, ignoreOpts, undo, history{epoch: epoch}); err != nil {
+		return err
+	}
+
+	if epoch > 0 {
+		if err = qs.writeUndoLog(ctx, tx, epoch, undo); err != nil {
+			return err
+		}
+		if err = qs.setEpoch(tx, epoch); err != nil {
+			return err
+		}
+	}
+	if err = putMeta(tx, meta); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// applyDeltas applies deltas in a given transaction. If undo log is not nil, all applied changes will be recorded to it.
+// Epochs of the changes are recorded to primitives according to the history.
+func (qs *QuadStore) applyDeltas(ctx context.Context, tx kv.Tx, in []graph.Delta, ignoreOpts graph.IgnoreOpts, undo *undoLog, hist history) error {
 	deltas := graphlog.SplitDeltas(in)
 	if len(deltas.QuadDel) != 0 || len(deltas.DecNode) != 0 {
 		qs.mapNodes = nil
 	}
 
-	nodes, err := qs.applyAddDeltas(tx, in, deltas, ignoreOpts)
+	nodes, err := qs.applyAddDeltas(tx, in, deltas, ignoreOpts, undo, hist)
 	if err != nil {
 		return err
 	}
-
 	if len(deltas.QuadDel) != 0 || len(deltas.DecNode) != 0 {
 		links := make([]proto.Primitive, 0, len(deltas.QuadDel))
 		cids := make([]string, 0, len(deltas.QuadDel))
@@ -598,25 +714,62 @@ func (qs *QuadStore) ApplyDeltas(epoch int64, in []graph.Delta, ignoreOpts graph
 			return err
 		}
 
+		// resolve quads of all deleted cids; quads that are still provided by other cids are kept
+		cidPrims := make(map[string][]*proto.Primitive)
+		for _, q := range deltas.QuadDel {
+			cid := in[q.Ind].Cid
+			if len(cid) == 0 {
+				continue
+			} else if _, ok := cidPrims[cid]; ok {
+				continue
+			}
+			prims, err := qs.getPrimitivesByCid(ctx, tx, cid)
+			if err != nil {
+				return err
+			}
+			cidPrims[cid] = prims
+		}
+		shared, err := qs.sharedCidQuads(ctx, tx, cidPrims)
+		if err != nil {
+			return err
+		}
+
 		// check for existence and delete quads
 		fixNodes := make(map[refs.ValueHash]int)
+		seen := make(map[uint64]struct{})
+		seenCids := make(map[string]struct{})
 		for _, q := range deltas.QuadDel {
-			var link proto.Primitive
-			exists := true
+			var found []proto.Primitive
 			byCid := len(in[q.Ind].Cid) > 0
 			if byCid {
-				l, err := qs.getPrimitiveByCid(ctx, tx, in[q.Ind].Cid)
-				if err != nil && err != kv.ErrNotFound {
-					return err
+				cid := in[q.Ind].Cid
+				if _, ok := seenCids[cid]; ok {
+					continue // already deleted by another delta in this batch
+				}
+				seenCids[cid] = struct{}{}
+				dropped := false
+				for _, p := range cidPrims[cid] {
+					if _, ok := shared[p.ID]; !ok {
+						found = append(found, *p)
+						continue
+					}
+					// only drop the reference from this cid, but keep it in the undo log
+					qd, err := qs.linkQuad(ctx, tx, p)
+					if err != nil {
+						return err
+					}
+					undo.delCid(cid, qd)
+					dropped = true
 				}
-				if l != nil {
-					link = *l
-				} else {
-					exists = false
+				if len(found) == 0 && dropped {
+					cids = append(cids, cid)
+					continue
 				}
 			} else {
 				// resolve values of all quad directions
 				// if any of the direction does not exists, the quad does not exists as well
+				var link proto.Primitive
+				exists := true
 				for _, dir := range quad.Directions {
 					h := q.Quad.Get(dir)
 					n, ok := nodes[h]
@@ -635,14 +788,12 @@ func (qs *QuadStore) ApplyDeltas(epoch int64, in []graph.Delta, ignoreOpts graph
 					p, err := qs.hasPrimitive(ctx, tx, &link, true)
 					if err != nil {
 						return err
-					} else if p == nil || p.Deleted {
-						exists = false
-					} else {
-						link = *p
+					} else if p != nil && !p.Deleted {
+						found = append(found, *p)
 					}
 				}
 			}
-			if !exists {
+			if len(found) == 0 {
 				if !ignoreOpts.IgnoreMissing {
 					return &graph.DeltaError{Delta: in[q.Ind], Err: graph.ErrQuadNotExist}
 				}
@@ -656,40 +807,61 @@ func (qs *QuadStore) ApplyDeltas(epoch int64, in []graph.Delta, ignoreOpts graph
 				}
 				continue
 			}
-			links = append(links, link)
 			if byCid {
 				cids = append(cids, in[q.Ind].Cid)
-				for _, dir := range quad.Directions {
-					id := link.GetDirection(dir)
-					if id == 0 {
-						continue
-					}
-					dec, ok := decNodes[id]
-					if !ok {
-						p, err := qs.getPrimitiveFromLog(ctx, tx, id)
-						if err != nil {
-							return err
-						}
-						v, err := pquads.UnmarshalValue(p.Value)
-						if err != nil {
-							return err
+			}
+			for _, link := range found {
+				if _, ok := seen[link.ID]; ok {
+					// already deleted by another delta in this batch
+					if !byCid {
+						for _, dir := range quad.Directions {
+							if h := q.Quad.Get(dir); h.Valid() {
+								fixNodes[h]++
+							}
 						}
-						if v == nil {
-							return graph.ErrNodeNotExists
+					}
+					continue
+				}
+				seen[link.ID] = struct{}{}
+				links = append(links, link)
+				d := in[q.Ind]
+				if byCid {
+					// quad is not known for deltas by cid, restore it from node values
+					d.Quad = quad.Quad{}
+					for _, dir := range quad.Directions {
+						id := link.GetDirection(dir)
+						if id == 0 {
+							continue
 						}
-						dec = &graphlog.NodeUpdate{
-							Hash: refs.HashOf(v),
-							Val:  v,
+						dec, ok := decNodes[id]
+						if !ok {
+							p, err := qs.getPrimitiveFromLog(ctx, tx, id)
+							if err != nil {
+								return err
+							}
+							v, err := pquads.UnmarshalValue(p.Value)
+							if err != nil {
+								return err
+							}
+							if v == nil {
+								return graph.ErrNodeNotExists
+							}
+							dec = &graphlog.NodeUpdate{
+								Hash: refs.HashOf(v),
+								Val:  v,
+							}
+							decNodes[id] = dec
+							dnodes[dec.Hash] = id
 						}
-						decNodes[id] = dec
-						dnodes[dec.Hash] = id
+						dec.RefInc--
+						d.Quad.Set(dir, dec.Val)
 					}
-					dec.RefInc--
 				}
+				undo.add(&link, d)
 			}
 		}
 		deltas.QuadDel = nil
-		if err := qs.markLinksDead(ctx, tx, links); err != nil {
+		if err := qs.markLinksDead(ctx, tx, links, hist); err != nil {
 			return err
 		}
 		if err := qs.delCids(ctx, tx, cids); err != nil {
@@ -725,28 +897,31 @@ func (qs *QuadStore) ApplyDeltas(epoch int64, in []graph.Delta, ignoreOpts graph
 		sort.Slice(deltas, func(i, j int) bool {
 			return bytes.Compare(deltas[i].Hash[:], deltas[j].Hash[:]) < 0
 		})
-		// finally decrement and remove nodes
-		if err := qs.decNodes(ctx, tx, deltas, dnodes); err != nil {
+		// finally decrement and remove nodes; once the store is synced from a chain
+		// nodes are kept, since quads of past epochs may still reference them
+		keep := hist.epoch > 0
+		if !keep {
+			epoch, err := qs.getMetaIntTx(ctx, tx, "epoch")
+			if err != nil && err != kv.ErrNotFound {
+				return err
+			}
+			keep = epoch > 0
+		}
+		if err := qs.decNodes(ctx, tx, deltas, dnodes, hist, keep); err != nil {
 			return err
 		}
 		deltas = nil
 		dnodes = nil
 		decNodes = nil
 	}
-	// flush quad indexes and commit
-	err = qs.flushMapBucket(ctx, tx)
-	if err != nil {
-		return err
-	}
+	// flush quad indexes
+	return qs.flushMapBucket(ctx, tx)
+}
 
-	if epoch > 0 {
-		var buf [8]byte
-		binary.LittleEndian.PutUint64(buf[:], uint64(epoch))
-		if err := tx.Put(metaBucket.AppendBytes([]byte("epoch")), buf[:]); err != nil {
-			return err
-		}
-	}
-	return tx.Commit(ctx)
+func (qs *QuadStore) setEpoch(tx kv.Tx, epoch int64) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(epoch))
+	return tx.Put(metaBucket.AppendBytes([]byte("epoch")), buf[:])
 }
 
 func (qs *QuadStore) indexNode(tx kv.Tx, p *proto.Primitive, val quad.Value) error {
@@ -758,7 +933,7 @@ func (qs *QuadStore) indexNode(tx kv.Tx, p *proto.Primitive, val quad.Value) err
 		}
 	}
 	hash := quad.HashOf(val)
-	err = tx.Put(bucketForVal(hash[0], hash[1]).AppendBytes(hash), uint64toBytes(p.ID))
+	err = tx.Put(bucketForVal(hash[0], hash[1]).AppendBytes(hash), valueEntry(p.ID, false))
 	if err != nil {
 		return err
 	}
@@ -771,19 +946,12 @@ func (qs *QuadStore) indexNode(tx kv.Tx, p *proto.Primitive, val quad.Value) err
 	return qs.addToLog(tx, p)
 }
 
-func (qs *QuadStore) indexLinks(ctx context.Context, tx kv.Tx, links []proto.Primitive, cids []string) error {
-	ctop := make(map[string]uint64)
-	for i, p := range links {
-		if len(cids) > 0 && len(cids[i]) > 0 {
-			ctop[cids[i]] = p.ID
-		}
+func (qs *QuadStore) indexLinks(ctx context.Context, tx kv.Tx, links []proto.Primitive) error {
+	for _, p := range links {
 		if err := qs.indexLink(tx, &p); err != nil {
 			return err
 		}
 	}
-	if err := qs.addCidIndex(tx, ctop); err != nil {
-		return err
-	}
 	return qs.incSize(ctx, tx, int64(len(links)))
 }
 
@@ -806,8 +974,9 @@ func (qs *QuadStore) indexLink(tx kv.Tx, p *proto.Primitive) error {
 	return qs.addToLog(tx, p)
 }
 
-func (qs *QuadStore) markAsDead(tx kv.Tx, p *proto.Primitive) error {
+func (qs *QuadStore) markAsDead(tx kv.Tx, p *proto.Primitive, hist history) error {
 	p.Deleted = true
+	p.DeletedEpoch = hist.deletedAt(p)
 	//TODO(barakmich): Add tombstone?
 	qs.bloomRemove(p)
 	return qs.addToLog(tx, p)
@@ -817,9 +986,9 @@ func (qs *QuadStore) delLog(tx kv.Tx, id uint64) error {
 	return tx.Del(logIndex.Append(uint64KeyBytes(id)))
 }
 
-func (qs *QuadStore) markLinksDead(ctx context.Context, tx kv.Tx, links []proto.Primitive) error {
+func (qs *QuadStore) markLinksDead(ctx context.Context, tx kv.Tx, links []proto.Primitive, hist history) error {
 	for _, p := range links {
-		if err := qs.markAsDead(tx, &p); err != nil {
+		if err := qs.markAsDead(tx, &p, hist); err != nil {
 			return err
 		}
 	}
@@ -898,7 +1067,9 @@ func (qs *QuadStore) bestUnique() ([]QuadIndex, error) {
 	if len(qs.indexes.exists) != 0 {
 		return qs.indexes.exists, nil
 	}
-	for _, in := range qs.indexes.all {
+	// incomplete indexes may miss existing quads
+	inds := completeIndexes(qs.indexes.all)
+	for _, in := range inds {
 		if in.Unique {
 			if clog.V(2) {
 				clog.Infof("using unique index: %v", in.Dirs)
@@ -908,7 +1079,6 @@ func (qs *QuadStore) bestUnique() ([]QuadIndex, error) {
 		}
 	}
 	// TODO: find best combination of indexes
-	inds := qs.indexes.all
 	if len(inds) == 0 {
 		return nil, fmt.Errorf("no indexes defined")
 	}
@@ -930,7 +1100,7 @@ func hasDir(dirs []quad.Direction, d quad.Direction) bool {
 
 func (qs *QuadStore) bestIndexes(dirs []quad.Direction) []QuadIndex {
 	qs.indexes.RLock()
-	all := qs.indexes.all
+	all := completeIndexes(qs.indexes.all)
 	qs.indexes.RUnlock()
 	var (
 		max  int // more specific index is better
@@ -1071,60 +1241,84 @@ func (qs *QuadStore) flushMapBucket(ctx context.Context, tx kv.Tx) error {
 		if len(m) == 0 {
 			continue
 		}
-		bloom := qs.mapBloom[bucket]
 		mIndexWriteBufferFlushBatch.WithLabelValues(bucket).Observe(float64(len(m)))
-		entryBytes := mIndexEntrySizeBytes.WithLabelValues(bucket)
-		b := kv.Key{[]byte(bucket)}
-		var (
-			keys    []kv.Key
-			keysPut []kv.Key
-		)
-		if qs.mapBloom == nil {
-			keys = make([]kv.Key, 0, len(m))
-		}
-		for k := range m {
-			bk := []byte(k)
-			if qs.mapBloom != nil && (bloom == nil || !bloom.Test(bk)) {
-				keysPut = append(keysPut, b.AppendBytes(bk))
-			} else {
-				keys = append(keys, b.AppendBytes(bk))
-			}
+		entries := make([]indexEntry, 0, len(m))
+		for k, l := range m {
+			entries = append(entries, indexEntry{key: []byte(k), ids: l})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i].key, entries[j].key) < 0
+		})
+		if err := qs.putIndexEntries(ctx, tx, bucket, entries); err != nil {
+			return err
+		}
+		mIndexWriteBufferEntries.WithLabelValues(bucket).Set(0)
+	}
+	qs.mapBucket = nil
+	return nil
+}
+
+// indexEntry is a key in a quad index bucket with quad ids that should be added to it.
+type indexEntry struct {
+	key []byte
+	ids []uint64
+}
+
+// putIndexEntries adds quad ids to entries of a quad index bucket. Entries must be sorted by key.
+//
+// During a bulk load, keys that are missing in the bloom filter of the bucket are written without reading
+// the current value first.
+func (qs *QuadStore) putIndexEntries(ctx context.Context, tx kv.Tx, bucket string, entries []indexEntry) error {
+	bloom := qs.mapBloom[bucket]
+	entryBytes := mIndexEntrySizeBytes.WithLabelValues(bucket)
+	b := kv.Key{[]byte(bucket)}
+	var (
+		keys    []kv.Key
+		keysPut []kv.Key
+		ids     [][]uint64
+		idsPut  [][]uint64
+	)
+	if qs.mapBloom == nil {
+		keys = make([]kv.Key, 0, len(entries))
+		ids = make([][]uint64, 0, len(entries))
+	}
+	for _, e := range entries {
+		if qs.mapBloom != nil && (bloom == nil || !bloom.Test(e.key)) {
+			keysPut = append(keysPut, b.AppendBytes(e.key))
+			idsPut = append(idsPut, e.ids)
+		} else {
+			keys = append(keys, b.AppendBytes(e.key))
+			ids = append(ids, e.ids)
 		}
-		sort.Sort(kv.ByKey(keysPut))
-		sort.Sort(kv.ByKey(keys))
-		vals, err := tx.GetBatch(ctx, keys)
+	}
+	vals, err := tx.GetBatch(ctx, keys)
+	if err != nil {
+		return err
+	}
+	if qs.mapBloom != nil && bloom == nil {
+		bloom = newBloomFilter(100*1000*1000, 0.05)
+		qs.mapBloom[bucket] = bloom
+	}
+	for i, k := range keysPut {
+		err = tx.Put(k, appendIndex(nil, idsPut[i]))
 		if err != nil {
 			return err
 		}
-		if qs.mapBloom != nil && bloom == nil {
-			bloom = boom.NewBloomFilter(100*1000*1000, 0.05)
-			qs.mapBloom[bucket] = bloom
+		if bloom != nil {
+			bloom.Add(k[1])
 		}
-		for _, k := range keysPut {
-			l := m[string(k[1])]
-			err = tx.Put(k, appendIndex(nil, l))
-			if err != nil {
-				return err
-			}
-			if bloom != nil {
-				bloom.Add(k[1])
-			}
+	}
+	for i, k := range keys {
+		buf := appendIndex(vals[i], ids[i])
+		entryBytes.Observe(float64(len(buf)))
+		err = tx.Put(k, buf)
+		if err != nil {
+			return err
 		}
-		for i, k := range keys {
-			l := m[string(k[1])]
-			buf := appendIndex(vals[i], l)
-			entryBytes.Observe(float64(len(buf)))
-			err = tx.Put(k, buf)
-			if err != nil {
-				return err
-			}
-			if bloom != nil {
-				bloom.Add(k[1])
-			}
+		if bloom != nil {
+			bloom.Add(k[1])
 		}
-		mIndexWriteBufferEntries.WithLabelValues(bucket).Set(0)
 	}
-	qs.mapBucket = nil
 	return nil
 }
 
@@ -1132,13 +1326,135 @@ func (qs *QuadStore) indexSchema(tx kv.Tx, p *proto.Primitive) error {
 	return nil
 }
 
-func (qs *QuadStore) addCidIndex(tx kv.Tx, cidToPrimitive map[string]uint64) error {
-	for cid, pid := range cidToPrimitive {
+// cidRef is a reference from a cid to a quad. Quad is either a new link with a given index, or an existing quad.
+type cidRef struct {
+	cid  string
+	link int
+	id   uint64
+	new  bool // reference is added together with a new link and recorded to the undo log with it
+}
+
+// cidPrefix returns a key prefix for all quads of the cid.
+// Cid index entries are stored as "cid/<cid>\x00<id>", thus each cid can reference any number of quads.
+func cidPrefix(cid string) kv.Key {
+	k := make([]byte, len(cid)+1)
+	copy(k, cid)
+	return cidIndex.AppendBytes(k)
+}
+
+func cidKey(cid string, id uint64) kv.Key {
+	k := make([]byte, len(cid)+1+8)
+	copy(k, cid)
+	quadKeyEnc.PutUint64(k[len(cid)+1:], id)
+	return cidIndex.AppendBytes(k)
+}
+
+// legacyCidKey returns a key of the cid index entry written by older versions, which referenced a single quad.
+func legacyCidKey(cid string) kv.Key {
+	return cidIndex.AppendBytes([]byte(cid))
+}
+
+// quadCidPrefix returns a key prefix for all reverse cid index entries of the quad.
+func quadCidPrefix(id uint64) kv.Key {
+	k := make([]byte, 8)
+	quadKeyEnc.PutUint64(k, id)
+	return quadCidIndex.AppendBytes(k)
+}
+
+// quadCidKey returns a key of the reverse cid index entry. Entries are stored as "quadcid/<id><cid>",
+// and the value is the last part of the key of the cid index entry, see cidKey and legacyCidKey.
+func quadCidKey(id uint64, cid string) kv.Key {
+	k := make([]byte, 8+len(cid))
+	quadKeyEnc.PutUint64(k, id)
+	copy(k[8:], cid)
+	return quadCidIndex.AppendBytes(k)
+}
+
+// putCidRef writes the cid index entry with a given key, and its reverse entry.
+func putCidRef(tx kv.Tx, key kv.Key, cid string, id uint64) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, id)
+	if err := tx.Put(key, buf); err != nil {
+		return err
+	}
+	return tx.Put(quadCidKey(id, cid), key[len(key)-1])
+}
+
+// metaQuadCids is set once reverse entries are written for all entries of the cid index.
+const metaQuadCids = "quadcid"
+
+// indexQuadCids writes reverse entries for cid index entries written by older versions, see quadCidKey.
+func (qs *QuadStore) indexQuadCids(ctx context.Context) error {
+	var ops []compactOp
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		if _, err := qs.getMetaIntTx(ctx, tx, metaQuadCids); err == nil {
+			return nil
+		} else if err != kv.ErrNotFound {
+			return err
+		}
+		it := tx.Scan(cidIndex)
+		defer it.Close()
+		for it.Next(ctx) {
+			k, v := it.Key(), it.Val()
+			if len(v) != 8 {
+				continue
+			}
+			id := binary.LittleEndian.Uint64(v)
+			last := append([]byte{}, k[len(k)-1]...)
+			ops = append(ops, compactOp{key: quadCidKey(id, cidOfKey(k, id)), val: last})
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
 		buf := make([]byte, 8)
-		binary.LittleEndian.PutUint64(buf, pid)
-		if err := tx.Put(cidIndex.AppendBytes([]byte(cid)), buf); err != nil {
+		binary.LittleEndian.PutUint64(buf, 1)
+		ops = append(ops, compactOp{key: metaBucket.AppendBytes([]byte(metaQuadCids)), val: buf})
+		return nil
+	})
+	if err != nil || len(ops) == 0 {
+		return err
+	}
+	if len(ops) > 1 {
+		clog.Infof("kv: indexing quads of %d cid references", len(ops)-1)
+	}
+	// the flag is written by the last batch
+	return qs.writeCompactOps(ctx, ops)
+}
+
+// delCidRef removes the cid index entry with a given key, and its reverse entry.
+func delCidRef(tx kv.Tx, key kv.Key, cid string, id uint64) error {
+	if err := tx.Del(key); err != nil {
+		return err
+	}
+	return tx.Del(quadCidKey(id, cid))
+}
+
+func (qs *QuadStore) addCidIndex(ctx context.Context, tx kv.Tx, crefs []cidRef, undo *undoLog) error {
+	type pair struct {
+		cid string
+		id  uint64
+	}
+	seen := make(map[pair]struct{}, len(crefs))
+	for _, r := range crefs {
+		if _, ok := seen[pair{r.cid, r.id}]; ok {
+			continue
+		}
+		seen[pair{r.cid, r.id}] = struct{}{}
+		key := cidKey(r.cid, r.id)
+		if r.link < 0 {
+			_, err := tx.Get(ctx, key)
+			if err == nil {
+				continue // quad is already provided by this cid
+			} else if err != kv.ErrNotFound {
+				return err
+			}
+		}
+		if err := putCidRef(tx, key, r.cid, r.id); err != nil {
 			return err
 		}
+		if !r.new {
+			undo.addCid(r.cid, r.id)
+		}
 	}
 	return nil
 }
@@ -1166,14 +1482,6 @@ func createNodePrimitive(v quad.Value) (*proto.Primitive, error) {
 	return p, nil
 }
 
-func (qs *QuadStore) resolveQuadValue(ctx context.Context, tx kv.Tx, v quad.Value) (uint64, error) {
-	out, err := qs.resolveQuadValues(ctx, tx, []quad.Value{v})
-	if err != nil {
-		return 0, err
-	}
-	return out[0], nil
-}
-
 func bucketKeyForVal(v quad.Value) kv.Key {
 	hash := refs.HashOf(v)
 	return bucketKeyForHash(hash)
@@ -1187,7 +1495,30 @@ func bucketKeyForHashRefs(h refs.ValueHash) kv.Key {
 	return bucketForValRefs(h[0], h[1]).AppendBytes(h[:])
 }
 
-func (qs *QuadStore) resolveQuadValues(ctx context.Context, tx kv.Tx, vals []quad.Value) ([]uint64, error) {
+// valueDead is appended to value index entries of deleted nodes that are kept for queries at past epochs, see decNodes.
+const valueDead = 1
+
+// valueEntry encodes a value index entry of a node.
+func valueEntry(id uint64, dead bool) []byte {
+	b := uint64toBytes(id)
+	if dead {
+		b = append(b, valueDead)
+	}
+	return b
+}
+
+// decodeValueEntry returns the node id of a value index entry, and whether the node is deleted.
+func decodeValueEntry(b []byte) (uint64, bool) {
+	id, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, false
+	}
+	return id, len(b) > n && b[n] == valueDead
+}
+
+// resolveQuadValues returns ids of nodes with given values, or zero for missing values.
+// Deleted nodes are only returned if live is not set.
+func (qs *QuadStore) resolveQuadValues(ctx context.Context, tx kv.Tx, vals []quad.Value, live bool) ([]uint64, error) {
 	out := make([]uint64, len(vals))
 	inds := make([]int, 0, len(vals))
 	keys := make([]kv.Key, 0, len(vals))
@@ -1215,9 +1546,16 @@ func (qs *QuadStore) resolveQuadValues(ctx context.Context, tx kv.Tx, vals []qua
 			continue
 		}
 		ind := inds[i]
-		out[ind], _ = binary.Uvarint(b)
-		if iri, ok := vals[ind].(quad.IRI); ok && out[ind] != 0 {
-			qs.valueLRU.Put(string(iri), uint64(out[ind]))
+		id, dead := decodeValueEntry(b)
+		if dead {
+			if !live {
+				out[ind] = id
+			}
+			continue
+		}
+		out[ind] = id
+		if iri, ok := vals[ind].(quad.IRI); ok && id != 0 {
+			qs.valueLRU.Put(string(iri), id)
 		}
 	}
 	return out, nil
@@ -1276,28 +1614,200 @@ func (qs *QuadStore) getPrimitiveFromLog(ctx context.Context, tx kv.Tx, k uint64
 	return out[0], nil
 }
 
-func (qs *QuadStore) getPrimitiveByCid(ctx context.Context, tx kv.Tx, cid string) (*proto.Primitive, error) {
-	v, err := tx.Get(ctx, cidIndex.AppendBytes([]byte(cid)))
-	if err != nil {
+// getCidKeys returns all cid index keys of the cid and ids of quads they reference.
+func (qs *QuadStore) getCidKeys(ctx context.Context, tx kv.Tx, cid string) ([]kv.Key, []uint64, error) {
+	var (
+		keys []kv.Key
+		ids  []uint64
+	)
+	v, err := tx.Get(ctx, legacyCidKey(cid))
+	if err == nil && len(v) == 8 {
+		keys = append(keys, legacyCidKey(cid))
+		ids = append(ids, binary.LittleEndian.Uint64(v))
+	} else if err != nil && err != kv.ErrNotFound {
+		return nil, nil, err
+	}
+	it := tx.Scan(cidPrefix(cid))
+	defer it.Close()
+	for it.Next(ctx) {
+		v := it.Val()
+		if len(v) != 8 {
+			continue
+		}
+		keys = append(keys, it.Key().Clone())
+		ids = append(ids, binary.LittleEndian.Uint64(v))
+	}
+	return keys, ids, it.Err()
+}
+
+var _ graph.CidIndex = (*QuadStore)(nil)
+
+func (qs *QuadStore) QuadsByCid(ctx context.Context, cid string) ([]graph.Ref, error) {
+	var out []graph.Ref
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		prims, err := qs.getPrimitivesByCid(ctx, tx, cid)
+		if err != nil {
+			return err
+		}
+		for _, p := range prims {
+			out = append(out, p)
+		}
+		return nil
+	})
+	return out, err
+}
+
+var _ graph.CidLister = (*QuadStore)(nil)
+
+func (qs *QuadStore) ForEachCid(ctx context.Context, fn func(cid string, q graph.Ref) error) error {
+	return kv.View(qs.db, func(tx kv.Tx) error {
+		it := tx.Scan(cidIndex)
+		defer it.Close()
+		for it.Next(ctx) {
+			v := it.Val()
+			if len(v) != 8 {
+				continue
+			}
+			id := binary.LittleEndian.Uint64(v)
+			cid := cidOfKey(it.Key(), id)
+			p, err := qs.getPrimitiveFromLog(ctx, tx, id)
+			if err == kv.ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			} else if p.Deleted {
+				continue
+			}
+			if err = fn(cid, p); err != nil {
+				return err
+			}
+		}
+		return it.Err()
+	})
+}
+
+var _ graph.QuadLister = (*QuadStore)(nil)
+
+// ForEachQuad lists live quads in the order of their ids in the log.
+func (qs *QuadStore) ForEachQuad(ctx context.Context, after int64, fn func(pos int64, q graph.Ref) error) error {
+	it := qs.newAllIteratorNext(false, nil, 0)
+	defer it.Close()
+	it.id = uint64(after)
+	for it.Next(ctx) {
+		if err := fn(int64(it.prim.ID), it.prim); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// getPrimitivesByCid returns all live quads that were added with a given cid.
+func (qs *QuadStore) getPrimitivesByCid(ctx context.Context, tx kv.Tx, cid string) ([]*proto.Primitive, error) {
+	_, ids, err := qs.getCidKeys(ctx, tx, cid)
+	if err != nil || len(ids) == 0 {
 		return nil, err
 	}
-	id := binary.LittleEndian.Uint64(v)
-	p, err := qs.getPrimitiveFromLog(ctx, tx, id)
+	prims, err := qs.getPrimitivesFromLog(ctx, tx, ids)
 	if err != nil {
 		return nil, err
 	}
-	if p.Deleted {
-		return nil, kv.ErrNotFound
+	out := prims[:0]
+	for _, p := range prims {
+		if p != nil && !p.Deleted {
+			out = append(out, p)
+		}
 	}
-	return p, nil
+	return out, nil
+}
+
+// sharedCidQuads returns ids of quads of given cids that are referenced by any other cid.
+// Only cids of these quads are listed, see quadCidKey.
+func (qs *QuadStore) sharedCidQuads(ctx context.Context, tx kv.Tx, cids map[string][]*proto.Primitive) (map[uint64]struct{}, error) {
+	shared := make(map[uint64]struct{})
+	seen := make(map[uint64]struct{})
+	for _, prims := range cids {
+		for _, p := range prims {
+			if _, ok := seen[p.ID]; ok {
+				continue
+			}
+			seen[p.ID] = struct{}{}
+			ok, err := qs.hasOtherCids(ctx, tx, p.ID, cids)
+			if err != nil {
+				return nil, err
+			} else if ok {
+				shared[p.ID] = struct{}{}
+			}
+		}
+	}
+	return shared, nil
+}
+
+// hasOtherCids checks if the quad is referenced by any cid that is not in the given set.
+func (qs *QuadStore) hasOtherCids(ctx context.Context, tx kv.Tx, id uint64, cids map[string][]*proto.Primitive) (bool, error) {
+	prefix := quadCidPrefix(id)
+	it := tx.Scan(prefix)
+	defer it.Close()
+	for it.Next(ctx) {
+		k := it.Key()
+		cid := string(k[len(k)-1][8:])
+		if _, ok := cids[cid]; ok {
+			continue
+		}
+		// the reverse entry may outlive the cid entry if the latter was removed by a check
+		_, err := tx.Get(ctx, cidIndex.AppendBytes(it.Val()))
+		if err == nil {
+			return true, nil
+		} else if err != kv.ErrNotFound {
+			return false, err
+		}
+	}
+	return false, it.Err()
+}
+
+// cidOfKey returns the cid of the index entry that references a given quad, see cidKey and legacyCidKey.
+func cidOfKey(k kv.Key, id uint64) string {
+	cid := k[len(k)-1]
+	if n := len(cid) - 9; n >= 0 && cid[n] == 0 && quadKeyEnc.Uint64(cid[n+1:]) == id {
+		cid = cid[:n]
+	}
+	return string(cid)
+}
+
+// linkQuad restores the quad of the link from values of its nodes.
+func (qs *QuadStore) linkQuad(ctx context.Context, tx kv.Tx, p *proto.Primitive) (quad.Quad, error) {
+	var q quad.Quad
+	for _, dir := range quad.Directions {
+		id := p.GetDirection(dir)
+		if id == 0 {
+			continue
+		}
+		n, err := qs.getPrimitiveFromLog(ctx, tx, id)
+		if err != nil {
+			return q, err
+		}
+		v, err := pquads.UnmarshalValue(n.Value)
+		if err != nil {
+			return q, err
+		}
+		if v == nil {
+			return q, graph.ErrNodeNotExists
+		}
+		q.Set(dir, v)
+	}
+	return q, nil
 }
 
 func (qs *QuadStore) delCids(ctx context.Context, tx kv.Tx, cids []string) error {
 	for _, cid := range cids {
-		err := tx.Del(cidIndex.AppendBytes([]byte(cid)))
+		keys, ids, err := qs.getCidKeys(ctx, tx, cid)
 		if err != nil {
 			return err
 		}
+		for i, k := range keys {
+			if err := delCidRef(tx, k, cid, ids[i]); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -1307,7 +1817,7 @@ func (qs *QuadStore) initBloomFilter(ctx context.Context) error {
 		return nil
 	}
 	qs.exists.buf = make([]byte, 3*8)
-	qs.exists.DeletableBloomFilter = boom.NewDeletableBloomFilter(100*1000*1000, 120, 0.05)
+	qs.exists.bloomFilter = newDeletableBloomFilter(100*1000*1000, 120, 0.05)
 	return kv.View(qs.db, func(tx kv.Tx) error {
 		p := proto.Primitive{}
 		it := tx.Scan(logIndex)
@@ -49,3 +49,34 @@ func TestIndexlist(t *testing.T) {
 		}
 	}
 }
+
+func TestUnionSorted(t *testing.T) {
+	tt := []struct {
+		a      []uint64
+		b      []uint64
+		expect []uint64
+	}{
+		{
+			a:      []uint64{1, 3, 5},
+			b:      []uint64{2, 3, 4, 8},
+			expect: []uint64{1, 2, 3, 4, 5, 8},
+		},
+		{
+			a:      nil,
+			b:      []uint64{6, 7},
+			expect: []uint64{6, 7},
+		},
+	}
+
+	for i, x := range tt {
+		c := unionSortedUint64(x.a, x.b)
+		if len(c) != len(x.expect) {
+			t.Errorf("unexpected length: %d expected %d for test %d", len(c), len(x.expect), i)
+		}
+		for j, y := range c {
+			if j < len(x.expect) && y != x.expect[j] {
+				t.Errorf("unexpected entry: %#v expected %#v for test %d", c, x.expect, i)
+			}
+		}
+	}
+}
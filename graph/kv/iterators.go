@@ -14,11 +14,11 @@ import (
 )
 
 func (qs *QuadStore) NodesAllIterator() iterator.Shape {
-	return qs.newAllIterator(true, nil)
+	return qs.newAllIterator(true, nil, 0)
 }
 
 func (qs *QuadStore) QuadsAllIterator() iterator.Shape {
-	return qs.newAllIterator(false, nil)
+	return qs.newAllIterator(false, nil, 0)
 }
 
 func (qs *QuadStore) indexSize(ctx context.Context, ind QuadIndex, vals []uint64) (refs.Size, error) {
@@ -55,7 +55,7 @@ func (qs *QuadStore) QuadIteratorSize(ctx context.Context, d quad.Direction, v g
 		return refs.Size{Value: 0, Exact: true}, nil
 	}
 	qs.indexes.RLock()
-	all := qs.indexes.all
+	all := completeIndexes(qs.indexes.all)
 	qs.indexes.RUnlock()
 	for _, ind := range all {
 		if len(ind.Dirs) == 1 && ind.Dirs[0] == d {
@@ -73,6 +73,10 @@ func (qs *QuadStore) QuadIteratorSize(ctx context.Context, d quad.Direction, v g
 }
 
 func (qs *QuadStore) QuadIterator(dir quad.Direction, v graph.Ref) iterator.Shape {
+	return qs.quadIterator(dir, v, 0)
+}
+
+func (qs *QuadStore) quadIterator(dir quad.Direction, v graph.Ref, asOf int64) iterator.Shape {
 	if v == nil {
 		return iterator.NewNull()
 	}
@@ -83,24 +87,24 @@ func (qs *QuadStore) QuadIterator(dir quad.Direction, v graph.Ref) iterator.Shap
 	// Find the best index for this direction.
 	if ind := qs.bestIndexes([]quad.Direction{dir}); len(ind) == 1 {
 		// this will scan the prefix automatically
-		return qs.newQuadIterator(ind[0], []uint64{uint64(vi)})
+		return qs.newQuadIterator(ind[0], []uint64{uint64(vi)}, asOf)
 	}
 	// Fallback: iterate all quads and check the corresponding direction.
 	return qs.newAllIterator(false, &constraint{
 		dir: dir,
 		val: vi,
-	})
+	}, asOf)
 }
 
 func (qs *QuadStore) OptimizeShape(ctx context.Context, s shape.Shape) (shape.Shape, bool) {
 	switch s := s.(type) {
 	case shape.QuadsAction:
-		return qs.optimizeQuadsAction(s)
+		return qs.optimizeQuadsAction(s, 0)
 	}
 	return s, false
 }
 
-func (qs *QuadStore) optimizeQuadsAction(s shape.QuadsAction) (shape.Shape, bool) {
+func (qs *QuadStore) optimizeQuadsAction(s shape.QuadsAction, asOf int64) (shape.Shape, bool) {
 	if len(s.Filter) == 0 {
 		return s, false
 	}
@@ -112,7 +116,7 @@ func (qs *QuadStore) optimizeQuadsAction(s shape.QuadsAction) (shape.Shape, bool
 	if len(ind) != 1 {
 		return s, false // TODO(dennwc): allow intersecting indexes
 	}
-	quads := IndexScan{Index: ind[0]}
+	quads := IndexScan{Index: ind[0], AsOf: asOf}
 	for _, d := range ind[0].Dirs {
 		v, ok := s.Filter[d].(Int64Value)
 		if !ok {
@@ -126,14 +130,21 @@ func (qs *QuadStore) optimizeQuadsAction(s shape.QuadsAction) (shape.Shape, bool
 type IndexScan struct {
 	Index  QuadIndex
 	Values []uint64
+	// AsOf is an epoch to scan the index at, or zero for the latest state.
+	AsOf int64
 }
 
 func (s IndexScan) BuildIterator(qs graph.QuadStore) iterator.Shape {
-	kqs, ok := qs.(*QuadStore)
-	if !ok {
+	var kqs *QuadStore
+	switch qs := qs.(type) {
+	case *QuadStore:
+		kqs = qs
+	case *asOfStore:
+		kqs = qs.qs
+	default:
 		return iterator.NewError(fmt.Errorf("expected KV quadstore, got: %T", qs))
 	}
-	return kqs.newQuadIterator(s.Index, s.Values)
+	return kqs.newQuadIterator(s.Index, s.Values, s.AsOf)
 }
 
 func (s IndexScan) Optimize(ctx context.Context, r shape.Optimizer) (shape.Shape, bool) {
@@ -0,0 +1,53 @@
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/hidal-go/hidalgo/kv"
+)
+
+var _ graph.LockStore = (*QuadStore)(nil)
+
+// lockKey returns a metadata key of the lock. The value contains an expiration time in unix nanoseconds,
+// followed by the owner.
+func lockKey(name string) kv.Key {
+	return metaBucket.AppendBytes([]byte("lock/" + name))
+}
+
+func (qs *QuadStore) Lock(ctx context.Context, name, owner string, ttl time.Duration) error {
+	return kv.Update(ctx, qs.db, func(tx kv.Tx) error {
+		key := lockKey(name)
+		now := time.Now()
+		val, err := tx.Get(ctx, key)
+		if err == nil && len(val) >= 8 {
+			expires := time.Unix(0, int64(quadKeyEnc.Uint64(val[:8])))
+			if string(val[8:]) != owner && now.Before(expires) {
+				return graph.ErrLocked
+			}
+		} else if err != nil && err != kv.ErrNotFound {
+			return err
+		}
+		buf := make([]byte, 8+len(owner))
+		quadKeyEnc.PutUint64(buf, uint64(now.Add(ttl).UnixNano()))
+		copy(buf[8:], owner)
+		return tx.Put(key, buf)
+	})
+}
+
+func (qs *QuadStore) Unlock(ctx context.Context, name, owner string) error {
+	return kv.Update(ctx, qs.db, func(tx kv.Tx) error {
+		key := lockKey(name)
+		val, err := tx.Get(ctx, key)
+		if err == kv.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(val) < 8 || string(val[8:]) != owner {
+			return nil
+		}
+		return tx.Del(key)
+	})
+}
@@ -0,0 +1,73 @@
+package kv
+
+import (
+	"context"
+	"sort"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/hidal-go/hidalgo/kv"
+)
+
+var _ graph.MetaStore = (*QuadStore)(nil)
+
+// listenerMetaPrefix prefixes metadata keys of graph.MetaStore, so they do not collide with keys of the store itself.
+var listenerMetaPrefix = []byte("listener/")
+
+func listenerMetaKey(key string) kv.Key {
+	return metaBucket.AppendBytes(append(append([]byte{}, listenerMetaPrefix...), key...))
+}
+
+// putMeta writes metadata values of graph.MetaStore in a given transaction. Nil values are removed.
+func putMeta(tx kv.Tx, meta map[string][]byte) error {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var err error
+		if v := meta[k]; v == nil {
+			err = tx.Del(listenerMetaKey(k))
+		} else {
+			err = tx.Put(listenerMetaKey(k), v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (qs *QuadStore) Meta(ctx context.Context, key string) ([]byte, error) {
+	var out []byte
+	err := kv.View(qs.db, func(tx kv.Tx) error {
+		val, err := tx.Get(ctx, listenerMetaKey(key))
+		if err == kv.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		out = append([]byte{}, val...)
+		return nil
+	})
+	return out, err
+}
+
+func (qs *QuadStore) ForEachMeta(ctx context.Context, fn func(key string, val []byte) error) error {
+	return kv.View(qs.db, func(tx kv.Tx) error {
+		it := tx.Scan(metaBucket.AppendBytes(listenerMetaPrefix))
+		defer it.Close()
+		for it.Next(ctx) {
+			k := it.Key()
+			key := string(k[len(k)-1][len(listenerMetaPrefix):])
+			if err := fn(key, append([]byte{}, it.Val()...)); err != nil {
+				return err
+			}
+		}
+		return it.Err()
+	})
+}
+
+func (qs *QuadStore) ApplyDeltasMeta(epoch int64, in []graph.Delta, opts graph.IgnoreOpts, meta map[string][]byte) error {
+	return qs.applyEpoch(epoch, in, opts, meta)
+}
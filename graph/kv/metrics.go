@@ -52,6 +52,10 @@ var (
 		Name: "gateway_kv_primitive_append",
 		Help: "Number of primitives appended to log.",
 	})
+	mBulkQuads = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_kv_bulk_quads_count",
+		Help: "Number of new quads added by bulk loads.",
+	})
 
 	mIndexWriteBufferEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "gateway_kv_index_buffer_entries",
@@ -66,6 +70,11 @@ var (
 		Help: "Size of a single index entry.",
 	}, []string{"index"})
 
+	mCompactBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_kv_compact_reclaimed_bytes",
+		Help: "Estimated size of keys and values removed by the compaction.",
+	})
+
 	mKVGet = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "gateway_kv_get_count",
 		Help: "Number of get KV calls.",
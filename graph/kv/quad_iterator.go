@@ -30,22 +30,26 @@ type QuadIterator struct {
 	qs   *QuadStore
 	ind  QuadIndex
 	vals []uint64
+	asOf int64
 
 	size refs.Size
 	err  error
 }
 
-func (qs *QuadStore) newQuadIterator(ind QuadIndex, vals []uint64) *QuadIterator {
+// newQuadIterator creates an iterator over quads in the index with a given prefix.
+// If asOf is not zero, only quads that existed at this epoch are returned.
+func (qs *QuadStore) newQuadIterator(ind QuadIndex, vals []uint64, asOf int64) *QuadIterator {
 	return &QuadIterator{
 		qs:   qs,
 		ind:  ind,
 		vals: vals,
+		asOf: asOf,
 		size: refs.Size{Value: -1},
 	}
 }
 
 func (it *QuadIterator) Iterate() iterator.Scanner {
-	return it.qs.newQuadIteratorNext(it.ind, it.vals)
+	return it.qs.newQuadIteratorNext(it.ind, it.vals, it.asOf)
 }
 
 func (it *QuadIterator) Lookup() iterator.Index {
@@ -99,6 +103,7 @@ type quadIteratorNext struct {
 	qs   *QuadStore
 	ind  QuadIndex
 	vals []uint64
+	asOf int64
 
 	tx   kv.Tx
 	it   kv.Iterator
@@ -111,11 +116,12 @@ type quadIteratorNext struct {
 	prim *proto.Primitive
 }
 
-func (qs *QuadStore) newQuadIteratorNext(ind QuadIndex, vals []uint64) *quadIteratorNext {
+func (qs *QuadStore) newQuadIteratorNext(ind QuadIndex, vals []uint64, asOf int64) *quadIteratorNext {
 	return &quadIteratorNext{
 		qs:   qs,
 		ind:  ind,
 		vals: vals,
+		asOf: asOf,
 	}
 }
 
@@ -202,7 +208,7 @@ func (it *quadIteratorNext) Next(ctx context.Context) bool {
 		}
 		for ; len(it.buf) > 0; it.buf, it.off = it.buf[1:], it.off+1 {
 			p := it.buf[0]
-			if p == nil || p.Deleted {
+			if p == nil || !p.ExistsAt(it.asOf) {
 				continue
 			}
 			// TODO(dennwc): shouldn't this check the horizon?
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/hidal-go/hidalgo/kv"
 
@@ -33,7 +34,6 @@ import (
 	"github.com/epik-protocol/epik-gateway-backend/graph/refs"
 	"github.com/epik-protocol/epik-gateway-backend/internal/lru"
 	"github.com/epik-protocol/epik-gateway-backend/query/shape"
-	boom "github.com/tylertreat/BoomFilters"
 )
 
 var (
@@ -107,14 +107,35 @@ type QuadStore struct {
 
 	writer    sync.Mutex
 	mapBucket map[string]map[string][]uint64
-	mapBloom  map[string]*boom.BloomFilter
-	mapNodes  *boom.BloomFilter
+	mapBloom  map[string]*bloomFilter
+	mapNodes  *bloomFilter
 
 	exists struct {
 		disabled bool
 		sync.Mutex
 		buf []byte
-		*boom.DeletableBloomFilter
+		*bloomFilter
+	}
+
+	// checkpoint of filters in the metadata, see saveFilters
+	filters struct {
+		disabled bool
+		// save serializes checkpoints, it is acquired before the writer lock
+		save sync.Mutex
+
+		mu sync.Mutex
+		// gen is incremented each time filters are invalidated
+		gen uint64
+		// stored is set if the checkpoint header exists in the database
+		stored bool
+		// saved is set if the stored checkpoint matches in-memory filters
+		saved bool
+	}
+
+	// background compaction, see startCompaction
+	compact struct {
+		stop chan struct{}
+		done chan struct{}
 	}
 }
 
@@ -152,11 +173,16 @@ func Init(kv kv.KV, opt graph.Options) error {
 	if err := qs.writeIndexesMeta(ctx); err != nil {
 		return err
 	}
-	return nil
+	// the reverse cid index is complete in a new store
+	return qs.indexQuadCids(ctx)
 }
 
 const (
 	OptNoBloom = "no_bloom"
+	// OptNoBloomCheckpoint disables checkpoints of bloom filters, thus filters are rebuilt from the log on each start.
+	OptNoBloomCheckpoint = "no_bloom_checkpoint"
+	// OptCompactInterval enables the background compaction with a given interval, see QuadStore.Compact.
+	OptCompactInterval = "compact_interval"
 )
 
 func New(kv kv.KV, opt graph.Options) (graph.QuadStore, error) {
@@ -174,19 +200,42 @@ func New(kv kv.KV, opt graph.Options) (graph.QuadStore, error) {
 		return nil, err
 	}
 	qs.indexes.all = list
+	if readOnly, _ := opt.BoolKey(graph.OptionReadOnly, false); !readOnly {
+		if err = qs.indexQuadCids(ctx); err != nil {
+			return nil, err
+		}
+	}
 	qs.valueLRU = lru.New(2000)
 	qs.exists.disabled, _ = opt.BoolKey(OptNoBloom, false)
-	if err := qs.initBloomFilter(ctx); err != nil {
-		return nil, err
+	qs.filters.disabled, _ = opt.BoolKey(OptNoBloomCheckpoint, false)
+	loaded := false
+	if !qs.exists.disabled && !qs.filters.disabled {
+		if loaded, err = qs.loadFilters(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if !loaded {
+		if err := qs.initBloomFilter(ctx); err != nil {
+			return nil, err
+		}
 	}
-	if !qs.exists.disabled {
+	if !qs.exists.disabled && !loaded {
 		if sz, err := qs.getSize(); err != nil {
 			return nil, err
 		} else if sz == 0 {
-			qs.mapBloom = make(map[string]*boom.BloomFilter)
-			qs.mapNodes = boom.NewBloomFilter(100*1000*1000, 0.05)
+			qs.mapBloom = make(map[string]*bloomFilter)
+			qs.mapNodes = newBloomFilter(100*1000*1000, 0.05)
 		}
 	}
+	if every, err := opt.StringKey(OptCompactInterval, ""); err != nil {
+		return nil, err
+	} else if every != "" {
+		d, err := time.ParseDuration(every)
+		if err != nil {
+			return nil, fmt.Errorf("kv: invalid %s: %v", OptCompactInterval, err)
+		}
+		qs.startCompaction(d)
+	}
 	return qs, nil
 }
 
@@ -233,7 +282,7 @@ func (qs *QuadStore) Size() int64 {
 }
 
 func (qs *QuadStore) Stats(ctx context.Context, exact bool) (graph.Stats, error) {
-	sz, err := qs.getMetaInt(ctx, "size")
+	sz, err := qs.getSize()
 	if err != nil {
 		return graph.Stats{}, err
 	}
@@ -269,6 +318,14 @@ func (qs *QuadStore) Stats(ctx context.Context, exact bool) (graph.Stats, error)
 }
 
 func (qs *QuadStore) Close() error {
+	qs.stopCompaction()
+	if err := qs.saveFilters(context.TODO()); err != nil {
+		// filters are rebuilt on the next start
+		clog.Warningf("kv: cannot checkpoint bloom filters: %v", err)
+	}
+	// wait for writes in progress
+	qs.writer.Lock()
+	defer qs.writer.Unlock()
 	return qs.db.Close()
 }
 
@@ -352,14 +409,19 @@ func (qs *QuadStore) ValuesOf(ctx context.Context, vals []graph.Ref) ([]quad.Val
 }
 
 func (qs *QuadStore) RefsOf(ctx context.Context, nodes []quad.Value) ([]graph.Ref, error) {
+	return qs.refsAt(ctx, nodes, 0)
+}
+
+// refsAt resolves nodes that exist at a given epoch, or live nodes if the epoch is zero.
+func (qs *QuadStore) refsAt(ctx context.Context, nodes []quad.Value, epoch int64) ([]graph.Ref, error) {
 	values := make([]graph.Ref, len(nodes))
 	err := kv.View(qs.db, func(tx kv.Tx) error {
-		for i, node := range nodes {
-			value, err := qs.resolveQuadValue(ctx, tx, node)
-			if err != nil {
-				return err
-			}
-			values[i] = Int64Value(value)
+		ids, err := qs.resolveValuesAt(ctx, tx, nodes, epoch)
+		if err != nil {
+			return err
+		}
+		for i, id := range ids {
+			values[i] = Int64Value(id)
 		}
 		return nil
 	})
@@ -369,6 +431,37 @@ func (qs *QuadStore) RefsOf(ctx context.Context, nodes []quad.Value) ([]graph.Re
 	return values, nil
 }
 
+// resolveValuesAt is like resolveQuadValues, but only returns ids of nodes that exist at a given epoch,
+// or live nodes if the epoch is zero. Deleted nodes are kept for queries at past epochs, see decNodes,
+// thus the log is only read for past epochs.
+func (qs *QuadStore) resolveValuesAt(ctx context.Context, tx kv.Tx, vals []quad.Value, epoch int64) ([]uint64, error) {
+	ids, err := qs.resolveQuadValues(ctx, tx, vals, epoch == 0)
+	if err != nil || epoch == 0 {
+		return ids, err
+	}
+	inds := make([]int, 0, len(ids))
+	found := make([]uint64, 0, len(ids))
+	for i, id := range ids {
+		if id != 0 {
+			inds = append(inds, i)
+			found = append(found, id)
+		}
+	}
+	if len(found) == 0 {
+		return ids, nil
+	}
+	prims, err := qs.getPrimitivesFromLog(ctx, tx, found)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range prims {
+		if p == nil || !p.ExistsAt(epoch) {
+			ids[inds[i]] = 0
+		}
+	}
+	return ids, nil
+}
+
 func (qs *QuadStore) NameOf(v graph.Ref) quad.Value {
 	ctx := context.TODO()
 	vals, err := qs.ValuesOf(ctx, []graph.Ref{v})
@@ -426,12 +519,20 @@ func (qs *QuadStore) getValFromLog(ctx context.Context, tx kv.Tx, k uint64) (qua
 }
 
 func (qs *QuadStore) ValueOf(s quad.Value) graph.Ref {
+	return qs.valueAt(s, 0)
+}
+
+// valueAt resolves a node that exists at a given epoch, or a live node if the epoch is zero.
+func (qs *QuadStore) valueAt(s quad.Value, epoch int64) graph.Ref {
 	ctx := context.TODO()
 	var out Int64Value
 	_ = kv.View(qs.db, func(tx kv.Tx) error {
-		v, err := qs.resolveQuadValue(ctx, tx, s)
-		out = Int64Value(v)
-		return err
+		ids, err := qs.resolveValuesAt(ctx, tx, []quad.Value{s}, epoch)
+		if err != nil {
+			return err
+		}
+		out = Int64Value(ids[0])
+		return nil
 	})
 	if out == 0 {
 		return nil
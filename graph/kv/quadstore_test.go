@@ -5,10 +5,14 @@ import (
 	"context"
 	"encoding/binary"
 	henc "encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"sort"
+	"strconv"
 	"sync"
 	"testing"
+	"time"
 
 	hkv "github.com/hidal-go/hidalgo/kv"
 	"github.com/stretchr/testify/require"
@@ -48,6 +52,14 @@ func key(b string, k []byte) hkv.Key {
 	return hkv.Key{[]byte(b), k}
 }
 
+func cidk(cid string, id uint64) []byte {
+	return append(append([]byte(cid), 0), be(id)...)
+}
+
+func quadcidk(id uint64, cid string) []byte {
+	return append(be(id), cid...)
+}
+
 func be(v ...uint64) []byte {
 	b := make([]byte, 8*len(v))
 	for i, vi := range v {
@@ -62,9 +74,13 @@ func le(v uint64) []byte {
 }
 
 const (
-	bMeta = "meta"
-	bLog  = "log"
-	bCid  = "cid"
+	bMeta  = "meta"
+	bLog   = "log"
+	bCid   = "cid"
+	bEpoch = "epoch"
+	bUndo  = "undo"
+	// bQuadCid is the reverse cid index
+	bQuadCid = "quadcid"
 )
 
 var (
@@ -75,6 +91,8 @@ var (
 	vAuto = []byte("auto")
 
 	kIndexes = []byte("indexes")
+	kFilters = []byte("filters")
+	kQuadCid = []byte("quadcid")
 )
 
 type Ops []kvOp
@@ -135,11 +153,16 @@ func TestApplyDeltas(t *testing.T) {
 		{opPut, key(bMeta, []byte{}), nil, nil},
 		{opPut, key(bLog, []byte{}), nil, nil},
 		{opPut, key(bCid, []byte{}), nil, nil},
+		{opPut, key(bEpoch, []byte{}), nil, nil},
+		{opPut, key(bUndo, []byte{}), nil, nil},
+		{opPut, key(bQuadCid, []byte{}), nil, nil},
 		{opPut, key("sp", []byte{}), nil, nil},
 		{opPut, key("ops", []byte{}), nil, nil},
 		{opPut, key(bMeta, kVers), vVers, nil},
 		{opPut, key(bMeta, kEpoch), le(0), nil},
 		{opPut, key(bMeta, kIndexes), []byte(`[{"dirs":"AQI=","unique":false},{"dirs":"AwIB","unique":false}]`), nil},
+		{opGet, key(bMeta, kQuadCid), nil, hkv.ErrNotFound},
+		{opPut, key(bMeta, kQuadCid), le(1), nil},
 	})
 
 	qs, err := kv.New(hook, nil)
@@ -149,6 +172,8 @@ func TestApplyDeltas(t *testing.T) {
 	expect(Ops{
 		{opGet, key(bMeta, kVers), vVers, nil},
 		{opGet, key(bMeta, kIndexes), []byte(`[{"dirs":"AQI=","unique":false},{"dirs":"AwIB","unique":false}]`), nil},
+		{opGet, key(bMeta, kQuadCid), le(1), nil},
+		{opGet, key(bMeta, kFilters), nil, hkv.ErrNotFound},
 		{opGet, key(bMeta, []byte("size")), nil, hkv.ErrNotFound},
 	})
 
@@ -217,6 +242,7 @@ func TestApplyDeltas(t *testing.T) {
 		{opPut, key(bLog, be(4)), vAuto, nil},
 		{opGet, key(bMeta, []byte("size")), le(2), nil},
 		{opPut, key(bMeta, []byte("size")), le(1), nil},
+		{opGet, key(bMeta, kEpoch), le(0), nil},
 		{opGet, key(iric("a"), irih("a")), hex("02"), nil},
 		{opGet, key(iric("b"), irih("b")), hex("02"), nil},
 		{opGet, key(iric("c"), irih("c")), hex("01"), nil},
@@ -432,11 +458,16 @@ func TestApplyEpikDeltas(t *testing.T) {
 		{opPut, key(bMeta, []byte{}), nil, nil},
 		{opPut, key(bLog, []byte{}), nil, nil},
 		{opPut, key(bCid, []byte{}), nil, nil},
+		{opPut, key(bEpoch, []byte{}), nil, nil},
+		{opPut, key(bUndo, []byte{}), nil, nil},
+		{opPut, key(bQuadCid, []byte{}), nil, nil},
 		{opPut, key("sp", []byte{}), nil, nil},
 		{opPut, key("ops", []byte{}), nil, nil},
 		{opPut, key(bMeta, kVers), vVers, nil},
 		{opPut, key(bMeta, kEpoch), le(0), nil},
 		{opPut, key(bMeta, kIndexes), []byte(`[{"dirs":"AQI=","unique":false},{"dirs":"AwIB","unique":false}]`), nil},
+		{opGet, key(bMeta, kQuadCid), nil, hkv.ErrNotFound},
+		{opPut, key(bMeta, kQuadCid), le(1), nil},
 	})
 
 	qs, err := kv.New(hook, nil)
@@ -446,6 +477,8 @@ func TestApplyEpikDeltas(t *testing.T) {
 	expect(Ops{
 		{opGet, key(bMeta, kVers), vVers, nil},
 		{opGet, key(bMeta, kIndexes), []byte(`[{"dirs":"AQI=","unique":false},{"dirs":"AwIB","unique":false}]`), nil},
+		{opGet, key(bMeta, kQuadCid), le(1), nil},
+		{opGet, key(bMeta, kFilters), nil, hkv.ErrNotFound},
 		{opGet, key(bMeta, []byte("size")), nil, hkv.ErrNotFound},
 	})
 
@@ -475,6 +508,9 @@ func TestApplyEpikDeltas(t *testing.T) {
 		{opPut, key(bMeta, []byte("size")), le(1), nil},
 		{opPut, key("ops", be(3, 2, 1)), hex("04"), nil},
 		{opPut, key("sp", be(1, 2)), hex("04"), nil},
+		{opGet, key(bMeta, []byte("undo")), nil, hkv.ErrNotFound},
+		{opPut, key(bMeta, []byte("undo")), le(1), nil},
+		{opPut, key(bUndo, be(1, 0)), vAuto, nil},
 		{opPut, key(bMeta, kEpoch), le(1), nil},
 	})
 
@@ -500,12 +536,16 @@ func TestApplyEpikDeltas(t *testing.T) {
 		{opGet, key(bMeta, []byte("horizon")), le(5), nil},
 		{opPut, key(bMeta, []byte("horizon")), le(6), nil},
 		{opPut, key(bLog, be(6)), vAuto, nil},
-		{opPut, key(bCid, []byte(deltas[1].Cid)), le(6), nil},
 		{opGet, key(bMeta, []byte("size")), le(1), nil},
 		{opPut, key(bMeta, []byte("size")), le(2), nil},
+		{opPut, key(bCid, cidk(deltas[1].Cid, 6)), le(6), nil},
+		{opPut, key(bQuadCid, quadcidk(6, deltas[1].Cid)), cidk(deltas[1].Cid, 6), nil},
 		{opPut, key("ops", be(5, 2, 1)), hex("06"), nil},
 		{opGet, key("sp", be(1, 2)), hex("04"), nil},
 		{opPut, key("sp", be(1, 2)), hex("0406"), nil},
+		{opGet, key(bMeta, []byte("undo")), le(1), nil},
+		{opPut, key(bMeta, []byte("undo")), le(2), nil},
+		{opPut, key(bUndo, be(2, 1)), vAuto, nil},
 		{opPut, key(bMeta, kEpoch), le(2), nil},
 	})
 
@@ -539,8 +579,13 @@ func TestApplyEpikDeltas(t *testing.T) {
 		{opPut, key(iric("a"), irih("a")), hex("01"), nil},
 		{opPut, key(iric("b"), irih("b")), hex("01"), nil},
 		{opDel, key(iric("c"), irih("c")), nil, nil},
-		{opDel, key(irib("c"), irih("c")), nil, nil},
-		{opDel, key(bLog, be(3)), nil, nil},
+		// nodes and their value index entries are kept for queries at past epochs, live lookups skip them
+		{opGet, key(bLog, be(3)), vAuto, nil},
+		{opPut, key(bLog, be(3)), vAuto, nil},
+		{opPut, key(irib("c"), irih("c")), hex("0301"), nil},
+		{opGet, key(bMeta, []byte("undo")), le(2), nil},
+		{opPut, key(bMeta, []byte("undo")), le(3), nil},
+		{opPut, key(bUndo, be(2, 2)), vAuto, nil},
 		{opPut, key(bMeta, kEpoch), le(2), nil},
 	})
 	require.NoError(t, err)
@@ -548,7 +593,7 @@ func TestApplyEpikDeltas(t *testing.T) {
 	// delete deltas[3] - by cid
 	err = qs.ApplyDeltas(3, []graph.Delta{deltas[3]}, graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true})
 	expect(Ops{
-		{opGet, key(bCid, []byte(deltas[3].Cid)), le(6), nil},
+		{opGet, key(bCid, []byte(deltas[3].Cid)), nil, hkv.ErrNotFound},
 		{opGet, key(bLog, be(6)), vAuto, nil},
 		{opGet, key(bLog, be(1)), vAuto, nil},
 		{opGet, key(bLog, be(2)), vAuto, nil},
@@ -556,7 +601,9 @@ func TestApplyEpikDeltas(t *testing.T) {
 		{opPut, key(bLog, be(6)), vAuto, nil},
 		{opGet, key(bMeta, []byte("size")), le(1), nil},
 		{opPut, key(bMeta, []byte("size")), le(0), nil},
-		{opDel, key(bCid, []byte(deltas[3].Cid)), nil, nil},
+		{opGet, key(bCid, []byte(deltas[3].Cid)), nil, hkv.ErrNotFound},
+		{opDel, key(bCid, cidk(deltas[3].Cid, 6)), nil, nil},
+		{opDel, key(bQuadCid, quadcidk(6, deltas[3].Cid)), nil, nil},
 		{opGet, key(iric("e"), irih("e")), hex("01"), nil},
 		{opGet, key(iric("a"), irih("a")), hex("01"), nil},
 		{opGet, key(iric("b"), irih("b")), hex("01"), nil},
@@ -564,13 +611,546 @@ func TestApplyEpikDeltas(t *testing.T) {
 		{opDel, key(iric("a"), irih("a")), nil, nil},
 		{opDel, key(iric("b"), irih("b")), nil, nil},
 
-		{opDel, key(irib("e"), irih("e")), nil, nil},
-		{opDel, key(bLog, be(5)), nil, nil},
-		{opDel, key(irib("a"), irih("a")), nil, nil},
-		{opDel, key(bLog, be(1)), nil, nil},
-		{opDel, key(irib("b"), irih("b")), nil, nil},
-		{opDel, key(bLog, be(2)), nil, nil},
+		{opGet, key(bLog, be(5)), vAuto, nil},
+		{opPut, key(bLog, be(5)), vAuto, nil},
+		{opPut, key(irib("e"), irih("e")), hex("0501"), nil},
+		{opGet, key(bLog, be(1)), vAuto, nil},
+		{opPut, key(bLog, be(1)), vAuto, nil},
+		{opPut, key(irib("a"), irih("a")), hex("0101"), nil},
+		{opGet, key(bLog, be(2)), vAuto, nil},
+		{opPut, key(bLog, be(2)), vAuto, nil},
+		{opPut, key(irib("b"), irih("b")), hex("0201"), nil},
+		{opGet, key(bMeta, []byte("undo")), le(3), nil},
+		{opPut, key(bMeta, []byte("undo")), le(4), nil},
+		{opPut, key(bUndo, be(3, 3)), vAuto, nil},
 		{opPut, key(bMeta, kEpoch), le(3), nil},
 	})
 	require.NoError(t, err)
 }
+
+func newBackupStore(t *testing.T) *kv.QuadStore {
+	db := btree.New()
+	require.NoError(t, kv.Init(db, nil))
+	qs, err := kv.New(db, nil)
+	require.NoError(t, err)
+	return qs.(*kv.QuadStore)
+}
+
+func listQuads(t *testing.T, qs graph.QuadStore) []string {
+	qr := graph.NewQuadStoreReader(qs)
+	defer qr.Close()
+	all, err := quad.ReadAll(qr)
+	require.NoError(t, err)
+	var out []string
+	for _, q := range all {
+		out = append(out, q.String())
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestBackup(t *testing.T) {
+	ctx := context.TODO()
+	qs := newBackupStore(t)
+	defer qs.Close()
+
+	apply := func(epoch int64, key string, deltas ...graph.Delta) {
+		require.NoError(t, qs.ApplyDeltas(epoch, deltas, graph.IgnoreOpts{IgnoreDup: true}))
+		if key != "" {
+			require.NoError(t, qs.SetEpochKey(ctx, epoch, []byte(key)))
+		}
+	}
+	add := func(cid string, q quad.Quad) graph.Delta {
+		return graph.Delta{Cid: cid, Quad: q, Action: graph.Add}
+	}
+	apply(1, "k1",
+		add("c1", quad.MakeIRI("a", "b", "c", "")),
+		add("c1", quad.MakeIRI("a", "b", "d", "")),
+	)
+	apply(2, "k2", add("c2", quad.MakeIRI("x", "y", "z", "")))
+	require.NoError(t, qs.ApplyDeltasMeta(2, nil, graph.IgnoreOpts{}, map[string][]byte{"deals": []byte("d2")}))
+	require.NoError(t, qs.Lock(ctx, "sync", "src", time.Minute))
+
+	var full bytes.Buffer
+	info, err := qs.Backup(ctx, &full, graph.BackupOptions{})
+	require.NoError(t, err)
+	require.False(t, info.Incremental())
+	require.Equal(t, int64(2), info.Epoch)
+	snapshot := listQuads(t, qs)
+
+	apply(3, "k3",
+		graph.Delta{Quad: quad.MakeIRI("a", "b", "c", ""), Action: graph.Delete},
+		add("c3", quad.MakeIRI("a", "b", "e", "")),
+		add("c4", quad.MakeIRI("x", "y", "z", "")),
+	)
+	apply(4, "k4", graph.Delta{Cid: "c3", Action: graph.Delete})
+	require.NoError(t, qs.ApplyDeltasMeta(5, nil, graph.IgnoreOpts{}, map[string][]byte{"deals": []byte("d5")}))
+
+	var inc bytes.Buffer
+	info, err = qs.Backup(ctx, &inc, graph.BackupOptions{From: 2})
+	require.NoError(t, err)
+	require.True(t, info.Incremental())
+	require.Equal(t, int64(5), info.Epoch)
+
+	_, err = qs.Backup(ctx, ioutil.Discard, graph.BackupOptions{From: 2, To: 6})
+	require.Equal(t, graph.ErrEpochNotExist, err)
+
+	for _, b := range []*bytes.Buffer{&full, &inc} {
+		_, err = graph.VerifyBackup(bytes.NewReader(b.Bytes()))
+		require.NoError(t, err)
+	}
+	corrupted := append([]byte{}, inc.Bytes()...)
+	// flip the last byte of the last record, right before the checksum
+	corrupted[len(corrupted)-35] ^= 0xff
+	_, err = graph.VerifyBackup(bytes.NewReader(corrupted))
+	require.Equal(t, graph.ErrBackupChecksum, err)
+
+	dst := newBackupStore(t)
+	defer dst.Close()
+
+	_, err = dst.Restore(ctx, bytes.NewReader(inc.Bytes()))
+	require.Error(t, err, "incremental backup must follow the last epoch")
+
+	info, err = dst.Restore(ctx, bytes.NewReader(full.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), info.Epoch)
+	require.Equal(t, snapshot, listQuads(t, dst))
+	key, err := dst.EpochKey(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "k1", string(key))
+	refs, err := dst.QuadsByCid(ctx, "c1")
+	require.NoError(t, err)
+	require.Len(t, refs, 2)
+	meta, err := dst.Meta(ctx, "deals")
+	require.NoError(t, err)
+	require.Equal(t, "d2", string(meta))
+	// locks are not restored
+	require.NoError(t, dst.Lock(ctx, "sync", "dst", time.Minute))
+
+	_, err = dst.Restore(ctx, bytes.NewReader(full.Bytes()))
+	require.Equal(t, graph.ErrRestoreNotEmpty, err)
+
+	info, err = dst.Restore(ctx, bytes.NewReader(inc.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), info.Epoch)
+	require.Equal(t, listQuads(t, qs), listQuads(t, dst))
+	st, err := dst.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), st.Epoch)
+	key, err = dst.EpochKey(ctx, 3)
+	require.NoError(t, err)
+	require.Equal(t, "k3", string(key))
+	meta, err = dst.Meta(ctx, "deals")
+	require.NoError(t, err)
+	require.Equal(t, "d5", string(meta))
+	for cid, n := range map[string]int{"c1": 1, "c2": 1, "c3": 0, "c4": 1} {
+		refs, err = dst.QuadsByCid(ctx, cid)
+		require.NoError(t, err)
+		require.Len(t, refs, n, cid)
+	}
+	past, err := dst.AsOf(ctx, 3)
+	require.NoError(t, err)
+	require.Contains(t, listQuads(t, past), quad.MakeIRI("a", "b", "e", "").String())
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.TODO()
+	qs := newBackupStore(t)
+	defer qs.Close()
+
+	apply := func(epoch int64, deltas ...graph.Delta) {
+		require.NoError(t, qs.ApplyDeltas(epoch, deltas, graph.IgnoreOpts{IgnoreDup: true}))
+	}
+	add := func(cid string, q quad.Quad) graph.Delta {
+		return graph.Delta{Cid: cid, Quad: q, Action: graph.Add}
+	}
+	apply(1,
+		add("c1", quad.MakeIRI("a", "b", "c", "")),
+		add("c1", quad.MakeIRI("a", "b", "d", "")),
+	)
+	apply(2, graph.Delta{Quad: quad.MakeIRI("a", "b", "c", ""), Action: graph.Delete})
+	apply(3, add("c2", quad.MakeIRI("x", "y", "z", "")))
+	snapshot := listQuads(t, qs)
+
+	// deleted quad can still be reverted, only the cid reference to it is removed
+	st, err := qs.Compact(ctx, graph.CompactOptions{})
+	require.NoError(t, err)
+	require.Equal(t, graph.CompactStats{Before: 1, Cids: 1, Bytes: st.Bytes}, *st)
+	past, err := qs.AsOf(ctx, 1)
+	require.NoError(t, err)
+	require.Contains(t, listQuads(t, past), quad.MakeIRI("a", "b", "c", "").String())
+
+	require.NoError(t, qs.PruneEpochs(ctx, 3))
+	exp := graph.CompactStats{Before: 3, Quads: 1, Nodes: 1, Indexes: 2}
+	st, err = qs.Compact(ctx, graph.CompactOptions{DryRun: true})
+	require.NoError(t, err)
+	require.True(t, st.Bytes > 0)
+	exp.Bytes = st.Bytes
+	require.Equal(t, exp, *st)
+
+	st, err = qs.Compact(ctx, graph.CompactOptions{})
+	require.NoError(t, err)
+	require.Equal(t, exp, *st)
+	require.Equal(t, snapshot, listQuads(t, qs))
+
+	// epochs that would miss removed quads can no longer be queried
+	_, err = qs.AsOf(ctx, 1)
+	require.Equal(t, graph.ErrEpochNotExist, err)
+	past, err = qs.AsOf(ctx, 2)
+	require.NoError(t, err)
+	require.NotContains(t, listQuads(t, past), quad.MakeIRI("a", "b", "c", "").String())
+	require.Contains(t, listQuads(t, past), quad.MakeIRI("a", "b", "d", "").String())
+
+	st, err = qs.Compact(ctx, graph.CompactOptions{})
+	require.NoError(t, err)
+	require.Equal(t, graph.CompactStats{Before: 3}, *st)
+
+	// removed node is created again
+	apply(4, add("c3", quad.MakeIRI("a", "b", "c", "")))
+	require.Contains(t, listQuads(t, qs), quad.MakeIRI("a", "b", "c", "").String())
+	refs, err := qs.QuadsByCid(ctx, "c1")
+	require.NoError(t, err)
+	require.Len(t, refs, 1)
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.TODO()
+	db := btree.New()
+	require.NoError(t, kv.Init(db, nil))
+	hqs, err := kv.New(db, nil)
+	require.NoError(t, err)
+	qs := hqs.(*kv.QuadStore)
+	defer qs.Close()
+
+	err = qs.ApplyDeltas(1, []graph.Delta{
+		{Cid: "c1", Quad: quad.MakeIRI("a", "b", "c", ""), Action: graph.Add},
+	}, graph.IgnoreOpts{})
+	require.NoError(t, err)
+
+	rep, err := qs.Check(ctx, graph.CheckOptions{})
+	require.NoError(t, err)
+	require.Equal(t, graph.CheckReport{Quads: 1, Nodes: 3}, *rep)
+
+	err = hkv.Update(ctx, db, func(tx hkv.Tx) error {
+		if err := tx.Del(key("sp", be(1, 2))); err != nil {
+			return err
+		}
+		if err := tx.Put(key(iric("a"), irih("a")), hex("05")); err != nil {
+			return err
+		}
+		return tx.Put(key(bCid, cidk("c2", 1)), le(1))
+	})
+	require.NoError(t, err)
+
+	exp := []graph.Inconsistency{
+		{Kind: graph.CheckIndex, ID: 4, Key: `"sp"/` + henc.EncodeToString(be(1, 2)), Msg: "index entry for quads [4] is missing"},
+		{Kind: graph.CheckRefs, ID: 1, Key: strconv.Quote(iric("a")) + "/" + henc.EncodeToString(irih("a")), Msg: "entry is 5 instead of 1"},
+		{Kind: graph.CheckCid, ID: 1, Key: `"cid"/` + henc.EncodeToString(cidk("c2", 1)), Msg: "cid references a node"},
+	}
+	rep, err = qs.Check(ctx, graph.CheckOptions{})
+	require.NoError(t, err)
+	require.Equal(t, exp, rep.Problems)
+
+	rep, err = qs.Check(ctx, graph.CheckOptions{Repair: true})
+	require.NoError(t, err)
+	require.Len(t, rep.Problems, len(exp))
+	require.Equal(t, 0, rep.Unrepaired())
+
+	rep, err = qs.Check(ctx, graph.CheckOptions{})
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+
+	ids, err := qs.QuadsByCid(ctx, "c2")
+	require.NoError(t, err)
+	require.Empty(t, ids)
+}
+
+func TestQuadCidIndex(t *testing.T) {
+	ctx := context.TODO()
+	db := noCloseKV{btree.New()}
+	require.NoError(t, kv.Init(db, nil))
+	open := func() *kv.QuadStore {
+		qs, err := kv.New(db, nil)
+		require.NoError(t, err)
+		return qs.(*kv.QuadStore)
+	}
+	q1, q2 := quad.MakeIRI("a", "b", "c", ""), quad.MakeIRI("a", "b", "d", "")
+
+	qs := open()
+	err := qs.ApplyDeltas(1, []graph.Delta{
+		{Cid: "c1", Quad: q1, Action: graph.Add},
+		{Cid: "c1", Quad: q2, Action: graph.Add},
+		{Cid: "c2", Quad: q2, Action: graph.Add},
+	}, graph.IgnoreOpts{IgnoreDup: true})
+	require.NoError(t, err)
+	require.NoError(t, qs.Close())
+
+	// reverse entries are written on open for stores of older versions
+	err = hkv.Update(ctx, db, func(tx hkv.Tx) error {
+		var keys []hkv.Key
+		it := tx.Scan(hkv.Key{[]byte(bQuadCid)})
+		for it.Next(ctx) {
+			keys = append(keys, it.Key().Clone())
+		}
+		it.Close()
+		require.Len(t, keys, 3)
+		keys = append(keys, key(bMeta, kQuadCid))
+		for _, k := range keys {
+			if err := tx.Del(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	qs = open()
+	defer qs.Close()
+	rep, err := qs.Check(ctx, graph.CheckOptions{})
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+
+	// the quad provided by another cid is kept
+	err = qs.ApplyDeltas(2, []graph.Delta{{Cid: "c1", Action: graph.Delete}}, graph.IgnoreOpts{})
+	require.NoError(t, err)
+	require.Equal(t, []string{q2.String()}, listQuads(t, qs))
+}
+
+func TestIndexes(t *testing.T) {
+	ctx := context.TODO()
+	qs := newBackupStore(t)
+	defer qs.Close()
+
+	err := qs.ApplyDeltas(1, []graph.Delta{
+		{Cid: "c1", Quad: quad.MakeIRI("a", "b", "c", ""), Action: graph.Add},
+		{Cid: "c1", Quad: quad.MakeIRI("a", "b", "d", ""), Action: graph.Add},
+		{Cid: "c2", Quad: quad.MakeIRI("x", "y", "z", ""), Action: graph.Add},
+	}, graph.IgnoreOpts{})
+	require.NoError(t, err)
+	countBy := func(d quad.Direction, v string) int {
+		it := qs.QuadIterator(d, qs.ValueOf(quad.IRI(v))).Iterate()
+		defer it.Close()
+		n := 0
+		for it.Next(ctx) {
+			n++
+		}
+		require.NoError(t, it.Err())
+		return n
+	}
+
+	po := []quad.Direction{quad.Predicate, quad.Object}
+	var progress []graph.Index
+	err = qs.AddIndex(ctx, po, func(ind graph.Index) {
+		progress = append(progress, ind)
+	})
+	require.NoError(t, err)
+	require.Equal(t, []graph.Index{{Dirs: po, Complete: true, Progress: 1}}, progress)
+	require.Equal(t, graph.ErrIndexExists, qs.AddIndex(ctx, po, nil))
+
+	list, err := qs.Indexes(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 3)
+	require.Equal(t, graph.Index{Dirs: po, Complete: true, Progress: 1}, list[2])
+
+	// new quads are written to the index as well
+	err = qs.ApplyDeltas(2, []graph.Delta{
+		{Cid: "c3", Quad: quad.MakeIRI("x", "b", "c", ""), Action: graph.Add},
+	}, graph.IgnoreOpts{})
+	require.NoError(t, err)
+	require.Equal(t, 3, countBy(quad.Predicate, "b"))
+	rep, err := qs.Check(ctx, graph.CheckOptions{})
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+
+	sp := []quad.Direction{quad.Subject, quad.Predicate}
+	require.NoError(t, qs.DropIndex(ctx, sp))
+	require.NoError(t, qs.DropIndex(ctx, po))
+	require.Equal(t, graph.ErrIndexNotExist, qs.DropIndex(ctx, po))
+	require.Equal(t, graph.ErrLastIndex, qs.DropIndex(ctx, []quad.Direction{quad.Object, quad.Predicate, quad.Subject}))
+	require.Equal(t, 2, countBy(quad.Subject, "a"))
+	require.Equal(t, 3, countBy(quad.Predicate, "b"))
+
+	// dropped index is built again from the log
+	require.NoError(t, qs.AddIndex(ctx, sp, nil))
+	require.Equal(t, 2, countBy(quad.Subject, "a"))
+	rep, err = qs.Check(ctx, graph.CheckOptions{})
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+}
+
+// noCloseKV keeps an in-memory database when the quad store is closed, so it can be opened again.
+type noCloseKV struct {
+	hkv.KV
+}
+
+func (noCloseKV) Close() error { return nil }
+
+func TestBulkWriter(t *testing.T) {
+	ctx := context.TODO()
+	qs := newBackupStore(t)
+	defer qs.Close()
+
+	err := qs.ApplyDeltas(1, []graph.Delta{
+		{Cid: "c1", Quad: quad.MakeIRI("a", "b", "c", ""), Action: graph.Add},
+	}, graph.IgnoreOpts{})
+	require.NoError(t, err)
+
+	w, err := qs.NewBulkWriter(graph.BulkOptions{Workers: 4})
+	require.NoError(t, err)
+	_, err = w.WriteQuads([]quad.Quad{
+		quad.MakeIRI("a", "b", "c", ""), // exists
+		quad.MakeIRI("a", "b", "d", ""),
+		quad.MakeIRI("a", "b", "d", ""), // duplicate in the batch
+	})
+	require.NoError(t, err)
+	_, err = w.WriteQuads([]quad.Quad{
+		quad.MakeIRI("a", "b", "d", ""), // duplicate of a quad that is not flushed yet
+		quad.MakeIRI("e", "b", "c", "g"),
+	})
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	_, err = w.WriteQuads([]quad.Quad{quad.MakeIRI("x", "y", "z", "")})
+	require.Error(t, err)
+
+	require.Equal(t, int64(3), qs.Size())
+	it := qs.QuadIterator(quad.Predicate, qs.ValueOf(quad.IRI("b"))).Iterate()
+	defer it.Close()
+	var got []quad.Quad
+	for it.Next(ctx) {
+		got = append(got, qs.Quad(it.Result()))
+	}
+	require.NoError(t, it.Err())
+	sort.Sort(quad.ByQuadString(got))
+	require.Equal(t, []quad.Quad{
+		quad.MakeIRI("a", "b", "c", ""),
+		quad.MakeIRI("a", "b", "d", ""),
+		quad.MakeIRI("e", "b", "c", "g"),
+	}, got)
+
+	// indexes and reference counters match the log
+	rep, err := qs.Check(ctx, graph.CheckOptions{})
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+}
+
+func TestFilterCheckpoint(t *testing.T) {
+	ctx := context.TODO()
+	db := noCloseKV{btree.New()}
+	require.NoError(t, kv.Init(db, nil))
+	open := func() *kv.QuadStore {
+		qs, err := kv.New(db, nil)
+		require.NoError(t, err)
+		return qs.(*kv.QuadStore)
+	}
+	header := func() map[string]interface{} {
+		var out map[string]interface{}
+		err := hkv.View(db, func(tx hkv.Tx) error {
+			data, err := tx.Get(ctx, key(bMeta, kFilters))
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(data, &out)
+		})
+		if err == hkv.ErrNotFound {
+			return nil
+		}
+		require.NoError(t, err)
+		return out
+	}
+	check := func(qs *kv.QuadStore) {
+		rep, err := qs.Check(ctx, graph.CheckOptions{})
+		require.NoError(t, err)
+		require.Empty(t, rep.Problems)
+	}
+	add := func(q quad.Quad) graph.Delta {
+		return graph.Delta{Quad: q, Action: graph.Add}
+	}
+
+	qs := open()
+	err := qs.ApplyDeltas(1, []graph.Delta{
+		add(quad.MakeIRI("a", "b", "c", "")),
+		add(quad.MakeIRI("a", "b", "d", "")),
+	}, graph.IgnoreOpts{})
+	require.NoError(t, err)
+	require.Nil(t, header())
+	require.NoError(t, qs.Close())
+	h := header()
+	require.Equal(t, float64(1), h["epoch"])
+	require.Equal(t, true, h["bulk"])
+
+	// filters are loaded from the checkpoint, and the checkpoint is removed by the first write
+	qs = open()
+	check(qs)
+	err = qs.ApplyDeltas(2, []graph.Delta{
+		{Quad: quad.MakeIRI("a", "b", "c", ""), Action: graph.Delete},
+		add(quad.MakeIRI("x", "y", "z", "")),
+	}, graph.IgnoreOpts{})
+	require.NoError(t, err)
+	require.Nil(t, header())
+	check(qs)
+	require.NoError(t, qs.Close())
+	h = header()
+	require.Equal(t, float64(2), h["epoch"])
+	// node filter is dropped by deletions
+	require.Equal(t, true, h["bulk"])
+	require.Nil(t, h["nodes"])
+
+	// checkpoint of another epoch is ignored
+	err = hkv.Update(ctx, db, func(tx hkv.Tx) error {
+		return tx.Put(key(bMeta, kEpoch), le(3))
+	})
+	require.NoError(t, err)
+	qs = open()
+	check(qs)
+	require.Equal(t, []string{
+		quad.MakeIRI("a", "b", "d", "").String(),
+		quad.MakeIRI("x", "y", "z", "").String(),
+	}, listQuads(t, qs))
+	require.NoError(t, qs.Close())
+	h = header()
+	require.Equal(t, float64(3), h["epoch"])
+	require.Nil(t, h["bulk"])
+}
+
+func TestFilterCheckpointConcurrent(t *testing.T) {
+	ctx := context.TODO()
+	db := noCloseKV{btree.New()}
+	require.NoError(t, kv.Init(db, nil))
+	open := func() *kv.QuadStore {
+		qs, err := kv.New(db, nil)
+		require.NoError(t, err)
+		return qs.(*kv.QuadStore)
+	}
+	const n = 50
+
+	// checkpoints are saved while writes proceed, run with -race
+	qs := open()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			require.NoError(t, qs.Close())
+		}
+	}()
+	for i := 1; i <= n; i++ {
+		err := qs.ApplyDeltas(int64(i), []graph.Delta{
+			{Quad: quad.MakeIRI("a", "b", strconv.Itoa(i), ""), Action: graph.Add},
+		}, graph.IgnoreOpts{})
+		require.NoError(t, err)
+	}
+	<-done
+	require.NoError(t, qs.Close())
+
+	// the last checkpoint matches the database
+	qs = open()
+	rep, err := qs.Check(ctx, graph.CheckOptions{})
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+	require.Len(t, listQuads(t, qs), n)
+	for i := 1; i <= n; i++ {
+		err = qs.ApplyDeltas(int64(n+i), []graph.Delta{
+			{Quad: quad.MakeIRI("a", "b", strconv.Itoa(i), ""), Action: graph.Add},
+		}, graph.IgnoreOpts{})
+		require.True(t, graph.IsQuadExist(err), "quad %d is missing from filters: %v", i, err)
+	}
+	require.NoError(t, qs.Close())
+}
@@ -107,7 +107,7 @@ func (it *LinksTo) getSize(ctx context.Context) refs.Size {
 	if it.size.Value != 0 {
 		return it.size
 	}
-	if fixed, ok := it.primary.(*iterator.Fixed); ok {
+	if fixed, ok := iterator.Unprofile(it.primary).(*iterator.Fixed); ok {
 		// get real sizes from sub iterators
 		var (
 			sz    int64
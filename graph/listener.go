@@ -1,7 +1,10 @@
 package graph
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"time"
 )
 
 var listenerRegistry = make(map[string]ListenerRegistration)
@@ -9,12 +12,82 @@ var listenerRegistry = make(map[string]ListenerRegistration)
 type HandleDeltaFunc func([]Delta) error
 
 type ListenerRegistration struct {
-	NewListenerFunc func(QuadStore) (Listener, error)
+	NewListenerFunc func(QuadStore, Options) (Listener, error)
 }
 
+// Listener applies changes of an external data source, such as a chain, to a QuadStore.
 type Listener interface {
 	Start()
 	Stop()
+
+	// Status reports the progress of the sync.
+	Status() ListenerStatus
+
+	// Pause stops the listener from starting new syncs. A sync in progress is not interrupted.
+	Pause()
+	// Resume starts a sync immediately and enables periodic syncs again.
+	Resume()
+	// Resync reverts all epochs starting from a given one and applies them again during the next sync,
+	// which is started immediately unless the listener is paused.
+	Resync(from int64) error
+}
+
+// RangeSyncer is an optional interface for listeners that can apply a given range of epochs on demand.
+type RangeSyncer interface {
+	// SyncRange applies epochs in the range of [from, to] in the foreground. Applied epochs in the range are
+	// reverted first. If from is zero, the sync starts after the last applied epoch, and if to is zero,
+	// the sync stops at the chain head.
+	SyncRange(ctx context.Context, from, to int64, opts SyncRangeOptions) error
+}
+
+// Leader is an optional interface for listeners that elect a single process syncing the store, see LockStore.
+type Leader interface {
+	// Lead takes the sync role in the foreground, instead of waiting for the first periodic sync.
+	// It returns ErrLocked if the store is synced by another process. The role is kept until the listener is stopped.
+	Lead(ctx context.Context) error
+}
+
+// SyncRangeOptions are options of RangeSyncer.SyncRange.
+type SyncRangeOptions struct {
+	// DryRun reports data of the range without applying it.
+	DryRun bool
+	// Progress is called after each epoch of the range is processed.
+	Progress func(SyncProgress)
+}
+
+// SyncProgress describes an epoch processed by RangeSyncer.SyncRange.
+type SyncProgress struct {
+	Epoch int64
+	// Done is the number of processed epochs, including this one, out of Total epochs in the range.
+	// Null rounds of the chain are not counted.
+	Done, Total int
+	// Roots lists identifiers (file cids) of data added at this epoch.
+	Roots []string
+}
+
+// ListenerStatus describes the progress of a Listener.
+type ListenerStatus struct {
+	// Epoch is the last epoch applied to the store.
+	Epoch int64 `json:"epoch"`
+	// Head is the chain head seen by the last sync.
+	Head int64 `json:"head"`
+	// Lag is the number of epochs the store is behind the head.
+	Lag int64 `json:"lag"`
+	// Paused is set if periodic syncs are disabled.
+	Paused bool `json:"paused"`
+	// Syncing is set while a sync is in progress.
+	Syncing bool `json:"syncing"`
+	// Standby is set if the store is synced by another process, see LockStore.
+	Standby bool `json:"standby"`
+	// InFlight lists identifiers (file cids) of data being retrieved.
+	InFlight []string `json:"in_flight"`
+	// Pending lists identifiers (file cids) of data that could not be retrieved with its epoch and will be retried.
+	Pending []string `json:"pending,omitempty"`
+	// LastSync is the time of the last successful sync, or zero if none.
+	LastSync time.Time `json:"last_sync"`
+	// LastError is the error of the last failed sync, and LastErrorTime is the time it happened.
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time"`
 }
 
 func RegisterListener(name string, register ListenerRegistration) {
@@ -28,10 +101,96 @@ func RegisterListener(name string, register ListenerRegistration) {
 	listenerRegistry[name] = register
 }
 
-func NewListener(name string, store QuadStore) (Listener, error) {
+// OptListenerDatabase is a listener option with the local path of the database, if the store has one.
+// Listeners keep their files under this path by default, thus they are not shared by different databases.
+const OptListenerDatabase = "database"
+
+// NewListener creates a listener of a given type for the store.
+// Options are specific to the listener type.
+func NewListener(name string, store QuadStore, opts Options) (Listener, error) {
 	r, registered := listenerRegistry[name]
 	if !registered {
 		return nil, fmt.Errorf("listener(%s) not registered", name)
 	}
-	return r.NewListenerFunc(store)
+	return r.NewListenerFunc(store, opts)
+}
+
+// ErrEpochNotExist is returned by EpochStore when no chain key was recorded for an epoch.
+var ErrEpochNotExist = errors.New("epoch does not exist")
+
+// EpochStore is an optional interface for quad stores that keep track of chain epochs applied by a Listener.
+//
+// Every delta applied with a non-zero epoch is recorded to an undo log, so the listener can
+// revert orphaned epochs when the chain reorganises.
+type EpochStore interface {
+	// EpochKey returns a chain key (tipset key) recorded for the epoch.
+	// It returns ErrEpochNotExist if the key was not recorded.
+	EpochKey(ctx context.Context, epoch int64) ([]byte, error)
+
+	// SetEpochKey records a chain key for the epoch.
+	SetEpochKey(ctx context.Context, epoch int64, key []byte) error
+
+	// RevertEpochs reverts all deltas applied at the given epoch and above in the reverse order,
+	// removes chain keys of these epochs and resets the indexed epoch to from-1.
+	RevertEpochs(ctx context.Context, from int64) error
+
+	// PruneEpochs removes undo logs and chain keys of all epochs before the given one.
+	// Pruned epochs can no longer be reverted.
+	PruneEpochs(ctx context.Context, before int64) error
+}
+
+// ErrReadOnlyHistory is returned when writing to a quad store view returned by HistoryStore.
+var ErrReadOnlyHistory = errors.New("cannot modify the graph at a past epoch")
+
+// HistoryStore is an optional interface for quad stores that record epochs at which quads were added and deleted.
+type HistoryStore interface {
+	// AsOf returns a read-only view of the graph as it was after applying the given epoch.
+	// It returns ErrEpochNotExist if the epoch was not applied yet, or if its history was removed by a Compactor.
+	//
+	// Quads written without an epoch are visible at all epochs.
+	AsOf(ctx context.Context, epoch int64) (QuadStore, error)
+}
+
+// AsOf returns a read-only view of the graph as it was after applying the given epoch.
+// The quad store must implement HistoryStore.
+func AsOf(ctx context.Context, qs QuadStore, epoch int64) (QuadStore, error) {
+	if epoch <= 0 {
+		return nil, fmt.Errorf("invalid epoch: %d", epoch)
+	}
+	hs, ok := qs.(HistoryStore)
+	if !ok {
+		return nil, fmt.Errorf("quad store does not support queries at past epochs")
+	}
+	return hs.AsOf(ctx, epoch)
+}
+
+// ErrLocked is returned by LockStore when a lock is held by another owner.
+var ErrLocked = errors.New("lock is held by another owner")
+
+// LockStore is an optional interface for quad stores that can keep named locks in their metadata.
+// It allows multiple processes sharing the same database to elect a single Listener.
+//
+// Locks expire after a given time, thus the owner must renew the lock periodically.
+type LockStore interface {
+	// Lock acquires the lock for the owner, or renews it if the owner already holds it.
+	// It returns ErrLocked if the lock is held by another owner and has not expired yet.
+	Lock(ctx context.Context, name, owner string, ttl time.Duration) error
+
+	// Unlock releases the lock. It does nothing if the lock is not held by the owner.
+	Unlock(ctx context.Context, name, owner string) error
+}
+
+// MetaStore is an optional interface for quad stores that keep listener state in their metadata,
+// such as storage deals tracked by the listener. The state is committed together with the epoch,
+// thus it always matches the applied graph, and it is carried by backups and migrations.
+type MetaStore interface {
+	// Meta returns a metadata value, or nil if it was not set.
+	Meta(ctx context.Context, key string) ([]byte, error)
+
+	// ForEachMeta calls fn for all metadata values, sorted by key.
+	ForEachMeta(ctx context.Context, fn func(key string, val []byte) error) error
+
+	// ApplyDeltasMeta applies deltas the same way as ApplyDeltas and sets metadata values in the same transaction.
+	// A nil value removes the key.
+	ApplyDeltasMeta(epoch int64, deltas []Delta, opts IgnoreOpts, meta map[string][]byte) error
 }
@@ -0,0 +1,114 @@
+package epik
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/epik-protocol/epik-gateway-backend/clog"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+)
+
+var _ graph.RangeSyncer = (*Listener)(nil)
+
+// SyncRange applies tipsets in the range of [from, to] in the foreground, which allows to backfill a fresh store
+// without waiting for periodic syncs. The range is limited by the last confirmed epoch, which is at least
+// one epoch before the chain head, since messages of the head are not executed yet.
+//
+// If the range starts after the next local epoch, the epochs in between are never applied by the listener.
+// SyncRange fails if the sync lock of the store is held by another process.
+func (s *Listener) SyncRange(ctx context.Context, from, to int64, opts graph.SyncRangeOptions) (err error) {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	if err = s.connect(); err != nil {
+		return fmt.Errorf("failed to init epik client: %v", err)
+	}
+	if !opts.DryRun && !s.leading() {
+		ctx, err = s.lead(ctx)
+		if err == graph.ErrLocked {
+			return fmt.Errorf("the store is synced by another process")
+		} else if err != nil {
+			return fmt.Errorf("failed to acquire the sync lock: %v", err)
+		}
+		defer s.resign()
+	}
+	local, err := s.store.Stats(ctx, false)
+	if err != nil {
+		return fmt.Errorf("failed to get quadstore stats: %v", err)
+	}
+	head, err := s.client.ChainHead(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get head epoch: %v", err)
+	}
+	remote := int64(head.Height)
+	s.setEpoch(local.Epoch)
+	s.setHead(remote)
+	s.deals.revert(local.Epoch + 1)
+
+	if from <= 0 {
+		from = local.Epoch + 1
+	}
+	if to <= 0 || to > remote-s.confirmations {
+		to = remote - s.confirmations
+	}
+	if from > to {
+		return fmt.Errorf("nothing to sync in the range of [%d, %d], chain head is %d", from, to, remote)
+	}
+	if opts.DryRun {
+		return s.listRange(ctx, head, from, to, opts.Progress)
+	}
+
+	s.syncStarted()
+	defer func() {
+		s.syncDone(err)
+	}()
+	if from <= local.Epoch {
+		clog.Warningf("resyncing epochs from %d to %d", from, local.Epoch)
+		if err = s.revertFrom(ctx, from); err != nil {
+			return err
+		}
+	} else if from > local.Epoch+1 {
+		clog.Warningf("skipping epochs from %d to %d", local.Epoch+1, from-1)
+	}
+	var parent []byte
+	if es, ok := s.store.(graph.EpochStore); ok {
+		// make sure the range continues the local chain, the previous epoch may be a null round
+		parent, err = es.EpochKey(ctx, from-1)
+		if err == graph.ErrEpochNotExist {
+			parent, err = nil, nil
+		} else if err != nil {
+			return err
+		}
+	}
+	if err = s.syncDeltas(ctx, head, parent, from, to, opts.Progress); err != nil {
+		return fmt.Errorf("failed to sync deltas from %d to %d, error is: %v", from, to, err)
+	}
+	return nil
+}
+
+// listRange reports root cids of files published in the range of [from, to], without changing the store or deals.
+func (s *Listener) listRange(ctx context.Context, head *TipSet, from, to int64, progress func(graph.SyncProgress)) error {
+	tss, err := s.getTipSets(ctx, head.Key(), from, to)
+	if err != nil {
+		return err
+	}
+	for i, ts := range tss {
+		msgs, err := s.getTipSetMessages(ctx, ts)
+		if err != nil {
+			return err
+		}
+		_, cids, err := s.parseDeals(ctx, ts, msgs)
+		if err != nil {
+			return err
+		}
+		if progress == nil {
+			continue
+		}
+		roots := make([]string, 0, len(cids))
+		for _, c := range cids {
+			roots = append(roots, c.String())
+		}
+		progress(graph.SyncProgress{Epoch: int64(ts.Height), Done: i + 1, Total: len(tss), Roots: roots})
+	}
+	return nil
+}
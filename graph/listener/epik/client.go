@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/epik-protocol/epik-gateway-backend/clog"
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/filecoin-project/specs-actors/actors/abi"
 	"github.com/ipfs/go-cid"
@@ -37,16 +38,23 @@ type EpikClient interface {
 	ClientQuery(ctx context.Context, roots []cid.Cid) ([]FileResp, error)
 	ChainGetParentMessages(ctx context.Context, blockCid cid.Cid) ([]APIMessage, error)
 	ChainGetMessage(context.Context, cid.Cid) (*Message, error)
+	StateSearchMsg(context.Context, cid.Cid) (*MsgLookup, error)
+	// StateMarketStorageDeal returns errDealNotFound if the deal is not in the market state at the tipset.
+	StateMarketStorageDeal(context.Context, abi.DealID, TipSetKey) (*MarketDeal, error)
+	StateLookupID(context.Context, address.Address, TipSetKey) (address.Address, error)
 }
 
 type EpikClientStruct struct {
 	Internal struct {
-		ChainHead              func(context.Context) (*TipSet, error)                              `perm:"read"`
-		ChainGetTipSetByHeight func(context.Context, abi.ChainEpoch, TipSetKey) (*TipSet, error)   `perm:"read"`
-		ChainGetBlockMessages  func(ctx context.Context, blockCid cid.Cid) (*BlockMessages, error) `perm:"read"`
-		ClientQuery            func(ctx context.Context, roots []cid.Cid) ([]FileResp, error)      `perm:"read"`
-		ChainGetParentMessages func(ctx context.Context, blockCid cid.Cid) ([]APIMessage, error)   `perm:"read"`
-		ChainGetMessage        func(context.Context, cid.Cid) (*Message, error)                    `perm:"read"`
+		ChainHead              func(context.Context) (*TipSet, error)                                     `perm:"read"`
+		ChainGetTipSetByHeight func(context.Context, abi.ChainEpoch, TipSetKey) (*TipSet, error)          `perm:"read"`
+		ChainGetBlockMessages  func(ctx context.Context, blockCid cid.Cid) (*BlockMessages, error)        `perm:"read"`
+		ClientQuery            func(ctx context.Context, roots []cid.Cid) ([]FileResp, error)             `perm:"read"`
+		ChainGetParentMessages func(ctx context.Context, blockCid cid.Cid) ([]APIMessage, error)          `perm:"read"`
+		ChainGetMessage        func(context.Context, cid.Cid) (*Message, error)                           `perm:"read"`
+		StateSearchMsg         func(context.Context, cid.Cid) (*MsgLookup, error)                         `perm:"read"`
+		StateMarketStorageDeal func(context.Context, abi.DealID, TipSetKey) (*MarketDeal, error)          `perm:"read"`
+		StateLookupID          func(context.Context, address.Address, TipSetKey) (address.Address, error) `perm:"read"`
 	}
 }
 
@@ -70,6 +78,24 @@ func (e *EpikClientStruct) ChainGetMessage(ctx context.Context, blockCid cid.Cid
 	return e.Internal.ChainGetMessage(ctx, blockCid)
 }
 
+func (e *EpikClientStruct) StateSearchMsg(ctx context.Context, msg cid.Cid) (*MsgLookup, error) {
+	return e.Internal.StateSearchMsg(ctx, msg)
+}
+
+// StateMarketStorageDeal converts the error of a missing deal to errDealNotFound.
+// The node reports it only by the message, thus it is matched exactly, together with the deal id.
+func (e *EpikClientStruct) StateMarketStorageDeal(ctx context.Context, deal abi.DealID, tsk TipSetKey) (*MarketDeal, error) {
+	d, err := e.Internal.StateMarketStorageDeal(ctx, deal, tsk)
+	if err != nil && err.Error() == fmt.Sprintf("deal %d not found", deal) {
+		return nil, errDealNotFound
+	}
+	return d, err
+}
+
+func (e *EpikClientStruct) StateLookupID(ctx context.Context, addr address.Address, tsk TipSetKey) (address.Address, error) {
+	return e.Internal.StateLookupID(ctx, addr, tsk)
+}
+
 func (e *EpikClientStruct) ClientQuery(ctx context.Context, roots []cid.Cid) ([]FileResp, error) {
 	return e.Internal.ClientQuery(ctx, roots)
 }
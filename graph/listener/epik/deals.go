@@ -0,0 +1,457 @@
+package epik
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/epik-protocol/epik-gateway-backend/clog"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+	"github.com/filecoin-project/specs-actors/actors/runtime/exitcode"
+	"github.com/ipfs/go-cid"
+	"github.com/spf13/viper"
+)
+
+// deal is a storage deal that provides a file to the graph.
+// Provider is the ID address of the storage provider, see Listener.lookupID.
+type deal struct {
+	ID       abi.DealID `json:"id"`
+	Root     string     `json:"root"`
+	Provider string     `json:"provider"`
+	Start    int64      `json:"start"`
+	End      int64      `json:"end"`
+	// Epoch is the epoch of the tipset the deal was published in.
+	Epoch int64 `json:"epoch"`
+	// Active is set once the deal is included in a proven sector.
+	Active bool `json:"active,omitempty"`
+	// Ended is the epoch at which the deal expired or was terminated, or zero for live deals.
+	Ended int64 `json:"ended,omitempty"`
+	// Expired is the epoch of the tipset that moved the end of the deal, and ProposedEnd is the end
+	// of the deal before it was moved. Both are restored when the tipset is reverted.
+	Expired     int64 `json:"expired,omitempty"`
+	ProposedEnd int64 `json:"proposed_end,omitempty"`
+}
+
+// metaDeals is the metadata key of tracked deals, see graph.MetaStore.
+const metaDeals = "epik/deals"
+
+// errDealNotFound is returned by EpikClient when a deal is not in the market state.
+var errDealNotFound = errors.New("deal not found")
+
+// dealTracker keeps track of storage deals of files that were added to the graph.
+// A file is deleted from the graph once all of its deals end.
+//
+// Deals are kept in the metadata of stores that implement graph.MetaStore and are written together with
+// the epoch, see Listener.commitEpoch. Other stores save deals to a file if the path is set, otherwise
+// deals published before the listener was started are not tracked.
+type dealTracker struct {
+	path  string
+	deals map[abi.DealID]*deal
+	// roots counts live deals by root cids of their files
+	roots map[string]int
+	dirty bool
+}
+
+func newDealTracker(path string) *dealTracker {
+	return &dealTracker{path: path, deals: make(map[abi.DealID]*deal), roots: make(map[string]int)}
+}
+
+// readDealsFile reads deals saved by dealTracker.save, or returns nil if the file does not exist.
+func readDealsFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// decode replaces tracked deals with deals encoded by encode.
+func (t *dealTracker) decode(data []byte) error {
+	var deals []*deal
+	if err := json.Unmarshal(data, &deals); err != nil {
+		return err
+	}
+	t.deals = make(map[abi.DealID]*deal, len(deals))
+	t.roots = make(map[string]int)
+	for _, d := range deals {
+		t.add(d)
+	}
+	t.dirty = false
+	return nil
+}
+
+// encode returns all deals encoded as JSON, sorted by id.
+func (t *dealTracker) encode() ([]byte, error) {
+	deals := make([]*deal, 0, len(t.deals))
+	for _, d := range t.deals {
+		deals = append(deals, d)
+	}
+	sort.Slice(deals, func(i, j int) bool {
+		return deals[i].ID < deals[j].ID
+	})
+	return json.Marshal(deals)
+}
+
+// save writes all deals to the file, if the path is set. The file is replaced atomically.
+func (t *dealTracker) save() error {
+	if t.path == "" || !t.dirty {
+		return nil
+	}
+	data, err := t.encode()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(t.path), filepath.Base(t.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), t.path); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+func (t *dealTracker) ref(root string) {
+	t.roots[root]++
+}
+
+func (t *dealTracker) unref(root string) {
+	if t.roots[root]--; t.roots[root] <= 0 {
+		delete(t.roots, root)
+	}
+}
+
+func (t *dealTracker) add(d *deal) {
+	if old, ok := t.deals[d.ID]; ok && old.Ended == 0 {
+		t.unref(old.Root)
+	}
+	t.deals[d.ID] = d
+	if d.Ended == 0 {
+		t.ref(d.Root)
+	}
+	t.dirty = true
+}
+
+func (t *dealTracker) activate(d *deal) {
+	d.Active = true
+	t.dirty = true
+}
+
+// expire moves the end of the deal to a given epoch while processing the tipset at a given height.
+func (t *dealTracker) expire(d *deal, height, epoch int64) {
+	if epoch < d.End {
+		if d.Expired == 0 {
+			d.Expired, d.ProposedEnd = height, d.End
+		}
+		d.End = epoch
+		t.dirty = true
+	}
+}
+
+// end marks the deal as ended at a given epoch.
+func (t *dealTracker) end(d *deal, epoch int64) {
+	if d.Ended == 0 {
+		t.unref(d.Root)
+	}
+	d.Ended = epoch
+	t.dirty = true
+}
+
+// live returns the list of deals that did not end yet, sorted by id.
+func (t *dealTracker) live() []*deal {
+	var out []*deal
+	for _, d := range t.deals {
+		if d.Ended == 0 {
+			out = append(out, d)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// stored checks if any live deal provides a file with a given root.
+func (t *dealTracker) stored(root string) bool {
+	return t.roots[root] > 0
+}
+
+// revert forgets deals that were published at or after a given epoch, and restores ones that ended
+// or expired since then.
+func (t *dealTracker) revert(epoch int64) {
+	for id, d := range t.deals {
+		if d.Epoch >= epoch {
+			if d.Ended == 0 {
+				t.unref(d.Root)
+			}
+			delete(t.deals, id)
+			t.dirty = true
+			continue
+		}
+		if d.Ended >= epoch {
+			d.Ended = 0
+			t.ref(d.Root)
+			t.dirty = true
+		}
+		if d.Expired >= epoch {
+			d.End = d.ProposedEnd
+			d.Expired, d.ProposedEnd = 0, 0
+			t.dirty = true
+		}
+	}
+}
+
+// prune forgets deals that ended before a given epoch.
+func (t *dealTracker) prune(epoch int64) {
+	for id, d := range t.deals {
+		if d.Ended != 0 && d.Ended < epoch {
+			delete(t.deals, id)
+			t.dirty = true
+		}
+	}
+}
+
+// loadDeals reads tracked deals from the metadata of the store. Stores without metadata, or ones that do not
+// have deals yet, read deals from the file, thus deals saved by previous versions are imported into the store.
+// Deals of epochs after the last applied one were never committed, thus they are reverted.
+func (s *Listener) loadDeals(ctx context.Context) error {
+	path := viper.GetString(flagEpikDeals)
+	ms, isMeta := s.store.(graph.MetaStore)
+	var (
+		data []byte
+		err  error
+	)
+	if isMeta {
+		if data, err = ms.Meta(ctx, metaDeals); err != nil {
+			return err
+		}
+	}
+	imported := false
+	if data == nil && path != "" {
+		if data, err = readDealsFile(path); err != nil {
+			return err
+		}
+		imported = isMeta && data != nil
+	}
+	t := newDealTracker(path)
+	if isMeta {
+		t.path = ""
+	} else if path == "" {
+		clog.Warningf("%s is not set and %T does not keep metadata, deals published before the start will not expire", flagEpikDeals, s.store)
+	}
+	if data != nil {
+		if err = t.decode(data); err != nil {
+			return err
+		}
+	}
+	if imported {
+		clog.Infof("importing deals from %s, they are kept in the store metadata from now on", path)
+		t.dirty = true
+	}
+	st, err := s.store.Stats(ctx, false)
+	if err != nil {
+		return err
+	}
+	t.revert(st.Epoch + 1)
+	s.deals = t
+	return nil
+}
+
+// commitEpoch sets the epoch of the store. Deals are written to the metadata in the same transaction,
+// if the store supports it. Otherwise they are saved to the file before the epoch, and deals of the epoch
+// are reverted when they are loaded if the epoch is not committed, see loadDeals.
+func (s *Listener) commitEpoch(epoch int64, opts graph.IgnoreOpts) error {
+	if ms, ok := s.store.(graph.MetaStore); ok && s.deals.dirty {
+		data, err := s.deals.encode()
+		if err != nil {
+			return err
+		}
+		if err = ms.ApplyDeltasMeta(epoch, nil, opts, map[string][]byte{metaDeals: data}); err != nil {
+			return err
+		}
+		s.deals.dirty = false
+		s.committed = epoch
+		return nil
+	}
+	if err := s.deals.save(); err != nil {
+		return fmt.Errorf("failed to save deals: %v", err)
+	}
+	if err := s.store.ApplyDeltas(epoch, nil, opts); err != nil {
+		return err
+	}
+	s.committed = epoch
+	return nil
+}
+
+// lookupID returns the ID address of an actor, so different addresses of the same actor can be compared.
+// Robust addresses are resolved at a given tipset and cached, since they do not change once assigned.
+func (s *Listener) lookupID(ctx context.Context, addr address.Address, ts *TipSet) (string, error) {
+	if addr.Protocol() == address.ID {
+		return addr.String(), nil
+	}
+	if id, ok := s.ids[addr]; ok {
+		return id, nil
+	}
+	id, err := s.client.StateLookupID(ctx, addr, ts.Key())
+	if err != nil {
+		return "", err
+	}
+	if s.ids == nil {
+		s.ids = make(map[address.Address]string)
+	}
+	s.ids[addr] = id.String()
+	return id.String(), nil
+}
+
+// publishDeals starts tracking storage deals published in the tipset, and returns root cids of their files.
+func (s *Listener) publishDeals(ctx context.Context, ts *TipSet, msgs []APIMessage) ([]cid.Cid, error) {
+	deals, cids, err := s.parseDeals(ctx, ts, msgs)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deals {
+		s.deals.add(d)
+	}
+	return cids, nil
+}
+
+// parseDeals returns storage deals published in the tipset, and root cids of their files.
+// Deals of messages that failed to execute are ignored.
+func (s *Listener) parseDeals(ctx context.Context, ts *TipSet, msgs []APIMessage) ([]*deal, []cid.Cid, error) {
+	var (
+		deals []*deal
+		cids  []cid.Cid
+	)
+	for _, m := range msgs {
+		msg := m.Message
+		if msg.To != builtin.StorageMarketActorAddr ||
+			msg.Method != builtin.MethodsMarket.PublishStorageDeals {
+			continue
+		}
+
+		var params market.PublishStorageDealsParams
+		if err := params.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
+			clog.Errorf("failed to unmarshal params at tipset %d, error is: %v", ts.Height, err)
+			return nil, nil, err
+		}
+		lookup, err := s.client.StateSearchMsg(ctx, m.Cid)
+		if err != nil {
+			clog.Errorf("failed to search message %s at tipset %d, error is: %v", m.Cid, ts.Height, err)
+			return nil, nil, err
+		} else if lookup == nil {
+			return nil, nil, fmt.Errorf("message %s at tipset %d is not executed", m.Cid, ts.Height)
+		}
+		if lookup.Receipt.ExitCode != exitcode.Ok {
+			clog.Warningf("skipping deals of message %s at tipset %d, exit code: %d", m.Cid, ts.Height, lookup.Receipt.ExitCode)
+			continue
+		}
+		var ret market.PublishStorageDealsReturn
+		if err := ret.UnmarshalCBOR(bytes.NewReader(lookup.Receipt.Return)); err != nil {
+			clog.Errorf("failed to unmarshal return value at tipset %d, error is: %v", ts.Height, err)
+			return nil, nil, err
+		}
+		if len(ret.IDs) != len(params.Deals) {
+			return nil, nil, fmt.Errorf("unexpected number of deal ids in message %s: %d != %d", m.Cid, len(ret.IDs), len(params.Deals))
+		}
+		root := params.RootCID.String()
+		for i, p := range params.Deals {
+			provider, err := s.lookupID(ctx, p.Proposal.Provider, ts)
+			if err != nil {
+				clog.Errorf("failed to look up provider %s at tipset %d, error is: %v", p.Proposal.Provider, ts.Height, err)
+				return nil, nil, err
+			}
+			deals = append(deals, &deal{
+				ID:       ret.IDs[i],
+				Root:     root,
+				Provider: provider,
+				Start:    int64(p.Proposal.StartEpoch),
+				End:      int64(p.Proposal.EndEpoch),
+				Epoch:    int64(ts.Height),
+			})
+		}
+		cids = append(cids, params.RootCID)
+	}
+	return deals, cids, nil
+}
+
+// endDeals marks deals that expired, were terminated or were never activated by the tipset.
+// It returns root cids of files that are no longer stored by any deal.
+//
+// Sectors are terminated by the miner actor, thus market state is only checked for deals
+// of providers that received sector termination or consensus fault messages in the tipset,
+// and for deals that reached their start epoch without being activated. The state is checked at the tipset
+// itself, since deals that ended later are already removed from the state of the head.
+func (s *Listener) endDeals(ctx context.Context, ts *TipSet, msgs []APIMessage) ([]string, error) {
+	terminated := make(map[string]struct{})
+	for _, m := range msgs {
+		switch m.Message.Method {
+		case builtin.MethodsMiner.TerminateSectors, builtin.MethodsMiner.ReportConsensusFault:
+			// providers of deals are ID addresses, see parseDeals
+			id, err := s.lookupID(ctx, m.Message.To, ts)
+			if err != nil {
+				// messages to unknown actors fail, thus they cannot terminate any deal
+				clog.Warningf("failed to look up actor %s at tipset %d, error is: %v", m.Message.To, ts.Height, err)
+				continue
+			}
+			terminated[id] = struct{}{}
+		}
+	}
+	height := int64(ts.Height)
+	var roots []string
+	for _, d := range s.deals.live() {
+		_, check := terminated[d.Provider]
+		if d.End > height && (check || (!d.Active && d.Start <= height)) {
+			md, err := s.client.StateMarketStorageDeal(ctx, d.ID, ts.Key())
+			switch {
+			case err == errDealNotFound:
+				// deal was already removed from the market state
+				s.deals.expire(d, height, height)
+			case err != nil:
+				clog.Errorf("failed to get deal %d at tipset %d, error is: %v", d.ID, ts.Height, err)
+				return nil, err
+			case md.State.SlashEpoch >= 0:
+				s.deals.expire(d, height, int64(md.State.SlashEpoch))
+			case md.State.SectorStartEpoch >= 0:
+				s.deals.activate(d)
+			case d.Start <= height:
+				// deal was not included in a sector in time
+				s.deals.expire(d, height, height)
+			}
+		}
+		if d.End <= height {
+			s.deals.end(d, height)
+			roots = append(roots, d.Root)
+		}
+	}
+	// files are deleted only if no other deal stores them
+	out := roots[:0]
+	seen := make(map[string]struct{}, len(roots))
+	for _, root := range roots {
+		if _, ok := seen[root]; ok || s.deals.stored(root) {
+			continue
+		}
+		seen[root] = struct{}{}
+		out = append(out, root)
+	}
+	return out, nil
+}
@@ -2,37 +2,335 @@ package epik
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/epik-protocol/epik-gateway-backend/clog"
+	"github.com/epik-protocol/epik-gateway-backend/internal/epikquad"
 	"golang.org/x/sync/semaphore"
 )
 
+const (
+	flagEpikSpool       = "datasource.spool"
+	flagEpikTimeout     = "datasource.download.timeout"
+	flagEpikRetries     = "datasource.download.retries"
+	flagEpikMaxFileSize = "datasource.download.max_file_size"
+
+	defaultTimeout     = 10 * time.Minute
+	defaultRetries     = 5
+	defaultMaxFileSize = 1 << 30
+
+	// minBackoff is a delay before the first retry, it doubles with each attempt up to maxBackoff.
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// errFileTooLarge is returned when a file exceeds the size limit.
+var errFileTooLarge = errors.New("file is too large")
+
+// downloadConfig contains settings of the downloader.
+type downloadConfig struct {
+	// Timeout is a timeout of a single request.
+	Timeout time.Duration
+	// Retries is a number of retries after a failed request.
+	Retries int
+	// Backoff is a delay before the first retry.
+	Backoff time.Duration
+	// MaxFileSize is a size limit of a single file in bytes.
+	MaxFileSize int64
+}
+
+// statusError is returned for unexpected response codes.
+type statusError struct {
+	Code int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("unexpected response status: %d %s", e.Code, http.StatusText(e.Code))
+}
+
+// temporary checks if the request should be retried.
+func (e statusError) temporary() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code >= 500
+}
+
+// netError is returned for failed requests and failed reads of the response body.
+// Errors of the spool are not wrapped, thus they are not retried.
+type netError struct {
+	err error
+}
+
+func (e netError) Error() string {
+	return e.err.Error()
+}
+
+// bodyReader wraps read errors of the response body with netError.
+type bodyReader struct {
+	r io.Reader
+}
+
+func (r bodyReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = netError{err: err}
+	}
+	return n, err
+}
+
+// spool is a directory where downloaded files are kept until they are applied to the graph.
+// Files are keyed by root cid, thus restarts of the sync do not download them again.
+//
+// Files that failed to download are quarantined: they are skipped by the sync until
+// the corresponding file in the quarantine subdirectory is removed.
+//
+// Files that were not applied with their tipsets, either quarantined or not reported by the node,
+// are recorded in the pending subdirectory, thus they are retried by later syncs, including after restarts.
+type spool struct {
+	dir string
+}
+
+// spoolDir returns the default spool directory of a database with a given local path.
+// The spool is kept under the database directory, or next to the database file.
+func spoolDir(db string) string {
+	if fi, err := os.Stat(db); err == nil && fi.IsDir() {
+		return filepath.Join(db, "epik-spool")
+	}
+	return filepath.Clean(db) + "-epik-spool"
+}
+
+func newSpool(dir string) (spool, error) {
+	s := spool{dir: dir}
+	for _, dir := range []string{s.quarantineDir(), s.reportDir(), s.pendingDir()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return spool{}, err
+		}
+	}
+	return s, nil
+}
+
+func (s spool) path(root string) string {
+	return filepath.Join(s.dir, root)
+}
+
+func (s spool) quarantineDir() string {
+	return filepath.Join(s.dir, "quarantine")
+}
+
+func (s spool) reportDir() string {
+	return filepath.Join(s.dir, "reports")
+}
+
+func (s spool) pendingDir() string {
+	return filepath.Join(s.dir, "pending")
+}
+
+// has checks if a file was downloaded already.
+func (s spool) has(root string) bool {
+	_, err := os.Stat(s.path(root))
+	return err == nil
+}
+
+// remove deletes the downloaded file.
+func (s spool) remove(root string) error {
+	err := os.Remove(s.path(root))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s spool) quarantined(root string) bool {
+	_, err := os.Stat(filepath.Join(s.quarantineDir(), root))
+	return err == nil
+}
+
+// quarantine records the reason why the file cannot be retrieved.
+func (s spool) quarantine(root string, reason error) error {
+	return ioutil.WriteFile(filepath.Join(s.quarantineDir(), root), []byte(reason.Error()+"\n"), 0644)
+}
+
+// saveReport records malformed lines of the file, thus they can be reported to the data provider.
+func (s spool) saveReport(root string, report *epikquad.Report) error {
+	data, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.reportDir(), root+".json"), data, 0644)
+}
+
+// pendingFile is a file of a storage deal that was not applied with its tipset.
+type pendingFile struct {
+	// Epoch is the epoch of the tipset the file was published in.
+	Epoch int64 `json:"epoch"`
+	// Applied is the epoch the file was applied at by a retry, or zero if it's still pending.
+	Applied int64 `json:"applied,omitempty"`
+}
+
+// setPending records the state of the pending file.
+func (s spool) setPending(root string, p pendingFile) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.pendingDir(), root), data, 0644)
+}
+
+// addPending records the file as pending, unless it's recorded already.
+func (s spool) addPending(root string, epoch int64) error {
+	_, err := os.Stat(filepath.Join(s.pendingDir(), root))
+	if err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return s.setPending(root, pendingFile{Epoch: epoch})
+}
+
+func (s spool) removePending(root string) error {
+	err := os.Remove(filepath.Join(s.pendingDir(), root))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// listPending returns all pending files by root cid, including ones that were applied by a retry.
+func (s spool) listPending() (map[string]pendingFile, error) {
+	files, err := ioutil.ReadDir(s.pendingDir())
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]pendingFile, len(files))
+	for _, f := range files {
+		data, err := ioutil.ReadFile(filepath.Join(s.pendingDir(), f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var p pendingFile
+		if err = json.Unmarshal(data, &p); err != nil {
+			return nil, fmt.Errorf("invalid pending file %s: %v", f.Name(), err)
+		}
+		out[f.Name()] = p
+	}
+	return out, nil
+}
+
+// pendingRoots returns sorted root cids of files that are still pending.
+func (s spool) pendingRoots() ([]string, error) {
+	pending, err := s.listPending()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for root, p := range pending {
+		if p.Applied == 0 {
+			out = append(out, root)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// revertPending forgets files published at or after a given epoch, since their tipsets are applied again,
+// and makes files applied by retries since then pending again.
+func (s spool) revertPending(epoch int64) error {
+	pending, err := s.listPending()
+	if err != nil {
+		return err
+	}
+	for root, p := range pending {
+		if p.Epoch >= epoch {
+			err = s.removePending(root)
+		} else if p.Applied >= epoch {
+			err = s.setPending(root, pendingFile{Epoch: p.Epoch})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prunePending forgets files applied by retries before a given epoch.
+func (s spool) prunePending(epoch int64) error {
+	pending, err := s.listPending()
+	if err != nil {
+		return err
+	}
+	for root, p := range pending {
+		if p.Applied != 0 && p.Applied < epoch {
+			if err = s.removePending(root); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// listQuarantined returns root cids of all quarantined files.
+func (s spool) listQuarantined() ([]string, error) {
+	files, err := ioutil.ReadDir(s.quarantineDir())
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(files))
+	for _, f := range files {
+		out = append(out, f.Name())
+	}
+	return out, nil
+}
+
 type downloader struct {
+	conf   downloadConfig
+	spool  spool
+	client *http.Client
+
+	wg  sync.WaitGroup
+	sem *semaphore.Weighted
+
 	mu     sync.Mutex
-	wg     sync.WaitGroup
-	sem    *semaphore.Weighted
-	result map[string][]byte
-	failed int
+	result map[string]string
+	failed map[string]error
 }
 
-func newDownloader() *downloader {
+func newDownloader(conf downloadConfig, sp spool) *downloader {
 	return &downloader{
+		conf:   conf,
+		spool:  sp,
+		client: &http.Client{Timeout: conf.Timeout},
 		sem:    semaphore.NewWeighted(int64(runtime.NumCPU()) * 2),
-		result: make(map[string][]byte),
+		result: make(map[string]string),
+		failed: make(map[string]error),
 	}
 }
 
+// download starts downloading the file to the spool.
+// Files that are already in the spool are not downloaded again.
 func (d *downloader) download(ctx context.Context, key, url string) error {
 	d.mu.Lock()
-	defer d.mu.Unlock()
-
-	if _, ok := d.result[key]; ok {
+	_, ok := d.result[key]
+	if !ok {
+		_, ok = d.failed[key]
+	}
+	if !ok && d.spool.has(key) {
+		d.result[key] = d.spool.path(key)
+		ok = true
+	}
+	d.mu.Unlock()
+	if ok {
 		return nil
 	}
+
 	if err := d.sem.Acquire(ctx, 1); err != nil {
 		clog.Errorf("failed to acquire semaphore error: %v", err)
 		return err
@@ -42,36 +340,108 @@ func (d *downloader) download(ctx context.Context, key, url string) error {
 		defer d.sem.Release(1)
 		defer d.wg.Done()
 
-		resp, err := http.Get(url)
+		err := d.fetch(ctx, key, url)
+		d.mu.Lock()
+		defer d.mu.Unlock()
 		if err != nil {
 			clog.Errorf("failed to download %s(%s), error: %v", key, url, err)
-			d.failed++
+			d.failed[key] = err
 			return
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode == http.StatusOK {
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				clog.Errorf("failed to read response %s(%s), error: %v", key, url, err)
-				d.failed++
-				return
-			}
-			body, err = preprocess(body)
-			if err != nil {
-				clog.Errorf("failed to preprocess data from %s(%s), error: %v", key, url, err)
-				d.failed++
-				return
-			}
-			d.mu.Lock()
-			d.result[key] = body
-			d.mu.Unlock()
-		} else {
-			d.failed++
-		}
+		d.result[key] = d.spool.path(key)
 	}()
 	return nil
 }
 
+// fetch downloads the file, retrying temporary errors with exponential backoff.
+func (d *downloader) fetch(ctx context.Context, key, url string) error {
+	backoff := d.conf.Backoff
+	for i := 0; ; i++ {
+		err := d.fetchOnce(ctx, key, url)
+		if err == nil || i >= d.conf.Retries || !retryable(err) {
+			return err
+		}
+		clog.Warningf("failed to download %s(%s), retrying in %v, error: %v", key, url, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// retryable checks if the download failed because of a network error or a temporary error of the server.
+func retryable(err error) bool {
+	switch err := err.(type) {
+	case statusError:
+		return err.temporary()
+	case netError:
+		return true
+	}
+	return false
+}
+
+// fetchOnce downloads the file to a temporary file in the spool, and renames it once the download is complete.
+func (d *downloader) fetchOnce(ctx context.Context, key, url string) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return netError{err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError{Code: resp.StatusCode}
+	}
+	if d.conf.MaxFileSize > 0 && resp.ContentLength > d.conf.MaxFileSize {
+		return errFileTooLarge
+	}
+	f, err := ioutil.TempFile(d.spool.dir, key+".*.part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	var r io.Reader = bodyReader{r: resp.Body}
+	if d.conf.MaxFileSize > 0 {
+		r = io.LimitReader(r, d.conf.MaxFileSize+1)
+	}
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		if _, ok := err.(netError); ok && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if d.conf.MaxFileSize > 0 && n > d.conf.MaxFileSize {
+		return errFileTooLarge
+	}
+	return os.Rename(f.Name(), d.spool.path(key))
+}
+
 func (d *downloader) wait() {
 	d.wg.Wait()
 }
+
+// failures returns errors of files that failed to download by their keys.
+func (d *downloader) failures() map[string]error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make(map[string]error, len(d.failed))
+	for k, err := range d.failed {
+		out[k] = err
+	}
+	return out
+}
@@ -0,0 +1,203 @@
+package epik
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph/memstore"
+)
+
+func newTestSpool(t *testing.T) spool {
+	dir, err := ioutil.TempDir("", "spool")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sp, err := newSpool(dir)
+	require.NoError(t, err)
+	return sp
+}
+
+func TestDownloaderRetry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		switch r.URL.Path {
+		case "/flaky":
+			if n < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("<a> - <b> - <c>\n"))
+		case "/ok":
+			w.Write([]byte("<a> - <b> - <c>\n"))
+		case "/large":
+			w.Write(make([]byte, 100))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.TODO()
+	sp := newTestSpool(t)
+	conf := downloadConfig{Timeout: time.Second, Retries: 3, Backoff: time.Millisecond, MaxFileSize: 50}
+
+	d := newDownloader(conf, sp)
+	require.NoError(t, d.download(ctx, "flaky", srv.URL+"/flaky"))
+	d.wait()
+	require.Empty(t, d.failures())
+	require.Equal(t, map[string]string{"flaky": sp.path("flaky")}, d.result)
+	require.Equal(t, int32(3), calls)
+
+	// permanent errors are not retried
+	calls = 0
+	d = newDownloader(conf, sp)
+	require.NoError(t, d.download(ctx, "missing", srv.URL+"/missing"))
+	require.NoError(t, d.download(ctx, "large", srv.URL+"/large"))
+	d.wait()
+	failed := d.failures()
+	require.Equal(t, statusError{Code: http.StatusNotFound}, failed["missing"])
+	require.Equal(t, errFileTooLarge, failed["large"])
+	require.Equal(t, int32(2), calls)
+	require.False(t, sp.has("large"))
+
+	// errors of the spool and cancelled downloads are not retried
+	calls = 0
+	d = newDownloader(conf, spool{dir: filepath.Join(sp.dir, "missing")})
+	require.NoError(t, d.download(ctx, "ok", srv.URL+"/ok"))
+	d.wait()
+	require.Error(t, d.failures()["ok"])
+	require.Equal(t, int32(1), calls)
+
+	calls = 0
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	d = newDownloader(conf, sp)
+	require.Equal(t, context.Canceled, d.fetch(cctx, "ok", srv.URL+"/ok"))
+	require.Equal(t, int32(0), calls)
+
+	// spooled files are not downloaded again
+	calls = 0
+	d = newDownloader(conf, sp)
+	require.NoError(t, d.download(ctx, "flaky", srv.URL+"/flaky"))
+	d.wait()
+	require.Equal(t, int32(0), calls)
+	require.Equal(t, map[string]string{"flaky": sp.path("flaky")}, d.result)
+}
+
+func TestListenerQuarantine(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ok" {
+			w.Write([]byte("<a> - <b> - <c>\n"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	ctx := context.TODO()
+	l, err := newListener(memstore.New())
+	require.NoError(t, err)
+	l.spool = newTestSpool(t)
+	l.download = downloadConfig{Timeout: time.Second, Backoff: time.Millisecond}
+	l.pollInterval = time.Millisecond
+
+	good, bad, broken := blockCid(t, "file-ok"), blockCid(t, "file-missing"), blockCid(t, "file-broken")
+	absent := blockCid(t, "file-absent")
+	chain := newTestChain(t, "a", 1)
+	chain.files = map[cid.Cid]FileResp{
+		good:   {Root: good, Status: FileDownloaded, Url: srv.URL + "/ok"},
+		bad:    {Root: bad, Status: FileDownloaded, Url: srv.URL + "/missing"},
+		broken: {Root: broken, Status: -1},
+	}
+	l.client = chain
+
+	files, err := l.retrieveFiles(ctx, 1, []cid.Cid{good, bad, broken, absent})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{good.String(): l.spool.path(good.String())}, files)
+	require.True(t, l.spool.quarantined(bad.String()))
+	require.True(t, l.spool.quarantined(broken.String()))
+
+	q, err := l.spool.listQuarantined()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{bad.String(), broken.String()}, q)
+
+	// files that were quarantined or not reported by the node are retried later
+	pending, err := l.spool.pendingRoots()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{bad.String(), broken.String(), absent.String()}, pending)
+	require.ElementsMatch(t, pending, l.Status().Pending)
+
+	qs := l.store.(*memstore.QuadStore)
+	require.NoError(t, l.applyTipSet(ctx, 1, nil, files))
+	refs, err := qs.QuadsByCid(ctx, good.String())
+	require.NoError(t, err)
+	require.Len(t, refs, 1)
+
+	// quarantined files are skipped without asking the node
+	chain.files = nil
+	files, err = l.retrieveFiles(ctx, 2, []cid.Cid{good, bad})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{good.String(): l.spool.path(good.String())}, files)
+
+	// the retry applies files that became available, and forgets files without live deals
+	chain.files = map[cid.Cid]FileResp{
+		absent: {Root: absent, Status: FileDownloaded, Url: srv.URL + "/ok"},
+	}
+	l.deals.add(&deal{ID: 1, Root: absent.String(), End: 100, Epoch: 1})
+	require.NoError(t, l.retryPending(ctx, 2))
+	refs, err = qs.QuadsByCid(ctx, absent.String())
+	require.NoError(t, err)
+	require.Len(t, refs, 1)
+	pending, err = l.spool.pendingRoots()
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	// reverting the epoch of the retry makes the file pending again
+	require.NoError(t, l.spool.revertPending(2))
+	pending, err = l.spool.pendingRoots()
+	require.NoError(t, err)
+	require.Equal(t, []string{absent.String()}, pending)
+
+	// and reverting the epoch it was published in forgets it
+	require.NoError(t, l.spool.revertPending(1))
+	all, err := l.spool.listPending()
+	require.NoError(t, err)
+	require.Empty(t, all)
+}
+
+func TestListenerMalformedLines(t *testing.T) {
+	ctx := context.TODO()
+	const data = "<a> - <b> - <c>\nillegal line\n"
+	for _, strict := range []bool{false, true} {
+		l, err := newListener(memstore.New())
+		require.NoError(t, err)
+		l.spool = newTestSpool(t)
+		l.strict = strict
+
+		root := blockCid(t, "file-malformed").String()
+		require.NoError(t, ioutil.WriteFile(l.spool.path(root), []byte(data), 0644))
+		require.NoError(t, l.applyTipSet(ctx, 1, nil, map[string]string{root: l.spool.path(root)}))
+
+		refs, err := l.store.(*memstore.QuadStore).QuadsByCid(ctx, root)
+		require.NoError(t, err)
+		require.Equal(t, strict, l.spool.quarantined(root))
+		if strict {
+			require.Len(t, refs, 0)
+			continue
+		}
+		require.Len(t, refs, 1)
+		report, err := ioutil.ReadFile(filepath.Join(l.spool.reportDir(), root+".json"))
+		require.NoError(t, err)
+		require.Contains(t, string(report), `"line": 2`)
+	}
+}
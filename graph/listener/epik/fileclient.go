@@ -0,0 +1,191 @@
+package epik
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/ipfs/go-cid"
+)
+
+var _ EpikClient = (*FileClient)(nil)
+
+// FileClient is an EpikClient that replays a chain from a directory of fixtures, thus the listener can sync offline.
+//
+// Fixtures use the same JSON encoding as the node API, so they can be recorded from a live node:
+//
+//	tipsets/<height>.json       TipSet, the highest one is the chain head
+//	messages/<block cid>.json   BlockMessages of the block, blocks without a file have no messages
+//	receipts/<message cid>.json MsgLookup of an executed message
+//	deals/<deal id>.json        MarketDeal in the market actor state
+//	actors/<address>.json       ID address of an actor with a given robust address
+//	files/<root cid>            file of the storage deal, served over HTTP
+//
+// Message params and return values are CBOR encoded, as on the chain.
+type FileClient struct {
+	dir     string
+	tipsets map[abi.ChainEpoch]*TipSet
+	head    *TipSet
+
+	srv *http.Server
+	url string
+}
+
+// NewFileClient loads tipsets from a directory and starts a local HTTP server for files.
+func NewFileClient(dir string) (*FileClient, error) {
+	c := &FileClient{dir: dir, tipsets: make(map[abi.ChainEpoch]*TipSet)}
+	names, err := filepath.Glob(filepath.Join(dir, "tipsets", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		var ts TipSet
+		if err = c.readJSON(&ts, "tipsets", filepath.Base(name)); err != nil {
+			return nil, err
+		}
+		c.tipsets[ts.Height] = &ts
+		if c.head == nil || ts.Height > c.head.Height {
+			c.head = &ts
+		}
+	}
+	if c.head == nil {
+		return nil, fmt.Errorf("no tipsets in %s", dir)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	c.url = "http://" + lis.Addr().String()
+	c.srv = &http.Server{Handler: http.FileServer(http.Dir(filepath.Join(dir, "files")))}
+	go c.srv.Serve(lis)
+	return c, nil
+}
+
+func (c *FileClient) readJSON(dst interface{}, path ...string) error {
+	data, err := ioutil.ReadFile(filepath.Join(append([]string{c.dir}, path...)...))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+// Close stops the file server.
+func (c *FileClient) Close() error {
+	return c.srv.Close()
+}
+
+func (c *FileClient) ChainHead(context.Context) (*TipSet, error) {
+	return c.head, nil
+}
+
+// ChainGetTipSetByHeight returns a tipset at a given height, or the first one before it in case of null rounds.
+// Fixtures only contain a single chain, thus the key is ignored.
+func (c *FileClient) ChainGetTipSetByHeight(_ context.Context, h abi.ChainEpoch, _ TipSetKey) (*TipSet, error) {
+	if h > c.head.Height {
+		return nil, fmt.Errorf("looking for tipset with height greater than head (%d > %d)", h, c.head.Height)
+	}
+	for ; h >= 0; h-- {
+		if ts, ok := c.tipsets[h]; ok {
+			return ts, nil
+		}
+	}
+	return nil, fmt.Errorf("no tipset at %d", h)
+}
+
+func (c *FileClient) ChainGetBlockMessages(_ context.Context, blockCid cid.Cid) (*BlockMessages, error) {
+	var bm BlockMessages
+	err := c.readJSON(&bm, "messages", blockCid.String()+".json")
+	if os.IsNotExist(err) {
+		return &BlockMessages{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &bm, nil
+}
+
+// ClientQuery reports all files that exist in the fixtures as downloaded.
+func (c *FileClient) ClientQuery(_ context.Context, roots []cid.Cid) ([]FileResp, error) {
+	out := make([]FileResp, 0, len(roots))
+	for _, root := range roots {
+		if _, err := os.Stat(filepath.Join(c.dir, "files", root.String())); err != nil {
+			return nil, err
+		}
+		out = append(out, FileResp{
+			Root:   root,
+			Status: FileDownloaded,
+			Url:    c.url + "/" + root.String(),
+		})
+	}
+	return out, nil
+}
+
+func (c *FileClient) ChainGetParentMessages(context.Context, cid.Cid) ([]APIMessage, error) {
+	return nil, errors.New("parent messages are not supported by the file client")
+}
+
+// ChainGetMessage searches for a message in all blocks of the fixtures.
+func (c *FileClient) ChainGetMessage(ctx context.Context, mc cid.Cid) (*Message, error) {
+	for _, ts := range c.tipsets {
+		for _, bc := range ts.Cids {
+			bm, err := c.ChainGetBlockMessages(ctx, bc)
+			if err != nil {
+				return nil, err
+			}
+			for i, m := range bm.Cids {
+				if m != mc {
+					continue
+				} else if i < len(bm.BlsMessages) {
+					return bm.BlsMessages[i], nil
+				}
+				return &bm.SecpkMessages[i-len(bm.BlsMessages)].Message, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("message %s not found", mc)
+}
+
+// StateSearchMsg returns nil if there is no receipt for the message, same as the node does.
+func (c *FileClient) StateSearchMsg(_ context.Context, mc cid.Cid) (*MsgLookup, error) {
+	var lookup MsgLookup
+	err := c.readJSON(&lookup, "receipts", mc.String()+".json")
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &lookup, nil
+}
+
+func (c *FileClient) StateMarketStorageDeal(_ context.Context, id abi.DealID, _ TipSetKey) (*MarketDeal, error) {
+	var deal MarketDeal
+	err := c.readJSON(&deal, "deals", strconv.FormatUint(uint64(id), 10)+".json")
+	if os.IsNotExist(err) {
+		return nil, errDealNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &deal, nil
+}
+
+func (c *FileClient) StateLookupID(_ context.Context, addr address.Address, _ TipSetKey) (address.Address, error) {
+	if addr.Protocol() == address.ID {
+		return addr, nil
+	}
+	var id address.Address
+	err := c.readJSON(&id, "actors", addr.String()+".json")
+	if os.IsNotExist(err) {
+		return address.Undef, fmt.Errorf("actor %s not found", addr)
+	} else if err != nil {
+		return address.Undef, err
+	}
+	return id, nil
+}
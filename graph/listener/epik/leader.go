@@ -0,0 +1,114 @@
+package epik
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/epik-protocol/epik-gateway-backend/clog"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+)
+
+const (
+	// syncLock is the name of the lock held by the listener that syncs the store.
+	syncLock = "sync"
+	// lockTTL is the time after which the lock of a crashed process can be taken over.
+	lockTTL = time.Minute
+)
+
+// lockOwner identifies the process in the sync lock.
+func lockOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}
+
+// lead acquires the sync lock of the store, unless it is held already, so only one process
+// applies epochs to a database shared by multiple gateways. The lock is renewed in the background
+// and the returned context is cancelled if the lock is lost.
+//
+// It returns graph.ErrLocked if the store is synced by another process.
+// Stores that do not support locks are synced without one. Must be called with syncMu held.
+func (s *Listener) lead(ctx context.Context) (context.Context, error) {
+	ls, ok := s.store.(graph.LockStore)
+	if !ok {
+		return ctx, nil
+	}
+	if s.leader != nil && s.leader.Err() == nil {
+		return s.leader, nil
+	}
+	s.resign()
+	if err := ls.Lock(ctx, syncLock, s.owner, lockTTL); err != nil {
+		s.setStandby(err == graph.ErrLocked)
+		return nil, err
+	}
+	lctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(lockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-lctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := ls.Lock(lctx, syncLock, s.owner, lockTTL); err != nil && lctx.Err() == nil {
+				clog.Errorf("lost the sync lock: %v", err)
+				cancel()
+				return
+			}
+		}
+	}()
+	s.leader = lctx
+	s.unlock = func() {
+		cancel()
+		<-done
+		if err := ls.Unlock(context.Background(), syncLock, s.owner); err != nil {
+			clog.Warningf("failed to release the sync lock: %v", err)
+		}
+	}
+	s.setStandby(false)
+	clog.Infof("epik syncer acquired the sync lock as %s", s.owner)
+
+	// deals could be changed by the process that held the lock before
+	if err := s.loadDeals(lctx); err != nil {
+		s.resign()
+		return nil, fmt.Errorf("failed to load deals: %v", err)
+	}
+	return lctx, nil
+}
+
+var _ graph.Leader = (*Listener)(nil)
+
+// Lead acquires the sync lock of the store, see lead.
+func (s *Listener) Lead(ctx context.Context) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	_, err := s.lead(ctx)
+	return err
+}
+
+// leading checks if the sync lock is held. Must be called with syncMu held.
+func (s *Listener) leading() bool {
+	return s.leader != nil && s.leader.Err() == nil
+}
+
+// resign releases the sync lock, if it is held. Must be called with syncMu held.
+func (s *Listener) resign() {
+	if s.unlock == nil {
+		return
+	}
+	s.unlock()
+	s.leader, s.unlock = nil, nil
+}
+
+func (s *Listener) setStandby(standby bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Standby = standby
+}
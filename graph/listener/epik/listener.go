@@ -2,33 +2,92 @@ package epik
 
 import (
 	"bytes"
-	"container/list"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"sort"
 	"sync"
 	"time"
 
-	"github.com/cayleygraph/quad/nquads"
+	"github.com/cayleygraph/quad"
 	"github.com/epik-protocol/epik-gateway-backend/clog"
 	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/internal/epikquad"
+	"github.com/filecoin-project/go-address"
 	"github.com/ipfs/go-cid"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/filecoin-project/specs-actors/actors/abi"
-	"github.com/filecoin-project/specs-actors/actors/builtin"
-	"github.com/filecoin-project/specs-actors/actors/builtin/market"
 )
 
 const (
 	ListenerType = "epik"
 
 	syncDuration = 10 * time.Minute
+	// pollDuration is an interval of file status checks while files are retrieved by the node.
+	pollDuration = 5 * time.Second
+
+	flagEpikFinality      = "datasource.finality"
+	flagEpikConfirmations = "datasource.confirmations"
+	flagEpikDeals         = "datasource.deals"
+	flagEpikStrict        = "datasource.strict"
+	flagEpikFetchers      = "datasource.fetchers"
+
+	flagLoadBatch = "load.batch"
+
+	// defaultFinality is the number of epochs after which a tipset is considered final.
+	defaultFinality = 900
+	// defaultConfirmations is the number of epochs a tipset must be behind the head to be applied.
+	defaultConfirmations = 1
+	// defaultFetchers is the number of tipsets fetched in parallel.
+	defaultFetchers = 8
+
+	// OptClient is a listener option to use a given EpikClient instead of dialing a node.
+	OptClient = "client"
+	// OptFixtures is a listener option to replay the chain from a directory of fixtures, see NewFileClient.
+	OptFixtures = "fixtures"
 )
 
+// errChainReorg is returned when the chain was reorganized during the sync.
+var errChainReorg = errors.New("chain reorganization detected")
+
 func init() {
 	graph.RegisterListener(ListenerType, graph.ListenerRegistration{
-		NewListenerFunc: func(store graph.QuadStore) (graph.Listener, error) {
-			return newListener(store)
+		NewListenerFunc: func(store graph.QuadStore, opts graph.Options) (graph.Listener, error) {
+			l, err := newListener(store)
+			if err != nil {
+				return nil, err
+			}
+			if c, ok := opts[OptClient]; ok {
+				client, ok := c.(EpikClient)
+				if !ok {
+					return nil, fmt.Errorf("invalid %s option type: %T", OptClient, c)
+				}
+				l.client = client
+			} else if dir, err := opts.StringKey(OptFixtures, ""); err != nil {
+				return nil, err
+			} else if dir != "" {
+				client, err := NewFileClient(dir)
+				if err != nil {
+					return nil, err
+				}
+				l.client, l.closeClient = client, func() { client.Close() }
+			}
+			if l.spool.dir == "" {
+				db, err := opts.StringKey(graph.OptListenerDatabase, "")
+				if err != nil {
+					return nil, err
+				} else if db == "" {
+					return nil, fmt.Errorf("%s must be set for databases without a local path", flagEpikSpool)
+				}
+				if l.spool, err = newSpool(spoolDir(db)); err != nil {
+					return nil, fmt.Errorf("failed to create spool: %v", err)
+				}
+			}
+			return l, nil
 		},
 	})
 }
@@ -38,16 +97,117 @@ type Listener struct {
 	start sync.Once
 	wg    sync.WaitGroup
 
-	client EpikClient
-	store  graph.QuadStore
+	client      EpikClient
+	closeClient func()
+	store       graph.QuadStore
+
+	// finality is the max depth of chain reorgs the listener is able to revert.
+	finality int64
+	// confirmations is the number of epochs a tipset must be behind the chain head to be applied.
+	confirmations int64
+
+	deals *dealTracker
+	// ids caches ID addresses of actors by their robust addresses, see lookupID.
+	ids map[address.Address]string
+
+	spool        spool
+	download     downloadConfig
+	pollInterval time.Duration
+	// batchSize is the max number of deltas applied at once.
+	batchSize int
+	// strict is set to quarantine files with malformed lines instead of skipping these lines.
+	strict bool
+	// fetchers is the number of tipsets fetched in parallel.
+	fetchers int
+
+	// syncMu prevents concurrent syncs.
+	syncMu sync.Mutex
+	// committed is the last epoch committed by this listener, see commitEpoch.
+	committed int64
+	// owner identifies the process in the sync lock of the store.
+	owner string
+	// leader is the context of the sync lock, it is cancelled when the lock is lost.
+	leader context.Context
+	unlock func()
+
+	// trigger starts a sync without waiting for the ticker.
+	trigger chan struct{}
+	// feed receives applied deltas, it may be nil.
+	feed *graph.Feed
+
+	mu     sync.Mutex
+	status graph.ListenerStatus
+	// resync is the epoch requested by Resync, or zero.
+	resync int64
 }
 
 func newListener(store graph.QuadStore) (*Listener, error) {
+	finality := viper.GetInt64(flagEpikFinality)
+	if finality <= 0 {
+		finality = defaultFinality
+	}
+	confirmations := viper.GetInt64(flagEpikConfirmations)
+	if confirmations <= 0 {
+		confirmations = defaultConfirmations
+	}
+	// the spool is created by the listener registration if it is not set, see spoolDir
+	var sp spool
+	if dir := viper.GetString(flagEpikSpool); dir != "" {
+		var err error
+		if sp, err = newSpool(dir); err != nil {
+			return nil, fmt.Errorf("failed to create spool: %v", err)
+		}
+	}
+	download := downloadConfig{
+		Timeout:     viper.GetDuration(flagEpikTimeout),
+		Retries:     defaultRetries,
+		Backoff:     minBackoff,
+		MaxFileSize: viper.GetInt64(flagEpikMaxFileSize),
+	}
+	if download.Timeout <= 0 {
+		download.Timeout = defaultTimeout
+	}
+	if viper.IsSet(flagEpikRetries) {
+		download.Retries = viper.GetInt(flagEpikRetries)
+	}
+	if download.MaxFileSize <= 0 {
+		download.MaxFileSize = defaultMaxFileSize
+	}
+	fetchers := viper.GetInt(flagEpikFetchers)
+	if fetchers <= 0 {
+		fetchers = defaultFetchers
+	}
+	batch := viper.GetInt(flagLoadBatch)
+	if batch <= 0 {
+		batch = quad.DefaultBatch
+	}
+	l := &Listener{
+		quit:          make(chan struct{}),
+		trigger:       make(chan struct{}, 1),
+		store:         store,
+		finality:      finality,
+		confirmations: confirmations,
+
+		spool:        sp,
+		download:     download,
+		pollInterval: pollDuration,
+		batchSize:    batch,
+		strict:       viper.GetBool(flagEpikStrict),
+		fetchers:     fetchers,
+		owner:        lockOwner(),
+	}
+	if err = l.loadDeals(context.TODO()); err != nil {
+		return nil, fmt.Errorf("failed to load deals: %v", err)
+	}
+	return l, nil
+}
 
-	return &Listener{
-		quit:  make(chan struct{}),
-		store: store,
-	}, nil
+var _ graph.FeedPublisher = (*Listener)(nil)
+
+// SetFeed implements graph.FeedPublisher. Deltas are published once they are applied to the store,
+// before the epoch is committed; reverted epochs are published as revert events.
+func (s *Listener) SetFeed(f *graph.Feed) {
+	s.feed = f
 }
 
 func (s *Listener) Start() {
@@ -57,6 +217,19 @@ func (s *Listener) Start() {
 	})
 }
 
+// connect dials the node, unless the client was set by options.
+func (s *Listener) connect() error {
+	if s.client != nil {
+		return nil
+	}
+	client, closer, err := NewEpikClient()
+	if err != nil {
+		return err
+	}
+	s.client, s.closeClient = client, closer
+	return nil
+}
+
 func (s *Listener) listen() {
 
 	defer func() {
@@ -70,15 +243,12 @@ func (s *Listener) listen() {
 	ticker := time.NewTicker(syncDuration)
 	defer ticker.Stop()
 
-	var (
-		err   error
-		close func()
-	)
-	s.client, close, err = NewEpikClient()
-	if err != nil {
+	if err := s.connect(); err != nil {
 		clog.Fatalf("failed to init epik client: %v", err)
 	}
-	defer close()
+	if s.closeClient != nil {
+		defer s.closeClient()
+	}
 
 	ctx, cancelFunc := context.WithCancel(context.Background())
 
@@ -86,50 +256,223 @@ func (s *Listener) listen() {
 		select {
 		case <-s.quit:
 			cancelFunc()
+			s.syncMu.Lock()
+			s.resign()
+			s.syncMu.Unlock()
 			clog.Infof("epik syncer stopped")
 			return
 		case <-ticker.C:
-			local, err := s.store.Stats(ctx, false)
-			if err != nil {
-				clog.Errorf("failed to get quadstore stats: %v", err)
-				continue
-			}
+		case <-s.trigger:
+		}
+		if s.paused() {
+			continue
+		}
+		s.syncMu.Lock()
+		lctx, err := s.lead(ctx)
+		s.syncMu.Unlock()
+		if err == graph.ErrLocked {
+			clog.Infof("epik syncer is on standby, the store is synced by another process")
+			continue
+		} else if err != nil {
+			clog.Errorf("failed to acquire the sync lock: %v", err)
+			s.syncDone(err)
+			continue
+		}
+		s.syncStarted()
+		err = s.sync(lctx)
+		if err != nil {
+			clog.Errorf("failed to sync: %v", err)
+		}
+		s.syncDone(err)
+	}
+}
 
-			head, err := s.client.ChainHead(ctx)
-			if err != nil {
-				clog.Errorf("failed to get head epoch: %v", err)
-				continue
+// sync reverts epochs that are no longer on the canonical chain, if any,
+// and applies all tipsets between the local epoch and the last confirmed epoch, see confirmations.
+func (s *Listener) sync(ctx context.Context) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	local, err := s.store.Stats(ctx, false)
+	if err != nil {
+		return fmt.Errorf("failed to get quadstore stats: %v", err)
+	}
+
+	head, err := s.client.ChainHead(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get head epoch: %v", err)
+	}
+	remote := int64(head.Height)
+	s.setEpoch(local.Epoch)
+	s.setHead(remote)
+	// deals of an interrupted sync were not committed with the epoch
+	s.deals.revert(local.Epoch + 1)
+	if remote <= 1 {
+		return nil
+	}
+
+	if from := s.takeResync(); from > 0 && from <= local.Epoch {
+		clog.Warningf("resyncing epochs from %d to %d", from, local.Epoch)
+		if err = s.revertFrom(ctx, from); err != nil {
+			return err
+		}
+		local.Epoch = from - 1
+	}
+
+	es, _ := s.store.(graph.EpochStore)
+	if es == nil && local.Epoch > 0 && local.Epoch != s.committed {
+		// stores without undo logs set the epoch with the first batch of the tipset, thus the last
+		// epoch may be applied partially; it is applied again, since its deltas are idempotent
+		if err = s.revertDeals(local.Epoch); err != nil {
+			return err
+		}
+		local.Epoch--
+	} else if es != nil && local.Epoch > 0 {
+		// tipset keys are written after all deltas of the epoch, the last epoch is either
+		// a null round or was interrupted, reverting it is safe in both cases
+		if _, err = es.EpochKey(ctx, local.Epoch); err == graph.ErrEpochNotExist {
+			if err = s.revertEpochs(ctx, es, local.Epoch); err != nil {
+				return fmt.Errorf("failed to revert epoch %d: %v", local.Epoch, err)
 			}
-			remote := int64(head.Height)
-			if remote <= 1 {
-				continue
+			if err = s.revertDeals(local.Epoch); err != nil {
+				return err
 			}
+			local.Epoch--
+			s.setEpoch(local.Epoch)
+		} else if err != nil {
+			return err
+		}
+	}
+	start := local.Epoch + 1
+	var parent []byte
+	if es != nil {
+		fork, key, err := s.findFork(ctx, es, head, local.Epoch)
+		if err != nil {
+			return err
+		}
+		if fork < local.Epoch {
+			clog.Warningf("chain reorg detected, reverting epochs from %d to %d", fork+1, local.Epoch)
+			if err = s.revertEpochs(ctx, es, fork+1); err != nil {
+				return fmt.Errorf("failed to revert epochs from %d: %v", fork+1, err)
+			}
+			if err = s.revertDeals(fork + 1); err != nil {
+				return err
+			}
+			start = fork + 1
+			s.setEpoch(fork)
+		}
+		parent = key
+	}
 
-			if err = s.syncDeltas(ctx, local.Epoch+1, remote-1); err != nil {
-				clog.Errorf("failed to sync deltas from %d to %d, error is: %v", local.Epoch+1, remote-1, err)
+	if start > 1 {
+		// files missing from previous epochs are applied on top of the last synced one
+		if err = s.retryPending(ctx, start-1); err != nil {
+			return err
+		}
+	}
+	end := remote - s.confirmations
+	if start > end {
+		return nil
+	}
+	if err = s.syncDeltas(ctx, head, parent, start, end, nil); err != nil {
+		return fmt.Errorf("failed to sync deltas from %d to %d, error is: %v", start, end, err)
+	}
+	if remote <= s.finality {
+		return nil
+	}
+	if es != nil {
+		// undo logs of final epochs are no longer needed
+		if err = es.PruneEpochs(ctx, remote-s.finality); err != nil {
+			return fmt.Errorf("failed to prune epochs before %d: %v", remote-s.finality, err)
+		}
+	}
+	// pruned deals are written with the next epoch
+	s.deals.prune(remote - s.finality)
+	if err = s.spool.prunePending(remote - s.finality); err != nil {
+		return fmt.Errorf("failed to prune pending files: %v", err)
+	}
+	return nil
+}
+
+// findFork walks back from the local epoch and compares tipset keys recorded in the store with the canonical chain.
+// It returns the last local epoch that is still on the chain, and the key of the tipset for this epoch, if known.
+func (s *Listener) findFork(ctx context.Context, es graph.EpochStore, head *TipSet, local int64) (int64, []byte, error) {
+	reorg := false
+	for e := local; e > 0 && e > local-s.finality; e-- {
+		key, err := es.EpochKey(ctx, e)
+		if err == graph.ErrEpochNotExist {
+			// null round or an epoch applied without a key
+			continue
+		} else if err != nil {
+			return 0, nil, err
+		}
+		if e < int64(head.Height) {
+			ts, err := s.client.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(e), head.Key())
+			if err != nil {
+				clog.Errorf("failed to get tipset at epoch %d, error is: %v", e, err)
+				return 0, nil, err
+			}
+			if int64(ts.Height) == e && bytes.Equal(ts.Key().Bytes(), key) {
+				if !reorg && e < local && local < int64(head.Height) {
+					// local epochs after e were null rounds, make sure they still are
+					ts, err = s.client.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(local), head.Key())
+					if err != nil {
+						clog.Errorf("failed to get tipset at epoch %d, error is: %v", local, err)
+						return 0, nil, err
+					}
+					reorg = int64(ts.Height) > e
+				}
+				if reorg {
+					return e, key, nil
+				}
+				return local, key, nil
 			}
 		}
+		reorg = true
 	}
+	if reorg {
+		return 0, nil, fmt.Errorf("chain reorg is deeper than %d epochs", s.finality)
+	}
+	// no tipset keys recorded, reorgs cannot be detected
+	return local, nil, nil
 }
 
 func (s *Listener) Stop() {
 	close(s.quit)
 	s.wg.Wait()
+	// the client and the lock are released by listen, unless the listener was never started
+	s.start.Do(func() {
+		s.syncMu.Lock()
+		s.resign()
+		s.syncMu.Unlock()
+		if s.closeClient != nil {
+			s.closeClient()
+		}
+	})
 }
 
-func (s *Listener) syncDeltas(ctx context.Context, start, end int64) error {
-	tss, err := s.getTipSets(ctx, start, end)
+// syncDeltas applies tipsets in the range of [start, end] on the chain with a given head.
+// If parent is set, the first tipset must be its child. If progress is set, it is called after each tipset.
+func (s *Listener) syncDeltas(ctx context.Context, head *TipSet, parent []byte, start, end int64, progress func(graph.SyncProgress)) error {
+	tss, err := s.getTipSets(ctx, head.Key(), start, end)
 	if err != nil {
 		return err
 	}
 
-	for _, ts := range tss {
+	es, _ := s.store.(graph.EpochStore)
+	for i, ts := range tss {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
 		}
 
+		key := ts.Key().Bytes()
+		if es != nil && parent != nil && !bytes.Equal(ts.Parents().Bytes(), parent) {
+			clog.Errorf("unexpected parent of tipset %d", ts.Height)
+			return errChainReorg
+		}
+
 		// get tipset messages
 		msgs, err := s.getTipSetMessages(ctx, ts)
 		if err != nil {
@@ -137,136 +480,416 @@ func (s *Listener) syncDeltas(ctx context.Context, start, end int64) error {
 		}
 
 		// filter storage deals
-		cids := make([]cid.Cid, 0, len(msgs))
-		for _, msg := range msgs {
-			if msg.To != builtin.StorageMarketActorAddr ||
-				msg.Method != builtin.MethodsMarket.PublishStorageDeals {
-				continue
-			}
-
-			var params market.PublishStorageDealsParams
-			if err := params.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
-				clog.Errorf("failed to unmarshal params at tipset %d, error is: %v", ts.Height, err)
-				return err
-			}
-			cids = append(cids, params.RootCID)
+		cids, err := s.publishDeals(ctx, ts, msgs)
+		if err != nil {
+			return err
 		}
 
-		// add to QuadStore
-		// TODO: delete
-		// deltas, err := parseDeletes(msgs.Deletes)
-		// if err != nil {
-		// 	clog.Errorf("failed to parse deleted cids at epoch %d, error is: %v", current, err)
-		// 	return err
-		// }
-
-		quadAdds, err := s.retrieveFiles(ctx, cids)
+		ended, err := s.endDeals(ctx, ts, msgs)
 		if err != nil {
-			clog.Errorf("failed to wait at epoch %d, error is: %v", ts.Height, err)
 			return err
 		}
-
-		adds, err := parseAdds(quadAdds)
+		deletes, err := parseDeletes(ended)
 		if err != nil {
-			clog.Errorf("failed to parse added cids at epoch %d, error is: %v", ts.Height, err)
+			clog.Errorf("failed to parse deleted cids at epoch %d, error is: %v", ts.Height, err)
 			return err
 		}
 
-		if err = s.store.ApplyDeltas(int64(ts.Height), adds, graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true}); err != nil {
-			clog.Errorf("failed to apply adds at epoch %d, error is: %v", ts.Height, err)
+		var files map[string]string
+		if len(cids) > 0 {
+			files, err = s.retrieveFiles(ctx, int64(ts.Height), cids)
+			if err != nil {
+				clog.Errorf("failed to wait at epoch %d, error is: %v", ts.Height, err)
+				return err
+			}
+		}
+
+		if err = s.applyTipSet(ctx, int64(ts.Height), deletes, files); err != nil {
+			clog.Errorf("failed to apply deltas at epoch %d, error is: %v", ts.Height, err)
 			return err
 		}
+		if es != nil {
+			// tipset key is written last, thus a partially applied epoch is reverted by the next sync
+			if err = es.SetEpochKey(ctx, int64(ts.Height), key); err != nil {
+				clog.Errorf("failed to set tipset key at epoch %d, error is: %v", ts.Height, err)
+				return err
+			}
+		}
+		for root := range files {
+			if err = s.spool.remove(root); err != nil {
+				clog.Warningf("failed to remove file %s from the spool, error is: %v", root, err)
+			}
+		}
+		s.setEpoch(int64(ts.Height))
+		if progress != nil {
+			progress(graph.SyncProgress{Epoch: int64(ts.Height), Done: i + 1, Total: len(tss), Roots: sortedRoots(files)})
+		}
+		parent = key
 	}
 	// just set epoch to "end"
-	return s.store.ApplyDeltas(end, nil, graph.IgnoreOpts{})
+	if err = s.commitEpoch(end, graph.IgnoreOpts{}); err != nil {
+		return err
+	}
+	s.setEpoch(end)
+	return nil
 }
 
-func (s *Listener) retrieveFiles(ctx context.Context, cids []cid.Cid) (map[string][]byte, error) {
-	ticker := time.NewTicker(5 * time.Second)
+// retrieveFiles downloads files with given root cids to the spool, and returns paths of these files by root cid.
+// Files that cannot be retrieved are quarantined and skipped. Quarantined files and files not reported
+// by the node are recorded as pending at a given epoch and are retried by later syncs, see retryPending.
+func (s *Listener) retrieveFiles(ctx context.Context, epoch int64, cids []cid.Cid) (map[string]string, error) {
+	files := make(map[string]string)
+	pending := make([]cid.Cid, 0, len(cids))
+	for _, c := range cids {
+		root := c.String()
+		if s.spool.quarantined(root) {
+			clog.Warningf("skipping quarantined file %s", root)
+			s.addPending(root, epoch)
+		} else if s.spool.has(root) {
+			files[root] = s.spool.path(root)
+		} else {
+			pending = append(pending, c)
+		}
+	}
+	cids = pending
+
+	inFlight := make([]string, 0, len(cids))
+	for _, c := range cids {
+		inFlight = append(inFlight, c.String())
+	}
+	s.setInFlight(inFlight)
+	defer s.setInFlight(nil)
+
+	ticker := time.NewTicker(s.pollInterval)
 	defer ticker.Stop()
 
-	downloader := newDownloader()
-loop:
-	for {
+	downloader := newDownloader(s.download, s.spool)
+	for len(cids) != 0 {
+		fds, err := s.client.ClientQuery(ctx, cids)
+		if err != nil {
+			return nil, err
+		}
+
+		reported := make(map[string]struct{}, len(fds))
 		var unfinished []cid.Cid
+		for _, fd := range fds {
+			reported[fd.Root.String()] = struct{}{}
+			switch fd.Status {
+			case FileDownloaded:
+				if err := downloader.download(ctx, fd.Root.String(), fd.Url); err != nil {
+					return nil, err
+				}
+			case FileDownloading:
+				unfinished = append(unfinished, fd.Root)
+			default:
+				s.quarantine(fd.Root.String(), fmt.Errorf("unexpected file status: %d", fd.Status))
+				s.addPending(fd.Root.String(), epoch)
+			}
+		}
+		for _, c := range cids {
+			if _, ok := reported[c.String()]; !ok {
+				clog.Warningf("file %s is not known to the node, it will be retried", c)
+				s.addPending(c.String(), epoch)
+			}
+		}
+		cids = unfinished
+		if len(cids) == 0 {
+			break
+		}
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
 		case <-ticker.C:
-			if len(cids) == 0 {
-				break loop
-			}
-			fds, err := s.client.ClientQuery(ctx, cids)
-			if err != nil {
-				return nil, err
-			}
+		}
+	}
+	downloader.wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for root, err := range downloader.failures() {
+		s.quarantine(root, err)
+		s.addPending(root, epoch)
+	}
+	for root, path := range downloader.result {
+		files[root] = path
+	}
+	return files, nil
+}
 
-			for _, fd := range fds {
-				switch fd.Status {
-				case FileDownloaded:
-					if err := downloader.download(ctx, fd.Root.String(), fd.Url); err != nil {
-						return nil, err
-					}
-				case FileDownloading:
-					unfinished = append(unfinished, fd.Root)
-				default:
-					return nil, fmt.Errorf("unexpected file status: %d", fd.Status)
-				}
+// retryPending retrieves and applies pending files at a given epoch, which must be already applied to the store.
+// Files of deals that ended in the meantime are forgotten.
+func (s *Listener) retryPending(ctx context.Context, epoch int64) error {
+	roots, err := s.spool.pendingRoots()
+	if err != nil {
+		return fmt.Errorf("failed to list pending files: %v", err)
+	}
+	var cids []cid.Cid
+	for _, root := range roots {
+		if !s.deals.stored(root) {
+			clog.Infof("forgetting pending file %s, no deal stores it anymore", root)
+			if err = s.spool.removePending(root); err != nil {
+				return err
 			}
+			continue
+		} else if s.spool.quarantined(root) {
+			continue
 		}
-		cids = unfinished
+		c, err := cid.Decode(root)
+		if err != nil {
+			clog.Errorf("forgetting pending file with invalid cid %q", root)
+			if err = s.spool.removePending(root); err != nil {
+				return err
+			}
+			continue
+		}
+		cids = append(cids, c)
 	}
-	downloader.wait()
-	if downloader.failed > 0 {
-		return nil, fmt.Errorf("file downloader failed %d times", downloader.failed)
+	if len(cids) == 0 {
+		return nil
+	}
+	files, err := s.retrieveFiles(ctx, epoch, cids)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	if err = s.applyTipSet(ctx, epoch, nil, files); err != nil {
+		return fmt.Errorf("failed to apply pending files at epoch %d: %v", epoch, err)
+	}
+	pending, err := s.spool.listPending()
+	if err != nil {
+		return err
+	}
+	for _, root := range sortedRoots(files) {
+		clog.Infof("applied pending file %s at epoch %d", root, epoch)
+		if err = s.spool.setPending(root, pendingFile{Epoch: pending[root].Epoch, Applied: epoch}); err != nil {
+			return err
+		}
+		if err = s.spool.remove(root); err != nil {
+			clog.Warningf("failed to remove file %s from the spool, error is: %v", root, err)
+		}
+	}
+	return nil
+}
+
+// addPending records the file as pending, so it's retried by later syncs.
+func (s *Listener) addPending(root string, epoch int64) {
+	if err := s.spool.addPending(root, epoch); err != nil {
+		clog.Errorf("failed to record pending file %s, error is: %v", root, err)
+	}
+}
+
+// revertDeals reverts deal changes and pending files starting from a given epoch.
+// Reverted deals are written with the next epoch, see commitEpoch.
+func (s *Listener) revertDeals(from int64) error {
+	s.deals.revert(from)
+	if err := s.spool.revertPending(from); err != nil {
+		return fmt.Errorf("failed to revert pending files: %v", err)
 	}
-	return downloader.result, nil
+	return nil
 }
 
-func (s *Listener) getTipSets(ctx context.Context, start, end int64) ([]*TipSet, error) {
-	from := EmptyTSK
-	tsl := list.New()
+// quarantine excludes the file from the sync.
+func (s *Listener) quarantine(root string, reason error) {
+	clog.Errorf("quarantining file %s, error is: %v", root, reason)
+	if err := s.spool.quarantine(root, reason); err != nil {
+		clog.Errorf("failed to quarantine file %s, error is: %v", root, err)
+	}
+}
+
+// applyTipSet applies deletions and quads of downloaded files in batches of at most batchSize deltas.
+// Files are streamed from the spool, files that cannot be parsed are quarantined.
+//
+// All batches are applied with the epoch, and the epoch is committed once all batches are applied.
+// Stores that keep undo logs revert partially applied epochs, other stores apply them again, see sync.
+func (s *Listener) applyTipSet(ctx context.Context, epoch int64, deletes []graph.Delta, files map[string]string) error {
+	ignore := graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true}
+
+	buf := make([]graph.Delta, 0, s.batchSize)
+	flush := func() error {
+		if len(buf) == 0 {
+			return nil
+		}
+		if err := s.feed.Apply(s.store, epoch, buf, ignore); err != nil {
+			return err
+		}
+		buf = buf[:0]
+		return nil
+	}
+	add := func(d graph.Delta) error {
+		buf = append(buf, d)
+		if len(buf) >= s.batchSize {
+			return flush()
+		}
+		return nil
+	}
+
+	// files of ended deals are deleted before adding new files, since the same file may be published again
+	for _, d := range deletes {
+		if err := add(d); err != nil {
+			return err
+		}
+	}
 
-	for start <= end {
+	for _, root := range sortedRoots(files) {
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
 		default:
 		}
+		// files are validated first, thus a broken file is never applied partially
+		report, err := s.readFile(root, files[root], nil)
+		if err != nil {
+			if _, ok := err.(*os.PathError); ok {
+				return err
+			}
+			s.quarantine(root, err)
+			delete(files, root)
+			continue
+		}
+		if len(report.Dropped) != 0 {
+			clog.Warningf("skipping %d malformed lines of file %s", len(report.Dropped), root)
+			if err = s.spool.saveReport(root, report); err != nil {
+				clog.Errorf("failed to save report of file %s, error is: %v", root, err)
+			}
+		}
+		if _, err = s.readFile(root, files[root], add); err != nil {
+			return err
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return s.commitEpoch(epoch, ignore)
+}
 
-		ts, err := s.client.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(end), from)
+// sortedRoots returns root cids of files in a sorted order.
+func sortedRoots(files map[string]string) []string {
+	roots := make([]string, 0, len(files))
+	for root := range files {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// readFile streams quads of the file to fn. If fn is nil, the file is only validated.
+// It returns the validation report of the file.
+func (s *Listener) readFile(root, path string, fn func(graph.Delta) error) (*epikquad.Report, error) {
+	if len(root) == 0 {
+		return nil, graph.ErrInvalidCid
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// the reader is not closed, since malformed lines are reported by the caller
+	qr := epikquad.NewReader(f, s.strict)
+	for {
+		q, err := qr.ReadQuad()
+		if err == io.EOF {
+			report := qr.Report()
+			return &report, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if fn == nil {
+			continue
+		}
+		err = fn(graph.Delta{
+			Cid:    root,
+			Quad:   q,
+			Action: graph.Add,
+		})
 		if err != nil {
-			clog.Errorf("failed to get tipset at epoch %d, error is: %v", end, err)
 			return nil, err
 		}
-		if int64(ts.Height) < start {
-			break
+	}
+}
+
+// getTipSets returns tipsets in the range of [start, end] on the chain with a given head, skipping null rounds.
+// Tipsets are fetched in parallel and checked to form a chain.
+func (s *Listener) getTipSets(ctx context.Context, head TipSetKey, start, end int64) ([]*TipSet, error) {
+	if start > end {
+		return nil, nil
+	}
+	tss := make([]*TipSet, end-start+1)
+	heights := make(chan int64)
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer close(heights)
+		for h := start; h <= end; h++ {
+			select {
+			case heights <- h:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
 		}
-		tsl.PushFront(ts)
-		from = ts.Key()
-		end = int64(ts.Height) - 1
+		return nil
+	})
+	for i := 0; i < s.fetchers; i++ {
+		g.Go(func() error {
+			for h := range heights {
+				ts, err := s.client.ChainGetTipSetByHeight(gctx, abi.ChainEpoch(h), head)
+				if err != nil {
+					clog.Errorf("failed to get tipset at epoch %d, error is: %v", h, err)
+					return err
+				}
+				// a tipset before the height is returned for null rounds
+				if int64(ts.Height) == h {
+					tss[h-start] = ts
+				}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	r := make([]*TipSet, 0, tsl.Len())
-	for e := tsl.Front(); e != nil; e.Next() {
-		r = append(r, e.Value.(*TipSet))
+	out := tss[:0]
+	for _, ts := range tss {
+		if ts == nil {
+			continue
+		}
+		if n := len(out); n != 0 && !bytes.Equal(ts.Parents().Bytes(), out[n-1].Key().Bytes()) {
+			clog.Errorf("unexpected parent of tipset %d", ts.Height)
+			return nil, errChainReorg
+		}
+		out = append(out, ts)
 	}
-	return r, nil
+	return out, nil
 }
 
-func (s *Listener) getTipSetMessages(ctx context.Context, ts *TipSet) ([]*Message, error) {
+// getTipSetMessages returns messages of all blocks in the tipset.
+// Messages included in multiple blocks are returned once.
+func (s *Listener) getTipSetMessages(ctx context.Context, ts *TipSet) ([]APIMessage, error) {
 	// get tipset messages
-	msgs := make([]*Message, 0, 100)
+	msgs := make([]APIMessage, 0, 100)
+	seen := make(map[cid.Cid]struct{})
 	for _, bcid := range ts.Cids {
 		bm, err := s.client.ChainGetBlockMessages(ctx, bcid)
 		if err != nil {
 			clog.Errorf("failed to get block messages at tipset %d, error is: %v", ts.Height, err)
 			return nil, err
 		}
-		for _, m := range bm.BlsMessages {
-			msgs = append(msgs, m)
+		// cids of bls messages go first
+		if len(bm.Cids) != len(bm.BlsMessages)+len(bm.SecpkMessages) {
+			return nil, fmt.Errorf("unexpected number of message cids in block %s", bcid)
 		}
-		for _, m := range bm.SecpkMessages {
-			msgs = append(msgs, &m.Message)
+		for i, c := range bm.Cids {
+			if _, ok := seen[c]; ok {
+				continue
+			}
+			seen[c] = struct{}{}
+			var m *Message
+			if i < len(bm.BlsMessages) {
+				m = bm.BlsMessages[i]
+			} else {
+				m = &bm.SecpkMessages[i-len(bm.BlsMessages)].Message
+			}
+			msgs = append(msgs, APIMessage{Cid: c, Message: m})
 		}
 	}
 	return msgs, nil
@@ -285,32 +908,3 @@ func parseDeletes(cids []string) ([]graph.Delta, error) {
 	}
 	return deltas, nil
 }
-
-// map value maybe empty for expiration
-func parseAdds(m map[string][]byte) ([]graph.Delta, error) {
-	deltas := make([]graph.Delta, 0, len(m))
-	for cid, data := range m {
-		if len(cid) == 0 {
-			return nil, graph.ErrInvalidCid
-		}
-		// load.go:83
-		qr := nquads.NewReader(bytes.NewReader(data), false)
-		for {
-			q, err := qr.ReadQuad()
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				qr.Close()
-				return nil, err
-			}
-			deltas = append(deltas, graph.Delta{
-				Cid:    cid,
-				Quad:   q,
-				Action: graph.Add,
-			})
-		}
-		qr.Close()
-	}
-	return deltas, nil
-}
@@ -0,0 +1,699 @@
+package epik
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/memstore"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+	"github.com/filecoin-project/specs-actors/actors/crypto"
+	"github.com/filecoin-project/specs-actors/actors/runtime/exitcode"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// testChain is a fake chain without any messages.
+// Executed messages and market deals can be set for tests of storage deals.
+type testChain struct {
+	tipsets map[abi.ChainEpoch]*TipSet
+	head    *TipSet
+
+	lookups map[cid.Cid]*MsgLookup
+	deals   map[abi.DealID]*MarketDeal
+	ids     map[address.Address]address.Address
+	files   map[cid.Cid]FileResp
+	// dealsAt is the key of the tipset market state was last requested at
+	dealsAt TipSetKey
+}
+
+func blockCid(t testing.TB, s string) cid.Cid {
+	c, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: multihash.BLAKE2B_MIN + 31}.Sum([]byte(s))
+	require.NoError(t, err)
+	return c
+}
+
+// newTestChain creates a chain with tipsets at given heights.
+// Fork name is used to make block cids unique across forks.
+func newTestChain(t testing.TB, fork string, heights ...abi.ChainEpoch) *testChain {
+	c := &testChain{tipsets: make(map[abi.ChainEpoch]*TipSet)}
+	var parents []cid.Cid
+	for _, h := range heights {
+		b := blockCid(t, fmt.Sprintf("%s-%d", fork, h))
+		ts := &TipSet{
+			Cids:   []cid.Cid{b},
+			Blocks: []*BlockHeader{{Parents: parents, Height: h}},
+			Height: h,
+		}
+		c.tipsets[h] = ts
+		c.head = ts
+		parents = ts.Cids
+	}
+	return c
+}
+
+// extend adds tipsets from another chain, starting at a given height.
+func (c *testChain) extend(o *testChain, from abi.ChainEpoch) *testChain {
+	out := &testChain{tipsets: make(map[abi.ChainEpoch]*TipSet), head: o.head}
+	for h, ts := range c.tipsets {
+		if h < from {
+			out.tipsets[h] = ts
+		}
+	}
+	for h, ts := range o.tipsets {
+		if h >= from {
+			out.tipsets[h] = ts
+		}
+	}
+	return out
+}
+
+func (c *testChain) ChainHead(context.Context) (*TipSet, error) {
+	return c.head, nil
+}
+
+func (c *testChain) ChainGetTipSetByHeight(_ context.Context, h abi.ChainEpoch, _ TipSetKey) (*TipSet, error) {
+	for ; h >= 0; h-- {
+		if ts, ok := c.tipsets[h]; ok {
+			return ts, nil
+		}
+	}
+	return nil, fmt.Errorf("no tipset at %d", h)
+}
+
+func (c *testChain) ChainGetBlockMessages(context.Context, cid.Cid) (*BlockMessages, error) {
+	return &BlockMessages{}, nil
+}
+
+func (c *testChain) ClientQuery(_ context.Context, roots []cid.Cid) ([]FileResp, error) {
+	var out []FileResp
+	for _, root := range roots {
+		if fd, ok := c.files[root]; ok {
+			out = append(out, fd)
+		}
+	}
+	return out, nil
+}
+
+func (c *testChain) ChainGetParentMessages(context.Context, cid.Cid) ([]APIMessage, error) {
+	return nil, nil
+}
+
+func (c *testChain) ChainGetMessage(context.Context, cid.Cid) (*Message, error) {
+	return nil, fmt.Errorf("not found")
+}
+
+func (c *testChain) StateSearchMsg(_ context.Context, msg cid.Cid) (*MsgLookup, error) {
+	return c.lookups[msg], nil
+}
+
+func (c *testChain) StateMarketStorageDeal(_ context.Context, id abi.DealID, key TipSetKey) (*MarketDeal, error) {
+	c.dealsAt = key
+	d, ok := c.deals[id]
+	if !ok {
+		return nil, errDealNotFound
+	}
+	return d, nil
+}
+
+func (c *testChain) StateLookupID(_ context.Context, addr address.Address, _ TipSetKey) (address.Address, error) {
+	if addr.Protocol() == address.ID {
+		return addr, nil
+	}
+	id, ok := c.ids[addr]
+	if !ok {
+		return address.Undef, fmt.Errorf("actor %s not found", addr)
+	}
+	return id, nil
+}
+
+func TestListenerReorg(t *testing.T) {
+	ctx := context.TODO()
+	qs := memstore.New()
+	l, err := newListener(qs)
+	require.NoError(t, err)
+	l.spool = newTestSpool(t)
+	feed := graph.NewFeed(10)
+	l.SetFeed(feed)
+
+	chain := newTestChain(t, "a", 1, 2, 4, 5, 6)
+	l.client = chain
+	require.NoError(t, l.sync(ctx))
+
+	st, err := qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), st.Epoch)
+	for _, h := range []abi.ChainEpoch{1, 2, 4, 5} {
+		key, err := qs.EpochKey(ctx, int64(h))
+		require.NoError(t, err)
+		require.Equal(t, chain.tipsets[h].Key().Bytes(), key)
+	}
+	_, err = qs.EpochKey(ctx, 3)
+	require.Equal(t, graph.ErrEpochNotExist, err)
+
+	q := quad.MakeIRI("a", "b", "c", "")
+	err = qs.ApplyDeltas(5, []graph.Delta{{Cid: "deal", Quad: q, Action: graph.Add}}, graph.IgnoreOpts{})
+	require.NoError(t, err)
+
+	// no changes on the chain
+	require.NoError(t, l.sync(ctx))
+	st, err = qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), st.Epoch)
+	require.Equal(t, int64(1), st.Quads.Value)
+
+	// fork after epoch 2, null round at 3 is replaced with a tipset
+	fork := newTestChain(t, "b", 3, 4, 5, 6, 7, 8)
+	fork.tipsets[3].Blocks[0].Parents = chain.tipsets[2].Cids
+	chain = chain.extend(fork, 3)
+	l.client = chain
+	require.NoError(t, l.sync(ctx))
+
+	st, err = qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), st.Epoch)
+	require.Equal(t, int64(0), st.Quads.Value, "quad from reverted epoch")
+	require.Equal(t, uint64(1), feed.Seq())
+	sub, err := feed.Subscribe(feed.ID(), 1)
+	require.NoError(t, err)
+	require.True(t, sub.Next(ctx))
+	require.Equal(t, graph.FeedEvent{Feed: feed.ID(), Seq: 1, Epoch: 3, Revert: true}, sub.Result())
+	for _, h := range []abi.ChainEpoch{1, 2, 3, 4, 5, 6, 7} {
+		key, err := qs.EpochKey(ctx, int64(h))
+		require.NoError(t, err)
+		require.Equal(t, chain.tipsets[h].Key().Bytes(), key, "epoch %d", h)
+	}
+}
+
+func TestListenerConfirmations(t *testing.T) {
+	ctx := context.TODO()
+	qs := memstore.New()
+	l, err := newListener(qs)
+	require.NoError(t, err)
+	l.spool = newTestSpool(t)
+	require.Equal(t, int64(defaultConfirmations), l.confirmations)
+	l.confirmations = 3
+
+	chain := newTestChain(t, "a", 1, 2, 3, 4, 5, 6)
+	l.client = chain
+	require.NoError(t, l.sync(ctx))
+	st, err := qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), st.Epoch)
+
+	// tipsets that are not confirmed yet are not applied, even if requested explicitly
+	require.Error(t, l.SyncRange(ctx, 4, 6, graph.SyncRangeOptions{}))
+	st, err = qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), st.Epoch)
+
+	chain = newTestChain(t, "a", 1, 2, 3, 4, 5, 6, 7)
+	l.client = chain
+	require.NoError(t, l.sync(ctx))
+	st, err = qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), st.Epoch)
+}
+
+func TestListenerParentMismatch(t *testing.T) {
+	ctx := context.TODO()
+	qs := memstore.New()
+	l, err := newListener(qs)
+	require.NoError(t, err)
+	l.spool = newTestSpool(t)
+
+	chain := newTestChain(t, "a", 1, 2, 3)
+	l.client = chain
+	require.NoError(t, l.sync(ctx))
+
+	// tipset at epoch 4 points to an unknown parent
+	chain.tipsets[4] = &TipSet{
+		Cids:   []cid.Cid{blockCid(t, "b-4")},
+		Blocks: []*BlockHeader{{Parents: []cid.Cid{blockCid(t, "b-3")}, Height: 4}},
+		Height: 4,
+	}
+	chain.head = &TipSet{Height: 5}
+	err = l.syncDeltas(ctx, chain.head, chain.tipsets[2].Key().Bytes(), 4, 4, nil)
+	require.Equal(t, errChainReorg, err)
+}
+
+func newDealProposal(provider address.Address, start, end abi.ChainEpoch) market.ClientDealProposal {
+	return market.ClientDealProposal{
+		Proposal: market.DealProposal{
+			Provider:             provider,
+			Client:               provider,
+			StartEpoch:           start,
+			EndEpoch:             end,
+			StoragePricePerEpoch: abi.NewTokenAmount(0),
+			ProviderCollateral:   abi.NewTokenAmount(0),
+			ClientCollateral:     abi.NewTokenAmount(0),
+		},
+		ClientSignature: crypto.Signature{Type: crypto.SigTypeBLS},
+	}
+}
+
+// publish adds an executed message that publishes deals with given ids.
+func (c *testChain) publish(t testing.TB, root cid.Cid, code exitcode.ExitCode, ids []abi.DealID, deals ...market.ClientDealProposal) APIMessage {
+	for i := range deals {
+		deals[i].Proposal.PieceCID = root
+	}
+	params := market.PublishStorageDealsParams{Deals: deals, RootCID: root}
+	buf := new(bytes.Buffer)
+	require.NoError(t, params.MarshalCBOR(buf))
+	msg := APIMessage{
+		Cid: blockCid(t, "msg-"+root.String()),
+		Message: &Message{
+			To:     builtin.StorageMarketActorAddr,
+			Method: builtin.MethodsMarket.PublishStorageDeals,
+			Params: buf.Bytes(),
+		},
+	}
+	ret := market.PublishStorageDealsReturn{IDs: ids}
+	buf = new(bytes.Buffer)
+	require.NoError(t, ret.MarshalCBOR(buf))
+	if c.lookups == nil {
+		c.lookups = make(map[cid.Cid]*MsgLookup)
+	}
+	c.lookups[msg.Cid] = &MsgLookup{Message: msg.Cid, Receipt: MessageReceipt{ExitCode: code, Return: buf.Bytes()}}
+	return msg
+}
+
+func TestListenerDeals(t *testing.T) {
+	ctx := context.TODO()
+	l, err := newListener(memstore.New())
+	require.NoError(t, err)
+	l.spool = newTestSpool(t)
+	chain := newTestChain(t, "a", 1, 2, 3, 4, 5)
+	l.client = chain
+
+	provider, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	// deals may be proposed with a robust address of the provider
+	robust, err := address.NewActorAddress([]byte("provider"))
+	require.NoError(t, err)
+	chain.ids = map[address.Address]address.Address{robust: provider}
+	rootA, rootB, rootC := blockCid(t, "file-a"), blockCid(t, "file-b"), blockCid(t, "file-c")
+
+	msgs := []APIMessage{
+		chain.publish(t, rootA, exitcode.Ok, []abi.DealID{1, 2},
+			newDealProposal(provider, 3, 4),
+			newDealProposal(robust, 3, 10),
+		),
+		chain.publish(t, rootB, exitcode.Ok, []abi.DealID{3}, newDealProposal(provider, 3, 10)),
+		chain.publish(t, rootC, exitcode.ErrIllegalArgument, nil, newDealProposal(provider, 3, 10)),
+	}
+	cids, err := l.publishDeals(ctx, chain.tipsets[2], msgs)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{rootA, rootB}, cids)
+	require.Len(t, l.deals.live(), 3)
+
+	chain.deals = map[abi.DealID]*MarketDeal{
+		1: {State: market.DealState{SectorStartEpoch: 3, SlashEpoch: -1}},
+		2: {State: market.DealState{SectorStartEpoch: 3, SlashEpoch: -1}},
+		3: {State: market.DealState{SectorStartEpoch: -1, SlashEpoch: -1}},
+	}
+
+	// deal of the second file was not activated
+	ended, err := l.endDeals(ctx, chain.tipsets[3], nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{rootB.String()}, ended)
+	require.Equal(t, chain.tipsets[3].Key(), chain.dealsAt)
+
+	// the first file is still stored by another deal
+	ended, err = l.endDeals(ctx, chain.tipsets[4], nil)
+	require.NoError(t, err)
+	require.Empty(t, ended)
+
+	// sectors of the provider are terminated
+	chain.deals[2].State.SlashEpoch = 5
+	terminate := APIMessage{
+		Cid:     blockCid(t, "msg-terminate"),
+		Message: &Message{To: provider, Method: builtin.MethodsMiner.TerminateSectors},
+	}
+	ended, err = l.endDeals(ctx, chain.tipsets[5], []APIMessage{terminate})
+	require.NoError(t, err)
+	require.Equal(t, []string{rootA.String()}, ended)
+	require.Empty(t, l.deals.live())
+
+	deltas, err := parseDeletes(ended)
+	require.NoError(t, err)
+	require.Equal(t, []graph.Delta{{Cid: rootA.String(), Action: graph.Delete}}, deltas)
+
+	// reorg restores deals that ended in reverted epochs, together with their original end
+	l.deals.revert(4)
+	require.True(t, l.deals.stored(rootA.String()))
+	require.False(t, l.deals.stored(rootB.String()))
+	require.Equal(t, int64(10), l.deals.deals[2].End)
+	require.Equal(t, int64(3), l.deals.deals[3].End)
+	l.deals.revert(2)
+	require.Empty(t, l.deals.deals)
+}
+
+func TestDealTrackerSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deals")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "deals.json")
+
+	data, err := readDealsFile(path)
+	require.NoError(t, err)
+	require.Nil(t, data)
+
+	tr := newDealTracker(path)
+	tr.add(&deal{ID: 1, Root: "a", End: 10, Epoch: 2})
+	tr.add(&deal{ID: 2, Root: "b", End: 10, Epoch: 2})
+	tr.end(tr.deals[2], 5)
+	require.NoError(t, tr.save())
+	require.False(t, tr.stored("b"))
+
+	data, err = readDealsFile(path)
+	require.NoError(t, err)
+	tr2 := newDealTracker("")
+	require.NoError(t, tr2.decode(data))
+	require.Equal(t, tr.deals, tr2.deals)
+	require.Equal(t, tr.roots, tr2.roots)
+
+	tr2.prune(6)
+	require.Len(t, tr2.deals, 1)
+	require.True(t, tr2.stored("a"))
+}
+
+func TestListenerDealsMeta(t *testing.T) {
+	ctx := context.TODO()
+	qs := memstore.New()
+	l, err := newListener(qs)
+	require.NoError(t, err)
+	chain := newTestChain(t, "a", 1, 2, 3)
+	l.client = chain
+	l.spool = newTestSpool(t)
+
+	provider, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	root := blockCid(t, "file-a")
+	msg := chain.publish(t, root, exitcode.Ok, []abi.DealID{1}, newDealProposal(provider, 3, 10))
+	_, err = l.publishDeals(ctx, chain.tipsets[2], []APIMessage{msg})
+	require.NoError(t, err)
+
+	// deals are written to the store together with the epoch
+	meta, err := qs.Meta(ctx, metaDeals)
+	require.NoError(t, err)
+	require.Nil(t, meta)
+	require.NoError(t, l.applyTipSet(ctx, 2, nil, nil))
+	meta, err = qs.Meta(ctx, metaDeals)
+	require.NoError(t, err)
+	require.NotNil(t, meta)
+	require.False(t, l.deals.dirty)
+
+	l2, err := newListener(qs)
+	require.NoError(t, err)
+	require.True(t, l2.deals.stored(root.String()))
+
+	// deals of epochs that were not committed are reverted when loaded
+	require.NoError(t, qs.RevertEpochs(ctx, 2))
+	l2, err = newListener(qs)
+	require.NoError(t, err)
+	require.Empty(t, l2.deals.deals)
+}
+
+// fixtureOptions returns options of a listener that reads the chain from testdata.
+func fixtureOptions(t *testing.T) graph.Options {
+	dir, err := ioutil.TempDir("", "db")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return graph.Options{OptFixtures: "testdata/chain", graph.OptListenerDatabase: dir}
+}
+
+func TestListenerSpoolDir(t *testing.T) {
+	qs := memstore.New()
+	_, err := graph.NewListener(ListenerType, qs, graph.Options{OptFixtures: "testdata/chain"})
+	require.Error(t, err)
+
+	opts := fixtureOptions(t)
+	gl, err := graph.NewListener(ListenerType, qs, opts)
+	require.NoError(t, err)
+	l := gl.(*Listener)
+	defer l.closeClient()
+	require.Equal(t, filepath.Join(opts[graph.OptListenerDatabase].(string), "epik-spool"), l.spool.dir)
+	require.DirExists(t, l.spool.pendingDir())
+}
+
+func TestListenerFixtures(t *testing.T) {
+	ctx := context.TODO()
+	qs := memstore.New()
+	gl, err := graph.NewListener(ListenerType, qs, fixtureOptions(t))
+	require.NoError(t, err)
+	l := gl.(*Listener)
+	defer l.closeClient()
+	l.pollInterval = time.Millisecond
+	l.spool = newTestSpool(t)
+
+	require.NoError(t, l.sync(ctx))
+
+	st, err := qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), st.Epoch)
+	require.Equal(t, int64(3), st.Quads.Value)
+
+	root := blockCid(t, "file-1").String()
+	refs, err := qs.QuadsByCid(ctx, root)
+	require.NoError(t, err)
+	require.Len(t, refs, 3)
+	require.Equal(t, quad.MakeIRI("alice", "follows", "bob", ""), qs.Quad(refs[0]))
+
+	live := l.deals.live()
+	require.Len(t, live, 1)
+	require.Equal(t, root, live[0].Root)
+	require.True(t, live[0].Active)
+}
+
+// failingStore fails to apply deltas after a given number of calls.
+type failingStore struct {
+	*memstore.QuadStore
+	calls, failAt int
+}
+
+func (s *failingStore) ApplyDeltas(epoch int64, in []graph.Delta, opts graph.IgnoreOpts) error {
+	s.calls++
+	if s.calls == s.failAt {
+		return fmt.Errorf("apply failed")
+	}
+	return s.QuadStore.ApplyDeltas(epoch, in, opts)
+}
+
+func (s *failingStore) ApplyDeltasMeta(epoch int64, in []graph.Delta, opts graph.IgnoreOpts, meta map[string][]byte) error {
+	s.calls++
+	if s.calls == s.failAt {
+		return fmt.Errorf("apply failed")
+	}
+	return s.QuadStore.ApplyDeltasMeta(epoch, in, opts, meta)
+}
+
+func TestListenerInterruptedEpoch(t *testing.T) {
+	ctx := context.TODO()
+	qs := &failingStore{QuadStore: memstore.New(), failAt: 3}
+	gl, err := graph.NewListener(ListenerType, qs, fixtureOptions(t))
+	require.NoError(t, err)
+	l := gl.(*Listener)
+	defer l.closeClient()
+	l.pollInterval = time.Millisecond
+	l.spool = newTestSpool(t)
+	l.batchSize = 1
+
+	// epoch 1 and the first batch of epoch 2 are applied
+	require.Error(t, l.sync(ctx))
+	st, err := qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), st.Epoch)
+	require.Equal(t, int64(1), st.Quads.Value)
+	_, err = qs.EpochKey(ctx, 2)
+	require.Equal(t, graph.ErrEpochNotExist, err)
+
+	// the interrupted epoch is reverted and applied again
+	require.NoError(t, l.sync(ctx))
+	st, err = qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), st.Epoch)
+	require.Equal(t, int64(3), st.Quads.Value)
+	_, err = qs.EpochKey(ctx, 2)
+	require.NoError(t, err)
+}
+
+// plainStore hides optional interfaces of the store, such as EpochStore.
+type plainStore struct {
+	graph.QuadStore
+}
+
+func TestListenerInterruptedEpochPlain(t *testing.T) {
+	ctx := context.TODO()
+	fs := &failingStore{QuadStore: memstore.New(), failAt: 3}
+	qs := plainStore{fs}
+	gl, err := graph.NewListener(ListenerType, qs, fixtureOptions(t))
+	require.NoError(t, err)
+	l := gl.(*Listener)
+	defer l.closeClient()
+	l.pollInterval = time.Millisecond
+	l.spool = newTestSpool(t)
+	l.batchSize = 1
+
+	// the epoch is set with the first batch of epoch 2
+	require.Error(t, l.sync(ctx))
+	st, err := qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), st.Epoch)
+	require.Equal(t, int64(1), st.Quads.Value)
+
+	// the interrupted epoch is applied again
+	require.NoError(t, l.sync(ctx))
+	st, err = qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), st.Epoch)
+	require.Equal(t, int64(3), st.Quads.Value)
+	require.Equal(t, int64(4), l.committed)
+}
+
+func TestListenerStatus(t *testing.T) {
+	ctx := context.TODO()
+	qs := memstore.New()
+	gl, err := graph.NewListener(ListenerType, qs, fixtureOptions(t))
+	require.NoError(t, err)
+	l := gl.(*Listener)
+	defer l.closeClient()
+	l.pollInterval = time.Millisecond
+	l.spool = newTestSpool(t)
+
+	require.NoError(t, l.sync(ctx))
+	st := l.Status()
+	require.Equal(t, int64(4), st.Epoch)
+	require.Equal(t, int64(5), st.Head)
+	require.Equal(t, int64(1), st.Lag)
+	require.Empty(t, st.InFlight)
+
+	l.Pause()
+	require.True(t, l.Status().Paused)
+	l.Resume()
+	require.False(t, l.Status().Paused)
+
+	// epochs are reverted and applied again
+	require.Error(t, l.Resync(0))
+	require.NoError(t, l.Resync(2))
+	require.Equal(t, int64(2), l.resync)
+	require.NoError(t, qs.ApplyDeltas(4, []graph.Delta{{Cid: "x", Quad: quad.MakeIRI("a", "b", "c", ""), Action: graph.Add}}, graph.IgnoreOpts{}))
+	require.NoError(t, l.sync(ctx))
+	require.Zero(t, l.resync)
+
+	stats, err := qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), stats.Epoch)
+	require.Equal(t, int64(3), stats.Quads.Value, "quad of the reverted epoch")
+	require.Len(t, l.deals.live(), 1)
+}
+
+func TestListenerSyncRange(t *testing.T) {
+	ctx := context.TODO()
+	qs := memstore.New()
+	gl, err := graph.NewListener(ListenerType, qs, fixtureOptions(t))
+	require.NoError(t, err)
+	l := gl.(*Listener)
+	defer l.Stop()
+	l.pollInterval = time.Millisecond
+	l.spool = newTestSpool(t)
+	l.fetchers = 2
+
+	root := blockCid(t, "file-1").String()
+	var progress []graph.SyncProgress
+	opts := graph.SyncRangeOptions{Progress: func(p graph.SyncProgress) {
+		progress = append(progress, p)
+	}}
+
+	// dry run does not change the store
+	opts.DryRun = true
+	require.NoError(t, l.SyncRange(ctx, 0, 0, opts))
+	require.Equal(t, []graph.SyncProgress{
+		{Epoch: 1, Done: 1, Total: 3, Roots: []string{}},
+		{Epoch: 2, Done: 2, Total: 3, Roots: []string{root}},
+		{Epoch: 4, Done: 3, Total: 3, Roots: []string{}},
+	}, progress)
+	st, err := qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), st.Epoch)
+	require.Empty(t, l.deals.live())
+
+	progress = nil
+	opts.DryRun = false
+	require.NoError(t, l.SyncRange(ctx, 1, 2, opts))
+	require.Len(t, progress, 2)
+	require.Equal(t, []string{root}, progress[1].Roots)
+	st, err = qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), st.Epoch)
+	require.Equal(t, int64(3), st.Quads.Value)
+
+	// applied epochs are synced again
+	progress = nil
+	require.NoError(t, l.SyncRange(ctx, 2, 0, opts))
+	require.Len(t, progress, 2)
+	st, err = qs.Stats(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), st.Epoch)
+	require.Equal(t, int64(3), st.Quads.Value)
+	require.Len(t, l.deals.live(), 1)
+
+	require.Error(t, l.SyncRange(ctx, 5, 0, opts))
+}
+
+func TestListenerLock(t *testing.T) {
+	ctx := context.TODO()
+	qs := memstore.New()
+	newLockListener := func(owner string) *Listener {
+		gl, err := graph.NewListener(ListenerType, qs, fixtureOptions(t))
+		require.NoError(t, err)
+		l := gl.(*Listener)
+		t.Cleanup(l.Stop)
+		l.pollInterval = time.Millisecond
+		l.spool = newTestSpool(t)
+		l.owner = owner
+		return l
+	}
+	l1, l2 := newLockListener("a"), newLockListener("b")
+
+	lctx, err := l1.lead(ctx)
+	require.NoError(t, err)
+	require.True(t, l1.leading())
+	_, err = l2.lead(ctx)
+	require.Equal(t, graph.ErrLocked, err)
+	require.True(t, l2.Status().Standby)
+	require.EqualError(t, l2.SyncRange(ctx, 0, 0, graph.SyncRangeOptions{}), "the store is synced by another process")
+
+	// the lock is released on resign and the context of the leader is cancelled
+	l1.resign()
+	require.Error(t, lctx.Err())
+	require.NoError(t, l2.SyncRange(ctx, 0, 0, graph.SyncRangeOptions{}))
+	require.False(t, l2.Status().Standby)
+	require.False(t, l2.leading(), "lock is released after the range sync")
+
+	_, err = l1.lead(ctx)
+	require.NoError(t, err)
+	l1.resign()
+
+	// the role taken in the foreground is released when the listener is stopped
+	gl, err := graph.NewListener(ListenerType, qs, fixtureOptions(t))
+	require.NoError(t, err)
+	l3 := gl.(*Listener)
+	l3.spool = newTestSpool(t)
+	l3.owner = "c"
+	require.NoError(t, l3.Lead(ctx))
+	require.Equal(t, graph.ErrLocked, l2.Lead(ctx))
+	l3.Stop()
+	require.NoError(t, l2.Lead(ctx))
+}
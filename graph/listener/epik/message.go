@@ -4,7 +4,9 @@ import (
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/specs-actors/actors/abi"
 	big2 "github.com/filecoin-project/specs-actors/actors/abi/big"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
 	"github.com/filecoin-project/specs-actors/actors/crypto"
+	"github.com/filecoin-project/specs-actors/actors/runtime/exitcode"
 	"github.com/ipfs/go-cid"
 )
 
@@ -43,3 +45,23 @@ type BlockMessages struct {
 
 	Cids []cid.Cid
 }
+
+type MessageReceipt struct {
+	ExitCode exitcode.ExitCode
+	Return   []byte
+	GasUsed  int64
+}
+
+// MsgLookup is the result of a search for an executed message.
+type MsgLookup struct {
+	Message cid.Cid
+	Receipt MessageReceipt
+	TipSet  TipSetKey
+	Height  abi.ChainEpoch
+}
+
+// MarketDeal is a storage deal as recorded in the market actor state.
+type MarketDeal struct {
+	Proposal market.DealProposal
+	State    market.DealState
+}
@@ -0,0 +1,194 @@
+package epik
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/epik-protocol/epik-gateway-backend/clog"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	mEpoch = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_listener_epoch",
+		Help: "Last epoch applied to the store.",
+	})
+	mHead = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_listener_head",
+		Help: "Chain head seen by the last sync.",
+	})
+	mLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_listener_lag",
+		Help: "Number of epochs the store is behind the chain head.",
+	})
+	mInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_listener_in_flight",
+		Help: "Number of files being retrieved.",
+	})
+	mPaused = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_listener_paused",
+		Help: "Set to 1 if the sync is paused.",
+	})
+	mLastSync = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_listener_last_sync_timestamp_seconds",
+		Help: "Time of the last successful sync.",
+	})
+	mSyncErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_listener_sync_errors_count",
+		Help: "Number of failed syncs.",
+	})
+)
+
+var _ graph.Listener = (*Listener)(nil)
+
+func (s *Listener) Status() graph.ListenerStatus {
+	s.mu.Lock()
+	st := s.status
+	st.InFlight = append([]string{}, st.InFlight...)
+	s.mu.Unlock()
+	pending, err := s.spool.pendingRoots()
+	if err != nil {
+		clog.Warningf("failed to list pending files: %v", err)
+	}
+	st.Pending = pending
+	return st
+}
+
+func (s *Listener) Pause() {
+	s.mu.Lock()
+	s.status.Paused = true
+	s.mu.Unlock()
+	mPaused.Set(1)
+	clog.Infof("epik syncer paused")
+}
+
+func (s *Listener) Resume() {
+	s.mu.Lock()
+	s.status.Paused = false
+	s.mu.Unlock()
+	mPaused.Set(0)
+	clog.Infof("epik syncer resumed")
+	s.triggerSync()
+}
+
+func (s *Listener) Resync(from int64) error {
+	if from <= 0 {
+		return fmt.Errorf("invalid epoch to resync from: %d", from)
+	}
+	s.mu.Lock()
+	s.resync = from
+	s.mu.Unlock()
+	clog.Infof("epik syncer will resync from epoch %d", from)
+	s.triggerSync()
+	return nil
+}
+
+// triggerSync starts a sync without waiting for the ticker.
+func (s *Listener) triggerSync() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Listener) paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status.Paused
+}
+
+// takeResync returns the epoch requested by Resync and resets it, or zero if no resync was requested.
+func (s *Listener) takeResync() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	from := s.resync
+	s.resync = 0
+	return from
+}
+
+// revertEpochs reverts epochs of the store starting from a given one, and publishes the revert to the feed.
+func (s *Listener) revertEpochs(ctx context.Context, es graph.EpochStore, from int64) error {
+	return s.feed.Revert(from, func() error {
+		return es.RevertEpochs(ctx, from)
+	})
+}
+
+// revertFrom reverts epochs starting from a given one.
+// Stores without undo logs only move their epoch back, the data is then applied again on top of the existing one.
+func (s *Listener) revertFrom(ctx context.Context, from int64) error {
+	es, ok := s.store.(graph.EpochStore)
+	if !ok && from <= 1 {
+		return fmt.Errorf("cannot resync %T from the first epoch", s.store)
+	}
+	if ok {
+		if err := s.revertEpochs(ctx, es, from); err != nil {
+			return fmt.Errorf("failed to revert epochs from %d: %v", from, err)
+		}
+	}
+	if err := s.revertDeals(from); err != nil {
+		return err
+	}
+	if !ok {
+		if err := s.commitEpoch(from-1, graph.IgnoreOpts{}); err != nil {
+			return err
+		}
+	}
+	s.setEpoch(from - 1)
+	return nil
+}
+
+func (s *Listener) setEpoch(epoch int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Epoch = epoch
+	s.updateLag()
+	mEpoch.Set(float64(epoch))
+}
+
+func (s *Listener) setHead(head int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Head = head
+	s.updateLag()
+	mHead.Set(float64(head))
+}
+
+// updateLag must be called with the lock held.
+func (s *Listener) updateLag() {
+	s.status.Lag = 0
+	if s.status.Head > s.status.Epoch {
+		s.status.Lag = s.status.Head - s.status.Epoch
+	}
+	mLag.Set(float64(s.status.Lag))
+}
+
+func (s *Listener) setInFlight(roots []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.InFlight = roots
+	mInFlight.Set(float64(len(roots)))
+}
+
+func (s *Listener) syncStarted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Syncing = true
+}
+
+func (s *Listener) syncDone(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Syncing = false
+	now := time.Now()
+	if err != nil {
+		s.status.LastError = err.Error()
+		s.status.LastErrorTime = now
+		mSyncErrors.Inc()
+		return
+	}
+	s.status.LastSync = now
+	mLastSync.Set(float64(now.Unix()))
+}
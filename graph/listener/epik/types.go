@@ -22,8 +22,15 @@ func init() {
 	blockHeaderCIDLen = len(c.Bytes())
 }
 
+// BlockHeader contains the subset of block header fields used by the listener.
+type BlockHeader struct {
+	Parents []cid.Cid
+	Height  abi.ChainEpoch
+}
+
 type TipSet struct {
 	Cids   []cid.Cid
+	Blocks []*BlockHeader
 	Height abi.ChainEpoch
 }
 
@@ -34,6 +41,15 @@ func (ts *TipSet) Key() TipSetKey {
 	return NewTipSetKey(ts.Cids...)
 }
 
+// Parents returns the key of the parent tipset.
+// All blocks of the tipset share the same parents.
+func (ts *TipSet) Parents() TipSetKey {
+	if ts == nil || len(ts.Blocks) == 0 {
+		return EmptyTSK
+	}
+	return NewTipSetKey(ts.Blocks[0].Parents...)
+}
+
 type TipSetKey struct {
 	// The empty key has value "".
 	value string
@@ -44,6 +60,20 @@ func NewTipSetKey(cids ...cid.Cid) TipSetKey {
 	return TipSetKey{string(encoded)}
 }
 
+// TipSetKeyFromBytes wraps an encoded key, validating correct decoding.
+func TipSetKeyFromBytes(encoded []byte) (TipSetKey, error) {
+	_, err := decodeKey(encoded)
+	if err != nil {
+		return EmptyTSK, err
+	}
+	return TipSetKey{string(encoded)}, nil
+}
+
+// Bytes returns a binary representation of the key.
+func (k TipSetKey) Bytes() []byte {
+	return []byte(k.value)
+}
+
 func (k TipSetKey) MarshalJSON() ([]byte, error) {
 	return json.Marshal(k.Cids())
 }
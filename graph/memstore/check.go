@@ -0,0 +1,199 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+)
+
+var _ graph.Checker = (*QuadStore)(nil)
+
+// Check implements graph.Checker.
+//
+// Primitives are the source of truth. The value and quad maps, direction indexes, the list of all primitives
+// and reference counters of nodes are compared with the ones built from primitives, and cid references must
+// point to quads. Repair rebuilds all of them from primitives.
+func (qs *QuadStore) Check(ctx context.Context, opts graph.CheckOptions) (*graph.CheckReport, error) {
+	rep := &graph.CheckReport{}
+	repairable := false
+	report := func(kind string, id int64, fix bool, format string, args ...interface{}) {
+		rep.Problems = append(rep.Problems, graph.Inconsistency{
+			Kind: kind, ID: uint64(id), Msg: fmt.Sprintf(format, args...),
+		})
+		repairable = repairable || fix
+	}
+	ids := make([]int64, 0, len(qs.prim))
+	for id := range qs.prim {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	vals := make(map[string]int64)
+	quads := make(map[internalQuad]int64)
+	nrefs := make(map[int64]int)
+	for _, id := range ids {
+		p := qs.prim[id]
+		if p.ID != id {
+			report(graph.CheckLog, id, false, "primitive is stored with a wrong id %d", p.ID)
+			continue
+		}
+		if p.Quad.Zero() {
+			rep.Nodes++
+			if p.Value != nil {
+				vals[p.Value.String()] = id
+			}
+			continue
+		}
+		rep.Quads++
+		if prev, ok := quads[p.Quad]; ok {
+			report(graph.CheckLog, id, false, "quad is the same as quad %d", prev)
+		}
+		quads[p.Quad] = id
+		for dir := quad.Subject; dir <= quad.Label; dir++ {
+			vid := p.Quad.Dir(dir)
+			if vid == 0 {
+				continue
+			}
+			if n := qs.prim[vid]; n == nil || !n.Quad.Zero() {
+				report(graph.CheckLog, id, false, "quad references a missing node %d", vid)
+				continue
+			}
+			nrefs[vid]++
+		}
+	}
+
+	if len(qs.all) != len(qs.prim) {
+		report(graph.CheckIndex, 0, true, "list of all primitives has %d entries instead of %d", len(qs.all), len(qs.prim))
+	}
+	for _, p := range qs.all {
+		if qs.prim[p.ID] != p {
+			report(graph.CheckIndex, p.ID, true, "list of all primitives contains a missing primitive")
+		}
+	}
+	for _, id := range ids {
+		p := qs.prim[id]
+		if p.Value == nil {
+			continue
+		}
+		if got, ok := qs.vals[p.Value.String()]; !ok {
+			report(graph.CheckValue, id, true, "value index entry is missing")
+		} else if got != vals[p.Value.String()] {
+			report(graph.CheckValue, id, true, "value index references node %d", got)
+		}
+	}
+	for vs, id := range qs.vals {
+		if _, ok := vals[vs]; !ok {
+			report(graph.CheckValue, id, true, "value index entry references no existing node")
+		}
+	}
+	for q, id := range qs.quads {
+		if exp, ok := quads[q]; !ok || exp != id {
+			report(graph.CheckIndex, id, true, "quad index entry references a wrong quad")
+		}
+	}
+	for q, id := range quads {
+		if _, ok := qs.quads[q]; !ok {
+			report(graph.CheckIndex, id, true, "quad index entry is missing")
+		}
+		p := qs.prim[id]
+		for dir := quad.Subject; dir <= quad.Label; dir++ {
+			vid := q.Dir(dir)
+			if vid == 0 {
+				continue
+			}
+			t, ok := qs.index.Get(dir, vid)
+			if !ok {
+				report(graph.CheckIndex, id, true, "%v index of node %d is missing", dir, vid)
+			} else if v, ok := t.Get(id); !ok || v != p {
+				report(graph.CheckIndex, id, true, "%v index of node %d does not contain the quad", dir, vid)
+			}
+		}
+	}
+	for dir := quad.Subject; dir <= quad.Label; dir++ {
+		for vid, t := range qs.index.index[dir-1] {
+			e, err := t.SeekFirst()
+			if err == io.EOF {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			for {
+				id, p, err := e.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					e.Close()
+					return nil, err
+				}
+				if qs.prim[id] != p || p.Quad.Dir(dir) != vid {
+					report(graph.CheckIndex, id, true, "%v index of node %d contains a wrong quad", dir, vid)
+				}
+			}
+			e.Close()
+		}
+	}
+	// nodes that are not used by quads are skipped, since they may hold a reference of AddValue
+	for id, n := range nrefs {
+		if p := qs.prim[id]; p.refs != n {
+			report(graph.CheckRefs, id, true, "node has %d references instead of %d", p.refs, n)
+		}
+	}
+	for cid, cids := range qs.cqIndex.index {
+		for id := range cids {
+			// references to deleted quads are expected, they are skipped by QuadsByCid
+			if p := qs.prim[id]; p != nil && p.Quad.Zero() {
+				report(graph.CheckCid, id, true, "cid %q references a node", cid)
+			}
+		}
+	}
+	sort.SliceStable(rep.Problems, func(i, j int) bool {
+		a, b := rep.Problems[i], rep.Problems[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.ID < b.ID
+	})
+	if !opts.Repair || !repairable {
+		return rep, nil
+	}
+	qs.rebuild(ids, vals, quads, nrefs)
+	for i, p := range rep.Problems {
+		if p.Kind != graph.CheckLog {
+			rep.Problems[i].Repaired = true
+		}
+	}
+	return rep, nil
+}
+
+// rebuild replaces all indexes with the ones built from primitives.
+func (qs *QuadStore) rebuild(ids []int64, vals map[string]int64, quads map[internalQuad]int64, nrefs map[int64]int) {
+	qs.vals, qs.quads = vals, quads
+	qs.index = NewQuadDirectionIndex()
+	all := make([]*Primitive, 0, len(ids))
+	for _, id := range ids {
+		p := qs.prim[id]
+		all = append(all, p)
+		if p.Quad.Zero() {
+			if n, ok := nrefs[id]; ok {
+				p.refs = n
+			}
+			continue
+		}
+		for _, t := range qs.indexesForQuad(p.Quad) {
+			t.Set(id, p)
+		}
+	}
+	// the old slice may still be read by iterators
+	qs.all, qs.reading = all, false
+	for cid, cids := range qs.cqIndex.index {
+		for id := range cids {
+			if p := qs.prim[id]; p != nil && p.Quad.Zero() {
+				qs.cqIndex.Remove(cid, id)
+			}
+		}
+	}
+}
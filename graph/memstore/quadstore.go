@@ -17,8 +17,11 @@ package memstore
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/cayleygraph/quad"
 	"github.com/epik-protocol/epik-gateway-backend/graph"
@@ -90,36 +93,92 @@ func (qdi QuadDirectionIndex) Get(d quad.Direction, id int64) (*Tree, bool) {
 	return tree, ok
 }
 
+// CidQuadIndex maps each cid to a set of quads that were added with it.
 type CidQuadIndex struct {
-	index map[string]int64
+	index map[string]map[int64]struct{}
+	// refs is the number of cids that reference each quad
+	refs map[int64]int
 }
 
 func NewCidQuadIndex() CidQuadIndex {
-	return CidQuadIndex{map[string]int64{}}
+	return CidQuadIndex{
+		index: map[string]map[int64]struct{}{},
+		refs:  map[int64]int{},
+	}
 }
 
+// Add a quad to the cid. It returns false if the quad is already referenced by the cid.
 func (cqi CidQuadIndex) Add(cid string, id int64) bool {
 	if len(cid) == 0 || id == 0 {
 		return false
 	}
-	_, exist := cqi.index[cid]
-	if !exist {
-		cqi.index[cid] = id
+	ids, ok := cqi.index[cid]
+	if !ok {
+		ids = make(map[int64]struct{})
+		cqi.index[cid] = ids
+	}
+	if _, exist := ids[id]; exist {
+		return false
+	}
+	ids[id] = struct{}{}
+	cqi.refs[id]++
+	return true
+}
+
+// Remove a single quad from the cid.
+func (cqi CidQuadIndex) Remove(cid string, id int64) bool {
+	ids, ok := cqi.index[cid]
+	if !ok {
+		return false
+	}
+	_, exist := ids[id]
+	if exist {
+		delete(ids, id)
+		cqi.unref(id)
+	}
+	if len(ids) == 0 {
+		delete(cqi.index, cid)
 	}
-	return !exist
+	return exist
 }
 
+// Delete the cid with all its quads.
 func (cqi CidQuadIndex) Delete(cid string) bool {
-	_, exist := cqi.index[cid]
+	ids, exist := cqi.index[cid]
 	if exist {
+		for id := range ids {
+			cqi.unref(id)
+		}
 		delete(cqi.index, cid)
 	}
 	return exist
 }
 
-func (cqi CidQuadIndex) Get(cid string) (int64, bool) {
-	qid, exist := cqi.index[cid]
-	return qid, exist
+func (cqi CidQuadIndex) unref(id int64) {
+	if cqi.refs[id] <= 1 {
+		delete(cqi.refs, id)
+	} else {
+		cqi.refs[id]--
+	}
+}
+
+// Refs returns the number of cids that reference the quad.
+func (cqi CidQuadIndex) Refs(id int64) int {
+	return cqi.refs[id]
+}
+
+// Get returns all quads of the cid in ascending order.
+func (cqi CidQuadIndex) Get(cid string) ([]int64, bool) {
+	ids, exist := cqi.index[cid]
+	if !exist {
+		return nil, false
+	}
+	out := make([]int64, 0, len(ids))
+	for id := range ids {
+		out = append(out, id)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out, true
 }
 
 type Primitive struct {
@@ -180,6 +239,27 @@ type QuadStore struct {
 
 	epoch   int64
 	cqIndex CidQuadIndex
+	// chain keys and undo logs of applied epochs
+	epochKeys map[int64][]byte
+	undo      map[int64][]undoEntry
+
+	// metadata values of graph.MetaStore
+	meta map[string][]byte
+
+	// locks are renewed concurrently with other operations, thus they are guarded separately
+	lockMu sync.Mutex
+	locks  map[string]memLock
+}
+
+type memLock struct {
+	owner   string
+	expires time.Time
+}
+
+// undoEntry records a single delta applied at some epoch, so it can be reverted later.
+type undoEntry struct {
+	graph.Delta
+	New bool // quad was created by the delta
 }
 
 // New creates a new in-memory quad store and loads provided quads.
@@ -198,6 +278,11 @@ func newQuadStore() *QuadStore {
 		prim:    make(map[int64]*Primitive),
 		index:   NewQuadDirectionIndex(),
 		cqIndex: NewCidQuadIndex(),
+
+		epochKeys: make(map[int64][]byte),
+		undo:      make(map[int64][]undoEntry),
+		meta:      make(map[string][]byte),
+		locks:     make(map[string]memLock),
 	}
 }
 
@@ -473,20 +558,33 @@ func (qs *QuadStore) ApplyDeltas(epoch int64, deltas []graph.Delta, ignoreOpts g
 		}
 	}
 
+	var undo []undoEntry
 	for _, d := range deltas {
 		switch d.Action {
 		case graph.Add:
-			id, _ := qs.AddQuad(d.Quad)
-			if len(d.Cid) > 0 {
-				qs.cqIndex.Add(d.Cid, id)
+			id, created := qs.AddQuad(d.Quad)
+			if len(d.Cid) > 0 && qs.cqIndex.Add(d.Cid, id) || created {
+				undo = append(undo, undoEntry{Delta: d, New: created})
 			}
 		case graph.Delete:
 			if len(d.Cid) > 0 {
-				if id, ok := qs.cqIndex.Get(d.Cid); ok {
-					qs.Delete(id)
-					qs.cqIndex.Delete(d.Cid)
+				ids, _ := qs.cqIndex.Get(d.Cid)
+				qs.cqIndex.Delete(d.Cid)
+				for _, id := range ids {
+					// quads may be already deleted directly or by another cid
+					if _, ok := qs.quad(bnode(id)); !ok {
+						continue
+					}
+					// the revert restores the reference, and the quad if it was deleted
+					d.Quad = qs.Quad(bnode(id))
+					undo = append(undo, undoEntry{Delta: d})
+					if qs.cqIndex.Refs(id) == 0 {
+						// quad is not provided by any other cid
+						qs.Delete(id)
+					}
 				}
 			} else if id, _, ok := qs.findQuad(d.Quad); ok {
+				undo = append(undo, undoEntry{Delta: d})
 				qs.Delete(id)
 			}
 		default:
@@ -496,11 +594,184 @@ func (qs *QuadStore) ApplyDeltas(epoch int64, deltas []graph.Delta, ignoreOpts g
 	}
 	if epoch > 0 {
 		qs.epoch = epoch
+		if len(undo) != 0 {
+			qs.undo[epoch] = append(qs.undo[epoch], undo...)
+		}
 	}
 	qs.horizon++ //
 	return nil
 }
 
+var _ graph.CidIndex = (*QuadStore)(nil)
+
+func (qs *QuadStore) QuadsByCid(ctx context.Context, cid string) ([]graph.Ref, error) {
+	ids, _ := qs.cqIndex.Get(cid)
+	out := make([]graph.Ref, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := qs.quad(bnode(id)); ok {
+			out = append(out, bnode(id))
+		}
+	}
+	return out, nil
+}
+
+var _ graph.CidLister = (*QuadStore)(nil)
+
+func (qs *QuadStore) ForEachCid(ctx context.Context, fn func(cid string, q graph.Ref) error) error {
+	cids := make([]string, 0, len(qs.cqIndex.index))
+	for cid := range qs.cqIndex.index {
+		cids = append(cids, cid)
+	}
+	sort.Strings(cids)
+	for _, cid := range cids {
+		ids, _ := qs.cqIndex.Get(cid)
+		for _, id := range ids {
+			if _, ok := qs.quad(bnode(id)); !ok {
+				continue
+			}
+			if err := fn(cid, bnode(id)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var _ graph.EpochStore = (*QuadStore)(nil)
+
+func (qs *QuadStore) EpochKey(ctx context.Context, epoch int64) ([]byte, error) {
+	key, ok := qs.epochKeys[epoch]
+	if !ok {
+		return nil, graph.ErrEpochNotExist
+	}
+	return key, nil
+}
+
+func (qs *QuadStore) SetEpochKey(ctx context.Context, epoch int64, key []byte) error {
+	qs.epochKeys[epoch] = append([]byte{}, key...)
+	return nil
+}
+
+func (qs *QuadStore) RevertEpochs(ctx context.Context, from int64) error {
+	var epochs []int64
+	for e := range qs.undo {
+		if e >= from {
+			epochs = append(epochs, e)
+		}
+	}
+	sort.Slice(epochs, func(i, j int) bool { return epochs[i] > epochs[j] })
+	for _, e := range epochs {
+		undo := qs.undo[e]
+		for i := len(undo) - 1; i >= 0; i-- {
+			qs.revert(undo[i])
+		}
+		delete(qs.undo, e)
+	}
+	for e := range qs.epochKeys {
+		if e >= from {
+			delete(qs.epochKeys, e)
+		}
+	}
+	if from <= qs.epoch {
+		qs.epoch = from - 1
+	}
+	qs.horizon++
+	return nil
+}
+
+func (qs *QuadStore) revert(u undoEntry) {
+	switch u.Action {
+	case graph.Add:
+		// quad ids are not stable across reverts, thus resolve it again
+		id, _, ok := qs.findQuad(u.Quad)
+		if !ok {
+			return
+		}
+		if len(u.Cid) > 0 {
+			qs.cqIndex.Remove(u.Cid, id)
+		}
+		if u.New {
+			qs.Delete(id)
+		}
+	case graph.Delete:
+		id, _ := qs.AddQuad(u.Quad)
+		if len(u.Cid) > 0 {
+			qs.cqIndex.Add(u.Cid, id)
+		}
+	}
+}
+
+func (qs *QuadStore) PruneEpochs(ctx context.Context, before int64) error {
+	for e := range qs.undo {
+		if e < before {
+			delete(qs.undo, e)
+		}
+	}
+	for e := range qs.epochKeys {
+		if e < before {
+			delete(qs.epochKeys, e)
+		}
+	}
+	return nil
+}
+
+var _ graph.LockStore = (*QuadStore)(nil)
+
+func (qs *QuadStore) Lock(ctx context.Context, name, owner string, ttl time.Duration) error {
+	qs.lockMu.Lock()
+	defer qs.lockMu.Unlock()
+	now := time.Now()
+	if l, ok := qs.locks[name]; ok && l.owner != owner && now.Before(l.expires) {
+		return graph.ErrLocked
+	}
+	qs.locks[name] = memLock{owner: owner, expires: now.Add(ttl)}
+	return nil
+}
+
+func (qs *QuadStore) Unlock(ctx context.Context, name, owner string) error {
+	qs.lockMu.Lock()
+	defer qs.lockMu.Unlock()
+	if l, ok := qs.locks[name]; ok && l.owner == owner {
+		delete(qs.locks, name)
+	}
+	return nil
+}
+
+var _ graph.MetaStore = (*QuadStore)(nil)
+
+func (qs *QuadStore) Meta(ctx context.Context, key string) ([]byte, error) {
+	return qs.meta[key], nil
+}
+
+func (qs *QuadStore) ForEachMeta(ctx context.Context, fn func(key string, val []byte) error) error {
+	keys := make([]string, 0, len(qs.meta))
+	for k := range qs.meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn(k, qs.meta[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyDeltasMeta applies deltas and sets metadata values once all deltas are applied.
+func (qs *QuadStore) ApplyDeltasMeta(epoch int64, deltas []graph.Delta, opts graph.IgnoreOpts, meta map[string][]byte) error {
+	if err := qs.ApplyDeltas(epoch, deltas, opts); err != nil {
+		return err
+	}
+	for k, v := range meta {
+		if v == nil {
+			delete(qs.meta, k)
+		} else {
+			qs.meta[k] = append([]byte{}, v...)
+		}
+	}
+	return nil
+}
+
 func asID(v graph.Ref) (int64, bool) {
 	switch v := v.(type) {
 	case bnode:
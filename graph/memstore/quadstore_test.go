@@ -16,6 +16,7 @@ package memstore
 
 import (
 	"context"
+	"fmt"
 	"reflect"
 	"sort"
 	"testing"
@@ -342,3 +343,41 @@ func TestApplyDeltas(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, exp, st, "Unexpected quadstore size")
 }
+
+func TestCheck(t *testing.T) {
+	ctx := context.TODO()
+	qs, _, _ := makeTestStore(simpleGraph)
+
+	rep, err := qs.Check(ctx, graph.CheckOptions{})
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+	require.Equal(t, int64(len(simpleGraph)), rep.Quads)
+
+	node := int64(qs.ValueOf(quad.Raw("E")).(bnode))
+	qs.prim[node].refs += 2
+	var q internalQuad
+	var id int64
+	for q, id = range qs.quads {
+		break
+	}
+	qs.index.Tree(quad.Subject, q.S).Delete(id)
+
+	rep, err = qs.Check(ctx, graph.CheckOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []graph.Inconsistency{
+		{Kind: graph.CheckIndex, ID: uint64(id), Msg: fmt.Sprintf("subject index of node %d does not contain the quad", q.S)},
+		{Kind: graph.CheckRefs, ID: uint64(node), Msg: "node has 3 references instead of 1"},
+	}, rep.Problems)
+
+	rep, err = qs.Check(ctx, graph.CheckOptions{Repair: true})
+	require.NoError(t, err)
+	require.Len(t, rep.Problems, 2)
+	require.Equal(t, 0, rep.Unrepaired())
+
+	rep, err = qs.Check(ctx, graph.CheckOptions{})
+	require.NoError(t, err)
+	require.Empty(t, rep.Problems)
+	sz, err := qs.QuadIteratorSize(ctx, quad.Subject, bnode(q.S))
+	require.NoError(t, err)
+	require.NotZero(t, sz.Value)
+}
@@ -0,0 +1,223 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cayleygraph/quad"
+)
+
+// ErrMigrateNotEmpty is returned when a migration is started into a database that already has data.
+var ErrMigrateNotEmpty = errors.New("migrate: destination database is not empty")
+
+// MigrateCheckpoint records the progress of a migration, so it can be resumed after an interruption.
+type MigrateCheckpoint struct {
+	// Epoch is the epoch the source was at when the migration started.
+	Epoch int64 `json:"epoch"`
+	// Quads is the number of quads copied to the destination.
+	Quads int64 `json:"quads"`
+	// QuadPos is the position of the last copied quad, if the source is a QuadLister.
+	QuadPos int64 `json:"quad_pos,omitempty"`
+	// Cids is the number of references from cids to quads copied to the destination.
+	Cids int64 `json:"cids"`
+}
+
+// MigrateOptions are options of Migrate.
+type MigrateOptions struct {
+	// Batch is the number of deltas applied to the destination at once.
+	Batch int
+	// Resume continues an interrupted migration from a given checkpoint.
+	Resume *MigrateCheckpoint
+	// Checkpoint is called after each batch is applied to the destination.
+	// The migration is stopped if it returns an error.
+	Checkpoint func(MigrateCheckpoint) error
+}
+
+// Migrate copies all quads from one quad store to another, together with cid references, chain keys of epochs,
+// listener metadata and the indexed epoch, if both stores support them. At the end, exact node and quad counts of both stores are compared.
+//
+// The source must not be modified during the migration, and the destination must be empty
+// unless the migration is resumed. A resumed migration continues after the last copied quad if the source
+// is a QuadLister, otherwise all quads are copied again and duplicates are ignored.
+func Migrate(ctx context.Context, src, dst QuadStore, opts MigrateOptions) error {
+	if opts.Batch <= 0 {
+		opts.Batch = quad.DefaultBatch
+	}
+	st, err := src.Stats(ctx, false)
+	if err != nil {
+		return err
+	}
+	var cp MigrateCheckpoint
+	if opts.Resume != nil {
+		cp = *opts.Resume
+		if st.Epoch != cp.Epoch {
+			return fmt.Errorf("migrate: source is at epoch %d, but the migration was started at epoch %d", st.Epoch, cp.Epoch)
+		}
+	} else {
+		ds, err := dst.Stats(ctx, false)
+		if err != nil {
+			return err
+		} else if ds.Quads.Value != 0 {
+			return ErrMigrateNotEmpty
+		}
+		cp.Epoch = st.Epoch
+	}
+	checkpoint := func() error {
+		if opts.Checkpoint == nil {
+			return nil
+		}
+		return opts.Checkpoint(cp)
+	}
+	deltas := make([]Delta, 0, opts.Batch)
+	flush := func(n *int64) error {
+		if len(deltas) == 0 {
+			return nil
+		}
+		if err := dst.ApplyDeltas(NoCidEpoch, deltas, IgnoreOpts{IgnoreDup: true}); err != nil {
+			return err
+		}
+		*n += int64(len(deltas))
+		deltas = deltas[:0]
+		return checkpoint()
+	}
+
+	if ql, ok := src.(QuadLister); ok {
+		last := cp.QuadPos
+		err = ql.ForEachQuad(ctx, cp.QuadPos, func(pos int64, ref Ref) error {
+			deltas = append(deltas, Delta{Quad: src.Quad(ref), Action: Add})
+			last = pos
+			if len(deltas) >= opts.Batch {
+				cp.QuadPos = last
+				return flush(&cp.Quads)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		cp.QuadPos = last
+		if err = flush(&cp.Quads); err != nil {
+			return err
+		}
+	} else {
+		// the order is not stable, thus quads are copied from the start and ones copied before are ignored as duplicates
+		cp.Quads = 0
+		it := src.QuadsAllIterator().Iterate()
+		defer it.Close()
+		for it.Next(ctx) {
+			deltas = append(deltas, Delta{Quad: src.Quad(it.Result()), Action: Add})
+			if len(deltas) >= opts.Batch {
+				if err = flush(&cp.Quads); err != nil {
+					return err
+				}
+			}
+		}
+		if err = it.Err(); err != nil {
+			return err
+		} else if err = flush(&cp.Quads); err != nil {
+			return err
+		}
+	}
+
+	if cl, ok := src.(CidLister); ok {
+		skip := cp.Cids
+		err = cl.ForEachCid(ctx, func(cid string, ref Ref) error {
+			if skip > 0 {
+				skip--
+				return nil
+			}
+			deltas = append(deltas, Delta{Cid: cid, Quad: src.Quad(ref), Action: Add})
+			if len(deltas) >= opts.Batch {
+				return flush(&cp.Cids)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		} else if err = flush(&cp.Cids); err != nil {
+			return err
+		}
+	} else if _, ok := src.(CidIndex); ok {
+		return errors.New("migrate: source does not support listing cids")
+	}
+
+	if cp.Epoch > 0 {
+		if err = migrateEpochKeys(ctx, src, dst, cp.Epoch); err != nil {
+			return err
+		}
+	}
+	meta, err := migrateMeta(ctx, src, dst)
+	if err != nil {
+		return err
+	}
+	if ms, ok := dst.(MetaStore); ok && len(meta) != 0 {
+		err = ms.ApplyDeltasMeta(cp.Epoch, nil, IgnoreOpts{}, meta)
+	} else if cp.Epoch > 0 {
+		err = dst.ApplyDeltas(cp.Epoch, nil, IgnoreOpts{})
+	}
+	if err != nil {
+		return err
+	}
+	return verifyMigration(ctx, src, dst, cp.Epoch)
+}
+
+// migrateMeta reads listener metadata of the source, if both stores support it.
+// Values are written to the destination together with the epoch.
+func migrateMeta(ctx context.Context, src, dst QuadStore) (map[string][]byte, error) {
+	from, ok := src.(MetaStore)
+	if !ok {
+		return nil, nil
+	} else if _, ok = dst.(MetaStore); !ok {
+		return nil, nil
+	}
+	meta := make(map[string][]byte)
+	err := from.ForEachMeta(ctx, func(key string, val []byte) error {
+		meta[key] = val
+		return nil
+	})
+	return meta, err
+}
+
+// migrateEpochKeys copies chain keys of all epochs up to a given one.
+func migrateEpochKeys(ctx context.Context, src, dst QuadStore, last int64) error {
+	from, ok := src.(EpochStore)
+	if !ok {
+		return nil
+	}
+	to, ok := dst.(EpochStore)
+	if !ok {
+		return nil
+	}
+	for e := int64(1); e <= last; e++ {
+		key, err := from.EpochKey(ctx, e)
+		if err == ErrEpochNotExist {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err = to.SetEpochKey(ctx, e, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyMigration(ctx context.Context, src, dst QuadStore, epoch int64) error {
+	s1, err := src.Stats(ctx, true)
+	if err != nil {
+		return err
+	}
+	if s1.Epoch != epoch {
+		return fmt.Errorf("migrate: source was modified during the migration: epoch %d changed to %d", epoch, s1.Epoch)
+	}
+	s2, err := dst.Stats(ctx, true)
+	if err != nil {
+		return err
+	}
+	if s1.Nodes.Value != s2.Nodes.Value || s1.Quads.Value != s2.Quads.Value {
+		return fmt.Errorf("migrate: source has %d nodes and %d quads, but destination has %d nodes and %d quads",
+			s1.Nodes.Value, s1.Quads.Value, s2.Nodes.Value, s2.Quads.Value)
+	}
+	return nil
+}
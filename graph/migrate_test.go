@@ -0,0 +1,118 @@
+package graph_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cayleygraph/quad"
+	"github.com/stretchr/testify/require"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/kv"
+	"github.com/epik-protocol/epik-gateway-backend/graph/kv/btree"
+)
+
+func newKVStore(t *testing.T) *kv.QuadStore {
+	db := btree.New()
+	require.NoError(t, kv.Init(db, nil))
+	qs, err := kv.New(db, nil)
+	require.NoError(t, err)
+	return qs.(*kv.QuadStore)
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.TODO()
+	src := newKVStore(t)
+	defer src.Close()
+	require.NoError(t, src.ApplyDeltas(1, []graph.Delta{
+		{Cid: "c1", Quad: quad.MakeIRI("a", "b", "c", ""), Action: graph.Add},
+		{Cid: "c1", Quad: quad.MakeIRI("a", "b", "d", ""), Action: graph.Add},
+		{Cid: "c2", Quad: quad.MakeIRI("x", "y", "z", ""), Action: graph.Add},
+	}, graph.IgnoreOpts{}))
+	require.NoError(t, src.SetEpochKey(ctx, 1, []byte("k1")))
+	require.NoError(t, src.ApplyDeltasMeta(2, []graph.Delta{
+		{Cid: "c2", Action: graph.Delete},
+		{Cid: "c3", Quad: quad.MakeIRI("a", "b", "c", ""), Action: graph.Add},
+		{Cid: "c3", Quad: quad.MakeIRI("e", "f", "g", ""), Action: graph.Add},
+	}, graph.IgnoreOpts{IgnoreDup: true}, map[string][]byte{"deals": []byte("d2")}))
+	require.NoError(t, src.SetEpochKey(ctx, 2, []byte("k2")))
+
+	dst := newKVStore(t)
+	defer dst.Close()
+
+	// interrupt the migration after the first batch
+	errStop := errors.New("stop")
+	var last graph.MigrateCheckpoint
+	err := graph.Migrate(ctx, src, dst, graph.MigrateOptions{
+		Batch: 2,
+		Checkpoint: func(cp graph.MigrateCheckpoint) error {
+			last = cp
+			return errStop
+		},
+	})
+	require.Equal(t, errStop, err)
+	require.Equal(t, int64(2), last.Epoch)
+	require.Equal(t, int64(2), last.Quads)
+	require.NotZero(t, last.QuadPos)
+	pos := last.QuadPos
+
+	err = graph.Migrate(ctx, src, dst, graph.MigrateOptions{Batch: 2})
+	require.Equal(t, graph.ErrMigrateNotEmpty, err)
+
+	err = graph.Migrate(ctx, src, dst, graph.MigrateOptions{
+		Batch:  2,
+		Resume: &last,
+		Checkpoint: func(cp graph.MigrateCheckpoint) error {
+			last = cp
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), last.Quads, "resumed after the last copied quad")
+	require.Equal(t, int64(4), last.Cids)
+	require.True(t, last.QuadPos > pos)
+
+	st, err := dst.Stats(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), st.Epoch)
+	require.Equal(t, int64(3), st.Quads.Value)
+	for cid, n := range map[string]int{"c1": 2, "c2": 0, "c3": 2} {
+		refs, err := dst.QuadsByCid(ctx, cid)
+		require.NoError(t, err)
+		require.Len(t, refs, n, cid)
+	}
+	key, err := dst.EpochKey(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, "k2", string(key))
+	meta, err := dst.Meta(ctx, "deals")
+	require.NoError(t, err)
+	require.Equal(t, "d2", string(meta))
+
+	// sources without a stable order of quads are copied from the start when resumed
+	unordered := struct{ graph.QuadStore }{src}
+	dst = newKVStore(t)
+	defer dst.Close()
+	err = graph.Migrate(ctx, unordered, dst, graph.MigrateOptions{
+		Batch: 2,
+		Checkpoint: func(cp graph.MigrateCheckpoint) error {
+			last = cp
+			return errStop
+		},
+	})
+	require.Equal(t, errStop, err)
+	require.Equal(t, graph.MigrateCheckpoint{Epoch: 2, Quads: 2}, last)
+	err = graph.Migrate(ctx, unordered, dst, graph.MigrateOptions{
+		Batch:  2,
+		Resume: &last,
+		Checkpoint: func(cp graph.MigrateCheckpoint) error {
+			last = cp
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, graph.MigrateCheckpoint{Epoch: 2, Quads: 3}, last)
+	st, err = dst.Stats(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), st.Quads.Value)
+}
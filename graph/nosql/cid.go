@@ -0,0 +1,279 @@
+package nosql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hidal-go/hidalgo/legacy/nosql"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+)
+
+const (
+	colMeta = "meta"
+	colCids = "cids"
+
+	fldMetaName = "name"
+	fldCid      = "cid"
+
+	metaEpoch = "epoch"
+)
+
+func ensureMeta(ctx context.Context, db nosql.Database) error {
+	err := db.EnsureIndex(ctx, colMeta, nosql.Index{
+		Fields: []string{fldMetaName},
+		Type:   nosql.StringExact,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	return db.EnsureIndex(ctx, colCids, nosql.Index{
+		Fields: []string{
+			fldCid,
+			fldSubject,
+			fldPredicate,
+			fldObject,
+			fldLabel,
+		},
+		Type: nosql.StringExact,
+	}, []nosql.Index{
+		{Fields: []string{fldCid}, Type: nosql.StringExact},
+		{Fields: []string{fldSubject}, Type: nosql.StringExact},
+	})
+}
+
+// readEpoch reads the epoch stored in the metadata collection. The epoch is not cached,
+// since it may be advanced by other processes sharing the database.
+func (qs *QuadStore) readEpoch(ctx context.Context) (int64, error) {
+	doc, err := qs.db.FindByKey(ctx, colMeta, nosql.Key{metaEpoch})
+	if err == nosql.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("error loading epoch: %v", err)
+	}
+	epoch, err := asInt(doc[fldValue])
+	if err != nil {
+		return 0, err
+	}
+	return int64(epoch), nil
+}
+
+// setEpoch stores the epoch in the metadata collection.
+// Backends cannot overwrite fields of existing documents, only increment them, thus the value
+// is updated by the difference with the epoch read from the database, not with a cached one.
+// Epochs are only written by the process holding the sync lock.
+func (qs *QuadStore) setEpoch(ctx context.Context, epoch int64) error {
+	cur, err := qs.readEpoch(ctx)
+	if err != nil {
+		return err
+	} else if cur == epoch {
+		return nil
+	}
+	err = qs.db.Update(colMeta, nosql.Key{metaEpoch}).
+		Upsert(nosql.Document{fldMetaName: nosql.String(metaEpoch)}).
+		Inc(fldValue, int(epoch-cur)).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("error updating epoch: %v", err)
+	}
+	return nil
+}
+
+func cidKey(cid string, q QuadHash) nosql.Key {
+	return nosql.Key{cid, q[0], q[1], q[2], q[3]}
+}
+
+// addCids references quads from cids of their deltas.
+func (qs *QuadStore) addCids(ctx context.Context, deltas []graph.Delta) error {
+	for _, d := range deltas {
+		if d.Cid == "" || d.Action != graph.Add {
+			continue
+		}
+		q := quadHashOf(d.Quad)
+		doc := nosql.Document{
+			fldCid:       nosql.String(d.Cid),
+			fldSubject:   nosql.String(q[0]),
+			fldPredicate: nosql.String(q[1]),
+			fldObject:    nosql.String(q[2]),
+		}
+		if q[3] != "" {
+			doc[fldLabel] = nosql.String(q[3])
+		}
+		err := qs.db.Update(colCids, cidKey(d.Cid, q)).Upsert(doc).Inc(fldQuadAdded, 1).Do(ctx)
+		if err != nil {
+			return &graph.DeltaError{Delta: d, Err: fmt.Errorf("cid update failed: %v", err)}
+		}
+	}
+	return nil
+}
+
+func (qs *QuadStore) delCids(ctx context.Context, cids []string) error {
+	for _, cid := range cids {
+		err := qs.db.Delete(colCids).WithFields(nosql.FieldFilter{
+			Path:   []string{fldCid},
+			Filter: nosql.Equal,
+			Value:  nosql.String(cid),
+		}).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("error deleting cid: %v", err)
+		}
+	}
+	return nil
+}
+
+func quadHashOf(q quad.Quad) QuadHash {
+	return QuadHash{
+		hashOf(q.Subject),
+		hashOf(q.Predicate),
+		hashOf(q.Object),
+		hashOf(q.Label),
+	}
+}
+
+// cidQuadHash returns a hash of the quad referenced by a document of the cid collection.
+func cidQuadHash(doc nosql.Document) QuadHash {
+	sh, _ := doc[fldSubject].(nosql.String)
+	ph, _ := doc[fldPredicate].(nosql.String)
+	oh, _ := doc[fldObject].(nosql.String)
+	lh, _ := doc[fldLabel].(nosql.String)
+	return QuadHash{string(sh), string(ph), string(oh), string(lh)}
+}
+
+// quadsByCid returns all valid quads that were added with a given cid.
+func (qs *QuadStore) quadsByCid(ctx context.Context, cid string) ([]QuadHash, error) {
+	it := qs.db.Query(colCids).WithFields(nosql.FieldFilter{
+		Path:   []string{fldCid},
+		Filter: nosql.Equal,
+		Value:  nosql.String(cid),
+	}).Iterate()
+	defer it.Close()
+	var out []QuadHash
+	for it.Next(ctx) {
+		q := cidQuadHash(it.Doc())
+		valid, err := qs.checkValidQuad(ctx, nosql.Key(q[:]))
+		if err != nil {
+			return nil, err
+		} else if valid {
+			out = append(out, q)
+		}
+	}
+	return out, it.Err()
+}
+
+// sharedCidQuad checks if the quad is provided by any cid except the given ones.
+func (qs *QuadStore) sharedCidQuad(ctx context.Context, q QuadHash, except map[string]struct{}) (bool, error) {
+	it := qs.db.Query(colCids).WithFields(
+		nosql.FieldFilter{Path: []string{fldSubject}, Filter: nosql.Equal, Value: nosql.String(q[0])},
+		nosql.FieldFilter{Path: []string{fldPredicate}, Filter: nosql.Equal, Value: nosql.String(q[1])},
+		nosql.FieldFilter{Path: []string{fldObject}, Filter: nosql.Equal, Value: nosql.String(q[2])},
+	).Iterate()
+	defer it.Close()
+	for it.Next(ctx) {
+		doc := it.Doc()
+		if cidQuadHash(doc) != q {
+			continue // different label
+		}
+		cid, _ := doc[fldCid].(nosql.String)
+		if _, ok := except[string(cid)]; !ok {
+			return true, nil
+		}
+	}
+	return false, it.Err()
+}
+
+// expandCidDeletes replaces deletions by cid with deletions of all quads that are provided only by the cid.
+// Quads that are still provided by other cids are kept. It also returns a list of cids that should be
+// removed from the index.
+func (qs *QuadStore) expandCidDeletes(ctx context.Context, deltas []graph.Delta, ignoreOpts graph.IgnoreOpts) ([]graph.Delta, []string, error) {
+	var (
+		out  []graph.Delta
+		cids []string
+		seen map[QuadHash]struct{}
+		// all cids deleted in this batch, quads shared only between them are deleted
+		deleted map[string]struct{}
+	)
+	for i, d := range deltas {
+		if d.Cid == "" || d.Action != graph.Delete {
+			if out != nil {
+				out = append(out, d)
+			}
+			continue
+		}
+		if out == nil {
+			out = make([]graph.Delta, 0, len(deltas))
+			out = append(out, deltas[:i]...)
+			seen = make(map[QuadHash]struct{})
+			deleted = make(map[string]struct{})
+			for _, d2 := range deltas {
+				if d2.Action != graph.Delete {
+					continue
+				} else if d2.Cid == "" {
+					seen[quadHashOf(d2.Quad)] = struct{}{}
+				} else {
+					deleted[d2.Cid] = struct{}{}
+				}
+			}
+		}
+		quads, err := qs.quadsByCid(ctx, d.Cid)
+		if err != nil {
+			return nil, nil, &graph.DeltaError{Delta: d, Err: err}
+		}
+		if len(quads) == 0 && !ignoreOpts.IgnoreMissing {
+			return nil, nil, &graph.DeltaError{Delta: d, Err: graph.ErrQuadNotExist}
+		}
+		for _, q := range quads {
+			if _, ok := seen[q]; ok {
+				continue
+			}
+			seen[q] = struct{}{}
+			shared, err := qs.sharedCidQuad(ctx, q, deleted)
+			if err != nil {
+				return nil, nil, &graph.DeltaError{Delta: d, Err: err}
+			} else if shared {
+				continue
+			}
+			out = append(out, graph.Delta{Cid: d.Cid, Quad: qs.Quad(q), Action: graph.Delete})
+		}
+		cids = append(cids, d.Cid)
+	}
+	if out == nil {
+		return deltas, nil, nil
+	}
+	return out, cids, nil
+}
+
+var _ graph.CidIndex = (*QuadStore)(nil)
+
+func (qs *QuadStore) QuadsByCid(ctx context.Context, cid string) ([]graph.Ref, error) {
+	quads, err := qs.quadsByCid(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]graph.Ref, 0, len(quads))
+	for _, q := range quads {
+		out = append(out, q)
+	}
+	return out, nil
+}
+
+var _ graph.CidLister = (*QuadStore)(nil)
+
+func (qs *QuadStore) ForEachCid(ctx context.Context, fn func(cid string, q graph.Ref) error) error {
+	it := qs.db.Query(colCids).Iterate()
+	defer it.Close()
+	for it.Next(ctx) {
+		doc := it.Doc()
+		cid, _ := doc[fldCid].(nosql.String)
+		q := cidQuadHash(doc)
+		valid, err := qs.checkValidQuad(ctx, nosql.Key(q[:]))
+		if err != nil {
+			return err
+		} else if !valid {
+			continue
+		}
+		if err = fn(string(cid), q); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
@@ -0,0 +1,81 @@
+package nosql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hidal-go/hidalgo/legacy/nosql"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+)
+
+const (
+	fldLockOwner   = "owner"
+	fldLockExpires = "expires"
+)
+
+var _ graph.LockStore = (*QuadStore)(nil)
+
+// lockKey returns a key of the lock document in the metadata collection.
+func lockKey(name string) nosql.Key {
+	return nosql.Key{"lock:" + name}
+}
+
+// lockOwner returns the current owner of the lock and its expiration time in unix nanoseconds.
+func (qs *QuadStore) lockOwner(ctx context.Context, name string) (string, int64, error) {
+	doc, err := qs.db.FindByKey(ctx, colMeta, lockKey(name))
+	if err != nil {
+		return "", 0, err
+	}
+	owner, _ := doc[fldLockOwner].(nosql.String)
+	expires, err := asInt(doc[fldLockExpires])
+	if err != nil {
+		return "", 0, err
+	}
+	return string(owner), int64(expires), nil
+}
+
+// Lock replaces an expired lock by deleting the exact document that was read and inserting a new one.
+// Inserts of the same key fail, thus only one of the concurrent callers acquires the lock.
+func (qs *QuadStore) Lock(ctx context.Context, name, owner string, ttl time.Duration) error {
+	now := time.Now()
+	cur, expires, err := qs.lockOwner(ctx, name)
+	if err == nil {
+		if cur != owner && now.UnixNano() < expires {
+			return graph.ErrLocked
+		}
+		err = qs.db.Delete(colMeta).Keys(lockKey(name)).WithFields(
+			nosql.FieldFilter{Path: []string{fldLockOwner}, Filter: nosql.Equal, Value: nosql.String(cur)},
+			nosql.FieldFilter{Path: []string{fldLockExpires}, Filter: nosql.Equal, Value: nosql.Int(expires)},
+		).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("error deleting lock: %v", err)
+		}
+	} else if err != nosql.ErrNotFound {
+		return fmt.Errorf("error loading lock: %v", err)
+	}
+	_, err = qs.db.Insert(ctx, colMeta, lockKey(name), nosql.Document{
+		fldMetaName:    nosql.String("lock:" + name),
+		fldLockOwner:   nosql.String(owner),
+		fldLockExpires: nosql.Int(now.Add(ttl).UnixNano()),
+	})
+	if err != nil {
+		// another process might have inserted the lock first
+		if cur, _, err2 := qs.lockOwner(ctx, name); err2 == nil && cur != owner {
+			return graph.ErrLocked
+		}
+		return fmt.Errorf("error inserting lock: %v", err)
+	}
+	return nil
+}
+
+func (qs *QuadStore) Unlock(ctx context.Context, name, owner string) error {
+	err := qs.db.Delete(colMeta).Keys(lockKey(name)).WithFields(
+		nosql.FieldFilter{Path: []string{fldLockOwner}, Filter: nosql.Equal, Value: nosql.String(owner)},
+	).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("error deleting lock: %v", err)
+	}
+	return nil
+}
@@ -113,6 +113,9 @@ func NewQuadStore(db nosql.Database, nopt *Traits, opt graph.Options) (*QuadStor
 	if nopt != nil {
 		qs.opt = *nopt
 	}
+	if _, err := qs.readEpoch(context.TODO()); err != nil {
+		return nil, err
+	}
 	return qs, nil
 }
 
@@ -212,7 +215,7 @@ func ensureIndexes(ctx context.Context, db nosql.Database) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	return ensureMeta(ctx, db)
 }
 
 func getKeyForQuad(t quad.Quad) nosql.Key {
@@ -373,10 +376,21 @@ func (w *quadWriter) Close() error {
 	return nil
 }
 
+// ApplyDeltas applies deltas and advances the store epoch.
+//
+// Backends have no transactions, thus quads, cid references and the epoch are written by separate updates.
+// The epoch is written last, only after all other updates succeed, so a failed batch leaves the epoch
+// unchanged and is applied again by the listener.
 func (qs *QuadStore) ApplyDeltas(epoch int64, deltas []graph.Delta, ignoreOpts graph.IgnoreOpts) error {
 	ctx := context.TODO()
 	ids := make(map[quad.Value]int)
 
+	deltas, cids, err := qs.expandCidDeletes(ctx, deltas, ignoreOpts)
+	if err != nil {
+		return err
+	}
+	addDeltas := deltas
+
 	var validDeltas []graph.Delta
 	if ignoreOpts.IgnoreDup || ignoreOpts.IgnoreMissing {
 		validDeltas = make([]graph.Delta, 0, len(deltas))
@@ -456,6 +470,16 @@ func (qs *QuadStore) ApplyDeltas(epoch int64, deltas []graph.Delta, ignoreOpts g
 			return &graph.DeltaError{Delta: d, Err: err}
 		}
 	}
+	// duplicates are referenced as well, since they are provided by another cid
+	if err := qs.addCids(ctx, addDeltas); err != nil {
+		return err
+	}
+	if err := qs.delCids(ctx, cids); err != nil {
+		return err
+	}
+	if epoch > 0 {
+		return qs.setEpoch(ctx, epoch)
+	}
 	return nil
 }
 
@@ -694,6 +718,10 @@ func (qs *QuadStore) NameOf(v graph.Ref) quad.Value {
 
 func (qs *QuadStore) Stats(ctx context.Context, exact bool) (graph.Stats, error) {
 	// TODO(barakmich): Make size real; store it in the log, and retrieve it.
+	epoch, err := qs.readEpoch(ctx)
+	if err != nil {
+		return graph.Stats{}, err
+	}
 	nodes, err := qs.db.Query(colNodes).Count(ctx)
 	if err != nil {
 		return graph.Stats{}, err
@@ -711,6 +739,7 @@ func (qs *QuadStore) Stats(ctx context.Context, exact bool) (graph.Stats, error)
 			Value: quads,
 			Exact: true,
 		},
+		Epoch: epoch,
 	}, nil
 }
 
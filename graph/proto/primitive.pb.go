@@ -77,15 +77,17 @@ func (x PrimitiveType) String() string {
 func (PrimitiveType) EnumDescriptor() ([]byte, []int) { return fileDescriptorPrimitive, []int{0} }
 
 type Primitive struct {
-	ID        uint64 `protobuf:"varint,1,opt,name=ID,json=iD,proto3" json:"ID,omitempty"`
-	Subject   uint64 `protobuf:"varint,2,opt,name=Subject,json=subject,proto3" json:"Subject,omitempty"`
-	Predicate uint64 `protobuf:"varint,3,opt,name=Predicate,json=predicate,proto3" json:"Predicate,omitempty"`
-	Object    uint64 `protobuf:"varint,4,opt,name=Object,json=object,proto3" json:"Object,omitempty"`
-	Label     uint64 `protobuf:"varint,5,opt,name=Label,json=label,proto3" json:"Label,omitempty"`
-	Replaces  uint64 `protobuf:"varint,6,opt,name=Replaces,json=replaces,proto3" json:"Replaces,omitempty"`
-	Timestamp int64  `protobuf:"varint,7,opt,name=Timestamp,json=timestamp,proto3" json:"Timestamp,omitempty"`
-	Value     []byte `protobuf:"bytes,8,opt,name=Value,json=value,proto3" json:"Value,omitempty"`
-	Deleted   bool   `protobuf:"varint,9,opt,name=Deleted,json=deleted,proto3" json:"Deleted,omitempty"`
+	ID           uint64 `protobuf:"varint,1,opt,name=ID,json=iD,proto3" json:"ID,omitempty"`
+	Subject      uint64 `protobuf:"varint,2,opt,name=Subject,json=subject,proto3" json:"Subject,omitempty"`
+	Predicate    uint64 `protobuf:"varint,3,opt,name=Predicate,json=predicate,proto3" json:"Predicate,omitempty"`
+	Object       uint64 `protobuf:"varint,4,opt,name=Object,json=object,proto3" json:"Object,omitempty"`
+	Label        uint64 `protobuf:"varint,5,opt,name=Label,json=label,proto3" json:"Label,omitempty"`
+	Replaces     uint64 `protobuf:"varint,6,opt,name=Replaces,json=replaces,proto3" json:"Replaces,omitempty"`
+	Timestamp    int64  `protobuf:"varint,7,opt,name=Timestamp,json=timestamp,proto3" json:"Timestamp,omitempty"`
+	Value        []byte `protobuf:"bytes,8,opt,name=Value,json=value,proto3" json:"Value,omitempty"`
+	Deleted      bool   `protobuf:"varint,9,opt,name=Deleted,json=deleted,proto3" json:"Deleted,omitempty"`
+	AddedEpoch   int64  `protobuf:"varint,10,opt,name=AddedEpoch,json=addedEpoch,proto3" json:"AddedEpoch,omitempty"`
+	DeletedEpoch int64  `protobuf:"varint,11,opt,name=DeletedEpoch,json=deletedEpoch,proto3" json:"DeletedEpoch,omitempty"`
 }
 
 func (m *Primitive) Reset()                    { *m = Primitive{} }
@@ -156,6 +158,20 @@ func (m *Primitive) GetDeleted() bool {
 	return false
 }
 
+func (m *Primitive) GetAddedEpoch() int64 {
+	if m != nil {
+		return m.AddedEpoch
+	}
+	return 0
+}
+
+func (m *Primitive) GetDeletedEpoch() int64 {
+	if m != nil {
+		return m.DeletedEpoch
+	}
+	return 0
+}
+
 func init() {
 	proto1.RegisterType((*Primitive)(nil), "proto.Primitive")
 	proto1.RegisterEnum("proto.PrimitiveType", PrimitiveType_name, PrimitiveType_value)
@@ -226,6 +242,16 @@ func (m *Primitive) MarshalTo(dAtA []byte) (int, error) {
 		}
 		i++
 	}
+	if m.AddedEpoch != 0 {
+		dAtA[i] = 0x50
+		i++
+		i = encodeVarintPrimitive(dAtA, i, uint64(m.AddedEpoch))
+	}
+	if m.DeletedEpoch != 0 {
+		dAtA[i] = 0x58
+		i++
+		i = encodeVarintPrimitive(dAtA, i, uint64(m.DeletedEpoch))
+	}
 	return i, nil
 }
 
@@ -287,6 +313,12 @@ func (m *Primitive) ProtoSize() (n int) {
 	if m.Deleted {
 		n += 2
 	}
+	if m.AddedEpoch != 0 {
+		n += 1 + sovPrimitive(uint64(m.AddedEpoch))
+	}
+	if m.DeletedEpoch != 0 {
+		n += 1 + sovPrimitive(uint64(m.DeletedEpoch))
+	}
 	return n
 }
 
@@ -516,6 +548,44 @@ func (m *Primitive) Unmarshal(dAtA []byte) error {
 				}
 			}
 			m.Deleted = bool(v != 0)
+		case 10:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field AddedEpoch", wireType)
+			}
+			m.AddedEpoch = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPrimitive
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.AddedEpoch |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 11:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DeletedEpoch", wireType)
+			}
+			m.DeletedEpoch = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPrimitive
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.DeletedEpoch |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipPrimitive(dAtA[iNdEx:])
@@ -645,28 +715,30 @@ var (
 func init() { proto1.RegisterFile("primitive.proto", fileDescriptorPrimitive) }
 
 var fileDescriptorPrimitive = []byte{
-	// 357 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x3c, 0x91, 0xdf, 0x4a, 0xeb, 0x30,
-	0x1c, 0xc7, 0x4f, 0xfa, 0xbf, 0x61, 0x3b, 0x27, 0x84, 0x83, 0x84, 0x21, 0xa3, 0x78, 0x55, 0x04,
-	0xb7, 0x0b, 0x9f, 0x60, 0xa3, 0x73, 0x14, 0xbb, 0x76, 0xa4, 0x41, 0xf0, 0x4a, 0xda, 0x2e, 0xce,
-	0x4a, 0x4b, 0xcb, 0x96, 0x0e, 0xbc, 0xf6, 0x45, 0x7c, 0x1c, 0x2f, 0x7d, 0x86, 0x79, 0xe9, 0x4b,
-	0x48, 0x52, 0xe7, 0x55, 0xf2, 0xf9, 0xfe, 0xf8, 0x7c, 0x93, 0x10, 0xf8, 0xaf, 0xdd, 0x95, 0x75,
-	0x29, 0xca, 0x03, 0x9f, 0xb4, 0xbb, 0x46, 0x34, 0xd8, 0x54, 0xcb, 0xe8, 0x6a, 0x5b, 0x8a, 0xa7,
-	0x2e, 0x9f, 0x14, 0x4d, 0x3d, 0xdd, 0x36, 0xdb, 0x66, 0xaa, 0xe2, 0xbc, 0x7b, 0x54, 0xa4, 0x40,
-	0xed, 0x7a, 0xeb, 0xe2, 0x0b, 0x40, 0x77, 0x7d, 0x6a, 0xc2, 0x7f, 0xa1, 0x16, 0x06, 0x04, 0x78,
-	0xc0, 0x37, 0xa8, 0x56, 0x06, 0x98, 0x40, 0x3b, 0xed, 0xf2, 0x67, 0x5e, 0x08, 0xa2, 0xa9, 0xd0,
-	0xde, 0xf7, 0x88, 0xcf, 0xa5, 0xc6, 0x37, 0x65, 0x91, 0x09, 0x4e, 0x74, 0x35, 0x73, 0xdb, 0x53,
-	0x80, 0xcf, 0xa0, 0x95, 0xf4, 0x9a, 0xa1, 0x46, 0x56, 0xd3, 0x5b, 0xff, 0xa1, 0x19, 0x65, 0x39,
-	0xaf, 0x88, 0xa9, 0x62, 0xb3, 0x92, 0x80, 0x47, 0xd0, 0xa1, 0xbc, 0xad, 0xb2, 0x82, 0xef, 0x89,
-	0xa5, 0x06, 0xce, 0xee, 0x87, 0xe5, 0x39, 0xac, 0xac, 0xf9, 0x5e, 0x64, 0x75, 0x4b, 0x6c, 0x0f,
-	0xf8, 0x3a, 0x75, 0xc5, 0x29, 0x90, 0x7d, 0x77, 0x59, 0xd5, 0x71, 0xe2, 0x78, 0xc0, 0x1f, 0x50,
-	0xf3, 0x20, 0x41, 0xde, 0x3a, 0xe0, 0x15, 0x17, 0x7c, 0x43, 0x5c, 0x0f, 0xf8, 0x0e, 0xb5, 0x37,
-	0x3d, 0x5e, 0xbe, 0x02, 0x38, 0xfc, 0x7d, 0x2d, 0x7b, 0x69, 0x39, 0x76, 0xa0, 0x11, 0x85, 0xf1,
-	0x2d, 0xfa, 0x83, 0x6d, 0xa8, 0x87, 0x34, 0x44, 0x00, 0x43, 0x68, 0xa5, 0x8c, 0x86, 0xf1, 0x12,
-	0x69, 0xd8, 0x85, 0xe6, 0x3c, 0x4e, 0x82, 0x05, 0xd2, 0xf1, 0x10, 0xba, 0xec, 0x7e, 0xbd, 0x08,
-	0x1e, 0x52, 0x46, 0x91, 0x81, 0x07, 0xd0, 0x89, 0x66, 0xf1, 0x52, 0x91, 0xa9, 0xe4, 0x98, 0x21,
-	0x4b, 0x0a, 0x37, 0x51, 0x32, 0x63, 0xc8, 0x96, 0xd5, 0xf3, 0x24, 0x89, 0x90, 0xa3, 0xd4, 0x70,
-	0xb5, 0x48, 0xd9, 0x6c, 0xb5, 0x46, 0xee, 0x7c, 0xf0, 0x7e, 0x1c, 0x83, 0x8f, 0xe3, 0x18, 0xbc,
-	0x7d, 0x8e, 0x41, 0x6e, 0xa9, 0x8f, 0xb8, 0xfe, 0x0e, 0x00, 0x00, 0xff, 0xff, 0x0a, 0x44, 0x49,
-	0x16, 0xd1, 0x01, 0x00, 0x00,
+	// 388 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x91, 0xdd, 0x8a, 0xd3, 0x40,
+	0x14, 0xc7, 0x9d, 0xb4, 0xf9, 0x3a, 0x66, 0x75, 0x18, 0x44, 0x86, 0x45, 0x4a, 0xd9, 0xab, 0x22,
+	0xb8, 0x7b, 0xe1, 0x13, 0xa4, 0x24, 0x2e, 0xc1, 0x6c, 0x52, 0x26, 0x83, 0xe0, 0x95, 0xe4, 0x63,
+	0xec, 0x46, 0x12, 0x12, 0xda, 0xc9, 0x82, 0xd7, 0xbe, 0x88, 0x6f, 0xe0, 0x6b, 0x78, 0xe9, 0x33,
+	0xac, 0x2f, 0x22, 0x73, 0xb2, 0x2d, 0x5e, 0x25, 0xbf, 0xff, 0x39, 0xbf, 0x7f, 0xc2, 0x0c, 0xbc,
+	0x1c, 0x0f, 0x6d, 0xdf, 0xea, 0xf6, 0x41, 0x5d, 0x8f, 0x87, 0x41, 0x0f, 0xcc, 0xc6, 0xc7, 0xe5,
+	0xbb, 0x7d, 0xab, 0xef, 0xa7, 0xea, 0xba, 0x1e, 0xfa, 0x9b, 0xfd, 0xb0, 0x1f, 0x6e, 0x30, 0xae,
+	0xa6, 0xaf, 0x48, 0x08, 0xf8, 0x36, 0x5b, 0x57, 0xbf, 0x2c, 0xf0, 0x77, 0xa7, 0x26, 0xf6, 0x02,
+	0xac, 0x24, 0xe2, 0x64, 0x4d, 0x36, 0x4b, 0x61, 0xb5, 0x11, 0xe3, 0xe0, 0x16, 0x53, 0xf5, 0x4d,
+	0xd5, 0x9a, 0x5b, 0x18, 0xba, 0xc7, 0x19, 0xd9, 0x1b, 0xa3, 0xa9, 0xa6, 0xad, 0x4b, 0xad, 0xf8,
+	0x02, 0x67, 0xfe, 0x78, 0x0a, 0xd8, 0x6b, 0x70, 0xf2, 0x59, 0x5b, 0xe2, 0xc8, 0x19, 0x66, 0xeb,
+	0x15, 0xd8, 0x69, 0x59, 0xa9, 0x8e, 0xdb, 0x18, 0xdb, 0x9d, 0x01, 0x76, 0x09, 0x9e, 0x50, 0x63,
+	0x57, 0xd6, 0xea, 0xc8, 0x1d, 0x1c, 0x78, 0x87, 0x27, 0x36, 0xdf, 0x91, 0x6d, 0xaf, 0x8e, 0xba,
+	0xec, 0x47, 0xee, 0xae, 0xc9, 0x66, 0x21, 0x7c, 0x7d, 0x0a, 0x4c, 0xdf, 0xa7, 0xb2, 0x9b, 0x14,
+	0xf7, 0xd6, 0x64, 0x13, 0x08, 0xfb, 0xc1, 0x80, 0xf9, 0xeb, 0x48, 0x75, 0x4a, 0xab, 0x86, 0xfb,
+	0x6b, 0xb2, 0xf1, 0x84, 0xdb, 0xcc, 0xc8, 0x56, 0x00, 0x61, 0xd3, 0xa8, 0x26, 0x1e, 0x87, 0xfa,
+	0x9e, 0x03, 0xd6, 0x41, 0x79, 0x4e, 0xd8, 0x15, 0x04, 0x4f, 0xe6, 0xbc, 0xf1, 0x1c, 0x37, 0x82,
+	0xe6, 0xbf, 0xec, 0xed, 0x0f, 0x02, 0x17, 0xe7, 0x13, 0x93, 0xdf, 0x47, 0xc5, 0x3c, 0x58, 0xa6,
+	0x49, 0xf6, 0x91, 0x3e, 0x63, 0x2e, 0x2c, 0x12, 0x91, 0x50, 0xc2, 0x00, 0x9c, 0x42, 0x8a, 0x24,
+	0xbb, 0xa5, 0x16, 0xf3, 0xc1, 0xde, 0x66, 0x79, 0x14, 0xd3, 0x05, 0xbb, 0x00, 0x5f, 0x7e, 0xde,
+	0xc5, 0xd1, 0x97, 0x42, 0x0a, 0xba, 0x64, 0x01, 0x78, 0x69, 0x98, 0xdd, 0x22, 0xd9, 0x28, 0x67,
+	0x92, 0x3a, 0x46, 0xf8, 0x90, 0xe6, 0xa1, 0xa4, 0xae, 0xa9, 0xde, 0xe6, 0x79, 0x4a, 0x3d, 0x54,
+	0x93, 0xbb, 0xb8, 0x90, 0xe1, 0xdd, 0x8e, 0xfa, 0xdb, 0xe0, 0xf7, 0xe3, 0x8a, 0xfc, 0x79, 0x5c,
+	0x91, 0x9f, 0x7f, 0x57, 0xa4, 0x72, 0xf0, 0x32, 0xdf, 0xff, 0x1b, 0x00, 0xe6, 0x7c, 0xae, 0x44,
+	0x15, 0x02, 0x00, 0x00,
 }
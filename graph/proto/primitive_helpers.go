@@ -42,3 +42,13 @@ func (p Primitive) Key() interface{} {
 func (p *Primitive) IsSameLink(q *Primitive) bool {
 	return p.Subject == q.Subject && p.Predicate == q.Predicate && p.Object == q.Object && p.Label == q.Label
 }
+
+// ExistsAt checks if the primitive existed after applying a given epoch.
+// Zero epoch checks the latest state. Primitives added without an epoch exist at all epochs,
+// and primitives deleted without an epoch do not exist at any of them.
+func (p *Primitive) ExistsAt(epoch int64) bool {
+	if epoch == 0 {
+		return !p.Deleted
+	}
+	return p.AddedEpoch <= epoch && !(p.Deleted && p.DeletedEpoch <= epoch)
+}
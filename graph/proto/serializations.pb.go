@@ -39,6 +39,7 @@ type LogDelta struct {
 	Quad      *pquads.Quad `protobuf:"bytes,2,opt,name=Quad,json=quad" json:"Quad,omitempty"`
 	Action    int32        `protobuf:"varint,3,opt,name=Action,json=action,proto3" json:"Action,omitempty"`
 	Timestamp int64        `protobuf:"varint,4,opt,name=Timestamp,json=timestamp,proto3" json:"Timestamp,omitempty"`
+	Cid       string       `protobuf:"bytes,5,opt,name=Cid,json=cid,proto3" json:"Cid,omitempty"`
 }
 
 func (m *LogDelta) Reset()                    { *m = LogDelta{} }
@@ -74,6 +75,13 @@ func (m *LogDelta) GetTimestamp() int64 {
 	return 0
 }
 
+func (m *LogDelta) GetCid() string {
+	if m != nil {
+		return m.Cid
+	}
+	return ""
+}
+
 type HistoryEntry struct {
 	History []uint64 `protobuf:"varint,1,rep,packed,name=History,json=history" json:"History,omitempty"`
 }
@@ -167,6 +175,12 @@ func (m *LogDelta) MarshalTo(dAtA []byte) (int, error) {
 		i++
 		i = encodeVarintSerializations(dAtA, i, uint64(m.Timestamp))
 	}
+	if len(m.Cid) > 0 {
+		dAtA[i] = 0x2a
+		i++
+		i = encodeVarintSerializations(dAtA, i, uint64(len(m.Cid)))
+		i += copy(dAtA[i:], m.Cid)
+	}
 	return i, nil
 }
 
@@ -287,6 +301,10 @@ func (m *LogDelta) ProtoSize() (n int) {
 	if m.Timestamp != 0 {
 		n += 1 + sovSerializations(uint64(m.Timestamp))
 	}
+	l = len(m.Cid)
+	if l > 0 {
+		n += 1 + l + sovSerializations(uint64(l))
+	}
 	return n
 }
 
@@ -452,6 +470,35 @@ func (m *LogDelta) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Cid", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSerializations
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthSerializations
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Cid = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipSerializations(dAtA[iNdEx:])
@@ -824,25 +871,26 @@ var (
 func init() { proto1.RegisterFile("serializations.proto", fileDescriptorSerializations) }
 
 var fileDescriptorSerializations = []byte{
-	// 311 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x90, 0xdf, 0x4a, 0xf3, 0x30,
-	0x18, 0xc6, 0xc9, 0x9a, 0xfd, 0xcb, 0xf6, 0x7d, 0x07, 0x41, 0xa4, 0x0c, 0x19, 0x61, 0x9e, 0xe4,
-	0xc4, 0x16, 0x26, 0x5e, 0x80, 0x52, 0x41, 0x41, 0x06, 0x46, 0xd1, 0xe3, 0x77, 0x5b, 0xec, 0x02,
-	0xed, 0x52, 0x93, 0x54, 0xe8, 0xae, 0xc6, 0xcb, 0xf1, 0xd0, 0x6b, 0x98, 0x37, 0x22, 0x49, 0x37,
-	0xf0, 0x24, 0x79, 0x9e, 0x5f, 0xf2, 0x92, 0x1f, 0x21, 0x27, 0x56, 0x1a, 0x05, 0x85, 0xda, 0x81,
-	0x53, 0x7a, 0x6b, 0x93, 0xca, 0x68, 0xa7, 0x69, 0x37, 0x6c, 0x93, 0x8b, 0x5c, 0xb9, 0x4d, 0xbd,
-	0x4c, 0x56, 0xba, 0x4c, 0x73, 0x9d, 0xeb, 0x34, 0xe0, 0x65, 0xfd, 0x16, 0x5a, 0x28, 0x21, 0xb5,
-	0x53, 0x93, 0xab, 0x3f, 0xd7, 0x57, 0xd0, 0x14, 0xb2, 0xc9, 0x0d, 0x54, 0x9b, 0x43, 0x4e, 0xdf,
-	0x6b, 0x58, 0xa7, 0x95, 0x5f, 0x6d, 0xc8, 0x87, 0xc7, 0x66, 0x86, 0x0c, 0x1e, 0x74, 0x9e, 0xc9,
-	0xc2, 0x01, 0xfd, 0x4f, 0x3a, 0xf7, 0x59, 0x8c, 0x18, 0xe2, 0x58, 0x74, 0x54, 0x46, 0x19, 0xc1,
-	0x8f, 0x35, 0xac, 0xe3, 0x0e, 0x43, 0x7c, 0x34, 0x1f, 0x27, 0xed, 0x78, 0xe2, 0x99, 0xc0, 0x3e,
-	0xd3, 0x53, 0xd2, 0xbb, 0x5e, 0x79, 0xf7, 0x38, 0x62, 0x88, 0x77, 0x45, 0x0f, 0x42, 0xa3, 0x67,
-	0x64, 0xf8, 0xac, 0x4a, 0x69, 0x1d, 0x94, 0x55, 0x8c, 0x19, 0xe2, 0x91, 0x18, 0xba, 0x23, 0x98,
-	0x71, 0x32, 0xbe, 0x53, 0xd6, 0x69, 0xd3, 0xdc, 0x6e, 0x9d, 0x69, 0x68, 0x4c, 0xfa, 0x87, 0x1e,
-	0x23, 0x16, 0x71, 0x2c, 0xfa, 0x9b, 0xb6, 0xce, 0x5e, 0xc9, 0x60, 0xa1, 0xd7, 0x32, 0x03, 0x07,
-	0x94, 0x12, 0xbc, 0x80, 0x52, 0x06, 0xbf, 0xa1, 0xc0, 0x5b, 0x28, 0xa5, 0x67, 0x4f, 0x6a, 0x27,
-	0x83, 0x61, 0x24, 0xb0, 0x55, 0x3b, 0x49, 0xcf, 0x49, 0xf7, 0x03, 0x8a, 0x5a, 0x06, 0xa5, 0xd1,
-	0xfc, 0xdf, 0x51, 0xfb, 0xc5, 0x43, 0xd1, 0x9e, 0xdd, 0x8c, 0xbf, 0xf6, 0x53, 0xf4, 0xbd, 0x9f,
-	0xa2, 0xcf, 0x9f, 0x29, 0x5a, 0xf6, 0xc2, 0x5f, 0x5c, 0xfe, 0x06, 0x00, 0x00, 0xff, 0xff, 0xc4,
-	0x7e, 0x15, 0x5d, 0x90, 0x01, 0x00, 0x00,
+	// 323 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x90, 0xc1, 0x4a, 0xc3, 0x30,
+	0x18, 0xc7, 0xc9, 0x9a, 0x6e, 0x6b, 0x36, 0x45, 0x82, 0x48, 0x19, 0x32, 0xc2, 0xbc, 0xe4, 0x62,
+	0x0b, 0x13, 0x1f, 0x40, 0xad, 0xa0, 0x20, 0x03, 0xa3, 0xe8, 0xf9, 0x5b, 0x1b, 0xbb, 0x40, 0xbb,
+	0xd4, 0x36, 0x15, 0xba, 0xb3, 0x0f, 0xe2, 0xe3, 0x78, 0xf4, 0x19, 0xe6, 0x8b, 0x48, 0xd3, 0x0d,
+	0xbc, 0xb4, 0xff, 0xdf, 0x2f, 0xf9, 0xc8, 0x3f, 0x21, 0xc7, 0x95, 0x2c, 0x15, 0x64, 0x6a, 0x03,
+	0x46, 0xe9, 0x75, 0x15, 0x14, 0xa5, 0x36, 0x9a, 0xba, 0xf6, 0x37, 0x39, 0x4f, 0x95, 0x59, 0xd5,
+	0xcb, 0x20, 0xd6, 0x79, 0x98, 0xea, 0x54, 0x87, 0x56, 0x2f, 0xeb, 0x37, 0x4b, 0x16, 0x6c, 0xea,
+	0xa6, 0x26, 0x97, 0xff, 0xb6, 0xc7, 0xd0, 0x64, 0xb2, 0x49, 0x4b, 0x28, 0x56, 0xbb, 0x1c, 0xbe,
+	0xd7, 0x90, 0x84, 0x45, 0xfb, 0xad, 0x6c, 0xde, 0x1d, 0x36, 0xfb, 0x44, 0x64, 0xf8, 0xa0, 0xd3,
+	0x48, 0x66, 0x06, 0xe8, 0x21, 0xe9, 0xdd, 0x47, 0x3e, 0x62, 0x88, 0x63, 0xd1, 0x53, 0x11, 0x65,
+	0x04, 0x3f, 0xd6, 0x90, 0xf8, 0x3d, 0x86, 0xf8, 0x68, 0x3e, 0x0e, 0xba, 0xf9, 0xa0, 0x75, 0x02,
+	0xb7, 0x99, 0x9e, 0x90, 0xfe, 0x55, 0xdc, 0x96, 0xf7, 0x1d, 0x86, 0xb8, 0x2b, 0xfa, 0x60, 0x89,
+	0x9e, 0x12, 0xef, 0x59, 0xe5, 0xb2, 0x32, 0x90, 0x17, 0x3e, 0x66, 0x88, 0x3b, 0xc2, 0x33, 0x7b,
+	0x41, 0x8f, 0x88, 0x73, 0xa3, 0x12, 0xdf, 0x65, 0x88, 0x7b, 0xc2, 0x89, 0x55, 0x32, 0xe3, 0x64,
+	0x7c, 0xa7, 0x2a, 0xa3, 0xcb, 0xe6, 0x76, 0x6d, 0xca, 0x86, 0xfa, 0x64, 0xb0, 0x63, 0x1f, 0x31,
+	0x87, 0x63, 0x31, 0x58, 0x75, 0x38, 0x7b, 0x25, 0xc3, 0x85, 0x4e, 0x64, 0x04, 0x06, 0x28, 0x25,
+	0x78, 0x01, 0xb9, 0xb4, 0x8d, 0x3d, 0x81, 0xd7, 0x90, 0xcb, 0xd6, 0x3d, 0xa9, 0x8d, 0xb4, 0x9d,
+	0x1d, 0x81, 0x2b, 0xb5, 0x91, 0xf4, 0x8c, 0xb8, 0x1f, 0x90, 0xd5, 0xd2, 0x96, 0x1c, 0xcd, 0x0f,
+	0xf6, 0x17, 0x79, 0x69, 0xa5, 0xe8, 0xd6, 0xae, 0xc7, 0xdf, 0xdb, 0x29, 0xfa, 0xd9, 0x4e, 0xd1,
+	0xd7, 0xef, 0x14, 0x2d, 0xfb, 0xf6, 0x79, 0x2e, 0xfe, 0x06, 0x00, 0x30, 0x2d, 0x0b, 0x53, 0xa3,
+	0x01, 0x00, 0x00,
 }
@@ -111,8 +111,35 @@ type QuadStore interface {
 	Close() error
 }
 
+// CidIndex is an optional interface for quad stores that keep track of storage deal roots (cids) the quads were added with.
+// A single cid can provide any number of quads, and a quad can be provided by multiple cids.
+type CidIndex interface {
+	// QuadsByCid returns all quads that were added with a given cid and were not deleted since.
+	QuadsByCid(ctx context.Context, cid string) ([]Ref, error)
+}
+
+// CidLister is an optional interface for CidIndex stores that can list all references from cids to quads.
+type CidLister interface {
+	// ForEachCid calls fn for each cid and each quad it references, in a stable order.
+	// Deleted quads are skipped. Iteration stops if fn returns an error.
+	ForEachCid(ctx context.Context, fn func(cid string, q Ref) error) error
+}
+
+// QuadLister is an optional interface for quad stores that can list all quads ordered by a stable position,
+// so an interrupted scan can be continued, see Migrate.
+type QuadLister interface {
+	// ForEachQuad calls fn for each quad with a position greater than a given one, in the order of positions.
+	// Positions are positive and do not change while the store is not modified. Deleted quads are skipped.
+	// Iteration stops if fn returns an error.
+	ForEachQuad(ctx context.Context, after int64, fn func(pos int64, q Ref) error) error
+}
+
 type Options map[string]interface{}
 
+// OptionReadOnly is set for stores opened in read-only mode. Backends must not write to the database
+// when opening it with this option, including upgrades of metadata.
+const OptionReadOnly = "read_only"
+
 var (
 	typeInt = reflect.TypeOf(int(0))
 )
@@ -72,6 +72,30 @@ type Handle struct {
 	QuadStore
 	QuadWriter
 	Listener
+
+	// Feed receives deltas applied by the writer and the listener of the handle, see SetFeed.
+	Feed *Feed
+}
+
+// SetFeed publishes deltas applied by the writer and the listener of the handle to a given feed.
+// It must be called before the listener is started.
+func (h *Handle) SetFeed(f *Feed) {
+	h.Feed = f
+	if p, ok := h.QuadWriter.(FeedPublisher); ok {
+		p.SetFeed(f)
+	}
+	if p, ok := h.Listener.(FeedPublisher); ok {
+		p.SetFeed(f)
+	}
+}
+
+// Subscribe reads deltas committed through the handle starting from a given sequence number issued by the feed
+// with a given ID, or from the next committed delta if the sequence number is zero. See Feed.Subscribe.
+func (h *Handle) Subscribe(id string, from uint64) (*FeedSubscription, error) {
+	if h.Feed == nil {
+		return nil, ErrNoFeed
+	}
+	return h.Feed.Subscribe(id, from)
 }
 
 type IgnoreOpts struct {
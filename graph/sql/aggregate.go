@@ -0,0 +1,345 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/iterator"
+	"github.com/epik-protocol/epik-gateway-backend/graph/refs"
+	"github.com/epik-protocol/epik-gateway-backend/query/shape"
+)
+
+const (
+	tagCount      = tagPref + "count"
+	tagSumInt     = tagPref + "sum_int"
+	tagSumFloat   = tagPref + "sum_float"
+	tagCountInt   = tagPref + "count_int"
+	tagCountFloat = tagPref + "count_float"
+)
+
+var _ Shape = AggregateSelect{}
+
+// AggregateSelect is a SQL query that groups rows of a Select by a column and computes an aggregate function
+// for each group. It is an equivalent of shape.Aggregate.
+//
+// Only count, countDistinct, sum and avg functions are supported. Sum and avg are computed from separate sums
+// of integer and float values to preserve the type of the result, thus nodes table is joined to the query.
+type AggregateSelect struct {
+	From    Select
+	Alias   string // alias for From subquery
+	Nodes   string // alias for nodes table; only used by sum and avg
+	Func    iterator.AggregateFunc
+	GroupBy string // column of From to group by; all rows form a single group if empty
+	Of      string // column of From to aggregate
+	As      string // tag to save an aggregate value to
+}
+
+func (s AggregateSelect) numeric() bool {
+	return s.Func == iterator.AggregateSum || s.Func == iterator.AggregateAvg
+}
+
+func (s AggregateSelect) Columns() []string {
+	var names []string
+	if s.GroupBy != "" {
+		names = append(names, tagNode)
+	}
+	if s.numeric() {
+		return append(names, tagSumInt, tagSumFloat, tagCountInt, tagCountFloat)
+	}
+	return append(names, tagCount)
+}
+
+func (s AggregateSelect) SQL(b *Builder) string {
+	of := FieldName{Table: s.Alias, Name: s.Of}.SQL(b)
+	var fields []string
+	if s.GroupBy != "" {
+		fields = append(fields, Field{Table: s.Alias, Name: s.GroupBy, Alias: tagNode}.SQL(b))
+	}
+	switch s.Func {
+	case iterator.AggregateCountDistinct:
+		fields = append(fields, "COUNT(DISTINCT "+of+") AS "+tagCount)
+	case iterator.AggregateSum, iterator.AggregateAvg:
+		vint := FieldName{Table: s.Nodes, Name: "value_int"}.SQL(b)
+		vfloat := FieldName{Table: s.Nodes, Name: "value_float"}.SQL(b)
+		fields = append(fields,
+			"SUM("+vint+") AS "+tagSumInt,
+			"SUM("+vfloat+") AS "+tagSumFloat,
+			"COUNT("+vint+") AS "+tagCountInt,
+			"COUNT("+vfloat+") AS "+tagCountFloat,
+		)
+	default:
+		fields = append(fields, "COUNT("+of+") AS "+tagCount)
+	}
+	parts := []string{
+		"SELECT " + strings.Join(fields, ", "),
+	}
+	from := "FROM " + Subquery{Query: s.From, Alias: s.Alias}.SQL(b)
+	if s.numeric() {
+		from += " LEFT JOIN " + Table{Name: "nodes", Alias: s.Nodes}.SQL(b) +
+			" ON " + FieldName{Table: s.Nodes, Name: "hash"}.SQL(b) + " = " + of
+	}
+	parts = append(parts, from)
+	if s.GroupBy != "" {
+		group := FieldName{Table: s.Alias, Name: s.GroupBy}.SQL(b)
+		parts = append(parts, "WHERE "+group+" IS NOT NULL", "GROUP BY "+group)
+	}
+	return strings.Join(parts, "\n\t")
+}
+
+func (s AggregateSelect) Args() []Value {
+	return s.From.Args()
+}
+
+func (s AggregateSelect) BuildIterator(qs graph.QuadStore) iterator.Shape {
+	sq, ok := qs.(*QuadStore)
+	if !ok {
+		return iterator.NewError(fmt.Errorf("not a SQL quadstore: %T", qs))
+	}
+	return sq.newAggregateIterator(s)
+}
+
+func (s AggregateSelect) Optimize(ctx context.Context, r shape.Optimizer) (shape.Shape, bool) {
+	return s, false
+}
+
+// scan reads a group key and an aggregate value from the query output.
+// The value is nil if it's not defined for the group, for example an average of an empty set.
+func (s AggregateSelect) scan(r *sql.Rows) (graph.Ref, quad.Value, error) {
+	var (
+		key       NodeHash
+		cnt       int64
+		sumInt    sql.NullInt64
+		sumFloat  sql.NullFloat64
+		cntInt    int64
+		cntFloat  int64
+		dst       []interface{}
+		isNumeric = s.numeric()
+	)
+	if s.GroupBy != "" {
+		dst = append(dst, &key)
+	}
+	if isNumeric {
+		dst = append(dst, &sumInt, &sumFloat, &cntInt, &cntFloat)
+	} else {
+		dst = append(dst, &cnt)
+	}
+	if err := r.Scan(dst...); err != nil {
+		return nil, nil, err
+	}
+	var val quad.Value
+	switch s.Func {
+	case iterator.AggregateSum:
+		if cntFloat != 0 {
+			val = quad.Float(sumFloat.Float64 + float64(sumInt.Int64))
+		} else {
+			val = quad.Int(sumInt.Int64)
+		}
+	case iterator.AggregateAvg:
+		if n := cntInt + cntFloat; n != 0 {
+			val = quad.Float((sumFloat.Float64 + float64(sumInt.Int64)) / float64(n))
+		}
+	default:
+		val = quad.Int(cnt)
+	}
+	if s.GroupBy == "" {
+		return nil, val, nil
+	}
+	return key, val, nil
+}
+
+func (qs *QuadStore) newAggregateIterator(s AggregateSelect) *AggregateIterator {
+	return &AggregateIterator{
+		qs:    qs,
+		query: s,
+	}
+}
+
+// AggregateIterator executes an AggregateSelect query.
+type AggregateIterator struct {
+	qs    *QuadStore
+	query AggregateSelect
+}
+
+func (it *AggregateIterator) Iterate() iterator.Scanner {
+	return &aggregateNext{aggregateBase: aggregateBase{qs: it.qs, query: it.query}}
+}
+
+func (it *AggregateIterator) Lookup() iterator.Index {
+	return &aggregateContains{aggregateBase: aggregateBase{qs: it.qs, query: it.query}}
+}
+
+func (it *AggregateIterator) Stats(ctx context.Context) (iterator.Costs, error) {
+	if it.query.GroupBy == "" {
+		return iterator.Costs{
+			NextCost:     1,
+			ContainsCost: 1,
+			Size: refs.Size{
+				Value: 1,
+				Exact: it.query.Func != iterator.AggregateAvg,
+			},
+		}, nil
+	}
+	sz, err := it.qs.querySize(ctx, it.query.From)
+	sz.Exact = false
+	return iterator.Costs{
+		NextCost:     1,
+		ContainsCost: sz.Value,
+		Size:         sz,
+	}, err
+}
+
+func (it *AggregateIterator) Optimize(ctx context.Context) (iterator.Shape, bool) {
+	return it, false
+}
+
+func (it *AggregateIterator) SubIterators() []iterator.Shape {
+	return nil
+}
+
+func (it *AggregateIterator) String() string {
+	return it.query.SQL(NewBuilder(it.qs.flavor.QueryDialect))
+}
+
+type aggregateBase struct {
+	qs    *QuadStore
+	query AggregateSelect
+
+	err error
+	key graph.Ref
+	val quad.Value
+}
+
+func (it *aggregateBase) Result() graph.Ref {
+	if it.query.GroupBy != "" {
+		return it.key
+	}
+	if it.val == nil {
+		return nil
+	}
+	return refs.PreFetched(it.val)
+}
+
+func (it *aggregateBase) TagResults(dst map[string]graph.Ref) {
+	if it.query.GroupBy != "" && it.key != nil {
+		dst[it.query.GroupBy] = it.key
+	}
+	if it.query.As != "" && it.val != nil {
+		dst[it.query.As] = refs.PreFetched(it.val)
+	}
+}
+
+func (it *aggregateBase) Err() error {
+	return it.err
+}
+
+func (it *aggregateBase) NextPath(ctx context.Context) bool {
+	return false
+}
+
+func (it *aggregateBase) String() string {
+	return it.query.SQL(NewBuilder(it.qs.flavor.QueryDialect))
+}
+
+type aggregateNext struct {
+	aggregateBase
+	cursor *sql.Rows
+}
+
+func (it *aggregateNext) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	if it.cursor == nil {
+		it.cursor, it.err = it.qs.Query(ctx, it.query)
+		if it.err != nil {
+			return false
+		}
+	}
+	for it.cursor.Next() {
+		it.key, it.val, it.err = it.query.scan(it.cursor)
+		if it.err != nil {
+			return false
+		}
+		if it.Result() != nil {
+			return true
+		}
+	}
+	it.key, it.val = nil, nil
+	it.err = it.cursor.Err()
+	return false
+}
+
+func (it *aggregateNext) Close() error {
+	if it.cursor != nil {
+		it.cursor.Close()
+		it.cursor = nil
+	}
+	return nil
+}
+
+type aggregateContains struct {
+	aggregateBase
+	groups map[interface{}]aggregateGroup
+}
+
+type aggregateGroup struct {
+	key graph.Ref
+	val quad.Value
+}
+
+// load runs the query and indexes all groups by their keys, or by values if the query has no groups.
+func (it *aggregateContains) load(ctx context.Context) error {
+	rows, err := it.qs.Query(ctx, it.query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	it.groups = make(map[interface{}]aggregateGroup)
+	for rows.Next() {
+		key, val, err := it.query.scan(rows)
+		if err != nil {
+			return err
+		}
+		if it.query.GroupBy != "" {
+			it.groups[refs.ToKey(key)] = aggregateGroup{key: key, val: val}
+		} else if val != nil {
+			it.groups[val] = aggregateGroup{val: val}
+		}
+	}
+	return rows.Err()
+}
+
+func (it *aggregateContains) Contains(ctx context.Context, v graph.Ref) bool {
+	it.key, it.val = nil, nil
+	if it.err != nil {
+		return false
+	}
+	if it.groups == nil {
+		if it.err = it.load(ctx); it.err != nil {
+			return false
+		}
+	}
+	var key interface{}
+	if it.query.GroupBy != "" {
+		key = refs.ToKey(v)
+	} else {
+		key = it.qs.NameOf(v)
+	}
+	if key == nil {
+		return false
+	}
+	g, ok := it.groups[key]
+	if ok {
+		it.key, it.val = g.key, g.val
+	}
+	return ok
+}
+
+func (it *aggregateContains) Close() error {
+	it.groups = nil
+	return nil
+}
@@ -0,0 +1,295 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/clog"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	graphlog "github.com/epik-protocol/epik-gateway-backend/graph/log"
+	"github.com/epik-protocol/epik-gateway-backend/graph/refs"
+)
+
+const metaEpoch = "epoch"
+
+// ensureMeta creates tables for cids, metadata and locks, if they do not exist yet.
+// It allows to open databases created before these tables were introduced.
+func ensureMeta(conn *sql.DB, fl Registration) error {
+	for _, q := range []string{fl.cidsTable(), fl.metaTable(), fl.locksTable()} {
+		if _, err := conn.Exec(q); err != nil {
+			err = fl.Error(err)
+			clog.Errorf("Cannot create metadata table: %v", err)
+			return err
+		}
+	}
+	var n int64
+	err := conn.QueryRow(`SELECT COUNT(*) FROM meta WHERE name = `+fl.Placeholder(1)+`;`, metaEpoch).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err = conn.Exec(`INSERT INTO meta(name, value) VALUES (`+fl.Placeholder(1)+`, 0);`, metaEpoch)
+	}
+	return err
+}
+
+// readEpoch reads the epoch stored in the metadata table. The epoch is not cached,
+// since it may be advanced by other processes sharing the database.
+func (qs *QuadStore) readEpoch(ctx context.Context) (int64, error) {
+	var epoch int64
+	err := qs.db.QueryRowContext(ctx, `SELECT value FROM meta WHERE name = `+qs.flavor.Placeholder(1)+`;`, metaEpoch).Scan(&epoch)
+	if err == sql.ErrNoRows {
+		// not initialized yet, only possible for read-only opens
+		return 0, nil
+	}
+	return epoch, err
+}
+
+func setEpoch(tx *sql.Tx, p []string, epoch int64) error {
+	_, err := tx.Exec(`UPDATE meta SET value = `+p[0]+` WHERE name = `+p[1]+`;`, epoch, metaEpoch)
+	if err != nil {
+		clog.Errorf("couldn't exec UPDATE meta statement: %v", err)
+	}
+	return err
+}
+
+// cidsBatch is the maximal number of quads referenced by a single cid INSERT statement.
+// It keeps the number of statement parameters below limits of all supported databases.
+const cidsBatch = 1000
+
+// addCids references added quads from cids of their deltas.
+// References are inserted by a single statement for each batch of quads: horizons are selected from
+// the quads table and references that already exist are ignored.
+func addCids(tx *sql.Tx, fl Registration, in []graph.Delta, quads []graphlog.QuadUpdate) error {
+	var (
+		query strings.Builder
+		args  []interface{}
+	)
+	flush := func() error {
+		if len(args) == 0 {
+			return nil
+		}
+		if fl.InsertIgnore {
+			query.WriteString(`;`)
+		} else {
+			query.WriteString(` ON CONFLICT (cid, horizon) DO NOTHING;`)
+		}
+		_, err := tx.Exec(query.String(), args...)
+		if err != nil {
+			clog.Errorf("couldn't exec INSERT statement: %v", err)
+		}
+		query.Reset()
+		args = args[:0]
+		return err
+	}
+	for _, d := range quads {
+		cid := in[d.Ind].Cid
+		if cid == "" {
+			continue
+		}
+		if len(args) == 0 {
+			if fl.InsertIgnore {
+				query.WriteString(`INSERT IGNORE INTO quad_cids(cid, horizon) `)
+			} else {
+				query.WriteString(`INSERT INTO quad_cids(cid, horizon) `)
+			}
+		} else {
+			query.WriteString(` UNION ALL `)
+		}
+		args = append(args, cid)
+		query.WriteString(`SELECT ` + fl.Placeholder(len(args)) + `, horizon FROM quads WHERE `)
+		for i, h := range d.Quad.Dirs() {
+			if i != 0 {
+				query.WriteString(` and `)
+			}
+			query.WriteString(quadHashFields[i])
+			if v := (NodeHash{h}).SQLValue(); v == nil {
+				query.WriteString(` is null`)
+			} else {
+				args = append(args, v)
+				query.WriteString(`=` + fl.Placeholder(len(args)))
+			}
+		}
+		if len(args) >= cidsBatch*len(quadHashFields) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// quadHashFields are columns of quad hashes, in the order of quad directions.
+var quadHashFields = []string{"subject_hash", "predicate_hash", "object_hash", "label_hash"}
+
+func scanQuadHashes(rows *sql.Rows) ([]QuadHashes, error) {
+	defer rows.Close()
+	var out []QuadHashes
+	for rows.Next() {
+		var (
+			q    QuadHashes
+			dirs [4]NodeHash
+		)
+		if err := rows.Scan(&dirs[0], &dirs[1], &dirs[2], &dirs[3]); err != nil {
+			return nil, err
+		}
+		for i, d := range quad.Directions {
+			q.Set(d, dirs[i].ValueHash)
+		}
+		out = append(out, q)
+	}
+	return out, rows.Err()
+}
+
+const selectQuadsByCid = `SELECT q.subject_hash, q.predicate_hash, q.object_hash, q.label_hash FROM quads q, quad_cids c WHERE c.cid = `
+
+// selectOwnQuadsByCid selects quads of the cid that are not provided by any other cid.
+const selectOwnQuadsByCid = selectQuadsByCid + `%s and q.horizon = c.horizon and NOT EXISTS (SELECT 1 FROM quad_cids o WHERE o.horizon = c.horizon and o.cid <> c.cid);`
+
+// expandCidDeletes replaces deletions by cid with deletions of all quads that are provided only by the cid,
+// and returns updated quad and node deltas. Cid references are removed as well, thus quads shared with other
+// cids are kept, until the last cid that provides them is deleted.
+func expandCidDeletes(tx *sql.Tx, p []string, in []graph.Delta, deltas *graphlog.Deltas, opts graph.IgnoreOpts) ([]graphlog.QuadUpdate, []graphlog.NodeUpdate, error) {
+	byCid := false
+	for _, d := range deltas.QuadDel {
+		if in[d.Ind].Cid != "" {
+			byCid = true
+			break
+		}
+	}
+	if !byCid {
+		return deltas.QuadDel, deltas.DecNode, nil
+	}
+	seen := make(map[refs.QuadHash]struct{}, len(deltas.QuadDel))
+	for _, d := range deltas.QuadDel {
+		if in[d.Ind].Cid == "" {
+			seen[d.Quad] = struct{}{}
+		}
+	}
+	dec := make(map[refs.ValueHash]int)
+	quads := make([]graphlog.QuadUpdate, 0, len(deltas.QuadDel))
+	for _, d := range deltas.QuadDel {
+		cid := in[d.Ind].Cid
+		if cid == "" {
+			quads = append(quads, d)
+			continue
+		}
+		rows, err := tx.Query(fmt.Sprintf(selectOwnQuadsByCid, p[0]), cid)
+		if err != nil {
+			return nil, nil, err
+		}
+		found, err := scanQuadHashes(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, q := range found {
+			if _, ok := seen[q.QuadHash]; ok {
+				continue
+			}
+			seen[q.QuadHash] = struct{}{}
+			quads = append(quads, graphlog.QuadUpdate{Ind: d.Ind, Quad: q.QuadHash, Del: true})
+			for _, h := range q.Dirs() {
+				if h.Valid() {
+					dec[h]--
+				}
+			}
+		}
+		res, err := tx.Exec(`DELETE FROM quad_cids WHERE cid = `+p[0]+`;`, cid)
+		if err != nil {
+			clog.Errorf("couldn't exec DELETE statement: %v", err)
+			return nil, nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, nil, err
+		} else if n == 0 && !opts.IgnoreMissing {
+			return nil, nil, &graph.DeltaError{Delta: in[d.Ind], Err: graph.ErrQuadNotExist}
+		}
+	}
+	nodes := make([]graphlog.NodeUpdate, 0, len(deltas.DecNode)+len(dec))
+	for _, n := range deltas.DecNode {
+		n.RefInc += dec[n.Hash]
+		delete(dec, n.Hash)
+		nodes = append(nodes, n)
+	}
+	for h, inc := range dec {
+		nodes = append(nodes, graphlog.NodeUpdate{Hash: h, RefInc: inc})
+	}
+	return quads, nodes, nil
+}
+
+var _ graph.CidIndex = (*QuadStore)(nil)
+
+func (qs *QuadStore) QuadsByCid(ctx context.Context, cid string) ([]graph.Ref, error) {
+	rows, err := qs.db.QueryContext(ctx, selectQuadsByCid+qs.flavor.Placeholder(1)+` and q.horizon = c.horizon ORDER BY q.horizon;`, cid)
+	if err != nil {
+		return nil, err
+	}
+	quads, err := scanQuadHashes(rows)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]graph.Ref, 0, len(quads))
+	for _, q := range quads {
+		out = append(out, q)
+	}
+	return out, nil
+}
+
+var _ graph.CidLister = (*QuadStore)(nil)
+
+func (qs *QuadStore) ForEachCid(ctx context.Context, fn func(cid string, q graph.Ref) error) error {
+	rows, err := qs.db.QueryContext(ctx, `SELECT c.cid, q.subject_hash, q.predicate_hash, q.object_hash, q.label_hash FROM quads q, quad_cids c WHERE q.horizon = c.horizon ORDER BY c.cid, c.horizon;`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid  string
+			q    QuadHashes
+			dirs [4]NodeHash
+		)
+		if err := rows.Scan(&cid, &dirs[0], &dirs[1], &dirs[2], &dirs[3]); err != nil {
+			return err
+		}
+		for i, d := range quad.Directions {
+			q.Set(d, dirs[i].ValueHash)
+		}
+		if err = fn(cid, q); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+var _ graph.QuadLister = (*QuadStore)(nil)
+
+// ForEachQuad lists quads in the order of their horizons.
+func (qs *QuadStore) ForEachQuad(ctx context.Context, after int64, fn func(pos int64, q graph.Ref) error) error {
+	rows, err := qs.db.QueryContext(ctx, `SELECT horizon, subject_hash, predicate_hash, object_hash, label_hash FROM quads WHERE horizon > `+
+		qs.flavor.Placeholder(1)+` ORDER BY horizon;`, after)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			pos  int64
+			q    QuadHashes
+			dirs [4]NodeHash
+		)
+		if err := rows.Scan(&pos, &dirs[0], &dirs[1], &dirs[2], &dirs[3]); err != nil {
+			return err
+		}
+		for i, d := range quad.Directions {
+			q.Set(d, dirs[i].ValueHash)
+		}
+		if err = fn(pos, q); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -31,6 +31,7 @@ type Registration struct {
 	ConditionalIndexes bool   // database supports conditional indexes
 	FillFactor         bool   // database supports fill percent on indexes
 	NoForeignKeys      bool   // database has no support for FKs
+	InsertIgnore       bool   // database uses INSERT IGNORE instead of ON CONFLICT DO NOTHING
 
 	QueryDialect
 	NoOffsetWithoutLimit bool // SELECT ... OFFSET can be used only with LIMIT
@@ -94,6 +95,34 @@ func (r Registration) quadsTable() string {
 );`
 }
 
+// cidsTable returns a definition of the table that maps each cid to quads it provides.
+// Quads are referenced by horizon, thus quads re-added after deletion are not referenced by old cids.
+func (r Registration) cidsTable() string {
+	return `CREATE TABLE IF NOT EXISTS quad_cids (
+	cid VARCHAR(128) NOT NULL,
+	horizon BIGINT NOT NULL,
+	PRIMARY KEY (cid, horizon)
+);`
+}
+
+// metaTable returns a definition of the table for store metadata, such as the indexed epoch.
+func (r Registration) metaTable() string {
+	return `CREATE TABLE IF NOT EXISTS meta (
+	name VARCHAR(64) PRIMARY KEY,
+	value BIGINT NOT NULL
+);`
+}
+
+// locksTable returns a definition of the table for locks held by processes sharing the database.
+// Expiration time is stored in unix nanoseconds.
+func (r Registration) locksTable() string {
+	return `CREATE TABLE IF NOT EXISTS locks (
+	name VARCHAR(64) PRIMARY KEY,
+	owner VARCHAR(255) NOT NULL,
+	expires BIGINT NOT NULL
+);`
+}
+
 func (r Registration) quadIndexes(options graph.Options) []string {
 	indexes := make([]string, 0, 10)
 	if r.ConditionalIndexes {
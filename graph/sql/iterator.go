@@ -27,12 +27,33 @@ import (
 	"github.com/epik-protocol/epik-gateway-backend/query/shape"
 )
 
-var _ shape.Optimizer = (*QuadStore)(nil)
+var (
+	_ shape.Optimizer = (*QuadStore)(nil)
+	_ shape.Explainer = (*QuadStore)(nil)
+)
 
 func (qs *QuadStore) OptimizeShape(ctx context.Context, s shape.Shape) (shape.Shape, bool) {
 	return qs.opt.OptimizeShape(ctx, s)
 }
 
+// ExplainShape implements shape.Explainer. It returns the SQL query and arguments of a Select or AggregateSelect shape.
+func (qs *QuadStore) ExplainShape(s shape.Shape) (string, bool) {
+	var sq Shape
+	switch s := s.(type) {
+	case Select:
+		sq = s
+	case AggregateSelect:
+		sq = s
+	default:
+		return "", false
+	}
+	qu, vals := qs.prepareQuery(sq)
+	if len(vals) == 0 {
+		return qu, true
+	}
+	return fmt.Sprintf("%s\nargs: %v", qu, vals), true
+}
+
 func (qs *QuadStore) prepareQuery(s Shape) (string, []interface{}) {
 	args := s.Args()
 	vals := make([]interface{}, 0, len(args))
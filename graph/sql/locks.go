@@ -0,0 +1,69 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+)
+
+var _ graph.LockStore = (*QuadStore)(nil)
+
+// lockOwner returns the current owner of the lock and its expiration time.
+func (qs *QuadStore) lockOwner(ctx context.Context, name string) (string, int64, error) {
+	var (
+		owner   string
+		expires int64
+	)
+	err := qs.db.QueryRowContext(ctx, `SELECT owner, expires FROM locks WHERE name = `+qs.flavor.Placeholder(1)+`;`, name).
+		Scan(&owner, &expires)
+	return owner, expires, err
+}
+
+// Lock acquires the lock with a compare-and-swap on the lock row, thus it is safe to call from different processes.
+func (qs *QuadStore) Lock(ctx context.Context, name, owner string, ttl time.Duration) error {
+	p := qs.flavor.Placeholder
+	now := time.Now()
+	expires := now.Add(ttl).UnixNano()
+	cur, curExpires, err := qs.lockOwner(ctx, name)
+	if err == sql.ErrNoRows {
+		_, err = qs.db.ExecContext(ctx, `INSERT INTO locks(name, owner, expires) VALUES (`+p(1)+`, `+p(2)+`, `+p(3)+`);`,
+			name, owner, expires)
+		if err != nil {
+			// another process might have inserted the lock first
+			if cur, _, err2 := qs.lockOwner(ctx, name); err2 == nil && cur != owner {
+				return graph.ErrLocked
+			}
+			return qs.flavor.Error(err)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if cur != owner && now.UnixNano() < curExpires {
+		return graph.ErrLocked
+	}
+	res, err := qs.db.ExecContext(ctx, `UPDATE locks SET owner = `+p(1)+`, expires = `+p(2)+
+		` WHERE name = `+p(3)+` AND owner = `+p(4)+` AND expires = `+p(5)+`;`,
+		owner, expires, name, cur, curExpires)
+	if err != nil {
+		return qs.flavor.Error(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		// the lock was taken or renewed concurrently
+		return graph.ErrLocked
+	}
+	return nil
+}
+
+func (qs *QuadStore) Unlock(ctx context.Context, name, owner string) error {
+	_, err := qs.db.ExecContext(ctx, `DELETE FROM locks WHERE name = `+qs.flavor.Placeholder(1)+` AND owner = `+qs.flavor.Placeholder(2)+`;`,
+		name, owner)
+	if err != nil {
+		return qs.flavor.Error(err)
+	}
+	return nil
+}
@@ -30,6 +30,7 @@ func init() {
 		BytesType:            `BLOB`,
 		HorizonType:          `SERIAL`,
 		TimeType:             `DATETIME(6)`,
+		InsertIgnore:         true,
 		QueryDialect:         QueryDialect,
 		NoOffsetWithoutLimit: true,
 		Error: func(err error) error {
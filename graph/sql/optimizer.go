@@ -97,6 +97,8 @@ func (opt *Optimizer) OptimizeShape(ctx context.Context, s shape.Shape) (shape.S
 		return opt.optimizeSave(s)
 	case shape.Page:
 		return opt.optimizePage(s)
+	case shape.Aggregate:
+		return opt.optimizeAggregate(s)
 	default:
 		return s, false
 	}
@@ -492,6 +494,48 @@ func (opt *Optimizer) optimizePage(s shape.Page) (shape.Shape, bool) {
 	return sel, true
 }
 
+func (opt *Optimizer) optimizeAggregate(s shape.Aggregate) (shape.Shape, bool) {
+	sel, ok := s.From.(Select)
+	if !ok {
+		return s, false
+	}
+	switch s.Func {
+	case iterator.AggregateCount, iterator.AggregateCountDistinct,
+		iterator.AggregateSum, iterator.AggregateAvg:
+	default:
+		// min and max compare values of different types, so they are computed by the iterator
+		return s, false
+	}
+	of := s.Of
+	if of == "" {
+		of = tagNode
+	}
+	var hasOf, hasGroup bool
+	for _, name := range sel.Columns() {
+		if name == of {
+			hasOf = true
+		}
+		if name == s.GroupBy {
+			hasGroup = true
+		}
+	}
+	if !hasOf || (s.GroupBy != "" && !hasGroup) {
+		return s, false
+	}
+	agg := AggregateSelect{
+		From:    sel,
+		Alias:   opt.nextTable(),
+		Func:    s.Func,
+		GroupBy: s.GroupBy,
+		Of:      of,
+		As:      s.As,
+	}
+	if agg.numeric() {
+		agg.Nodes = opt.nextTable()
+	}
+	return agg, true
+}
+
 func (opt *Optimizer) optimizeIntersect(s shape.Intersect) (shape.Shape, bool) {
 	var (
 		sels  []Select
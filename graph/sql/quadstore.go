@@ -261,7 +261,7 @@ func Init(typ string, addr string, options graph.Options) error {
 		}
 		tx.Commit()
 	}
-	return nil
+	return ensureMeta(conn, fl)
 }
 
 func New(typ string, addr string, options graph.Options) (graph.QuadStore, error) {
@@ -283,6 +283,26 @@ func New(typ string, addr string, options graph.Options) (graph.QuadStore, error
 		ids:     lru.New(1024),
 		noSizes: true, // Skip size checking by default.
 	}
+	// read-only opens must not write to the database, metadata of older databases is created on the next
+	// read-write open instead
+	readOnly, err := options.BoolKey(graph.OptionReadOnly, false)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !readOnly {
+		if err = ensureMeta(conn, fl); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if _, err = qs.readEpoch(context.Background()); err != nil {
+		conn.Close()
+		if readOnly {
+			return nil, fmt.Errorf("cannot load metadata, the database must be opened without read_only once to upgrade it: %v", err)
+		}
+		return nil, err
+	}
 	qs.opt.SetRegexpOp(qs.flavor.RegexpOp)
 	if qs.flavor.NoOffsetWithoutLimit {
 		qs.opt.NoOffsetWithoutLimit()
@@ -420,13 +440,25 @@ func (qs *QuadStore) ApplyDeltas(epoch int64, in []graph.Delta, opts graph.Ignor
 		if err != nil {
 			return err
 		}
+		if err = addCids(tx, qs.flavor, in, deltas.QuadAdd); err != nil {
+			return err
+		}
+		if epoch > 0 {
+			if err = setEpoch(tx, p, epoch); err != nil {
+				return err
+			}
+		}
+		quadDel, decNode, err := expandCidDeletes(tx, p, in, deltas, opts)
+		if err != nil {
+			return err
+		}
 		// quad delete is also generic, execute here
 		var (
 			deleteQuad   *sql.Stmt
 			deleteTriple *sql.Stmt
 		)
 		fixNodes := make(map[refs.ValueHash]int)
-		for _, d := range deltas.QuadDel {
+		for _, d := range quadDel {
 			dirs := make([]interface{}, 0, len(quad.Directions))
 			for _, h := range d.Quad.Dirs() {
 				dirs = append(dirs, NodeHash{h}.SQLValue())
@@ -469,7 +501,7 @@ func (qs *QuadStore) ApplyDeltas(epoch int64, in []graph.Delta, opts graph.Ignor
 				}
 			}
 		}
-		if len(deltas.DecNode) == 0 {
+		if len(decNode) == 0 {
 			return nil
 		}
 		// node update SQL is generic enough to run it here
@@ -477,7 +509,7 @@ func (qs *QuadStore) ApplyDeltas(epoch int64, in []graph.Delta, opts graph.Ignor
 		if err != nil {
 			return err
 		}
-		for _, n := range deltas.DecNode {
+		for _, n := range decNode {
 			n.RefInc += fixNodes[n.Hash]
 			if n.RefInc == 0 {
 				continue
@@ -724,6 +756,11 @@ func (qs *QuadStore) Stats(ctx context.Context, exact bool) (graph.Stats, error)
 	st.Quads.Value = qs.quads
 	st.Nodes.Value = qs.nodes
 	qs.mu.RUnlock()
+	epoch, err := qs.readEpoch(ctx)
+	if err != nil {
+		return graph.Stats{}, err
+	}
+	st.Epoch = epoch
 	if st.Quads.Value >= 0 {
 		return st, nil
 	}
@@ -735,7 +772,7 @@ func (qs *QuadStore) Stats(ctx context.Context, exact bool) (graph.Stats, error)
 		st.Quads.Exact = false
 		st.Nodes.Exact = false
 	}
-	err := qs.db.QueryRow(query("quads")).Scan(&st.Quads.Value)
+	err = qs.db.QueryRow(query("quads")).Scan(&st.Quads.Value)
 	if err != nil {
 		return graph.Stats{}, err
 	}
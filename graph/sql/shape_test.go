@@ -355,6 +355,46 @@ var shapeCases = []struct {
 		qu:   `SELECT t_5.object_hash AS __node FROM quads AS t_5, (SELECT t_3.subject_hash AS __node FROM quads AS t_3, (SELECT t_1.subject_hash AS __node FROM quads AS t_1, (SELECT subject_hash AS __node FROM quads WHERE predicate_hash = $1 AND object_hash = $2) AS t_2 WHERE t_1.predicate_hash = $3 AND t_1.object_hash = t_2.__node) AS t_4 WHERE t_3.predicate_hash = $4 AND t_3.object_hash = t_4.__node) AS t_6 WHERE t_5.predicate_hash = $5 AND t_5.subject_hash = t_6.__node`,
 		args: sVals("n", "k", "a", "s", "s"),
 	},
+	{
+		name: "group by",
+		s: shape.Aggregate{
+			From: shape.QuadsAction{
+				Result: quad.Subject,
+				Save: map[quad.Direction][]string{
+					quad.Object: {"target"},
+				},
+				Filter: map[quad.Direction]graph.Ref{
+					quad.Predicate: sVal("p"),
+				},
+			},
+			Func:    iterator.AggregateCount,
+			GroupBy: "target",
+			As:      "n",
+		},
+		qu: `SELECT t_1.target AS ` + tagNode + `, COUNT(t_1.` + tagNode + `) AS __count
+	FROM (SELECT subject_hash AS ` + tagNode + `, object_hash AS target
+	FROM quads
+	WHERE predicate_hash = $1) AS t_1
+	WHERE t_1.target IS NOT NULL
+	GROUP BY t_1.target`,
+		args: sVals("p"),
+	},
+	{
+		name: "sum",
+		s: shape.Aggregate{
+			From: shape.QuadsAction{
+				Result: quad.Object,
+				Filter: map[quad.Direction]graph.Ref{
+					quad.Predicate: sVal("p"),
+				},
+			},
+			Func: iterator.AggregateSum,
+			As:   "sum",
+		},
+		qu: `SELECT SUM(t_2.value_int) AS __sum_int, SUM(t_2.value_float) AS __sum_float, COUNT(t_2.value_int) AS __count_int, COUNT(t_2.value_float) AS __count_float
+	FROM (SELECT object_hash AS ` + tagNode + ` FROM quads WHERE predicate_hash = $1) AS t_1 LEFT JOIN nodes AS t_2 ON t_2.hash = t_1.` + tagNode,
+		args: sVals("p"),
+	},
 }
 
 func TestSQLShapes(t *testing.T) {
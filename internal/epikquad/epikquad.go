@@ -0,0 +1,336 @@
+// Package epikquad implements the line-based quad format used by files of the EpiK network.
+//
+// Each line contains 3 or 4 terms separated by " - ":
+//
+//	subject - predicate - object [- label]
+//
+// Terms in N-Quads syntax (IRIs, blank nodes and quoted literals) are decoded as in N-Quads,
+// any other term is read verbatim as a string literal. Blank lines are ignored.
+package epikquad
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/nquads"
+	"github.com/epik-protocol/epik-gateway-backend/clog"
+)
+
+const (
+	// Name is the name of the format in the quad format registry.
+	Name = "epik"
+
+	sep = " - "
+)
+
+func init() {
+	quad.RegisterFormat(quad.Format{
+		Name: Name,
+		Ext:  []string{".epik"},
+		Mime: []string{"text/x-epik"},
+		Reader: func(r io.Reader) quad.ReadCloser {
+			return NewReader(r, false)
+		},
+		Writer: func(w io.Writer) quad.WriteCloser { return NewWriter(w) },
+	})
+}
+
+// ParseError describes a malformed line.
+type ParseError struct {
+	// Line is a line number, starting from 1.
+	Line int `json:"line"`
+	// Column is a position of the malformed term in the line, in characters starting from 1.
+	Column int `json:"column"`
+	// Text is the content of the line.
+	Text string `json:"text"`
+	// Msg describes the problem.
+	Msg string `json:"error"`
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// Report summarizes the lines read from a file.
+type Report struct {
+	// Lines is a number of non-blank lines.
+	Lines int `json:"lines"`
+	// Quads is a number of quads that were read.
+	Quads int `json:"quads"`
+	// Dropped lists malformed lines that were skipped.
+	Dropped []*ParseError `json:"dropped,omitempty"`
+}
+
+// Reader decodes quads from the EpiK line format.
+//
+// Malformed lines are skipped and recorded in the report, unless the reader is strict.
+// A strict reader returns a *ParseError for the first malformed line.
+//
+// Lines with more than 4 terms are malformed for a strict reader. Other readers keep the
+// legacy behaviour and read the rest of such line, separators included, as a plain string label.
+type Reader struct {
+	r      *bufio.Reader
+	strict bool
+	line   int
+	report Report
+	err    error
+}
+
+// NewReader returns a reader that takes its input from r.
+func NewReader(r io.Reader, strict bool) *Reader {
+	return &Reader{r: bufio.NewReader(r), strict: strict}
+}
+
+// ReadQuad returns the next quad, or io.EOF if no quads are left.
+func (dec *Reader) ReadQuad() (quad.Quad, error) {
+	for dec.err == nil {
+		line, err := dec.r.ReadString('\n')
+		if err != nil {
+			dec.err = err
+			if line == "" {
+				break
+			}
+		}
+		dec.line++
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		dec.report.Lines++
+		q, perr := parseLine(line, dec.strict)
+		if perr != nil {
+			perr.Line = dec.line
+			if dec.strict {
+				dec.err = perr
+				return quad.Quad{}, perr
+			}
+			dec.report.Dropped = append(dec.report.Dropped, perr)
+			continue
+		}
+		dec.report.Quads++
+		return q, nil
+	}
+	return quad.Quad{}, dec.err
+}
+
+// Report returns the summary of lines read so far.
+func (dec *Reader) Report() Report {
+	return dec.report
+}
+
+// Close logs malformed lines that were skipped.
+func (dec *Reader) Close() error {
+	for _, e := range dec.report.Dropped {
+		clog.Warningf("skipped malformed %v", e)
+	}
+	if n := len(dec.report.Dropped); n != 0 {
+		clog.Warningf("skipped %d malformed lines out of %d", n, dec.report.Lines)
+	}
+	return nil
+}
+
+// parseLine decodes a single non-blank line. The line number of the error is set by the caller.
+// Unless the line is parsed strictly, extra terms are read as a part of the label, see Reader.
+func parseLine(line string, strict bool) (quad.Quad, *ParseError) {
+	var vals []quad.Value
+	for pos := 0; ; {
+		if len(vals) == 4 {
+			return quad.Quad{}, lineError(line, pos, "expected 3 or 4 terms, got more")
+		}
+		n := termLen(line[pos:])
+		if len(vals) == 3 && !strict && pos+n < len(line) {
+			vals = append(vals, quad.String(line[pos:]))
+			break
+		}
+		v, err := parseTerm(line[pos : pos+n])
+		if err != nil {
+			return quad.Quad{}, lineError(line, pos, err.Error())
+		}
+		vals = append(vals, v)
+		pos += n
+		if pos == len(line) {
+			break
+		}
+		pos += len(sep)
+	}
+	if len(vals) < 3 {
+		return quad.Quad{}, lineError(line, len(line), fmt.Sprintf("expected 3 or 4 terms, got %d", len(vals)))
+	}
+	q := quad.Quad{Subject: vals[0], Predicate: vals[1], Object: vals[2]}
+	if len(vals) == 4 {
+		q.Label = vals[3]
+	}
+	return q, nil
+}
+
+func lineError(line string, pos int, msg string) *ParseError {
+	return &ParseError{
+		Column: utf8.RuneCountInString(line[:pos]) + 1,
+		Text:   line,
+		Msg:    msg,
+	}
+}
+
+// termLen returns the length of the term at the beginning of s.
+//
+// Terms in N-Quads syntax may contain the separator, thus they are scanned first.
+// If the term is not followed by a separator, it is read as a plain string up to the next separator.
+func termLen(s string) int {
+	if n := nquadsTermLen(s); n > 0 && (n == len(s) || strings.HasPrefix(s[n:], sep)) {
+		return n
+	}
+	if i := strings.Index(s, sep); i >= 0 {
+		return i
+	}
+	return len(s)
+}
+
+// nquadsTermLen returns the length of the IRI, blank node or quoted literal at the beginning of s,
+// or zero if s does not start with one.
+func nquadsTermLen(s string) int {
+	switch {
+	case strings.HasPrefix(s, "<"):
+		return strings.IndexByte(s, '>') + 1
+	case strings.HasPrefix(s, "_:"):
+		if i := strings.IndexAny(s, " \t"); i >= 0 {
+			return i
+		}
+		return len(s)
+	case strings.HasPrefix(s, `"`):
+	default:
+		return 0
+	}
+	n := 1
+	for ; n < len(s) && s[n] != '"'; n++ {
+		if s[n] == '\\' {
+			n++
+		}
+	}
+	if n >= len(s) {
+		return 0
+	}
+	n++
+	if strings.HasPrefix(s[n:], "^^<") {
+		i := strings.IndexByte(s[n:], '>')
+		if i < 0 {
+			return 0
+		}
+		n += i + 1
+	} else if strings.HasPrefix(s[n:], "@") {
+		for n++; n < len(s) && (isAlnum(s[n]) || s[n] == '-'); n++ {
+		}
+	}
+	return n
+}
+
+func isAlnum(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// parseTerm decodes a single term.
+func parseTerm(s string) (quad.Value, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty term")
+	}
+	if !isNQuads(s) {
+		return quad.String(s), nil
+	}
+	q, err := nquads.Parse("<s> <p> " + s + " .")
+	if err == nil && q.Label != nil {
+		err = fmt.Errorf("unexpected %v", q.Label)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid term %s: %v", s, err)
+	}
+	return q.Object, nil
+}
+
+// isNQuads checks if the term is written in N-Quads syntax.
+func isNQuads(v string) bool {
+	if len(v) > 2 {
+		if (v[0] == '<' && v[len(v)-1] == '>') || (v[0] == '"' && v[len(v)-1] == '"') {
+			return true
+		} else if v[:2] == "_:" {
+			return true
+		} else if i := strings.Index(v, `"^^<`); i > 0 && v[0] == '"' && v[len(v)-1] == '>' {
+			return true
+		} else if i := strings.Index(v, `"@`); i > 0 && v[0] == '"' && v[len(v)-1] != '"' {
+			return true
+		}
+	}
+	return false
+}
+
+// Writer encodes quads to the EpiK line format.
+//
+// Strings are written verbatim if they can be read back as is, and quoted otherwise.
+type Writer struct {
+	bw  *bufio.Writer
+	buf bytes.Buffer
+	err error
+}
+
+// NewWriter returns a writer that writes to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{bw: bufio.NewWriter(w)}
+}
+
+func (enc *Writer) WriteQuad(q quad.Quad) error {
+	if enc.err != nil {
+		return enc.err
+	} else if !q.IsValid() {
+		return quad.ErrInvalid
+	}
+	enc.buf.Reset()
+	enc.writeValue(q.Subject)
+	enc.buf.WriteString(sep)
+	enc.writeValue(q.Predicate)
+	enc.buf.WriteString(sep)
+	enc.writeValue(q.Object)
+	if q.Label != nil {
+		enc.buf.WriteString(sep)
+		enc.writeValue(q.Label)
+	}
+	enc.buf.WriteByte('\n')
+	_, enc.err = enc.bw.Write(enc.buf.Bytes())
+	return enc.err
+}
+
+func (enc *Writer) writeValue(v quad.Value) {
+	if s, ok := v.(quad.String); ok && isPlain(string(s)) {
+		enc.buf.WriteString(string(s))
+		return
+	}
+	enc.buf.WriteString(v.String())
+}
+
+// isPlain checks if the string is read back as is when written verbatim.
+func isPlain(s string) bool {
+	return s != "" && !isNQuads(s) &&
+		strings.TrimSpace(s) == s &&
+		!strings.ContainsAny(s, "\r\n") &&
+		!strings.Contains(s, sep) &&
+		!strings.HasPrefix(s, "- ") && !strings.HasSuffix(s, " -") &&
+		nquadsTermLen(s) == 0
+}
+
+func (enc *Writer) WriteQuads(buf []quad.Quad) (int, error) {
+	for i, q := range buf {
+		if err := enc.WriteQuad(q); err != nil {
+			return i, err
+		}
+	}
+	return len(buf), nil
+}
+
+func (enc *Writer) Close() error {
+	if enc.err == nil {
+		enc.err = enc.bw.Flush()
+	}
+	return enc.err
+}
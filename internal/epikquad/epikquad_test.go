@@ -0,0 +1,108 @@
+package epikquad
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cayleygraph/quad"
+	"github.com/stretchr/testify/require"
+)
+
+const testFile = "<alice> - <name> - Alice \"A\"\r\n" +
+	"illegal line\n" +
+	"\n" +
+	"_:b - <follows> - <bob> - <graph>\n" +
+	"<bob> - <motto> - \"a - b\"@en\n" +
+	"<bob> - <age> - \"42\"^^<xsd:int> - <graph> - <extra>\n" +
+	"<bob> - <age> - \"42\"^^<xsd:int>"
+
+func readAll(t testing.TB, r *Reader) ([]quad.Quad, error) {
+	var out []quad.Quad
+	for {
+		q, err := r.ReadQuad()
+		if err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return out, err
+		}
+		out = append(out, q)
+	}
+}
+
+func TestReader(t *testing.T) {
+	r := NewReader(strings.NewReader(testFile), false)
+	quads, err := readAll(t, r)
+	require.NoError(t, err)
+	require.Equal(t, []quad.Quad{
+		quad.Make(quad.IRI("alice"), quad.IRI("name"), quad.String(`Alice "A"`), nil),
+		quad.Make(quad.BNode("b"), quad.IRI("follows"), quad.IRI("bob"), quad.IRI("graph")),
+		quad.Make(quad.IRI("bob"), quad.IRI("motto"), quad.LangString{Value: "a - b", Lang: "en"}, nil),
+		quad.Make(quad.IRI("bob"), quad.IRI("age"), quad.Int(42), quad.String("<graph> - <extra>")),
+		quad.Make(quad.IRI("bob"), quad.IRI("age"), quad.Int(42), nil),
+	}, quads)
+	require.Equal(t, Report{
+		Lines: 6,
+		Quads: 5,
+		Dropped: []*ParseError{
+			{Line: 2, Column: 13, Text: "illegal line", Msg: "expected 3 or 4 terms, got 1"},
+		},
+	}, r.Report())
+}
+
+func TestReaderStrict(t *testing.T) {
+	r := NewReader(strings.NewReader(testFile), true)
+	quads, err := readAll(t, r)
+	require.Len(t, quads, 1)
+	require.Equal(t, &ParseError{Line: 2, Column: 13, Text: "illegal line", Msg: "expected 3 or 4 terms, got 1"}, err)
+	require.EqualError(t, err, "line 2, column 13: expected 3 or 4 terms, got 1")
+
+	// extra terms are not read as the label
+	r = NewReader(strings.NewReader("<bob> - <age> - \"42\"^^<xsd:int> - <graph> - <extra>\n"), true)
+	_, err = readAll(t, r)
+	require.Equal(t, &ParseError{Line: 1, Column: 45, Text: "<bob> - <age> - \"42\"^^<xsd:int> - <graph> - <extra>", Msg: "expected 3 or 4 terms, got more"}, err)
+
+	// the error is reported at the malformed term
+	r = NewReader(strings.NewReader("<a> - <b> - <c> - <d> <e>\n"), true)
+	_, err = readAll(t, r)
+	require.Error(t, err)
+	require.Equal(t, 19, err.(*ParseError).Column)
+}
+
+func TestWriter(t *testing.T) {
+	quads := []quad.Quad{
+		quad.Make(quad.IRI("alice"), quad.IRI("name"), quad.String(`Alice "A"`), nil),
+		quad.Make(quad.BNode("b"), quad.IRI("follows"), quad.IRI("bob"), quad.IRI("graph")),
+		quad.Make(quad.IRI("bob"), quad.IRI("motto"), quad.String("a - b"), nil),
+		quad.Make(quad.IRI("bob"), quad.IRI("quote"), quad.String(`"a"`), nil),
+		quad.Make(quad.IRI("bob"), quad.IRI("dash"), quad.String("a -"), nil),
+		quad.Make(quad.IRI("bob"), quad.IRI("lines"), quad.String("a\nb"), nil),
+		quad.Make(quad.IRI("bob"), quad.IRI("age"), quad.Int(42), nil),
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	n, err := w.WriteQuads(quads)
+	require.NoError(t, err)
+	require.Equal(t, len(quads), n)
+	require.NoError(t, w.Close())
+	require.Equal(t, "<alice> - <name> - Alice \"A\"\n"+
+		"_:b - <follows> - <bob> - <graph>\n"+
+		"<bob> - <motto> - \"a - b\"\n"+
+		"<bob> - <quote> - \"\\\"a\\\"\"\n"+
+		"<bob> - <dash> - \"a -\"\n"+
+		"<bob> - <lines> - \"a\\nb\"\n"+
+		"<bob> - <age> - "+quad.Int(42).String()+"\n", buf.String())
+
+	got, err := readAll(t, NewReader(&buf, true))
+	require.NoError(t, err)
+	require.Equal(t, quads, got)
+}
+
+func TestFormat(t *testing.T) {
+	f := quad.FormatByName(Name)
+	require.NotNil(t, f)
+	require.Equal(t, f, quad.FormatByExt(".epik"))
+	require.NotNil(t, f.Reader)
+	require.NotNil(t, f.Writer)
+}
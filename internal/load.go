@@ -8,17 +8,27 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/cayleygraph/quad"
 	"github.com/cayleygraph/quad/nquads"
 	"github.com/epik-protocol/epik-gateway-backend/clog"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
 	"github.com/epik-protocol/epik-gateway-backend/internal/decompressor"
+	"github.com/epik-protocol/epik-gateway-backend/internal/epikquad"
 )
 
+// ReadOptions configures quad readers created by QuadReaderFor.
+type ReadOptions struct {
+	// Strict makes readers of formats that can skip malformed lines fail on them instead.
+	// Only the epikquad format supports it.
+	Strict bool
+}
+
 // Load loads a graph from the given path and write it to qw.  See
 // DecompressAndLoad for more information.
-func Load(qw quad.WriteCloser, batch int, path, typ string) error {
-	return DecompressAndLoad(qw, batch, path, typ)
+func Load(qw quad.WriteCloser, batch int, path, typ string, opts ReadOptions) error {
+	return DecompressAndLoad(qw, batch, path, typ, opts)
 }
 
 type readCloser struct {
@@ -40,7 +50,7 @@ type nopCloser struct {
 
 func (r nopCloser) Close() error { return nil }
 
-func QuadReaderFor(path, typ string) (quad.ReadCloser, error) {
+func QuadReaderFor(path, typ string, opts ReadOptions) (quad.ReadCloser, error) {
 	var (
 		r io.Reader
 		c io.Closer
@@ -109,7 +119,11 @@ func QuadReaderFor(path, typ string) (quad.ReadCloser, error) {
 			}
 			return nil, err
 		}
-		qr = format.Reader(r)
+		if format.Name == epikquad.Name {
+			qr = epikquad.NewReader(r, opts.Strict)
+		} else {
+			qr = format.Reader(r)
+		}
 	}
 	if c != nil {
 		return readCloser{ReadCloser: qr, close: c.Close}, nil
@@ -120,11 +134,11 @@ func QuadReaderFor(path, typ string) (quad.ReadCloser, error) {
 // DecompressAndLoad will load or fetch a graph from the given path, decompress
 // it, and then call the given load function to process the decompressed graph.
 // If no loadFn is provided, db.Load is called.
-func DecompressAndLoad(qw quad.WriteCloser, batch int, path, typ string) error {
+func DecompressAndLoad(qw quad.WriteCloser, batch int, path, typ string, opts ReadOptions) error {
 	if path == "" {
 		return nil
 	}
-	qr, err := QuadReaderFor(path, typ)
+	qr, err := QuadReaderFor(path, typ, opts)
 	if err != nil {
 		return err
 	}
@@ -137,6 +151,37 @@ func DecompressAndLoad(qw quad.WriteCloser, batch int, path, typ string) error {
 	return qw.Close()
 }
 
+// LoadBulk loads a graph from the given path into the quad store with a bulk writer, see graph.NewBulkWriter.
+// The file is parsed concurrently with writes. If progress is set, it is called after each written batch.
+func LoadBulk(qs graph.QuadStore, opts graph.BulkOptions, batch int, path, typ string, ropts ReadOptions, progress func(graph.BulkStats)) (graph.BulkStats, error) {
+	var st graph.BulkStats
+	if path == "" {
+		return st, nil
+	}
+	qr, err := QuadReaderFor(path, typ, ropts)
+	if err != nil {
+		return st, err
+	}
+	defer qr.Close()
+
+	qw, err := graph.NewBulkWriter(qs, opts)
+	if err != nil {
+		return st, err
+	}
+	start := time.Now()
+	st, err = graph.CopyBulk(qw, qr, batch, progress)
+	if err != nil {
+		qw.Close()
+		return st, fmt.Errorf("db: failed to load data: %v", err)
+	}
+	if err = qw.Close(); err != nil {
+		return st, err
+	}
+	// writes are only complete once the writer is closed
+	st.Duration = time.Since(start)
+	return st, nil
+}
+
 type batchLogger struct {
 	cnt int
 	w   quad.Writer
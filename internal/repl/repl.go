@@ -32,6 +32,7 @@ import (
 	"github.com/epik-protocol/epik-gateway-backend/clog"
 	"github.com/epik-protocol/epik-gateway-backend/graph"
 	"github.com/epik-protocol/epik-gateway-backend/query"
+	"github.com/epik-protocol/epik-gateway-backend/query/shape"
 )
 
 func trace(s string) (string, time.Time) {
@@ -78,6 +79,55 @@ func Run(ctx context.Context, qu string, ses query.REPLSession) error {
 	return nil
 }
 
+// Explain plans a query and prints plans of all iterators built for it, see shape.Trace.
+// If profile is set, the query is executed, and execution statistics of each iterator are printed as well.
+func Explain(ctx context.Context, qu string, ses query.REPLSession, profile bool) error {
+	tr := &shape.Trace{Profile: profile}
+	ctx = shape.WithTrace(ctx, tr)
+	startTrace, startTime := trace("Elapsed time: %g ms\n\n")
+	fmt.Printf("\n")
+	it, err := ses.Execute(ctx, qu, query.Options{
+		Collation: query.REPL,
+		Limit:     100,
+	})
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	nResults := 0
+	for it.Next(ctx) {
+		nResults++
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	for i, p := range tr.Plans(ctx) {
+		fmt.Printf("Plan %d\nShape: %s\nOptimized: %s\n", i+1, p.Shape, p.Optimized)
+		for _, b := range p.Backend {
+			fmt.Printf("Backend: %s\n", b)
+		}
+		fmt.Println("Iterators:")
+		printIterator(p.Iterator, 1)
+		fmt.Println()
+	}
+	if profile {
+		fmt.Printf("-----------\n%d Results\n", nResults)
+		un(startTrace, startTime)
+	}
+	return nil
+}
+
+func printIterator(d *shape.IteratorPlan, depth int) {
+	fmt.Printf("%s%s (size: %d, next cost: %d, contains cost: %d)", strings.Repeat("  ", depth), d.Name, d.Size, d.NextCost, d.ContainsCost)
+	if p := d.Profile; p != nil {
+		fmt.Printf(" [next: %d, results: %d, contains: %d, hits: %d, time: %v]", p.Next, p.Results, p.Contains, p.Hits, p.Time)
+	}
+	fmt.Println()
+	for _, sub := range d.Sub {
+		printIterator(sub, depth+1)
+	}
+}
+
 const (
 	defaultLanguage = "gizmo"
 
@@ -191,8 +241,17 @@ func Repl(ctx context.Context, h *graph.Handle, queryLanguage string, timeout ti
 				}
 				continue
 
+			case ":explain", ":profile":
+				nctx, cancel := newCtx()
+				err = Explain(nctx, strings.TrimSpace(args), ses, cmd == ":profile")
+				cancel()
+				if err != nil {
+					fmt.Println("Error: ", err)
+				}
+				continue
+
 			case "help":
-				fmt.Printf("Help\n\texit // Exit\n\thelp // this help\n\td: <quad> // delete quad\n\ta: <quad> // add quad\n\t:debug [t|f]\n")
+				fmt.Printf("Help\n\texit // Exit\n\thelp // this help\n\td: <quad> // delete quad\n\ta: <quad> // add quad\n\t:debug [t|f]\n\t:explain <query> // show query plans\n\t:profile <query> // execute a query and show query plans with statistics\n")
 				continue
 
 			case "exit":
@@ -426,6 +426,43 @@ func toViaDepthData(objs []interface{}) (predicates []interface{}, maxDepth int,
 	return
 }
 
+// toRouteOptions converts an options object of path finding steps: {via, maxDepth, reverse, weight}.
+func toRouteOptions(o interface{}) (opt path.RouteOptions, err error) {
+	if o == nil {
+		return opt, nil
+	}
+	m, ok := o.(map[string]interface{})
+	if !ok {
+		return opt, fmt.Errorf("expected options object, got: %T", o)
+	}
+	for k, v := range m {
+		switch k {
+		case "via":
+			vals, ok := v.([]interface{})
+			if !ok {
+				vals = []interface{}{v}
+			}
+			opt.Via, err = toQuadValues(vals)
+		case "maxDepth":
+			if opt.MaxDepth, ok = toInt(v); !ok {
+				err = fmt.Errorf("expected number for %q, got: %T", k, v)
+			}
+		case "reverse":
+			if opt.Reverse, ok = v.(bool); !ok {
+				err = fmt.Errorf("expected boolean for %q, got: %T", k, v)
+			}
+		case "weight":
+			opt.Weight, err = toQuadValue(v)
+		default:
+			err = fmt.Errorf("unknown option: %q", k)
+		}
+		if err != nil {
+			return opt, err
+		}
+	}
+	return opt, nil
+}
+
 func throwErr(vm *goja.Runtime, err error) goja.Value {
 	panic(vm.ToValue(err))
 }
@@ -15,10 +15,14 @@
 package gizmo
 
 import (
+	"context"
+	"errors"
+
 	"github.com/dop251/goja"
 
 	"github.com/cayleygraph/quad"
 	"github.com/epik-protocol/epik-gateway-backend/graph/iterator"
+	"github.com/epik-protocol/epik-gateway-backend/query/path"
 )
 
 const TopResultTag = "id"
@@ -167,6 +171,107 @@ func (p *pathObject) Count() (int64, error) {
 	return p.s.countResults(it)
 }
 
+var errStopRoutes = errors.New("stop routes iteration")
+
+// routeToNative converts a route to an object with "nodes", "edges" and "weight" fields.
+func (s *Session) routeToNative(r path.Route) map[string]interface{} {
+	nodes := make([]interface{}, 0, len(r.Nodes))
+	for _, v := range r.Nodes {
+		nodes = append(nodes, s.quadValueToNative(v))
+	}
+	edges := make([]interface{}, 0, len(r.Edges))
+	for _, q := range r.Edges {
+		e := map[string]interface{}{
+			"subject":   s.quadValueToNative(q.Subject),
+			"predicate": s.quadValueToNative(q.Predicate),
+			"object":    s.quadValueToNative(q.Object),
+		}
+		if q.Label != nil {
+			e["label"] = s.quadValueToNative(q.Label)
+		}
+		edges = append(edges, e)
+	}
+	return map[string]interface{}{
+		"nodes":  nodes,
+		"edges":  edges,
+		"weight": r.Weight,
+	}
+}
+
+func (p *pathObject) routeLimit(call goja.FunctionCall) (int, error) {
+	args := exportArgs(call.Arguments)
+	if len(args) > 1 {
+		return 0, errArgCount2{Expected: 1, Got: len(args)}
+	}
+	limit := -1
+	if len(args) > 0 {
+		limit, _ = toInt(args[0])
+	}
+	return limit, nil
+}
+
+// Routes executes the query and adds routes found by the last `shortestPath`, `allPaths` or `reachable` step
+// to the output set. Each route is an object with an ordered list of "nodes", a list of "edges" between them
+// (objects with "subject", "predicate", "object" and optional "label") and a "weight" of the route.
+// Signature: ([limit])
+//
+// Arguments:
+//
+// * `limit` (Optional): A maximal number of routes to return.
+//
+// Example:
+//
+//	// javascript
+//	// Returns {"nodes": ["<alice>", "<bob>", "<fred>"], "edges": [...], "weight": 2}
+//	g.V("<alice>").shortestPath(g.V("<fred>"), {via: "<follows>"}).routes()
+func (p *pathObject) Routes(call goja.FunctionCall) goja.Value {
+	limit, err := p.routeLimit(call)
+	if err != nil {
+		return throwErr(p.s.vm, err)
+	}
+	if len(call.Arguments) != 0 {
+		p.s.limit = limit
+	}
+	p.s.count = 0
+	ctx, cancel := context.WithCancel(p.s.context())
+	defer cancel()
+	err = p.path.IterateRoutes(ctx, func(r path.Route) error {
+		if !p.s.send(ctx, &Result{Val: p.s.routeToNative(r)}) {
+			return errStopRoutes
+		}
+		return nil
+	})
+	if err != nil && err != errStopRoutes {
+		return throwErr(p.s.vm, err)
+	}
+	return goja.Null()
+}
+
+// RouteArray is the same as Routes, but returns routes as a JS array instead of adding them to the output set.
+// Signature: ([limit])
+//
+// Example:
+//
+//	// javascript
+//	// Emits the list of nodes on the way from alice to fred: "<alice>", "<bob>", "<fred>"
+//	var route = g.V("<alice>").shortestPath(g.V("<fred>"), {via: "<follows>"}).routeArray()[0]
+//	g.emit(route.nodes)
+func (p *pathObject) RouteArray(call goja.FunctionCall) goja.Value {
+	limit, err := p.routeLimit(call)
+	if err != nil {
+		return throwErr(p.s.vm, err)
+	}
+	routes, err := p.path.Routes(p.s.context(), limit)
+	if err != nil {
+		return throwErr(p.s.vm, err)
+	}
+	out := make([]interface{}, 0, len(routes))
+	for _, r := range routes {
+		out = append(out, p.s.routeToNative(r))
+	}
+	return p.s.vm.ToValue(out)
+}
+
 // Backwards compatibility
 func (p *pathObject) CapitalizedGetLimit(limit int) error {
 	return p.GetLimit(limit)
@@ -32,6 +32,7 @@ import (
 	"github.com/epik-protocol/epik-gateway-backend/graph"
 	"github.com/epik-protocol/epik-gateway-backend/graph/iterator"
 	"github.com/epik-protocol/epik-gateway-backend/query"
+	"github.com/epik-protocol/epik-gateway-backend/query/shape"
 	"github.com/epik-protocol/epik-gateway-backend/schema"
 )
 
@@ -290,7 +291,12 @@ func (s *Session) Execute(ctx context.Context, qu string, opt query.Options) (qu
 	}
 	s.limit = opt.Limit
 	s.count = 0
+	tr := shape.TraceFrom(ctx)
 	ctx, cancel := context.WithCancel(context.Background())
+	if tr != nil {
+		// iterators are built on the session context, which must record plans of the query
+		ctx = shape.WithTrace(ctx, tr)
+	}
 	s.ctx = ctx
 	s.col = opt.Collation
 	return &results{
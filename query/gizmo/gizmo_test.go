@@ -395,6 +395,74 @@ var testQueries = []struct {
 		`,
 		expect: []string{"6"},
 	},
+	{
+		message: "use Aggregate with groups",
+		query: `
+				g.V().tag("follower").out("<follows>").tag("target").aggregate("count", "target", "follower", "n").all()
+		`,
+		tag:    "n",
+		expect: []string{intVal(3), intVal(1), intVal(2), intVal(2)},
+	},
+	{
+		message: "use Aggregate value",
+		query: `
+				g.V().save("<status>", "status").aggregate("countDistinct", null, "status").all()
+		`,
+		expect: []string{intVal(2)},
+	},
+	{
+		message: "use Aggregate with unknown function",
+		query: `
+				g.V().aggregate("median").all()
+		`,
+		err: true,
+	},
+	{
+		message: "use shortestPath",
+		query: `
+				g.V("<alice>").shortestPath(g.V("<greg>"), {via: "<follows>"}).all()
+		`,
+		expect: []string{"<greg>"},
+	},
+	{
+		message: "show shortestPath routes",
+		query: `
+				g.V("<alice>").shortestPath(g.V("<bob>")).routes()
+		`,
+		expect: []string{"map[edges:[map[object:<bob> predicate:<follows> subject:<alice>]] nodes:[<alice> <bob>] weight:1]"},
+	},
+	{
+		message: "use shortestPath route nodes",
+		query: `
+				var r = g.V("<alice>").shortestPath(g.V("<greg>"), {via: "<follows>"}).routeArray()
+				g.emit(r[0].nodes.join(" "))
+		`,
+		expect: []string{"<alice> <bob> <fred> <greg>"},
+	},
+	{
+		message: "use allPaths with depth",
+		query: `
+				g.V("<charlie>").allPaths(g.V("<greg>"), {via: ["<follows>"], maxDepth: 3}).routeArray().forEach(function(r) {
+					g.emit(r.nodes.join(" "))
+				})
+		`,
+		expect: []string{"<charlie> <bob> <fred> <greg>", "<charlie> <dani> <greg>"},
+	},
+	{
+		message: "use reachable",
+		query: `
+				g.V("<alice>", "<emily>", "<greg>").tag("source").reachable(g.V("<fred>"), {via: "<follows>"}).all()
+		`,
+		tag:    "source",
+		expect: []string{"<alice>", "<emily>"},
+	},
+	{
+		message: "use shortestPath with unknown option",
+		query: `
+				g.V("<alice>").shortestPath(null, {depth: 2}).all()
+		`,
+		err: true,
+	},
 
 	// Tag tests.
 	{
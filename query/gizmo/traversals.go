@@ -264,6 +264,91 @@ func (p *pathObject) FollowRecursive(call goja.FunctionCall) goja.Value {
 	return p.newVal(np)
 }
 
+func (p *pathObject) routes(call goja.FunctionCall, kind iterator.RouteKind) goja.Value {
+	args := exportArgs(call.Arguments)
+	if len(args) == 0 || len(args) > 2 {
+		return throwErr(p.s.vm, errArgCount{Got: len(args)})
+	}
+	var to *path.Path
+	switch a := args[0].(type) {
+	case nil:
+	case *path.Path:
+		to = a
+	default:
+		return throwErr(p.s.vm, fmt.Errorf("expected path, got: %T", a))
+	}
+	var opt path.RouteOptions
+	if len(args) == 2 {
+		var err error
+		if opt, err = toRouteOptions(args[1]); err != nil {
+			return throwErr(p.s.vm, err)
+		}
+	}
+	np := p.clonePath()
+	switch kind {
+	case iterator.RouteShortest:
+		np = np.ShortestPath(to, opt)
+	case iterator.RouteAll:
+		np = np.AllPaths(to, opt)
+	case iterator.RouteReachable:
+		np = np.Reachable(to, opt)
+	}
+	return p.newVal(np)
+}
+
+// ShortestPath finds the shortest route from each node of the path to each node of the target path.
+// Signature: (target, [options])
+//
+// Arguments:
+//
+// * `target`: A path with target nodes, or null to find routes to all reachable nodes.
+// * `options` (Optional): An object with the following fields:
+//   - `via`: A predicate or a list of predicates to follow. Any predicate is followed if not set.
+//   - `maxDepth`: A maximal number of edges in a route. Default is 50.
+//   - `reverse`: Follow predicates from objects to subjects.
+//   - `weight`: A predicate with a numeric cost of a step to a node. Steps to nodes without it cost 1.
+//
+// The path continues from the last nodes of found routes. Use `routes` to get routes themselves.
+//
+// Example:
+//
+//	// javascript
+//	// Returns a single route: alice -> bob -> fred -> greg.
+//	g.V("<alice>").shortestPath(g.V("<greg>"), {via: "<follows>"}).routes()
+func (p *pathObject) ShortestPath(call goja.FunctionCall) goja.Value {
+	return p.routes(call, iterator.RouteShortest)
+}
+
+// AllPaths finds all routes without repeated nodes from each node of the path to nodes of the target path.
+// Signature: (target, [options])
+//
+// Arguments are the same as for `shortestPath`, except that `weight` is ignored.
+// If target is null, all nodes within `maxDepth` steps are returned (k-hop neighbourhood).
+//
+// Example:
+//
+//	// javascript
+//	// Returns two routes: charlie -> dani -> greg and charlie -> bob -> fred -> greg.
+//	g.V("<charlie>").allPaths(g.V("<greg>"), {via: "<follows>", maxDepth: 3}).routes()
+func (p *pathObject) AllPaths(call goja.FunctionCall) goja.Value {
+	return p.routes(call, iterator.RouteAll)
+}
+
+// Reachable finds a route from each node of the path to the nearest node of the target path.
+// Nodes that cannot reach the target path are dropped.
+// Signature: (target, [options])
+//
+// Arguments are the same as for `shortestPath`, except that `weight` is ignored.
+//
+// Example:
+//
+//	// javascript
+//	// Returns alice and emily, since greg doesn't follow anyone.
+//	g.V("<alice>", "<emily>", "<greg>").tag("source").reachable(g.V("<fred>"), {via: "<follows>"}).all()
+func (p *pathObject) Reachable(call goja.FunctionCall) goja.Value {
+	return p.routes(call, iterator.RouteReachable)
+}
+
 // And is an alias for Intersect.
 func (p *pathObject) And(path *pathObject) *pathObject {
 	return p.Intersect(path)
@@ -707,6 +792,49 @@ func (p *pathObject) Order() *pathObject {
 	return p.new(np)
 }
 
+// Aggregate groups nodes by a tag and computes an aggregate value for each group.
+// Signature: (func, [groupBy], [of], [as])
+//
+// Arguments:
+//
+// * `func`: An aggregate function, one of "count", "countDistinct", "sum", "min", "max" or "avg".
+// * `groupBy` (Optional): A tag to group nodes by. If not set, the aggregate value is returned as a single node.
+// * `of` (Optional): A tag to aggregate values of. If not set, the function is applied to nodes of the path.
+// * `as` (Optional): A tag to save the aggregate value to.
+//
+// Example:
+//
+//	// javascript
+//	// Count followers of each person.
+//	// Returns:
+//	//   {"id": "<bob>", "target": "<bob>", "followers": 3},
+//	//   {"id": "<fred>", "target": "<fred>", "followers": 2},
+//	//   {"id": "<dani>", "target": "<dani>", "followers": 1},
+//	//   {"id": "<greg>", "target": "<greg>", "followers": 2}
+//	g.V().tag("follower").out("<follows>").tag("target").aggregate("count", "target", "follower", "followers").all()
+func (p *pathObject) Aggregate(call goja.FunctionCall) goja.Value {
+	args := exportArgs(call.Arguments)
+	if len(args) == 0 || len(args) > 4 {
+		return throwErr(p.s.vm, errArgCount{Got: len(args)})
+	}
+	var strs [4]string
+	for i, a := range args {
+		switch a := a.(type) {
+		case nil:
+		case string:
+			strs[i] = a
+		default:
+			return throwErr(p.s.vm, fmt.Errorf("expected string, got: %T", a))
+		}
+	}
+	fn, err := iterator.ParseAggregateFunc(strs[0])
+	if err != nil {
+		return throwErr(p.s.vm, err)
+	}
+	np := p.clonePath().Aggregate(fn, strs[1], strs[2], strs[3])
+	return p.newVal(np)
+}
+
 // Backwards compatibility
 func (p *pathObject) CapitalizedIs(call goja.FunctionCall) goja.Value {
 	return p.Is(call)
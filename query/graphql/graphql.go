@@ -137,11 +137,12 @@ type field struct {
 	Labels    []quad.Value
 	Has       []has
 	Fields    []field
-	AllFields bool // fetch all fields
-	UnNest    bool // all fields will be saved to parent object
+	AllFields bool                    // fetch all fields
+	UnNest    bool                    // all fields will be saved to parent object
+	Agg       *iterator.AggregateFunc // aggregate values of the field for each object
 }
 
-func (f field) isSave() bool { return len(f.Has)+len(f.Fields) == 0 && !f.AllFields }
+func (f field) isSave() bool { return len(f.Has)+len(f.Fields) == 0 && !f.AllFields && f.Agg == nil }
 
 type object struct {
 	id     graph.Ref
@@ -347,6 +348,16 @@ func iterateObject(ctx context.Context, qs graph.QuadStore, f *field, p *path.Pa
 			if f2.isSave() {
 				continue // skip flat values
 			}
+			if f2.Agg != nil {
+				v, err := aggregateField(ctx, qs, &f2, r.id)
+				if err != nil {
+					return out, err
+				}
+				if v != nil {
+					obj[f2.Alias] = v
+				}
+				continue
+			}
 			// start from saved id for a field node
 			p2 := path.StartPathNodes(qs, r.id)
 			if len(f2.Labels) != 0 {
@@ -389,6 +400,48 @@ func iterateObject(ctx context.Context, qs graph.QuadStore, f *field, p *path.Pa
 	return out, nil
 }
 
+// aggregateField computes an aggregate value of field values of a given object.
+// It returns nil if the value is not defined, for example an average of an empty set.
+func aggregateField(ctx context.Context, qs graph.QuadStore, f *field, id graph.Ref) (quad.Value, error) {
+	p := path.StartPathNodes(qs, id)
+	if len(f.Labels) != 0 {
+		p = p.LabelContext(f.Labels)
+	}
+	if f.Rev {
+		p = p.In(f.Via)
+	} else {
+		p = p.Out(f.Via)
+	}
+	for _, h := range f.Has {
+		switch h.Via {
+		case quad.IRI(ValueKey):
+			p = p.Is(h.Values...)
+		case quad.IRI(LimitKey), quad.IRI(SkipKey):
+			return nil, fmt.Errorf("%v is not supported for aggregated field %q", string(h.Via), f.Alias)
+		default:
+			if len(h.Labels) != 0 {
+				p = p.LabelContext(h.Labels)
+			}
+			if h.Rev {
+				p = p.HasReverse(h.Via, h.Values...)
+			} else {
+				p = p.Has(h.Via, h.Values...)
+			}
+			if len(h.Labels) != 0 {
+				p = p.LabelContext(f.Labels)
+			}
+		}
+	}
+	p = p.Aggregate(*f.Agg, "", "", "")
+
+	it := buildIterator(ctx, qs, p).Iterate()
+	defer it.Close()
+	if !it.Next(ctx) {
+		return nil, it.Err()
+	}
+	return qs.NameOf(it.Result()), nil
+}
+
 func (q *Query) Execute(ctx context.Context, qs graph.QuadStore) (map[string]interface{}, error) {
 	out := make(map[string]interface{})
 	for _, f := range q.fields {
@@ -539,6 +592,12 @@ func convField(fld *ast.Field, labels []quad.Value) (out field, err error) {
 			// already processed
 		case "unnest":
 			out.UnNest = true
+		case "aggregate":
+			fn, err := convAggregate(d)
+			if err != nil {
+				return out, err
+			}
+			out.Agg = &fn
 		default:
 			return out, fmt.Errorf("unknown directive: %q", d.Name.Value)
 		}
@@ -551,9 +610,29 @@ func convField(fld *ast.Field, labels []quad.Value) (out field, err error) {
 	if err != nil {
 		return
 	}
+	if out.Agg != nil && (len(out.Fields) != 0 || out.AllFields) {
+		return out, fmt.Errorf("cannot aggregate objects in %q", out.Alias)
+	}
 	return
 }
 
+// convAggregate returns an aggregate function of "aggregate" directive.
+func convAggregate(d *ast.Directive) (iterator.AggregateFunc, error) {
+	if len(d.Arguments) != 1 || d.Arguments[0].Name == nil || d.Arguments[0].Name.Value != "func" {
+		return 0, fmt.Errorf("aggregate directive should have 'func' argument")
+	}
+	var name string
+	switch v := d.Arguments[0].Value.(type) {
+	case *ast.EnumValue:
+		name = v.Value
+	case *ast.StringValue:
+		name = v.Value
+	default:
+		return 0, fmt.Errorf("unexpected aggregate function type: %T", v)
+	}
+	return iterator.ParseAggregateFunc(name)
+}
+
 func convValue(v ast.Value) (out []quad.Value, _ error) {
 	switch v := v.(type) {
 	case *ast.EnumValue:
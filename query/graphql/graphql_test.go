@@ -157,6 +157,38 @@ var casesExecute = []struct {
 			},
 		},
 	},
+	{
+		"aggregate fields",
+		`{
+  me(status: "cool_person") {
+    id: ` + ValueKey + `
+    follows @aggregate(func: count)
+    first: follows @aggregate(func: min)
+    followers: follows @rev @aggregate(func: "count")
+  }
+}`,
+		M{
+			"me": []M{
+				{
+					"id":        quad.IRI("bob"),
+					"follows":   quad.Int(1),
+					"first":     quad.IRI("fred"),
+					"followers": quad.Int(3),
+				},
+				{
+					"id":        quad.IRI("dani"),
+					"follows":   quad.Int(2),
+					"first":     quad.IRI("bob"),
+					"followers": quad.Int(1),
+				},
+				{
+					"id":        quad.IRI("greg"),
+					"follows":   quad.Int(0),
+					"followers": quad.Int(2),
+				},
+			},
+		},
+	},
 	{
 		"skip and limit",
 		`{
@@ -0,0 +1,92 @@
+package linkedql
+
+import (
+	"context"
+
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/jsonld"
+	"github.com/epik-protocol/epik-gateway-backend/query"
+	"github.com/epik-protocol/epik-gateway-backend/query/path"
+)
+
+var (
+	_ query.Iterator = (*RouteIterator)(nil)
+)
+
+// RouteIterator is an iterator of routes found by the last step of a path.
+type RouteIterator struct {
+	path   *path.Path
+	routes []path.Route
+	cur    *path.Route
+	loaded bool
+	err    error
+}
+
+// NewRouteIterator returns a new RouteIterator for a Path. The path must end with ShortestPath, AllPaths or Reachable.
+func NewRouteIterator(p *path.Path) *RouteIterator {
+	return &RouteIterator{path: p}
+}
+
+// Next implements query.Iterator.
+func (it *RouteIterator) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	if !it.loaded {
+		it.loaded = true
+		it.routes, it.err = it.path.Routes(ctx, 0)
+		if it.err != nil {
+			return false
+		}
+	}
+	if len(it.routes) == 0 {
+		it.cur = nil
+		return false
+	}
+	it.cur = &it.routes[0]
+	it.routes = it.routes[1:]
+	return true
+}
+
+// Result implements query.Iterator.
+func (it *RouteIterator) Result() interface{} {
+	if it.cur == nil {
+		return nil
+	}
+	nodes := make([]interface{}, 0, len(it.cur.Nodes))
+	for _, v := range it.cur.Nodes {
+		nodes = append(nodes, jsonld.FromValue(v))
+	}
+	edges := make([]interface{}, 0, len(it.cur.Edges))
+	for _, q := range it.cur.Edges {
+		edges = append(edges, edgeToJSONLD(q))
+	}
+	return map[string]interface{}{
+		"nodes":  nodes,
+		"edges":  edges,
+		"weight": it.cur.Weight,
+	}
+}
+
+func edgeToJSONLD(q quad.Quad) map[string]interface{} {
+	e := map[string]interface{}{
+		"subject":   jsonld.FromValue(q.Subject),
+		"predicate": jsonld.FromValue(q.Predicate),
+		"object":    jsonld.FromValue(q.Object),
+	}
+	if q.Label != nil {
+		e["label"] = jsonld.FromValue(q.Label)
+	}
+	return e
+}
+
+// Err implements query.Iterator.
+func (it *RouteIterator) Err() error {
+	return it.err
+}
+
+// Close implements query.Iterator.
+func (it *RouteIterator) Close() error {
+	it.routes, it.cur = nil, nil
+	return nil
+}
@@ -0,0 +1,42 @@
+package steps
+
+import (
+	"github.com/cayleygraph/quad/voc"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/iterator"
+	"github.com/epik-protocol/epik-gateway-backend/query/linkedql"
+	"github.com/epik-protocol/epik-gateway-backend/query/path"
+)
+
+func init() {
+	linkedql.Register(&Aggregate{})
+}
+
+var _ linkedql.PathStep = (*Aggregate)(nil)
+
+// Aggregate corresponds to .aggregate().
+type Aggregate struct {
+	From     linkedql.PathStep `json:"from"`
+	Function string            `json:"function"`
+	GroupBy  string            `json:"groupBy"`
+	Of       string            `json:"of"`
+	As       string            `json:"as"`
+}
+
+// Description implements Step.
+func (s *Aggregate) Description() string {
+	return "groups the resolved values of the from step by the values of the groupBy name and computes the function (count, countDistinct, sum, min, max or avg) of the values of the of name for each group. The result of the function is assigned to the as name. It resolves to the groups, or to the result of the function if groupBy is not provided."
+}
+
+// BuildPath implements linkedql.PathStep.
+func (s *Aggregate) BuildPath(qs graph.QuadStore, ns *voc.Namespaces) (*path.Path, error) {
+	fn, err := iterator.ParseAggregateFunc(s.Function)
+	if err != nil {
+		return nil, err
+	}
+	fromPath, err := s.From.BuildPath(qs, ns)
+	if err != nil {
+		return nil, err
+	}
+	return fromPath.Aggregate(fn, s.GroupBy, s.Of, s.As), nil
+}
@@ -0,0 +1,38 @@
+package steps
+
+import (
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/voc"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/query/linkedql"
+	"github.com/epik-protocol/epik-gateway-backend/query/path"
+)
+
+func init() {
+	linkedql.Register(&AllPaths{})
+}
+
+var _ linkedql.PathStep = (*AllPaths)(nil)
+
+// AllPaths corresponds to .allPaths().
+type AllPaths struct {
+	From       linkedql.PathStep `json:"from"`
+	To         linkedql.PathStep `json:"to"`
+	Properties []quad.IRI        `json:"properties"`
+	Reverse    bool              `json:"reverse"`
+	MaxDepth   int               `json:"maxDepth"`
+}
+
+// Description implements Step.
+func (s *AllPaths) Description() string {
+	return "finds all routes without repeated values from each of the resolved values of the from step to the resolved values of the to step, following the given properties (or any property) up to maxDepth times, in reverse if reverse is set. It resolves to the last values of the routes; use Routes to get the routes. If to is not provided, routes to all values within maxDepth steps are found."
+}
+
+// BuildPath implements linkedql.PathStep.
+func (s *AllPaths) BuildPath(qs graph.QuadStore, ns *voc.Namespaces) (*path.Path, error) {
+	fromPath, toPath, err := buildRoutePaths(qs, ns, s.From, s.To)
+	if err != nil {
+		return nil, err
+	}
+	return fromPath.AllPaths(toPath, routeOptions(ns, s.Properties, s.Reverse, s.MaxDepth)), nil
+}
@@ -0,0 +1,38 @@
+package steps
+
+import (
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/voc"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/query/linkedql"
+	"github.com/epik-protocol/epik-gateway-backend/query/path"
+)
+
+func init() {
+	linkedql.Register(&Reachable{})
+}
+
+var _ linkedql.PathStep = (*Reachable)(nil)
+
+// Reachable corresponds to .reachable().
+type Reachable struct {
+	From       linkedql.PathStep `json:"from"`
+	To         linkedql.PathStep `json:"to"`
+	Properties []quad.IRI        `json:"properties"`
+	Reverse    bool              `json:"reverse"`
+	MaxDepth   int               `json:"maxDepth"`
+}
+
+// Description implements Step.
+func (s *Reachable) Description() string {
+	return "finds a route from each of the resolved values of the from step to the nearest of the resolved values of the to step, following the given properties (or any property) up to maxDepth times, in reverse if reverse is set. It resolves to the last values of the routes; values that can't reach the to step are dropped. Use Routes to get the routes."
+}
+
+// BuildPath implements linkedql.PathStep.
+func (s *Reachable) BuildPath(qs graph.QuadStore, ns *voc.Namespaces) (*path.Path, error) {
+	fromPath, toPath, err := buildRoutePaths(qs, ns, s.From, s.To)
+	if err != nil {
+		return nil, err
+	}
+	return fromPath.Reachable(toPath, routeOptions(ns, s.Properties, s.Reverse, s.MaxDepth)), nil
+}
@@ -0,0 +1,70 @@
+package steps
+
+import (
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/voc"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/query/linkedql"
+	"github.com/epik-protocol/epik-gateway-backend/query/path"
+)
+
+func init() {
+	linkedql.Register(&ShortestPath{})
+}
+
+var _ linkedql.PathStep = (*ShortestPath)(nil)
+
+// ShortestPath corresponds to .shortestPath().
+type ShortestPath struct {
+	From       linkedql.PathStep `json:"from"`
+	To         linkedql.PathStep `json:"to"`
+	Properties []quad.IRI        `json:"properties"`
+	Reverse    bool              `json:"reverse"`
+	MaxDepth   int               `json:"maxDepth"`
+	Weight     quad.IRI          `json:"weight"`
+}
+
+// Description implements Step.
+func (s *ShortestPath) Description() string {
+	return "finds the shortest route from each of the resolved values of the from step to each of the resolved values of the to step, following the given properties (or any property) up to maxDepth times, in reverse if reverse is set. If weight is provided, the route with the smallest sum of the weight property values of its values is found. It resolves to the last values of the routes; use Routes to get the routes. If to is not provided, routes to all reachable values are found."
+}
+
+// BuildPath implements linkedql.PathStep.
+func (s *ShortestPath) BuildPath(qs graph.QuadStore, ns *voc.Namespaces) (*path.Path, error) {
+	fromPath, toPath, err := buildRoutePaths(qs, ns, s.From, s.To)
+	if err != nil {
+		return nil, err
+	}
+	opt := routeOptions(ns, s.Properties, s.Reverse, s.MaxDepth)
+	if s.Weight != "" {
+		opt.Weight = s.Weight.FullWith(ns)
+	}
+	return fromPath.ShortestPath(toPath, opt), nil
+}
+
+// buildRoutePaths builds source and target paths of path finding steps. The target path is nil if step is not set.
+func buildRoutePaths(qs graph.QuadStore, ns *voc.Namespaces, from, to linkedql.PathStep) (*path.Path, *path.Path, error) {
+	fromPath, err := from.BuildPath(qs, ns)
+	if err != nil {
+		return nil, nil, err
+	}
+	if to == nil {
+		return fromPath, nil, nil
+	}
+	toPath, err := to.BuildPath(qs, ns)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fromPath, toPath, nil
+}
+
+func routeOptions(ns *voc.Namespaces, properties []quad.IRI, reverse bool, maxDepth int) path.RouteOptions {
+	opt := path.RouteOptions{
+		Reverse:  reverse,
+		MaxDepth: maxDepth,
+	}
+	for _, p := range properties {
+		opt.Via = append(opt.Via, p.FullWith(ns))
+	}
+	return opt
+}
@@ -10,6 +10,7 @@ import (
 func init() {
 	linkedql.Register(&Select{})
 	linkedql.Register(&Documents{})
+	linkedql.Register(&Routes{})
 }
 
 var _ linkedql.IteratorStep = (*Select)(nil)
@@ -60,3 +61,24 @@ func (s *Documents) BuildIterator(qs graph.QuadStore, ns *voc.Namespaces) (query
 	}
 	return linkedql.NewDocumentIterator(it), nil
 }
+
+var _ linkedql.IteratorStep = (*Routes)(nil)
+
+// Routes corresponds to .routes().
+type Routes struct {
+	From linkedql.PathStep `json:"from"`
+}
+
+// Description implements Step.
+func (s *Routes) Description() string {
+	return "Routes returns routes found by the from step, which must be ShortestPath, AllPaths or Reachable. Each route is a document with an ordered list of nodes, a list of edges between them and a weight"
+}
+
+// BuildIterator implements IteratorStep
+func (s *Routes) BuildIterator(qs graph.QuadStore, ns *voc.Namespaces) (query.Iterator, error) {
+	p, err := s.From.BuildPath(qs, ns)
+	if err != nil {
+		return nil, err
+	}
+	return linkedql.NewRouteIterator(p), nil
+}
@@ -470,3 +470,38 @@ func countMorphism() morphism {
 		},
 	}
 }
+
+// aggregateMorphism will group values by a tag and compute an aggregate value for each group.
+func aggregateMorphism(fn iterator.AggregateFunc, groupBy, of, as string) morphism {
+	return morphism{
+		Reversal: func(ctx *pathContext) (morphism, *pathContext) {
+			return aggregateMorphism(fn, groupBy, of, as), ctx
+		},
+		Apply: func(in shape.Shape, ctx *pathContext) (shape.Shape, *pathContext) {
+			return shape.Aggregate{From: in, Func: fn, GroupBy: groupBy, Of: of, As: as}, ctx
+		},
+	}
+}
+
+// routesMorphism will find routes from current nodes to nodes of the target path and return the last node of each route.
+func routesMorphism(to *Path, kind iterator.RouteKind, opt RouteOptions) morphism {
+	return morphism{
+		Reversal: func(ctx *pathContext) (morphism, *pathContext) {
+			return routesMorphism(to, kind, opt), ctx
+		},
+		Apply: func(in shape.Shape, ctx *pathContext) (shape.Shape, *pathContext) {
+			s := shape.Routes{
+				From:     in,
+				Kind:     kind,
+				Via:      opt.Via,
+				Reverse:  opt.Reverse,
+				MaxDepth: opt.MaxDepth,
+				Weight:   opt.Weight,
+			}
+			if to != nil {
+				s.To = to.Shape()
+			}
+			return s, ctx
+		},
+	}
+}
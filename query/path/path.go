@@ -560,6 +560,59 @@ func (p *Path) Count() *Path {
 	return p
 }
 
+// Aggregate will group results by the groupBy tag and compute an aggregate value of the "of" tag for each group.
+//
+// If groupBy is empty, all results form a single group and the aggregate value becomes the only result.
+// If "of" is empty, the function is applied to results themselves. The aggregate value is saved to the "as" tag.
+func (p *Path) Aggregate(fn iterator.AggregateFunc, groupBy, of, as string) *Path {
+	p.stack = append(p.stack, aggregateMorphism(fn, groupBy, of, as))
+	return p
+}
+
+// RouteOptions controls which edges are followed by ShortestPath, AllPaths and Reachable.
+type RouteOptions struct {
+	// Via is a list of predicates to follow. Any predicate is followed if it's empty.
+	Via []quad.Value
+	// Reverse follows predicates from objects to subjects.
+	Reverse bool
+	// MaxDepth limits the number of edges in a route. If 0 is passed, the default value of 50 is used,
+	// or iterator.DefaultMaxRouteDepth for AllPaths.
+	MaxDepth int
+	// Weight is a predicate with a numeric cost of a step to a node. Steps to nodes without it cost 1.
+	// Only used by ShortestPath.
+	Weight quad.Value
+}
+
+// ShortestPath finds the shortest route from each node of the path to each node of the target path,
+// and returns the last nodes of these routes. Routes can be retrieved with IterateRoutes.
+//
+// If the target path is nil, routes to all reachable nodes are found. If opt.Weight is set,
+// the route with the smallest sum of weights is found instead of a route with the smallest number of edges.
+func (p *Path) ShortestPath(to *Path, opt RouteOptions) *Path {
+	np := p.clone()
+	np.stack = append(p.stack, routesMorphism(to, iterator.RouteShortest, opt))
+	return np
+}
+
+// AllPaths finds all routes without repeated nodes from each node of the path to nodes of the target path,
+// up to opt.MaxDepth edges long. If the target path is nil, all nodes within opt.MaxDepth steps are returned.
+// Routes can be retrieved with IterateRoutes.
+//
+// This is a very expensive operation in practice, thus MaxDepth is small by default. Be sure to keep it small.
+func (p *Path) AllPaths(to *Path, opt RouteOptions) *Path {
+	np := p.clone()
+	np.stack = append(p.stack, routesMorphism(to, iterator.RouteAll, opt))
+	return np
+}
+
+// Reachable finds a route from each node of the path to the nearest node of the target path.
+// Nodes that cannot reach the target path are dropped. Routes can be retrieved with IterateRoutes.
+func (p *Path) Reachable(to *Path, opt RouteOptions) *Path {
+	np := p.clone()
+	np.stack = append(p.stack, routesMorphism(to, iterator.RouteReachable, opt))
+	return np
+}
+
 // Iterate is an shortcut for graph.Iterate.
 func (p *Path) Iterate(ctx context.Context) *iterator.Chain {
 	return shape.Iterate(ctx, p.qs, p.Shape())
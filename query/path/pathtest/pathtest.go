@@ -339,6 +339,96 @@ func testSet(qs graph.QuadStore) []test {
 			path:    path.StartPath(qs).Has(vStatus).Count(),
 			expect:  []quad.Value{quad.Int(5)},
 		},
+		{
+			message: "Aggregate groups",
+			path: path.StartPath(qs).Tag("follower").Out(vFollows).Tag("target").
+				Aggregate(iterator.AggregateCount, "target", "follower", "n"),
+			expect: []quad.Value{vBob, vDani, vFred, vGreg},
+		},
+		{
+			message: "Aggregate count by group",
+			path: path.StartPath(qs).Tag("follower").Out(vFollows).Tag("target").
+				Aggregate(iterator.AggregateCount, "target", "follower", "n"),
+			tag:    "n",
+			expect: []quad.Value{quad.Int(1), quad.Int(2), quad.Int(2), quad.Int(3)},
+		},
+		{
+			message: "Aggregate max by group",
+			path: path.StartPath(qs).Tag("follower").Out(vFollows).Tag("target").
+				Aggregate(iterator.AggregateMax, "target", "follower", "last"),
+			tag:    "last",
+			expect: []quad.Value{vCharlie, vDani, vEmily, vFred},
+		},
+		{
+			message: "Aggregate distinct count",
+			path:    path.StartPath(qs).Save(vStatus, "status").Aggregate(iterator.AggregateCountDistinct, "", "status", ""),
+			expect:  []quad.Value{quad.Int(2)},
+		},
+		{
+			message: "Aggregate max",
+			path:    path.StartPath(qs).Out(vStatus).Aggregate(iterator.AggregateMax, "", "", ""),
+			expect:  []quad.Value{vSmart},
+		},
+		{
+			message: "Aggregate sum of non-numeric values",
+			path:    path.StartPath(qs).Out(vStatus).Aggregate(iterator.AggregateSum, "", "", "sum"),
+			expect:  []quad.Value{quad.Int(0)},
+		},
+		{
+			message: "Aggregate avg of non-numeric values",
+			path:    path.StartPath(qs).Out(vStatus).Aggregate(iterator.AggregateAvg, "", "", ""),
+			expect:  nil,
+		},
+		{
+			message: "ShortestPath",
+			path: path.StartPath(qs, vAlice).ShortestPath(path.StartPath(qs, vGreg), path.RouteOptions{
+				Via: []quad.Value{vFollows},
+			}),
+			expect: []quad.Value{vGreg},
+		},
+		{
+			message: "ShortestPath to any node",
+			path: path.StartPath(qs, vCharlie).ShortestPath(nil, path.RouteOptions{
+				Via: []quad.Value{vFollows},
+			}),
+			expect: []quad.Value{vBob, vDani, vFred, vGreg},
+		},
+		{
+			message: "ShortestPath with depth limit",
+			path: path.StartPath(qs, vAlice).ShortestPath(path.StartPath(qs, vGreg), path.RouteOptions{
+				Via: []quad.Value{vFollows}, MaxDepth: 2,
+			}),
+			expect: nil,
+		},
+		{
+			message: "ShortestPath reverse",
+			path: path.StartPath(qs, vFred).ShortestPath(path.StartPath(qs, vCharlie, vAlice), path.RouteOptions{
+				Via: []quad.Value{vFollows}, Reverse: true,
+			}),
+			expect: []quad.Value{vAlice, vCharlie},
+		},
+		{
+			message: "AllPaths up to depth",
+			path: path.StartPath(qs, vCharlie).AllPaths(path.StartPath(qs, vGreg), path.RouteOptions{
+				Via: []quad.Value{vFollows}, MaxDepth: 3,
+			}),
+			expect: []quad.Value{vGreg, vGreg},
+		},
+		{
+			message: "AllPaths k hops",
+			path: path.StartPath(qs, vDani).AllPaths(nil, path.RouteOptions{
+				Via: []quad.Value{vFollows}, MaxDepth: 2,
+			}),
+			expect: []quad.Value{vBob, vFred, vGreg},
+		},
+		{
+			message: "Reachable",
+			path: path.StartPath(qs, vAlice, vEmily, vGreg).Tag("source").Reachable(path.StartPath(qs, vFred), path.RouteOptions{
+				Via: []quad.Value{vFollows},
+			}),
+			tag:    "source",
+			expect: []quad.Value{vAlice, vEmily},
+		},
 		{
 			message: "double Has",
 			path:    path.StartPath(qs).Has(vStatus, vCool).Has(vFollows, vFred),
@@ -524,6 +614,7 @@ func RunTestMorphisms(t *testing.T, fnc testutil.DatabaseFunc) {
 	for _, ftest := range []func(*testing.T, testutil.DatabaseFunc){
 		testFollowRecursive,
 		testFollowRecursiveHas,
+		testRoutes,
 	} {
 		ftest(t, fnc)
 	}
@@ -700,3 +791,87 @@ func testFollowRecursiveHas(t *testing.T, fnc testutil.DatabaseFunc) {
 		})
 	}
 }
+
+func testRoutes(t *testing.T, fnc testutil.DatabaseFunc) {
+	var (
+		vA, vB, vC, vD = quad.IRI("a"), quad.IRI("b"), quad.IRI("c"), quad.IRI("d")
+		vRoad, vCost   = quad.IRI("road"), quad.IRI("cost")
+	)
+	qs, closer := makeTestStore(t, fnc, []quad.Quad{
+		quad.Make(vA, vRoad, vB, nil),
+		quad.Make(vB, vRoad, vD, nil),
+		quad.Make(vA, vRoad, vC, nil),
+		quad.Make(vC, vRoad, vD, nil),
+		quad.Make(vB, vCost, quad.Int(10), nil),
+		quad.Make(vC, vCost, quad.Int(2), nil),
+	}...)
+	defer closer()
+
+	road := []quad.Value{vRoad}
+	for _, c := range []struct {
+		name   string
+		path   *path.Path
+		expect []path.Route
+	}{
+		{
+			name: "weighted shortest path",
+			path: path.StartPath(qs, vA).ShortestPath(path.StartPath(qs, vD), path.RouteOptions{
+				Via: road, Weight: vCost,
+			}),
+			expect: []path.Route{{
+				Nodes:  []quad.Value{vA, vC, vD},
+				Edges:  []quad.Quad{quad.Make(vA, vRoad, vC, nil), quad.Make(vC, vRoad, vD, nil)},
+				Weight: 3,
+			}},
+		},
+		{
+			name: "all paths",
+			path: path.StartPath(qs, vA).AllPaths(path.StartPath(qs, vD), path.RouteOptions{
+				Via: road, MaxDepth: 2,
+			}),
+			expect: []path.Route{
+				{
+					Nodes:  []quad.Value{vA, vB, vD},
+					Edges:  []quad.Quad{quad.Make(vA, vRoad, vB, nil), quad.Make(vB, vRoad, vD, nil)},
+					Weight: 2,
+				},
+				{
+					Nodes:  []quad.Value{vA, vC, vD},
+					Edges:  []quad.Quad{quad.Make(vA, vRoad, vC, nil), quad.Make(vC, vRoad, vD, nil)},
+					Weight: 2,
+				},
+			},
+		},
+		{
+			name: "reachable",
+			path: path.StartPath(qs, vA, vD).Reachable(path.StartPath(qs, vB, vD), path.RouteOptions{
+				Via: road,
+			}),
+			expect: []path.Route{
+				{
+					Nodes:  []quad.Value{vA, vB},
+					Edges:  []quad.Quad{quad.Make(vA, vRoad, vB, nil)},
+					Weight: 1,
+				},
+				{
+					Nodes:  []quad.Value{vD},
+					Edges:  []quad.Quad{},
+					Weight: 0,
+				},
+			},
+		},
+	} {
+		t.Run("routes "+c.name, func(t *testing.T) {
+			got, err := c.path.Routes(context.TODO(), 0)
+			require.NoError(t, err)
+			sort.Slice(got, func(i, j int) bool {
+				return quad.ToString(got[i].Nodes[1%len(got[i].Nodes)]) < quad.ToString(got[j].Nodes[1%len(got[j].Nodes)])
+			})
+			require.Equal(t, c.expect, got)
+		})
+	}
+	t.Run("routes of a regular path", func(t *testing.T) {
+		_, err := path.StartPath(qs, vA).Out(vRoad).Routes(context.TODO(), 0)
+		require.Equal(t, path.ErrNoRoutes, err)
+	})
+}
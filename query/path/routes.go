@@ -0,0 +1,87 @@
+package path
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/iterator"
+	"github.com/epik-protocol/epik-gateway-backend/graph/refs"
+	"github.com/epik-protocol/epik-gateway-backend/query/shape"
+)
+
+// ErrNoRoutes is returned when routes are requested from a path that does not end
+// with ShortestPath, AllPaths or Reachable.
+var ErrNoRoutes = errors.New("path does not end with a path finding step")
+
+// Route is an ordered list of nodes and edges between them. Edges[i] connects Nodes[i] and Nodes[i+1].
+type Route struct {
+	Nodes  []quad.Value `json:"nodes"`
+	Edges  []quad.Quad  `json:"edges"`
+	Weight float64      `json:"weight"`
+}
+
+// IterateRoutes executes the path and calls fnc for each route found by the last step of the path.
+// The last step must be ShortestPath, AllPaths or Reachable. Iteration stops if fnc returns an error.
+func (p *Path) IterateRoutes(ctx context.Context, fnc func(Route) error) error {
+	if _, ok := p.Shape().(shape.Routes); !ok {
+		return ErrNoRoutes
+	}
+	qs := graph.Unwrap(p.qs)
+	s, _ := shape.Optimize(ctx, p.Shape(), qs)
+	if shape.IsNull(s) {
+		return nil
+	}
+	if _, ok := s.(shape.Routes); !ok {
+		return ErrNoRoutes
+	}
+	it, _ := s.BuildIterator(qs).Optimize(ctx)
+	sc, ok := it.Iterate().(iterator.RouteScanner)
+	if !ok {
+		return ErrNoRoutes
+	}
+	defer sc.Close()
+	for sc.Next(ctx) {
+		r, err := resolveRoute(ctx, qs, sc.Route())
+		if err != nil {
+			return err
+		}
+		if err = fnc(r); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+var errRoutesLimit = errors.New("routes limit reached")
+
+// Routes executes the path and returns routes found by the last step of the path.
+// The last step must be ShortestPath, AllPaths or Reachable. If limit is positive, at most limit routes are returned.
+func (p *Path) Routes(ctx context.Context, limit int) ([]Route, error) {
+	var out []Route
+	err := p.IterateRoutes(ctx, func(r Route) error {
+		out = append(out, r)
+		if limit > 0 && len(out) >= limit {
+			return errRoutesLimit
+		}
+		return nil
+	})
+	if err == errRoutesLimit {
+		err = nil
+	}
+	return out, err
+}
+
+// resolveRoute loads values of all nodes and edges of the route.
+func resolveRoute(ctx context.Context, qs graph.QuadStore, r iterator.Route) (Route, error) {
+	nodes, err := refs.ValuesOf(ctx, qs, r.Nodes)
+	if err != nil {
+		return Route{}, err
+	}
+	edges := make([]quad.Quad, 0, len(r.Edges))
+	for _, e := range r.Edges {
+		edges = append(edges, qs.Quad(e))
+	}
+	return Route{Nodes: nodes, Edges: edges, Weight: r.Weight}, nil
+}
@@ -67,6 +67,9 @@ const (
 type Options struct {
 	Limit     int
 	Collation Collation
+	// AsOf is a chain epoch to evaluate the query at, or zero to use the latest state of the graph.
+	// It is applied by Execute; sessions are bound to a quad store, see graph.AsOf.
+	AsOf int64
 }
 
 type Session interface {
@@ -136,6 +139,13 @@ func Execute(ctx context.Context, qs graph.QuadStore, lang, query string, opt Op
 	if l == nil {
 		return nil, fmt.Errorf("unsupported language: %q", lang)
 	}
+	if opt.AsOf != 0 {
+		var err error
+		qs, err = graph.AsOf(ctx, qs, opt.AsOf)
+		if err != nil {
+			return nil, err
+		}
+	}
 	sess := l.Session(qs)
 	return sess.Execute(ctx, query, opt)
 }
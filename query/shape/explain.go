@@ -0,0 +1,196 @@
+package shape
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/iterator"
+)
+
+// Explainer is an optional interface for quad stores that can describe shapes produced by their Optimizer.
+// For example, SQL backends return a query that is sent to the database.
+type Explainer interface {
+	// ExplainShape returns a description of a backend-specific shape.
+	// It returns false if the shape is not specific to the quad store.
+	ExplainShape(s Shape) (string, bool)
+}
+
+// Trace collects plans of all iterators built with BuildIterator for a query. See WithTrace.
+//
+// If Profile is not set, iterators are only planned, and BuildIterator returns an empty iterator,
+// thus queries produce no results. Note that some queries build iterators based on results of
+// other iterators, and such iterators will not be listed in explained plans.
+//
+// If Profile is set, each shape of the optimized tree collects execution statistics of its iterators.
+// Profile iterators are transparent to iterator optimizers, see iterator.Unprofile, but optimizers of
+// backends may not recognize wrapped iterators, thus the profiled plan may differ from the one used
+// to execute the query without profiling. Explain such queries to see their exact plan.
+type Trace struct {
+	Profile bool
+
+	mu    sync.Mutex
+	plans []*plan
+}
+
+type plan struct {
+	shape     string
+	optimized string
+	backend   []string
+	it        iterator.Shape
+}
+
+// Plan describes how a shape is executed.
+type Plan struct {
+	// Shape is the original query shape.
+	Shape string `json:"shape"`
+	// Optimized is the shape after optimizations.
+	Optimized string `json:"optimized"`
+	// Backend lists descriptions of backend-specific shapes, see Explainer.
+	Backend []string `json:"backend,omitempty"`
+	// Iterator is the iterator tree built for the optimized shape.
+	Iterator *IteratorPlan `json:"iterator"`
+}
+
+// IteratorPlan describes an iterator in the tree, its estimated costs, and execution statistics if it was profiled.
+type IteratorPlan struct {
+	Name         string                 `json:"name"`
+	ContainsCost int64                  `json:"contains_cost"`
+	NextCost     int64                  `json:"next_cost"`
+	Size         int64                  `json:"size"`
+	ExactSize    bool                   `json:"exact_size"`
+	Error        string                 `json:"error,omitempty"`
+	Profile      *iterator.ProfileStats `json:"profile,omitempty"`
+	Sub          []*IteratorPlan        `json:"sub,omitempty"`
+}
+
+type traceKey struct{}
+
+// WithTrace returns a context that records plans of all iterators built with BuildIterator to the trace.
+func WithTrace(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// TraceFrom returns a trace associated with the context, or nil.
+func TraceFrom(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// Plans returns plans of all iterators built so far. For profiled queries, it must be called after
+// the query is executed to include execution statistics.
+func (t *Trace) Plans(ctx context.Context) []Plan {
+	t.mu.Lock()
+	plans := append([]*plan{}, t.plans...)
+	t.mu.Unlock()
+	out := make([]Plan, 0, len(plans))
+	for _, p := range plans {
+		out = append(out, Plan{
+			Shape:     p.shape,
+			Optimized: p.optimized,
+			Backend:   p.backend,
+			Iterator:  describeIterator(ctx, p.it),
+		})
+	}
+	return out
+}
+
+// build records the plan of a shape and builds the iterator. The original shape is the one before optimizations.
+func (t *Trace) build(ctx context.Context, qs graph.QuadStore, orig, s Shape) iterator.Shape {
+	p := &plan{
+		shape:     fmt.Sprintf("%#v", orig),
+		optimized: fmt.Sprintf("%#v", s),
+		backend:   explainBackend(qs, s),
+	}
+	var it iterator.Shape
+	if IsNull(s) {
+		it = iterator.NewNull()
+	} else if t.Profile {
+		it = profileShapes(ctx, s).BuildIterator(qs)
+	} else {
+		// the same optimizations are applied to iterators when they are executed
+		it, _ = s.BuildIterator(qs).Optimize(ctx)
+	}
+	p.it = it
+	t.mu.Lock()
+	t.plans = append(t.plans, p)
+	t.mu.Unlock()
+	if !t.Profile {
+		return iterator.NewNull()
+	}
+	return it
+}
+
+// explainBackend describes backend-specific shapes in the tree, see Explainer.
+func explainBackend(qs graph.QuadStore, s Shape) []string {
+	e, ok := qs.(Explainer)
+	if !ok {
+		return nil
+	}
+	var out []string
+	Walk(s, func(s Shape) bool {
+		if d, ok := e.ExplainShape(s); ok {
+			out = append(out, d)
+			return false
+		}
+		return true
+	})
+	return out
+}
+
+func describeIterator(ctx context.Context, it iterator.Shape) *IteratorPlan {
+	var prof *iterator.ProfileStats
+	if p, ok := it.(*iterator.Profile); ok {
+		st := p.ProfileStats()
+		prof = &st
+		it = p.Unwrap()
+	}
+	d := &IteratorPlan{Name: it.String(), Profile: prof}
+	st, err := it.Stats(ctx)
+	if err != nil {
+		d.Error = err.Error()
+	}
+	d.ContainsCost, d.NextCost = st.ContainsCost, st.NextCost
+	d.Size, d.ExactSize = st.Size.Value, st.Size.Exact
+	for _, sub := range it.SubIterators() {
+		d.Sub = append(d.Sub, describeIterator(ctx, sub))
+	}
+	return d
+}
+
+// profileShapes wraps each shape in the tree to collect execution statistics of its iterators.
+// It must run after all other shape optimizations, since wrapped shapes are not recognized by them.
+// Iterators built from wrapped shapes are optimized later, see iterator.Unprofile.
+func profileShapes(ctx context.Context, s Shape) Shape {
+	s, _ = s.Optimize(ctx, profiler{})
+	if s == nil {
+		return Null{}
+	} else if _, ok := s.(profiled); !ok {
+		// the shape does not pass optimizers to its sub-shapes
+		s = profiled{Shape: s}
+	}
+	return s
+}
+
+type profiler struct{}
+
+func (profiler) OptimizeShape(ctx context.Context, s Shape) (Shape, bool) {
+	switch s.(type) {
+	case Null, profiled:
+		return s, false
+	}
+	return profiled{Shape: s}, true
+}
+
+// profiled is a shape that wraps iterators of a sub-shape with iterator.Profile.
+type profiled struct {
+	Shape
+}
+
+func (s profiled) BuildIterator(qs graph.QuadStore) iterator.Shape {
+	return iterator.NewProfile(s.Shape.BuildIterator(qs))
+}
+func (s profiled) Optimize(ctx context.Context, r Optimizer) (Shape, bool) {
+	return s, false
+}
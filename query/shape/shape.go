@@ -206,8 +206,10 @@ func IsNull(s Shape) bool {
 }
 
 // BuildIterator optimizes the shape and builds a corresponding iterator tree.
+// If the context has a Trace, the plan is recorded to it. See WithTrace.
 func BuildIterator(ctx context.Context, qs graph.QuadStore, s Shape) iterator.Shape {
 	qs = graph.Unwrap(qs)
+	orig := s
 	if s != nil {
 		if debugShapes || clog.V(2) {
 			clog.Infof("shape: %#v", s)
@@ -217,6 +219,9 @@ func BuildIterator(ctx context.Context, qs graph.QuadStore, s Shape) iterator.Sh
 			clog.Infof("optimized: %#v", s)
 		}
 	}
+	if t := TraceFrom(ctx); t != nil {
+		return t.build(ctx, qs, orig, s)
+	}
 	if IsNull(s) {
 		return iterator.NewNull()
 	}
@@ -433,6 +438,133 @@ func (s Count) Optimize(ctx context.Context, r Optimizer) (Shape, bool) {
 	return s, opt
 }
 
+// Aggregate groups objects in source by a tag and computes an aggregate value for each group.
+//
+// If GroupBy is set, it returns one object for each unique value of the GroupBy tag.
+// Otherwise, it returns an aggregate value as a single object.
+// Func is applied to values of the Of tag, or to objects in source if Of is not set.
+// The aggregate value is saved to the As tag, if it is set.
+type Aggregate struct {
+	From    Shape
+	Func    iterator.AggregateFunc
+	GroupBy string
+	Of      string
+	As      string
+}
+
+func (s Aggregate) BuildIterator(qs graph.QuadStore) iterator.Shape {
+	var it iterator.Shape
+	if IsNull(s.From) {
+		it = iterator.NewNull()
+	} else {
+		it = s.From.BuildIterator(qs)
+	}
+	return iterator.NewAggregate(it, qs, s.Func, s.GroupBy, s.Of, s.As)
+}
+
+// empty returns a shape for aggregation of an empty source.
+func (s Aggregate) empty() Shape {
+	if s.GroupBy != "" {
+		return nil
+	}
+	switch s.Func {
+	case iterator.AggregateCount, iterator.AggregateCountDistinct, iterator.AggregateSum:
+	default:
+		return nil
+	}
+	v := refs.PreFetched(quad.Int(0))
+	if s.As == "" {
+		return Fixed{v}
+	}
+	return FixedTags{Tags: map[string]refs.Ref{s.As: v}, On: Fixed{v}}
+}
+
+func (s Aggregate) Optimize(ctx context.Context, r Optimizer) (Shape, bool) {
+	if IsNull(s.From) {
+		return s.empty(), true
+	}
+	var opt bool
+	s.From, opt = s.From.Optimize(ctx, r)
+	if IsNull(s.From) {
+		return s.empty(), true
+	}
+	if r != nil {
+		ns, nopt := r.OptimizeShape(ctx, s)
+		return ns, opt || nopt
+	}
+	return s, opt
+}
+
+// Routes finds routes from objects in source to objects in target, and returns the last node of each route.
+//
+// Any node is a target if To is nil. Only predicates in Via are followed, or any predicate if Via is empty.
+// Weight is a predicate with a cost of reaching a node; it is only used by shortest routes.
+// See iterator.Routes for details.
+type Routes struct {
+	From     Shape
+	To       Shape
+	Kind     iterator.RouteKind
+	Via      []quad.Value
+	Reverse  bool
+	MaxDepth int
+	Weight   quad.Value
+}
+
+func (s Routes) BuildIterator(qs graph.QuadStore) iterator.Shape {
+	if IsNull(s.From) {
+		return iterator.NewNull()
+	}
+	opt := iterator.RouteOptions{
+		Reverse:  s.Reverse,
+		MaxDepth: s.MaxDepth,
+	}
+	for _, v := range s.Via {
+		if p := qs.ValueOf(v); p != nil {
+			opt.Via = append(opt.Via, p)
+		}
+	}
+	if len(s.Via) != 0 && len(opt.Via) == 0 {
+		// none of predicates exist
+		return iterator.NewNull()
+	}
+	if s.Weight != nil {
+		// all nodes cost the same if predicate does not exist
+		opt.Weight = qs.ValueOf(s.Weight)
+	}
+	var to iterator.Shape
+	if s.To != nil {
+		if IsNull(s.To) {
+			return iterator.NewNull()
+		}
+		to = s.To.BuildIterator(qs)
+	}
+	return iterator.NewRoutes(qs, s.From.BuildIterator(qs), to, s.Kind, opt)
+}
+
+func (s Routes) Optimize(ctx context.Context, r Optimizer) (Shape, bool) {
+	if IsNull(s.From) {
+		return nil, true
+	}
+	var opt bool
+	s.From, opt = s.From.Optimize(ctx, r)
+	if IsNull(s.From) {
+		return nil, true
+	}
+	if s.To != nil {
+		var topt bool
+		s.To, topt = s.To.Optimize(ctx, r)
+		if IsNull(s.To) {
+			return nil, true
+		}
+		opt = opt || topt
+	}
+	if r != nil {
+		ns, nopt := r.OptimizeShape(ctx, s)
+		return ns, opt || nopt
+	}
+	return s, opt
+}
+
 // QuadFilter is a constraint used to filter quads that have a certain set of values on a given direction.
 // Analog of LinksTo iterator.
 type QuadFilter struct {
@@ -352,6 +352,51 @@ var optimizeCases = []struct {
 			},
 		},
 	},
+	{
+		name: "sum of empty set",
+		from: Aggregate{
+			From: Intersect{Fixed{intVal(1)}, emptySet()},
+			Func: iterator.AggregateSum,
+			As:   "sum",
+		},
+		opt: true,
+		expect: FixedTags{
+			Tags: map[string]refs.Ref{"sum": refs.PreFetched(quad.Int(0))},
+			On:   Fixed{refs.PreFetched(quad.Int(0))},
+		},
+	},
+	{
+		name: "group empty set",
+		from: Aggregate{
+			From:    Intersect{Fixed{intVal(1)}, emptySet()},
+			Func:    iterator.AggregateCount,
+			GroupBy: "id",
+			As:      "n",
+		},
+		opt:    true,
+		expect: Null{},
+	},
+	{
+		name: "routes to empty set",
+		from: Routes{
+			From: Fixed{intVal(1)},
+			To:   Intersect{Fixed{intVal(2)}, emptySet()},
+			Kind: iterator.RouteShortest,
+		},
+		opt:    true,
+		expect: Null{},
+	},
+	{
+		name: "routes to any node",
+		from: Routes{
+			From: Fixed{intVal(1)},
+			Kind: iterator.RouteAll,
+		},
+		expect: Routes{
+			From: Fixed{intVal(1)},
+			Kind: iterator.RouteAll,
+		},
+	},
 }
 
 func TestOptimize(t *testing.T) {
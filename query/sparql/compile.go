@@ -0,0 +1,248 @@
+package sparql
+
+import (
+	"strconv"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/refs"
+	"github.com/epik-protocol/epik-gateway-backend/query/shape"
+)
+
+// aliasPrefix is a prefix of tags for repeated occurrences of variables in a pattern.
+// It cannot clash with variable names, which only contain name characters.
+const aliasPrefix = "#"
+
+// dirs is the order of quad directions in quad patterns.
+var dirs = [4]quad.Direction{quad.Subject, quad.Predicate, quad.Object, quad.Label}
+
+// node is a position of a quad pattern: either a variable, or a set of values.
+// A node without both matches any value.
+type node struct {
+	v      string
+	values shape.Shape
+}
+
+// compiler compiles a basic graph pattern into shapes.
+//
+// Each connected part of the pattern is compiled into a tree of shapes rooted at one of its variables.
+// Each variable is saved as a tag, and triple patterns which refer to the variable become constraints
+// on the nodes of the variable, which in turn refer to nodes of other variables of the triple.
+// If a variable is already a part of the tree (the pattern has a cycle), it is saved to an alias tag,
+// and its values are required to be equal to the values of the variable. See compiled.
+type compiler struct {
+	pats [][4]node
+	used []bool
+	// consts lists values that variables are known to be equal to
+	consts  map[string][]quad.Value
+	visited map[string]bool
+	aliases map[string]string
+}
+
+// compiled is a compiled part of a basic graph pattern.
+type compiled struct {
+	shape shape.Shape
+	// aliases maps alias tags to variables; results are only valid if values of both tags are equal
+	aliases map[string]string
+}
+
+// valid checks if all tags of a result are set, and values of aliases are equal to values of their variables.
+func (c compiled) valid(tags map[string]graph.Ref) bool {
+	for _, v := range tags {
+		// quads without a label do not match GRAPH patterns
+		if v == nil {
+			return false
+		}
+	}
+	for alias, v := range c.aliases {
+		if a, b := tags[alias], tags[v]; b == nil || !sameRef(a, b) {
+			return false
+		}
+	}
+	return true
+}
+
+// compileBGP compiles a basic graph pattern in a given graph into shapes, one for each connected part of the pattern.
+// Variables bound in the solution are replaced with their values.
+func compileBGP(bgp BGP, g graphScope, consts map[string][]quad.Value, b binding) []compiled {
+	c := &compiler{
+		used:    make([]bool, len(bgp)),
+		consts:  consts,
+		visited: make(map[string]bool),
+	}
+	term := func(t Term) node {
+		if !t.IsVar() {
+			return node{values: shape.Lookup{t.Value}}
+		} else if r, ok := b[t.Var]; ok {
+			return node{values: shape.Fixed{r}}
+		}
+		return node{v: t.Var}
+	}
+	label := node{}
+	if g.name != nil {
+		label = term(*g.name)
+		if label.v != "" && len(g.named) != 0 {
+			c.consts = withConst(c.consts, label.v, g.named)
+		}
+	} else if len(g.from) != 0 {
+		label = node{values: shape.Lookup(g.from)}
+	}
+	for _, t := range bgp {
+		c.pats = append(c.pats, [4]node{term(t.S), term(t.P), term(t.O), label})
+	}
+	var out []compiled
+	for i, p := range c.pats {
+		if c.used[i] {
+			continue
+		}
+		c.aliases = nil
+		root := ""
+		for _, n := range p {
+			if n.v != "" {
+				root = n.v
+				break
+			}
+		}
+		var s shape.Shape
+		if root != "" {
+			s = c.build(root)
+		} else {
+			// the pattern has no variables, thus only its existence is checked
+			c.used[i] = true
+			s = shape.NodesFrom{Dir: quad.Subject, Quads: c.quads(p, 0)}
+		}
+		out = append(out, compiled{shape: s, aliases: c.aliases})
+	}
+	return out
+}
+
+func withConst(consts map[string][]quad.Value, v string, vals []quad.Value) map[string][]quad.Value {
+	m := make(map[string][]quad.Value, len(consts)+1)
+	for k, c := range consts {
+		m[k] = c
+	}
+	m[v] = vals
+	return m
+}
+
+// build returns a shape for all values of the variable that satisfy unused triple patterns which refer to it.
+func (c *compiler) build(v string) shape.Shape {
+	c.visited[v] = true
+	var and shape.Intersect
+	if vals := c.consts[v]; len(vals) != 0 {
+		and = append(and, shape.Lookup(vals))
+	}
+	for i, p := range c.pats {
+		if c.used[i] {
+			continue
+		}
+		res := -1
+		for j, n := range p {
+			if n.v == v {
+				res = j
+				break
+			}
+		}
+		if res < 0 {
+			continue
+		}
+		c.used[i] = true
+		and = append(and, shape.NodesFrom{Dir: dirs[res], Quads: c.quads(p, res)})
+	}
+	var s shape.Shape
+	switch len(and) {
+	case 0:
+		s = shape.AllNodes{}
+	case 1:
+		s = and[0]
+	default:
+		s = and
+	}
+	return shape.Save{Tags: []string{v}, From: s}
+}
+
+// quads returns constraints of a quad pattern on all directions except the given one.
+func (c *compiler) quads(p [4]node, res int) shape.Quads {
+	var qs shape.Quads
+	for j, n := range p {
+		if j == res {
+			continue
+		}
+		var vals shape.Shape
+		switch {
+		case n.v == "":
+			if n.values == nil {
+				continue
+			}
+			vals = n.values
+		case c.visited[n.v]:
+			vals = shape.Save{Tags: []string{c.alias(n.v)}, From: shape.AllNodes{}}
+		default:
+			vals = c.build(n.v)
+		}
+		qs = append(qs, shape.QuadFilter{Dir: dirs[j], Values: vals})
+	}
+	return qs
+}
+
+func (c *compiler) alias(v string) string {
+	if c.aliases == nil {
+		c.aliases = make(map[string]string)
+	}
+	tag := aliasPrefix + strconv.Itoa(len(c.aliases)) + v
+	c.aliases[tag] = v
+	return tag
+}
+
+// filterConsts finds filters which require variables to be equal to IRIs, so the values can be used
+// directly in patterns. Filters are still evaluated.
+func filterConsts(filters []Expr) map[string][]quad.Value {
+	var out map[string][]quad.Value
+	var walk func(e Expr)
+	walk = func(e Expr) {
+		b, ok := e.(exprBinary)
+		if !ok {
+			return
+		}
+		switch b.Op {
+		case "&&":
+			walk(b.A)
+			walk(b.B)
+			return
+		case "=":
+		default:
+			return
+		}
+		v, ok1 := b.A.(exprVar)
+		c, ok2 := b.B.(exprValue)
+		if !ok1 || !ok2 {
+			v, ok1 = b.B.(exprVar)
+			c, ok2 = b.A.(exprValue)
+		}
+		if !ok1 || !ok2 {
+			return
+		}
+		// literals are compared by value, which may match different terms
+		if _, ok := c.Value.(quad.IRI); !ok {
+			return
+		}
+		if out == nil {
+			out = make(map[string][]quad.Value)
+		}
+		if prev, ok := out[string(v)]; ok && (len(prev) != 1 || prev[0] != c.Value) {
+			// contradicting filters
+			out[string(v)] = []quad.Value{}
+			return
+		}
+		out[string(v)] = []quad.Value{c.Value}
+	}
+	for _, f := range filters {
+		walk(f)
+	}
+	return out
+}
+
+// sameRef checks if two references point to the same value.
+func sameRef(a, b graph.Ref) bool {
+	return refs.ToKey(a) == refs.ToKey(b)
+}
@@ -0,0 +1,408 @@
+package sparql
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/iterator"
+	"github.com/epik-protocol/epik-gateway-backend/query/shape"
+)
+
+// binding is a solution of a pattern; it maps variables to values.
+type binding map[string]graph.Ref
+
+func (b binding) with(tags map[string]graph.Ref) binding {
+	out := make(binding, len(b)+len(tags))
+	for k, v := range b {
+		out[k] = v
+	}
+	for k, v := range tags {
+		if !strings.HasPrefix(k, aliasPrefix) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// solutions is an iterator over solutions of a pattern.
+type solutions interface {
+	Next(ctx context.Context) bool
+	Result() binding
+	Err() error
+	Close() error
+}
+
+// graphScope is a dataset that patterns are matched against.
+type graphScope struct {
+	// name is a graph of a GRAPH pattern; patterns are matched against the default graph if it is not set
+	name *Term
+	// from lists graphs that form the default graph
+	from []quad.Value
+	// named lists graphs that can be matched by GRAPH patterns
+	named []quad.Value
+}
+
+// evaluator evaluates graph patterns using a bind join: each pattern of a group is matched
+// once for each solution of preceding patterns, with variables of that solution replaced with their values.
+type evaluator struct {
+	qs graph.QuadStore
+}
+
+func (ev *evaluator) group(g *Group, gs graphScope, b binding) solutions {
+	consts := filterConsts(g.Filters)
+	var it solutions = &single{b: b}
+	for _, p := range g.Patterns {
+		switch p := p.(type) {
+		case BGP:
+			it = &join{left: it, right: func(b binding) solutions {
+				return ev.bgp(p, gs, consts, b)
+			}}
+		case *Group:
+			it = &join{left: it, right: func(b binding) solutions {
+				return ev.group(p, gs, b)
+			}}
+		case Union:
+			it = &join{left: it, right: func(b binding) solutions {
+				u := &concat{}
+				for _, g := range p {
+					g := g
+					u.next = append(u.next, func() solutions {
+						return ev.group(g, gs, b)
+					})
+				}
+				return u
+			}}
+		case Graph:
+			name := p.Name
+			it = &join{left: it, right: func(b binding) solutions {
+				return ev.group(p.Group, graphScope{name: &name, from: gs.from, named: gs.named}, b)
+			}}
+		case Optional:
+			it = &join{left: it, optional: true, right: func(b binding) solutions {
+				return ev.group(p.Group, gs, b)
+			}}
+		default:
+			panic(fmt.Errorf("unsupported pattern: %T", p))
+		}
+	}
+	if len(g.Filters) != 0 {
+		it = &filter{ev: ev, gs: gs, sub: it, filters: g.Filters}
+	}
+	return it
+}
+
+func (ev *evaluator) bgp(p BGP, gs graphScope, consts map[string][]quad.Value, b binding) solutions {
+	if len(p) == 0 {
+		return &single{b: b}
+	}
+	parts := compileBGP(p, gs, consts, b)
+	var it solutions = &single{b: b}
+	for _, c := range parts {
+		c := c
+		it = &join{left: it, right: func(b binding) solutions {
+			return &scan{qs: ev.qs, c: c, b: b}
+		}}
+	}
+	return it
+}
+
+// env is an environment of expressions.
+type env struct {
+	ctx context.Context
+	ev  *evaluator
+	gs  graphScope
+	b   binding
+}
+
+// value returns a value of the variable, or nil if it is not bound.
+func (e *env) value(name string) quad.Value {
+	r, ok := e.b[name]
+	if !ok {
+		return nil
+	}
+	return e.ev.qs.NameOf(r)
+}
+
+// exists checks if the group has any solutions that are compatible with the current one.
+func (e *env) exists(g *Group) (bool, error) {
+	it := e.ev.group(g, e.gs, e.b)
+	defer it.Close()
+	ok := it.Next(e.ctx)
+	return ok, it.Err()
+}
+
+// single is an iterator with a single solution.
+type single struct {
+	b    binding
+	done bool
+}
+
+func (it *single) Next(ctx context.Context) bool {
+	if it.done {
+		return false
+	}
+	it.done = true
+	return true
+}
+
+func (it *single) Result() binding { return it.b }
+func (it *single) Err() error      { return nil }
+func (it *single) Close() error    { return nil }
+
+// scan iterates over solutions of a compiled part of a basic graph pattern.
+type scan struct {
+	qs   graph.QuadStore
+	c    compiled
+	b    binding
+	it   iterator.Scanner
+	path bool
+	res  binding
+	err  error
+}
+
+func (it *scan) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	if it.it == nil {
+		it.it = shape.BuildIterator(ctx, it.qs, it.c.shape).Iterate()
+	}
+	for {
+		if !it.path || !it.it.NextPath(ctx) {
+			if !it.it.Next(ctx) {
+				it.err = it.it.Err()
+				return false
+			}
+			it.path = true
+		}
+		tags := make(map[string]graph.Ref)
+		it.it.TagResults(tags)
+		if it.c.valid(tags) {
+			it.res = it.b.with(tags)
+			return true
+		}
+	}
+}
+
+func (it *scan) Result() binding { return it.res }
+func (it *scan) Err() error      { return it.err }
+
+func (it *scan) Close() error {
+	if it.it == nil {
+		return nil
+	}
+	return it.it.Close()
+}
+
+// join matches the right pattern for each solution of the left one.
+// If it is optional, solutions of the left pattern are kept when the right pattern has no matches.
+type join struct {
+	left     solutions
+	right    func(b binding) solutions
+	optional bool
+
+	cur     solutions
+	matched bool
+	res     binding
+	err     error
+}
+
+func (it *join) Next(ctx context.Context) bool {
+	for it.err == nil {
+		if it.cur == nil {
+			if !it.left.Next(ctx) {
+				it.err = it.left.Err()
+				return false
+			}
+			it.cur = it.right(it.left.Result())
+			it.matched = false
+		}
+		if it.cur.Next(ctx) {
+			it.matched = true
+			it.res = it.cur.Result()
+			return true
+		}
+		it.err = it.cur.Err()
+		it.cur.Close()
+		it.cur = nil
+		if it.err == nil && it.optional && !it.matched {
+			it.res = it.left.Result()
+			return true
+		}
+	}
+	return false
+}
+
+func (it *join) Result() binding { return it.res }
+func (it *join) Err() error      { return it.err }
+
+func (it *join) Close() error {
+	if it.cur != nil {
+		it.cur.Close()
+	}
+	return it.left.Close()
+}
+
+// concat returns solutions of all patterns, one after another.
+type concat struct {
+	next []func() solutions
+	cur  solutions
+	err  error
+}
+
+func (it *concat) Next(ctx context.Context) bool {
+	for it.err == nil {
+		if it.cur == nil {
+			if len(it.next) == 0 {
+				return false
+			}
+			it.cur = it.next[0]()
+			it.next = it.next[1:]
+		}
+		if it.cur.Next(ctx) {
+			return true
+		}
+		it.err = it.cur.Err()
+		it.cur.Close()
+		it.cur = nil
+	}
+	return false
+}
+
+func (it *concat) Result() binding { return it.cur.Result() }
+func (it *concat) Err() error      { return it.err }
+
+func (it *concat) Close() error {
+	if it.cur != nil {
+		return it.cur.Close()
+	}
+	return nil
+}
+
+// filter keeps solutions for which all filters are true. Filters with errors are false.
+type filter struct {
+	ev      *evaluator
+	gs      graphScope
+	sub     solutions
+	filters []Expr
+	err     error
+}
+
+func (it *filter) Next(ctx context.Context) bool {
+next:
+	for it.sub.Next(ctx) {
+		e := &env{ctx: ctx, ev: it.ev, gs: it.gs, b: it.sub.Result()}
+		for _, f := range it.filters {
+			if ok, err := evalBool(e, f); err != nil || !ok {
+				// EXISTS fails when the context is canceled, which is not a false value
+				if it.err = ctx.Err(); it.err != nil {
+					return false
+				}
+				continue next
+			}
+		}
+		return true
+	}
+	it.err = it.sub.Err()
+	return false
+}
+
+func (it *filter) Result() binding { return it.sub.Result() }
+func (it *filter) Err() error      { return it.err }
+func (it *filter) Close() error    { return it.sub.Close() }
+
+// sorted materializes all solutions and sorts them by ORDER BY conditions.
+type sorted struct {
+	ev    *evaluator
+	gs    graphScope
+	sub   solutions
+	order []OrderCond
+
+	res  []binding
+	cur  int
+	done bool
+	err  error
+}
+
+func (it *sorted) Next(ctx context.Context) bool {
+	if !it.done {
+		it.done = true
+		type row struct {
+			b    binding
+			keys []quad.Value
+		}
+		var rows []row
+		for it.sub.Next(ctx) {
+			b := it.sub.Result()
+			e := &env{ctx: ctx, ev: it.ev, gs: it.gs, b: b}
+			keys := make([]quad.Value, len(it.order))
+			for i, o := range it.order {
+				// unbound values and errors are ordered first
+				keys[i], _ = o.Expr.eval(e)
+			}
+			rows = append(rows, row{b: b, keys: keys})
+		}
+		if it.err = it.sub.Err(); it.err != nil {
+			return false
+		}
+		sort.SliceStable(rows, func(i, j int) bool {
+			for k, o := range it.order {
+				c := orderValues(rows[i].keys[k], rows[j].keys[k])
+				if c == 0 {
+					continue
+				}
+				if o.Desc {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+		it.res = make([]binding, len(rows))
+		for i, r := range rows {
+			it.res[i] = r.b
+		}
+		it.cur = -1
+	}
+	if it.err != nil || it.cur+1 >= len(it.res) {
+		return false
+	}
+	it.cur++
+	return true
+}
+
+func (it *sorted) Result() binding { return it.res[it.cur] }
+func (it *sorted) Err() error      { return it.err }
+func (it *sorted) Close() error    { return it.sub.Close() }
+
+// slice applies OFFSET and LIMIT to solutions.
+type slice struct {
+	sub    solutions
+	offset int
+	limit  int
+	n      int
+}
+
+func (it *slice) Next(ctx context.Context) bool {
+	for ; it.offset > 0; it.offset-- {
+		if !it.sub.Next(ctx) {
+			return false
+		}
+	}
+	if it.limit >= 0 && it.n >= it.limit {
+		return false
+	}
+	if !it.sub.Next(ctx) {
+		return false
+	}
+	it.n++
+	return true
+}
+
+func (it *slice) Result() binding { return it.sub.Result() }
+func (it *slice) Err() error      { return it.sub.Err() }
+func (it *slice) Close() error    { return it.sub.Close() }
@@ -0,0 +1,270 @@
+package sparql
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/refs"
+)
+
+// Dataset overrides the dataset of a query, as with default-graph-uri and named-graph-uri parameters
+// of the SPARQL protocol.
+type Dataset struct {
+	From      []quad.Value
+	FromNamed []quad.Value
+}
+
+// Execute starts the evaluation of the query. The dataset of the query is replaced if ds is not nil.
+func (q *Query) Execute(qs graph.QuadStore, ds *Dataset) *Results {
+	gs := graphScope{from: q.From, named: q.FromNamed}
+	if ds != nil {
+		gs.from, gs.named = ds.From, ds.FromNamed
+	}
+	ev := &evaluator{qs: qs}
+	it := ev.group(q.Where, gs, binding{})
+	if len(q.Order) != 0 {
+		it = &sorted{ev: ev, gs: gs, sub: it, order: q.Order}
+	}
+	r := &Results{q: q, qs: qs}
+	switch q.Form {
+	case Select:
+		r.vars = q.Vars
+		if len(r.vars) == 0 {
+			r.vars = q.Where.Vars()
+		}
+		if q.Distinct {
+			it = &distinct{sub: it, vars: r.vars, seen: make(map[string]struct{})}
+		}
+	case Ask:
+		r.vars = []string{}
+	default:
+		r.seen = make(map[quad.Quad]struct{})
+	}
+	if q.Form != Ask && (q.Offset > 0 || q.Limit >= 0) {
+		it = &slice{sub: it, offset: q.Offset, limit: q.Limit}
+	}
+	r.it = it
+	return r
+}
+
+// Results is an iterator over results of a query.
+//
+// For SELECT, each result is a row of values of projected variables.
+// For ASK, there is exactly one result, which is a boolean.
+// For CONSTRUCT and DESCRIBE, each result is a quad.
+type Results struct {
+	q    *Query
+	qs   graph.QuadStore
+	it   solutions
+	vars []string
+
+	row  []quad.Value
+	ok   bool
+	done bool
+
+	quads []quad.Quad
+	quad  quad.Quad
+	seen  map[quad.Quad]struct{}
+	bnode int
+
+	err error
+}
+
+// Form returns the form of the query.
+func (r *Results) Form() Form { return r.q.Form }
+
+// Vars returns names of variables in rows of SELECT results.
+func (r *Results) Vars() []string { return r.vars }
+
+// Next advances the iterator to the next result.
+func (r *Results) Next(ctx context.Context) bool {
+	if r.err != nil || r.done {
+		return false
+	}
+	switch r.q.Form {
+	case Select:
+		if !r.it.Next(ctx) {
+			r.err = r.it.Err()
+			return false
+		}
+		b := r.it.Result()
+		r.row = make([]quad.Value, len(r.vars))
+		for i, v := range r.vars {
+			if ref, ok := b[v]; ok {
+				r.row[i] = r.qs.NameOf(ref)
+			}
+		}
+		return true
+	case Ask:
+		r.done = true
+		r.ok = r.it.Next(ctx)
+		r.err = r.it.Err()
+		return r.err == nil
+	}
+	for len(r.quads) == 0 {
+		if !r.it.Next(ctx) {
+			r.err = r.it.Err()
+			return false
+		}
+		if r.q.Form == Construct {
+			r.construct(r.it.Result())
+		} else if r.err = r.describe(ctx, r.it.Result()); r.err != nil {
+			return false
+		}
+	}
+	r.quad, r.quads = r.quads[0], r.quads[1:]
+	return true
+}
+
+// construct fills the template of CONSTRUCT with the solution.
+func (r *Results) construct(b binding) {
+	r.bnode++
+	bnodes := make(map[string]quad.BNode)
+	term := func(t Term) quad.Value {
+		if !t.IsVar() {
+			return t.Value
+		} else if isBlankVar(t.Var) {
+			// blank nodes of the template are new for each solution
+			id, ok := bnodes[t.Var]
+			if !ok {
+				id = quad.BNode(fmt.Sprintf("b%d_%s", r.bnode, strings.TrimPrefix(t.Var, "_:")))
+				bnodes[t.Var] = id
+			}
+			return id
+		} else if ref, ok := b[t.Var]; ok {
+			return r.qs.NameOf(ref)
+		}
+		return nil
+	}
+	for _, t := range r.q.Template {
+		q := quad.Quad{Subject: term(t.S), Predicate: term(t.P), Object: term(t.O)}
+		// triples with unbound variables or invalid terms are not constructed
+		switch q.Subject.(type) {
+		case quad.IRI, quad.BNode:
+		default:
+			continue
+		}
+		if _, ok := q.Predicate.(quad.IRI); !ok || q.Object == nil {
+			continue
+		}
+		r.add(q)
+	}
+}
+
+// describe adds all quads of resources of DESCRIBE, which refer to them as subjects.
+func (r *Results) describe(ctx context.Context, b binding) error {
+	terms := r.q.Describe
+	if len(terms) == 0 {
+		for _, v := range r.q.Where.Vars() {
+			terms = append(terms, Term{Var: v})
+		}
+	}
+	for _, t := range terms {
+		var ref graph.Ref
+		if t.IsVar() {
+			ref = b[t.Var]
+		} else {
+			ref = r.qs.ValueOf(t.Value)
+		}
+		if ref == nil {
+			continue
+		}
+		if _, ok := r.qs.NameOf(ref).(quad.IRI); !ok {
+			continue
+		}
+		it := r.qs.QuadIterator(quad.Subject, ref).Iterate()
+		for it.Next(ctx) {
+			q := r.qs.Quad(it.Result())
+			q.Label = nil
+			r.add(q)
+		}
+		err := it.Err()
+		it.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Results) add(q quad.Quad) {
+	if _, ok := r.seen[q]; ok {
+		return
+	}
+	r.seen[q] = struct{}{}
+	r.quads = append(r.quads, q)
+}
+
+// Row returns values of variables of the current SELECT result. Values of unbound variables are nil.
+func (r *Results) Row() []quad.Value { return r.row }
+
+// Bool returns the result of ASK.
+func (r *Results) Bool() bool { return r.ok }
+
+// Quad returns the current quad of CONSTRUCT or DESCRIBE.
+func (r *Results) Quad() quad.Quad { return r.quad }
+
+// Quads returns a reader for quads of CONSTRUCT and DESCRIBE.
+func (r *Results) Quads(ctx context.Context) quad.Reader {
+	return &quadReader{ctx: ctx, r: r}
+}
+
+type quadReader struct {
+	ctx context.Context
+	r   *Results
+}
+
+func (qr *quadReader) ReadQuad() (quad.Quad, error) {
+	if f := qr.r.Form(); f != Construct && f != Describe {
+		return quad.Quad{}, fmt.Errorf("sparql: %v query returns no quads", f)
+	}
+	if !qr.r.Next(qr.ctx) {
+		if err := qr.r.Err(); err != nil {
+			return quad.Quad{}, err
+		}
+		return quad.Quad{}, io.EOF
+	}
+	return qr.r.Quad(), nil
+}
+
+func (qr *quadReader) Close() error { return qr.r.Close() }
+
+// Err returns an error that occurred during the evaluation.
+func (r *Results) Err() error { return r.err }
+
+// Close stops the evaluation.
+func (r *Results) Close() error { return r.it.Close() }
+
+// distinct removes duplicate solutions of SELECT DISTINCT, comparing values of projected variables.
+type distinct struct {
+	sub  solutions
+	vars []string
+	seen map[string]struct{}
+}
+
+func (it *distinct) Next(ctx context.Context) bool {
+	for it.sub.Next(ctx) {
+		b := it.sub.Result()
+		var key strings.Builder
+		for _, v := range it.vars {
+			if ref, ok := b[v]; ok {
+				fmt.Fprintf(&key, "%T %v", refs.ToKey(ref), refs.ToKey(ref))
+			}
+			key.WriteByte(0)
+		}
+		if _, ok := it.seen[key.String()]; ok {
+			continue
+		}
+		it.seen[key.String()] = struct{}{}
+		return true
+	}
+	return false
+}
+
+func (it *distinct) Result() binding { return it.sub.Result() }
+func (it *distinct) Err() error      { return it.sub.Err() }
+func (it *distinct) Close() error    { return it.sub.Close() }
@@ -0,0 +1,615 @@
+package sparql
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cayleygraph/quad"
+)
+
+// Expr is an expression of FILTER or ORDER BY.
+type Expr interface {
+	eval(e *env) (quad.Value, error)
+}
+
+// errType is a type error of an expression. Filters with type errors do not match.
+var errType = errors.New("sparql: type error")
+
+type exprVar string
+
+func (v exprVar) eval(e *env) (quad.Value, error) {
+	val := e.value(string(v))
+	if val == nil {
+		return nil, fmt.Errorf("sparql: variable ?%s is not bound", string(v))
+	}
+	return val, nil
+}
+
+type exprValue struct {
+	Value quad.Value
+}
+
+func (v exprValue) eval(e *env) (quad.Value, error) {
+	return v.Value, nil
+}
+
+type exprUnary struct {
+	Op string
+	A  Expr
+}
+
+func (u exprUnary) eval(e *env) (quad.Value, error) {
+	a, err := u.A.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if u.Op == "!" {
+		b, err := ebv(a)
+		if err != nil {
+			return nil, err
+		}
+		return quad.Bool(!b), nil
+	}
+	switch a := a.(type) {
+	case quad.Int:
+		if u.Op == "-" {
+			return -a, nil
+		}
+		return a, nil
+	case quad.Float:
+		if u.Op == "-" {
+			return -a, nil
+		}
+		return a, nil
+	}
+	if f, ok := numeric(a); ok {
+		if u.Op == "-" {
+			f = -f
+		}
+		return quad.Float(f), nil
+	}
+	return nil, errType
+}
+
+type exprBinary struct {
+	Op   string
+	A, B Expr
+}
+
+func (b exprBinary) eval(e *env) (quad.Value, error) {
+	switch b.Op {
+	case "||", "&&":
+		return b.logical(e)
+	}
+	x, err := b.A.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	y, err := b.B.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	switch b.Op {
+	case "=":
+		return quad.Bool(equalValues(x, y)), nil
+	case "!=":
+		return quad.Bool(!equalValues(x, y)), nil
+	case "<", ">", "<=", ">=":
+		c, err := compareValues(x, y)
+		if err != nil {
+			return nil, err
+		}
+		switch b.Op {
+		case "<":
+			return quad.Bool(c < 0), nil
+		case ">":
+			return quad.Bool(c > 0), nil
+		case "<=":
+			return quad.Bool(c <= 0), nil
+		}
+		return quad.Bool(c >= 0), nil
+	}
+	return arithmetic(b.Op, x, y)
+}
+
+// logical evaluates || and && with the error handling of SPARQL: an error is ignored if the other operand
+// determines the result.
+func (b exprBinary) logical(e *env) (quad.Value, error) {
+	x, err1 := evalBool(e, b.A)
+	y, err2 := evalBool(e, b.B)
+	if b.Op == "||" {
+		if err1 == nil && x || err2 == nil && y {
+			return quad.Bool(true), nil
+		}
+	} else if err1 == nil && !x || err2 == nil && !y {
+		return quad.Bool(false), nil
+	}
+	if err1 != nil {
+		return nil, err1
+	} else if err2 != nil {
+		return nil, err2
+	}
+	return quad.Bool(b.Op == "&&"), nil
+}
+
+type exprIn struct {
+	A    Expr
+	List []Expr
+	Not  bool
+}
+
+func (in exprIn) eval(e *env) (quad.Value, error) {
+	a, err := in.A.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range in.List {
+		v, err := x.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		if equalValues(a, v) {
+			return quad.Bool(!in.Not), nil
+		}
+	}
+	return quad.Bool(in.Not), nil
+}
+
+type exprExists struct {
+	Group *Group
+	Not   bool
+}
+
+func (ex exprExists) eval(e *env) (quad.Value, error) {
+	ok, err := e.exists(ex.Group)
+	if err != nil {
+		return nil, err
+	}
+	return quad.Bool(ok != ex.Not), nil
+}
+
+type exprCall struct {
+	Name string
+	Args []Expr
+}
+
+var builtins = map[string]int{
+	// number of arguments; negative means at least that many
+	"BOUND": 1, "ISIRI": 1, "ISURI": 1, "ISBLANK": 1, "ISLITERAL": 1, "ISNUMERIC": 1,
+	"STR": 1, "LANG": 1, "DATATYPE": 1, "STRLEN": 1, "LCASE": 1, "UCASE": 1,
+	"CONTAINS": 2, "STRSTARTS": 2, "STRENDS": 2, "SAMETERM": 2, "LANGMATCHES": 2,
+	"REGEX": -2, "CONCAT": -1, "IF": 3, "COALESCE": -1,
+}
+
+func isBuiltin(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+func (c exprCall) eval(e *env) (quad.Value, error) {
+	if n, ok := builtins[c.Name]; ok {
+		if n >= 0 && len(c.Args) != n || n < 0 && len(c.Args) < -n {
+			return nil, fmt.Errorf("sparql: wrong number of arguments for %s", c.Name)
+		}
+	} else if len(c.Args) != 1 {
+		// casts to XML Schema types
+		return nil, fmt.Errorf("sparql: wrong number of arguments for <%s>", c.Name)
+	}
+	switch c.Name {
+	case "BOUND":
+		v, ok := c.Args[0].(exprVar)
+		if !ok {
+			return nil, errors.New("sparql: BOUND requires a variable")
+		}
+		return quad.Bool(e.value(string(v)) != nil), nil
+	case "IF":
+		cond, err := evalBool(e, c.Args[0])
+		if err != nil {
+			return nil, err
+		} else if cond {
+			return c.Args[1].eval(e)
+		}
+		return c.Args[2].eval(e)
+	case "COALESCE":
+		for _, a := range c.Args {
+			if v, err := a.eval(e); err == nil {
+				return v, nil
+			}
+		}
+		return nil, errType
+	}
+	args := make([]quad.Value, 0, len(c.Args))
+	for _, a := range c.Args {
+		v, err := a.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	switch c.Name {
+	case "ISIRI", "ISURI":
+		_, ok := args[0].(quad.IRI)
+		return quad.Bool(ok), nil
+	case "ISBLANK":
+		_, ok := args[0].(quad.BNode)
+		return quad.Bool(ok), nil
+	case "ISLITERAL":
+		return quad.Bool(isLiteral(args[0])), nil
+	case "ISNUMERIC":
+		_, ok := numeric(args[0])
+		return quad.Bool(ok), nil
+	case "STR":
+		s, ok := lexical(args[0])
+		if !ok {
+			return nil, errType
+		}
+		return quad.String(s), nil
+	case "LANG":
+		if l, ok := args[0].(quad.LangString); ok {
+			return quad.String(l.Lang), nil
+		} else if !isLiteral(args[0]) {
+			return nil, errType
+		}
+		return quad.String(""), nil
+	case "DATATYPE":
+		t := datatype(args[0])
+		if t == "" {
+			return nil, errType
+		}
+		return t, nil
+	case "SAMETERM":
+		return quad.Bool(args[0].String() == args[1].String()), nil
+	case "LANGMATCHES":
+		tag, ok1 := stringValue(args[0])
+		rng, ok2 := stringValue(args[1])
+		if !ok1 || !ok2 {
+			return nil, errType
+		}
+		if rng == "*" {
+			return quad.Bool(tag != ""), nil
+		}
+		tag, rng = strings.ToLower(tag), strings.ToLower(rng)
+		return quad.Bool(tag == rng || strings.HasPrefix(tag, rng+"-")), nil
+	case "REGEX":
+		return regex(args)
+	case "CONCAT":
+		var b strings.Builder
+		for _, a := range args {
+			s, ok := stringValue(a)
+			if !ok {
+				return nil, errType
+			}
+			b.WriteString(s)
+		}
+		return quad.String(b.String()), nil
+	}
+	s, ok := stringValue(args[0])
+	if !ok {
+		if strings.HasPrefix(c.Name, nsXSD) {
+			// casts also accept numbers and booleans
+			s, ok = lexical(args[0])
+		}
+		if !ok {
+			return nil, errType
+		}
+	}
+	switch c.Name {
+	case "STRLEN":
+		return quad.Int(len([]rune(s))), nil
+	case "LCASE":
+		return withLang(args[0], strings.ToLower(s)), nil
+	case "UCASE":
+		return withLang(args[0], strings.ToUpper(s)), nil
+	case "CONTAINS", "STRSTARTS", "STRENDS":
+		sub, ok := stringValue(args[1])
+		if !ok {
+			return nil, errType
+		}
+		switch c.Name {
+		case "CONTAINS":
+			return quad.Bool(strings.Contains(s, sub)), nil
+		case "STRSTARTS":
+			return quad.Bool(strings.HasPrefix(s, sub)), nil
+		}
+		return quad.Bool(strings.HasSuffix(s, sub)), nil
+	case nsXSD + "string":
+		return quad.String(s), nil
+	case nsXSD + "integer", nsXSD + "int", nsXSD + "long":
+		if f, ok := numeric(args[0]); ok {
+			return quad.Int(int64(f)), nil
+		}
+		v, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, errType
+		}
+		return quad.Int(v), nil
+	case nsXSD + "double", nsXSD + "decimal", nsXSD + "float":
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, errType
+		}
+		return quad.Float(v), nil
+	case nsXSD + "boolean":
+		v, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return nil, errType
+		}
+		return quad.Bool(v), nil
+	}
+	return nil, fmt.Errorf("sparql: unsupported function %s", c.Name)
+}
+
+func regex(args []quad.Value) (quad.Value, error) {
+	s, ok1 := stringValue(args[0])
+	pat, ok2 := stringValue(args[1])
+	if !ok1 || !ok2 {
+		return nil, errType
+	}
+	if len(args) > 2 {
+		flags, ok := stringValue(args[2])
+		if !ok {
+			return nil, errType
+		}
+		for _, f := range flags {
+			if !strings.ContainsRune("ism", f) {
+				return nil, fmt.Errorf("sparql: unsupported regex flag %q", f)
+			}
+		}
+		if flags != "" {
+			pat = "(?" + flags + ")" + pat
+		}
+	}
+	re, err := regexp.Compile(pat)
+	if err != nil {
+		return nil, fmt.Errorf("sparql: invalid regex: %v", err)
+	}
+	return quad.Bool(re.MatchString(s)), nil
+}
+
+func evalBool(e *env, x Expr) (bool, error) {
+	v, err := x.eval(e)
+	if err != nil {
+		return false, err
+	}
+	return ebv(v)
+}
+
+// ebv returns the effective boolean value of a value.
+func ebv(v quad.Value) (bool, error) {
+	switch v := v.(type) {
+	case quad.Bool:
+		return bool(v), nil
+	case quad.Int:
+		return v != 0, nil
+	case quad.Float:
+		return v != 0 && !math.IsNaN(float64(v)), nil
+	}
+	if s, ok := stringValue(v); ok {
+		return s != "", nil
+	}
+	if f, ok := numeric(v); ok {
+		return f != 0 && !math.IsNaN(f), nil
+	}
+	return false, errType
+}
+
+func isLiteral(v quad.Value) bool {
+	switch v.(type) {
+	case quad.IRI, quad.BNode, nil:
+		return false
+	}
+	return true
+}
+
+// numeric returns a value of a numeric literal.
+func numeric(v quad.Value) (float64, bool) {
+	switch v := v.(type) {
+	case quad.Int:
+		return float64(v), true
+	case quad.Float:
+		return float64(v), true
+	case quad.TypedString:
+		switch v.Type {
+		case nsXSD + "integer", nsXSD + "int", nsXSD + "long", nsXSD + "short", nsXSD + "byte",
+			nsXSD + "decimal", nsXSD + "double", nsXSD + "float",
+			nsXSD + "nonNegativeInteger", nsXSD + "positiveInteger", nsXSD + "negativeInteger", nsXSD + "nonPositiveInteger",
+			nsXSD + "unsignedLong", nsXSD + "unsignedInt", nsXSD + "unsignedShort", nsXSD + "unsignedByte":
+			f, err := strconv.ParseFloat(string(v.Value), 64)
+			return f, err == nil
+		}
+	}
+	return 0, false
+}
+
+// stringValue returns a value of a simple, typed or language-tagged string literal.
+func stringValue(v quad.Value) (string, bool) {
+	switch v := v.(type) {
+	case quad.String:
+		return string(v), true
+	case quad.LangString:
+		return string(v.Value), true
+	case quad.TypedString:
+		if v.Type == nsXSD+"string" {
+			return string(v.Value), true
+		}
+	}
+	return "", false
+}
+
+// lexical returns the lexical form of an IRI or a literal, as returned by STR.
+func lexical(v quad.Value) (string, bool) {
+	switch v := v.(type) {
+	case quad.IRI:
+		return string(v), true
+	case quad.BNode, nil:
+		return "", false
+	case quad.String:
+		return string(v), true
+	case quad.LangString:
+		return string(v.Value), true
+	case quad.TypedString:
+		return string(v.Value), true
+	case quad.Int:
+		return strconv.FormatInt(int64(v), 10), true
+	case quad.Float:
+		return strconv.FormatFloat(float64(v), 'g', -1, 64), true
+	case quad.Bool:
+		return strconv.FormatBool(bool(v)), true
+	case quad.Time:
+		return time.Time(v).Format(time.RFC3339Nano), true
+	}
+	return quad.StringOf(v), true
+}
+
+// datatype returns the datatype IRI of a literal, or an empty IRI.
+func datatype(v quad.Value) quad.IRI {
+	switch v := v.(type) {
+	case quad.String:
+		return nsXSD + "string"
+	case quad.LangString:
+		return nsRDF + "langString"
+	case quad.TypedString:
+		return v.Type
+	case quad.Int:
+		return nsXSD + "integer"
+	case quad.Float:
+		return nsXSD + "double"
+	case quad.Bool:
+		return nsXSD + "boolean"
+	case quad.Time:
+		return nsXSD + "dateTime"
+	}
+	return ""
+}
+
+func withLang(v quad.Value, s string) quad.Value {
+	if l, ok := v.(quad.LangString); ok {
+		return quad.LangString{Value: quad.String(s), Lang: l.Lang}
+	}
+	return quad.String(s)
+}
+
+// equalValues checks if values are equal, comparing literals by their values.
+func equalValues(a, b quad.Value) bool {
+	if c, err := compareValues(a, b); err == nil {
+		if _, ok := a.(quad.LangString); ok {
+			// language tags must match as well
+			return c == 0 && a.String() == b.String()
+		}
+		return c == 0
+	}
+	return a.String() == b.String()
+}
+
+// compareValues compares numbers, strings, booleans and dates. It returns a type error for other values.
+func compareValues(a, b quad.Value) (int, error) {
+	if x, ok := numeric(a); ok {
+		if y, ok := numeric(b); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+		return 0, errType
+	}
+	if x, ok := stringValue(a); ok {
+		if y, ok := stringValue(b); ok {
+			return strings.Compare(x, y), nil
+		}
+		return 0, errType
+	}
+	switch x := a.(type) {
+	case quad.Bool:
+		if y, ok := b.(quad.Bool); ok {
+			switch {
+			case x == y:
+				return 0, nil
+			case !x:
+				return -1, nil
+			}
+			return 1, nil
+		}
+	case quad.Time:
+		if y, ok := b.(quad.Time); ok {
+			switch tx, ty := time.Time(x), time.Time(y); {
+			case tx.Before(ty):
+				return -1, nil
+			case tx.After(ty):
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	return 0, errType
+}
+
+func arithmetic(op string, a, b quad.Value) (quad.Value, error) {
+	if x, ok := a.(quad.Int); ok && op != "/" {
+		if y, ok := b.(quad.Int); ok {
+			switch op {
+			case "+":
+				return x + y, nil
+			case "-":
+				return x - y, nil
+			case "*":
+				return x * y, nil
+			}
+		}
+	}
+	x, ok1 := numeric(a)
+	y, ok2 := numeric(b)
+	if !ok1 || !ok2 {
+		return nil, errType
+	}
+	switch op {
+	case "+":
+		return quad.Float(x + y), nil
+	case "-":
+		return quad.Float(x - y), nil
+	case "*":
+		return quad.Float(x * y), nil
+	case "/":
+		if y == 0 {
+			return nil, errType
+		}
+		return quad.Float(x / y), nil
+	}
+	return nil, fmt.Errorf("sparql: unknown operator %q", op)
+}
+
+// termOrder returns the order of kinds of terms for ORDER BY: unbound, blank nodes, IRIs, literals.
+func termOrder(v quad.Value) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case quad.BNode:
+		return 1
+	case quad.IRI:
+		return 2
+	}
+	return 3
+}
+
+// orderValues compares values for ORDER BY.
+func orderValues(a, b quad.Value) int {
+	if oa, ob := termOrder(a), termOrder(b); oa != ob {
+		if oa < ob {
+			return -1
+		}
+		return 1
+	} else if oa == 0 {
+		return 0
+	}
+	if c, err := compareValues(a, b); err == nil {
+		return c
+	}
+	return strings.Compare(a.String(), b.String())
+}
@@ -0,0 +1,284 @@
+package sparql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenType int
+
+const (
+	tokEOF     tokenType = iota
+	tokIRI               // <http://example.com/>
+	tokPName             // prefix:local
+	tokBNode             // _:label
+	tokVar               // ?name or $name
+	tokString            // "string" or 'string', with escapes resolved
+	tokLangTag           // @en
+	tokInteger           // 10
+	tokDecimal           // 1.5
+	tokDouble            // 1e10
+	tokKeyword           // SELECT, a, true, regex...
+	tokPunct             // { } ( ) [ ] . ; , * = != < > <= >= ! && || + - / ^^
+)
+
+type token struct {
+	typ tokenType
+	val string
+	pos int
+}
+
+func (t token) String() string {
+	switch t.typ {
+	case tokEOF:
+		return "end of query"
+	case tokIRI:
+		return "<" + t.val + ">"
+	case tokVar:
+		return "?" + t.val
+	case tokString:
+		return fmt.Sprintf("%q", t.val)
+	case tokLangTag:
+		return "@" + t.val
+	}
+	return fmt.Sprintf("%q", t.val)
+}
+
+// lex splits a query into tokens.
+func lex(s string) ([]token, error) {
+	var (
+		out []token
+		i   int
+	)
+	for {
+		// skip spaces and comments
+		for i < len(s) {
+			r, n := utf8.DecodeRuneInString(s[i:])
+			if unicode.IsSpace(r) {
+				i += n
+			} else if r == '#' {
+				for i < len(s) && s[i] != '\n' {
+					i++
+				}
+			} else {
+				break
+			}
+		}
+		if i >= len(s) {
+			out = append(out, token{typ: tokEOF, pos: i})
+			return out, nil
+		}
+		start := i
+		c := s[i]
+		switch {
+		case c == '<':
+			if j := scanIRI(s[i:]); j > 0 {
+				out = append(out, token{typ: tokIRI, val: s[i+1 : i+j-1], pos: start})
+				i += j
+				continue
+			}
+			if strings.HasPrefix(s[i:], "<=") {
+				out = append(out, token{typ: tokPunct, val: "<=", pos: start})
+				i += 2
+			} else {
+				out = append(out, token{typ: tokPunct, val: "<", pos: start})
+				i++
+			}
+		case c == '?' || c == '$':
+			j := i + 1
+			for j < len(s) && isNameChar(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("sparql: empty variable name at %d", start)
+			}
+			out = append(out, token{typ: tokVar, val: s[i+1 : j], pos: start})
+			i = j
+		case c == '"' || c == '\'':
+			val, n, err := scanString(s[i:])
+			if err != nil {
+				return nil, fmt.Errorf("sparql: %v at %d", err, start)
+			}
+			out = append(out, token{typ: tokString, val: val, pos: start})
+			i += n
+		case c == '@':
+			j := i + 1
+			for j < len(s) && (isAlnum(s[j]) || s[j] == '-') {
+				j++
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("sparql: empty language tag at %d", start)
+			}
+			out = append(out, token{typ: tokLangTag, val: s[i+1 : j], pos: start})
+			i = j
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			typ, j := scanNumber(s[i:])
+			out = append(out, token{typ: typ, val: s[i : i+j], pos: start})
+			i += j
+		case c == '_' && i+1 < len(s) && s[i+1] == ':':
+			j := i + 2
+			for j < len(s) && (isNameChar(s[j]) || s[j] == '-' || s[j] == '.' && j+1 < len(s) && isNameChar(s[j+1])) {
+				j++
+			}
+			out = append(out, token{typ: tokBNode, val: s[i+2 : j], pos: start})
+			i = j
+		case isNameStart(c) || c == ':':
+			j := i
+			colon := false
+		loop:
+			for j < len(s) {
+				switch {
+				case isNameChar(s[j]) || s[j] == '-':
+				case s[j] == ':':
+					colon = true
+				case colon && s[j] == '%':
+				case colon && s[j] == '.' && j+1 < len(s) && (isNameChar(s[j+1]) || s[j+1] == ':'):
+				default:
+					break loop
+				}
+				j++
+			}
+			typ := tokKeyword
+			if colon {
+				typ = tokPName
+			}
+			out = append(out, token{typ: typ, val: s[i:j], pos: start})
+			i = j
+		default:
+			for _, p := range []string{"^^", "!=", ">=", "&&", "||"} {
+				if strings.HasPrefix(s[i:], p) {
+					out = append(out, token{typ: tokPunct, val: p, pos: start})
+					i += len(p)
+					break
+				}
+			}
+			if i != start {
+				continue
+			}
+			if !strings.ContainsRune("{}()[].;,*=<>!+-/", rune(c)) {
+				r, _ := utf8.DecodeRuneInString(s[i:])
+				return nil, fmt.Errorf("sparql: unexpected character %q at %d", r, start)
+			}
+			out = append(out, token{typ: tokPunct, val: string(c), pos: start})
+			i++
+		}
+	}
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isNameChar(c byte) bool {
+	return isAlnum(c) || c == '_' || c >= 0x80
+}
+
+// scanIRI returns the length of an IRI reference at the start of the string, or zero if there is none.
+// It allows to distinguish IRIs from the less-than operator, since IRIs cannot contain spaces.
+func scanIRI(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '>':
+			return i + 1
+		case c <= ' ' || strings.IndexByte("<\"{}|^`", c) >= 0:
+			return 0
+		}
+	}
+	return 0
+}
+
+func scanNumber(s string) (tokenType, int) {
+	typ := tokInteger
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	if i < len(s) && s[i] == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9' {
+		typ = tokDecimal
+		i++
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			return tokDouble, j
+		}
+	}
+	return typ, i
+}
+
+// scanString reads a short or long string literal and returns its value and the length of the literal.
+func scanString(s string) (string, int, error) {
+	q := s[:1]
+	long := strings.HasPrefix(s, q+q+q)
+	i := 1
+	if long {
+		q = q + q + q
+		i = 3
+	}
+	var b strings.Builder
+	for i < len(s) {
+		if strings.HasPrefix(s[i:], q) {
+			return b.String(), i + len(q), nil
+		}
+		c := s[i]
+		if !long && (c == '\n' || c == '\r') {
+			return "", 0, fmt.Errorf("new line in string")
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		if i+1 >= len(s) {
+			break
+		}
+		i++
+		switch e := s[i]; e {
+		case 't':
+			b.WriteByte('\t')
+		case 'b':
+			b.WriteByte('\b')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case '"', '\'', '\\':
+			b.WriteByte(e)
+		case 'u', 'U':
+			n := 4
+			if e == 'U' {
+				n = 8
+			}
+			if i+n >= len(s) {
+				return "", 0, fmt.Errorf("invalid escape sequence")
+			}
+			var r rune
+			if _, err := fmt.Sscanf(s[i+1:i+1+n], "%x", &r); err != nil {
+				return "", 0, fmt.Errorf("invalid escape sequence")
+			}
+			b.WriteRune(r)
+			i += n
+		default:
+			return "", 0, fmt.Errorf("invalid escape sequence \\%c", e)
+		}
+		i++
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,900 @@
+package sparql
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cayleygraph/quad"
+)
+
+const (
+	nsRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsXSD = "http://www.w3.org/2001/XMLSchema#"
+
+	iriType = quad.IRI(nsRDF + "type")
+)
+
+// Form is a form of a SPARQL query.
+type Form int
+
+const (
+	Select Form = iota
+	Ask
+	Construct
+	Describe
+)
+
+func (f Form) String() string {
+	switch f {
+	case Select:
+		return "SELECT"
+	case Ask:
+		return "ASK"
+	case Construct:
+		return "CONSTRUCT"
+	case Describe:
+		return "DESCRIBE"
+	}
+	return fmt.Sprintf("Form(%d)", int(f))
+}
+
+// Term is a variable or a value in a triple pattern.
+// Blank nodes in patterns are variables that are not projected; their names start with "_:".
+type Term struct {
+	Var   string
+	Value quad.Value
+}
+
+// IsVar checks if the term is a variable.
+func (t Term) IsVar() bool { return t.Var != "" }
+
+func (t Term) String() string {
+	if t.IsVar() {
+		if isBlankVar(t.Var) {
+			return t.Var
+		}
+		return "?" + t.Var
+	}
+	return t.Value.String()
+}
+
+func isBlankVar(name string) bool { return strings.HasPrefix(name, "_:") }
+
+// TriplePattern is a triple with variables.
+type TriplePattern struct {
+	S, P, O Term
+}
+
+// Pattern is an element of a group graph pattern.
+type Pattern interface {
+	isPattern()
+}
+
+// BGP is a basic graph pattern - a list of triple patterns that must all match.
+type BGP []TriplePattern
+
+// Group is a group graph pattern. Its patterns are joined in order, and filters apply to the whole group.
+type Group struct {
+	Patterns []Pattern
+	Filters  []Expr
+}
+
+// Optional is an OPTIONAL pattern, which is left-joined with preceding patterns of the group.
+type Optional struct {
+	Group *Group
+}
+
+// Union is a UNION of group patterns.
+type Union []*Group
+
+// Graph is a GRAPH pattern, which matches quads in a named graph, or binds the graph to a variable.
+type Graph struct {
+	Name  Term
+	Group *Group
+}
+
+func (BGP) isPattern()      {}
+func (*Group) isPattern()   {}
+func (Optional) isPattern() {}
+func (Union) isPattern()    {}
+func (Graph) isPattern()    {}
+
+// OrderCond is a condition of ORDER BY.
+type OrderCond struct {
+	Expr Expr
+	Desc bool
+}
+
+// Query is a parsed SPARQL query.
+type Query struct {
+	Form     Form
+	Distinct bool
+	// Vars lists projected variables of SELECT. All variables of the pattern are projected if it is empty.
+	Vars []string
+	// Template is a template of CONSTRUCT.
+	Template BGP
+	// Describe lists resources and variables of DESCRIBE. Values of all variables are described if it is empty.
+	Describe []Term
+	// From lists graphs which form the default graph. All quads are in the default graph if it is empty.
+	From []quad.Value
+	// FromNamed lists graphs that can be matched by GRAPH patterns. All graphs can be matched if it is empty.
+	FromNamed []quad.Value
+	Where     *Group
+	Order     []OrderCond
+	// Limit is the maximal number of solutions; negative means no limit.
+	Limit  int
+	Offset int
+}
+
+type parseError struct {
+	err error
+}
+
+type parser struct {
+	toks     []token
+	i        int
+	base     *url.URL
+	prefixes map[string]string
+	// bnodes is a counter for names of anonymous blank nodes
+	bnodes int
+}
+
+// Parse parses a SPARQL 1.1 query.
+//
+// Supported are SELECT, ASK, CONSTRUCT and DESCRIBE queries with basic graph patterns, OPTIONAL, UNION,
+// FILTER, GRAPH, ORDER BY, LIMIT and OFFSET. Other features of SPARQL 1.1, such as aggregates,
+// sub-queries, property paths and updates return an error.
+func Parse(qu string) (q *Query, err error) {
+	toks, err := lex(qu)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, prefixes: make(map[string]string)}
+	defer func() {
+		if r := recover(); r != nil {
+			pe, ok := r.(parseError)
+			if !ok {
+				panic(r)
+			}
+			q, err = nil, pe.err
+		}
+	}()
+	return p.query(), nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) {
+	t := p.peek()
+	panic(parseError{err: fmt.Errorf("sparql: "+format+" at %d", append(args, t.pos)...)})
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.typ != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// isKeyword checks if the next token is a given keyword. Keywords are case-insensitive.
+func (p *parser) isKeyword(kw string) bool {
+	t := p.peek()
+	return t.typ == tokKeyword && strings.EqualFold(t.val, kw)
+}
+
+func (p *parser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(kw string) {
+	if !p.acceptKeyword(kw) {
+		p.errorf("expected %s, got %v", kw, p.peek())
+	}
+}
+
+func (p *parser) isPunct(s string) bool {
+	t := p.peek()
+	return t.typ == tokPunct && t.val == s
+}
+
+func (p *parser) acceptPunct(s string) bool {
+	if p.isPunct(s) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectPunct(s string) {
+	if !p.acceptPunct(s) {
+		p.errorf("expected %q, got %v", s, p.peek())
+	}
+}
+
+func (p *parser) query() *Query {
+	p.prologue()
+	q := &Query{Limit: -1}
+	switch {
+	case p.acceptKeyword("SELECT"):
+		q.Form = Select
+		if p.acceptKeyword("DISTINCT") || p.acceptKeyword("REDUCED") {
+			q.Distinct = true
+		}
+		if !p.acceptPunct("*") {
+			for p.peek().typ == tokVar {
+				q.Vars = append(q.Vars, p.next().val)
+			}
+			if len(q.Vars) == 0 {
+				if p.isPunct("(") {
+					p.errorf("expressions in SELECT are not supported")
+				}
+				p.errorf("expected variables, got %v", p.peek())
+			}
+		}
+		p.dataset(q)
+		p.acceptKeyword("WHERE")
+		q.Where = p.group()
+	case p.acceptKeyword("ASK"):
+		q.Form = Ask
+		p.dataset(q)
+		p.acceptKeyword("WHERE")
+		q.Where = p.group()
+	case p.acceptKeyword("CONSTRUCT"):
+		q.Form = Construct
+		if p.isPunct("{") {
+			q.Template = p.template()
+			p.dataset(q)
+			p.acceptKeyword("WHERE")
+			q.Where = p.group()
+		} else {
+			// short form: the template is the pattern
+			p.dataset(q)
+			p.expectKeyword("WHERE")
+			p.expectPunct("{")
+			q.Template = p.triplesBlock("}")
+			p.expectPunct("}")
+			q.Where = &Group{Patterns: []Pattern{q.Template}}
+		}
+	case p.acceptKeyword("DESCRIBE"):
+		q.Form = Describe
+		if !p.acceptPunct("*") {
+			for {
+				t := p.peek()
+				if t.typ != tokVar && t.typ != tokIRI && t.typ != tokPName {
+					break
+				}
+				q.Describe = append(q.Describe, p.varOrIRI())
+			}
+			if len(q.Describe) == 0 {
+				p.errorf("expected variables or IRIs, got %v", p.peek())
+			}
+		}
+		p.dataset(q)
+		if p.acceptKeyword("WHERE") || p.isPunct("{") {
+			q.Where = p.group()
+		} else {
+			if len(q.Describe) == 0 {
+				p.errorf("DESCRIBE * requires WHERE")
+			}
+			for _, t := range q.Describe {
+				if t.IsVar() {
+					p.errorf("DESCRIBE of variables requires WHERE")
+				}
+			}
+			q.Where = &Group{}
+		}
+	default:
+		for _, kw := range []string{"INSERT", "DELETE", "LOAD", "CLEAR", "CREATE", "DROP", "COPY", "MOVE", "ADD", "WITH"} {
+			if p.isKeyword(kw) {
+				p.errorf("updates are not supported")
+			}
+		}
+		p.errorf("expected SELECT, ASK, CONSTRUCT or DESCRIBE, got %v", p.peek())
+	}
+	p.modifiers(q)
+	if p.isKeyword("VALUES") {
+		p.errorf("VALUES is not supported")
+	}
+	if t := p.peek(); t.typ != tokEOF {
+		p.errorf("unexpected %v", t)
+	}
+	return q
+}
+
+func (p *parser) prologue() {
+	for {
+		switch {
+		case p.acceptKeyword("BASE"):
+			t := p.next()
+			if t.typ != tokIRI {
+				p.errorf("expected base IRI, got %v", t)
+			}
+			u, err := url.Parse(p.resolve(t.val))
+			if err != nil {
+				p.errorf("invalid base IRI: %v", err)
+			}
+			p.base = u
+		case p.acceptKeyword("PREFIX"):
+			t := p.next()
+			if t.typ != tokPName || !strings.HasSuffix(t.val, ":") || strings.Count(t.val, ":") != 1 {
+				p.errorf("expected prefix name, got %v", t)
+			}
+			iri := p.next()
+			if iri.typ != tokIRI {
+				p.errorf("expected prefix IRI, got %v", iri)
+			}
+			p.prefixes[strings.TrimSuffix(t.val, ":")] = p.resolve(iri.val)
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) dataset(q *Query) {
+	for p.acceptKeyword("FROM") {
+		named := p.acceptKeyword("NAMED")
+		iri := p.iri()
+		if named {
+			q.FromNamed = append(q.FromNamed, iri)
+		} else {
+			q.From = append(q.From, iri)
+		}
+	}
+}
+
+func (p *parser) modifiers(q *Query) {
+	if p.isKeyword("GROUP") || p.isKeyword("HAVING") {
+		p.errorf("aggregates are not supported")
+	}
+	if p.acceptKeyword("ORDER") {
+		p.expectKeyword("BY")
+		for {
+			var c OrderCond
+			switch {
+			case p.acceptKeyword("ASC"):
+				c.Expr = p.bracketted()
+			case p.acceptKeyword("DESC"):
+				c.Desc = true
+				c.Expr = p.bracketted()
+			case p.peek().typ == tokVar:
+				c.Expr = exprVar(p.next().val)
+			case p.isPunct("("):
+				c.Expr = p.bracketted()
+			case p.peek().typ == tokKeyword && p.isCall():
+				c.Expr = p.primary()
+			default:
+				if len(q.Order) == 0 {
+					p.errorf("expected order condition, got %v", p.peek())
+				}
+			}
+			if c.Expr == nil {
+				break
+			}
+			q.Order = append(q.Order, c)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		switch {
+		case p.acceptKeyword("LIMIT"):
+			q.Limit = p.integer()
+		case p.acceptKeyword("OFFSET"):
+			q.Offset = p.integer()
+		}
+	}
+}
+
+func (p *parser) integer() int {
+	t := p.next()
+	if t.typ != tokInteger {
+		p.errorf("expected integer, got %v", t)
+	}
+	n, err := strconv.Atoi(t.val)
+	if err != nil {
+		p.errorf("invalid integer: %v", err)
+	}
+	return n
+}
+
+func (p *parser) resolve(iri string) string {
+	if p.base == nil {
+		return iri
+	}
+	u, err := url.Parse(iri)
+	if err != nil || u.IsAbs() {
+		return iri
+	}
+	return p.base.ResolveReference(u).String()
+}
+
+func (p *parser) iri() quad.IRI {
+	t := p.next()
+	switch t.typ {
+	case tokIRI:
+		return quad.IRI(p.resolve(t.val))
+	case tokPName:
+		i := strings.Index(t.val, ":")
+		ns, ok := p.prefixes[t.val[:i]]
+		if !ok {
+			p.i--
+			p.errorf("unknown prefix %q", t.val[:i])
+		}
+		local, err := url.PathUnescape(t.val[i+1:])
+		if err != nil {
+			local = t.val[i+1:]
+		}
+		return quad.IRI(ns + local)
+	}
+	p.i--
+	p.errorf("expected IRI, got %v", t)
+	return ""
+}
+
+func (p *parser) varOrIRI() Term {
+	if t := p.peek(); t.typ == tokVar {
+		p.i++
+		return Term{Var: t.val}
+	}
+	return Term{Value: p.iri()}
+}
+
+func (p *parser) blankVar() Term {
+	p.bnodes++
+	return Term{Var: fmt.Sprintf("_:b%d", p.bnodes)}
+}
+
+// term parses a variable, an IRI, a blank node or a literal.
+func (p *parser) term() Term {
+	t := p.peek()
+	switch t.typ {
+	case tokVar:
+		p.i++
+		return Term{Var: t.val}
+	case tokIRI, tokPName:
+		return Term{Value: p.iri()}
+	case tokBNode:
+		p.i++
+		// labels of query blank nodes are distinct from generated ones
+		return Term{Var: "_:q" + t.val}
+	case tokPunct:
+		if t.val == "[" && p.toks[p.i+1].typ == tokPunct && p.toks[p.i+1].val == "]" {
+			p.i += 2
+			return p.blankVar()
+		}
+		if t.val == "(" {
+			p.errorf("collections are not supported")
+		}
+	}
+	if v := p.literal(); v != nil {
+		return Term{Value: v}
+	}
+	p.errorf("expected term, got %v", t)
+	return Term{}
+}
+
+// literal parses a literal value, or returns nil if the next token is not a literal.
+func (p *parser) literal() quad.Value {
+	t := p.peek()
+	sign := ""
+	if t.typ == tokPunct && (t.val == "-" || t.val == "+") {
+		if n := p.toks[p.i+1]; n.typ == tokInteger || n.typ == tokDecimal || n.typ == tokDouble {
+			p.i++
+			sign, t = t.val, n
+		}
+	}
+	switch t.typ {
+	case tokString:
+		p.i++
+		s := quad.String(t.val)
+		if n := p.peek(); n.typ == tokLangTag {
+			p.i++
+			return quad.LangString{Value: s, Lang: n.val}
+		} else if p.acceptPunct("^^") {
+			return typedLiteral(s, p.iri())
+		}
+		return s
+	case tokInteger:
+		p.i++
+		v, err := strconv.ParseInt(sign+t.val, 10, 64)
+		if err != nil {
+			return quad.TypedString{Value: quad.String(sign + t.val), Type: nsXSD + "integer"}
+		}
+		return quad.Int(v)
+	case tokDecimal, tokDouble:
+		p.i++
+		v, _ := strconv.ParseFloat(sign+t.val, 64)
+		return quad.Float(v)
+	case tokKeyword:
+		switch {
+		case strings.EqualFold(t.val, "true"):
+			p.i++
+			return quad.Bool(true)
+		case strings.EqualFold(t.val, "false"):
+			p.i++
+			return quad.Bool(false)
+		}
+	}
+	return nil
+}
+
+// typedLiteral converts literals of common XML Schema types to native values, as they are stored in the graph.
+func typedLiteral(s quad.String, typ quad.IRI) quad.Value {
+	switch typ {
+	case nsXSD + "string":
+		return s
+	case nsXSD + "integer", nsXSD + "int", nsXSD + "long", nsXSD + "short":
+		if v, err := strconv.ParseInt(string(s), 10, 64); err == nil {
+			return quad.Int(v)
+		}
+	case nsXSD + "decimal", nsXSD + "double", nsXSD + "float":
+		if v, err := strconv.ParseFloat(string(s), 64); err == nil {
+			return quad.Float(v)
+		}
+	case nsXSD + "boolean":
+		if v, err := strconv.ParseBool(string(s)); err == nil {
+			return quad.Bool(v)
+		}
+	case nsXSD + "dateTime":
+		if v, err := time.Parse(time.RFC3339Nano, string(s)); err == nil {
+			return quad.Time(v)
+		}
+	}
+	return quad.TypedString{Value: s, Type: typ}
+}
+
+// template parses a template of CONSTRUCT.
+func (p *parser) template() BGP {
+	p.expectPunct("{")
+	tpl := p.triplesBlock("}")
+	p.expectPunct("}")
+	return tpl
+}
+
+// triplesBlock parses triples until the given punctuation.
+func (p *parser) triplesBlock(end string) BGP {
+	var out BGP
+	for !p.isPunct(end) {
+		out = p.triples(out)
+		if !p.acceptPunct(".") {
+			break
+		}
+	}
+	return out
+}
+
+// triples parses a subject with a property list and appends triples to the list.
+func (p *parser) triples(out BGP) BGP {
+	if p.acceptPunct("[") {
+		if p.acceptPunct("]") {
+			return p.propertyList(out, p.blankVar(), true)
+		}
+		s := p.blankVar()
+		out = p.propertyList(out, s, true)
+		p.expectPunct("]")
+		// the property list is optional for blank nodes with properties
+		if p.isPunct(".") || p.isPunct("}") {
+			return out
+		}
+		return p.propertyList(out, s, false)
+	}
+	return p.propertyList(out, p.term(), true)
+}
+
+func (p *parser) propertyList(out BGP, s Term, required bool) BGP {
+	for {
+		if !required && !p.isVerb() {
+			return out
+		}
+		required = false
+		pred := p.verb()
+		for {
+			var o Term
+			if p.acceptPunct("[") {
+				if p.acceptPunct("]") {
+					o = p.blankVar()
+				} else {
+					o = p.blankVar()
+					out = p.propertyList(out, o, true)
+					p.expectPunct("]")
+				}
+			} else {
+				o = p.term()
+			}
+			out = append(out, TriplePattern{S: s, P: pred, O: o})
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+		if !p.acceptPunct(";") {
+			return out
+		}
+		for p.acceptPunct(";") {
+		}
+	}
+}
+
+func (p *parser) isVerb() bool {
+	t := p.peek()
+	return t.typ == tokVar || t.typ == tokIRI || t.typ == tokPName || t.typ == tokKeyword && t.val == "a"
+}
+
+func (p *parser) verb() Term {
+	t := p.peek()
+	switch {
+	case t.typ == tokKeyword && t.val == "a":
+		p.i++
+		return Term{Value: iriType}
+	case t.typ == tokPunct && (t.val == "^" || t.val == "/" || t.val == "|" || t.val == "*"):
+		p.errorf("property paths are not supported")
+	case t.typ == tokVar, t.typ == tokIRI, t.typ == tokPName:
+		v := p.varOrIRI()
+		if n := p.peek(); n.typ == tokPunct && (n.val == "/" || n.val == "*" || n.val == "+" || n.val == "|") {
+			p.errorf("property paths are not supported")
+		}
+		return v
+	}
+	p.errorf("expected predicate, got %v", t)
+	return Term{}
+}
+
+// group parses a group graph pattern.
+func (p *parser) group() *Group {
+	p.expectPunct("{")
+	if p.isKeyword("SELECT") {
+		p.errorf("sub-queries are not supported")
+	}
+	g := &Group{}
+	addTriples := func(bgp BGP) {
+		if n := len(g.Patterns); n != 0 {
+			if last, ok := g.Patterns[n-1].(BGP); ok {
+				g.Patterns[n-1] = append(last, bgp...)
+				return
+			}
+		}
+		g.Patterns = append(g.Patterns, bgp)
+	}
+	for !p.acceptPunct("}") {
+		switch {
+		case p.acceptPunct("."):
+		case p.acceptKeyword("OPTIONAL"):
+			g.Patterns = append(g.Patterns, Optional{Group: p.group()})
+		case p.acceptKeyword("FILTER"):
+			g.Filters = append(g.Filters, p.constraint())
+		case p.acceptKeyword("GRAPH"):
+			name := p.varOrIRI()
+			g.Patterns = append(g.Patterns, Graph{Name: name, Group: p.group()})
+		case p.isPunct("{"):
+			u := Union{p.group()}
+			for p.acceptKeyword("UNION") {
+				u = append(u, p.group())
+			}
+			if len(u) == 1 {
+				g.Patterns = append(g.Patterns, u[0])
+			} else {
+				g.Patterns = append(g.Patterns, u)
+			}
+		case p.peek().typ == tokEOF:
+			p.errorf("expected \"}\", got %v", p.peek())
+		default:
+			for _, kw := range []string{"MINUS", "BIND", "VALUES", "SERVICE"} {
+				if p.isKeyword(kw) {
+					p.errorf("%s is not supported", kw)
+				}
+			}
+			addTriples(p.triples(nil))
+		}
+	}
+	return g
+}
+
+// constraint parses a condition of FILTER.
+func (p *parser) constraint() Expr {
+	if p.isPunct("(") {
+		return p.bracketted()
+	}
+	if t := p.peek(); (t.typ == tokKeyword || t.typ == tokIRI || t.typ == tokPName) && p.isCall() {
+		return p.primary()
+	}
+	p.errorf("expected filter condition, got %v", p.peek())
+	return nil
+}
+
+func (p *parser) bracketted() Expr {
+	p.expectPunct("(")
+	e := p.expr()
+	p.expectPunct(")")
+	return e
+}
+
+// isCall checks if the next token is a name of a function followed by arguments.
+func (p *parser) isCall() bool {
+	if p.isKeyword("NOT") || p.isKeyword("EXISTS") {
+		return true
+	}
+	n := p.toks[p.i+1]
+	return n.typ == tokPunct && n.val == "("
+}
+
+func (p *parser) expr() Expr {
+	e := p.andExpr()
+	for p.acceptPunct("||") {
+		e = exprBinary{Op: "||", A: e, B: p.andExpr()}
+	}
+	return e
+}
+
+func (p *parser) andExpr() Expr {
+	e := p.relExpr()
+	for p.acceptPunct("&&") {
+		e = exprBinary{Op: "&&", A: e, B: p.relExpr()}
+	}
+	return e
+}
+
+func (p *parser) relExpr() Expr {
+	e := p.addExpr()
+	t := p.peek()
+	switch {
+	case t.typ == tokPunct && (t.val == "=" || t.val == "!=" || t.val == "<" || t.val == ">" || t.val == "<=" || t.val == ">="):
+		p.i++
+		return exprBinary{Op: t.val, A: e, B: p.addExpr()}
+	case p.isKeyword("IN"):
+		p.i++
+		return exprIn{A: e, List: p.exprList()}
+	case p.isKeyword("NOT"):
+		p.i++
+		p.expectKeyword("IN")
+		return exprIn{A: e, List: p.exprList(), Not: true}
+	}
+	return e
+}
+
+func (p *parser) addExpr() Expr {
+	e := p.mulExpr()
+	for {
+		switch {
+		case p.acceptPunct("+"):
+			e = exprBinary{Op: "+", A: e, B: p.mulExpr()}
+		case p.acceptPunct("-"):
+			e = exprBinary{Op: "-", A: e, B: p.mulExpr()}
+		default:
+			return e
+		}
+	}
+}
+
+func (p *parser) mulExpr() Expr {
+	e := p.unaryExpr()
+	for {
+		switch {
+		case p.acceptPunct("*"):
+			e = exprBinary{Op: "*", A: e, B: p.unaryExpr()}
+		case p.acceptPunct("/"):
+			e = exprBinary{Op: "/", A: e, B: p.unaryExpr()}
+		default:
+			return e
+		}
+	}
+}
+
+func (p *parser) unaryExpr() Expr {
+	switch {
+	case p.acceptPunct("!"):
+		return exprUnary{Op: "!", A: p.unaryExpr()}
+	case p.acceptPunct("-"):
+		return exprUnary{Op: "-", A: p.unaryExpr()}
+	case p.acceptPunct("+"):
+		return exprUnary{Op: "+", A: p.unaryExpr()}
+	}
+	return p.primary()
+}
+
+func (p *parser) exprList() []Expr {
+	p.expectPunct("(")
+	var out []Expr
+	if p.acceptPunct(")") {
+		return out
+	}
+	for {
+		out = append(out, p.expr())
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+	p.expectPunct(")")
+	return out
+}
+
+func (p *parser) primary() Expr {
+	t := p.peek()
+	switch t.typ {
+	case tokVar:
+		p.i++
+		return exprVar(t.val)
+	case tokPunct:
+		if t.val == "(" {
+			return p.bracketted()
+		}
+	case tokIRI, tokPName:
+		iri := p.iri()
+		if p.isPunct("(") {
+			return exprCall{Name: string(iri), Args: p.exprList()}
+		}
+		return exprValue{Value: iri}
+	case tokKeyword:
+		if p.acceptKeyword("NOT") {
+			p.expectKeyword("EXISTS")
+			return exprExists{Group: p.group(), Not: true}
+		} else if p.acceptKeyword("EXISTS") {
+			return exprExists{Group: p.group()}
+		}
+		if p.isCall() {
+			p.i++
+			name := strings.ToUpper(t.val)
+			if !isBuiltin(name) {
+				p.i--
+				p.errorf("unknown function %s", t.val)
+			}
+			p.expectPunct("(")
+			if p.acceptPunct("*") || p.isKeyword("DISTINCT") {
+				p.errorf("aggregates are not supported")
+			}
+			p.i--
+			return exprCall{Name: name, Args: p.exprList()}
+		}
+	}
+	if v := p.literal(); v != nil {
+		return exprValue{Value: v}
+	}
+	p.errorf("expected expression, got %v", t)
+	return nil
+}
+
+// Vars returns variables of the pattern in the order of appearance, excluding blank nodes.
+func (g *Group) Vars() []string {
+	var (
+		out  []string
+		seen = make(map[string]bool)
+	)
+	add := func(t Term) {
+		if t.IsVar() && !isBlankVar(t.Var) && !seen[t.Var] {
+			seen[t.Var] = true
+			out = append(out, t.Var)
+		}
+	}
+	var walk func(g *Group)
+	walk = func(g *Group) {
+		for _, pt := range g.Patterns {
+			switch pt := pt.(type) {
+			case BGP:
+				for _, t := range pt {
+					add(t.S)
+					add(t.P)
+					add(t.O)
+				}
+			case *Group:
+				walk(pt)
+			case Optional:
+				walk(pt.Group)
+			case Union:
+				for _, g := range pt {
+					walk(g)
+				}
+			case Graph:
+				add(pt.Name)
+				walk(pt.Group)
+			}
+		}
+	}
+	walk(g)
+	return out
+}
@@ -0,0 +1,239 @@
+package sparql
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/cayleygraph/quad"
+)
+
+// ResultFormat is a serialization format of SELECT and ASK results.
+type ResultFormat struct {
+	Name string
+	Mime []string
+	// Write writes all results to w.
+	Write func(ctx context.Context, w io.Writer, r *Results) error
+}
+
+var resultFormats = []*ResultFormat{
+	{
+		Name:  "json",
+		Mime:  []string{"application/sparql-results+json", "application/json"},
+		Write: writeJSON,
+	},
+	{
+		Name:  "xml",
+		Mime:  []string{"application/sparql-results+xml", "application/xml", "text/xml"},
+		Write: writeXML,
+	},
+	{
+		Name:  "csv",
+		Mime:  []string{"text/csv"},
+		Write: writeCSV,
+	},
+}
+
+// ResultFormats returns all supported formats of results.
+func ResultFormats() []*ResultFormat {
+	return append([]*ResultFormat{}, resultFormats...)
+}
+
+// ResultFormatByName returns a format of results by its name, or nil if it is not supported.
+func ResultFormatByName(name string) *ResultFormat {
+	for _, f := range resultFormats {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// ResultFormatByMime returns a format of results by its MIME type, or nil if it is not supported.
+func ResultFormatByMime(mime string) *ResultFormat {
+	for _, f := range resultFormats {
+		for _, m := range f.Mime {
+			if m == mime {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// jsonTerm is a value in the SPARQL JSON results format.
+type jsonTerm struct {
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Lang     string `json:"xml:lang,omitempty"`
+	Datatype string `json:"datatype,omitempty"`
+}
+
+func toJSONTerm(v quad.Value) jsonTerm {
+	switch v := v.(type) {
+	case quad.IRI:
+		return jsonTerm{Type: "uri", Value: string(v)}
+	case quad.BNode:
+		return jsonTerm{Type: "bnode", Value: string(v)}
+	case quad.LangString:
+		return jsonTerm{Type: "literal", Value: string(v.Value), Lang: v.Lang}
+	}
+	t := jsonTerm{Type: "literal"}
+	t.Value, _ = lexical(v)
+	if dt := datatype(v); dt != "" && dt != nsXSD+"string" {
+		t.Datatype = string(dt)
+	}
+	return t
+}
+
+// JSONRow converts values of a row to terms of the SPARQL JSON results format.
+func JSONRow(vars []string, row []quad.Value) map[string]interface{} {
+	m := make(map[string]interface{}, len(vars))
+	for i, v := range row {
+		if v != nil {
+			m[vars[i]] = toJSONTerm(v)
+		}
+	}
+	return m
+}
+
+func writeJSON(ctx context.Context, w io.Writer, r *Results) error {
+	bw := bufio.NewWriter(w)
+	if r.Form() == Ask {
+		r.Next(ctx)
+		if err := r.Err(); err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, `{"head":{},"boolean":%v}`+"\n", r.Bool())
+		return bw.Flush()
+	}
+	if r.Form() != Select {
+		return fmt.Errorf("sparql: cannot write %v results as JSON", r.Form())
+	}
+	head, err := json.Marshal(r.Vars())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(bw, `{"head":{"vars":%s},"results":{"bindings":[`, head)
+	for n := 0; r.Next(ctx); n++ {
+		if n != 0 {
+			bw.WriteString(",")
+		}
+		data, err := json.Marshal(JSONRow(r.Vars(), r.Row()))
+		if err != nil {
+			return err
+		}
+		bw.Write(data)
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	bw.WriteString("]}}\n")
+	return bw.Flush()
+}
+
+func writeXML(ctx context.Context, w io.Writer, r *Results) error {
+	bw := bufio.NewWriter(w)
+	text := func(s string) {
+		xml.EscapeText(bw, []byte(s))
+	}
+	bw.WriteString(xml.Header)
+	bw.WriteString(`<sparql xmlns="http://www.w3.org/2005/sparql-results#">` + "\n")
+	switch r.Form() {
+	case Ask:
+		r.Next(ctx)
+		if err := r.Err(); err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, "  <head/>\n  <boolean>%v</boolean>\n</sparql>\n", r.Bool())
+		return bw.Flush()
+	case Select:
+	default:
+		return fmt.Errorf("sparql: cannot write %v results as XML", r.Form())
+	}
+	bw.WriteString("  <head>\n")
+	for _, v := range r.Vars() {
+		bw.WriteString(`    <variable name="`)
+		text(v)
+		bw.WriteString("\"/>\n")
+	}
+	bw.WriteString("  </head>\n  <results>\n")
+	for r.Next(ctx) {
+		bw.WriteString("    <result>\n")
+		for i, v := range r.Row() {
+			if v == nil {
+				continue
+			}
+			bw.WriteString(`      <binding name="`)
+			text(r.Vars()[i])
+			bw.WriteString(`">`)
+			t := toJSONTerm(v)
+			switch {
+			case t.Lang != "":
+				bw.WriteString(`<literal xml:lang="`)
+				text(t.Lang)
+				bw.WriteString(`">`)
+			case t.Datatype != "":
+				bw.WriteString(`<literal datatype="`)
+				text(t.Datatype)
+				bw.WriteString(`">`)
+			default:
+				bw.WriteString("<" + t.Type + ">")
+			}
+			text(t.Value)
+			bw.WriteString("</" + t.Type + "></binding>\n")
+		}
+		bw.WriteString("    </result>\n")
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	bw.WriteString("  </results>\n</sparql>\n")
+	return bw.Flush()
+}
+
+func writeCSV(ctx context.Context, w io.Writer, r *Results) error {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	if r.Form() == Ask {
+		r.Next(ctx)
+		if err := r.Err(); err != nil {
+			return err
+		}
+		cw.Write([]string{"boolean"})
+		cw.Write([]string{fmt.Sprint(r.Bool())})
+		cw.Flush()
+		return cw.Error()
+	}
+	if r.Form() != Select {
+		return fmt.Errorf("sparql: cannot write %v results as CSV", r.Form())
+	}
+	if err := cw.Write(r.Vars()); err != nil {
+		return err
+	}
+	rec := make([]string, len(r.Vars()))
+	for r.Next(ctx) {
+		for i, v := range r.Row() {
+			switch v := v.(type) {
+			case nil:
+				rec[i] = ""
+			case quad.BNode:
+				rec[i] = v.String()
+			default:
+				rec[i], _ = lexical(v)
+			}
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,123 @@
+// Package sparql implements SPARQL 1.1 queries on top of query shapes.
+//
+// SELECT, ASK, CONSTRUCT and DESCRIBE queries are supported, with basic graph patterns, OPTIONAL, UNION,
+// FILTER, GRAPH, ORDER BY, LIMIT and OFFSET. Updates, aggregates, sub-queries and property paths are not supported.
+package sparql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/query"
+)
+
+const Name = "sparql"
+
+func init() {
+	query.RegisterLanguage(query.Language{
+		Name: Name,
+		Session: func(qs graph.QuadStore) query.Session {
+			return NewSession(qs)
+		},
+	})
+}
+
+func NewSession(qs graph.QuadStore) *Session {
+	return &Session{qs: qs}
+}
+
+type Session struct {
+	qs graph.QuadStore
+}
+
+// Execute runs a query. Results of SELECT are rows of variables, results of ASK are booleans,
+// and results of CONSTRUCT and DESCRIBE are quads.
+func (s *Session) Execute(ctx context.Context, qu string, opt query.Options) (query.Iterator, error) {
+	switch opt.Collation {
+	case query.Raw, query.JSON, query.REPL:
+	default:
+		return nil, &query.ErrUnsupportedCollation{Collation: opt.Collation}
+	}
+	q, err := Parse(qu)
+	if err != nil {
+		return nil, err
+	}
+	return &results{
+		s:     s,
+		col:   opt.Collation,
+		r:     q.Execute(s.qs, nil),
+		limit: opt.Limit,
+	}, nil
+}
+
+type results struct {
+	s     *Session
+	col   query.Collation
+	r     *Results
+	limit int
+	n     int
+}
+
+func (it *results) Next(ctx context.Context) bool {
+	if it.limit > 0 && it.n >= it.limit {
+		return false
+	}
+	if !it.r.Next(ctx) {
+		return false
+	}
+	it.n++
+	return true
+}
+
+func (it *results) Result() interface{} {
+	switch it.r.Form() {
+	case Ask:
+		if it.col == query.REPL {
+			return fmt.Sprintf("%v\n", it.r.Bool())
+		}
+		return it.r.Bool()
+	case Construct, Describe:
+		q := it.r.Quad()
+		switch it.col {
+		case query.Raw:
+			return q
+		case query.JSON:
+			return map[string]interface{}{
+				"subject":   toJSONTerm(q.Subject),
+				"predicate": toJSONTerm(q.Predicate),
+				"object":    toJSONTerm(q.Object),
+			}
+		}
+		return fmt.Sprintf("%v %v %v .\n", q.Subject, q.Predicate, q.Object)
+	}
+	vars, row := it.r.Vars(), it.r.Row()
+	switch it.col {
+	case query.Raw:
+		m := make(map[string]quad.Value, len(vars))
+		for i, v := range row {
+			if v != nil {
+				m[vars[i]] = v
+			}
+		}
+		return m
+	case query.JSON:
+		return JSONRow(vars, row)
+	}
+	out := "****\n"
+	for i, v := range row {
+		if v != nil {
+			out += fmt.Sprintf("?%s : %s\n", vars[i], v)
+		}
+	}
+	return out
+}
+
+func (it *results) Err() error {
+	return it.r.Err()
+}
+
+func (it *results) Close() error {
+	return it.r.Close()
+}
@@ -0,0 +1,284 @@
+package sparql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cayleygraph/quad"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/graphtest/testutil"
+	"github.com/epik-protocol/epik-gateway-backend/graph/memstore"
+)
+
+func makeTestStore(t testing.TB) graph.QuadStore {
+	qs := memstore.New()
+	qw := testutil.MakeWriter(t, qs, nil)
+	err := qw.AddQuadSet(testutil.LoadGraph(t, "../../data/testdata.nq"))
+	require.NoError(t, err)
+	return qs
+}
+
+type row map[string]string
+
+// selectRows runs a SELECT query and returns values of bound variables.
+func selectRows(t testing.TB, qs graph.QuadStore, qu string) []row {
+	q, err := Parse(qu)
+	require.NoError(t, err)
+	require.Equal(t, Select, q.Form)
+	r := q.Execute(qs, nil)
+	defer r.Close()
+	ctx := context.Background()
+	out := []row{}
+	for r.Next(ctx) {
+		m := make(row)
+		for i, v := range r.Row() {
+			if v != nil {
+				m[r.Vars()[i]] = v.String()
+			}
+		}
+		out = append(out, m)
+	}
+	require.NoError(t, r.Err())
+	return out
+}
+
+var casesSelect = []struct {
+	name    string
+	query   string
+	ordered bool
+	expect  []row
+}{
+	{
+		name:  "single pattern",
+		query: `SELECT ?x WHERE { ?x <follows> <bob> }`,
+		expect: []row{
+			{"x": "<alice>"}, {"x": "<charlie>"}, {"x": "<dani>"},
+		},
+	},
+	{
+		name:  "join",
+		query: `SELECT ?x ?y WHERE { ?x <follows> ?y . ?y <status> "cool_person" }`,
+		expect: []row{
+			{"x": "<alice>", "y": "<bob>"},
+			{"x": "<charlie>", "y": "<bob>"},
+			{"x": "<dani>", "y": "<bob>"},
+			{"x": "<charlie>", "y": "<dani>"},
+			{"x": "<dani>", "y": "<greg>"},
+			{"x": "<fred>", "y": "<greg>"},
+		},
+	},
+	{
+		name:  "cycle",
+		query: `SELECT * { ?a <follows> ?b . ?b <follows> ?c . ?a <follows> ?c }`,
+		expect: []row{
+			{"a": "<charlie>", "b": "<dani>", "c": "<bob>"},
+		},
+	},
+	{
+		name:  "blank node",
+		query: `SELECT ?x { ?x <follows> [ <status> "smart_person" ] }`,
+		expect: []row{
+			{"x": "<dani>"}, {"x": "<fred>"},
+		},
+	},
+	{
+		name:  "optional",
+		query: `SELECT ?x ?s WHERE { ?x <follows> ?y OPTIONAL { ?x <status> ?s } FILTER(?y = <fred>) }`,
+		expect: []row{
+			{"x": "<bob>", "s": `"cool_person"`},
+			{"x": "<emily>", "s": `"smart_person"`},
+		},
+	},
+	{
+		name:  "optional unbound",
+		query: `SELECT ?x ?s WHERE { <charlie> <follows> ?y . ?x <follows> ?y OPTIONAL { ?x <status> ?s } }`,
+		expect: []row{
+			{"x": "<alice>"},
+			{"x": "<charlie>"},
+			{"x": "<charlie>"},
+			{"x": "<dani>", "s": `"cool_person"`},
+		},
+	},
+	{
+		name:  "union",
+		query: `SELECT ?x { { ?x <follows> <greg> } UNION { ?x <status> "smart_person" } }`,
+		expect: []row{
+			{"x": "<dani>"}, {"x": "<fred>"}, {"x": "<emily>"}, {"x": "<greg>"},
+		},
+	},
+	{
+		name:  "filter",
+		query: `SELECT ?x { ?x <follows> ?y FILTER(?y = <bob> && ?x != <alice>) }`,
+		expect: []row{
+			{"x": "<charlie>"}, {"x": "<dani>"},
+		},
+	},
+	{
+		name:  "filter functions",
+		query: `SELECT DISTINCT ?x { ?x <status> ?s FILTER(STRSTARTS(?s, "smart") && STRLEN(?s) > 11) }`,
+		expect: []row{
+			{"x": "<emily>"}, {"x": "<greg>"},
+		},
+	},
+	{
+		name:  "filter not exists",
+		query: `SELECT ?x { ?x <status> "cool_person" FILTER NOT EXISTS { ?x <follows> ?y } }`,
+		expect: []row{
+			{"x": "<greg>"},
+		},
+	},
+	{
+		name:  "graph variable",
+		query: `SELECT ?x ?g { GRAPH ?g { ?x <status> ?s } }`,
+		expect: []row{
+			{"x": "<emily>", "g": "<smart_graph>"},
+			{"x": "<greg>", "g": "<smart_graph>"},
+		},
+	},
+	{
+		name:  "graph",
+		query: `SELECT ?x { GRAPH <smart_graph> { ?x ?p ?o } }`,
+		expect: []row{
+			{"x": "<emily>"}, {"x": "<greg>"},
+		},
+	},
+	{
+		name:  "from",
+		query: `SELECT ?x FROM <smart_graph> { ?x <status> ?s }`,
+		expect: []row{
+			{"x": "<emily>"}, {"x": "<greg>"},
+		},
+	},
+	{
+		name:    "order limit offset",
+		query:   `SELECT ?x { ?x <follows> ?y } ORDER BY DESC(?x) LIMIT 2 OFFSET 1`,
+		ordered: true,
+		expect: []row{
+			{"x": "<emily>"}, {"x": "<dani>"},
+		},
+	},
+	{
+		name:    "order by string",
+		query:   `PREFIX ex: <> SELECT ?x ?s { ?x ex:status ?s } ORDER BY ?s DESC(?x)`,
+		ordered: true,
+		expect: []row{
+			{"x": "<greg>", "s": `"cool_person"`},
+			{"x": "<dani>", "s": `"cool_person"`},
+			{"x": "<bob>", "s": `"cool_person"`},
+			{"x": "<greg>", "s": `"smart_person"`},
+			{"x": "<emily>", "s": `"smart_person"`},
+		},
+	},
+}
+
+func TestSelect(t *testing.T) {
+	qs := makeTestStore(t)
+	for _, c := range casesSelect {
+		t.Run(c.name, func(t *testing.T) {
+			got := selectRows(t, qs, c.query)
+			if c.ordered {
+				require.Equal(t, c.expect, got)
+			} else {
+				require.ElementsMatch(t, c.expect, got)
+			}
+		})
+	}
+}
+
+func TestAsk(t *testing.T) {
+	qs := makeTestStore(t)
+	ctx := context.Background()
+	for qu, exp := range map[string]bool{
+		`ASK { <alice> <follows> <bob> }`:                    true,
+		`ASK { <bob> <follows> <alice> }`:                    false,
+		`ASK { ?x <status> ?s FILTER(?s = "smart_person") }`: true,
+	} {
+		q, err := Parse(qu)
+		require.NoError(t, err)
+		r := q.Execute(qs, nil)
+		require.True(t, r.Next(ctx), qu)
+		require.Equal(t, exp, r.Bool(), qu)
+		require.False(t, r.Next(ctx))
+		require.NoError(t, r.Close())
+	}
+}
+
+func readQuads(t testing.TB, qs graph.QuadStore, qu string) []quad.Quad {
+	q, err := Parse(qu)
+	require.NoError(t, err)
+	r := q.Execute(qs, nil)
+	qr := r.Quads(context.Background())
+	defer qr.Close()
+	out, err := quad.ReadAll(qr)
+	require.NoError(t, err)
+	return out
+}
+
+func TestConstruct(t *testing.T) {
+	qs := makeTestStore(t)
+	got := readQuads(t, qs, `CONSTRUCT { ?y <followed_by> ?x } WHERE { ?x <follows> ?y FILTER(?y = <fred>) }`)
+	require.ElementsMatch(t, []quad.Quad{
+		quad.MakeIRI("fred", "followed_by", "bob", ""),
+		quad.MakeIRI("fred", "followed_by", "emily", ""),
+	}, got)
+
+	got = readQuads(t, qs, `DESCRIBE <bob>`)
+	require.ElementsMatch(t, []quad.Quad{
+		quad.MakeIRI("bob", "follows", "fred", ""),
+		quad.Make(quad.IRI("bob"), quad.IRI("status"), quad.String("cool_person"), nil),
+	}, got)
+}
+
+func TestResultFormats(t *testing.T) {
+	qs := makeTestStore(t)
+	ctx := context.Background()
+	q, err := Parse(`SELECT ?x ?s { ?x <follows> <fred> OPTIONAL { ?x <status> ?s } } ORDER BY ?x`)
+	require.NoError(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	err = ResultFormatByName("json").Write(ctx, buf, q.Execute(qs, nil))
+	require.NoError(t, err)
+	var res struct {
+		Head struct {
+			Vars []string `json:"vars"`
+		} `json:"head"`
+		Results struct {
+			Bindings []map[string]jsonTerm `json:"bindings"`
+		} `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &res))
+	require.Equal(t, []string{"x", "s"}, res.Head.Vars)
+	require.Equal(t, []map[string]jsonTerm{
+		{"x": {Type: "uri", Value: "bob"}, "s": {Type: "literal", Value: "cool_person"}},
+		{"x": {Type: "uri", Value: "emily"}, "s": {Type: "literal", Value: "smart_person"}},
+	}, res.Results.Bindings)
+
+	buf.Reset()
+	err = ResultFormatByMime("text/csv").Write(ctx, buf, q.Execute(qs, nil))
+	require.NoError(t, err)
+	require.Equal(t, "x,s\r\nbob,cool_person\r\nemily,smart_person\r\n", buf.String())
+
+	buf.Reset()
+	err = ResultFormatByName("xml").Write(ctx, buf, q.Execute(qs, nil))
+	require.NoError(t, err)
+	require.True(t, strings.Contains(buf.String(), `<binding name="x"><uri>bob</uri></binding>`), buf.String())
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, qu := range []string{
+		`SELECT ?x WHERE { ?x <follows> }`,
+		`SELECT (COUNT(*) AS ?n) WHERE { ?x ?p ?o }`,
+		`SELECT ?x WHERE { ?x <follows>/<follows> ?y }`,
+		`INSERT DATA { <a> <b> <c> }`,
+		`SELECT ?x WHERE { ?x ex:follows ?y }`,
+		`ASK { ?x <follows> ?y } trailing`,
+	} {
+		_, err := Parse(qu)
+		require.Error(t, err, qu)
+	}
+}
@@ -15,6 +15,7 @@
 package gatewayhttp
 
 import (
+	"bufio"
 	"compress/gzip"
 	"context"
 	"encoding/json"
@@ -23,6 +24,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -42,6 +44,8 @@ import (
 const (
 	prefix             = "/api/v2"
 	defaultLimit       = 100
+	defaultMaxOffset   = 10000
+	defaultRouteDepth  = 10
 	defaultReplication = "single"
 )
 
@@ -52,7 +56,10 @@ func NewAPIv2(h *graph.Handle, wrappers ...HandlerWrapper) *APIv2 {
 
 // NewBoundAPIv2 creates a new instance of APIv2 bound to a given httprouter.Router
 func NewBoundAPIv2(h *graph.Handle, r *httprouter.Router) *APIv2 {
-	api := &APIv2{h: h, wtyp: defaultReplication, wopt: nil, limit: defaultLimit, handler: r}
+	api := &APIv2{
+		h: h, wtyp: defaultReplication, wopt: nil, handler: r,
+		limit: defaultLimit, maxOffset: defaultMaxOffset, routeDepth: defaultRouteDepth, cursorKey: newCursorKey(),
+	}
 	api.registerOn(r)
 	return api
 }
@@ -60,7 +67,10 @@ func NewBoundAPIv2(h *graph.Handle, r *httprouter.Router) *APIv2 {
 // NewAPIv2Writer creates a new instance of APIv2
 func NewAPIv2Writer(h *graph.Handle, wtype string, wopts graph.Options, wrappers ...HandlerWrapper) *APIv2 {
 	r := httprouter.New()
-	api := &APIv2{h: h, wtyp: wtype, wopt: wopts, limit: defaultLimit}
+	api := &APIv2{
+		h: h, wtyp: wtype, wopt: wopts,
+		limit: defaultLimit, maxOffset: defaultMaxOffset, routeDepth: defaultRouteDepth, cursorKey: newCursorKey(),
+	}
 	api.registerOn(r)
 	var handler http.Handler = r
 	for _, wrapper := range wrappers {
@@ -82,8 +92,12 @@ type APIv2 struct {
 	wopt graph.Options
 
 	// query
-	timeout time.Duration
-	limit   int
+	timeout   time.Duration
+	limit     int
+	maxOffset int
+	cursorKey []byte
+	// routeDepth is the maximal number of edges in routes found by ServePaths
+	routeDepth int
 }
 
 // SetReadOnly sets read-only mode for the request
@@ -106,6 +120,24 @@ func (api *APIv2) SetQueryLimit(n int) {
 	api.limit = n
 }
 
+// SetQueryMaxOffset sets the maximal number of results of previous pages a query page can skip.
+// Pages are not continued past this number of results.
+func (api *APIv2) SetQueryMaxOffset(n int) {
+	api.maxOffset = n
+}
+
+// SetCursorKey sets the key for signing query cursors. By default, a random key is used,
+// thus cursors are only valid for the server that issued them, until it restarts.
+func (api *APIv2) SetCursorKey(key []byte) {
+	api.cursorKey = key
+}
+
+// SetRouteMaxDepth sets the maximal number of edges in routes found by the path finding endpoint.
+// Requests with a larger depth are limited to it.
+func (api *APIv2) SetRouteMaxDepth(n int) {
+	api.routeDepth = n
+}
+
 // ServeHTTP implements http.Handler
 func (api *APIv2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	api.handler.ServeHTTP(w, r)
@@ -136,11 +168,19 @@ func (api *APIv2) registerDataOn(r *httprouter.Router) {
 func (api *APIv2) registerQueryOn(r *httprouter.Router) {
 	r.POST(prefix+"/query", toHandle(api.ServeQuery))
 	r.GET(prefix+"/query", toHandle(api.ServeQuery))
+	r.POST(prefix+"/explain", toHandle(api.ServeExplain))
+	r.GET(prefix+"/explain", toHandle(api.ServeExplain))
+	r.POST(prefix+"/profile", toHandle(api.ServeProfile))
+	r.GET(prefix+"/profile", toHandle(api.ServeProfile))
 }
 
 func (api *APIv2) registerOn(r *httprouter.Router) {
 	api.registerDataOn(r)
 	api.registerQueryOn(r)
+	api.registerListenerOn(r)
+	api.registerFeedOn(r)
+	api.registerSPARQLOn(r)
+	api.registerPathsOn(r)
 }
 
 const (
@@ -211,6 +251,8 @@ func (api *APIv2) handleForRequest(r *http.Request) (*graph.Handle, error) {
 type writeResponse struct {
 	Result string `json:"result"`
 	Count  int    `json:"count"`
+	// Rate is the throughput of a bulk write in quads per second.
+	Rate float64 `json:"rate,omitempty"`
 }
 
 // newWriteResponse creates a new WriteResponse for given count of quads written
@@ -221,13 +263,42 @@ func newWriteResponse(count int) writeResponse {
 	}
 }
 
-// ServeWrite writes data received in the request body to the database
+// writeBulk writes quads with a bulk writer of the quad store, see graph.BulkLoader.
+func (api *APIv2) writeBulk(h *graph.Handle, qr quad.Reader) (graph.BulkStats, error) {
+	start := time.Now()
+	qw, err := graph.NewBulkWriter(h.QuadStore, graph.BulkOptions{})
+	if err != nil {
+		return graph.BulkStats{}, err
+	}
+	st, err := graph.CopyBulk(qw, qr, api.batch, nil)
+	if err != nil {
+		qw.Close()
+		return st, err
+	}
+	if err = qw.Close(); err != nil {
+		return st, err
+	}
+	st.Duration = time.Since(start)
+	return st, nil
+}
+
+// ServeWrite writes data received in the request body to the database.
+// If the "bulk" parameter is set, quads are written with a bulk writer and the response includes the throughput.
+// Bulk writes are not published to the feed.
 func (api *APIv2) ServeWrite(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	if api.ro {
 		jsonResponse(w, http.StatusForbidden, errors.New("database is read-only"))
 		return
 	}
+	bulk := false
+	if s := r.URL.Query().Get("bulk"); s != "" {
+		var err error
+		if bulk, err = strconv.ParseBool(s); err != nil {
+			jsonResponse(w, http.StatusBadRequest, errors.New("bulk must be set as a boolean"))
+			return
+		}
+	}
 	format := getFormat(r, "", hdrContentType)
 	if format == nil || format.Reader == nil {
 		jsonResponse(w, http.StatusBadRequest, errors.New("format is not supported for reading data"))
@@ -246,6 +317,18 @@ func (api *APIv2) ServeWrite(w http.ResponseWriter, r *http.Request) {
 		jsonResponse(w, http.StatusBadRequest, err)
 		return
 	}
+	if bulk {
+		st, err := api.writeBulk(h, qr)
+		if err != nil {
+			jsonResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set(hdrContentType, contentTypeJSON)
+		response := newWriteResponse(st.Quads)
+		response.Rate = st.Rate()
+		json.NewEncoder(w).Encode(response)
+		return
+	}
 	qw := graph.NewWriter(h.QuadWriter)
 	defer qw.Close()
 	n, err := quad.CopyBatch(qw, qr, api.batch)
@@ -481,6 +564,19 @@ func writeResults(w io.Writer, r interface{}) {
 	})
 }
 
+// writePage writes a page of results with a cursor for the next page, unless it is the last one.
+func writePage(w io.Writer, r interface{}, cursor string) {
+	resp := map[string]interface{}{
+		"result": r,
+	}
+	if cursor != "" {
+		resp["cursor"] = cursor
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(resp)
+}
+
 const maxQuerySize = 1024 * 1024 // 1 MB
 func readLimit(r io.Reader) ([]byte, error) {
 	lr := io.LimitReader(r, maxQuerySize).(*io.LimitedReader)
@@ -491,7 +587,8 @@ func readLimit(r io.Reader) ([]byte, error) {
 	return data, err
 }
 
-// ServeQuery executes a query received in the request and responds with the result
+// ServeQuery executes a query received in the request and responds with the result.
+// Results can be streamed as newline-delimited JSON, see streamResults, and split into pages, see queryPage.
 func (api *APIv2) ServeQuery(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := api.queryContext(r)
 	defer cancel()
@@ -516,21 +613,69 @@ func (api *APIv2) ServeQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	default:
 	}
+	page, err := queryPage(vals, api.cursorKey)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	stream, err := streamRequested(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
 	h, err := api.handleForRequest(r)
 	if err != nil {
 		errFunc(w, err)
 		return
 	}
-	if l.HTTPQuery != nil {
+	qs := h.QuadStore
+	var asOf int64
+	if s := vals.Get("as_of"); s != "" {
+		asOf, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || asOf <= 0 {
+			jsonResponse(w, http.StatusBadRequest, "invalid epoch: "+s)
+			return
+		}
+	}
+	if page != nil && page.AsOf != 0 {
+		if asOf != 0 && asOf != page.AsOf {
+			jsonResponse(w, http.StatusBadRequest, fmt.Sprintf("cursor was issued for epoch %d", page.AsOf))
+			return
+		}
+		asOf = page.AsOf
+	}
+	if page != nil && page.Hash == 0 && asOf == 0 {
+		// pin pages of a new query to the current epoch, so they stay consistent while the graph is being synced
+		if _, ok := qs.(graph.HistoryStore); ok {
+			st, err := qs.Stats(ctx, false)
+			if err != nil {
+				errFunc(w, err)
+				return
+			}
+			asOf = st.Epoch
+		}
+	}
+	if asOf != 0 {
+		qs, err = graph.AsOf(ctx, qs, asOf)
+		if err == graph.ErrEpochNotExist {
+			jsonResponse(w, http.StatusBadRequest, fmt.Sprintf("epoch %d is not synced yet or its history was compacted", asOf))
+			return
+		} else if err != nil {
+			jsonResponse(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	// languages with a custom handler still use sessions to stream and paginate results
+	if l.HTTPQuery != nil && page == nil && !stream {
 		defer r.Body.Close()
-		l.HTTPQuery(ctx, h.QuadStore, w, r.Body)
+		l.HTTPQuery(ctx, qs, w, r.Body)
 		return
 	}
 	if l.Session == nil {
 		errFunc(w, errors.New("HTTP interface is not supported for this query language"))
 		return
 	}
-	ses := l.Session(h.QuadStore)
+	ses := l.Session(qs)
 	var qu string
 	if r.Method == "GET" {
 		qu = vals.Get("qu")
@@ -553,6 +698,27 @@ func (api *APIv2) ServeQuery(w http.ResponseWriter, r *http.Request) {
 	opt := query.Options{
 		Collation: query.JSON, // TODO: switch to JSON-LD by default when the time comes
 		Limit:     api.limit,
+		AsOf:      asOf,
+	}
+	if page != nil {
+		hash := queryHash(lang, qu)
+		if page.Hash != 0 && page.Hash != hash {
+			jsonResponse(w, http.StatusBadRequest, "cursor does not match the query")
+			return
+		}
+		page.Hash, page.AsOf = hash, asOf
+		if api.limit > 0 && page.Size > api.limit {
+			page.Size = api.limit
+		}
+		if api.maxOffset > 0 && page.Offset+page.Size > api.maxOffset {
+			if page.Offset >= api.maxOffset {
+				jsonResponse(w, http.StatusBadRequest, fmt.Sprintf("pages cannot continue past %d results", api.maxOffset))
+				return
+			}
+			page.Size = api.maxOffset - page.Offset
+		}
+		// one more result is requested to check if there is a next page
+		opt.Limit = page.Offset + page.Size + 1
 	}
 	if specs := ParseAccept(r.Header, hdrAccept); len(specs) != 0 {
 		// TODO: sort by Q
@@ -570,10 +736,20 @@ func (api *APIv2) ServeQuery(w http.ResponseWriter, r *http.Request) {
 	}
 	defer it.Close()
 
+	if page != nil {
+		// skip results of previous pages
+		for i := 0; i < page.Offset && it.Next(ctx); i++ {
+		}
+	}
+	if stream {
+		streamResults(ctx, w, it, page, api.nextPage, errFunc)
+		return
+	}
 	var out []interface{}
-	for it.Next(ctx) {
+	for (page == nil || len(out) < page.Size) && it.Next(ctx) {
 		out = append(out, it.Result())
 	}
+	more := page != nil && len(out) == page.Size && it.Next(ctx)
 	if err = it.Err(); err != nil {
 		errFunc(w, err)
 		return
@@ -583,7 +759,96 @@ func (api *APIv2) ServeQuery(w http.ResponseWriter, r *http.Request) {
 	} else {
 		w.Header().Set(hdrContentType, contentTypeJSON)
 	}
-	writeResults(w, out)
+	if page == nil {
+		writeResults(w, out)
+		return
+	}
+	var cursor string
+	if more {
+		cursor = api.nextPage(page, len(out))
+	}
+	writePage(w, out, cursor)
+}
+
+// nextPage advances the cursor by n results of the current page and returns a signed cursor of the next page.
+// It returns an empty string if pages cannot continue past the maximal offset.
+func (api *APIv2) nextPage(page *queryCursor, n int) string {
+	page.Offset += n
+	if api.maxOffset > 0 && page.Offset >= api.maxOffset {
+		return ""
+	}
+	return page.encode(api.cursorKey)
+}
+
+// streamRequested checks if query results should be streamed as newline-delimited JSON, which is requested
+// either by accepting it or with the "stream" parameter. The latter allows to stream JSON-LD results.
+func streamRequested(r *http.Request) (bool, error) {
+	if s := r.URL.Query().Get("stream"); s != "" {
+		stream, err := strconv.ParseBool(s)
+		if err != nil {
+			return false, errors.New("stream must be set as a boolean")
+		}
+		return stream, nil
+	}
+	specs := ParseAccept(r.Header, hdrAccept)
+	return len(specs) != 0 && specs[0].Value == contentTypeNDJSON, nil
+}
+
+// streamFlushInterval is the maximal time streamed query results are buffered for.
+const streamFlushInterval = 100 * time.Millisecond
+
+// streamResults writes each query result as a separate JSON object with a "result" field, followed by a new line.
+// Results are written as they are produced, and the stream ends with an object with an "error" field if the query
+// failed, or with a "cursor" field if there is a next page.
+func streamResults(ctx context.Context, w http.ResponseWriter, it query.Iterator, page *queryCursor, next func(*queryCursor, int) string, errFunc func(query.ResponseWriter, error)) {
+	var (
+		bw         = bufio.NewWriter(w)
+		enc        = json.NewEncoder(bw)
+		flusher, _ = w.(http.Flusher)
+		last       = time.Now()
+		n          int
+	)
+	enc.SetEscapeHTML(false)
+	flush := func() {
+		bw.Flush()
+		if flusher != nil {
+			flusher.Flush()
+		}
+		last = time.Now()
+	}
+	start := func() {
+		w.Header().Set(hdrContentType, contentTypeNDJSON)
+		w.WriteHeader(http.StatusOK)
+	}
+	for (page == nil || n < page.Size) && it.Next(ctx) {
+		if n == 0 {
+			start()
+		}
+		if err := enc.Encode(map[string]interface{}{"result": it.Result()}); err != nil {
+			return // client is gone
+		}
+		n++
+		if time.Since(last) >= streamFlushInterval {
+			flush()
+		}
+	}
+	more := page != nil && n == page.Size && it.Next(ctx)
+	err := it.Err()
+	if n == 0 {
+		if err != nil {
+			errFunc(w, err)
+			return
+		}
+		start()
+	}
+	if err != nil {
+		enc.Encode(map[string]string{"error": err.Error()})
+	} else if more {
+		if cursor := next(page, n); cursor != "" {
+			enc.Encode(map[string]string{"cursor": cursor})
+		}
+	}
+	flush()
 }
 
 // NamespaceRule defines a prefix for a namespace when prepended to the suffix of a compact IRI, results in an IRI.
@@ -2,17 +2,21 @@ package gatewayhttp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"sort"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/cayleygraph/quad"
 	"github.com/cayleygraph/quad/jsonld"
 	"github.com/epik-protocol/epik-gateway-backend/graph"
 	"github.com/epik-protocol/epik-gateway-backend/graph/memstore"
+	"github.com/epik-protocol/epik-gateway-backend/query"
 	"github.com/epik-protocol/epik-gateway-backend/writer"
 	"github.com/stretchr/testify/require"
 )
@@ -75,6 +79,28 @@ func TestV2Write(t *testing.T) {
 	require.Equal(t, expectedResponse, response)
 }
 
+func TestV2WriteBulk(t *testing.T) {
+	api := makeServerV2(t)
+	buf, err := newQuadsBuffer(quads)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, prefix+"/write?bulk=true", buf)
+	require.NoError(t, err)
+	req.Header.Set(hdrContentType, mime)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(api.ServeWrite)
+	handler.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var response writeResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Equal(t, len(quads), response.Count)
+	require.True(t, response.Rate > 0)
+	require.Equal(t, int64(len(quads)), api.h.Size())
+}
+
 func TestV2Read(t *testing.T) {
 	api := makeServerV2(t, quads...)
 	buf := bytes.NewBuffer(nil)
@@ -161,3 +187,259 @@ func TestV2PostNamespaceRules(t *testing.T) {
 	require.Equal(t, contentTypeJSON, rr.Header().Get(hdrContentType))
 	require.Contains(t, rules, rule)
 }
+
+// historyStore serves views of the graph at past epochs from a set of memstores.
+type historyStore struct {
+	graph.QuadStore
+	epochs map[int64][]quad.Quad
+	// epoch is reported as the current epoch of the store
+	epoch int64
+}
+
+func (s *historyStore) Stats(ctx context.Context, exact bool) (graph.Stats, error) {
+	st, err := s.QuadStore.Stats(ctx, exact)
+	st.Epoch = s.epoch
+	return st, err
+}
+
+func (s *historyStore) AsOf(ctx context.Context, epoch int64) (graph.QuadStore, error) {
+	q, ok := s.epochs[epoch]
+	if !ok {
+		return nil, graph.ErrEpochNotExist
+	}
+	return memstore.New(q...), nil
+}
+
+// countSession returns the number of quads in the store as a single result.
+type countSession struct {
+	qs graph.QuadStore
+}
+
+func (s countSession) Execute(ctx context.Context, qu string, opt query.Options) (query.Iterator, error) {
+	st, err := s.qs.Stats(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	return &countIterator{n: st.Quads.Value}, nil
+}
+
+type countIterator struct {
+	n    int64
+	done bool
+}
+
+func (it *countIterator) Next(ctx context.Context) bool {
+	if it.done {
+		return false
+	}
+	it.done = true
+	return true
+}
+func (it *countIterator) Result() interface{} { return it.n }
+func (it *countIterator) Err() error          { return nil }
+func (it *countIterator) Close() error        { return nil }
+
+func TestV2QueryAsOf(t *testing.T) {
+	query.RegisterLanguage(query.Language{
+		Name:    "test-count",
+		Session: func(qs graph.QuadStore) query.Session { return countSession{qs: qs} },
+	})
+	api := makeServerV2(t, quads...)
+
+	serve := func(params string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, prefix+"/query?lang=test-count&qu=count"+params, nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		api.ServeQuery(rr, req)
+		return rr
+	}
+
+	rr := serve("")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.JSONEq(t, `{"result":[2]}`, rr.Body.String())
+
+	// memstore does not keep the history
+	rr = serve("&as_of=1")
+	require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+
+	api.h.QuadStore = &historyStore{
+		QuadStore: api.h.QuadStore,
+		epochs:    map[int64][]quad.Quad{1: quads[:1]},
+	}
+	rr = serve("&as_of=1")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.JSONEq(t, `{"result":[1]}`, rr.Body.String())
+
+	rr = serve("&as_of=2")
+	require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+
+	// pages are pinned to the current epoch
+	api.h.QuadStore.(*historyStore).epoch = 1
+	rr = serve("&limit=1")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.JSONEq(t, `{"result":[1]}`, rr.Body.String())
+	rr = serve("")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.JSONEq(t, `{"result":[2]}`, rr.Body.String())
+
+	rr = serve("&as_of=-1")
+	require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+	rr = serve("&as_of=abc")
+	require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+}
+
+// rangeSession returns numbers from 1 to the number given in the query.
+type rangeSession struct{}
+
+func (rangeSession) Execute(ctx context.Context, qu string, opt query.Options) (query.Iterator, error) {
+	n, err := strconv.Atoi(qu)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Limit > 0 && n > opt.Limit {
+		n = opt.Limit
+	}
+	return &rangeIterator{n: n}, nil
+}
+
+type rangeIterator struct {
+	i, n int
+}
+
+func (it *rangeIterator) Next(ctx context.Context) bool {
+	if it.i >= it.n {
+		return false
+	}
+	it.i++
+	return true
+}
+func (it *rangeIterator) Result() interface{} { return it.i }
+func (it *rangeIterator) Err() error          { return nil }
+func (it *rangeIterator) Close() error        { return nil }
+
+func init() {
+	query.RegisterLanguage(query.Language{
+		Name:    "test-range",
+		Session: func(qs graph.QuadStore) query.Session { return rangeSession{} },
+	})
+}
+
+func TestV2QueryPages(t *testing.T) {
+	api := makeServerV2(t)
+
+	serve := func(qu, params string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, prefix+"/query?lang=test-range&qu="+qu+params, nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		api.ServeQuery(rr, req)
+		return rr
+	}
+	type page struct {
+		Result []int  `json:"result"`
+		Cursor string `json:"cursor"`
+	}
+
+	var (
+		got    []int
+		cursor string
+		pages  int
+	)
+	for {
+		params := "&limit=2"
+		if cursor != "" {
+			params = "&cursor=" + cursor
+		}
+		rr := serve("5", params)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var p page
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+		got = append(got, p.Result...)
+		pages++
+		if cursor = p.Cursor; cursor == "" {
+			break
+		}
+	}
+	require.Equal(t, []int{1, 2, 3, 4, 5}, got)
+	require.Equal(t, 3, pages)
+
+	// the cursor only works with the same query
+	rr := serve("5", "&limit=2")
+	var p page
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+	rr = serve("6", "&cursor="+p.Cursor)
+	require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+	rr = serve("5", "&cursor=abc")
+	require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+	rr = serve("5", "&limit=0")
+	require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+
+	// cursors are signed by the server
+	forged := queryCursor{Hash: queryHash("test-range", "5"), Offset: 1, Size: 2}
+	rr = serve("5", "&cursor="+forged.encode([]byte("key")))
+	require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+	rr = serve("5", "&cursor="+forged.encode(api.cursorKey))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.JSONEq(t, `{"result":[2,3],"cursor":"`+(&queryCursor{Hash: forged.Hash, Offset: 3, Size: 2}).encode(api.cursorKey)+`"}`, rr.Body.String())
+
+	// pages do not continue past the maximal offset
+	api.SetQueryMaxOffset(3)
+	rr = serve("5", "&cursor="+forged.encode(api.cursorKey))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.JSONEq(t, `{"result":[2,3]}`, rr.Body.String())
+	rr = serve("5", "&limit=4")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.JSONEq(t, `{"result":[1,2,3]}`, rr.Body.String())
+}
+
+func TestV2QueryStream(t *testing.T) {
+	api := makeServerV2(t)
+
+	req, err := http.NewRequest(http.MethodGet, prefix+"/query?lang=test-range&qu=3", nil)
+	require.NoError(t, err)
+	req.Header.Set(hdrAccept, contentTypeNDJSON)
+	rr := httptest.NewRecorder()
+	api.ServeQuery(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, contentTypeNDJSON, rr.Header().Get(hdrContentType))
+	require.Equal(t, "{\"result\":1}\n{\"result\":2}\n{\"result\":3}\n", rr.Body.String())
+
+	// streamed pages end with a cursor
+	req, err = http.NewRequest(http.MethodGet, prefix+"/query?lang=test-range&qu=3&stream=true&limit=2", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	api.ServeQuery(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	require.Len(t, lines, 3)
+	require.Equal(t, `{"result":1}`, lines[0])
+	require.Equal(t, `{"result":2}`, lines[1])
+	var last struct {
+		Cursor string `json:"cursor"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &last))
+
+	req, err = http.NewRequest(http.MethodGet, prefix+"/query?lang=test-range&qu=3&stream=true&cursor="+last.Cursor, nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	api.ServeQuery(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, "{\"result\":3}\n", rr.Body.String())
+
+	// the limit of the server bounds both streamed results and streamed pages
+	api.SetQueryLimit(2)
+	req, err = http.NewRequest(http.MethodGet, prefix+"/query?lang=test-range&qu=3&stream=true", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	api.ServeQuery(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, "{\"result\":1}\n{\"result\":2}\n", rr.Body.String())
+
+	req, err = http.NewRequest(http.MethodGet, prefix+"/query?lang=test-range&qu=5&stream=true&limit=3", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	api.ServeQuery(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	lines = strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	require.Len(t, lines, 3)
+	require.Contains(t, lines[2], `"cursor"`)
+}
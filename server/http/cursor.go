@@ -0,0 +1,116 @@
+package gatewayhttp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// queryCursor is an opaque position in results of a query, which is returned with each page of results.
+//
+// Pages are produced by executing the query again and skipping results of previous pages, thus the number of
+// skipped results is bounded by the server, see APIv2.SetQueryMaxOffset. The first page of a query is evaluated
+// at the current epoch if the store keeps the history, and the epoch is kept in the cursor, so all pages are
+// consistent while the graph is being synced.
+//
+// Cursors are signed by the server, so clients cannot change the position or the epoch, see APIv2.SetCursorKey.
+type queryCursor struct {
+	// Hash of the query language and the query; the cursor can only be used with the same query.
+	Hash uint64 `json:"h"`
+	// Offset is the number of results returned by previous pages.
+	Offset int `json:"o"`
+	// Size is the number of results in a page.
+	Size int `json:"n"`
+	// AsOf is the epoch the query is evaluated at.
+	AsOf int64 `json:"e,omitempty"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// queryHash returns a hash of the query that is recorded to the cursor.
+func queryHash(lang, qu string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(lang))
+	h.Write([]byte{0})
+	h.Write([]byte(qu))
+	return h.Sum64()
+}
+
+// cursorMACSize is the size of the signature of the cursor in bytes.
+const cursorMACSize = 16
+
+func cursorMAC(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)[:cursorMACSize]
+}
+
+// newCursorKey returns a random key for signing cursors.
+func newCursorKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Errorf("cannot generate a cursor key: %v", err))
+	}
+	return key
+}
+
+// encode returns the cursor signed with a given key.
+func (c queryCursor) encode(key []byte) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data) + "." + base64.RawURLEncoding.EncodeToString(cursorMAC(key, data))
+}
+
+// decodeCursor decodes a cursor and checks that it was signed with a given key.
+func decodeCursor(s string, key []byte) (queryCursor, error) {
+	var c queryCursor
+	i := strings.IndexByte(s, '.')
+	if i < 0 {
+		return c, errInvalidCursor
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s[:i])
+	if err != nil {
+		return c, errInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(s[i+1:])
+	if err != nil || !hmac.Equal(sig, cursorMAC(key, data)) {
+		return c, errInvalidCursor
+	}
+	if err = json.Unmarshal(data, &c); err != nil || c.Offset < 0 || c.Size <= 0 || c.AsOf < 0 {
+		return queryCursor{}, errInvalidCursor
+	}
+	return c, nil
+}
+
+// queryPage parses pagination parameters of a query request. The page is nil if pagination was not requested.
+//
+// The "limit" parameter sets the size of the page, and the "cursor" parameter continues from the end
+// of a previous page. The cursor must be used with the same query and language.
+func queryPage(vals url.Values, key []byte) (*queryCursor, error) {
+	var c *queryCursor
+	if s := vals.Get("cursor"); s != "" {
+		cur, err := decodeCursor(s, key)
+		if err != nil {
+			return nil, err
+		}
+		c = &cur
+	}
+	if s := vals.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, errors.New("limit must be a positive integer")
+		}
+		if c == nil {
+			c = &queryCursor{}
+		}
+		c.Size = n
+	}
+	return c, nil
+}
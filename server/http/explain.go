@@ -0,0 +1,129 @@
+package gatewayhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/epik-protocol/epik-gateway-backend/clog"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/query"
+	"github.com/epik-protocol/epik-gateway-backend/query/shape"
+)
+
+// explainResponse lists plans of iterators built for a query, see shape.Trace.
+type explainResponse struct {
+	Plans []shape.Plan `json:"plans"`
+	// Results is the number of results returned by a profiled query.
+	Results int `json:"results"`
+	// Duration is the execution time of a profiled query.
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// ServeExplain plans a query received in the request without executing it, and responds with plans
+// of all iterators built for it: original and optimized shapes, backend-specific shapes and estimated costs.
+func (api *APIv2) ServeExplain(w http.ResponseWriter, r *http.Request) {
+	api.serveTrace(w, r, false)
+}
+
+// ServeProfile executes a query received in the request and responds with plans of all iterators built for it,
+// like ServeExplain, as well as the number of calls, results and the time spent in each iterator.
+// Results of the query are not returned.
+func (api *APIv2) ServeProfile(w http.ResponseWriter, r *http.Request) {
+	api.serveTrace(w, r, true)
+}
+
+func (api *APIv2) serveTrace(w http.ResponseWriter, r *http.Request, profile bool) {
+	ctx, cancel := api.queryContext(r)
+	defer cancel()
+	vals := r.URL.Query()
+	lang := vals.Get("lang")
+	if lang == "" {
+		jsonResponse(w, http.StatusBadRequest, "query language not specified")
+		return
+	}
+	l := query.GetLanguage(lang)
+	if l == nil {
+		jsonResponse(w, http.StatusBadRequest, "unknown query language")
+		return
+	}
+	errFunc := defaultErrorFunc
+	if l.HTTPError != nil {
+		errFunc = l.HTTPError
+	}
+	if l.Session == nil {
+		errFunc(w, errors.New("HTTP interface is not supported for this query language"))
+		return
+	}
+	h, err := api.handleForRequest(r)
+	if err != nil {
+		errFunc(w, err)
+		return
+	}
+	qs := h.QuadStore
+	var asOf int64
+	if s := vals.Get("as_of"); s != "" {
+		asOf, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || asOf <= 0 {
+			jsonResponse(w, http.StatusBadRequest, "invalid epoch: "+s)
+			return
+		}
+		qs, err = graph.AsOf(ctx, qs, asOf)
+		if err == graph.ErrEpochNotExist {
+			jsonResponse(w, http.StatusBadRequest, fmt.Sprintf("epoch %d is not synced yet or its history was compacted", asOf))
+			return
+		} else if err != nil {
+			jsonResponse(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	var qu string
+	if r.Method == "GET" {
+		qu = vals.Get("qu")
+	} else {
+		data, err := readLimit(r.Body)
+		if err != nil {
+			errFunc(w, err)
+			return
+		}
+		qu = string(data)
+	}
+	if qu == "" {
+		jsonResponse(w, http.StatusBadRequest, "query is empty")
+		return
+	}
+	if clog.V(1) {
+		clog.Infof("explain: %s: %q", lang, qu)
+	}
+	tr := &shape.Trace{Profile: profile}
+	ctx = shape.WithTrace(ctx, tr)
+
+	start := time.Now()
+	it, err := l.Session(qs).Execute(ctx, qu, query.Options{
+		Collation: query.JSON,
+		Limit:     api.limit,
+		AsOf:      asOf,
+	})
+	if err != nil {
+		errFunc(w, err)
+		return
+	}
+	defer it.Close()
+	var resp explainResponse
+	for it.Next(ctx) {
+		resp.Results++
+	}
+	if err = it.Err(); err != nil {
+		errFunc(w, err)
+		return
+	}
+	if profile {
+		resp.Duration = time.Since(start)
+	}
+	resp.Plans = tr.Plans(ctx)
+	w.Header().Set(hdrContentType, contentTypeJSON)
+	json.NewEncoder(w).Encode(resp)
+}
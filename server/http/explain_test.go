@@ -0,0 +1,74 @@
+package gatewayhttp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cayleygraph/quad"
+	"github.com/stretchr/testify/require"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/iterator"
+	"github.com/epik-protocol/epik-gateway-backend/query"
+	"github.com/epik-protocol/epik-gateway-backend/query/path"
+)
+
+// outSession follows the predicate given in the query from all nodes.
+type outSession struct {
+	qs graph.QuadStore
+}
+
+func (s outSession) Execute(ctx context.Context, qu string, opt query.Options) (query.Iterator, error) {
+	p := path.StartPath(s.qs).Out(quad.IRI(qu))
+	return &outIterator{it: p.BuildIterator(ctx).Iterate()}, nil
+}
+
+type outIterator struct {
+	it iterator.Scanner
+}
+
+func (it *outIterator) Next(ctx context.Context) bool { return it.it.Next(ctx) }
+func (it *outIterator) Result() interface{}           { return it.it.Result() }
+func (it *outIterator) Err() error                    { return it.it.Err() }
+func (it *outIterator) Close() error                  { return it.it.Close() }
+
+func init() {
+	query.RegisterLanguage(query.Language{
+		Name:    "test-out",
+		Session: func(qs graph.QuadStore) query.Session { return outSession{qs: qs} },
+	})
+}
+
+func TestV2ExplainProfile(t *testing.T) {
+	api := makeServerV2(t, quads...)
+
+	serve := func(handler http.HandlerFunc) explainResponse {
+		req, err := http.NewRequest(http.MethodGet, prefix+"/explain?lang=test-out&qu=http://example.com/likes", nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var resp explainResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Len(t, resp.Plans, 1)
+		p := resp.Plans[0]
+		require.NotEmpty(t, p.Shape)
+		require.NotEmpty(t, p.Optimized)
+		require.NotNil(t, p.Iterator)
+		return resp
+	}
+
+	resp := serve(api.ServeExplain)
+	require.Equal(t, 0, resp.Results)
+	require.Nil(t, resp.Plans[0].Iterator.Profile)
+
+	resp = serve(api.ServeProfile)
+	require.Equal(t, 2, resp.Results)
+	prof := resp.Plans[0].Iterator.Profile
+	require.NotNil(t, prof)
+	require.Equal(t, int64(2), prof.Results)
+	require.Equal(t, int64(3), prof.Next)
+}
@@ -0,0 +1,126 @@
+package gatewayhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+)
+
+const (
+	contentTypeEventStream = "text/event-stream"
+	contentTypeNDJSON      = "application/x-ndjson"
+	hdrLastEventID         = "Last-Event-ID"
+)
+
+func (api *APIv2) registerFeedOn(r *httprouter.Router) {
+	r.GET(prefix+"/feed", toHandle(api.ServeFeed))
+}
+
+// feedStart returns the feed ID and the sequence number to start the feed from, which are either set by
+// the "feed" and "from" parameters, or follow the last event received by an event stream client before reconnecting.
+// Event IDs of the stream are formatted as "<feed>/<seq>".
+func feedStart(r *http.Request) (string, uint64, error) {
+	if s := r.FormValue("from"); s != "" {
+		from, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return "", 0, errors.New("sequence number to start from must be set as an integer")
+		}
+		return r.FormValue("feed"), from, nil
+	}
+	if s := r.Header.Get(hdrLastEventID); s != "" {
+		i := strings.LastIndexByte(s, '/')
+		last, err := strconv.ParseUint(s[i+1:], 10, 64)
+		if err != nil || i < 0 {
+			return "", 0, fmt.Errorf("invalid %s header: %q", hdrLastEventID, s)
+		}
+		return s[:i], last + 1, nil
+	}
+	return "", 0, nil
+}
+
+// ServeFeed streams deltas committed to the database, starting from the sequence number given by the "from" parameter.
+// The sequence number must be issued by the feed with the ID given by the "feed" parameter.
+// Events are sent as Server-Sent Events if the client accepts them, or as chunked JSON objects separated by new lines.
+// The optional "limit" parameter stops the stream after a given number of events.
+func (api *APIv2) ServeFeed(w http.ResponseWriter, r *http.Request) {
+	id, from, err := feedStart(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	limit := 0
+	if s := r.FormValue("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			jsonResponse(w, http.StatusBadRequest, errors.New("limit must be a non-negative integer"))
+			return
+		}
+	}
+	sub, err := api.h.Subscribe(id, from)
+	switch err {
+	case nil:
+	case graph.ErrNoFeed:
+		jsonResponse(w, http.StatusNotFound, err)
+		return
+	case graph.ErrFeedTruncated, graph.ErrFeedInstance:
+		jsonResponse(w, http.StatusGone, err)
+		return
+	default:
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	sse := strings.Contains(r.Header.Get(hdrAccept), contentTypeEventStream)
+	if sse {
+		w.Header().Set(hdrContentType, contentTypeEventStream)
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set(hdrContentType, contentTypeNDJSON)
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	flush()
+
+	ctx := r.Context()
+	enc := json.NewEncoder(w)
+	for n := 0; limit == 0 || n < limit; n++ {
+		if !sub.Next(ctx) {
+			break
+		}
+		e := sub.Result()
+		if sse {
+			fmt.Fprintf(w, "id: %s/%d\nevent: delta\ndata: ", e.Feed, e.Seq)
+		}
+		if err = enc.Encode(e); err != nil {
+			return
+		}
+		if sse {
+			fmt.Fprint(w, "\n")
+		}
+		// write events in chunks while the subscriber catches up
+		if sub.Buffered() == 0 {
+			flush()
+		}
+	}
+	if err = sub.Err(); err == graph.ErrFeedTruncated {
+		if sse {
+			fmt.Fprint(w, "event: error\ndata: ")
+		}
+		enc.Encode(map[string]string{"error": err.Error()})
+		if sse {
+			fmt.Fprint(w, "\n")
+		}
+	}
+	flush()
+}
@@ -0,0 +1,83 @@
+package gatewayhttp
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/stretchr/testify/require"
+)
+
+func serveFeed(t testing.TB, api *APIv2, query string, hdr http.Header) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(http.MethodGet, prefix+"/feed?"+query, nil)
+	require.NoError(t, err)
+	for k := range hdr {
+		req.Header.Set(k, hdr.Get(k))
+	}
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestV2Feed(t *testing.T) {
+	api := makeServerV2(t)
+	rr := serveFeed(t, api, "", nil)
+	require.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+
+	feed := graph.NewFeed(10)
+	api.h.SetFeed(feed)
+	require.NoError(t, api.h.AddQuadSet(quads))
+	require.NoError(t, api.h.RemoveQuad(quads[0]))
+
+	rr = serveFeed(t, api, "feed="+feed.ID()+"&from=1&limit=3", nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, contentTypeNDJSON, rr.Header().Get(hdrContentType))
+	var actions []string
+	sc := bufio.NewScanner(rr.Body)
+	for sc.Scan() {
+		var e struct {
+			Feed   string          `json:"feed"`
+			Seq    uint64          `json:"seq"`
+			Action string          `json:"action"`
+			Quad   json.RawMessage `json:"quad"`
+		}
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &e))
+		require.Equal(t, feed.ID(), e.Feed)
+		require.Equal(t, uint64(len(actions)+1), e.Seq)
+		require.NotEmpty(t, e.Quad)
+		actions = append(actions, e.Action)
+	}
+	require.Equal(t, []string{"add", "add", "delete"}, actions)
+
+	// event stream clients resume after the last received event
+	hdr := make(http.Header)
+	hdr.Set(hdrAccept, contentTypeEventStream)
+	hdr.Set(hdrLastEventID, feed.ID()+"/2")
+	rr = serveFeed(t, api, "limit=1", hdr)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, contentTypeEventStream, rr.Header().Get(hdrContentType))
+	require.True(t, strings.HasPrefix(rr.Body.String(), "id: "+feed.ID()+"/3\nevent: delta\ndata: {"), rr.Body.String())
+	require.True(t, strings.HasSuffix(rr.Body.String(), "}\n\n"), rr.Body.String())
+
+	rr = serveFeed(t, api, "feed="+feed.ID()+"&from=5", nil)
+	require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+	rr = serveFeed(t, api, "from=x", nil)
+	require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+	hdr.Set(hdrLastEventID, "2")
+	rr = serveFeed(t, api, "", hdr)
+	require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+
+	// sequence numbers of the previous feed cannot be resumed, as after a restart
+	old := feed.ID()
+	feed = graph.NewFeed(1)
+	api.h.SetFeed(feed)
+	require.NoError(t, api.h.AddQuadSet(quads))
+	rr = serveFeed(t, api, "feed="+old+"&from=1", nil)
+	require.Equal(t, http.StatusGone, rr.Code, rr.Body.String())
+	rr = serveFeed(t, api, "feed="+feed.ID()+"&from=1", nil)
+	require.Equal(t, http.StatusGone, rr.Code, rr.Body.String())
+}
@@ -0,0 +1,79 @@
+package gatewayhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/julienschmidt/httprouter"
+)
+
+var errNoListener = errors.New("database has no listener")
+
+func (api *APIv2) registerListenerOn(r *httprouter.Router) {
+	r.GET(prefix+"/listener/status", toHandle(api.ServeListenerStatus))
+	r.POST(prefix+"/listener/pause", toHandle(api.ServeListenerPause))
+	r.POST(prefix+"/listener/resume", toHandle(api.ServeListenerResume))
+	r.POST(prefix+"/listener/resync", toHandle(api.ServeListenerResync))
+}
+
+// listenerForRequest returns the listener of the database, or writes an error response if there is none,
+// or if the request changes the state of the listener while the database is read-only.
+func (api *APIv2) listenerForRequest(w http.ResponseWriter, write bool) graph.Listener {
+	if api.h.Listener == nil {
+		jsonResponse(w, http.StatusNotFound, errNoListener)
+		return nil
+	} else if write && api.ro {
+		jsonResponse(w, http.StatusForbidden, errors.New("database is read-only"))
+		return nil
+	}
+	return api.h.Listener
+}
+
+func writeListenerStatus(w http.ResponseWriter, l graph.Listener) {
+	w.Header().Set(hdrContentType, contentTypeJSON)
+	json.NewEncoder(w).Encode(l.Status())
+}
+
+// ServeListenerStatus responds with the sync status of the listener.
+func (api *APIv2) ServeListenerStatus(w http.ResponseWriter, r *http.Request) {
+	if l := api.listenerForRequest(w, false); l != nil {
+		writeListenerStatus(w, l)
+	}
+}
+
+// ServeListenerPause pauses the listener and responds with its status.
+func (api *APIv2) ServeListenerPause(w http.ResponseWriter, r *http.Request) {
+	if l := api.listenerForRequest(w, true); l != nil {
+		l.Pause()
+		writeListenerStatus(w, l)
+	}
+}
+
+// ServeListenerResume resumes the listener and responds with its status.
+func (api *APIv2) ServeListenerResume(w http.ResponseWriter, r *http.Request) {
+	if l := api.listenerForRequest(w, true); l != nil {
+		l.Resume()
+		writeListenerStatus(w, l)
+	}
+}
+
+// ServeListenerResync forces the listener to apply epochs starting from the one given by the "from" parameter again.
+func (api *APIv2) ServeListenerResync(w http.ResponseWriter, r *http.Request) {
+	l := api.listenerForRequest(w, true)
+	if l == nil {
+		return
+	}
+	from, err := strconv.ParseInt(r.FormValue("from"), 10, 64)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, errors.New("epoch to resync from must be set as an integer"))
+		return
+	}
+	if err = l.Resync(from); err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	writeListenerStatus(w, l)
+}
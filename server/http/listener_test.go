@@ -0,0 +1,80 @@
+package gatewayhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/stretchr/testify/require"
+)
+
+type testListener struct {
+	status graph.ListenerStatus
+	resync int64
+}
+
+func (l *testListener) Start() {}
+func (l *testListener) Stop()  {}
+
+func (l *testListener) Status() graph.ListenerStatus { return l.status }
+
+func (l *testListener) Pause()  { l.status.Paused = true }
+func (l *testListener) Resume() { l.status.Paused = false }
+
+func (l *testListener) Resync(from int64) error {
+	if from <= 0 {
+		return errors.New("invalid epoch")
+	}
+	l.resync = from
+	return nil
+}
+
+func serveListener(t testing.TB, api *APIv2, method, path string) (*httptest.ResponseRecorder, graph.ListenerStatus) {
+	req, err := http.NewRequest(method, prefix+path, nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, req)
+	var st graph.ListenerStatus
+	if rr.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &st))
+	}
+	return rr, st
+}
+
+func TestV2Listener(t *testing.T) {
+	api := makeServerV2(t)
+	rr, _ := serveListener(t, api, http.MethodGet, "/listener/status")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	l := &testListener{status: graph.ListenerStatus{Epoch: 5, Head: 8, Lag: 3, InFlight: []string{"a"}}}
+	api.h.Listener = l
+
+	rr, st := serveListener(t, api, http.MethodGet, "/listener/status")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, l.status, st)
+
+	rr, st = serveListener(t, api, http.MethodPost, "/listener/pause")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.True(t, st.Paused)
+
+	rr, st = serveListener(t, api, http.MethodPost, "/listener/resume")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.False(t, st.Paused)
+
+	rr, _ = serveListener(t, api, http.MethodPost, "/listener/resync")
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	rr, _ = serveListener(t, api, http.MethodPost, "/listener/resync?from=0")
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	rr, _ = serveListener(t, api, http.MethodPost, "/listener/resync?from=3")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, int64(3), l.resync)
+
+	api.SetReadOnly(true)
+	rr, _ = serveListener(t, api, http.MethodPost, "/listener/pause")
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	rr, _ = serveListener(t, api, http.MethodGet, "/listener/status")
+	require.Equal(t, http.StatusOK, rr.Code)
+}
@@ -0,0 +1,170 @@
+package gatewayhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/cayleygraph/quad"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/graph/iterator"
+	"github.com/epik-protocol/epik-gateway-backend/query/path"
+)
+
+func (api *APIv2) registerPathsOn(r *httprouter.Router) {
+	r.GET(prefix+"/paths", toHandle(api.ServePaths))
+	r.POST(prefix+"/paths", toHandle(api.ServePaths))
+}
+
+// routeEdge is a JSON representation of an edge of the route.
+type routeEdge struct {
+	Subject   string `json:"subject"`
+	Predicate string `json:"predicate"`
+	Object    string `json:"object"`
+	Label     string `json:"label,omitempty"`
+}
+
+// routeJSON is a JSON representation of path.Route. Values are encoded in the same way as in the request.
+type routeJSON struct {
+	Nodes  []string    `json:"nodes"`
+	Edges  []routeEdge `json:"edges"`
+	Weight float64     `json:"weight"`
+}
+
+// pathsResponse is the response of the path finding endpoint.
+type pathsResponse struct {
+	Result []routeJSON `json:"result"`
+}
+
+func newRouteJSON(r path.Route) routeJSON {
+	out := routeJSON{
+		Nodes:  make([]string, 0, len(r.Nodes)),
+		Edges:  make([]routeEdge, 0, len(r.Edges)),
+		Weight: r.Weight,
+	}
+	for _, v := range r.Nodes {
+		out.Nodes = append(out.Nodes, quad.StringOf(v))
+	}
+	for _, q := range r.Edges {
+		out.Edges = append(out.Edges, routeEdge{
+			Subject:   quad.StringOf(q.Subject),
+			Predicate: quad.StringOf(q.Predicate),
+			Object:    quad.StringOf(q.Object),
+			Label:     quad.StringOf(q.Label),
+		})
+	}
+	return out
+}
+
+// routesRequest parses path finding parameters of the request.
+func routesRequest(r *http.Request) (from, to []quad.Value, kind iterator.RouteKind, opt path.RouteOptions, err error) {
+	from = valuesFromString(r.FormValue("from"))
+	if len(from) == 0 {
+		return nil, nil, 0, opt, errors.New("source nodes must be set")
+	}
+	to = valuesFromString(r.FormValue("to"))
+	kind = iterator.RouteShortest
+	if s := r.FormValue("kind"); s != "" {
+		kind, err = iterator.ParseRouteKind(s)
+		if err != nil {
+			return nil, nil, 0, opt, err
+		}
+	}
+	opt.Via = valuesFromString(r.FormValue("via"))
+	if s := r.FormValue("reverse"); s != "" {
+		opt.Reverse, err = strconv.ParseBool(s)
+		if err != nil {
+			return nil, nil, 0, opt, fmt.Errorf("invalid reverse flag: %q", s)
+		}
+	}
+	if s := r.FormValue("max_depth"); s != "" {
+		opt.MaxDepth, err = strconv.Atoi(s)
+		if err != nil || opt.MaxDepth < 0 {
+			return nil, nil, 0, opt, errors.New("max depth must be a non-negative integer")
+		}
+	}
+	if s := r.FormValue("weight"); s != "" {
+		opt.Weight = quad.StringToValue(s)
+	}
+	return from, to, kind, opt, nil
+}
+
+// ServePaths finds routes between two sets of nodes and responds with each route
+// as an ordered list of nodes and edges between them. The graph can be searched at a past epoch with "as_of".
+func (api *APIv2) ServePaths(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := api.queryContext(r)
+	defer cancel()
+	from, to, kind, opt, err := routesRequest(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	// the limit of the request can only lower the limit of the server
+	limit := api.limit
+	if s := r.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			jsonResponse(w, http.StatusBadRequest, errors.New("limit must be a non-negative integer"))
+			return
+		}
+		if n > 0 && (limit <= 0 || n < limit) {
+			limit = n
+		}
+	}
+	// the depth of the request can only lower the depth limit of the server
+	if opt.MaxDepth <= 0 {
+		opt.MaxDepth = iterator.DefaultRouteDepth(kind)
+	}
+	if api.routeDepth > 0 && opt.MaxDepth > api.routeDepth {
+		opt.MaxDepth = api.routeDepth
+	}
+	h, err := api.handleForRequest(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	qs := h.QuadStore
+	if s := r.FormValue("as_of"); s != "" {
+		asOf, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || asOf <= 0 {
+			jsonResponse(w, http.StatusBadRequest, "invalid epoch: "+s)
+			return
+		}
+		qs, err = graph.AsOf(ctx, qs, asOf)
+		if err == graph.ErrEpochNotExist {
+			jsonResponse(w, http.StatusBadRequest, fmt.Sprintf("epoch %d is not synced yet or its history was compacted", asOf))
+			return
+		} else if err != nil {
+			jsonResponse(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	p := path.StartPath(qs, from...)
+	var target *path.Path
+	if len(to) != 0 {
+		target = path.StartPath(qs, to...)
+	}
+	switch kind {
+	case iterator.RouteAll:
+		p = p.AllPaths(target, opt)
+	case iterator.RouteReachable:
+		p = p.Reachable(target, opt)
+	default:
+		p = p.ShortestPath(target, opt)
+	}
+	routes, err := p.Routes(ctx, limit)
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp := pathsResponse{Result: make([]routeJSON, 0, len(routes))}
+	for _, rt := range routes {
+		resp.Result = append(resp.Result, newRouteJSON(rt))
+	}
+	w.Header().Set(hdrContentType, contentTypeJSON)
+	json.NewEncoder(w).Encode(resp)
+}
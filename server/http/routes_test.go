@@ -0,0 +1,149 @@
+package gatewayhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cayleygraph/quad"
+	"github.com/stretchr/testify/require"
+
+	"github.com/epik-protocol/epik-gateway-backend/graph/memstore"
+)
+
+var roadQuads = []quad.Quad{
+	quad.MakeIRI("http://example.com/a", "http://example.com/road", "http://example.com/b", ""),
+	quad.MakeIRI("http://example.com/b", "http://example.com/road", "http://example.com/d", ""),
+	quad.MakeIRI("http://example.com/a", "http://example.com/road", "http://example.com/c", ""),
+	quad.MakeIRI("http://example.com/c", "http://example.com/road", "http://example.com/d", ""),
+	quad.Make(quad.IRI("http://example.com/b"), quad.IRI("http://example.com/cost"), quad.Int(5), nil),
+	quad.Make(quad.IRI("http://example.com/c"), quad.IRI("http://example.com/cost"), quad.Int(1), nil),
+}
+
+func TestV2Paths(t *testing.T) {
+	api := makeServerV2(t, roadQuads...)
+
+	serve := func(vals url.Values) (int, pathsResponse) {
+		req, err := http.NewRequest(http.MethodGet, prefix+"/paths?"+vals.Encode(), nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		api.ServePaths(rr, req)
+		var resp pathsResponse
+		if rr.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp), rr.Body.String())
+		}
+		return rr.Code, resp
+	}
+
+	code, resp := serve(url.Values{
+		"from":   {"<http://example.com/a>"},
+		"to":     {"<http://example.com/d>"},
+		"via":    {"<http://example.com/road>"},
+		"weight": {"<http://example.com/cost>"},
+	})
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, []routeJSON{{
+		Nodes: []string{"<http://example.com/a>", "<http://example.com/c>", "<http://example.com/d>"},
+		Edges: []routeEdge{
+			{Subject: "<http://example.com/a>", Predicate: "<http://example.com/road>", Object: "<http://example.com/c>"},
+			{Subject: "<http://example.com/c>", Predicate: "<http://example.com/road>", Object: "<http://example.com/d>"},
+		},
+		Weight: 2,
+	}}, resp.Result)
+
+	code, resp = serve(url.Values{
+		"from": {"<http://example.com/a>"},
+		"to":   {"<http://example.com/d>"},
+		"via":  {"<http://example.com/road>"},
+		"kind": {"all"},
+	})
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, resp.Result, 2)
+
+	// the limit of the request cannot exceed the limit of the server
+	api.SetQueryLimit(1)
+	for _, limit := range []string{"0", "5"} {
+		code, resp = serve(url.Values{
+			"from":  {"<http://example.com/a>"},
+			"to":    {"<http://example.com/d>"},
+			"via":   {"<http://example.com/road>"},
+			"kind":  {"all"},
+			"limit": {limit},
+		})
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, resp.Result, 1)
+	}
+	api.SetQueryLimit(defaultLimit)
+
+	code, resp = serve(url.Values{
+		"from":      {"<http://example.com/d>"},
+		"to":        {"<http://example.com/a>"},
+		"via":       {"<http://example.com/road>"},
+		"reverse":   {"true"},
+		"max_depth": {"1"},
+	})
+	require.Equal(t, http.StatusOK, code)
+	require.Empty(t, resp.Result)
+
+	code, _ = serve(url.Values{"to": {"<http://example.com/d>"}})
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = serve(url.Values{"from": {"<http://example.com/a>"}, "kind": {"longest"}})
+	require.Equal(t, http.StatusBadRequest, code)
+
+	// the depth of the request cannot exceed the depth limit of the server
+	api.SetRouteMaxDepth(1)
+	for _, depth := range []string{"", "2"} {
+		code, resp = serve(url.Values{
+			"from":      {"<http://example.com/a>"},
+			"to":        {"<http://example.com/d>"},
+			"max_depth": {depth},
+		})
+		require.Equal(t, http.StatusOK, code)
+		require.Empty(t, resp.Result)
+	}
+	api.SetRouteMaxDepth(defaultRouteDepth)
+
+	// failures of the search are errors of the server
+	api.h.QuadStore = memstore.New(append(roadQuads,
+		quad.Make(quad.IRI("http://example.com/d"), quad.IRI("http://example.com/cost"), quad.Int(-1), nil),
+	)...)
+	code, _ = serve(url.Values{
+		"from":   {"<http://example.com/a>"},
+		"to":     {"<http://example.com/d>"},
+		"weight": {"<http://example.com/cost>"},
+	})
+	require.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestV2PathsAsOf(t *testing.T) {
+	api := makeServerV2(t, roadQuads...)
+	api.h.QuadStore = &historyStore{
+		QuadStore: api.h.QuadStore,
+		epochs:    map[int64][]quad.Quad{1: roadQuads[:1]},
+	}
+	serve := func(asOf string) (int, string) {
+		vals := url.Values{
+			"from":  {"<http://example.com/a>"},
+			"to":    {"<http://example.com/d>"},
+			"as_of": {asOf},
+		}
+		req, err := http.NewRequest(http.MethodGet, prefix+"/paths?"+vals.Encode(), nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		api.ServePaths(rr, req)
+		return rr.Code, rr.Body.String()
+	}
+
+	// d was not reachable at the first epoch
+	code, body := serve("1")
+	require.Equal(t, http.StatusOK, code, body)
+	require.JSONEq(t, `{"result":[]}`, body)
+
+	code, body = serve("2")
+	require.Equal(t, http.StatusBadRequest, code, body)
+	code, body = serve("abc")
+	require.Equal(t, http.StatusBadRequest, code, body)
+}
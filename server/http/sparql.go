@@ -0,0 +1,171 @@
+package gatewayhttp
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cayleygraph/quad"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/epik-protocol/epik-gateway-backend/clog"
+	"github.com/epik-protocol/epik-gateway-backend/graph"
+	"github.com/epik-protocol/epik-gateway-backend/query/sparql"
+)
+
+const (
+	contentTypeSPARQLQuery = "application/sparql-query"
+	contentTypeForm        = "application/x-www-form-urlencoded"
+)
+
+func (api *APIv2) registerSPARQLOn(r *httprouter.Router) {
+	r.GET(prefix+"/sparql", toHandle(api.ServeSPARQL))
+	r.POST(prefix+"/sparql", toHandle(api.ServeSPARQL))
+}
+
+// sparqlRequest returns a query and a dataset of a SPARQL Protocol request. The query is passed in the "query"
+// parameter of the URL or of the form, or as a body of the request with the application/sparql-query type.
+func sparqlRequest(r *http.Request) (string, *sparql.Dataset, error) {
+	var qu string
+	if r.Method == http.MethodPost {
+		typ := r.Header.Get(hdrContentType)
+		if i := strings.IndexByte(typ, ';'); i >= 0 {
+			typ = typ[:i]
+		}
+		switch strings.TrimSpace(typ) {
+		case contentTypeSPARQLQuery:
+			data, err := readLimit(r.Body)
+			if err != nil {
+				return "", nil, err
+			}
+			qu = string(data)
+		case contentTypeForm:
+		default:
+			return "", nil, fmt.Errorf("unsupported content type: %q", typ)
+		}
+	}
+	// the form is only read from the body for urlencoded requests
+	if err := r.ParseForm(); err != nil {
+		return "", nil, err
+	}
+	if qu == "" {
+		qu = r.Form.Get("query")
+	}
+	var ds *sparql.Dataset
+	from, named := r.Form["default-graph-uri"], r.Form["named-graph-uri"]
+	if len(from) != 0 || len(named) != 0 {
+		ds = &sparql.Dataset{}
+		for _, s := range from {
+			ds.From = append(ds.From, quad.IRI(s))
+		}
+		for _, s := range named {
+			ds.FromNamed = append(ds.FromNamed, quad.IRI(s))
+		}
+	}
+	return qu, ds, nil
+}
+
+// sparqlResultFormat selects a format of SELECT and ASK results by the "format" parameter or by the Accept header.
+// It defaults to SPARQL JSON results.
+func sparqlResultFormat(r *http.Request) *sparql.ResultFormat {
+	if name := r.FormValue("format"); name != "" {
+		return sparql.ResultFormatByName(name)
+	}
+	for _, spec := range ParseAccept(r.Header, hdrAccept) {
+		if f := sparql.ResultFormatByMime(spec.Value); f != nil {
+			return f
+		}
+	}
+	return sparql.ResultFormatByName("json")
+}
+
+// ServeSPARQL executes a SPARQL query according to the SPARQL 1.1 Protocol.
+// Results of SELECT and ASK are written as SPARQL JSON, XML or CSV results,
+// and results of CONSTRUCT and DESCRIBE are written in one of quad formats.
+func (api *APIv2) ServeSPARQL(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := api.queryContext(r)
+	defer cancel()
+	qu, ds, err := sparqlRequest(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	} else if qu == "" {
+		jsonResponse(w, http.StatusBadRequest, "query is empty")
+		return
+	}
+	q, err := sparql.Parse(qu)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	var (
+		rf *sparql.ResultFormat
+		qf *quad.Format
+	)
+	if q.Form == sparql.Select || q.Form == sparql.Ask {
+		rf = sparqlResultFormat(r)
+		if rf == nil {
+			jsonResponse(w, http.StatusNotAcceptable, "format is not supported for query results")
+			return
+		}
+	} else {
+		qf = getFormat(r, "format", hdrAccept)
+		if qf == nil || qf.Writer == nil {
+			jsonResponse(w, http.StatusNotAcceptable, "format is not supported for reading data")
+			return
+		}
+	}
+	h, err := api.handleForRequest(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	qs := h.QuadStore
+	if s := r.FormValue("as_of"); s != "" {
+		asOf, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || asOf <= 0 {
+			jsonResponse(w, http.StatusBadRequest, "invalid epoch: "+s)
+			return
+		}
+		qs, err = graph.AsOf(ctx, qs, asOf)
+		if err == graph.ErrEpochNotExist {
+			jsonResponse(w, http.StatusBadRequest, fmt.Sprintf("epoch %d is not synced yet or its history was compacted", asOf))
+			return
+		} else if err != nil {
+			jsonResponse(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if clog.V(1) {
+		clog.Infof("sparql: %q", qu)
+	}
+	if api.limit > 0 && (q.Limit < 0 || q.Limit > api.limit) {
+		q.Limit = api.limit
+	}
+	res := q.Execute(qs, ds)
+	defer res.Close()
+
+	wr := writerFrom(w, r, hdrAcceptEncoding)
+	defer wr.Close()
+	cw := &checkWriter{w: wr}
+	if rf != nil {
+		w.Header().Set(hdrContentType, rf.Mime[0])
+		err = rf.Write(ctx, cw, res)
+	} else {
+		if len(qf.Mime) != 0 {
+			w.Header().Set(hdrContentType, qf.Mime[0])
+		}
+		qw := qf.Writer(cw)
+		_, err = quad.Copy(qw, res.Quads(ctx))
+		if cerr := qw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil && !cw.written {
+		jsonResponse(w, http.StatusInternalServerError, err)
+	} else if err != nil {
+		// can do nothing here, since first byte (and header) was written
+		clog.Errorf("sparql query error: %v", err)
+	}
+}
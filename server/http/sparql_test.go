@@ -0,0 +1,72 @@
+package gatewayhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/jsonld"
+	"github.com/stretchr/testify/require"
+)
+
+func TestV2SPARQL(t *testing.T) {
+	api := makeServerV2(t, quads...)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		api.ServeSPARQL(rr, req)
+		return rr
+	}
+	get := func(qu string) *http.Request {
+		req, err := http.NewRequest(http.MethodGet, prefix+"/sparql?query="+url.QueryEscape(qu), nil)
+		require.NoError(t, err)
+		return req
+	}
+
+	rr := serve(get(`SELECT ?x WHERE { ?x <http://example.com/likes> <http://example.com/alice> }`))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, "application/sparql-results+json", rr.Header().Get(hdrContentType))
+	var res struct {
+		Head struct {
+			Vars []string `json:"vars"`
+		} `json:"head"`
+		Results struct {
+			Bindings []map[string]map[string]string `json:"bindings"`
+		} `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+	require.Equal(t, []string{"x"}, res.Head.Vars)
+	require.Equal(t, []map[string]map[string]string{
+		{"x": {"type": "uri", "value": "http://example.com/bob"}},
+	}, res.Results.Bindings)
+
+	req, err := http.NewRequest(http.MethodPost, prefix+"/sparql",
+		strings.NewReader(`ASK { <http://example.com/alice> <http://example.com/likes> <http://example.com/bob> }`))
+	require.NoError(t, err)
+	req.Header.Set(hdrContentType, contentTypeSPARQLQuery)
+	req.Header.Set(hdrAccept, "text/csv")
+	rr = serve(req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, "boolean\r\ntrue\r\n", rr.Body.String())
+
+	form := url.Values{"query": {`CONSTRUCT { ?y <http://example.com/likedBy> ?x } WHERE { ?x <http://example.com/likes> ?y }`}}
+	req, err = http.NewRequest(http.MethodPost, prefix+"/sparql", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set(hdrContentType, contentTypeForm)
+	req.Header.Set(hdrAccept, mime)
+	rr = serve(req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	got, err := quad.ReadAll(jsonld.NewReader(rr.Body))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []quad.Quad{
+		quad.MakeIRI("http://example.com/alice", "http://example.com/likedBy", "http://example.com/bob", ""),
+		quad.MakeIRI("http://example.com/bob", "http://example.com/likedBy", "http://example.com/alice", ""),
+	}, got)
+
+	rr = serve(get(`SELECT (COUNT(*) AS ?n) WHERE { ?x ?p ?o }`))
+	require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+}
@@ -23,9 +23,12 @@ func init() {
 	graph.RegisterWriter("single", NewSingleReplication)
 }
 
+var _ graph.FeedPublisher = (*Single)(nil)
+
 type Single struct {
 	qs         graph.QuadStore
 	ignoreOpts graph.IgnoreOpts
+	feed       *graph.Feed
 }
 
 func NewSingle(qs graph.QuadStore, opts graph.IgnoreOpts) (graph.QuadWriter, error) {
@@ -52,13 +55,24 @@ func NewSingleReplication(qs graph.QuadStore, opts graph.Options) (graph.QuadWri
 	})
 }
 
+// SetFeed implements graph.FeedPublisher.
+func (s *Single) SetFeed(f *graph.Feed) {
+	s.feed = f
+}
+
+// applyDeltas applies deltas to the store and publishes them to the feed in the order of commits.
+// Deltas skipped by the store because of ignore options are published as well, see graph.Feed.Apply.
+func (s *Single) applyDeltas(epoch int64, deltas []graph.Delta) error {
+	return s.feed.Apply(s.qs, epoch, deltas, s.ignoreOpts)
+}
+
 func (s *Single) AddQuad(q quad.Quad) error {
 	deltas := make([]graph.Delta, 1)
 	deltas[0] = graph.Delta{
 		Quad:   q,
 		Action: graph.Add,
 	}
-	return s.qs.ApplyDeltas(graph.NoCidEpoch, deltas, s.ignoreOpts)
+	return s.applyDeltas(graph.NoCidEpoch, deltas)
 }
 
 func (s *Single) AddQuadSet(set []quad.Quad) error {
@@ -66,7 +80,7 @@ func (s *Single) AddQuadSet(set []quad.Quad) error {
 	for _, q := range set {
 		tx.AddQuad(q)
 	}
-	return s.qs.ApplyDeltas(graph.NoCidEpoch, tx.Deltas, s.ignoreOpts)
+	return s.applyDeltas(graph.NoCidEpoch, tx.Deltas)
 }
 
 func (s *Single) RemoveQuad(q quad.Quad) error {
@@ -75,7 +89,7 @@ func (s *Single) RemoveQuad(q quad.Quad) error {
 		Quad:   q,
 		Action: graph.Delete,
 	}
-	return s.qs.ApplyDeltas(graph.NoCidEpoch, deltas, s.ignoreOpts)
+	return s.applyDeltas(graph.NoCidEpoch, deltas)
 }
 
 // RemoveNode removes all quads with the given value.
@@ -115,5 +129,5 @@ func (s *Single) Close() error {
 }
 
 func (s *Single) ApplyTransaction(t *graph.Transaction) error {
-	return s.qs.ApplyDeltas(t.Epoch, t.Deltas, s.ignoreOpts)
+	return s.applyDeltas(t.Epoch, t.Deltas)
 }